@@ -0,0 +1,654 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/interfaces"
+)
+
+// defaultImportSchedule is used for rsync commands found without a cron
+// schedule, such as in shell scripts
+const defaultImportSchedule = "0 2 * * *"
+
+// rsyncValueOptions are the long rsync options taking a value, which may
+// be given as the next argument
+var rsyncValueOptions = map[string]bool{
+	"exclude": true, "exclude-from": true, "include": true, "include-from": true,
+	"filter": true, "files-from": true, "bwlimit": true, "max-size": true, "min-size": true,
+	"link-dest": true, "compare-dest": true, "copy-dest": true, "backup-dir": true,
+	"suffix": true, "rsh": true, "rsync-path": true, "chmod": true, "chown": true,
+	"usermap": true, "groupmap": true, "timeout": true, "contimeout": true,
+	"log-file": true, "log-file-format": true, "out-format": true, "partial-dir": true,
+	"temp-dir": true, "password-file": true, "port": true, "block-size": true,
+	"compress-level": true, "compress-choice": true, "checksum-choice": true,
+	"skip-compress": true, "max-delete": true, "modify-window": true, "iconv": true,
+	"info": true, "debug": true, "outbuf": true, "sockopts": true, "protocol": true,
+	"stop-after": true, "stop-at": true, "address": true, "remote-option": true,
+}
+
+// rsyncIgnoredOptions are transport, output and metadata options that need
+// no equivalent, as uploads always copy content and modification times
+var rsyncIgnoredOptions = map[string]bool{
+	"verbose": true, "quiet": true, "progress": true, "stats": true, "human-readable": true,
+	"itemize-changes": true, "compress": true, "compress-level": true, "compress-choice": true,
+	"skip-compress": true, "partial": true, "partial-dir": true, "temp-dir": true,
+	"times": true, "perms": true, "owner": true, "group": true, "devices": true,
+	"specials": true, "links": true, "checksum": true, "checksum-choice": true,
+	"update": true, "sparse": true, "whole-file": true, "numeric-ids": true,
+	"hard-links": true, "executability": true, "inplace": true, "append": true,
+	"append-verify": true, "mkpath": true, "protect-args": true, "secluded-args": true,
+	"info": true, "debug": true, "out-format": true, "log-file": true,
+	"log-file-format": true, "timeout": true, "contimeout": true, "rsh": true,
+	"rsync-path": true, "password-file": true, "port": true, "block-size": true,
+	"iconv": true, "outbuf": true, "modify-window": true, "sockopts": true,
+	"protocol": true, "address": true, "omit-dir-times": true, "omit-link-times": true,
+	"prune-empty-dirs": true, "fuzzy": true, "delay-updates": true, "ipv4": true, "ipv6": true,
+	"8-bit-output": true,
+}
+
+// rsyncShortOptions maps the short rsync options that have an effect on the
+// import to their long names
+var rsyncShortOptions = map[byte]string{
+	'a': "archive", 'r': "recursive", 'd': "dirs", 'n': "dry-run", 'x': "one-file-system",
+	'L': "copy-links", 'k': "copy-dirlinks", 'K': "keep-dirlinks", 'b': "backup",
+	'C': "cvs-exclude", 'R': "relative", 'A': "acls", 'X': "xattrs", 'e': "rsh",
+	'f': "filter", 'F': "F", 'B': "block-size", 'T': "temp-dir", 'M': "remote-option",
+	'v': "verbose", 'q': "quiet", 'z': "compress", 'h': "human-readable", 'P': "progress",
+	'i': "itemize-changes", 't': "times", 'p': "perms", 'o': "owner", 'g': "group",
+	'D': "devices", 'l': "links", 'c': "checksum", 'u': "update", 'S': "sparse",
+	'W': "whole-file", 'H': "hard-links", 'E': "executability", 'y': "fuzzy",
+	'm': "prune-empty-dirs", 'O': "omit-dir-times", 'J': "omit-link-times",
+	'4': "ipv4", '6': "ipv6", '8': "8-bit-output",
+}
+
+// rsyncShortValueOptions are the short options taking a value
+var rsyncShortValueOptions = map[byte]bool{'e': true, 'f': true, 'B': true, 'T': true, 'M': true}
+
+// importer collects the directories translated from rsync jobs
+type importer struct {
+	directories []interfaces.SyncDirectory
+	warnings    []string
+}
+
+// warn records a problem found while importing
+func (im *importer) warn(source string, line int, format string, args ...interface{}) {
+	im.warnings = append(im.warnings, fmt.Sprintf("%s:%d: %s", source, line, fmt.Sprintf(format, args...)))
+}
+
+// runImportRsync translates rsync cron jobs, scripts and rsnapshot
+// configurations into directory entries printed as YAML
+func runImportRsync(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "import-rsync requires a crontab, script or rsnapshot configuration (- for stdin)")
+		return 1
+	}
+
+	im := &importer{}
+	for _, path := range args {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", path, err)
+			return 1
+		}
+
+		if isRsnapshotConfig(data) {
+			im.importRsnapshot(path, data)
+		} else {
+			im.importCrontab(path, data)
+		}
+	}
+
+	for _, warning := range im.warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	if len(im.directories) == 0 {
+		fmt.Fprintln(os.Stderr, "no rsync jobs with local sources found")
+		return 1
+	}
+
+	out, err := yaml.Marshal(struct {
+		Directories []interfaces.SyncDirectory `yaml:"directories"`
+	}{im.directories})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode directories: %v\n", err)
+		return 1
+	}
+	fmt.Printf("# Generated by %s import-rsync from %s\n", appName, strings.Join(args, ", "))
+	os.Stdout.Write(out)
+	return 0
+}
+
+// add records a directory, checking it the way configuration validation
+// would
+func (im *importer) add(source string, line int, dir interfaces.SyncDirectory) {
+	for _, existing := range im.directories {
+		if existing.LocalPath == dir.LocalPath {
+			im.warn(source, line, "%s is already imported, skipping the duplicate", dir.LocalPath)
+			return
+		}
+	}
+	if info, err := os.Stat(dir.LocalPath); err != nil || !info.IsDir() {
+		im.warn(source, line, "%s is not a directory on this host, create it before using the configuration", dir.LocalPath)
+	}
+	if err := config.ValidateCronExpression(dir.Schedule); err != nil {
+		im.warn(source, line, "schedule '%s' is not supported: %v", dir.Schedule, err)
+	}
+	im.directories = append(im.directories, dir)
+}
+
+// importCrontab imports the rsync commands of a crontab or shell script.
+// Cron lines supply the schedule, other lines get defaultImportSchedule.
+func (im *importer) importCrontab(source string, data []byte) {
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		words, err := shellWords(line)
+		if err != nil {
+			if strings.Contains(line, "rsync") {
+				im.warn(source, number, "cannot parse command: %v", err)
+			}
+			continue
+		}
+
+		schedule, command := cronSchedule(words)
+		for start := 0; start < len(command); {
+			end := start
+			for end < len(command) && !slices.Contains([]string{"&&", "||", ";", "|", "&"}, command[end]) {
+				end++
+			}
+			// The rsync binary may follow a crontab user, sudo or nice
+			args := command[start:end]
+			rsync := slices.IndexFunc(args, func(word string) bool { return filepath.Base(word) == "rsync" })
+			if rsync >= 0 {
+				if schedule == "" {
+					im.warn(source, number, "no cron schedule, using '%s'", defaultImportSchedule)
+					schedule = defaultImportSchedule
+				}
+				im.importRsync(source, number, schedule, args[rsync+1:])
+			}
+			start = end + 1
+		}
+	}
+}
+
+// cronSchedule splits a crontab line into its schedule and command. Lines
+// that are not cron entries are returned as commands without a schedule.
+func cronSchedule(words []string) (string, []string) {
+	if len(words) > 1 && strings.HasPrefix(words[0], "@") {
+		if words[0] == "@reboot" {
+			return "", words[1:]
+		}
+		return words[0], words[1:]
+	}
+	if len(words) > 5 && config.ValidateCronExpression(strings.Join(words[:5], " ")) == nil {
+		return strings.Join(words[:5], " "), words[5:]
+	}
+	return "", words
+}
+
+// importRsync translates one rsync invocation
+func (im *importer) importRsync(source string, line int, schedule string, args []string) {
+	dir := interfaces.SyncDirectory{
+		SyncMode: interfaces.SyncModeScheduled,
+		Schedule: schedule,
+		Enabled:  true,
+	}
+
+	var operands []string
+	option := func(name, value string) {
+		switch name {
+		case "archive", "recursive":
+			dir.Recursive = true
+		case "dirs":
+		case "exclude":
+			im.addExclude(source, line, &dir, value)
+		case "exclude-from":
+			dir.IgnoreFiles = append(dir.IgnoreFiles, value)
+		case "filter":
+			im.addFilterRule(source, line, &dir, value)
+		case "F":
+			im.addFilterRule(source, line, &dir, ": /.rsync-filter")
+		case "include", "include-from":
+			im.warn(source, line, "--%s %s cannot be translated, write the rules as '+ pattern' lines of an rsync filter file listed in ignore_files", name, value)
+		case "max-size", "min-size":
+			size, err := parseRsyncSize(value)
+			if err != nil {
+				im.warn(source, line, "--%s %s: %v", name, value, err)
+			} else if name == "max-size" {
+				dir.FileRules.MaxSize = size
+			} else {
+				dir.FileRules.MinSize = size
+			}
+		case "link-dest", "compare-dest", "copy-dest", "backup", "backup-dir":
+			dir.SyncMode = interfaces.SyncModeBackup
+		case "bwlimit":
+			im.warn(source, line, "--bwlimit %s applies to the whole agent as performance.bandwidth_limit (bytes per second)", value)
+		case "dry-run":
+			im.warn(source, line, "the job runs with --dry-run, the imported directory syncs for real")
+		case "remove-source-files":
+			im.warn(source, line, "--remove-source-files is not imported, see archive to remove local files after upload")
+		default:
+			if strings.HasPrefix(name, "delete") {
+				im.warn(source, line, "--%s is not imported, remote copies of deleted files are kept until remote_retention.delete_unseen_after is set", name)
+			} else if !rsyncIgnoredOptions[name] {
+				im.warn(source, line, "--%s is not supported and was ignored", name)
+			}
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			operands = append(operands, args[i+1:]...)
+			i = len(args)
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			if rsyncValueOptions[name] && !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			option(name, value)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			for j := 1; j < len(arg); j++ {
+				name, ok := rsyncShortOptions[arg[j]]
+				if !ok {
+					im.warn(source, line, "-%c is not supported and was ignored", arg[j])
+					continue
+				}
+				if rsyncShortValueOptions[arg[j]] {
+					value := arg[j+1:]
+					if value == "" && i+1 < len(args) {
+						i++
+						value = args[i]
+					}
+					option(name, value)
+					break
+				}
+				option(name, "")
+			}
+		default:
+			operands = append(operands, arg)
+		}
+	}
+
+	if len(operands) < 2 {
+		im.warn(source, line, "rsync command without source and destination skipped")
+		return
+	}
+	for _, src := range operands[:len(operands)-1] {
+		if isRemoteRsyncPath(src) {
+			im.warn(source, line, "source %s is remote, only local sources can be imported", src)
+			continue
+		}
+		entry := dir
+		entry.Filters = slices.Clone(dir.Filters)
+		entry.IgnoreFiles = slices.Clone(dir.IgnoreFiles)
+		entry.LocalPath = absPath(src)
+		entry.RemotePath = remoteName(entry.LocalPath)
+		im.add(source, line, entry)
+	}
+}
+
+// addExclude adds an rsync exclude pattern. Patterns on file names become
+// filters, patterns on paths need an ignore file.
+func (im *importer) addExclude(source string, line int, dir *interfaces.SyncDirectory, pattern string) {
+	if !nameOnlyPattern(pattern) {
+		im.warnPathPattern(source, line, pattern)
+		return
+	}
+	if !slices.Contains(dir.Filters, pattern) {
+		dir.Filters = append(dir.Filters, pattern)
+	}
+}
+
+// nameOnlyPattern reports whether an rsync pattern only matches file names,
+// as filters do
+func nameOnlyPattern(pattern string) bool {
+	return !strings.Contains(pattern, "/") && !strings.Contains(pattern, "**")
+}
+
+// warnPathPattern reports an exclude pattern that filters cannot express
+func (im *importer) warnPathPattern(source string, line int, pattern string) {
+	im.warn(source, line, "exclude '%s' matches paths, add '- %s' to an rsync filter file listed in ignore_files", pattern, pattern)
+}
+
+// addFilterRule adds an rsync --filter rule
+func (im *importer) addFilterRule(source string, line int, dir *interfaces.SyncDirectory, rule string) {
+	token, value, _ := strings.Cut(strings.TrimSpace(rule), " ")
+	value = strings.TrimSpace(value)
+	switch token {
+	case "-", "exclude":
+		im.addExclude(source, line, dir, value)
+	case ".", "merge", ":", "dir-merge":
+		if token == ":" || token == "dir-merge" {
+			// Only the file at the top of the directory is read
+			value = strings.TrimPrefix(value, "/")
+		}
+		if slices.Contains(dir.IgnoreFiles, value) {
+			return
+		}
+		dir.IgnoreFiles = append(dir.IgnoreFiles, value)
+		if token == "." || token == "merge" {
+			return
+		}
+		im.warn(source, line, "per-directory merge file %s is only read from the top of the directory", value)
+	default:
+		im.warn(source, line, "filter rule '%s' cannot be translated", rule)
+	}
+}
+
+// isRsnapshotConfig reports whether data looks like an rsnapshot
+// configuration
+func isRsnapshotConfig(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		key, _, _ := strings.Cut(strings.TrimSpace(line), "\t")
+		switch key {
+		case "config_version", "snapshot_root", "backup", "retain", "interval":
+			return true
+		}
+	}
+	return false
+}
+
+// rsnapshotSchedules are the cron schedules suggested for rsnapshot levels
+var rsnapshotSchedules = map[string]string{
+	"hourly":  "0 */4 * * *",
+	"daily":   "30 3 * * *",
+	"weekly":  "0 3 * * 1",
+	"monthly": "30 2 1 * *",
+}
+
+// importRsnapshot imports the backup points of an rsnapshot configuration
+// as backup directories. Retain levels become the retention policy and the
+// most frequent level the schedule.
+func (im *importer) importRsnapshot(source string, data []byte) {
+	var retention interfaces.RetentionPolicy
+	var excludes, excludeFiles []string
+	schedule := ""
+	type backupPoint struct {
+		line               int
+		src, dest, options string
+	}
+	var points []backupPoint
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		fields = slices.DeleteFunc(fields, func(field string) bool { return field == "" })
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "retain", "interval":
+			if len(fields) < 3 {
+				continue
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil {
+				im.warn(source, number, "invalid count '%s'", fields[2])
+				continue
+			}
+			switch fields[1] {
+			case "daily":
+				retention.KeepDaily = count
+			case "weekly":
+				retention.KeepWeekly = count
+			case "monthly":
+				retention.KeepMonthly = count
+			default:
+				retention.KeepLast = count
+			}
+			if schedule == "" {
+				// Levels are listed from the most frequent
+				if schedule = rsnapshotSchedules[fields[1]]; schedule == "" {
+					im.warn(source, number, "no schedule is known for level '%s', using '%s'", fields[1], defaultImportSchedule)
+					schedule = defaultImportSchedule
+				}
+			}
+		case "exclude":
+			if nameOnlyPattern(fields[1]) {
+				excludes = append(excludes, fields[1])
+			} else {
+				im.warnPathPattern(source, number, fields[1])
+			}
+		case "exclude_file":
+			excludeFiles = append(excludeFiles, fields[1])
+		case "include", "include_file":
+			im.warn(source, number, "%s cannot be translated, write the rules as '+ pattern' lines of an rsync filter file listed in ignore_files", fields[0])
+		case "backup":
+			if len(fields) < 3 {
+				im.warn(source, number, "backup line needs a source and a destination")
+				continue
+			}
+			point := backupPoint{line: number, src: fields[1], dest: fields[2]}
+			if len(fields) > 3 {
+				point.options = fields[3]
+			}
+			points = append(points, point)
+		case "backup_script", "backup_exec":
+			im.warn(source, number, "%s is not supported, run the script separately", fields[0])
+		case "one_fs", "rsync_short_args", "rsync_long_args", "link_dest":
+			im.warn(source, number, "%s is not imported", fields[0])
+		}
+	}
+
+	if schedule == "" {
+		im.warn(source, 0, "no retain levels found, using '%s'", defaultImportSchedule)
+		schedule = defaultImportSchedule
+	}
+
+	for _, point := range points {
+		if isRemoteRsyncPath(point.src) {
+			im.warn(source, point.line, "source %s is remote, only local sources can be imported", point.src)
+			continue
+		}
+		dir := interfaces.SyncDirectory{
+			LocalPath:   absPath(point.src),
+			RemotePath:  strings.Trim(filepath.ToSlash(filepath.Join(point.dest, filepath.Base(absPath(point.src)))), "/"),
+			SyncMode:    interfaces.SyncModeBackup,
+			Schedule:    schedule,
+			Recursive:   true,
+			Enabled:     true,
+			Retention:   retention,
+			IgnoreFiles: slices.Clone(excludeFiles),
+		}
+		dir.Filters = slices.Clone(excludes)
+		for _, option := range strings.Split(point.options, ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(option), "=")
+			switch key {
+			case "":
+			case "exclude":
+				im.addExclude(source, point.line, &dir, value)
+			case "exclude_file":
+				dir.IgnoreFiles = append(dir.IgnoreFiles, value)
+			default:
+				im.warn(source, point.line, "backup option %s is not imported", key)
+			}
+		}
+		im.add(source, point.line, dir)
+	}
+}
+
+// isRemoteRsyncPath reports whether an rsync operand names another host
+func isRemoteRsyncPath(path string) bool {
+	if strings.HasPrefix(path, "rsync://") {
+		return true
+	}
+	host, _, found := strings.Cut(path, ":")
+	return found && !strings.Contains(host, "/")
+}
+
+// absPath cleans an operand into an absolute path, dropping the trailing
+// slash rsync uses to copy a directory's content
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// remoteName derives a remote path from a local directory
+func remoteName(localPath string) string {
+	if name := filepath.Base(localPath); name != string(filepath.Separator) && name != "." {
+		return name
+	}
+	return "root"
+}
+
+// parseRsyncSize parses an rsync size such as 500K, 1.5G or 10MB. Single
+// letter and iB suffixes are powers of 1024, B suffixes powers of 1000.
+func parseRsyncSize(value string) (int64, error) {
+	value = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(value), "+1"), "-1")
+	number := strings.TrimRightFunc(value, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	suffix := value[len(number):]
+
+	base := 1024.0
+	switch {
+	case suffix == "" || suffix == "b":
+		suffix = ""
+	case len(suffix) == 2 && suffix[1] == 'b':
+		base, suffix = 1000, suffix[:1]
+	case len(suffix) == 3 && strings.HasSuffix(suffix, "ib"):
+		suffix = suffix[:1]
+	}
+	exponent := strings.Index("kmgtp", suffix) + 1
+	if len(suffix) > 1 || (suffix != "" && exponent == 0) {
+		return 0, fmt.Errorf("unknown size suffix '%s'", suffix)
+	}
+
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid size")
+	}
+	for range exponent {
+		size *= base
+	}
+	return int64(size), nil
+}
+
+// shellWords splits a command line into words the way a POSIX shell does
+// for quotes and backslashes. Operators such as && and ; become words of
+// their own.
+func shellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	redirect := false // the next word is the target of a redirection
+	flush := func() {
+		if inWord && redirect {
+			redirect = false
+		} else if inWord {
+			words = append(words, word.String())
+		}
+		word.Reset()
+		inWord = false
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) && strings.IndexByte(`"\$`+"`", line[j+1]) >= 0 {
+					j++
+				}
+				word.WriteByte(line[j])
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			i = j
+			inWord = true
+		case c == '#' && !inWord:
+			flush()
+			return words, nil
+		case c == '>' || c == '<':
+			// Redirections such as 2>&1 and >> file are dropped with the
+			// descriptor before them and their target
+			if inWord && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			flush()
+			for i+1 < len(line) && (line[i+1] == '>' || line[i+1] == '&') {
+				i++
+			}
+			redirect = true
+		case c == ';' || c == '|' || c == '&':
+			flush()
+			op := string(c)
+			if i+1 < len(line) && line[i+1] == c && c != ';' {
+				op += string(c)
+				i++
+			}
+			words = append(words, op)
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+	return words, nil
+}
@@ -0,0 +1,522 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/service"
+	"CloudAWSync/internal/utils"
+)
+
+const (
+	// installConfigDir holds the configuration of the installed service
+	installConfigDir = "/etc/cloudawsync"
+	// unitDir holds system unit files
+	unitDir = "/etc/systemd/system"
+	// installRecordPath lists what install created, so uninstall -purge
+	// removes nothing it did not create
+	installRecordPath = installConfigDir + "/installed.json"
+)
+
+// installRecord lists the accounts and directories install created
+type installRecord struct {
+	path string
+
+	User        string   `json:"user,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Directories []string `json:"directories,omitempty"`
+}
+
+// loadInstallRecord reads the install record at path, returning an empty
+// record if install has not written one
+func loadInstallRecord(path string) (*installRecord, error) {
+	record := &installRecord{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return record, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("invalid install record: %w", err)
+	}
+	return record, nil
+}
+
+// save writes the install record. It is saved after every step that
+// creates something, so a failed install still records what it did.
+func (r *installRecord) save() error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return utils.AtomicWrite(r.path, append(data, '\n'), 0644)
+}
+
+// dedicated reports whether dir belongs to the agent alone: install created
+// it, or it or one of its parents is named after the agent, such as
+// /var/lib/cloudawsync. Other directories may be shared, like /var/log.
+func (r *installRecord) dedicated(dir string) bool {
+	dir = filepath.Clean(dir)
+	if slices.Contains(r.Directories, dir) {
+		return true
+	}
+	for _, element := range strings.Split(dir, string(filepath.Separator)) {
+		if strings.Contains(strings.ToLower(element), "cloudawsync") {
+			return true
+		}
+	}
+	return false
+}
+
+// createDirectory creates dir and any missing parents with perm, adding
+// those it created to the record
+func (r *installRecord) createDirectory(dir string, perm os.FileMode) error {
+	var missing []string
+	for parent := filepath.Clean(dir); !utils.FileExists(parent); parent = filepath.Dir(parent) {
+		missing = append(missing, parent)
+		if parent == filepath.Dir(parent) {
+			break
+		}
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	for _, created := range missing {
+		if !slices.Contains(r.Directories, created) {
+			r.Directories = append(r.Directories, created)
+		}
+	}
+	return r.save()
+}
+
+// installStep is one action of install or uninstall
+type installStep struct {
+	description string
+	run         func() error
+}
+
+// runSteps runs steps in order, stopping at the first failure, or only
+// prints them with dryRun. It returns the process exit code.
+func runSteps(steps []installStep, dryRun bool) int {
+	for _, step := range steps {
+		if dryRun {
+			fmt.Printf("Would %s\n", step.description)
+			continue
+		}
+		fmt.Printf("%s\n", capitalize(step.description))
+		if err := step.run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to %s: %v\n", step.description, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// capitalize upper-cases the first letter of a step description
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return string(s[0]-'a'+'A') + s[1:]
+}
+
+// command returns a step running an external command
+func command(name string, args ...string) func() error {
+	return func() error {
+		cmd := exec.Command(name, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
+
+// runInstall installs the agent as a system service, returning the process
+// exit code
+func runInstall(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("install", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Print what would be done without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if os.Geteuid() != 0 && !*dryRun {
+		fmt.Fprintln(os.Stderr, "install must be run as root (use -dry-run to preview)")
+		return 1
+	}
+
+	source := *configPath
+	if source == "" {
+		source = config.DefaultConfigPath()
+	}
+	source, _ = filepath.Abs(source)
+	target := filepath.Join(installConfigDir, "config.yaml")
+
+	// An installed configuration is kept and decides the service's paths
+	var installConfig installStep
+	switch {
+	case source == target:
+	case utils.FileExists(target):
+		installed, err := config.LoadConfig(target)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load installed configuration: %v\n", err)
+			return 1
+		}
+		cfg = installed
+		fmt.Printf("Keeping existing configuration %s\n", target)
+	case utils.FileExists(source):
+		installConfig = installStep{
+			description: fmt.Sprintf("copy configuration %s to %s", source, target),
+			run: func() error {
+				return installFile(source, target, 0640, "root", cfg.SystemD.Group)
+			},
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "No configuration at %s, create one with init first\n", source)
+		return 1
+	}
+
+	serviceUser, group := cfg.SystemD.User, cfg.SystemD.Group
+	binary := filepath.Join(cfg.SystemD.WorkingDir, "cloudawsync")
+	unitPath := filepath.Join(unitDir, cfg.SystemD.ServiceName+".service")
+
+	record, err := loadInstallRecord(installRecordPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read install record: %v\n", err)
+		return 1
+	}
+
+	var steps []installStep
+	if _, err := user.LookupGroup(group); err != nil {
+		addGroup := command("groupadd", "--system", group)
+		steps = append(steps, installStep{
+			description: "create group " + group,
+			run: func() error {
+				if err := addGroup(); err != nil {
+					return err
+				}
+				record.Group = group
+				return record.save()
+			},
+		})
+	}
+	if _, err := user.Lookup(serviceUser); err != nil {
+		addUser := command("useradd", "--system", "--gid", group, "--home-dir", cfg.SystemD.WorkingDir,
+			"--shell", "/bin/false", "--comment", "CloudAWSync service user", serviceUser)
+		steps = append(steps, installStep{
+			description: "create user " + serviceUser,
+			run: func() error {
+				if err := addUser(); err != nil {
+					return err
+				}
+				record.User = serviceUser
+				return record.save()
+			},
+		})
+	}
+
+	// Existing directories that may be shared, such as /var/log, keep
+	// their owner and mode
+	for _, dir := range serviceDirectories(cfg) {
+		if utils.FileExists(dir) && !record.dedicated(dir) {
+			fmt.Printf("Keeping owner of shared directory %s\n", dir)
+			continue
+		}
+		steps = append(steps, installStep{
+			description: fmt.Sprintf("create %s owned by %s:%s", dir, serviceUser, group),
+			run: func() error {
+				perm := os.FileMode(0750)
+				if dir == cfg.StateDir {
+					// State may name every synced file, keep it private
+					perm = 0700
+				}
+				if err := record.createDirectory(dir, perm); err != nil {
+					return err
+				}
+				if err := os.Chmod(dir, perm); err != nil {
+					return err
+				}
+				return chown(dir, serviceUser, group)
+			},
+		})
+	}
+
+	// The service cannot create its log file in a shared directory
+	logFile := cfg.Logging.OutputPath
+	if logDir := filepath.Dir(logFile); filepath.IsAbs(logFile) && utils.FileExists(logDir) && !record.dedicated(logDir) {
+		steps = append(steps, installStep{
+			description: fmt.Sprintf("create %s owned by %s:%s", logFile, serviceUser, group),
+			run: func() error {
+				file, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+				if err != nil {
+					return err
+				}
+				if err := file.Close(); err != nil {
+					return err
+				}
+				return chown(logFile, serviceUser, group)
+			},
+		})
+	}
+
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to locate the running binary: %v\n", err)
+		return 1
+	}
+	if executable != binary {
+		steps = append(steps, installStep{
+			description: fmt.Sprintf("install %s to %s", executable, binary),
+			run: func() error {
+				return installFile(executable, binary, 0755, "root", "root")
+			},
+		})
+	}
+
+	steps = append(steps, installStep{
+		description: "create " + installConfigDir,
+		run:         func() error { return os.MkdirAll(installConfigDir, 0755) },
+	})
+	if installConfig.run != nil {
+		steps = append(steps, installConfig)
+	}
+
+	unit := systemdUnit(cfg, target, binary, false)
+	steps = append(steps,
+		installStep{
+			description: "write " + unitPath,
+			run:         func() error { return utils.AtomicWrite(unitPath, []byte(unit), 0644) },
+		},
+		installStep{
+			description: "run systemctl daemon-reload",
+			run:         command("systemctl", "daemon-reload"),
+		},
+		installStep{
+			description: "run systemctl enable --now " + cfg.SystemD.ServiceName,
+			run:         command("systemctl", "enable", "--now", cfg.SystemD.ServiceName),
+		},
+	)
+
+	return runSteps(steps, *dryRun)
+}
+
+// runUninstall removes the system service installed by install, returning
+// the process exit code. Configuration, state, logs and the service user
+// are kept unless -purge is given.
+func runUninstall(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("uninstall", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "Print what would be done without changing anything")
+	purge := flags.Bool("purge", false, "Also remove configuration, state, logs and the service user")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if os.Geteuid() != 0 && !*dryRun {
+		fmt.Fprintln(os.Stderr, "uninstall must be run as root (use -dry-run to preview)")
+		return 1
+	}
+
+	target := filepath.Join(installConfigDir, "config.yaml")
+	if utils.FileExists(target) {
+		if installed, err := config.LoadConfig(target); err == nil {
+			cfg = installed
+		}
+	}
+	unitPath := filepath.Join(unitDir, cfg.SystemD.ServiceName+".service")
+	binary := filepath.Join(cfg.SystemD.WorkingDir, "cloudawsync")
+	record, err := loadInstallRecord(installRecordPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read install record: %v\n", err)
+		return 1
+	}
+
+	var steps []installStep
+	if utils.FileExists(unitPath) {
+		steps = append(steps,
+			installStep{
+				description: "run systemctl disable --now " + cfg.SystemD.ServiceName,
+				run:         command("systemctl", "disable", "--now", cfg.SystemD.ServiceName),
+			},
+			installStep{
+				description: "remove " + unitPath,
+				run:         func() error { return os.Remove(unitPath) },
+			},
+			installStep{
+				description: "run systemctl daemon-reload",
+				run:         command("systemctl", "daemon-reload"),
+			},
+		)
+	}
+	if utils.FileExists(binary) {
+		steps = append(steps, installStep{
+			description: "remove " + binary,
+			run:         func() error { return os.Remove(binary) },
+		})
+	}
+
+	if *purge {
+		steps = append(steps, installStep{
+			description: "remove " + installConfigDir,
+			run:         func() error { return os.RemoveAll(installConfigDir) },
+		})
+		files := []string{cfg.State.Path, cfg.Audit.Path, cfg.Security.NameMapPath, service.InstanceLockPath(cfg), cfg.Logging.OutputPath}
+		for _, file := range files {
+			if filepath.IsAbs(file) && utils.FileExists(file) {
+				steps = append(steps, installStep{
+					description: "remove " + file,
+					run:         func() error { return os.Remove(file) },
+				})
+			}
+		}
+		if cacheDir := cfg.Mount.CacheDir; cacheDir != "" && utils.FileExists(cacheDir) {
+			if filepath.IsAbs(cacheDir) && record.dedicated(cacheDir) {
+				steps = append(steps, installStep{
+					description: "remove " + cacheDir,
+					run:         func() error { return os.RemoveAll(cacheDir) },
+				})
+			} else {
+				fmt.Printf("Keeping mount cache %s, it is not a directory of its own\n", cacheDir)
+			}
+		}
+		// Directories are only removed once empty, and only if they are the
+		// agent's own, deepest first so created parents go too
+		var dirs []string
+		for _, dir := range append(serviceDirectories(cfg), record.Directories...) {
+			if record.dedicated(dir) && !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		slices.SortFunc(dirs, func(a, b string) int { return len(b) - len(a) })
+		for _, dir := range dirs {
+			steps = append(steps, installStep{
+				description: fmt.Sprintf("remove %s if empty", dir),
+				run: func() error {
+					if err := os.Remove(dir); err != nil && !errors.Is(err, os.ErrNotExist) {
+						fmt.Printf("  kept %s: %v\n", dir, err)
+					}
+					return nil
+				},
+			})
+		}
+		// Accounts that existed before install may be used by others
+		if _, err := user.Lookup(cfg.SystemD.User); err == nil && record.User == cfg.SystemD.User {
+			steps = append(steps, installStep{
+				description: "remove user " + cfg.SystemD.User,
+				run:         command("userdel", cfg.SystemD.User),
+			})
+		}
+		if _, err := user.LookupGroup(cfg.SystemD.Group); err == nil && record.Group == cfg.SystemD.Group {
+			steps = append(steps, installStep{
+				description: "remove group " + cfg.SystemD.Group,
+				run:         command("groupdel", cfg.SystemD.Group),
+			})
+		}
+	}
+
+	if len(steps) == 0 {
+		fmt.Println("Nothing to uninstall")
+		return 0
+	}
+	return runSteps(steps, *dryRun)
+}
+
+// serviceDirectories returns the directories the service user must own:
+// the working directory, the state directory and those holding logs, the
+// state database, the audit log and the mount cache
+func serviceDirectories(cfg *config.Config) []string {
+	var dirs []string
+	add := func(dir string) {
+		if filepath.IsAbs(dir) && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	add(cfg.SystemD.WorkingDir)
+	add(cfg.StateDir)
+	add(filepath.Dir(cfg.Logging.OutputPath))
+	if cfg.State.Path != "" {
+		add(filepath.Dir(cfg.State.Path))
+	}
+	if cfg.Audit.Path != "" {
+		add(filepath.Dir(cfg.Audit.Path))
+	}
+	add(cfg.Mount.CacheDir)
+	return dirs
+}
+
+// installFile copies src to dst with the given mode and owner
+func installFile(src, dst string, perm os.FileMode, owner, group string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := utils.AtomicWrite(dst, data, perm); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, perm); err != nil {
+		return err
+	}
+	return chown(dst, owner, group)
+}
+
+// chown changes the owner of path to the named user and group
+func chown(path, owner, group string) error {
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return err
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s: %w", u.Uid, err)
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s: %w", g.Gid, err)
+	}
+	return os.Chown(path, uid, gid)
+}
@@ -0,0 +1,93 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestInstallRecordDedicated(t *testing.T) {
+	record := &installRecord{Directories: []string{"/srv/agent-state"}}
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "/var/lib/cloudawsync", want: true},
+		{dir: "/var/cache/cloudawsync/mount", want: true},
+		{dir: "/opt/CloudAWSync/", want: true},
+		{dir: "/srv/agent-state", want: true},
+		{dir: "/var/log"},
+		{dir: "/tmp"},
+		{dir: "/srv"},
+		{dir: "/home/user/.cache"},
+		{dir: "/"},
+	}
+	for _, tt := range tests {
+		if got := record.dedicated(tt.dir); got != tt.want {
+			t.Errorf("dedicated(%q) = %v, want %v", tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestInstallRecordCreateDirectory(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "etc", "installed.json")
+	record, err := loadInstallRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := filepath.Join(root, "shared")
+	if err := os.Mkdir(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := record.createDirectory(existing, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Directories) != 0 {
+		t.Errorf("existing directory recorded as created: %v", record.Directories)
+	}
+
+	nested := filepath.Join(existing, "agent", "logs")
+	if err := record.createDirectory(nested, 0750); err != nil {
+		t.Fatal(err)
+	}
+	record.User = "svc"
+
+	loaded, err := loadInstallRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{nested, filepath.Dir(nested)}
+	if !slices.Equal(loaded.Directories, want) {
+		t.Errorf("recorded directories = %v, want %v", loaded.Directories, want)
+	}
+	if loaded.User != "" {
+		t.Errorf("recorded user %q that was never saved", loaded.User)
+	}
+	if loaded.dedicated(existing) || !loaded.dedicated(nested) {
+		t.Error("only created directories are dedicated")
+	}
+}
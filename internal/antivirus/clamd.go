@@ -0,0 +1,185 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package antivirus scans content with a ClamAV daemon (clamd)
+package antivirus
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// chunkSize is the size of the chunks content is streamed to clamd in
+const chunkSize = 64 * 1024
+
+// errReadContent marks failures to read the content being scanned, as
+// opposed to failures to send it
+var errReadContent = errors.New("failed to read content")
+
+// Client scans content with clamd's INSTREAM command. A connection is
+// opened for each scan.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClient creates a client for the clamd listening at address, a unix
+// socket path (optionally prefixed with unix://) or host:port (optionally
+// prefixed with tcp://). timeout limits each scan, 0 means no limit.
+func NewClient(address string, timeout time.Duration) (*Client, error) {
+	c := &Client{timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		c.network, c.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		c.network, c.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		c.network, c.address = "unix", address
+	default:
+		c.network, c.address = "tcp", address
+	}
+	if c.address == "" {
+		return nil, fmt.Errorf("clamd address is empty")
+	}
+	if c.network == "tcp" {
+		if _, _, err := net.SplitHostPort(c.address); err != nil {
+			return nil, fmt.Errorf("invalid clamd address %q: %w", address, err)
+		}
+	}
+	return c, nil
+}
+
+// Ping checks that clamd answers
+func (c *Client) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "zPING\x00", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// Scan streams the content read from r to clamd and reports whether it
+// contains malware
+func (c *Client) Scan(ctx context.Context, r io.Reader) (interfaces.ScanResult, error) {
+	reply, err := c.command(ctx, "zINSTREAM\x00", r)
+	if err != nil {
+		return interfaces.ScanResult{}, err
+	}
+
+	// Replies are "stream: OK", "stream: <signature> FOUND" or
+	// "<message> ERROR"
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return interfaces.ScanResult{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return interfaces.ScanResult{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	case strings.HasSuffix(result, " ERROR"):
+		return interfaces.ScanResult{}, fmt.Errorf("clamd failed to scan: %s", strings.TrimSuffix(result, " ERROR"))
+	}
+	return interfaces.ScanResult{}, fmt.Errorf("unexpected clamd reply %q", reply)
+}
+
+// command sends a null-terminated command, followed by the content of
+// body in INSTREAM chunks when body is not nil, and returns the reply
+func (c *Client) command(ctx context.Context, command string, body io.Reader) (string, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := io.WriteString(conn, command); err != nil {
+		return "", fmt.Errorf("failed to send clamd command: %w", err)
+	}
+	var writeErr error
+	if body != nil {
+		// clamd replies and closes the connection early when the stream
+		// exceeds its StreamMaxLength, so the reply is read regardless
+		writeErr = writeChunks(conn, body)
+		if errors.Is(writeErr, errReadContent) {
+			// clamd would wait for the rest of the stream without
+			// replying; closing the connection ends the scan
+			return "", writeErr
+		}
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if reply == "" && writeErr != nil {
+		return "", writeErr
+	}
+	if err != nil && (err != io.EOF || reply == "") {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("failed to read clamd reply: %w", ctx.Err())
+		}
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return strings.TrimSpace(strings.TrimSuffix(reply, "\x00")), nil
+}
+
+// writeChunks streams body as length-prefixed chunks ended by an empty one
+func writeChunks(w io.Writer, body io.Reader) error {
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(body, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := w.Write(buf[:4+n]); err != nil {
+				return fmt.Errorf("failed to send content to clamd: %w", err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", errReadContent, err)
+		}
+	}
+	if _, err := w.Write([]byte{0, 0, 0, 0}); err != nil {
+		return fmt.Errorf("failed to send content to clamd: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// Entry is a single audit record
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"` // e.g. delete, delete_version
+	Key       string    `json:"key,omitempty"`
+	VersionID string    `json:"version_id,omitempty"`
+	LocalPath string    `json:"local_path,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	DryRun    bool      `json:"dry_run,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	// Outcome of malware scans
+	Result        string `json:"result,omitempty"`         // clean, infected or skipped
+	Signature     string `json:"signature,omitempty"`      // malware found
+	QuarantinedTo string `json:"quarantined_to,omitempty"` // where the infected file was moved
+
+	// Identifiers matching the entry to agent logs and S3 server access logs
+	SyncID            string `json:"sync_id,omitempty"`
+	RequestID         string `json:"request_id,omitempty"`
+	ExtendedRequestID string `json:"extended_request_id,omitempty"`
+}
+
+// SetError records err as the reason the action failed, with the IDs of
+// the storage service request that failed, if any
+func (e *Entry) SetError(err error) {
+	e.Error = err.Error()
+	e.RequestID, e.ExtendedRequestID = interfaces.RequestIDs(err)
+}
+
+// Log appends audit entries to a file as JSON lines. A nil *Log discards
+// every entry, so callers need not check whether auditing is enabled.
+type Log struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// Open opens or creates the audit log at path for appending
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{file: file}, nil
+}
+
+// Record appends an entry, filling in the time if unset
+func (l *Log) Record(entry Entry) error {
+	if l == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// Close closes the audit log
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,158 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package chunker splits files into content-defined chunks, encrypts them
+// and groups them into packfiles for space-efficient backups.
+package chunker
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Params bound the size of chunks. Chunk boundaries depend only on the
+// bytes near them, so an insertion changes the chunks around it but not
+// the rest of the file.
+type Params struct {
+	MinSize int // no boundary is placed before this many bytes
+	AvgSize int // targeted chunk size, rounded down to a power of two
+	MaxSize int // a boundary is forced after this many bytes
+}
+
+// DefaultParams are the chunk sizes used when none are configured
+var DefaultParams = Params{
+	MinSize: 512 * 1024,
+	AvgSize: 1024 * 1024,
+	MaxSize: 8 * 1024 * 1024,
+}
+
+// Validate checks that the sizes are usable
+func (p Params) Validate() error {
+	if p.MinSize < 64 {
+		return fmt.Errorf("minimum chunk size %d is below 64 bytes", p.MinSize)
+	}
+	if p.AvgSize <= p.MinSize || p.MaxSize <= p.AvgSize {
+		return fmt.Errorf("chunk sizes must satisfy min < avg < max, got %d, %d, %d", p.MinSize, p.AvgSize, p.MaxSize)
+	}
+	return nil
+}
+
+// gear holds one pseudo-random value per byte. It is fixed so that every
+// agent cuts the same content at the same boundaries.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	state := uint64(0x436c6f7564415753) // "CloudAWS"
+	for i := range gear {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker reads content-defined chunks from a stream using a gear rolling
+// hash with normalized chunking (FastCDC): boundaries are harder to hit
+// before the average size and easier after it, which narrows the spread
+// of chunk sizes.
+type Chunker struct {
+	r      io.Reader
+	params Params
+	maskS  uint64 // used before the average size
+	maskL  uint64 // used after the average size
+	buf    []byte
+	start  int
+	end    int
+	eof    bool
+}
+
+// New returns a chunker reading from r
+func New(r io.Reader, params Params) *Chunker {
+	avgBits := bits.Len(uint(params.AvgSize)) - 1
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  topBits(avgBits + 1),
+		maskL:  topBits(avgBits - 1),
+		buf:    make([]byte, params.MaxSize),
+	}
+}
+
+// topBits returns a mask of the n most significant bits, which depend on
+// the last 64 bytes hashed
+func topBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The returned
+// slice is only valid until the following call.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.params.MaxSize && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.params.MinSize {
+		return n
+	}
+	n = min(n, c.params.MaxSize)
+	normal := min(n, c.params.AvgSize)
+
+	var hash uint64
+	i := c.params.MinSize
+	for ; i < normal; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,212 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+// testParams are small sizes so tests cover many boundaries quickly
+var testParams = Params{MinSize: 256, AvgSize: 1024, MaxSize: 4096}
+
+// randomData returns n bytes that are the same on every run
+func randomData(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// chunks splits data read through r into copies of its chunks
+func chunks(t *testing.T, r io.Reader, params Params) [][]byte {
+	t.Helper()
+	var result [][]byte
+	c := New(r, params)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, bytes.Clone(chunk))
+	}
+}
+
+// chunkSums returns the SHA-256 of each chunk
+func chunkSums(chunks [][]byte) [][32]byte {
+	sums := make([][32]byte, len(chunks))
+	for i, chunk := range chunks {
+		sums[i] = sha256.Sum256(chunk)
+	}
+	return sums
+}
+
+func TestParamsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		wantErr bool
+	}{
+		{name: "default", params: DefaultParams},
+		{name: "small", params: testParams},
+		{name: "minimum too small", params: Params{MinSize: 32, AvgSize: 1024, MaxSize: 4096}, wantErr: true},
+		{name: "average not above minimum", params: Params{MinSize: 1024, AvgSize: 1024, MaxSize: 4096}, wantErr: true},
+		{name: "maximum not above average", params: Params{MinSize: 256, AvgSize: 4096, MaxSize: 4096}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.params.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChunkerRoundTrip(t *testing.T) {
+	data := randomData(300_000, 1)
+	tests := []struct {
+		name string
+		data []byte
+		r    func([]byte) io.Reader
+	}{
+		{name: "empty", data: nil},
+		{name: "below minimum", data: data[:100]},
+		{name: "one maximum chunk", data: data[:testParams.MaxSize]},
+		{name: "random", data: data},
+		{name: "one byte reads", data: data, r: func(b []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(b)) }},
+		{name: "half reads", data: data, r: func(b []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(b)) }},
+		{name: "data with eof", data: data, r: func(b []byte) io.Reader { return iotest.DataErrReader(bytes.NewReader(b)) }},
+	}
+	want := chunkSums(chunks(t, bytes.NewReader(data), testParams))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := io.Reader(bytes.NewReader(tt.data))
+			if tt.r != nil {
+				r = tt.r(tt.data)
+			}
+			got := chunks(t, r, testParams)
+
+			if joined := bytes.Join(got, nil); !bytes.Equal(joined, tt.data) {
+				t.Fatalf("chunks join to %d bytes that differ from the %d read", len(joined), len(tt.data))
+			}
+			for i, chunk := range got {
+				last := i == len(got)-1
+				if len(chunk) > testParams.MaxSize || (!last && len(chunk) < testParams.MinSize) || len(chunk) == 0 {
+					t.Errorf("chunk %d of %d has %d bytes", i, len(got), len(chunk))
+				}
+			}
+			// Boundaries depend on the content, not on how it is read
+			if len(tt.data) == len(data) && !slices.Equal(chunkSums(got), want) {
+				t.Error("chunks differ from those of a single read")
+			}
+		})
+	}
+}
+
+func TestChunkerReadError(t *testing.T) {
+	failure := errors.New("disk failure")
+	r := io.MultiReader(bytes.NewReader(randomData(1000, 2)), iotest.ErrReader(failure))
+	if _, err := New(r, testParams).Next(); !errors.Is(err, failure) {
+		t.Errorf("Next() = %v, want the read error", err)
+	}
+}
+
+func TestChunkerSizes(t *testing.T) {
+	got := chunks(t, bytes.NewReader(randomData(2_000_000, 3)), testParams)
+	average := 2_000_000 / len(got)
+	if average < testParams.AvgSize/2 || average > testParams.AvgSize*2 {
+		t.Errorf("average chunk size %d is far from the targeted %d", average, testParams.AvgSize)
+	}
+
+	// Content without boundaries is cut at the maximum size
+	zeros := chunks(t, bytes.NewReader(make([]byte, 5*testParams.MaxSize+10)), testParams)
+	for i, chunk := range zeros {
+		want := testParams.MaxSize
+		if i == len(zeros)-1 {
+			want = 10
+		}
+		if len(chunk) != want {
+			t.Errorf("zero chunk %d has %d bytes, want %d", i, len(chunk), want)
+		}
+	}
+}
+
+func TestChunkerBoundaryStability(t *testing.T) {
+	data := randomData(500_000, 4)
+	originalChunks := chunks(t, bytes.NewReader(data), testParams)
+	original := chunkSums(originalChunks)
+	if len(original) < 100 {
+		t.Fatalf("only %d chunks, the test needs many", len(original))
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		insert []byte
+		remove int
+	}{
+		{name: "insert byte", offset: 250_000, insert: []byte{0x42}},
+		{name: "insert at start", offset: 0, insert: []byte("header")},
+		{name: "insert near end", offset: 499_000, insert: randomData(100, 5)},
+		{name: "delete bytes", offset: 123_456, remove: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := slices.Concat(data[:tt.offset], tt.insert, data[tt.offset+tt.remove:])
+			changed := chunkSums(chunks(t, bytes.NewReader(modified), testParams))
+
+			// Chunks ending before the edit are unchanged, and the chunking
+			// resynchronizes within a few chunks after it
+			prefix := 0
+			for prefix < min(len(original), len(changed)) && original[prefix] == changed[prefix] {
+				prefix++
+			}
+			suffix := 0
+			for suffix < min(len(original), len(changed))-prefix &&
+				original[len(original)-1-suffix] == changed[len(changed)-1-suffix] {
+				suffix++
+			}
+			if differing := len(changed) - prefix - suffix; differing > 3 {
+				t.Errorf("%d of %d chunks changed, want at most 3", differing, len(changed))
+			}
+			var unaffected, end int
+			for _, chunk := range originalChunks {
+				if end += len(chunk); end > tt.offset {
+					break
+				}
+				unaffected++
+			}
+			if prefix < unaffected {
+				t.Errorf("%d chunks before the edit kept, want %d", prefix, unaffected)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package chunker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// minSecret is the minimum length of the secret chunks are encrypted with
+const minSecret = 32
+
+// ErrAuthentication is returned when sealed data was not encrypted with
+// the key or was modified
+var ErrAuthentication = errors.New("sealed data failed authentication")
+
+// Key encrypts chunks and derives their IDs. IDs are keyed so that the
+// stored names reveal nothing about the content to anyone without the
+// secret.
+type Key struct {
+	aead  cipher.AEAD
+	idKey []byte
+}
+
+// NewKey derives the chunk encryption and ID keys from secret
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) < minSecret {
+		return nil, fmt.Errorf("chunk key secret must be at least %d bytes, got %d", minSecret, len(secret))
+	}
+	keys, err := hkdf.Key(sha256.New, secret, nil, "cloudawsync chunk encryption", 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive chunk keys: %w", err)
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk cipher: %w", err)
+	}
+	return &Key{aead: aead, idKey: keys[32:]}, nil
+}
+
+// ID returns the hex encoded ID of plaintext content
+func (k *Key) ID(data []byte) string {
+	mac := hmac.New(sha256.New, k.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal encrypts data with a random nonce, returning nonce and ciphertext
+func (k *Key) Seal(data []byte) []byte {
+	out := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(data)+k.aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		panic(fmt.Sprintf("failed to read random nonce: %v", err))
+	}
+	return k.aead.Seal(out, out, data, nil)
+}
+
+// Open decrypts data produced by Seal
+func (k *Key) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < k.aead.NonceSize()+k.aead.Overhead() {
+		return nil, ErrAuthentication
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	data, err := k.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrAuthentication
+	}
+	return data, nil
+}
+
+// Overhead is the number of bytes Seal adds to its input
+func (k *Key) Overhead() int {
+	return k.aead.NonceSize() + k.aead.Overhead()
+}
@@ -0,0 +1,119 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package chunker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testKey returns a key derived from a fixed secret starting with b
+func testKey(t *testing.T, b byte) *Key {
+	t.Helper()
+	secret := bytes.Repeat([]byte{b}, minSecret)
+	key, err := NewKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestNewKeyShortSecret(t *testing.T) {
+	if _, err := NewKey(make([]byte, minSecret-1)); err == nil {
+		t.Error("NewKey accepted a short secret")
+	}
+}
+
+func TestKeySealOpen(t *testing.T) {
+	key := testKey(t, 1)
+	for _, data := range [][]byte{nil, []byte("chunk"), randomData(100_000, 6)} {
+		sealed := key.Seal(data)
+		if len(sealed) != len(data)+key.Overhead() {
+			t.Errorf("sealed %d bytes to %d, want overhead %d", len(data), len(sealed), key.Overhead())
+		}
+		if len(data) > 0 && bytes.Contains(sealed, data) {
+			t.Error("sealed data holds the plaintext")
+		}
+		if again := key.Seal(data); bytes.Equal(again, sealed) {
+			t.Error("sealing twice gave the same output, nonces are reused")
+		}
+		opened, err := key.Open(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, data) {
+			t.Errorf("opened %d bytes differing from the %d sealed", len(opened), len(data))
+		}
+	}
+}
+
+func TestKeyOpenRejects(t *testing.T) {
+	key := testKey(t, 1)
+	sealed := key.Seal([]byte("chunk content"))
+
+	flip := func(i int) []byte {
+		tampered := bytes.Clone(sealed)
+		tampered[i] ^= 0x80
+		return tampered
+	}
+	tests := []struct {
+		name   string
+		key    *Key
+		sealed []byte
+	}{
+		{name: "other key", key: testKey(t, 2), sealed: sealed},
+		{name: "nonce modified", key: key, sealed: flip(0)},
+		{name: "ciphertext modified", key: key, sealed: flip(len(sealed) / 2)},
+		{name: "tag modified", key: key, sealed: flip(len(sealed) - 1)},
+		{name: "truncated", key: key, sealed: sealed[:len(sealed)-1]},
+		{name: "shorter than overhead", key: key, sealed: sealed[:key.Overhead()-1]},
+		{name: "empty", key: key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.key.Open(tt.sealed); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("Open() = %v, want ErrAuthentication", err)
+			}
+		})
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	key := testKey(t, 1)
+	id := key.ID([]byte("chunk"))
+	if len(id) != 64 {
+		t.Errorf("ID %q is not 32 hex encoded bytes", id)
+	}
+	if key.ID([]byte("chunk")) != id {
+		t.Error("ID of the same content differs")
+	}
+	if testKey(t, 1).ID([]byte("chunk")) != id {
+		t.Error("ID differs for a key derived from the same secret")
+	}
+	if key.ID([]byte("chunk2")) == id {
+		t.Error("different content has the same ID")
+	}
+	if testKey(t, 2).ID([]byte("chunk")) == id {
+		t.Error("ID does not depend on the key")
+	}
+}
@@ -0,0 +1,151 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Pack layout: sealed blobs back to back, then the sealed JSON list of
+// those blobs, then the length of that list as a 4 byte little endian
+// integer. The trailing list lets an index be rebuilt from the packs alone.
+const packTrailerSize = 4
+
+// Blob locates one sealed chunk inside a pack
+type Blob struct {
+	ID        string `json:"id"`
+	Offset    int64  `json:"offset"`
+	Length    int64  `json:"length"`     // sealed bytes in the pack
+	RawLength int64  `json:"raw_length"` // plaintext bytes
+}
+
+// Packer collects sealed chunks into a pack
+type Packer struct {
+	key   *Key
+	buf   bytes.Buffer
+	blobs []Blob
+}
+
+// NewPacker returns an empty packer sealing chunks with key
+func NewPacker(key *Key) *Packer {
+	return &Packer{key: key}
+}
+
+// Add seals a chunk and appends it to the pack
+func (p *Packer) Add(id string, data []byte) {
+	p.AddSealed(Blob{ID: id, RawLength: int64(len(data))}, p.key.Seal(data))
+}
+
+// AddSealed appends an already sealed chunk, as read from another pack
+func (p *Packer) AddSealed(blob Blob, sealed []byte) {
+	blob.Offset = int64(p.buf.Len())
+	blob.Length = int64(len(sealed))
+	p.buf.Write(sealed)
+	p.blobs = append(p.blobs, blob)
+}
+
+// Size returns the bytes of sealed chunks added so far
+func (p *Packer) Size() int64 {
+	return int64(p.buf.Len())
+}
+
+// Count returns the number of chunks added so far
+func (p *Packer) Count() int {
+	return len(p.blobs)
+}
+
+// Finish appends the trailer and returns the pack ID, its content and the
+// blobs it holds. The ID is the SHA-256 of the content. The packer is
+// empty afterwards.
+func (p *Packer) Finish() (string, []byte, []Blob, error) {
+	header, err := json.Marshal(p.blobs)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to encode pack header: %w", err)
+	}
+	sealed := p.key.Seal(header)
+	p.buf.Write(sealed)
+	p.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(sealed))))
+
+	data := bytes.Clone(p.buf.Bytes())
+	blobs := p.blobs
+	p.buf.Reset()
+	p.blobs = nil
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data, blobs, nil
+}
+
+// ReadHeader returns the blobs listed in a pack's trailer
+func ReadHeader(key *Key, pack []byte) ([]Blob, error) {
+	if len(pack) < packTrailerSize {
+		return nil, fmt.Errorf("pack is too short")
+	}
+	length := int64(binary.LittleEndian.Uint32(pack[len(pack)-packTrailerSize:]))
+	end := int64(len(pack)) - packTrailerSize
+	if length > end {
+		return nil, fmt.Errorf("pack header length %d exceeds pack size", length)
+	}
+	header, err := key.Open(pack[end-length : end])
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack header: %w", err)
+	}
+	var blobs []Blob
+	if err := json.Unmarshal(header, &blobs); err != nil {
+		return nil, fmt.Errorf("failed to decode pack header: %w", err)
+	}
+	return blobs, nil
+}
+
+// Index lists the packs of a repository and the chunks each holds
+type Index struct {
+	Packs []PackInfo `json:"packs"`
+}
+
+// PackInfo describes one stored pack
+type PackInfo struct {
+	ID    string `json:"id"`
+	Size  int64  `json:"size"`
+	Blobs []Blob `json:"blobs"`
+}
+
+// Location is where a chunk is stored
+type Location struct {
+	Pack string
+	Blob
+}
+
+// Locations maps every chunk ID in the index to where it is stored. A
+// chunk stored twice resolves to one of its copies.
+func (ix *Index) Locations() map[string]Location {
+	locations := make(map[string]Location)
+	for _, pack := range ix.Packs {
+		for _, blob := range pack.Blobs {
+			locations[blob.ID] = Location{Pack: pack.ID, Blob: blob}
+		}
+	}
+	return locations
+}
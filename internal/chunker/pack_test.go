@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPackerRoundTrip(t *testing.T) {
+	key := testKey(t, 1)
+	contents := [][]byte{[]byte("first chunk"), randomData(5000, 7), {}}
+
+	packer := NewPacker(key)
+	for _, data := range contents {
+		packer.Add(key.ID(data), data)
+	}
+	// A chunk copied from another pack keeps its sealed form
+	copied := []byte("copied chunk")
+	sealedCopy := key.Seal(copied)
+	packer.AddSealed(Blob{ID: key.ID(copied), RawLength: int64(len(copied))}, sealedCopy)
+	contents = append(contents, copied)
+
+	if packer.Count() != 4 {
+		t.Errorf("Count() = %d, want 4", packer.Count())
+	}
+	size := packer.Size()
+	id, pack, blobs, err := packer.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(pack); id != hex.EncodeToString(sum[:]) {
+		t.Errorf("pack ID %s is not the SHA-256 of its content", id)
+	}
+	if packer.Count() != 0 || packer.Size() != 0 {
+		t.Error("packer not empty after Finish")
+	}
+	if int64(len(pack)) <= size {
+		t.Errorf("pack of %d bytes has no trailer after %d bytes of chunks", len(pack), size)
+	}
+
+	header, err := ReadHeader(key, pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(header, blobs) {
+		t.Errorf("header = %+v, want %+v", header, blobs)
+	}
+	for i, blob := range header {
+		data, err := key.Open(pack[blob.Offset : blob.Offset+blob.Length])
+		if err != nil {
+			t.Fatalf("blob %d: %v", i, err)
+		}
+		if !bytes.Equal(data, contents[i]) || blob.ID != key.ID(data) || blob.RawLength != int64(len(data)) {
+			t.Errorf("blob %d = %+v does not describe its chunk", i, blob)
+		}
+	}
+	if last := header[3]; !bytes.Equal(pack[last.Offset:last.Offset+last.Length], sealedCopy) {
+		t.Error("copied chunk was sealed again")
+	}
+}
+
+func TestReadHeaderRejects(t *testing.T) {
+	key := testKey(t, 1)
+	packer := NewPacker(key)
+	packer.Add("id", []byte("chunk"))
+	_, pack, _, err := packer.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := bytes.Clone(pack)
+	tampered[len(tampered)-packTrailerSize-1] ^= 0x01
+	oversized := bytes.Clone(pack)
+	oversized[len(oversized)-1] = 0xff
+
+	tests := []struct {
+		name string
+		key  *Key
+		pack []byte
+	}{
+		{name: "too short", key: key, pack: pack[:packTrailerSize-1]},
+		{name: "length beyond pack", key: key, pack: oversized},
+		{name: "header modified", key: key, pack: tampered},
+		{name: "other key", key: testKey(t, 2), pack: pack},
+		{name: "cut off", key: key, pack: pack[:len(pack)-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHeader(tt.key, tt.pack); err == nil {
+				t.Error("ReadHeader succeeded")
+			}
+		})
+	}
+}
+
+func TestIndexEncoding(t *testing.T) {
+	index := Index{Packs: []PackInfo{
+		{ID: "pack1", Size: 100, Blobs: []Blob{
+			{ID: "a", Offset: 0, Length: 40, RawLength: 12},
+			{ID: "b", Offset: 40, Length: 30, RawLength: 2},
+		}},
+		{ID: "pack2", Size: 50, Blobs: []Blob{
+			{ID: "c", Offset: 0, Length: 20, RawLength: 4},
+		}},
+	}}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Index
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, index) {
+		t.Errorf("decoded index = %+v, want %+v", decoded, index)
+	}
+	if !bytes.Contains(data, []byte(`"raw_length":12`)) {
+		t.Errorf("index encoding changed: %s", data)
+	}
+
+	locations := decoded.Locations()
+	want := map[string]Location{
+		"a": {Pack: "pack1", Blob: index.Packs[0].Blobs[0]},
+		"b": {Pack: "pack1", Blob: index.Packs[0].Blobs[1]},
+		"c": {Pack: "pack2", Blob: index.Packs[1].Blobs[0]},
+	}
+	if !reflect.DeepEqual(locations, want) {
+		t.Errorf("Locations() = %+v, want %+v", locations, want)
+	}
+}
@@ -26,11 +26,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"CloudAWSync/internal/interfaces"
-
-	"gopkg.in/yaml.v3"
+	"CloudAWSync/internal/layout"
 )
 
 // AWSConfig holds AWS-specific configuration
@@ -41,26 +41,42 @@ type AWSConfig struct {
 	SessionToken    string `yaml:"session_token"`
 	S3Bucket        string `yaml:"s3_bucket"`
 	S3Prefix        string `yaml:"s3_prefix"`
-	Endpoint        string `yaml:"endpoint"` // for S3-compatible services
+	Endpoint        string `yaml:"endpoint"`      // for S3-compatible services
+	StorageClass    string `yaml:"storage_class"` // e.g. STANDARD, STANDARD_IA
 }
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
 	Level      string `yaml:"level"`       // debug, info, warn, error
-	Format     string `yaml:"format"`      // json, text
+	Format     string `yaml:"format"`      // json, text, journald
 	OutputPath string `yaml:"output_path"` // file path or stdout
 	MaxSize    int    `yaml:"max_size"`    // MB
 	MaxAge     int    `yaml:"max_age"`     // days
 	MaxBackups int    `yaml:"max_backups"`
 	Compress   bool   `yaml:"compress"`
+
+	SummaryInterval time.Duration   `yaml:"summary_interval"` // log a summary of recent transfers, 0 = disabled
+	Dedupe          LogDedupeConfig `yaml:"dedupe"`
+}
+
+// LogDedupeConfig holds settings for collapsing repeated identical warnings
+// and errors, such as every retry failing the same way while S3 is down
+type LogDedupeConfig struct {
+	Every  int           `yaml:"every"`  // after the first, log only every Nth repeat, 0 = disabled
+	Window time.Duration `yaml:"window"` // a message not repeated for this long starts over
 }
 
 // MetricsConfig holds metrics configuration
 type MetricsConfig struct {
-	Enabled         bool          `yaml:"enabled"`
-	Port            int           `yaml:"port"`
-	Path            string        `yaml:"path"`
-	CollectInterval time.Duration `yaml:"collect_interval"`
+	Enabled         bool              `yaml:"enabled"`
+	Port            int               `yaml:"port"`
+	Path            string            `yaml:"path"`
+	CollectInterval time.Duration     `yaml:"collect_interval"`
+	Namespace       string            `yaml:"namespace"`      // metric name prefix, empty for none
+	ConstLabels     map[string]string `yaml:"const_labels"`   // labels added to every series, e.g. agent_id
+	HostnameLabel   bool              `yaml:"hostname_label"` // add a hostname label to every series
+	PerDirectory    bool              `yaml:"per_directory"`  // export storage, quota and cost series per directory
+	Pprof           bool              `yaml:"pprof"`          // serve /debug/pprof/ to localhost on the metrics server
 }
 
 // SecurityConfig holds security configuration
@@ -70,29 +86,278 @@ type SecurityConfig struct {
 	MaxFileSize       int64    `yaml:"max_file_size"` // bytes
 	AllowedExtensions []string `yaml:"allowed_extensions"`
 	DeniedExtensions  []string `yaml:"denied_extensions"`
+
+	// Remote key obfuscation
+	ObfuscateNames bool   `yaml:"obfuscate_names"` // encrypt file and directory names in remote keys
+	NameKeyFile    string `yaml:"name_key_file"`   // secret names are encrypted with, at least 32 bytes
+	NameMapPath    string `yaml:"name_map_path"`   // local record of encrypted names relative to state_dir, "" disables
+
+	RunAs string `yaml:"run_as"` // "user" or "user:group" to switch to after starting as root
 }
 
 // PerformanceConfig holds performance tuning configuration
 type PerformanceConfig struct {
 	MaxConcurrentUploads   int           `yaml:"max_concurrent_uploads"`
 	MaxConcurrentDownloads int           `yaml:"max_concurrent_downloads"`
-	UploadChunkSize        int64         `yaml:"upload_chunk_size"`
-	DownloadChunkSize      int64         `yaml:"download_chunk_size"`
+	UploadChunkSize        int64         `yaml:"upload_chunk_size"`    // part size of multipart uploads
+	MultipartThreshold     int64         `yaml:"multipart_threshold"`  // upload files this large in parts, 0 disables
+	DownloadChunkSize      int64         `yaml:"download_chunk_size"`  // range size for parallel downloads, 0 disables them
+	DownloadParallelism    int           `yaml:"download_parallelism"` // ranges fetched at once per download
 	RetryAttempts          int           `yaml:"retry_attempts"`
 	RetryDelay             time.Duration `yaml:"retry_delay"`
-	TimeoutDuration        time.Duration `yaml:"timeout_duration"`
-	BandwidthLimit         int64         `yaml:"bandwidth_limit"` // bytes per second
+	TimeoutDuration        time.Duration `yaml:"timeout_duration"`         // per provider operation
+	TransferTimeoutPerMB   time.Duration `yaml:"transfer_timeout_per_mb"`  // extra transfer allowance per MB
+	BandwidthLimit         int64         `yaml:"bandwidth_limit"`          // bytes per second
+	MinTransferSpeed       int64         `yaml:"min_transfer_speed"`       // bytes per second, 0 disables
+	StallTimeout           time.Duration `yaml:"stall_timeout"`            // time below min speed before abort
+	AdaptiveConcurrency    bool          `yaml:"adaptive_concurrency"`     // scale workers up to the maximums (AIMD)
+	MinConcurrentTransfers int           `yaml:"min_concurrent_transfers"` // adaptive lower bound per direction
+	ScanRateLimit          int           `yaml:"scan_rate_limit"`          // files per second, 0 = unlimited
+	ScanParallelism        int           `yaml:"scan_parallelism"`         // directories read concurrently
+	DirectoryParallelism   int           `yaml:"directory_parallelism"`    // sync directories run at once by multi-directory syncs
+	MemoryLimit            int64         `yaml:"memory_limit"`             // resident memory budget in bytes, 0 = unlimited
+	CPULimit               float64       `yaml:"cpu_limit"`                // CPU budget in percent of one core, 0 = unlimited
+	Nice                   int           `yaml:"nice"`                     // CPU nice level, 0 = unchanged
+	IOClass                string        `yaml:"io_class"`                 // realtime, best-effort or idle, empty = unchanged
+	IOPriority             int           `yaml:"io_priority"`              // 0 (highest) to 7 within the I/O class
+	ReadRateLimit          int64         `yaml:"read_rate_limit"`          // local read bytes per second per upload, 0 = unlimited
+}
+
+// NetworkConfig tunes the HTTP client used to reach the storage service
+type NetworkConfig struct {
+	MaxIdleConns        int           `yaml:"max_idle_conns"`          // idle connections kept across all hosts
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"` // idle connections kept per host
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"`      // 0 = unlimited
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+	TLSHandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+	HTTP2               bool          `yaml:"http2"`
+	CABundle            string        `yaml:"ca_bundle"` // PEM file trusted in addition to the system roots
+	Proxy               string        `yaml:"proxy"`     // http, https or socks5 URL; overrides HTTP(S)_PROXY
+	NoProxy             []string      `yaml:"no_proxy"`  // hosts reached directly, added to NO_PROXY
+
+	// How often the storage service is probed while unreachable
+	ConnectivityCheckInterval time.Duration `yaml:"connectivity_check_interval"`
+
+	// Local and remote modification times closer than this, after
+	// correcting for the measured clock skew, are compared by content
+	ClockSkewTolerance time.Duration `yaml:"clock_skew_tolerance"`
+
+	// Longest delay between requests to a bucket that keeps throttling
+	// them with SlowDown responses; 0 disables request pacing
+	MaxRequestDelay time.Duration `yaml:"max_request_delay"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig holds configuration for the circuit breaker kept
+// for each storage backend
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"` // consecutive failed requests before opening
+	Cooldown         time.Duration `yaml:"cooldown"`          // how long requests fail fast once open
+}
+
+// QuotaConfig holds global remote storage quota configuration
+type QuotaConfig struct {
+	MaxBytes      int64         `yaml:"max_bytes"`      // 0 = unlimited
+	MaxObjects    int64         `yaml:"max_objects"`    // 0 = unlimited
+	CheckInterval time.Duration `yaml:"check_interval"` // how often to recount remote usage
+}
+
+// CostConfig holds cost estimation and budget configuration. Zero prices
+// fall back to S3 standard pricing for the configured storage class.
+type CostConfig struct {
+	Enabled           bool    `yaml:"enabled"`
+	PutPer1000        float64 `yaml:"put_per_1000"`
+	GetPer1000        float64 `yaml:"get_per_1000"`
+	ListPer1000       float64 `yaml:"list_per_1000"`
+	StoragePerGBMonth float64 `yaml:"storage_per_gb_month"`
+	EgressPerGB       float64 `yaml:"egress_per_gb"`
+	MonthlyBudget     float64 `yaml:"monthly_budget"` // USD, 0 = no budget
+	BudgetAction      string  `yaml:"budget_action"`  // warn, pause
+}
+
+// StateConfig holds configuration for the persistent state database
+type StateConfig struct {
+	Path             string        `yaml:"path"`              // relative to state_dir, empty disables persistent state
+	QuarantineAfter  int           `yaml:"quarantine_after"`  // consecutive upload failures, 0 disables quarantine
+	QuarantineExpiry time.Duration `yaml:"quarantine_expiry"` // 0 keeps files quarantined until cleared
+	JournalPath      string        `yaml:"journal_path"`      // file event journal relative to state_dir, empty disables it
+	BacklogPath      string        `yaml:"backlog_path"`      // file events waiting for a full upload queue spill here, relative to state_dir, empty keeps them in memory
+	BacklogMemory    int           `yaml:"backlog_memory"`    // waiting file events held in memory before spilling to backlog_path
+}
+
+// ReplicationConfig holds configuration for mirroring every upload to
+// additional buckets
+type ReplicationConfig struct {
+	Mirrors        []MirrorConfig `yaml:"mirrors"`
+	RepairInterval time.Duration  `yaml:"repair_interval"` // how often missed copies are retried, 0 disables
+}
+
+// MirrorConfig describes a bucket receiving a copy of every upload. Mirrors
+// use the same settings as the primary bucket, e.g. a Backblaze B2 or
+// MinIO endpoint.
+type MirrorConfig struct {
+	Name      string `yaml:"name"` // identifies the mirror in logs and the state database
+	AWSConfig `yaml:",inline"`
+}
+
+// FailoverConfig holds configuration for switching to a secondary bucket
+// while the primary one keeps failing
+type FailoverConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Secondary     AWSConfig     `yaml:"secondary"`      // bucket used while the primary is failing
+	FailoverAfter int           `yaml:"failover_after"` // consecutive failed primary requests before switching
+	CheckInterval time.Duration `yaml:"check_interval"` // how often the primary is probed while failed over
+}
+
+// ManifestConfig holds configuration for the remote manifest of each
+// directory
+type ManifestConfig struct {
+	Enabled bool `yaml:"enabled"` // write a manifest after each directory sync
+}
+
+// KeysConfig holds configuration for remote object keys
+type KeysConfig struct {
+	MaxBytes int    `yaml:"max_bytes"` // longest key including s3_prefix
+	LongKeys string `yaml:"long_keys"` // hash, truncate or fail for longer keys
+}
+
+// AuditConfig holds audit log configuration
+type AuditConfig struct {
+	Path string `yaml:"path"` // JSON lines file relative to state_dir, empty disables auditing
+}
+
+// ScrubConfig holds remote integrity scrub configuration
+type ScrubConfig struct {
+	Interval   time.Duration `yaml:"interval"`    // 0 disables scheduled scrubs
+	SampleSize int           `yaml:"sample_size"` // objects downloaded and re-hashed per scrub
+}
+
+// RescanConfig holds configuration for periodic full scans of realtime
+// directories, which pick up changes whose file events were missed
+type RescanConfig struct {
+	Interval time.Duration `yaml:"interval"` // 0 disables periodic rescans
+}
+
+// AntivirusConfig holds configuration for scanning transferred files with
+// ClamAV
+type AntivirusConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	ClamdAddress  string        `yaml:"clamd_address"`  // unix socket path, unix://path or tcp://host:port
+	ScanUploads   bool          `yaml:"scan_uploads"`   // scan files before they are uploaded
+	ScanDownloads bool          `yaml:"scan_downloads"` // scan files after they are downloaded
+	QuarantineDir string        `yaml:"quarantine_dir"` // infected files are moved here, relative to state_dir
+	MaxScanSize   int64         `yaml:"max_scan_size"`  // bytes, larger files are not scanned, 0 = no limit
+	Timeout       time.Duration `yaml:"timeout"`        // per scan
+}
+
+// ControlConfig holds configuration for the local control API
+type ControlConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Socket     string `yaml:"socket"`      // unix socket path
+	GRPCSocket string `yaml:"grpc_socket"` // unix socket for the gRPC API, empty disables it
+}
+
+// DashboardConfig holds configuration for the embedded web dashboard
+type DashboardConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Listen   string        `yaml:"listen"`   // host:port, separate from the metrics server
+	Username string        `yaml:"username"` // HTTP basic auth user
+	Password string        `yaml:"password"` // HTTP basic auth password, required when enabled
+	History  time.Duration `yaml:"history"`  // how long transfer history is kept for graphs
+	TLSCert  string        `yaml:"tls_cert"` // PEM certificate served over HTTPS, required off loopback
+	TLSKey   string        `yaml:"tls_key"`  // PEM private key of tls_cert
+}
+
+// HydrationConfig holds configuration for on-demand download of archived files
+type HydrationConfig struct {
+	CacheSize int64 `yaml:"cache_size"` // bytes of hydrated files kept locally, 0 = unlimited
+}
+
+// GlacierRestoreConfig holds configuration for reading objects that moved
+// to archival storage classes
+type GlacierRestoreConfig struct {
+	Enabled       bool          `yaml:"enabled"`        // request restores of archived objects that are read
+	Days          int           `yaml:"days"`           // how long restored copies stay readable
+	Tier          string        `yaml:"tier"`           // Expedited, Standard or Bulk
+	CheckInterval time.Duration `yaml:"check_interval"` // how often requested restores are checked
+}
+
+// ChunkingConfig holds configuration for backup directories using the
+// chunked format
+type ChunkingConfig struct {
+	KeyFile  string `yaml:"key_file"`  // secret chunks are encrypted with, at least 32 bytes
+	MinSize  int    `yaml:"min_size"`  // bytes
+	AvgSize  int    `yaml:"avg_size"`  // bytes, rounded down to a power of two
+	MaxSize  int    `yaml:"max_size"`  // bytes
+	PackSize int64  `yaml:"pack_size"` // bytes of chunks collected into one uploaded pack
+}
+
+// MountConfig holds configuration for FUSE mounts of remote prefixes
+type MountConfig struct {
+	CacheDir  string        `yaml:"cache_dir"`  // downloaded and written file content
+	CacheSize int64         `yaml:"cache_size"` // bytes, 0 = unlimited
+	ListTTL   time.Duration `yaml:"list_ttl"`   // how long remote listings are reused
+}
+
+// RemoteConfig holds settings for fetching configuration published
+// centrally, such as one document for a fleet of agents
+type RemoteConfig struct {
+	URL             string        `yaml:"url"`              // s3://bucket/key or https://host/path
+	PublicKey       string        `yaml:"public_key"`       // PEM file of the Ed25519 key the document is signed with
+	DirectoriesOnly bool          `yaml:"directories_only"` // take only the directory list from the document
+	Interval        time.Duration `yaml:"interval"`         // check for a new document, 0 = at startup only
+	CachePath       string        `yaml:"cache_path"`       // last verified document, used when the source cannot be reached
 }
 
 // Config represents the main configuration structure
 type Config struct {
-	AWS         AWSConfig                  `yaml:"aws"`
-	Logging     LoggingConfig              `yaml:"logging"`
-	Metrics     MetricsConfig              `yaml:"metrics"`
-	Security    SecurityConfig             `yaml:"security"`
-	Performance PerformanceConfig          `yaml:"performance"`
-	Directories []interfaces.SyncDirectory `yaml:"directories"`
-	SystemD     SystemDConfig              `yaml:"systemd"`
+	AWS          AWSConfig                  `yaml:"aws"`
+	Logging      LoggingConfig              `yaml:"logging"`
+	Metrics      MetricsConfig              `yaml:"metrics"`
+	Security     SecurityConfig             `yaml:"security"`
+	Performance  PerformanceConfig          `yaml:"performance"`
+	Network      NetworkConfig              `yaml:"network"`
+	Quota        QuotaConfig                `yaml:"quota"`
+	Cost         CostConfig                 `yaml:"cost"`
+	StateDir     string                     `yaml:"state_dir"` // default: DefaultStateDir
+	State        StateConfig                `yaml:"state"`
+	Replication  ReplicationConfig          `yaml:"replication"`
+	Failover     FailoverConfig             `yaml:"failover"`
+	Manifest     ManifestConfig             `yaml:"manifest"`
+	Keys         KeysConfig                 `yaml:"keys"`
+	Scrub        ScrubConfig                `yaml:"scrub"`
+	Rescan       RescanConfig               `yaml:"rescan"`
+	Antivirus    AntivirusConfig            `yaml:"antivirus"`
+	Audit        AuditConfig                `yaml:"audit"`
+	Control      ControlConfig              `yaml:"control"`
+	Dashboard    DashboardConfig            `yaml:"dashboard"`
+	Hydration    HydrationConfig            `yaml:"hydration"`
+	Glacier      GlacierRestoreConfig       `yaml:"glacier_restore"`
+	Chunking     ChunkingConfig             `yaml:"chunking"`
+	Mount        MountConfig                `yaml:"mount"`
+	Directories  []interfaces.SyncDirectory `yaml:"directories"`
+	Profiles     []Profile                  `yaml:"profiles"`     // tenants run by the same daemon
+	Environment  string                     `yaml:"environment"`  // environment applied by default
+	Environments map[string]Environment     `yaml:"environments"` // named overrides selected with -environment
+	RemoteConfig RemoteConfig               `yaml:"remote_config"`
+	SystemD      SystemDConfig              `yaml:"systemd"`
+
+	// Profile is the name of the profile this configuration was derived
+	// for, empty at the top level
+	Profile string `yaml:"-"`
+
+	// RemoteDocument is the remote configuration merged into this one
+	RemoteDocument []byte `yaml:"-"`
+
+	// RemoteFetched identifies the remote document and signature last
+	// fetched, whether they were accepted or not
+	RemoteFetched RemoteDigest `yaml:"-"`
+
+	// Warnings lists problems found while loading that did not prevent
+	// it, for the caller to log
+	Warnings []string `yaml:"-"`
 }
 
 // SystemDConfig holds systemd-specific configuration
@@ -120,28 +385,126 @@ func DefaultConfig() *Config {
 			MaxAge:     30,
 			MaxBackups: 10,
 			Compress:   true,
+			Dedupe: LogDedupeConfig{
+				Window: 5 * time.Minute,
+			},
 		},
 		Metrics: MetricsConfig{
 			Enabled:         true,
 			Port:            9090,
 			Path:            "/metrics",
 			CollectInterval: 30 * time.Second,
+			Namespace:       "cloudawsync",
+			PerDirectory:    true,
 		},
 		Security: SecurityConfig{
 			EncryptionEnabled: true,
 			MaxFileSize:       100 * 1024 * 1024, // 100MB
 			AllowedExtensions: []string{},
 			DeniedExtensions:  []string{".tmp", ".lock"},
+			NameMapPath:       "names.jsonl",
 		},
 		Performance: PerformanceConfig{
 			MaxConcurrentUploads:   5,
 			MaxConcurrentDownloads: 5,
 			UploadChunkSize:        5 * 1024 * 1024, // 5MB
 			DownloadChunkSize:      5 * 1024 * 1024, // 5MB
+			DownloadParallelism:    4,
 			RetryAttempts:          3,
 			RetryDelay:             5 * time.Second,
 			TimeoutDuration:        30 * time.Second,
-			BandwidthLimit:         0, // unlimited
+			TransferTimeoutPerMB:   10 * time.Second, // tolerates ~100KB/s links
+			BandwidthLimit:         0,                // unlimited
+			MinTransferSpeed:       1024,             // 1KB/s
+			StallTimeout:           60 * time.Second,
+			MinConcurrentTransfers: 1,
+			ScanParallelism:        1,
+			DirectoryParallelism:   4,
+		},
+		Network: NetworkConfig{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+			HTTP2:               true,
+
+			ConnectivityCheckInterval: 30 * time.Second,
+			ClockSkewTolerance:        5 * time.Second,
+			MaxRequestDelay:           5 * time.Second,
+
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: 5,
+				Cooldown:         30 * time.Second,
+			},
+		},
+		Quota: QuotaConfig{
+			CheckInterval: time.Hour,
+		},
+		Cost: CostConfig{
+			BudgetAction: "warn",
+		},
+		State: StateConfig{
+			Path:             "state.json",
+			QuarantineAfter:  5,
+			QuarantineExpiry: 24 * time.Hour,
+			BacklogPath:      "event-backlog.jsonl",
+			BacklogMemory:    10000,
+		},
+		Replication: ReplicationConfig{
+			RepairInterval: 15 * time.Minute,
+		},
+		Failover: FailoverConfig{
+			FailoverAfter: 5,
+			CheckInterval: 30 * time.Second,
+		},
+		Keys: KeysConfig{
+			MaxBytes: 1024,
+			LongKeys: "hash",
+		},
+		Scrub: ScrubConfig{
+			SampleSize: 10,
+		},
+		Rescan: RescanConfig{
+			Interval: 24 * time.Hour,
+		},
+		Antivirus: AntivirusConfig{
+			ClamdAddress:  "/run/clamav/clamd.ctl",
+			ScanUploads:   true,
+			ScanDownloads: true,
+			QuarantineDir: "infected",
+			MaxScanSize:   25 * 1024 * 1024,
+			Timeout:       time.Minute,
+		},
+		Audit: AuditConfig{
+			Path: "audit.log",
+		},
+		Control: ControlConfig{
+			Socket:     "/run/cloudawsync/control.sock",
+			GRPCSocket: "/run/cloudawsync/control-grpc.sock",
+		},
+		Dashboard: DashboardConfig{
+			Listen:   "127.0.0.1:9091",
+			Username: "admin",
+			History:  time.Hour,
+		},
+		Glacier: GlacierRestoreConfig{
+			Days:          7,
+			Tier:          "Standard",
+			CheckInterval: 15 * time.Minute,
+		},
+		Chunking: ChunkingConfig{
+			MinSize:  512 * 1024,      // 512KB
+			AvgSize:  1024 * 1024,     // 1MB
+			MaxSize:  8 * 1024 * 1024, // 8MB
+			PackSize: 16 * 1024 * 1024,
+		},
+		Mount: MountConfig{
+			CacheDir:  "/var/cache/cloudawsync/mount",
+			CacheSize: 1024 * 1024 * 1024, // 1GB
+			ListTTL:   30 * time.Second,
+		},
+		RemoteConfig: RemoteConfig{
+			CachePath: "remote-config",
 		},
 		SystemD: SystemDConfig{
 			ServiceName:   "cloudawsync",
@@ -154,8 +517,15 @@ func DefaultConfig() *Config {
 	}
 }
 
-// LoadConfig loads configuration from file
+// LoadConfig loads configuration from a YAML, JSON or TOML file. A remote
+// configuration is taken from its last verified copy.
 func LoadConfig(configPath string) (*Config, error) {
+	return LoadConfigFrom(context.Background(), configPath, nil)
+}
+
+// LoadConfigFrom loads configuration like LoadConfig, fetching the remote
+// configuration with fetch
+func LoadConfigFrom(ctx context.Context, configPath string, fetch RemoteFetcher) (*Config, error) {
 	config := DefaultConfig()
 
 	if configPath == "" {
@@ -166,6 +536,7 @@ func LoadConfig(configPath string) (*Config, error) {
 		// Return default config with warning if no config file exists
 		fmt.Printf("Warning: No configuration file found at %s, using defaults\n", configPath)
 		fmt.Println("Run 'cloudawsync -generate-config' to create a sample configuration")
+		config.resolvePaths()
 		return config, nil
 	}
 
@@ -174,18 +545,105 @@ func LoadConfig(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	if err := yaml.Unmarshal(data, config); err != nil {
+	if err := decodeConfig(data, DetectFormat(configPath, data), config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	if err := config.loadDropIns(configPath); err != nil {
+		return nil, err
+	}
+	if err := config.loadRemote(ctx, fetch); err != nil {
+		return nil, err
+	}
 
+	if err := config.applyEnvironment(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.expandRemotePaths(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	config.resolvePaths()
 
 	return config, nil
 }
 
-// SaveConfig saves configuration to file
+// DefaultStateDir returns the state directory used when state_dir is not
+// set: the directory systemd assigns with StateDirectory=, otherwise
+// $XDG_STATE_HOME/cloudawsync, ~/.local/state/cloudawsync for users other
+// than root and /var/lib/cloudawsync for root
+func DefaultStateDir() string {
+	if dirs := os.Getenv("STATE_DIRECTORY"); dirs != "" {
+		// systemd separates several state directories with colons
+		dir, _, _ := strings.Cut(dirs, ":")
+		return dir
+	}
+	if xdgDir := os.Getenv("XDG_STATE_HOME"); xdgDir != "" {
+		return filepath.Join(xdgDir, "cloudawsync")
+	}
+	if homeDir := os.Getenv("HOME"); homeDir != "" && os.Geteuid() != 0 {
+		return filepath.Join(homeDir, ".local", "state", "cloudawsync")
+	}
+	return "/var/lib/cloudawsync"
+}
+
+// resolvePaths sets the state directory when it is not configured and
+// resolves relative state, journal, backlog, audit, name map, quarantine
+// and remote configuration cache paths against it
+func (c *Config) resolvePaths() {
+	if c.StateDir == "" {
+		c.StateDir = DefaultStateDir()
+	}
+	for _, path := range []*string{&c.State.Path, &c.State.JournalPath, &c.State.BacklogPath, &c.Audit.Path, &c.Security.NameMapPath, &c.Antivirus.QuarantineDir, &c.RemoteConfig.CachePath} {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(c.StateDir, *path)
+		}
+	}
+}
+
+// expandRemotePaths replaces the {hostname} and {dir_id} placeholders in
+// the remote paths of all directories, including those of profiles
+func (c *Config) expandRemotePaths() error {
+	var hostname string
+	expand := func(field string, dir *interfaces.SyncDirectory) error {
+		if !strings.Contains(dir.RemotePath, "{") {
+			return nil
+		}
+		if hostname == "" {
+			var err error
+			if hostname, err = os.Hostname(); err != nil {
+				return fmt.Errorf("failed to get hostname for %s.remote_path: %w", field, err)
+			}
+		}
+		expanded, err := layout.Expand(dir.RemotePath, map[string]string{
+			"hostname": hostname,
+			"dir_id":   layout.DirectoryID(dir.Name, dir.LocalPath),
+		})
+		if err != nil {
+			return fmt.Errorf("%s.remote_path: %w", field, err)
+		}
+		dir.RemotePath = expanded
+		return nil
+	}
+
+	for i := range c.Directories {
+		if err := expand(fmt.Sprintf("directories[%d]", i), &c.Directories[i]); err != nil {
+			return err
+		}
+	}
+	for i := range c.Profiles {
+		for j := range c.Profiles[i].Directories {
+			if err := expand(fmt.Sprintf("profiles[%d].directories[%d]", i, j), &c.Profiles[i].Directories[j]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SaveConfig saves configuration to file, using the format implied by
+// the file extension (YAML unless .json or .toml)
 func (c *Config) SaveConfig(configPath string) error {
 	if configPath == "" {
 		configPath = getDefaultConfigPath()
@@ -197,7 +655,7 @@ func (c *Config) SaveConfig(configPath string) error {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	data, err := yaml.Marshal(c)
+	data, err := encodeConfig(c, DetectFormat(configPath, nil))
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -209,60 +667,12 @@ func (c *Config) SaveConfig(configPath string) error {
 	return nil
 }
 
-// Validate validates the configuration
+// Validate validates the configuration, returning ValidationErrors
+// describing every problem found
 func (c *Config) Validate() error {
-	// AWS validation
-	if c.AWS.Region == "" {
-		return fmt.Errorf("AWS region is required")
-	}
-	if c.AWS.S3Bucket == "" {
-		return fmt.Errorf("AWS S3 bucket is required")
-	}
-	if c.AWS.AccessKeyID == "" {
-		return fmt.Errorf("AWS access key ID is required")
-	}
-	if c.AWS.SecretAccessKey == "" {
-		return fmt.Errorf("AWS secret access key is required")
+	if problems := c.collectProblems(); len(problems) > 0 {
+		return problems
 	}
-
-	// Directories validation
-	if len(c.Directories) == 0 {
-		return fmt.Errorf("at least one directory must be configured for synchronization")
-	}
-
-	for i, dir := range c.Directories {
-		if dir.LocalPath == "" {
-			return fmt.Errorf("directory %d: local path is required", i)
-		}
-		if dir.RemotePath == "" {
-			return fmt.Errorf("directory %d: remote path is required", i)
-		}
-		if dir.SyncMode == "" {
-			return fmt.Errorf("directory %d: sync mode is required", i)
-		}
-
-		// Validate sync mode
-		switch dir.SyncMode {
-		case "realtime", "scheduled", "both":
-			// Valid modes
-		default:
-			return fmt.Errorf("directory %d: invalid sync mode '%s' (must be 'realtime', 'scheduled', or 'both')", i, dir.SyncMode)
-		}
-
-		// Check if local path exists
-		if _, err := os.Stat(dir.LocalPath); os.IsNotExist(err) {
-			return fmt.Errorf("directory %d: local path '%s' does not exist", i, dir.LocalPath)
-		}
-	}
-
-	// Performance validation
-	if c.Performance.MaxConcurrentUploads <= 0 {
-		return fmt.Errorf("max concurrent uploads must be greater than 0")
-	}
-	if c.Performance.MaxConcurrentDownloads <= 0 {
-		return fmt.Errorf("max concurrent downloads must be greater than 0")
-	}
-
 	return nil
 }
 
@@ -271,14 +681,32 @@ func NewConfig(ctx context.Context) *Config {
 	return DefaultConfig()
 }
 
+// DefaultConfigPath returns the configuration path used when none is given
+func DefaultConfigPath() string {
+	return getDefaultConfigPath()
+}
+
 func getDefaultConfigPath() string {
-	if configDir := os.Getenv("XDG_CONFIG_HOME"); configDir != "" {
-		return filepath.Join(configDir, "cloudawsync", "config.yaml")
+	var configDir string
+	if xdgDir := os.Getenv("XDG_CONFIG_HOME"); xdgDir != "" {
+		configDir = filepath.Join(xdgDir, "cloudawsync")
+	} else if homeDir := os.Getenv("HOME"); homeDir != "" {
+		configDir = filepath.Join(homeDir, ".config", "cloudawsync")
+	} else {
+		configDir = "/etc/cloudawsync"
 	}
 
-	if homeDir := os.Getenv("HOME"); homeDir != "" {
-		return filepath.Join(homeDir, ".config", "cloudawsync", "config.yaml")
-	}
+	return findConfigFile(configDir)
+}
 
-	return "/etc/cloudawsync/config.yaml"
+// findConfigFile returns the first config file present in dir, preferring
+// YAML, then JSON, then TOML; config.yaml is returned if none exist
+func findConfigFile(dir string) string {
+	for _, name := range []string{"config.yaml", "config.yml", "config.json", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, "config.yaml")
 }
@@ -0,0 +1,67 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import "testing"
+
+func TestValidateCronExpression(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "0 2 * * *"},
+		{expr: "  */15 * * * *  "},
+		{expr: "0 0-6/2 1,15 * mon-fri"},
+		{expr: "30 4 * JAN-mar sun"},
+		{expr: "0 0 * * 7"},
+		{expr: "@daily"},
+		{expr: "@Hourly"},
+		{expr: "* * * *", wantErr: "expected 5 fields, got 4"},
+		{expr: "* * * * * *", wantErr: "expected 5 fields, got 6"},
+		{expr: "", wantErr: "expected 5 fields, got 0"},
+		{expr: "@reboot", wantErr: "expected 5 fields, got 1"},
+		{expr: "60 * * * *", wantErr: "minute field '60': value 60 out of range 0-59"},
+		{expr: "* 24 * * *", wantErr: "hour field '24': value 24 out of range 0-23"},
+		{expr: "* * 0 * *", wantErr: "day of month field '0': value 0 out of range 1-31"},
+		{expr: "* * * 13 *", wantErr: "month field '13': value 13 out of range 1-12"},
+		{expr: "* * * * 8", wantErr: "day of week field '8': value 8 out of range 0-7"},
+		{expr: "* * * foo *", wantErr: "month field 'foo': invalid value 'foo'"},
+		{expr: "* * * * mon-sun", wantErr: "day of week field 'mon-sun': range start 1 is after end 0"},
+		{expr: "*/0 * * * *", wantErr: "minute field '*/0': invalid step '0'"},
+		{expr: "*/x * * * *", wantErr: "minute field '*/x': invalid step 'x'"},
+		{expr: "1,,2 * * * *", wantErr: "minute field '1,,2': empty list element"},
+		{expr: "1- * * * *", wantErr: "minute field '1-': invalid value ''"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			err := ValidateCronExpression(tt.expr)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ValidateCronExpression(%q) = %v, want nil", tt.expr, err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("ValidateCronExpression(%q) = nil, want %q", tt.expr, tt.wantErr)
+			case tt.wantErr != "" && err.Error() != tt.wantErr:
+				t.Fatalf("ValidateCronExpression(%q) = %q, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
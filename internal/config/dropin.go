@@ -0,0 +1,222 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dropInDirName is the directory beside the configuration file whose files
+// are merged into it, such as /etc/cloudawsync/conf.d
+const dropInDirName = "conf.d"
+
+// dropInListPattern matches a field within a directory or profile
+var dropInListPattern = regexp.MustCompile(`^(directories|profiles)\[(\d+)\](.*)$`)
+
+// dropIn is a drop-in file merged into the configuration
+type dropIn struct {
+	path         string
+	lines        map[string]int // line of every key, empty for TOML
+	firstDir     int            // index of its first directory in the merged configuration
+	dirs         int
+	firstProfile int
+	profiles     int
+}
+
+// dropInDir returns the drop-in directory of a configuration file
+func dropInDir(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), dropInDirName)
+}
+
+// dropInFiles returns the YAML, JSON and TOML files of the drop-in
+// directory in the order they are merged, sorted by name. A missing
+// directory has no drop-ins.
+func dropInFiles(configPath string) ([]string, error) {
+	dir := dropInDir(configPath)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drop-in directory: %w", err)
+	}
+
+	// ReadDir sorts by name, so merging is deterministic
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json", ".toml":
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// loadDropIns merges every drop-in file of configPath into c
+func (c *Config) loadDropIns(configPath string) error {
+	paths, err := dropInFiles(configPath)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read drop-in file: %w", err)
+		}
+		if err := c.mergeDropIn(data, DetectFormat(path, data)); err != nil {
+			return fmt.Errorf("failed to parse drop-in file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// mergeDropIn merges a drop-in file of the given format into c. Its
+// directories and profiles are appended to those already configured;
+// every other key replaces the value set before, so nested sections are
+// merged key by key.
+func (c *Config) mergeDropIn(data []byte, format Format) error {
+	data, err := toYAML(data, format)
+	if err != nil {
+		return err
+	}
+
+	// Decoding into an empty configuration checks the keys and collects
+	// the lists with line numbers of the drop-in itself
+	var lists Config
+	if err := decodeYAMLStrict(data, &lists); err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return err
+	}
+	delete(settings, "directories")
+	delete(settings, "profiles")
+	if len(settings) > 0 {
+		out, err := yaml.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("failed to encode drop-in settings: %w", err)
+		}
+		if err := decodeYAMLStrict(out, c); err != nil {
+			return err
+		}
+	}
+
+	c.Directories = append(c.Directories, lists.Directories...)
+	c.Profiles = append(c.Profiles, lists.Profiles...)
+	return nil
+}
+
+// validateDropIns merges every drop-in file of configPath into c like
+// loadDropIns, returning where each came from and the problems decoding
+// them. The returned error is only set if a file could not be read.
+func (c *Config) validateDropIns(configPath string) ([]dropIn, ValidationErrors, error) {
+	paths, err := dropInFiles(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dropIns []dropIn
+	var problems ValidationErrors
+	for _, path := range paths {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read drop-in file: %w", err)
+		}
+		format := DetectFormat(path, raw)
+		data, err := toYAML(raw, format)
+		if err != nil {
+			problems = append(problems, ValidationError{File: path, Message: err.Error()})
+			continue
+		}
+
+		d := dropIn{path: path, lines: make(map[string]int), firstDir: len(c.Directories), firstProfile: len(c.Profiles)}
+		var root yaml.Node
+		if err := yaml.Unmarshal(data, &root); err == nil {
+			collectNodeLines(&root, "", d.lines)
+		}
+		// TOML is converted to YAML, so its keys are known but not their lines
+		if format == FormatTOML {
+			for key := range d.lines {
+				d.lines[key] = 0
+			}
+		}
+
+		if err := c.mergeDropIn(data, FormatYAML); err != nil {
+			for _, problem := range yamlProblems(err) {
+				problem.File = path
+				if format == FormatTOML {
+					problem.Line = 0
+				}
+				problems = append(problems, problem)
+			}
+			continue
+		}
+		d.dirs = len(c.Directories) - d.firstDir
+		d.profiles = len(c.Profiles) - d.firstProfile
+		dropIns = append(dropIns, d)
+	}
+	return dropIns, problems, nil
+}
+
+// locate attributes a problem found in the merged configuration to the
+// drop-in file that configured the field, rewriting directory and profile
+// indexes to those within the file. Problems with other fields go to the
+// last drop-in setting the field itself.
+func locate(problem ValidationError, dropIns []dropIn) ValidationError {
+	if m := dropInListPattern.FindStringSubmatch(problem.Field); m != nil {
+		index, _ := strconv.Atoi(m[2])
+		for _, d := range dropIns {
+			first, count := d.firstDir, d.dirs
+			if m[1] == "profiles" {
+				first, count = d.firstProfile, d.profiles
+			}
+			if index >= first && index < first+count {
+				problem.File = d.path
+				problem.Field = fmt.Sprintf("%s[%d]%s", m[1], index-first, m[3])
+				problem.Line = lookupLine(d.lines, problem.Field)
+				return problem
+			}
+		}
+		return problem
+	}
+
+	for i := len(dropIns) - 1; i >= 0; i-- {
+		if line, ok := dropIns[i].lines[problem.Field]; ok {
+			problem.File = dropIns[i].path
+			problem.Line = line
+			return problem
+		}
+	}
+	return problem
+}
@@ -0,0 +1,135 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"CloudAWSync/internal/interfaces"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvironmentVariable selects an environment when -environment is not given
+const EnvironmentVariable = "CLOUDAWSYNC_ENVIRONMENT"
+
+// Environment is a named set of overrides for one situation the machine
+// runs in, such as "home" or "tethered", selected at startup without
+// editing the rest of the configuration
+type Environment struct {
+	DisableRealtime bool                   `yaml:"disable_realtime"` // switch realtime and both directories to scheduled
+	Settings        map[string]interface{} `yaml:"settings"`         // configuration keys replacing the top-level values
+}
+
+// selectedEnvironment returns the name of the environment to apply: the
+// CLOUDAWSYNC_ENVIRONMENT variable, otherwise the environment key
+func (c *Config) selectedEnvironment() string {
+	if name := os.Getenv(EnvironmentVariable); name != "" {
+		return name
+	}
+	return c.Environment
+}
+
+// applyEnvironment applies the overrides of the selected environment, if
+// any. Settings are decoded over the loaded configuration, so maps are
+// merged while lists such as directories are replaced as a whole.
+func (c *Config) applyEnvironment() error {
+	name := c.selectedEnvironment()
+	if name == "" {
+		return nil
+	}
+	env, ok := c.Environments[name]
+	if !ok {
+		return fmt.Errorf("environment '%s' is not configured", name)
+	}
+
+	if len(env.Settings) > 0 {
+		data, err := yaml.Marshal(env.Settings)
+		if err != nil {
+			return fmt.Errorf("failed to encode environments.%s.settings: %w", name, err)
+		}
+		if err := decodeYAMLStrict(data, c); err != nil {
+			return fmt.Errorf("environments.%s.settings: %w", name, err)
+		}
+	}
+
+	if env.DisableRealtime {
+		disableRealtime(c.Directories)
+		for i := range c.Profiles {
+			disableRealtime(c.Profiles[i].Directories)
+		}
+	}
+
+	c.Environment = name
+	return nil
+}
+
+// disableRealtime switches directories watching for changes to scheduled
+// sync only
+func disableRealtime(dirs []interfaces.SyncDirectory) {
+	for i := range dirs {
+		if dirs[i].SyncMode == interfaces.SyncModeRealtime || dirs[i].SyncMode == interfaces.SyncModeBoth {
+			dirs[i].SyncMode = interfaces.SyncModeScheduled
+			dirs[i].RescanInterval = 0
+		}
+	}
+}
+
+// environmentProblems checks the environment names and that the settings
+// of every environment, not only the selected one, decode. An unknown
+// selected environment is reported by applyEnvironment.
+func environmentProblems(c *Config) ValidationErrors {
+	var problems ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	names := slices.Sorted(maps.Keys(c.Environments))
+	for _, name := range names {
+		field := "environments." + name
+		if !profileNamePattern.MatchString(name) {
+			add(field, "'%s' must be lowercase letters, digits, '-' and '_'", name)
+			continue
+		}
+		settings := c.Environments[name].Settings
+		for _, key := range []string{"environment", "environments"} {
+			if _, ok := settings[key]; ok {
+				add(field+".settings."+key, "cannot be set by an environment")
+			}
+		}
+		data, err := yaml.Marshal(settings)
+		if err != nil {
+			add(field+".settings", "%v", err)
+			continue
+		}
+		if err := decodeYAMLStrict(data, DefaultConfig()); err != nil {
+			// line numbers refer to the re-encoded settings, not the file
+			for _, problem := range yamlProblems(err) {
+				add(field+".settings", "%s", problem.Message)
+			}
+		}
+	}
+	return problems
+}
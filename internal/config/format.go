@@ -0,0 +1,190 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Format identifies a configuration file encoding
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// tomlTablePattern matches a TOML table or array-of-tables header
+var tomlTablePattern = regexp.MustCompile(`^\[\[?[A-Za-z0-9_.\-"]+\]\]?$`)
+
+// tomlKeyValuePattern matches a TOML key/value assignment
+var tomlKeyValuePattern = regexp.MustCompile(`^[A-Za-z0-9_\-"]+\s*=`)
+
+// DetectFormat determines the format of a configuration file from its
+// extension, falling back to sniffing the content
+func DetectFormat(configPath string, data []byte) Format {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	case ".yaml", ".yml":
+		return FormatYAML
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return FormatJSON
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tomlTablePattern.MatchString(line) || tomlKeyValuePattern.MatchString(line) {
+			return FormatTOML
+		}
+		break
+	}
+
+	return FormatYAML
+}
+
+// toYAML converts configuration data in any supported format to YAML so
+// that all formats share the same field names and strict decoding
+func toYAML(data []byte, format Format) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		// JSON escapes such as \/ are not valid YAML, so decode with the
+		// JSON parser rather than relying on JSON being a subset of YAML
+		var doc map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if decoder.More() {
+			return nil, errors.New("unexpected data after the top-level JSON object")
+		}
+		return yaml.Marshal(plainValue(doc))
+	case FormatTOML:
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(plainValue(doc))
+	default:
+		return data, nil
+	}
+}
+
+// plainValue replaces JSON numbers and TOML dates and times in a decoded
+// document with values YAML encodes as plain scalars
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = plainValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = plainValue(item)
+		}
+		return v
+	case json.Number:
+		// Keep integers exact; float64 would print large sizes in
+		// exponent form, which does not decode into integer fields
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case toml.LocalDate:
+		return v.String()
+	case toml.LocalTime:
+		return v.String()
+	case toml.LocalDateTime:
+		return v.String()
+	default:
+		return value
+	}
+}
+
+// decodeConfig strictly decodes configuration data of the given format
+func decodeConfig(data []byte, format Format, out *Config) error {
+	yamlData, err := toYAML(data, format)
+	if err != nil {
+		return err
+	}
+	return decodeYAMLStrict(yamlData, out)
+}
+
+// encodeConfig encodes a configuration in the given format
+func encodeConfig(c *Config, format Format) ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatYAML {
+		return data, nil
+	}
+
+	// Round-trip through a generic document so JSON and TOML output use
+	// the same keys and duration strings as YAML
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatJSON:
+		out, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	case FormatTOML:
+		return toml.Marshal(doc)
+	default:
+		return nil, fmt.Errorf("unsupported config format '%s'", format)
+	}
+}
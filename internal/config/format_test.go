@@ -0,0 +1,156 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeConfigFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		data    string
+		check   func(t *testing.T, c *Config)
+		wantErr bool
+	}{
+		{
+			name:   "json escapes",
+			format: FormatJSON,
+			data:   `{"aws": {"s3_bucket": "b\u00fccket", "s3_prefix": "a\/b\tc"}, "state_dir": "C:\\state"}`,
+			check: func(t *testing.T, c *Config) {
+				if c.AWS.S3Bucket != "bücket" || c.AWS.S3Prefix != "a/b\tc" || c.StateDir != `C:\state` {
+					t.Errorf("decoded %q, %q, %q", c.AWS.S3Bucket, c.AWS.S3Prefix, c.StateDir)
+				}
+			},
+		},
+		{
+			name:   "json large integers",
+			format: FormatJSON,
+			data:   `{"security": {"max_file_size": 10737418240}, "performance": {"max_concurrent_uploads": 4}}`,
+			check: func(t *testing.T, c *Config) {
+				if c.Security.MaxFileSize != 10737418240 || c.Performance.MaxConcurrentUploads != 4 {
+					t.Errorf("decoded %d, %d", c.Security.MaxFileSize, c.Performance.MaxConcurrentUploads)
+				}
+			},
+		},
+		{
+			name:   "json durations",
+			format: FormatJSON,
+			data:   `{"performance": {"retry_delay": "1m30s"}}`,
+			check: func(t *testing.T, c *Config) {
+				if c.Performance.RetryDelay != 90*time.Second {
+					t.Errorf("retry_delay = %v", c.Performance.RetryDelay)
+				}
+			},
+		},
+		{
+			name:    "json unknown field",
+			format:  FormatJSON,
+			data:    `{"aws": {"bucket": "b"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "json trailing data",
+			format:  FormatJSON,
+			data:    `{"state_dir": "/a"} {"state_dir": "/b"}`,
+			wantErr: true,
+		},
+		{
+			name:   "empty json",
+			format: FormatJSON,
+			data:   "",
+			check: func(t *testing.T, c *Config) {
+				if c.StateDir != "" {
+					t.Errorf("state_dir = %q", c.StateDir)
+				}
+			},
+		},
+		{
+			name:   "toml durations",
+			format: FormatTOML,
+			data:   "[performance]\nretry_delay = \"2h45m\"\nmax_concurrent_uploads = 3\n",
+			check: func(t *testing.T, c *Config) {
+				if c.Performance.RetryDelay != 2*time.Hour+45*time.Minute || c.Performance.MaxConcurrentUploads != 3 {
+					t.Errorf("decoded %v, %d", c.Performance.RetryDelay, c.Performance.MaxConcurrentUploads)
+				}
+			},
+		},
+		{
+			name:   "toml dates",
+			format: FormatTOML,
+			data:   "environment = 2026-03-15\nstate_dir = 2026-03-15T12:30:00Z\n[aws]\ns3_prefix = 2026-03-15T12:30:00\n",
+			check: func(t *testing.T, c *Config) {
+				if c.Environment != "2026-03-15" || c.StateDir != "2026-03-15T12:30:00Z" || c.AWS.S3Prefix != "2026-03-15T12:30:00" {
+					t.Errorf("decoded %q, %q, %q", c.Environment, c.StateDir, c.AWS.S3Prefix)
+				}
+			},
+		},
+		{
+			name:    "toml date as duration",
+			format:  FormatTOML,
+			data:    "[performance]\nretry_delay = 2026-03-15\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			err := decodeConfig([]byte(tt.data), tt.format, &c)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("decodeConfig succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, &c)
+		})
+	}
+}
+
+func TestEncodeConfigRoundTrip(t *testing.T) {
+	var original Config
+	original.AWS.S3Prefix = `a/b "quoted" \ path`
+	original.Security.MaxFileSize = 10737418240
+	original.Performance.RetryDelay = 90 * time.Second
+
+	for _, format := range []Format{FormatYAML, FormatJSON, FormatTOML} {
+		t.Run(string(format), func(t *testing.T) {
+			data, err := encodeConfig(&original, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var decoded Config
+			if err := decodeConfig(data, format, &decoded); err != nil {
+				t.Fatalf("decoding %s: %v\n%s", format, err, data)
+			}
+			if decoded.AWS.S3Prefix != original.AWS.S3Prefix || decoded.Security.MaxFileSize != original.Security.MaxFileSize ||
+				decoded.Performance.RetryDelay != original.Performance.RetryDelay {
+				t.Errorf("round trip through %s changed the configuration:\n%s", format, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// profileNamePattern limits profile names to characters that are safe in
+// file names, socket paths and remote prefixes
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Profile is a tenant of a shared daemon, such as one member of a family
+// NAS, syncing its own directories with its own bucket and quota. Unset
+// aws and quota fields are inherited from the top level.
+type Profile struct {
+	Name        string                     `yaml:"name"`
+	AWS         AWSConfig                  `yaml:"aws"`      // overrides the top-level aws fields that are set
+	Quota       QuotaConfig                `yaml:"quota"`    // overrides the top-level quota fields that are set
+	Schedule    string                     `yaml:"schedule"` // default for directories without one
+	RunAs       string                     `yaml:"run_as"`   // default run_as of the directories
+	Directories []interfaces.SyncDirectory `yaml:"directories"`
+}
+
+// ProfileConfigs returns the configuration of every profile
+func (c *Config) ProfileConfigs() []*Config {
+	configs := make([]*Config, len(c.Profiles))
+	for i, profile := range c.Profiles {
+		configs[i] = c.profileConfig(profile)
+	}
+	return configs
+}
+
+// ProfileConfig returns the configuration of the named profile
+func (c *Config) ProfileConfig(name string) (*Config, error) {
+	for _, profile := range c.Profiles {
+		if profile.Name == name {
+			return c.profileConfig(profile), nil
+		}
+	}
+	return nil, fmt.Errorf("profile '%s' is not configured", name)
+}
+
+// profileConfig derives a profile's configuration from the top level. The
+// profile keeps its state below state_dir/profiles/<name>, logs to its own
+// file and answers on its own control sockets. Metrics and the dashboard
+// stay with the top level.
+func (c *Config) profileConfig(profile Profile) *Config {
+	pc := *c
+	pc.Profile = profile.Name
+	pc.Profiles = nil
+
+	pc.AWS.S3Prefix = c.AWS.S3Prefix + profile.Name + "/"
+	mergeAWS(&pc.AWS, profile.AWS)
+	if profile.Quota.MaxBytes != 0 {
+		pc.Quota.MaxBytes = profile.Quota.MaxBytes
+	}
+	if profile.Quota.MaxObjects != 0 {
+		pc.Quota.MaxObjects = profile.Quota.MaxObjects
+	}
+	if profile.Quota.CheckInterval != 0 {
+		pc.Quota.CheckInterval = profile.Quota.CheckInterval
+	}
+
+	pc.Directories = make([]interfaces.SyncDirectory, len(profile.Directories))
+	for i, dir := range profile.Directories {
+		if dir.Schedule == "" {
+			dir.Schedule = profile.Schedule
+		}
+		if dir.RunAs == "" {
+			dir.RunAs = profile.RunAs
+		}
+		pc.Directories[i] = dir
+	}
+
+	pc.StateDir = filepath.Join(c.StateDir, "profiles", profile.Name)
+	for _, path := range []*string{&pc.State.Path, &pc.State.BacklogPath, &pc.Audit.Path, &pc.Security.NameMapPath, &pc.Antivirus.QuarantineDir} {
+		if *path != "" {
+			*path = filepath.Join(pc.StateDir, filepath.Base(*path))
+		}
+	}
+	if pc.Logging.Format != "journald" && pc.Logging.OutputPath != "stdout" && pc.Logging.OutputPath != "stderr" {
+		pc.Logging.OutputPath = profilePath(pc.Logging.OutputPath, profile.Name)
+	}
+	pc.Control.Socket = profilePath(pc.Control.Socket, profile.Name)
+	pc.Control.GRPCSocket = profilePath(pc.Control.GRPCSocket, profile.Name)
+	if pc.Mount.CacheDir != "" {
+		pc.Mount.CacheDir = filepath.Join(pc.Mount.CacheDir, profile.Name)
+	}
+
+	pc.Metrics.Enabled = false
+	pc.Metrics.Pprof = false
+	pc.Dashboard.Enabled = false
+	return &pc
+}
+
+// mergeAWS overrides the fields of dst that are set in src
+func mergeAWS(dst *AWSConfig, src AWSConfig) {
+	for _, field := range []struct{ dst, src *string }{
+		{&dst.Region, &src.Region},
+		{&dst.AccessKeyID, &src.AccessKeyID},
+		{&dst.SecretAccessKey, &src.SecretAccessKey},
+		{&dst.SessionToken, &src.SessionToken},
+		{&dst.S3Bucket, &src.S3Bucket},
+		{&dst.S3Prefix, &src.S3Prefix},
+		{&dst.Endpoint, &src.Endpoint},
+		{&dst.StorageClass, &src.StorageClass},
+	} {
+		if *field.src != "" {
+			*field.dst = *field.src
+		}
+	}
+}
+
+// profilePath inserts the profile name before the extension of path, so
+// /var/log/cloudawsync/cloudawsync.log becomes cloudawsync-<name>.log
+func profilePath(path, name string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
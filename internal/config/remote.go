@@ -0,0 +1,316 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteFetcher downloads the document at a remote configuration URL and
+// its detached signature, at SignatureURL, with one client
+type RemoteFetcher func(ctx context.Context, c *Config, rawURL string) (document, signature []byte, err error)
+
+// SignatureURL returns the URL of the detached signature of a remote
+// configuration, the document URL with ".sig" appended to its path
+func SignatureURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path += ".sig"
+	return u.String(), nil
+}
+
+// readPublicKey reads the PEM encoded Ed25519 public key that remote
+// configurations are signed with
+func readPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote configuration public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an Ed25519 key", path)
+	}
+	return edKey, nil
+}
+
+// verifySignature checks a document against its detached signature,
+// either the raw 64 bytes or base64 encoded
+func verifySignature(key ed25519.PublicKey, data, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid signature encoding: %w", err)
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("signature does not match the document")
+	}
+	return nil
+}
+
+// remoteCachePath returns where the last verified remote configuration is
+// kept, resolved against the state directory, or "" for none
+func (c *Config) remoteCachePath() string {
+	path := c.RemoteConfig.CachePath
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	stateDir := c.StateDir
+	if stateDir == "" {
+		stateDir = DefaultStateDir()
+	}
+	return filepath.Join(stateDir, path)
+}
+
+// RemoteDigest identifies a fetched remote document and signature,
+// accepted or not, so that polls only reload when either changes
+type RemoteDigest [sha256.Size]byte
+
+// remoteDigest returns the digest of a document and its signature
+func remoteDigest(document, signature []byte) RemoteDigest {
+	hash := sha256.New()
+	documentHash := sha256.Sum256(document)
+	hash.Write(documentHash[:])
+	hash.Write(signature)
+	var digest RemoteDigest
+	hash.Sum(digest[:0])
+	return digest
+}
+
+// remoteYAML returns a remote document as YAML without its serial, and
+// the serial, zero when the document has none. The serial is signed with
+// the document and must grow with each publication, so that an older
+// document cannot be served again in place of a newer one.
+func (c *Config) remoteYAML(data []byte) ([]byte, int64, error) {
+	u, err := url.Parse(c.RemoteConfig.URL)
+	if err != nil {
+		return nil, 0, err
+	}
+	yamlData, err := toYAML(data, DetectFormat(u.Path, data))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(yamlData, &doc); err != nil {
+		return nil, 0, err
+	}
+	value, ok := doc["serial"]
+	if !ok {
+		return yamlData, 0, nil
+	}
+	var serial int64
+	switch v := value.(type) {
+	case int:
+		serial = int64(v)
+	case int64:
+		serial = v
+	}
+	if serial <= 0 {
+		return nil, 0, fmt.Errorf("serial must be a positive integer, got %v", value)
+	}
+
+	delete(doc, "serial")
+	if len(doc) == 0 {
+		return nil, serial, nil
+	}
+	yamlData, err = yaml.Marshal(doc)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode remote configuration: %w", err)
+	}
+	return yamlData, serial, nil
+}
+
+// fetchRemote downloads the remote configuration and its signature and
+// verifies them, refusing a document with a lower serial than minSerial
+func (c *Config) fetchRemote(ctx context.Context, fetch RemoteFetcher, key ed25519.PublicKey, minSerial int64) ([]byte, []byte, error) {
+	data, signature, err := fetch(ctx, c, c.RemoteConfig.URL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch remote configuration: %w", err)
+	}
+	c.RemoteFetched = remoteDigest(data, signature)
+
+	if err := verifySignature(key, data, signature); err != nil {
+		return nil, nil, fmt.Errorf("remote configuration %s rejected: %w", c.RemoteConfig.URL, err)
+	}
+	_, serial, err := c.remoteYAML(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse remote configuration %s: %w", c.RemoteConfig.URL, err)
+	}
+	if serial < minSerial {
+		return nil, nil, fmt.Errorf("remote configuration %s rejected: serial %d is older than serial %d of the kept copy",
+			c.RemoteConfig.URL, serial, minSerial)
+	}
+	return data, signature, nil
+}
+
+// readRemoteCache reads and verifies the last fetched remote configuration
+func readRemoteCache(path string, key ed25519.PublicKey) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := os.ReadFile(path + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(key, data, signature); err != nil {
+		return nil, fmt.Errorf("cached remote configuration %s rejected: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeRemoteCache keeps a verified remote configuration and its
+// signature for starts without access to the source
+func writeRemoteCache(path string, data, signature []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create remote configuration cache directory: %w", err)
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{path + ".sig", signature}, {path, data}} {
+		tmp := file.path + ".tmp"
+		if err := os.WriteFile(tmp, file.data, 0600); err != nil {
+			return fmt.Errorf("failed to write remote configuration cache: %w", err)
+		}
+		if err := os.Rename(tmp, file.path); err != nil {
+			return fmt.Errorf("failed to write remote configuration cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadRemote merges the remote configuration into c. It is fetched with
+// fetch when given, falling back to the last verified copy when the
+// source cannot be reached or its document fails verification or is
+// older than the copy. Without a fetcher only the copy is used, and
+// nothing is merged before the first successful fetch. Problems that
+// the copy covers are added to c.Warnings.
+func (c *Config) loadRemote(ctx context.Context, fetch RemoteFetcher) error {
+	if c.RemoteConfig.URL == "" {
+		return nil
+	}
+	key, err := readPublicKey(c.RemoteConfig.PublicKey)
+	if err != nil {
+		return err
+	}
+	cachePath := c.remoteCachePath()
+
+	var cached []byte
+	var cachedSerial int64
+	if cachePath != "" {
+		cached, err = readRemoteCache(cachePath, key)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if cached != nil {
+			if _, cachedSerial, err = c.remoteYAML(cached); err != nil {
+				return fmt.Errorf("failed to parse cached remote configuration %s: %w", cachePath, err)
+			}
+		}
+	}
+
+	var data []byte
+	var fetchErr error
+	if fetch != nil {
+		var signature []byte
+		data, signature, fetchErr = c.fetchRemote(ctx, fetch, key, cachedSerial)
+		if fetchErr == nil && cachePath != "" {
+			if err := writeRemoteCache(cachePath, data, signature); err != nil {
+				c.Warnings = append(c.Warnings, err.Error())
+			}
+		}
+	}
+
+	if data == nil {
+		if cached == nil {
+			return fetchErr
+		}
+		data = cached
+		if fetchErr != nil {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("%v, using the copy from %s", fetchErr, cachePath))
+		}
+	}
+
+	if err := c.mergeRemote(data); err != nil {
+		return fmt.Errorf("failed to parse remote configuration %s: %w", c.RemoteConfig.URL, err)
+	}
+	c.RemoteDocument = data
+	return nil
+}
+
+// mergeRemote merges a verified remote configuration into c like a
+// drop-in file, or only its directories with directories_only
+func (c *Config) mergeRemote(data []byte) error {
+	yamlData, _, err := c.remoteYAML(data)
+	if err != nil {
+		return err
+	}
+
+	var doc Config
+	if err := decodeYAMLStrict(yamlData, &doc); err != nil {
+		return err
+	}
+	if doc.RemoteConfig != (RemoteConfig{}) {
+		return fmt.Errorf("remote_config cannot be set by a remote configuration")
+	}
+	if c.RemoteConfig.DirectoriesOnly {
+		c.Directories = append(c.Directories, doc.Directories...)
+		return nil
+	}
+	return c.mergeDropIn(yamlData, FormatYAML)
+}
+
+// FetchRemoteDigest fetches the published remote configuration and its
+// signature without verifying them and returns their digest, to compare
+// with RemoteFetched. The configuration is reloaded to apply a change.
+func (c *Config) FetchRemoteDigest(ctx context.Context, fetch RemoteFetcher) (RemoteDigest, error) {
+	data, signature, err := fetch(ctx, c, c.RemoteConfig.URL)
+	if err != nil {
+		return RemoteDigest{}, fmt.Errorf("failed to fetch remote configuration: %w", err)
+	}
+	return remoteDigest(data, signature), nil
+}
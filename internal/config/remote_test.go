@@ -0,0 +1,185 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// remoteFixture is a configuration file fetching a signed remote
+// document from a fake source
+type remoteFixture struct {
+	configPath string
+	key        ed25519.PrivateKey
+	document   []byte
+	signature  []byte
+	fetchErr   error
+	fetches    int
+}
+
+// newRemoteFixture writes a configuration with remote_config and a fresh
+// public key to a temporary directory
+func newRemoteFixture(t *testing.T) *remoteFixture {
+	t.Helper()
+	dir := t.TempDir()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "fleet.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	config := strings.Join([]string{
+		"aws:",
+		"  s3_bucket: local-bucket",
+		"  access_key_id: test",
+		"  secret_access_key: test-secret",
+		"state_dir: " + filepath.Join(dir, "state"),
+		"remote_config:",
+		"  url: https://config.example.com/fleet.yaml",
+		"  public_key: " + keyPath,
+		"",
+	}, "\n")
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &remoteFixture{configPath: configPath, key: private}
+}
+
+// publish sets the document served by the fake source, signed with the
+// fixture's key
+func (f *remoteFixture) publish(document string) {
+	f.document = []byte(document)
+	f.signature = ed25519.Sign(f.key, f.document)
+}
+
+// fetch is the fixture's RemoteFetcher
+func (f *remoteFixture) fetch(ctx context.Context, c *Config, rawURL string) ([]byte, []byte, error) {
+	f.fetches++
+	return f.document, f.signature, f.fetchErr
+}
+
+// load loads the fixture's configuration, fetching from the fake source
+func (f *remoteFixture) load(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := LoadConfigFrom(context.Background(), f.configPath, f.fetch)
+	if err != nil {
+		t.Fatalf("LoadConfigFrom failed: %v", err)
+	}
+	return cfg
+}
+
+func TestRemoteConfigFallsBackToKeptCopy(t *testing.T) {
+	f := newRemoteFixture(t)
+	f.publish("serial: 2\naws:\n  s3_bucket: fleet-bucket\n")
+	if cfg := f.load(t); cfg.AWS.S3Bucket != "fleet-bucket" || len(cfg.Warnings) != 0 {
+		t.Fatalf("first load: bucket %q, warnings %v", cfg.AWS.S3Bucket, cfg.Warnings)
+	}
+
+	tests := []struct {
+		name    string
+		change  func()
+		warning string
+	}{
+		{
+			name:    "unreachable",
+			change:  func() { f.fetchErr = errors.New("connection refused") },
+			warning: "connection refused",
+		},
+		{
+			name: "bad signature",
+			change: func() {
+				f.publish("serial: 3\naws:\n  s3_bucket: evil-bucket\n")
+				f.signature[0] ^= 0xff
+			},
+			warning: "signature does not match",
+		},
+		{
+			name:    "older serial",
+			change:  func() { f.publish("serial: 1\naws:\n  s3_bucket: old-bucket\n") },
+			warning: "serial 1 is older than serial 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.fetchErr = nil
+			tt.change()
+			cfg := f.load(t)
+			if cfg.AWS.S3Bucket != "fleet-bucket" {
+				t.Errorf("bucket = %q, want the kept copy's fleet-bucket", cfg.AWS.S3Bucket)
+			}
+			if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], tt.warning) {
+				t.Errorf("warnings = %v, want one containing %q", cfg.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestRemoteConfigRejectedDocumentNotChanged(t *testing.T) {
+	f := newRemoteFixture(t)
+	f.publish("serial: 5\naws:\n  s3_bucket: fleet-bucket\n")
+	f.load(t)
+
+	f.publish("serial: 4\naws:\n  s3_bucket: old-bucket\n")
+	cfg := f.load(t)
+	digest, err := cfg.FetchRemoteDigest(context.Background(), f.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != cfg.RemoteFetched {
+		t.Error("rejected document is reported as changed")
+	}
+
+	f.publish("serial: 6\naws:\n  s3_bucket: new-bucket\n")
+	if digest, _ := cfg.FetchRemoteDigest(context.Background(), f.fetch); digest == cfg.RemoteFetched {
+		t.Error("newly published document is not reported as changed")
+	}
+	if cfg := f.load(t); cfg.AWS.S3Bucket != "new-bucket" {
+		t.Errorf("bucket = %q, want new-bucket", cfg.AWS.S3Bucket)
+	}
+}
+
+func TestRemoteConfigInvalidSerial(t *testing.T) {
+	f := newRemoteFixture(t)
+	f.publish("serial: -1\n")
+	if _, err := LoadConfigFrom(context.Background(), f.configPath, f.fetch); err == nil {
+		t.Error("document with a negative serial was accepted")
+	}
+}
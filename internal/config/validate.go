@@ -0,0 +1,1441 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"CloudAWSync/internal/antivirus"
+	"CloudAWSync/internal/cron"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/layout"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError describes a single configuration problem
+type ValidationError struct {
+	Field   string // dotted path, e.g. directories[0].schedule
+	File    string // drop-in file the problem is in, empty for the configuration file
+	Line    int    // line in the source file, 0 if unknown
+	Message string
+}
+
+// Error implements the error interface
+func (v ValidationError) Error() string {
+	if v.Field == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+// ValidationErrors collects every problem found during validation
+type ValidationErrors []ValidationError
+
+// Error implements the error interface
+func (v ValidationErrors) Error() string {
+	msgs := make([]string, len(v))
+	for i, problem := range v {
+		msgs[i] = problem.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateFile decodes a configuration file strictly and returns every
+// problem found, annotated with source line numbers where possible.
+// The returned error is only set if the file itself could not be read.
+func ValidateFile(configPath string) (ValidationErrors, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var problems ValidationErrors
+
+	// TOML is converted to YAML before decoding, so its line numbers
+	// cannot be mapped back to the source file
+	format := DetectFormat(configPath, data)
+	yamlData, err := toYAML(data, format)
+	if err != nil {
+		return append(problems, ValidationError{Message: err.Error()}), nil
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlData, &root); err != nil {
+		return append(problems, yamlProblems(err)...), nil
+	}
+
+	cfg := DefaultConfig()
+	if err := decodeYAMLStrict(yamlData, cfg); err != nil {
+		for _, problem := range yamlProblems(err) {
+			if format == FormatTOML {
+				problem.Line = 0
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	dropIns, dropInProblems, err := cfg.validateDropIns(configPath)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, dropInProblems...)
+
+	// The remote configuration is checked as last fetched, if it was. A
+	// missing or unreadable key is reported with the other problems.
+	if _, err := readPublicKey(cfg.RemoteConfig.PublicKey); err == nil {
+		if err := cfg.loadRemote(context.Background(), nil); err != nil {
+			problems = append(problems, ValidationError{Field: "remote_config", Message: err.Error()})
+		}
+	}
+
+	if err := cfg.applyEnvironment(); err != nil {
+		problems = append(problems, ValidationError{Field: "environment", Message: err.Error()})
+	}
+
+	lines := make(map[string]int)
+	if format != FormatTOML {
+		collectNodeLines(&root, "", lines)
+	}
+
+	for _, problem := range cfg.collectProblems() {
+		problem = locate(problem, dropIns)
+		if problem.Line == 0 && problem.File == "" {
+			problem.Line = lookupLine(lines, problem.Field)
+		}
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+// decodeYAMLStrict decodes YAML rejecting keys that do not map to a field
+func decodeYAMLStrict(data []byte, out interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+var yamlLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlProblems converts YAML decoding errors into validation errors
+func yamlProblems(err error) ValidationErrors {
+	var messages []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	problems := make(ValidationErrors, 0, len(messages))
+	for _, msg := range messages {
+		problem := ValidationError{Message: msg}
+		if m := yamlLinePattern.FindStringSubmatch(msg); m != nil {
+			problem.Line, _ = strconv.Atoi(m[1])
+			problem.Message = m[2]
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// collectNodeLines records the line of every key in a YAML document
+func collectNodeLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			collectNodeLines(child, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			childPath := key.Value
+			if path != "" {
+				childPath = path + "." + key.Value
+			}
+			lines[childPath] = key.Line
+			collectNodeLines(node.Content[i+1], childPath, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			lines[childPath] = child.Line
+			collectNodeLines(child, childPath, lines)
+		}
+	}
+}
+
+// lookupLine finds the line for a field, falling back to its nearest parent
+func lookupLine(lines map[string]int, field string) int {
+	for field != "" {
+		if line, ok := lines[field]; ok {
+			return line
+		}
+		idx := strings.LastIndexAny(field, ".[")
+		if idx < 0 {
+			break
+		}
+		field = field[:idx]
+	}
+	return 0
+}
+
+// collectProblems runs every semantic check and returns all problems found
+func (c *Config) collectProblems() ValidationErrors {
+	var problems ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// AWS validation
+	if c.AWS.Region == "" {
+		add("aws.region", "AWS region is required")
+	}
+	if c.AWS.S3Bucket == "" {
+		add("aws.s3_bucket", "AWS S3 bucket is required")
+	} else if err := validateBucketName(c.AWS.S3Bucket); err != nil {
+		add("aws.s3_bucket", "%v", err)
+	}
+	if c.AWS.AccessKeyID == "" {
+		add("aws.access_key_id", "AWS access key ID is required")
+	}
+	if c.AWS.SecretAccessKey == "" {
+		add("aws.secret_access_key", "AWS secret access key is required")
+	}
+
+	// Logging validation
+	switch c.Logging.Format {
+	case "", "json", "text", "journald":
+	default:
+		add("logging.format", "invalid log format '%s' (must be 'json', 'text', or 'journald')", c.Logging.Format)
+	}
+	if c.Logging.SummaryInterval < 0 {
+		add("logging.summary_interval", "must not be negative")
+	}
+	if c.Logging.Dedupe.Every < 0 {
+		add("logging.dedupe.every", "must not be negative")
+	}
+	if c.Logging.Dedupe.Every > 0 && c.Logging.Dedupe.Window <= 0 {
+		add("logging.dedupe.window", "must be positive when dedupe is enabled")
+	}
+
+	// Directories validation
+	if len(c.Directories) == 0 && len(c.Profiles) == 0 && c.RemoteConfig.URL == "" {
+		add("directories", "at least one directory must be configured for synchronization")
+	}
+
+	chunked := false
+	for i, dir := range c.Directories {
+		field := fmt.Sprintf("directories[%d]", i)
+
+		if dir.LocalPath == "" {
+			add(field+".local_path", "local path is required")
+		} else if _, err := os.Stat(dir.LocalPath); os.IsNotExist(err) {
+			add(field+".local_path", "local path '%s' does not exist", dir.LocalPath)
+		}
+		if dir.RemotePath == "" {
+			add(field+".remote_path", "remote path is required")
+		}
+
+		switch dir.SyncMode {
+		case "":
+			add(field+".sync_mode", "sync mode is required")
+		case "realtime", "scheduled", "both", "backup":
+			// Valid modes
+		default:
+			add(field+".sync_mode", "invalid sync mode '%s' (must be 'realtime', 'scheduled', 'both', or 'backup')", dir.SyncMode)
+		}
+
+		encoding := dir.KeyEncoding
+		switch encoding.Mode {
+		case "", "percent":
+			if len(encoding.Replace) > 0 {
+				add(field+".key_encoding.replace", "replacements require mode 'replace'")
+			}
+		case "replace":
+			if len(encoding.Replace) == 0 {
+				add(field+".key_encoding.replace", "at least one replacement is required")
+			}
+			originals := slices.Sorted(maps.Keys(encoding.Replace))
+			seen := make(map[string]string, len(encoding.Replace))
+			for _, original := range originals {
+				replacement := encoding.Replace[original]
+				switch {
+				case utf8.RuneCountInString(original) != 1 || original == "/":
+					add(field+".key_encoding.replace", "%q must be a single character other than '/'", original)
+				case replacement == "" || strings.Contains(replacement, "/"):
+					add(field+".key_encoding.replace", "replacement of %q must be non-empty and must not contain '/'", original)
+				case seen[replacement] != "":
+					add(field+".key_encoding.replace", "%q and %q have the same replacement", seen[replacement], original)
+				}
+				seen[replacement] = original
+			}
+			for _, replacement := range slices.Sorted(maps.Keys(seen)) {
+				for _, original := range originals {
+					if strings.Contains(replacement, original) {
+						add(field+".key_encoding.replace", "replacement %q must not contain the replaced character %q", replacement, original)
+					}
+				}
+			}
+		default:
+			add(field+".key_encoding.mode", "invalid key encoding '%s' (must be 'percent' or 'replace')", encoding.Mode)
+		}
+		if encoding.Mode != "" && dir.SyncMode == "backup" {
+			add(field+".key_encoding", "key encoding does not apply to backup mode")
+		}
+
+		if dir.KeyLayout != "" {
+			if _, err := layout.Expand(dir.KeyLayout, layout.KeyVariables("", "", time.Time{}, "", "")); err != nil {
+				add(field+".key_layout", "%v", err)
+			} else if !strings.Contains(dir.KeyLayout, "{path}") && !strings.Contains(dir.KeyLayout, "{hash}") {
+				add(field+".key_layout", "must contain {path} or {hash} so that every file has its own key")
+			}
+			if strings.HasPrefix(dir.KeyLayout, "/") || slices.Contains(strings.Split(dir.KeyLayout, "/"), "..") {
+				add(field+".key_layout", "must stay below the remote path")
+			}
+			if dir.SyncMode == "backup" || dir.ReadOnly || dir.Distribution.Role != "" || dir.Archive.After > 0 || dir.RemotePollInterval > 0 {
+				add(field+".key_layout", "key layouts do not apply to backup mode, read-only, distribution, archive or remote_poll_interval directories")
+			}
+		}
+
+		retention := dir.Retention
+		if retention.KeepLast < 0 || retention.KeepDaily < 0 || retention.KeepWeekly < 0 || retention.KeepMonthly < 0 {
+			add(field+".retention", "retention counts must not be negative")
+		}
+		if !retention.IsZero() && dir.SyncMode != "backup" {
+			add(field+".retention", "retention only applies to backup mode")
+		}
+		switch dir.BackupFormat {
+		case "":
+		case "chunked":
+			chunked = true
+			if dir.SyncMode != "backup" {
+				add(field+".backup_format", "backup format only applies to backup mode")
+			}
+		default:
+			add(field+".backup_format", "unknown backup format %q, expected chunked or empty", dir.BackupFormat)
+		}
+
+		parity := dir.Parity
+		if parity.DataShards < 0 || parity.ParityShards < 0 {
+			add(field+".parity", "shard counts must not be negative")
+		} else if parity.IsZero() && parity.DataShards > 0 {
+			add(field+".parity.parity_shards", "data_shards requires parity_shards to be set")
+		} else if !parity.IsZero() {
+			if dir.SyncMode != "backup" {
+				add(field+".parity", "parity only applies to backup mode")
+			}
+			if parity.DataShards < 1 {
+				add(field+".parity.data_shards", "at least one data shard is required")
+			}
+			if parity.DataShards+parity.ParityShards > 256 {
+				add(field+".parity", "a stripe holds at most 256 shards, got %d", parity.DataShards+parity.ParityShards)
+			}
+		}
+
+		remoteRetention := dir.RemoteRetention
+		if remoteRetention.DeleteUnseenAfter < 0 {
+			add(field+".remote_retention.delete_unseen_after", "duration must not be negative")
+		}
+		if remoteRetention.KeepVersions < 0 {
+			add(field+".remote_retention.keep_versions", "version count must not be negative")
+		}
+		if !remoteRetention.IsZero() && dir.SyncMode == "backup" {
+			add(field+".remote_retention", "remote retention does not apply to backup mode, use retention")
+		}
+		if dir.Archive.After < 0 {
+			add(field+".archive.after", "archive age must not be negative")
+		}
+		if dir.Archive.After > 0 && dir.SyncMode == "backup" {
+			add(field+".archive", "archive mode does not apply to backup mode")
+		}
+		if remoteRetention.DeleteUnseenAfter > 0 && c.State.Path == "" {
+			add(field+".remote_retention.delete_unseen_after", "requires state.path to be set")
+		}
+
+		deletions := dir.Deletions
+		switch deletions.Action {
+		case interfaces.DeletionIgnore, interfaces.DeletionReport, interfaces.DeletionTrash, interfaces.DeletionDelete:
+		default:
+			add(field+".deletions.action", "invalid action '%s' (must be 'report', 'trash' or 'delete')", deletions.Action)
+		}
+		if deletions.MaxPercent < 0 || deletions.MaxPercent > 100 {
+			add(field+".deletions.max_percent", "must be between 0 and 100, got %d", deletions.MaxPercent)
+		}
+		if deletions.TrashPath != "" && (strings.HasPrefix(deletions.TrashPath, "/") || strings.Contains(deletions.TrashPath, "..")) {
+			add(field+".deletions.trash_path", "must be a relative remote path without '..'")
+		}
+		if deletions.Action != interfaces.DeletionIgnore {
+			if c.State.Path == "" {
+				add(field+".deletions.action", "requires state.path to be set")
+			}
+			if dir.SyncMode == "backup" || dir.ReadOnly || dir.Distribution.Role != "" {
+				add(field+".deletions", "deletion handling does not apply to backup, read-only or distribution directories")
+			}
+			if remoteRetention.DeleteUnseenAfter > 0 {
+				add(field+".deletions.action", "conflicts with remote_retention.delete_unseen_after, use one or the other")
+			}
+		}
+
+		if dir.Schedule != "" {
+			if schedule, err := cron.Parse(dir.Schedule); err != nil {
+				add(field+".schedule", "invalid cron expression '%s': %v", dir.Schedule, err)
+			} else if schedule.Next(time.Now()).IsZero() {
+				add(field+".schedule", "cron expression '%s' never fires", dir.Schedule)
+			}
+		}
+
+		if dir.VerifyInterval < 0 {
+			add(field+".verify_interval", "verify interval must not be negative")
+		}
+		if dir.RescanInterval < 0 {
+			add(field+".rescan_interval", "rescan interval must not be negative")
+		} else if dir.RescanInterval > 0 && dir.SyncMode != "realtime" {
+			add(field+".rescan_interval", "rescan interval only applies to realtime mode")
+		}
+		if dir.RemotePollInterval < 0 {
+			add(field+".remote_poll_interval", "remote poll interval must not be negative")
+		}
+
+		if dir.UploadWeight < 0 {
+			add(field+".upload_weight", "upload weight must not be negative")
+		}
+		if dir.MaxConcurrentUploads < 0 {
+			add(field+".max_concurrent_uploads", "max concurrent uploads must not be negative")
+		}
+		if dir.QuotaBytes < 0 {
+			add(field+".quota_bytes", "quota must not be negative")
+		}
+		if dir.QuotaObjects < 0 {
+			add(field+".quota_objects", "quota must not be negative")
+		}
+
+		for _, filter := range dir.Filters {
+			if _, err := filepath.Match(filter, ""); err != nil {
+				add(field+".filters", "invalid filter pattern '%s': %v", filter, err)
+			}
+		}
+
+		for _, name := range dir.IgnoreFiles {
+			if name == "" || (!filepath.IsAbs(name) && !filepath.IsLocal(name)) {
+				add(field+".ignore_files", "'%s' must be a path inside the directory or an absolute path", name)
+			}
+		}
+
+		if dir.AdoptRemote && c.State.Path == "" {
+			add(field+".adopt_remote", "requires state.path to be set")
+		}
+		if dir.PartialHash.MinSize < 0 || dir.PartialHash.SampleSize < 0 {
+			add(field+".partial_hash", "sizes must not be negative")
+		}
+		if dir.PartialHash.MinSize > 0 && c.State.Path == "" {
+			add(field+".partial_hash", "requires state.path to be set")
+		}
+
+		rules := dir.FileRules
+		if rules.MinSize < 0 || rules.MaxSize < 0 {
+			add(field+".file_rules", "size limits must not be negative")
+		}
+		if rules.MaxSize > 0 && rules.MinSize > rules.MaxSize {
+			add(field+".file_rules.min_size", "min_size %d is larger than max_size %d", rules.MinSize, rules.MaxSize)
+		}
+		if rules.ModifiedWithin < 0 || rules.OlderThan < 0 {
+			add(field+".file_rules", "age limits must not be negative")
+		}
+		if rules.ModifiedWithin > 0 && rules.OlderThan >= rules.ModifiedWithin {
+			add(field+".file_rules.older_than", "older_than must be shorter than modified_within or no file matches")
+		}
+		for _, owner := range rules.Owners {
+			if !knownOwner(owner, false) {
+				add(field+".file_rules.owners", "unknown user '%s'", owner)
+			}
+		}
+		for _, group := range rules.Groups {
+			if !knownOwner(group, true) {
+				add(field+".file_rules.groups", "unknown group '%s'", group)
+			}
+		}
+
+		if dir.RunAs != "" {
+			if c.Security.RunAs != "" {
+				add(field+".run_as", "cannot be combined with security.run_as, which drops root for every directory")
+			} else if msg := runAsProblem(dir.RunAs); msg != "" {
+				add(field+".run_as", "%s", msg)
+			}
+		}
+
+		if dir.Throttle.MinInterval < 0 {
+			add(field+".throttle.min_interval", "must not be negative")
+		}
+		for j, rule := range dir.Throttle.Rules {
+			ruleField := fmt.Sprintf("%s.throttle.rules[%d]", field, j)
+			if rule.Pattern == "" {
+				add(ruleField+".pattern", "is required")
+			} else if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+				add(ruleField+".pattern", "invalid pattern '%s': %v", rule.Pattern, err)
+			}
+			if rule.QuietTime < 0 || rule.MinInterval < 0 {
+				add(ruleField, "durations must not be negative")
+			}
+		}
+
+		snapshot := dir.Snapshot
+		switch snapshot.Mode {
+		case "", "copy":
+		case "lock":
+			if runtime.GOOS == "windows" {
+				add(field+".snapshot.mode", "lock mode is not supported on %s", runtime.GOOS)
+			}
+		case "command":
+			if snapshot.Command == "" {
+				add(field+".snapshot.command", "is required for command mode")
+			}
+			if !filepath.IsAbs(snapshot.Path) {
+				add(field+".snapshot.path", "must be an absolute path for command mode")
+			}
+		default:
+			add(field+".snapshot.mode", "invalid snapshot mode '%s' (must be 'lock', 'copy' or 'command')", snapshot.Mode)
+		}
+		for _, pattern := range snapshot.Patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				add(field+".snapshot.patterns", "invalid pattern '%s': %v", pattern, err)
+			}
+		}
+		if snapshot.Timeout < 0 {
+			add(field+".snapshot.timeout", "must not be negative")
+		}
+
+		if len(dir.ContentFilters) > 0 && dir.SyncMode == "backup" {
+			add(field+".content_filters", "content filters do not apply to backup mode")
+		}
+		if len(dir.ContentFilters) > 0 && dir.Archive.After > 0 {
+			add(field+".content_filters", "content filters cannot be combined with archive mode, which would remove the only unfiltered copy")
+		}
+		for j, filter := range dir.ContentFilters {
+			filterField := fmt.Sprintf("%s.content_filters[%d]", field, j)
+			switch {
+			case filter.Command != "" && filter.Builtin != "":
+				add(filterField, "set either builtin or command, not both")
+			case filter.Command == "" && filter.Builtin == "":
+				add(filterField, "builtin or command is required")
+			case filter.Builtin != "" && filter.Builtin != interfaces.ContentFilterStripGPS && filter.Builtin != interfaces.ContentFilterRedact:
+				add(filterField+".builtin", "unknown filter '%s' (must be 'strip-gps' or 'redact')", filter.Builtin)
+			}
+			if filter.Builtin == interfaces.ContentFilterRedact && len(filter.Redact) == 0 {
+				add(filterField+".redact", "at least one pattern is required")
+			}
+			if filter.Builtin != interfaces.ContentFilterRedact && (len(filter.Redact) > 0 || filter.Replacement != "") {
+				add(filterField+".redact", "redact patterns require builtin 'redact'")
+			}
+			for _, pattern := range filter.Redact {
+				if _, err := regexp.Compile(pattern); err != nil {
+					add(filterField+".redact", "invalid pattern '%s': %v", pattern, err)
+				}
+			}
+			for _, pattern := range filter.Patterns {
+				if _, err := filepath.Match(pattern, ""); err != nil {
+					add(filterField+".patterns", "invalid pattern '%s': %v", pattern, err)
+				}
+			}
+			for _, contentType := range filter.ContentTypes {
+				if major, minor, ok := strings.Cut(contentType, "/"); !ok || major == "" || minor == "" || strings.ContainsAny(contentType, " ;") {
+					add(filterField+".content_types", "invalid content type '%s' (expected e.g. 'image/jpeg' or 'text/*')", contentType)
+				}
+			}
+			if filter.Timeout < 0 {
+				add(filterField+".timeout", "must not be negative")
+			}
+		}
+
+		if dir.ReadOnly {
+			if dir.SyncMode != interfaces.SyncModeScheduled {
+				add(field+".sync_mode", "read-only directories must use sync mode 'scheduled'")
+			}
+			if c.State.Path == "" && dir.Distribution.Role != interfaces.DistributionSubscribe {
+				add(field+".read_only", "read-only directories require state.path to be set")
+			}
+			if dir.Archive.After > 0 || !dir.RemoteRetention.IsZero() || dir.AdoptRemote || len(dir.ContentFilters) > 0 || dir.SecretScan.Enabled {
+				add(field+".read_only", "archive, remote_retention, adopt_remote, content_filters and secret_scan do not apply to read-only directories")
+			}
+		}
+		switch dir.LocalChanges {
+		case "":
+		case interfaces.LocalChangesFlag, interfaces.LocalChangesRevert:
+			if !dir.ReadOnly {
+				add(field+".local_changes", "local_changes only applies to read-only directories")
+			}
+		default:
+			add(field+".local_changes", "invalid value '%s' (must be 'flag' or 'revert')", dir.LocalChanges)
+		}
+
+		if len(dir.Sparse) > 0 {
+			if !dir.ReadOnly && dir.RemotePollInterval == 0 {
+				add(field+".sparse", "sparse patterns only apply to read-only directories and directories with remote_poll_interval")
+			}
+			if dir.Distribution.Role != "" {
+				add(field+".sparse", "sparse patterns do not apply to distribution directories")
+			}
+		}
+		for _, pattern := range dir.Sparse {
+			trimmed := strings.Trim(pattern, "/")
+			if _, err := path.Match(trimmed, ""); err != nil {
+				add(field+".sparse", "invalid pattern '%s': %v", pattern, err)
+			} else if trimmed == "" || strings.HasPrefix(pattern, "/") || slices.Contains(strings.Split(trimmed, "/"), "..") {
+				add(field+".sparse", "pattern '%s' must be a path below the remote path", pattern)
+			}
+		}
+
+		distribution := dir.Distribution
+		switch distribution.Role {
+		case "":
+			if distribution != (interfaces.Distribution{}) {
+				add(field+".distribution.role", "role is required ('publish' or 'subscribe')")
+			}
+		case interfaces.DistributionPublish:
+			if dir.ReadOnly {
+				add(field+".distribution.role", "read-only directories cannot publish releases")
+			}
+			if distribution.PollInterval != 0 || distribution.PostApply != "" || distribution.Timeout != 0 {
+				add(field+".distribution", "poll_interval, post_apply and timeout only apply to subscribers")
+			}
+		case interfaces.DistributionSubscribe:
+			if !dir.ReadOnly {
+				add(field+".read_only", "subscriber directories must be read-only")
+			}
+			if dir.LocalChanges != "" {
+				add(field+".local_changes", "local_changes does not apply to subscribers, every release replaces the directory")
+			}
+			if distribution.KeepReleases != 0 {
+				add(field+".distribution.keep_releases", "keep_releases only applies to publishers")
+			}
+		default:
+			add(field+".distribution.role", "invalid role '%s' (must be 'publish' or 'subscribe')", distribution.Role)
+		}
+		if distribution.Role != "" && !dir.ReadOnly && dir.SyncMode != interfaces.SyncModeScheduled {
+			add(field+".sync_mode", "distribution directories must use sync mode 'scheduled'")
+		}
+		if distribution.Role != "" && (dir.Archive.After > 0 || !dir.RemoteRetention.IsZero() || len(dir.ContentFilters) > 0) {
+			add(field+".distribution", "archive, remote_retention and content_filters do not apply to distribution directories")
+		}
+		if distribution.KeepReleases < 0 {
+			add(field+".distribution.keep_releases", "must not be negative")
+		}
+		if distribution.PollInterval < 0 {
+			add(field+".distribution.poll_interval", "must not be negative")
+		}
+		if distribution.Timeout < 0 {
+			add(field+".distribution.timeout", "must not be negative")
+		}
+
+		secrets := dir.SecretScan
+		if secrets.Action != "" && secrets.Action != interfaces.SecretScanWarn && secrets.Action != interfaces.SecretScanBlock {
+			add(field+".secret_scan.action", "invalid action '%s' (must be 'warn' or 'block')", secrets.Action)
+		}
+		for _, pattern := range secrets.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add(field+".secret_scan.patterns", "invalid pattern '%s': %v", pattern, err)
+			}
+		}
+		for _, pattern := range secrets.AllowMatches {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add(field+".secret_scan.allow_matches", "invalid pattern '%s': %v", pattern, err)
+			}
+		}
+		for _, pattern := range secrets.AllowPaths {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				add(field+".secret_scan.allow_paths", "invalid pattern '%s': %v", pattern, err)
+			}
+		}
+	}
+
+	problems = append(problems, overlappingDirectoryProblems(c)...)
+	problems = append(problems, directoryOrderProblems(c)...)
+
+	// Performance validation
+	if c.Performance.MaxConcurrentUploads <= 0 {
+		add("performance.max_concurrent_uploads", "max concurrent uploads must be greater than 0")
+	}
+	if c.Performance.MaxConcurrentDownloads <= 0 {
+		add("performance.max_concurrent_downloads", "max concurrent downloads must be greater than 0")
+	}
+	if c.Performance.AdaptiveConcurrency && c.Performance.MinConcurrentTransfers < 1 {
+		add("performance.min_concurrent_transfers", "minimum concurrent transfers must be at least 1")
+	}
+	if c.Performance.MultipartThreshold < 0 {
+		add("performance.multipart_threshold", "multipart threshold must not be negative")
+	}
+	if c.Performance.MultipartThreshold > 0 && c.Performance.UploadChunkSize < 5*1024*1024 {
+		add("performance.upload_chunk_size", "multipart uploads need parts of at least 5MB, got %d", c.Performance.UploadChunkSize)
+	}
+	if c.Performance.DownloadChunkSize < 0 {
+		add("performance.download_chunk_size", "download chunk size must not be negative")
+	}
+	if c.Performance.DownloadParallelism < 1 {
+		add("performance.download_parallelism", "download parallelism must be at least 1")
+	}
+	if c.Performance.ScanParallelism < 1 {
+		add("performance.scan_parallelism", "scan parallelism must be at least 1")
+	}
+	if c.Performance.DirectoryParallelism < 1 {
+		add("performance.directory_parallelism", "directory parallelism must be at least 1")
+	}
+	if c.Performance.ScanRateLimit < 0 {
+		add("performance.scan_rate_limit", "scan rate limit must not be negative")
+	}
+	if c.Performance.TimeoutDuration < 0 {
+		add("performance.timeout_duration", "timeout duration must not be negative")
+	}
+	if c.Performance.TransferTimeoutPerMB < 0 {
+		add("performance.transfer_timeout_per_mb", "transfer timeout per MB must not be negative")
+	}
+	if c.Performance.MinTransferSpeed < 0 {
+		add("performance.min_transfer_speed", "minimum transfer speed must not be negative")
+	}
+	if c.Performance.BandwidthLimit < 0 {
+		add("performance.bandwidth_limit", "bandwidth limit must not be negative")
+	}
+	if c.Performance.BandwidthLimit > 0 && c.Performance.MinTransferSpeed > c.Performance.BandwidthLimit {
+		add("performance.min_transfer_speed", "minimum transfer speed must not exceed bandwidth_limit")
+	}
+	if c.Performance.MinTransferSpeed > 0 && c.Performance.StallTimeout <= 0 {
+		add("performance.stall_timeout", "stall timeout must be greater than 0 when a minimum transfer speed is set")
+	}
+	if c.Performance.RetryAttempts < 0 {
+		add("performance.retry_attempts", "retry attempts must not be negative")
+	}
+
+	// Quota validation
+	if c.Quota.MaxBytes < 0 {
+		add("quota.max_bytes", "quota must not be negative")
+	}
+	if c.Quota.MaxObjects < 0 {
+		add("quota.max_objects", "quota must not be negative")
+	}
+	if (c.Quota.MaxBytes > 0 || c.Quota.MaxObjects > 0) && c.Quota.CheckInterval <= 0 {
+		add("quota.check_interval", "check interval must be greater than 0 when a quota is set")
+	}
+
+	// Cost validation
+	if c.Cost.MonthlyBudget < 0 {
+		add("cost.monthly_budget", "monthly budget must not be negative")
+	}
+	switch c.Cost.BudgetAction {
+	case "", "warn", "pause":
+	default:
+		add("cost.budget_action", "invalid budget action '%s' (must be 'warn' or 'pause')", c.Cost.BudgetAction)
+	}
+
+	// State validation
+	if c.StateDir != "" && !filepath.IsAbs(c.StateDir) {
+		add("state_dir", "must be an absolute path")
+	}
+	if c.State.QuarantineAfter < 0 {
+		add("state.quarantine_after", "quarantine failure count must not be negative")
+	}
+	if c.State.QuarantineExpiry < 0 {
+		add("state.quarantine_expiry", "quarantine expiry must not be negative")
+	}
+	if c.State.BacklogMemory < 0 {
+		add("state.backlog_memory", "must not be negative")
+	}
+
+	// Replication validation
+	mirrorNames := make(map[string]bool)
+	for i, mirror := range c.Replication.Mirrors {
+		field := fmt.Sprintf("replication.mirrors[%d]", i)
+
+		switch {
+		case mirror.Name == "":
+			add(field+".name", "mirror name is required")
+		case mirror.Name == "primary":
+			add(field+".name", "mirror name 'primary' is reserved for the aws bucket")
+		case mirrorNames[mirror.Name]:
+			add(field+".name", "duplicate mirror name '%s'", mirror.Name)
+		}
+		mirrorNames[mirror.Name] = true
+
+		if mirror.Region == "" {
+			add(field+".region", "region is required")
+		}
+		if mirror.S3Bucket == "" {
+			add(field+".s3_bucket", "bucket is required")
+		} else if err := validateBucketName(mirror.S3Bucket); err != nil {
+			add(field+".s3_bucket", "%v", err)
+		} else if mirror.S3Bucket == c.AWS.S3Bucket && mirror.Endpoint == c.AWS.Endpoint && mirror.S3Prefix == c.AWS.S3Prefix {
+			add(field+".s3_bucket", "mirror must not write to the primary bucket and prefix")
+		}
+		if mirror.AccessKeyID == "" {
+			add(field+".access_key_id", "access key ID is required")
+		}
+		if mirror.SecretAccessKey == "" {
+			add(field+".secret_access_key", "secret access key is required")
+		}
+	}
+	if c.Replication.RepairInterval < 0 {
+		add("replication.repair_interval", "repair interval must not be negative")
+	}
+
+	// Failover validation
+	if c.Failover.Enabled {
+		secondary := c.Failover.Secondary
+		if len(c.Replication.Mirrors) > 0 {
+			add("failover.enabled", "failover cannot be combined with replication mirrors")
+		}
+		if secondary.Region == "" {
+			add("failover.secondary.region", "region is required")
+		}
+		if secondary.S3Bucket == "" {
+			add("failover.secondary.s3_bucket", "bucket is required")
+		} else if err := validateBucketName(secondary.S3Bucket); err != nil {
+			add("failover.secondary.s3_bucket", "%v", err)
+		} else if secondary.S3Bucket == c.AWS.S3Bucket && secondary.Endpoint == c.AWS.Endpoint && secondary.S3Prefix == c.AWS.S3Prefix {
+			add("failover.secondary.s3_bucket", "secondary must not use the primary bucket and prefix")
+		}
+		if secondary.AccessKeyID == "" {
+			add("failover.secondary.access_key_id", "access key ID is required")
+		}
+		if secondary.SecretAccessKey == "" {
+			add("failover.secondary.secret_access_key", "secret access key is required")
+		}
+		if c.Failover.FailoverAfter < 1 {
+			add("failover.failover_after", "failover_after must be at least 1")
+		}
+		if c.Failover.CheckInterval <= 0 {
+			add("failover.check_interval", "check interval must be positive")
+		}
+	}
+
+	// Key validation
+	switch c.Keys.LongKeys {
+	case "hash", "truncate", "fail":
+	default:
+		add("keys.long_keys", "long_keys must be hash, truncate or fail, got %q", c.Keys.LongKeys)
+	}
+	if c.Keys.MaxBytes < 0 || c.Keys.MaxBytes > 1024 {
+		add("keys.max_bytes", "max_bytes must be between 0 and 1024")
+	} else if c.Keys.MaxBytes > 0 && c.Keys.MaxBytes < len(c.AWS.S3Prefix)+128 {
+		add("keys.max_bytes", "max_bytes must leave at least 128 bytes after s3_prefix")
+	}
+
+	// Scrub validation
+	if c.Scrub.Interval < 0 {
+		add("scrub.interval", "scrub interval must not be negative")
+	}
+	if c.Scrub.SampleSize < 0 {
+		add("scrub.sample_size", "sample size must not be negative")
+	}
+	if c.Scrub.Interval > 0 && c.State.Path == "" {
+		add("scrub.interval", "scrubbing requires state.path to be set")
+	}
+
+	if c.Rescan.Interval < 0 {
+		add("rescan.interval", "rescan interval must not be negative")
+	}
+
+	if c.Antivirus.Enabled {
+		if c.Antivirus.ClamdAddress == "" {
+			add("antivirus.clamd_address", "clamd address is required when antivirus scanning is enabled")
+		} else if _, err := antivirus.NewClient(c.Antivirus.ClamdAddress, c.Antivirus.Timeout); err != nil {
+			add("antivirus.clamd_address", "%v", err)
+		}
+		if !c.Antivirus.ScanUploads && !c.Antivirus.ScanDownloads {
+			add("antivirus.enabled", "at least one of scan_uploads and scan_downloads must be set")
+		}
+		if c.Antivirus.QuarantineDir == "" {
+			add("antivirus.quarantine_dir", "quarantine directory is required when antivirus scanning is enabled")
+		}
+	}
+	if c.Antivirus.MaxScanSize < 0 {
+		add("antivirus.max_scan_size", "max scan size must not be negative")
+	}
+	if c.Antivirus.Timeout < 0 {
+		add("antivirus.timeout", "timeout must not be negative")
+	}
+
+	// Control and hydration validation
+	if c.Control.Enabled && c.Control.Socket == "" {
+		add("control.socket", "control socket path is required when the control API is enabled")
+	}
+	if c.Control.Enabled && c.Control.GRPCSocket != "" && c.Control.GRPCSocket == c.Control.Socket {
+		add("control.grpc_socket", "gRPC socket must differ from control.socket")
+	}
+	if c.Hydration.CacheSize < 0 {
+		add("hydration.cache_size", "cache size must not be negative")
+	}
+	if c.Hydration.CacheSize > 0 && c.State.Path == "" {
+		add("hydration.cache_size", "the hydration cache requires state.path to be set")
+	}
+
+	if c.Glacier.Enabled {
+		if c.Glacier.Days < 1 {
+			add("glacier_restore.days", "restored copies must be kept for at least one day")
+		}
+		switch c.Glacier.Tier {
+		case "Expedited", "Standard", "Bulk":
+		default:
+			add("glacier_restore.tier", "unknown retrieval tier %q, expected Expedited, Standard or Bulk", c.Glacier.Tier)
+		}
+		if c.Glacier.CheckInterval <= 0 {
+			add("glacier_restore.check_interval", "check interval must be positive")
+		}
+	}
+
+	if chunked {
+		chunking := c.Chunking
+		if chunking.KeyFile == "" {
+			add("chunking.key_file", "chunked backups require a key file")
+		} else if secret, err := os.ReadFile(chunking.KeyFile); err != nil {
+			add("chunking.key_file", "key file %s cannot be read: %v", chunking.KeyFile, err)
+		} else if len(secret) < 32 {
+			add("chunking.key_file", "key file %s must hold at least 32 bytes, has %d", chunking.KeyFile, len(secret))
+		}
+		if chunking.MinSize < 64 {
+			add("chunking.min_size", "minimum chunk size must be at least 64 bytes")
+		}
+		if chunking.AvgSize <= chunking.MinSize || chunking.MaxSize <= chunking.AvgSize {
+			add("chunking", "chunk sizes must satisfy min_size < avg_size < max_size")
+		}
+		if chunking.PackSize < int64(chunking.MaxSize) {
+			add("chunking.pack_size", "pack size must be at least max_size")
+		}
+	}
+
+	// Resource budget validation
+	if c.Performance.MemoryLimit < 0 {
+		add("performance.memory_limit", "memory limit must not be negative")
+	}
+	if c.Performance.CPULimit < 0 {
+		add("performance.cpu_limit", "CPU limit must not be negative")
+	}
+
+	// Priority validation
+	if c.Performance.Nice < -20 || c.Performance.Nice > 19 {
+		add("performance.nice", "nice level %d must be between -20 and 19", c.Performance.Nice)
+	}
+	switch c.Performance.IOClass {
+	case "", "realtime", "best-effort", "idle":
+	default:
+		add("performance.io_class", "unknown I/O class %q, expected realtime, best-effort or idle", c.Performance.IOClass)
+	}
+	if c.Performance.IOPriority < 0 || c.Performance.IOPriority > 7 {
+		add("performance.io_priority", "I/O priority %d must be between 0 and 7", c.Performance.IOPriority)
+	}
+	if (c.Performance.Nice != 0 || c.Performance.IOClass != "") && runtime.GOOS != "linux" {
+		add("performance.nice", "process priorities are only supported on Linux")
+	}
+	if c.Performance.ReadRateLimit < 0 {
+		add("performance.read_rate_limit", "read rate limit must not be negative")
+	}
+
+	// Network validation
+	if c.Network.MaxIdleConns < 0 {
+		add("network.max_idle_conns", "max idle connections must not be negative")
+	}
+	if c.Network.MaxIdleConnsPerHost < 0 {
+		add("network.max_idle_conns_per_host", "max idle connections per host must not be negative")
+	}
+	if c.Network.MaxConnsPerHost < 0 {
+		add("network.max_conns_per_host", "max connections per host must not be negative")
+	}
+	if c.Network.IdleConnTimeout < 0 {
+		add("network.idle_conn_timeout", "idle connection timeout must not be negative")
+	}
+	if c.Network.TLSHandshakeTimeout < 0 {
+		add("network.tls_handshake_timeout", "TLS handshake timeout must not be negative")
+	}
+	if c.Network.ConnectivityCheckInterval < 0 {
+		add("network.connectivity_check_interval", "connectivity check interval must not be negative")
+	}
+	if c.Network.ClockSkewTolerance < 0 {
+		add("network.clock_skew_tolerance", "clock skew tolerance must not be negative")
+	}
+	if c.Network.MaxRequestDelay < 0 {
+		add("network.max_request_delay", "max request delay must not be negative")
+	}
+	if c.Network.CircuitBreaker.Enabled {
+		if c.Network.CircuitBreaker.FailureThreshold < 1 {
+			add("network.circuit_breaker.failure_threshold", "failure threshold must be at least 1")
+		}
+		if c.Network.CircuitBreaker.Cooldown <= 0 {
+			add("network.circuit_breaker.cooldown", "cooldown must be positive")
+		}
+	}
+	if c.Network.CABundle != "" {
+		if _, err := os.Stat(c.Network.CABundle); err != nil {
+			add("network.ca_bundle", "CA bundle %s cannot be read: %v", c.Network.CABundle, err)
+		}
+	}
+
+	if c.Network.Proxy != "" {
+		proxyURL, err := url.Parse(c.Network.Proxy)
+		switch {
+		case err != nil:
+			add("network.proxy", "proxy is not a valid URL")
+		case proxyURL.Host == "":
+			add("network.proxy", "proxy URL %q has no host", proxyURL.Redacted())
+		case !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, proxyURL.Scheme):
+			add("network.proxy", "proxy scheme %q is not supported, use http, https, socks5 or socks5h", proxyURL.Scheme)
+		}
+	}
+
+	// Dashboard validation
+	if c.Dashboard.Enabled {
+		if host, port, err := net.SplitHostPort(c.Dashboard.Listen); err != nil || port == "" {
+			add("dashboard.listen", "listen address %q must be host:port", c.Dashboard.Listen)
+		} else if c.Dashboard.TLSCert == "" && !loopbackHost(host) {
+			// Basic auth credentials would cross the network in the clear
+			add("dashboard.listen", "listen address %q is reachable from the network, set tls_cert and tls_key or bind to 127.0.0.1", c.Dashboard.Listen)
+		}
+		if (c.Dashboard.TLSCert == "") != (c.Dashboard.TLSKey == "") {
+			add("dashboard.tls_key", "tls_cert and tls_key must be set together")
+		}
+		if c.Dashboard.TLSCert != "" {
+			if _, err := os.Stat(c.Dashboard.TLSCert); err != nil {
+				add("dashboard.tls_cert", "certificate %s cannot be read: %v", c.Dashboard.TLSCert, err)
+			}
+		}
+		if c.Dashboard.TLSKey != "" {
+			if _, err := os.Stat(c.Dashboard.TLSKey); err != nil {
+				add("dashboard.tls_key", "key %s cannot be read: %v", c.Dashboard.TLSKey, err)
+			}
+		}
+		if c.Dashboard.Username == "" {
+			add("dashboard.username", "username is required when the dashboard is enabled")
+		}
+		if c.Dashboard.Password == "" {
+			add("dashboard.password", "password is required when the dashboard is enabled")
+		}
+		if c.Dashboard.History <= 0 {
+			add("dashboard.history", "history must be positive")
+		}
+	}
+
+	// Mount validation
+	if c.Mount.CacheSize < 0 {
+		add("mount.cache_size", "cache size must not be negative")
+	}
+	if c.Mount.ListTTL < 0 {
+		add("mount.list_ttl", "list TTL must not be negative")
+	}
+
+	// Security validation
+	if c.Security.ObfuscateNames {
+		if c.Security.NameKeyFile == "" {
+			add("security.name_key_file", "name obfuscation requires a key file")
+		} else if secret, err := os.ReadFile(c.Security.NameKeyFile); err != nil {
+			add("security.name_key_file", "key file %s cannot be read: %v", c.Security.NameKeyFile, err)
+		} else if len(secret) < 32 {
+			add("security.name_key_file", "key file %s must hold at least 32 bytes, has %d", c.Security.NameKeyFile, len(secret))
+		}
+	}
+
+	if c.Security.RunAs != "" {
+		if runtime.GOOS == "windows" {
+			add("security.run_as", "dropping privileges is not supported on %s", runtime.GOOS)
+		} else if msg := runAsProblem(c.Security.RunAs); msg != "" {
+			add("security.run_as", "%s", msg)
+		}
+	}
+
+	// Metrics validation
+	if c.Metrics.Pprof && !c.Metrics.Enabled {
+		add("metrics.pprof", "profiling is served by the metrics server, set metrics.enabled")
+	}
+	if c.Metrics.Pprof && strings.HasPrefix(c.Metrics.Path, "/debug/pprof/") {
+		add("metrics.path", "metrics path must not be below /debug/pprof/ when profiling is enabled")
+	}
+	if c.Metrics.Enabled {
+		if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
+			add("metrics.port", "metrics port %d is out of range", c.Metrics.Port)
+		}
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			add("metrics.path", "metrics path must start with '/'")
+		}
+		if c.Metrics.Namespace != "" && !metricNamePattern.MatchString(c.Metrics.Namespace) {
+			add("metrics.namespace", "namespace %q must contain only letters, digits and underscores and not start with a digit", c.Metrics.Namespace)
+		}
+		for name := range c.Metrics.ConstLabels {
+			switch {
+			case !metricNamePattern.MatchString(name) || strings.HasPrefix(name, "__"):
+				add("metrics.const_labels", "invalid label name %q", name)
+			case slices.Contains(reservedMetricLabels, name):
+				add("metrics.const_labels", "label %q is already used by the agent's metrics", name)
+			case name == "hostname" && c.Metrics.HostnameLabel:
+				add("metrics.const_labels", "label \"hostname\" conflicts with metrics.hostname_label")
+			}
+		}
+	}
+
+	if remote := c.RemoteConfig; remote.URL != "" {
+		u, err := url.Parse(remote.URL)
+		switch {
+		case err != nil:
+			add("remote_config.url", "invalid URL: %v", err)
+		case u.Scheme == "s3":
+			if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+				add("remote_config.url", "'%s' must be s3://bucket/key", remote.URL)
+			}
+		case u.Scheme == "https":
+			if u.Host == "" {
+				add("remote_config.url", "'%s' has no host", remote.URL)
+			}
+		default:
+			add("remote_config.url", "'%s' must be an s3:// or https:// URL", remote.URL)
+		}
+		if remote.PublicKey == "" {
+			add("remote_config.public_key", "is required to verify the remote configuration")
+		} else if _, err := readPublicKey(remote.PublicKey); err != nil {
+			add("remote_config.public_key", "%v", err)
+		}
+		if remote.Interval < 0 {
+			add("remote_config.interval", "must not be negative")
+		}
+	} else if c.RemoteConfig.DirectoriesOnly || c.RemoteConfig.Interval != 0 || c.RemoteConfig.PublicKey != "" {
+		add("remote_config.url", "is required for the other remote_config settings")
+	}
+
+	problems = append(problems, profileProblems(c, problems)...)
+	problems = append(problems, environmentProblems(c)...)
+
+	return problems
+}
+
+// profileProblems checks every profile as the configuration it runs with,
+// leaving out the problems already reported for the top level
+func profileProblems(c *Config, topLevel ValidationErrors) ValidationErrors {
+	var problems ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	names := make(map[string]int)
+	for i, profile := range c.Profiles {
+		field := fmt.Sprintf("profiles[%d]", i)
+		if !profileNamePattern.MatchString(profile.Name) {
+			add(field+".name", "'%s' must be lowercase letters, digits, '-' and '_'", profile.Name)
+			continue
+		}
+		if j, ok := names[profile.Name]; ok {
+			add(field+".name", "duplicates profiles[%d]", j)
+			continue
+		}
+		names[profile.Name] = i
+
+		for _, problem := range c.profileConfig(profile).collectProblems() {
+			if !slices.Contains(topLevel, problem) {
+				add(field+"."+problem.Field, "%s", problem.Message)
+			}
+		}
+	}
+	return problems
+}
+
+// overlappingDirectoryProblems reports directories that are equal to or
+// nested within another configured directory
+func overlappingDirectoryProblems(c *Config) ValidationErrors {
+	var problems ValidationErrors
+
+	for i := 0; i < len(c.Directories); i++ {
+		if c.Directories[i].LocalPath == "" {
+			continue
+		}
+		a := filepath.Clean(c.Directories[i].LocalPath)
+		for j := i + 1; j < len(c.Directories); j++ {
+			if c.Directories[j].LocalPath == "" {
+				continue
+			}
+			b := filepath.Clean(c.Directories[j].LocalPath)
+
+			var msg string
+			switch {
+			case a == b:
+				msg = fmt.Sprintf("local path duplicates directories[%d]", i)
+			case isNestedPath(a, b):
+				msg = fmt.Sprintf("local path is nested inside directories[%d] (%s)", i, a)
+			case isNestedPath(b, a):
+				msg = fmt.Sprintf("local path contains directories[%d] (%s)", i, a)
+			default:
+				continue
+			}
+			problems = append(problems, ValidationError{
+				Field:   fmt.Sprintf("directories[%d].local_path", j),
+				Message: msg,
+			})
+		}
+	}
+
+	return problems
+}
+
+// directoryOrderProblems reports duplicate directory names, after entries
+// that match no other directory or one in a lower priority tier, and
+// dependency cycles
+func directoryOrderProblems(c *Config) ValidationErrors {
+	var problems ValidationErrors
+	dirs := c.Directories
+
+	names := make(map[string]int)
+	for i, dir := range dirs {
+		if dir.Name == "" {
+			continue
+		}
+		if j, ok := names[dir.Name]; ok {
+			problems = append(problems, ValidationError{
+				Field:   fmt.Sprintf("directories[%d].name", i),
+				Message: fmt.Sprintf("name %q duplicates directories[%d]", dir.Name, j),
+			})
+			continue
+		}
+		names[dir.Name] = i
+	}
+
+	deps := make([][]int, len(dirs))
+	for i, dir := range dirs {
+		field := fmt.Sprintf("directories[%d].after", i)
+		for _, ref := range dir.After {
+			j := slices.IndexFunc(dirs, func(other interfaces.SyncDirectory) bool { return other.IsReferencedBy(ref) })
+			switch {
+			case j < 0:
+				problems = append(problems, ValidationError{Field: field, Message: fmt.Sprintf("%q matches no directory name or local path", ref)})
+			case j == i:
+				problems = append(problems, ValidationError{Field: field, Message: "a directory cannot be synced after itself"})
+			case dirs[j].Priority < dir.Priority:
+				problems = append(problems, ValidationError{
+					Field:   field,
+					Message: fmt.Sprintf("%q has a lower priority (%d) and is synced later", ref, dirs[j].Priority),
+				})
+			default:
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+
+	// Depth-first search for cycles; each cycle is reported once, at the
+	// directory where it was found
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(dirs))
+	var visit func(i int) bool
+	visit = func(i int) bool {
+		marks[i] = visiting
+		for _, j := range deps[i] {
+			if marks[j] == visiting || (marks[j] == unvisited && visit(j)) {
+				marks[i] = visited
+				return true
+			}
+		}
+		marks[i] = visited
+		return false
+	}
+	for i := range dirs {
+		if marks[i] == unvisited && visit(i) {
+			problems = append(problems, ValidationError{
+				Field:   fmt.Sprintf("directories[%d].after", i),
+				Message: "after entries form a cycle",
+			})
+		}
+	}
+
+	return problems
+}
+
+// isNestedPath reports whether child is located below parent
+func isNestedPath(parent, child string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedMetricLabels are the variable labels of the agent's metrics,
+// which constant labels must not repeat
+var reservedMetricLabels = []string{"operation", "status", "direction", "scope", "kind"}
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*[a-z0-9]$`)
+
+// validateBucketName checks a bucket name against the S3 naming rules
+func validateBucketName(name string) error {
+	if len(name) < 3 || len(name) > 63 {
+		return fmt.Errorf("bucket name '%s' must be between 3 and 63 characters long", name)
+	}
+	if !bucketNamePattern.MatchString(name) {
+		return fmt.Errorf("bucket name '%s' may only contain lowercase letters, digits, dots and hyphens, and must begin and end with a letter or digit", name)
+	}
+	if strings.Contains(name, "..") {
+		return fmt.Errorf("bucket name '%s' must not contain consecutive dots", name)
+	}
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("bucket name '%s' must not be formatted as an IP address", name)
+	}
+	if strings.HasPrefix(name, "xn--") || strings.HasPrefix(name, "sthree-") {
+		return fmt.Errorf("bucket name '%s' uses a reserved prefix", name)
+	}
+	if strings.HasSuffix(name, "-s3alias") || strings.HasSuffix(name, "--ol-s3") {
+		return fmt.Errorf("bucket name '%s' uses a reserved suffix", name)
+	}
+	return nil
+}
+
+// WriteSchema writes every configuration key with its type and default value
+func WriteSchema(w io.Writer) error {
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	var rows [][3]string
+	collectSchema(defaults.Type(), defaults, "", &rows)
+
+	width := 0
+	for _, row := range rows {
+		if len(row[0]) > width {
+			width = len(row[0])
+		}
+	}
+
+	for _, row := range rows {
+		line := fmt.Sprintf("%-*s  %-10s", width, row[0], row[1])
+		if row[2] != "" {
+			line += "  default: " + row[2]
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectSchema walks a struct type, recording its yaml keys
+func collectSchema(t reflect.Type, v reflect.Value, prefix string, rows *[][3]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		var value reflect.Value
+		if v.IsValid() {
+			value = v.Field(i)
+		}
+
+		switch {
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			*rows = append(*rows, [3]string{key, "duration", schemaDefault(value)})
+		case field.Type.Kind() == reflect.Struct:
+			collectSchema(field.Type, value, key, rows)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			collectSchema(field.Type.Elem(), reflect.Value{}, key+"[]", rows)
+		case field.Type.Kind() == reflect.Slice:
+			*rows = append(*rows, [3]string{key, "[]" + field.Type.Elem().Kind().String(), schemaDefault(value)})
+		default:
+			*rows = append(*rows, [3]string{key, field.Type.Kind().String(), schemaDefault(value)})
+		}
+	}
+}
+
+// schemaDefault formats a default value for the schema dump
+func schemaDefault(v reflect.Value) string {
+	if !v.IsValid() || v.IsZero() {
+		return ""
+	}
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	if v.Kind() == reflect.Slice && v.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// loopbackHost reports whether a listen host only accepts connections
+// from this machine
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runAsProblem describes what is wrong with a "user" or "user:group" spec,
+// or returns "" when both exist
+func runAsProblem(spec string) string {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+	if userName == "" || (hasGroup && groupName == "") {
+		return fmt.Sprintf("'%s' must be \"user\" or \"user:group\"", spec)
+	}
+	if !knownOwner(userName, false) {
+		return fmt.Sprintf("unknown user '%s'", userName)
+	}
+	if hasGroup && !knownOwner(groupName, true) {
+		return fmt.Sprintf("unknown group '%s'", groupName)
+	}
+	return ""
+}
+
+// knownOwner reports whether name is a numeric ID or an existing user or
+// group name
+func knownOwner(name string, group bool) bool {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return true
+	}
+	var err error
+	if group {
+		_, err = user.LookupGroup(name)
+	} else {
+		_, err = user.Lookup(name)
+	}
+	return err == nil
+}
@@ -0,0 +1,265 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// validTestConfig returns a configuration without problems, syncing one
+// temporary directory
+func validTestConfig(t *testing.T) *Config {
+	t.Helper()
+	c := DefaultConfig()
+	c.AWS.Region = "us-east-1"
+	c.AWS.S3Bucket = "my-bucket"
+	c.AWS.AccessKeyID = "AKIDEXAMPLE"
+	c.AWS.SecretAccessKey = "secret"
+	c.Directories = []interfaces.SyncDirectory{{
+		LocalPath:  t.TempDir(),
+		RemotePath: "documents",
+		SyncMode:   "realtime",
+		Enabled:    true,
+	}}
+	return c
+}
+
+func TestCollectProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, c *Config)
+		want   []ValidationError
+	}{
+		{
+			name:   "valid",
+			modify: func(t *testing.T, c *Config) {},
+		},
+		{
+			name: "missing credentials",
+			modify: func(t *testing.T, c *Config) {
+				c.AWS.Region = ""
+				c.AWS.AccessKeyID = ""
+				c.AWS.SecretAccessKey = ""
+			},
+			want: []ValidationError{
+				{Field: "aws.region", Message: "AWS region is required"},
+				{Field: "aws.access_key_id", Message: "AWS access key ID is required"},
+				{Field: "aws.secret_access_key", Message: "AWS secret access key is required"},
+			},
+		},
+		{
+			name:   "invalid log format",
+			modify: func(t *testing.T, c *Config) { c.Logging.Format = "xml" },
+			want: []ValidationError{
+				{Field: "logging.format", Message: "invalid log format 'xml' (must be 'json', 'text', or 'journald')"},
+			},
+		},
+		{
+			name:   "no directories",
+			modify: func(t *testing.T, c *Config) { c.Directories = nil },
+			want: []ValidationError{
+				{Field: "directories", Message: "at least one directory must be configured for synchronization"},
+			},
+		},
+		{
+			name: "missing local path",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].LocalPath = filepath.Join(t.TempDir(), "missing")
+			},
+			want: []ValidationError{
+				{Field: "directories[0].local_path"},
+			},
+		},
+		{
+			name:   "invalid sync mode",
+			modify: func(t *testing.T, c *Config) { c.Directories[0].SyncMode = "hourly" },
+			want: []ValidationError{
+				{Field: "directories[0].sync_mode", Message: "invalid sync mode 'hourly' (must be 'realtime', 'scheduled', 'both', or 'backup')"},
+			},
+		},
+		{
+			name: "invalid schedule",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].SyncMode = "scheduled"
+				c.Directories[0].Schedule = "0 25 * * *"
+			},
+			want: []ValidationError{
+				{Field: "directories[0].schedule", Message: "invalid cron expression '0 25 * * *': hour field '25': value 25 out of range 0-23"},
+			},
+		},
+		{
+			name: "schedule that never fires",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].SyncMode = "scheduled"
+				c.Directories[0].Schedule = "0 0 30 2 *"
+			},
+			want: []ValidationError{
+				{Field: "directories[0].schedule", Message: "cron expression '0 0 30 2 *' never fires"},
+			},
+		},
+		{
+			name: "retention outside backup mode",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].Retention.KeepLast = 3
+			},
+			want: []ValidationError{
+				{Field: "directories[0].retention", Message: "retention only applies to backup mode"},
+			},
+		},
+		{
+			name: "negative retention",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].SyncMode = "backup"
+				c.Directories[0].Retention.KeepDaily = -1
+			},
+			want: []ValidationError{
+				{Field: "directories[0].retention", Message: "retention counts must not be negative"},
+			},
+		},
+		{
+			name: "archive in backup mode",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].SyncMode = "backup"
+				c.Directories[0].Archive.After = 24 * time.Hour
+			},
+			want: []ValidationError{
+				{Field: "directories[0].archive", Message: "archive mode does not apply to backup mode"},
+			},
+		},
+		{
+			name: "deletions without state",
+			modify: func(t *testing.T, c *Config) {
+				c.State.Path = ""
+				c.Directories[0].Deletions.Action = interfaces.DeletionDelete
+				c.Directories[0].Deletions.MaxPercent = 150
+			},
+			want: []ValidationError{
+				{Field: "directories[0].deletions.max_percent", Message: "must be between 0 and 100, got 150"},
+				{Field: "directories[0].deletions.action", Message: "requires state.path to be set"},
+			},
+		},
+		{
+			name: "rescan interval outside realtime mode",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].SyncMode = "scheduled"
+				c.Directories[0].RescanInterval = time.Hour
+			},
+			want: []ValidationError{
+				{Field: "directories[0].rescan_interval", Message: "rescan interval only applies to realtime mode"},
+			},
+		},
+		{
+			name: "replacement containing slash",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].KeyEncoding.Mode = "replace"
+				c.Directories[0].KeyEncoding.Replace = map[string]string{":": "/"}
+			},
+			want: []ValidationError{
+				{Field: "directories[0].key_encoding.replace", Message: `replacement of ":" must be non-empty and must not contain '/'`},
+			},
+		},
+		{
+			name: "file rules min above max",
+			modify: func(t *testing.T, c *Config) {
+				c.Directories[0].FileRules.MinSize = 10
+				c.Directories[0].FileRules.MaxSize = 5
+			},
+			want: []ValidationError{
+				{Field: "directories[0].file_rules.min_size", Message: "min_size 10 is larger than max_size 5"},
+			},
+		},
+		{
+			name: "nested directories",
+			modify: func(t *testing.T, c *Config) {
+				nested := c.Directories[0]
+				nested.LocalPath = t.TempDir()
+				nested.RemotePath = "nested"
+				c.Directories = append(c.Directories, nested)
+				c.Directories[0].LocalPath = filepath.Dir(nested.LocalPath)
+			},
+			want: []ValidationError{
+				{Field: "directories[1].local_path"},
+			},
+		},
+		{
+			name: "dashboard on all interfaces without TLS",
+			modify: func(t *testing.T, c *Config) {
+				c.Dashboard.Enabled = true
+				c.Dashboard.Password = "secret"
+				c.Dashboard.Listen = ":9091"
+			},
+			want: []ValidationError{
+				{Field: "dashboard.listen", Message: `listen address ":9091" is reachable from the network, set tls_cert and tls_key or bind to 127.0.0.1`},
+			},
+		},
+		{
+			name: "dashboard on all interfaces with TLS",
+			modify: func(t *testing.T, c *Config) {
+				dir := t.TempDir()
+				c.Dashboard.Enabled = true
+				c.Dashboard.Password = "secret"
+				c.Dashboard.Listen = "0.0.0.0:9091"
+				c.Dashboard.TLSCert = filepath.Join(dir, "cert.pem")
+				c.Dashboard.TLSKey = filepath.Join(dir, "key.pem")
+				for _, path := range []string{c.Dashboard.TLSCert, c.Dashboard.TLSKey} {
+					if err := os.WriteFile(path, []byte("pem"), 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+		{
+			name: "dashboard certificate without key",
+			modify: func(t *testing.T, c *Config) {
+				c.Dashboard.Enabled = true
+				c.Dashboard.Password = "secret"
+				c.Dashboard.Listen = "[::1]:9091"
+				c.Dashboard.TLSCert = filepath.Join(t.TempDir(), "missing.pem")
+			},
+			want: []ValidationError{
+				{Field: "dashboard.tls_key", Message: "tls_cert and tls_key must be set together"},
+				{Field: "dashboard.tls_cert"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validTestConfig(t)
+			tt.modify(t, c)
+			problems := c.collectProblems()
+			if len(problems) != len(tt.want) {
+				t.Fatalf("collectProblems() = %v, want %d problems", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := problems[i]
+				if got.Field != want.Field || (want.Message != "" && got.Message != want.Message) {
+					t.Errorf("problem %d = %s: %s, want %s: %s", i, got.Field, got.Message, want.Field, want.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+)
+
+// ErrUnavailable is returned when no agent is listening on the socket
+var ErrUnavailable = errors.New("control socket unavailable")
+
+// Client talks to a running agent over its control socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a client for the control socket at socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					conn, err := dialer.DialContext(ctx, "unix", socketPath)
+					if err != nil {
+						return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+					}
+					return conn, nil
+				},
+			},
+		},
+	}
+}
+
+// Stats returns the agent's sync statistics
+func (c *Client) Stats(ctx context.Context) (interfaces.SyncStats, error) {
+	var stats interfaces.SyncStats
+	err := c.do(ctx, http.MethodGet, "/v1/stats", nil, &stats)
+	return stats, err
+}
+
+// Health returns whether the agent can sync and which storage backend it
+// uses
+func (c *Client) Health(ctx context.Context) (interfaces.Health, error) {
+	var health interfaces.Health
+	err := c.do(ctx, http.MethodGet, "/v1/health", nil, &health)
+	return health, err
+}
+
+// Activity returns the agent's transfers in progress and recent failures
+func (c *Client) Activity(ctx context.Context) (interfaces.Activity, error) {
+	var activity interfaces.Activity
+	err := c.do(ctx, http.MethodGet, "/v1/activity", nil, &activity)
+	return activity, err
+}
+
+// Directories returns the status of the agent's sync directories
+func (c *Client) Directories(ctx context.Context) ([]interfaces.DirectoryStatus, error) {
+	var directories []interfaces.DirectoryStatus
+	err := c.do(ctx, http.MethodGet, "/v1/directories", nil, &directories)
+	return directories, err
+}
+
+// TriggerSync asks the agent to sync the directory at path, or every
+// directory when path is empty
+func (c *Client) TriggerSync(ctx context.Context, path string) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/sync", SyncRequest{Path: path}, &response)
+}
+
+// SyncNow asks the agent to sync the directory at path and waits until
+// its changes are queued for transfer
+func (c *Client) SyncNow(ctx context.Context, path string) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/sync", SyncRequest{Path: path, Wait: true}, &response)
+}
+
+// EnableDirectory asks the agent to resume syncing the directory at path
+func (c *Client) EnableDirectory(ctx context.Context, path string) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/directories/enable", DirectoryRequest{Path: path}, &response)
+}
+
+// DisableDirectory asks the agent to stop syncing the directory at path
+func (c *Client) DisableDirectory(ctx context.Context, path string) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/directories/disable", DirectoryRequest{Path: path}, &response)
+}
+
+// Quarantined returns the files the agent skips after repeated upload
+// failures
+func (c *Client) Quarantined(ctx context.Context) ([]state.QuarantinedFile, error) {
+	var files []state.QuarantinedFile
+	err := c.do(ctx, http.MethodGet, "/v1/quarantine", nil, &files)
+	return files, err
+}
+
+// RemoteOnly returns the remote objects with no local file of each
+// directory of the agent, with their keys when withKeys is set
+func (c *Client) RemoteOnly(ctx context.Context, withKeys bool) ([]interfaces.RemoteOnlyReport, error) {
+	var reports []interfaces.RemoteOnlyReport
+	err := c.do(ctx, http.MethodGet, "/v1/remote-only?keys="+strconv.FormatBool(withKeys), nil, &reports)
+	return reports, err
+}
+
+// SyncRuns returns the agent's recorded sync runs of a directory, or of
+// every directory when directory is empty, newest first. A positive limit
+// returns that many runs at most.
+func (c *Client) SyncRuns(ctx context.Context, directory string, limit int) ([]state.SyncRun, error) {
+	query := url.Values{}
+	if directory != "" {
+		query.Set("directory", directory)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	path := "/v1/runs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var runs []state.SyncRun
+	err := c.do(ctx, http.MethodGet, path, nil, &runs)
+	return runs, err
+}
+
+// ClearQuarantine asks the agent to release files from quarantine, or
+// every quarantined file when paths is empty
+func (c *Client) ClearQuarantine(ctx context.Context, paths []string) (int, error) {
+	var response ClearQuarantineResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/quarantine/clear", ClearQuarantineRequest{Paths: paths}, &response); err != nil {
+		return 0, err
+	}
+	return response.Cleared, nil
+}
+
+// Pause stops the agent from starting new transfers
+func (c *Client) Pause(ctx context.Context) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/pause", nil, &response)
+}
+
+// Resume lets the agent's transfers run again
+func (c *Client) Resume(ctx context.Context) error {
+	var response StatusResponse
+	return c.do(ctx, http.MethodPost, "/v1/resume", nil, &response)
+}
+
+// Hydrate asks the agent to download the archived file at path
+func (c *Client) Hydrate(ctx context.Context, path string) (string, error) {
+	var response HydrateResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/hydrate", HydrateRequest{Path: path}, &response); err != nil {
+		return "", err
+	}
+	return response.Path, nil
+}
+
+// SetConcurrency changes the agent's number of concurrent transfers
+func (c *Client) SetConcurrency(ctx context.Context, uploads, downloads int) error {
+	request := ConcurrencyRequest{Uploads: uploads, Downloads: downloads}
+	var response ConcurrencyRequest
+	return c.do(ctx, http.MethodPost, "/v1/concurrency", request, &response)
+}
+
+// Events calls handle with each sync event streamed by the agent, limited
+// to types when any are given, until ctx is cancelled, the stream ends or
+// handle returns an error
+func (c *Client) Events(ctx context.Context, types []string, handle func(interfaces.SyncEvent) error) error {
+	query := url.Values{"type": types}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://cloudawsync/v1/events?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("event stream failed with status %d", response.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event interfaces.SyncEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		if err := handle(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// do sends a request and decodes the JSON response into result
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, "http://cloudawsync"+path, &payload)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var failure errorResponse
+		if err := json.NewDecoder(response.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("control request failed with status %d", response.StatusCode)
+		}
+		return errors.New(failure.Error)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"embed"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// historyPoints is the number of samples kept for the history graphs
+const historyPoints = 360
+
+//go:embed web
+var webFiles embed.FS
+
+// DashboardOptions configures the web dashboard
+type DashboardOptions struct {
+	Listen   string        // host:port
+	Username string        // HTTP basic auth user
+	Password string        // HTTP basic auth password
+	History  time.Duration // span of the history graphs
+	CertFile string        // PEM certificate, serves HTTPS when set
+	KeyFile  string        // PEM private key of CertFile
+
+	// Clock times the history samples, defaulting to the system clock
+	Clock interfaces.Clock
+}
+
+// HistoryPoint is one sample of the agent's counters, from which the
+// dashboard draws transfer graphs
+type HistoryPoint struct {
+	Time            time.Time `json:"time"`
+	BytesSent       int64     `json:"bytes_sent"`
+	BytesReceived   int64     `json:"bytes_received"`
+	FilesUploaded   int64     `json:"files_uploaded"`
+	FilesDownloaded int64     `json:"files_downloaded"`
+	SyncErrors      int64     `json:"sync_errors"`
+	QueuedUploads   int       `json:"queued_uploads"`
+	ActiveTransfers int       `json:"active_transfers"`
+}
+
+// Dashboard serves a web UI and the control API over TCP, protected by
+// HTTP basic auth
+type Dashboard struct {
+	options DashboardOptions
+	api     *Server
+	clock   interfaces.Clock
+	logger  *zap.Logger
+	server  *http.Server
+	stop    chan struct{}
+	wg      sync.WaitGroup
+
+	history      []HistoryPoint // oldest first
+	historyMutex sync.Mutex
+	mutex        sync.Mutex
+}
+
+// NewDashboard creates a dashboard answering requests with handler
+func NewDashboard(options DashboardOptions, handler Handler, logger *zap.Logger) *Dashboard {
+	clock := options.Clock
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+	return &Dashboard{
+		options: options,
+		api:     &Server{handler: handler, logger: logger},
+		clock:   clock,
+		logger:  logger,
+	}
+}
+
+// Start begins serving the dashboard and sampling the history
+func (d *Dashboard) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.server != nil {
+		return fmt.Errorf("dashboard already running")
+	}
+
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		return fmt.Errorf("failed to load dashboard files: %w", err)
+	}
+
+	var tlsConfig *tls.Config
+	if d.options.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(d.options.CertFile, d.options.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load dashboard certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	listener, err := net.Listen("tcp", d.options.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on dashboard address: %w", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", http.StripPrefix("/api", d.api.routes()))
+	mux.HandleFunc("GET /api/history", d.handleHistory)
+	mux.Handle("/", http.FileServerFS(static))
+
+	d.server = newHTTPServer(d.protect(mux))
+	d.server.ReadHeaderTimeout = 10 * time.Second
+	d.stop = make(chan struct{})
+
+	d.wg.Add(1)
+	go d.sampleHistory()
+
+	go func() {
+		d.logger.Info("Starting dashboard",
+			zap.String("listen", listener.Addr().String()),
+			zap.Bool("tls", tlsConfig != nil))
+
+		if err := d.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			d.logger.Error("Dashboard server error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts the dashboard down
+func (d *Dashboard) Stop() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.server == nil {
+		return nil
+	}
+
+	close(d.stop)
+	d.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := d.server.Shutdown(ctx)
+	d.server = nil
+	return err
+}
+
+// protect requires HTTP basic auth on every request. Requests that change
+// state must carry a JSON body, which browsers do not send cross-site
+// without a CORS preflight.
+func (d *Dashboard) protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(d.options.Username)) == 1
+		passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(d.options.Password)) == 1
+		if !ok || !userMatch || !passwordMatch {
+			w.Header().Set("WWW-Authenticate", `Basic realm="CloudAWSync", charset="UTF-8"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "authentication required"})
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, errorResponse{Error: "requests must be application/json"})
+				return
+			}
+		}
+
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleHistory returns the sampled counters, oldest first
+func (d *Dashboard) handleHistory(w http.ResponseWriter, r *http.Request) {
+	d.historyMutex.Lock()
+	history := append([]HistoryPoint(nil), d.history...)
+	d.historyMutex.Unlock()
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+// sampleHistory records the agent's counters historyPoints times per
+// history span until the dashboard stops
+func (d *Dashboard) sampleHistory() {
+	defer d.wg.Done()
+
+	interval := max(d.options.History/historyPoints, time.Second)
+	ticker := d.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.recordHistory()
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// recordHistory appends one sample, dropping the oldest beyond the limit
+func (d *Dashboard) recordHistory() {
+	handler := d.api.handler
+	stats := handler.GetStats()
+	activity := handler.GetActivity()
+
+	d.historyMutex.Lock()
+	defer d.historyMutex.Unlock()
+
+	d.history = append(d.history, HistoryPoint{
+		Time:            d.clock.Now(),
+		BytesSent:       activity.BytesSent,
+		BytesReceived:   activity.BytesReceived,
+		FilesUploaded:   stats.FilesUploaded,
+		FilesDownloaded: stats.FilesDownloaded,
+		SyncErrors:      stats.SyncErrors,
+		QueuedUploads:   activity.QueuedUploads,
+		ActiveTransfers: len(activity.Transfers),
+	})
+	if len(d.history) > historyPoints {
+		d.history = d.history[len(d.history)-historyPoints:]
+	}
+}
@@ -0,0 +1,391 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package control
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	cloudawsyncv1 "CloudAWSync/proto/cloudawsync/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCHandler is implemented by the service to answer gRPC control requests
+type GRPCHandler interface {
+	Handler
+	AddDirectory(dir interfaces.SyncDirectory) error
+	RemoveDirectory(ctx context.Context, localPath string, deleteRemote bool) (int, error)
+}
+
+// GRPCServer serves the versioned gRPC control API over a unix socket
+type GRPCServer struct {
+	cloudawsyncv1.UnimplementedControlServiceServer
+
+	socketPath string
+	handler    GRPCHandler
+	logger     *zap.Logger
+	server     *grpc.Server
+	mutex      sync.Mutex
+}
+
+// NewGRPCServer creates a gRPC control server listening on socketPath
+func NewGRPCServer(socketPath string, handler GRPCHandler, logger *zap.Logger) *GRPCServer {
+	return &GRPCServer{
+		socketPath: socketPath,
+		handler:    handler,
+		logger:     logger,
+	}
+}
+
+// Start begins serving gRPC requests. A stale socket left by a previous
+// run is replaced.
+func (s *GRPCServer) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		return fmt.Errorf("gRPC control server already running")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on gRPC control socket: %w", err)
+	}
+	if err := os.Chmod(s.socketPath, 0660); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set gRPC control socket permissions: %w", err)
+	}
+
+	s.server = grpc.NewServer()
+	cloudawsyncv1.RegisterControlServiceServer(s.server, s)
+
+	server := s.server
+	go func() {
+		s.logger.Info("Starting gRPC control server", zap.String("socket", s.socketPath))
+
+		if err := server.Serve(listener); err != nil {
+			s.logger.Error("gRPC control server error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts the gRPC server down and removes its socket. Open event
+// streams are cancelled if they do not end within five seconds.
+func (s *GRPCServer) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		s.server.Stop()
+	}
+
+	s.server = nil
+	os.Remove(s.socketPath)
+
+	return nil
+}
+
+// GetStatus returns the sync statistics and the status of every directory
+func (s *GRPCServer) GetStatus(ctx context.Context, request *cloudawsyncv1.GetStatusRequest) (*cloudawsyncv1.GetStatusResponse, error) {
+	response := &cloudawsyncv1.GetStatusResponse{
+		Stats: statsToProto(s.handler.GetStats()),
+	}
+	for _, dir := range s.handler.GetDirectories() {
+		response.Directories = append(response.Directories, directoryStatusToProto(dir))
+	}
+	return response, nil
+}
+
+// GetActivity returns the transfers in progress and recent failures
+func (s *GRPCServer) GetActivity(ctx context.Context, request *cloudawsyncv1.GetActivityRequest) (*cloudawsyncv1.GetActivityResponse, error) {
+	activity := s.handler.GetActivity()
+
+	response := &cloudawsyncv1.GetActivityResponse{
+		QueuedUploads:   int32(activity.QueuedUploads),
+		QueuedDownloads: int32(activity.QueuedDownloads),
+		BytesSent:       activity.BytesSent,
+		BytesReceived:   activity.BytesReceived,
+	}
+	for _, transfer := range activity.Transfers {
+		response.Transfers = append(response.Transfers, &cloudawsyncv1.Transfer{
+			LocalPath:   transfer.LocalPath,
+			RemotePath:  transfer.RemotePath,
+			Direction:   transfer.Direction,
+			Size:        transfer.Size,
+			Transferred: transfer.Transferred,
+			StartedAt:   timestampToProto(transfer.StartedAt),
+		})
+	}
+	for _, failure := range activity.RecentErrors {
+		response.RecentErrors = append(response.RecentErrors, &cloudawsyncv1.TransferError{
+			LocalPath:  failure.LocalPath,
+			RemotePath: failure.RemotePath,
+			Direction:  failure.Direction,
+			Error:      failure.Error,
+			Timestamp:  timestampToProto(failure.Timestamp),
+		})
+	}
+	return response, nil
+}
+
+// TriggerSync starts a sync, waiting for its scan to finish when asked to
+func (s *GRPCServer) TriggerSync(ctx context.Context, request *cloudawsyncv1.TriggerSyncRequest) (*cloudawsyncv1.TriggerSyncResponse, error) {
+	if request.GetWait() {
+		if request.GetPath() == "" {
+			return nil, status.Error(codes.InvalidArgument, "path is required to wait for a sync")
+		}
+		if err := s.handler.SyncNow(ctx, request.GetPath()); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return &cloudawsyncv1.TriggerSyncResponse{}, nil
+	}
+
+	if err := s.handler.TriggerSync(request.GetPath()); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.TriggerSyncResponse{}, nil
+}
+
+// EnableDirectory resumes syncing a configured directory
+func (s *GRPCServer) EnableDirectory(ctx context.Context, request *cloudawsyncv1.EnableDirectoryRequest) (*cloudawsyncv1.EnableDirectoryResponse, error) {
+	if request.GetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	}
+	if err := s.handler.EnableDirectory(request.GetPath()); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.EnableDirectoryResponse{}, nil
+}
+
+// DisableDirectory stops syncing a configured directory
+func (s *GRPCServer) DisableDirectory(ctx context.Context, request *cloudawsyncv1.DisableDirectoryRequest) (*cloudawsyncv1.DisableDirectoryResponse, error) {
+	if request.GetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	}
+	if err := s.handler.DisableDirectory(request.GetPath()); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.DisableDirectoryResponse{}, nil
+}
+
+// PauseTransfers stops new transfers
+func (s *GRPCServer) PauseTransfers(ctx context.Context, request *cloudawsyncv1.PauseTransfersRequest) (*cloudawsyncv1.PauseTransfersResponse, error) {
+	if err := s.handler.PauseTransfers(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.PauseTransfersResponse{}, nil
+}
+
+// ResumeTransfers lets transfers run again
+func (s *GRPCServer) ResumeTransfers(ctx context.Context, request *cloudawsyncv1.ResumeTransfersRequest) (*cloudawsyncv1.ResumeTransfersResponse, error) {
+	if err := s.handler.ResumeTransfers(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.ResumeTransfersResponse{}, nil
+}
+
+// AddDirectory starts syncing a new directory and runs its first sync
+func (s *GRPCServer) AddDirectory(ctx context.Context, request *cloudawsyncv1.AddDirectoryRequest) (*cloudawsyncv1.AddDirectoryResponse, error) {
+	directory := request.GetDirectory()
+	if directory == nil {
+		return nil, status.Error(codes.InvalidArgument, "directory is required")
+	}
+
+	dir := interfaces.SyncDirectory{
+		LocalPath:  filepath.Clean(directory.GetLocalPath()),
+		RemotePath: directory.GetRemotePath(),
+		SyncMode:   interfaces.SyncMode(directory.GetSyncMode()),
+		Schedule:   directory.GetSchedule(),
+		Recursive:  directory.GetRecursive(),
+		Filters:    directory.GetFilters(),
+		Enabled:    true,
+	}
+	if err := s.handler.AddDirectory(dir); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.handler.TriggerSync(dir.LocalPath); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.AddDirectoryResponse{}, nil
+}
+
+// RemoveDirectory stops syncing a directory, optionally deleting its
+// remote objects
+func (s *GRPCServer) RemoveDirectory(ctx context.Context, request *cloudawsyncv1.RemoveDirectoryRequest) (*cloudawsyncv1.RemoveDirectoryResponse, error) {
+	if request.GetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "path is required")
+	}
+	deleted, err := s.handler.RemoveDirectory(ctx, request.GetPath(), request.GetDeleteRemote())
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &cloudawsyncv1.RemoveDirectoryResponse{DeletedObjects: int32(deleted)}, nil
+}
+
+// StreamEvents sends sync events, optionally limited to the requested
+// types, until the client disconnects
+func (s *GRPCServer) StreamEvents(request *cloudawsyncv1.StreamEventsRequest, stream grpc.ServerStreamingServer[cloudawsyncv1.StreamEventsResponse]) error {
+	events, cancel, err := s.handler.SubscribeEvents(eventBuffer)
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	defer cancel()
+
+	types := request.GetTypes()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if len(types) > 0 && !slices.Contains(types, string(event.Type)) {
+				continue
+			}
+			if err := stream.Send(&cloudawsyncv1.StreamEventsResponse{Event: eventToProto(event)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// DialGRPC connects to the gRPC control API at socketPath
+func DialGRPC(socketPath string) (cloudawsyncv1.ControlServiceClient, *grpc.ClientConn, error) {
+	conn, err := grpc.NewClient("unix://"+socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to gRPC control socket: %w", err)
+	}
+	return cloudawsyncv1.NewControlServiceClient(conn), conn, nil
+}
+
+// statsToProto converts sync statistics to their protobuf form
+func statsToProto(stats interfaces.SyncStats) *cloudawsyncv1.SyncStats {
+	var recentErrors []*cloudawsyncv1.SyncError
+	for _, failure := range stats.RecentErrors {
+		message := ""
+		if failure.Error != nil {
+			message = failure.Error.Error()
+		}
+		recentErrors = append(recentErrors, &cloudawsyncv1.SyncError{
+			Path:      failure.Path,
+			Operation: failure.Operation,
+			Error:     message,
+			Timestamp: timestampToProto(failure.Timestamp),
+			Retries:   int32(failure.Retries),
+		})
+	}
+
+	return &cloudawsyncv1.SyncStats{
+		FilesUploaded:     stats.FilesUploaded,
+		FilesDownloaded:   stats.FilesDownloaded,
+		FilesDeleted:      stats.FilesDeleted,
+		BytesUploaded:     stats.BytesUploaded,
+		BytesDownloaded:   stats.BytesDownloaded,
+		SyncErrors:        stats.SyncErrors,
+		TransferStalls:    stats.TransferStalls,
+		UnreadableFiles:   int64(stats.UnreadableFiles),
+		QuotaExceeded:     stats.QuotaExceeded,
+		Paused:            stats.Paused,
+		EstimatedCost:     stats.EstimatedCost,
+		LastSyncTime:      timestampToProto(stats.LastSyncTime),
+		ActiveDirectories: int32(stats.ActiveDirectories),
+		Offline:           stats.Offline,
+		OfflineQueued:     int32(stats.OfflineQueued),
+		RecentErrors:      recentErrors,
+		ArchivedObjects:   int32(stats.ArchivedObjects),
+		RestoresPending:   int32(stats.RestoresPending),
+		RemoteOnly:        int32(stats.RemoteOnly),
+	}
+}
+
+// directoryStatusToProto converts a directory status to its protobuf form
+func directoryStatusToProto(dir interfaces.DirectoryStatus) *cloudawsyncv1.DirectoryStatus {
+	return &cloudawsyncv1.DirectoryStatus{
+		LocalPath:  dir.LocalPath,
+		RemotePath: dir.RemotePath,
+		SyncMode:   string(dir.SyncMode),
+		Enabled:    dir.Enabled,
+		Syncing:    dir.Syncing,
+		LastSync:   timestampToProto(dir.LastSync),
+		LastError:  dir.LastError,
+
+		ArchivedObjects: int32(dir.ArchivedObjects),
+		RemoteOnly:      int32(dir.RemoteOnly),
+		RemoteOnlyBytes: dir.RemoteOnlyBytes,
+	}
+}
+
+// eventToProto converts a sync event to its protobuf form
+func eventToProto(event interfaces.SyncEvent) *cloudawsyncv1.Event {
+	return &cloudawsyncv1.Event{
+		Type:       string(event.Type),
+		Time:       timestampToProto(event.Time),
+		Directory:  event.Directory,
+		LocalPath:  event.LocalPath,
+		RemotePath: event.RemotePath,
+		Size:       event.Size,
+		Error:      event.Error,
+	}
+}
+
+// timestampToProto converts t, leaving the zero time unset
+func timestampToProto(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
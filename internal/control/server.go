@@ -0,0 +1,473 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// Handler is implemented by the service to answer control requests
+type Handler interface {
+	GetStats() interfaces.SyncStats
+	Health() interfaces.Health
+	GetActivity() interfaces.Activity
+	GetDirectories() []interfaces.DirectoryStatus
+	Hydrate(ctx context.Context, path string) (string, error)
+	SetConcurrency(uploads, downloads int) error
+	TriggerSync(localPath string) error
+	SyncNow(ctx context.Context, localPath string) error
+	EnableDirectory(localPath string) error
+	DisableDirectory(localPath string) error
+	Quarantined() ([]state.QuarantinedFile, error)
+	SyncRuns(directory string, limit int) ([]state.SyncRun, error)
+	RemoteOnly(ctx context.Context, withKeys bool) ([]interfaces.RemoteOnlyReport, error)
+	ClearQuarantine(paths []string) (int, error)
+	PauseTransfers() error
+	ResumeTransfers() error
+	SubscribeEvents(buffer int) (<-chan interfaces.SyncEvent, func(), error)
+}
+
+// eventBuffer is the number of events queued for each streaming client
+const eventBuffer = 256
+
+// HydrateRequest asks the agent to download an archived file
+type HydrateRequest struct {
+	Path string `json:"path"`
+}
+
+// HydrateResponse names the file restored by a hydrate request
+type HydrateResponse struct {
+	Path string `json:"path"`
+}
+
+// SyncRequest starts a sync of one directory, or of all when Path is
+// empty. With Wait the response is sent once the directory's changes are
+// queued, which requires a Path.
+type SyncRequest struct {
+	Path string `json:"path"`
+	Wait bool   `json:"wait,omitempty"`
+}
+
+// DirectoryRequest names a configured sync directory
+type DirectoryRequest struct {
+	Path string `json:"path"`
+}
+
+// ClearQuarantineRequest releases files from quarantine, or every
+// quarantined file when Paths is empty
+type ClearQuarantineRequest struct {
+	Paths []string `json:"paths"`
+}
+
+// ClearQuarantineResponse reports the number of files released
+type ClearQuarantineResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// StatusResponse acknowledges a request that returns no data
+type StatusResponse struct {
+	Status string `json:"status"`
+}
+
+// ConcurrencyRequest sets the number of concurrent transfers
+type ConcurrencyRequest struct {
+	Uploads   int `json:"uploads"`
+	Downloads int `json:"downloads"`
+}
+
+// errorResponse is returned with every failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the control API over a unix socket
+type Server struct {
+	socketPath string
+	handler    Handler
+	logger     *zap.Logger
+	server     *http.Server
+	mutex      sync.Mutex
+}
+
+// NewServer creates a control server listening on socketPath
+func NewServer(socketPath string, handler Handler, logger *zap.Logger) *Server {
+	return &Server{
+		socketPath: socketPath,
+		handler:    handler,
+		logger:     logger,
+	}
+}
+
+// Start begins serving control requests. A stale socket left by a previous
+// run is replaced.
+func (s *Server) Start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server != nil {
+		return fmt.Errorf("control server already running")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(s.socketPath, 0660); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+
+	s.server = newHTTPServer(s.routes())
+
+	go func() {
+		s.logger.Info("Starting control server", zap.String("socket", s.socketPath))
+
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Control server error", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// routes returns the handler serving the control API
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/stats", s.handleStats)
+	mux.HandleFunc("GET /v1/health", s.handleHealth)
+	mux.HandleFunc("GET /v1/activity", s.handleActivity)
+	mux.HandleFunc("GET /v1/directories", s.handleDirectories)
+	mux.HandleFunc("POST /v1/directories/enable", s.handleEnableDirectory)
+	mux.HandleFunc("POST /v1/directories/disable", s.handleDisableDirectory)
+	mux.HandleFunc("GET /v1/quarantine", s.handleQuarantine)
+	mux.HandleFunc("POST /v1/quarantine/clear", s.handleClearQuarantine)
+	mux.HandleFunc("GET /v1/remote-only", s.handleRemoteOnly)
+	mux.HandleFunc("GET /v1/runs", s.handleRuns)
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+	mux.HandleFunc("POST /v1/hydrate", s.handleHydrate)
+	mux.HandleFunc("POST /v1/concurrency", s.handleConcurrency)
+	mux.HandleFunc("POST /v1/sync", s.handleSync)
+	mux.HandleFunc("POST /v1/pause", s.handlePause)
+	mux.HandleFunc("POST /v1/resume", s.handleResume)
+	return mux
+}
+
+// Stop shuts the control server down and removes its socket
+func (s *Server) Stop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	s.server = nil
+	os.Remove(s.socketPath)
+
+	return err
+}
+
+// handleStats returns the current sync statistics
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.handler.GetStats())
+}
+
+// handleHealth reports whether the agent can sync and which storage
+// backend it uses
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.handler.Health())
+}
+
+// handleActivity returns the transfers in progress and recent failures
+func (s *Server) handleActivity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.handler.GetActivity())
+}
+
+// handleDirectories returns the status of every sync directory
+func (s *Server) handleDirectories(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.handler.GetDirectories())
+}
+
+// handleEvents streams sync events as server-sent events until the client
+// disconnects. Repeated type parameters limit the stream to those event
+// types.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "streaming not supported"})
+		return
+	}
+
+	events, cancel, err := s.handler.SubscribeEvents(eventBuffer)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+	defer cancel()
+
+	types := r.URL.Query()["type"]
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(types) > 0 && !slices.Contains(types, string(event.Type)) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// handleSync starts a sync, waiting for its scan to finish when asked to
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	var request SyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	if request.Wait {
+		if request.Path == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "path is required to wait for a sync"})
+			return
+		}
+		if err := s.handler.SyncNow(r.Context(), request.Path); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, StatusResponse{Status: "completed"})
+		return
+	}
+
+	if err := s.handler.TriggerSync(request.Path); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "started"})
+}
+
+// handleEnableDirectory resumes syncing a directory
+func (s *Server) handleEnableDirectory(w http.ResponseWriter, r *http.Request) {
+	s.handleDirectoryState(w, r, s.handler.EnableDirectory, "enabled")
+}
+
+// handleDisableDirectory stops syncing a directory
+func (s *Server) handleDisableDirectory(w http.ResponseWriter, r *http.Request) {
+	s.handleDirectoryState(w, r, s.handler.DisableDirectory, "disabled")
+}
+
+// handleDirectoryState applies change to the directory named in the
+// request, answering with state on success
+func (s *Server) handleDirectoryState(w http.ResponseWriter, r *http.Request, change func(string) error, state string) {
+	var request DirectoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if request.Path == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "path is required"})
+		return
+	}
+
+	if err := change(request.Path); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: state})
+}
+
+// handleQuarantine returns the files skipped after repeated upload failures
+func (s *Server) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	files, err := s.handler.Quarantined()
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// handleRemoteOnly reports the remote objects with no local file of each
+// directory, with their keys when the keys parameter is true
+func (s *Server) handleRemoteOnly(w http.ResponseWriter, r *http.Request) {
+	withKeys, _ := strconv.ParseBool(r.URL.Query().Get("keys"))
+	reports, err := s.handler.RemoteOnly(r.Context(), withKeys)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// handleRuns returns the recorded sync runs, newest first, limited to
+// the directory and number of runs given by the directory and limit
+// parameters
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := 0
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid limit %q", value)})
+			return
+		}
+	}
+	runs, err := s.handler.SyncRuns(query.Get("directory"), limit)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleClearQuarantine releases files from quarantine
+func (s *Server) handleClearQuarantine(w http.ResponseWriter, r *http.Request) {
+	var request ClearQuarantineRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	cleared, err := s.handler.ClearQuarantine(request.Paths)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, ClearQuarantineResponse{Cleared: cleared})
+}
+
+// handlePause stops new transfers
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if err := s.handler.PauseTransfers(); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "paused"})
+}
+
+// handleResume lets transfers run again
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if err := s.handler.ResumeTransfers(); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, StatusResponse{Status: "running"})
+}
+
+// handleHydrate downloads an archived file in place of its stub
+func (s *Server) handleHydrate(w http.ResponseWriter, r *http.Request) {
+	var request HydrateRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	if request.Path == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "path is required"})
+		return
+	}
+
+	path, err := s.handler.Hydrate(r.Context(), request.Path)
+	if err != nil {
+		s.logger.Warn("Hydrate request failed",
+			zap.String("path", request.Path),
+			zap.Error(err))
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, HydrateResponse{Path: path})
+}
+
+// handleConcurrency resizes the engine's transfer worker pools
+func (s *Server) handleConcurrency(w http.ResponseWriter, r *http.Request) {
+	var request ConcurrencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	if err := s.handler.SetConcurrency(request.Uploads, request.Downloads); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, request)
+}
+
+// newHTTPServer creates an HTTP server whose request contexts are
+// cancelled when it shuts down, ending open event streams
+func newHTTPServer(handler http.Handler) *http.Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	server.RegisterOnShutdown(cancel)
+	return server
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
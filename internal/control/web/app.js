@@ -0,0 +1,236 @@
+// CloudAWSync dashboard. Polls the control API served under /api and
+// renders the agent's state; no external dependencies.
+"use strict";
+
+const refreshInterval = 2000;
+let paused = false;
+let previous = null; // last activity sample, for bandwidth
+
+const $ = (id) => document.getElementById(id);
+
+function formatBytes(bytes) {
+  const unit = 1024;
+  if (bytes < unit) {
+    return bytes + " B";
+  }
+  let exp = 0;
+  let value = bytes / unit;
+  while (value >= unit && exp < 5) {
+    value /= unit;
+    exp++;
+  }
+  return value.toFixed(1) + " " + "KMGTPE"[exp] + "B";
+}
+
+function formatTime(value) {
+  const time = new Date(value);
+  if (time.getFullYear() <= 1) {
+    return "never";
+  }
+  return time.toLocaleString();
+}
+
+async function api(method, path, body) {
+  const options = { method, headers: {} };
+  if (method !== "GET") {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body || {});
+  }
+  const response = await fetch("api" + path, options);
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || response.statusText);
+  }
+  return data;
+}
+
+function showMessage(text) {
+  const message = $("message");
+  message.textContent = text;
+  message.hidden = !text;
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function fillTable(tbody, items, columns, render) {
+  tbody.replaceChildren();
+  if (!items || items.length === 0) {
+    cell(tbody.insertRow(), "none", "empty").colSpan = columns;
+    return;
+  }
+  for (const item of items) {
+    render(tbody.insertRow(), item);
+  }
+}
+
+function renderState(stats) {
+  paused = stats.Paused;
+  const state = $("state");
+  state.textContent = paused ? "paused" : "running";
+  state.className = "badge " + (paused ? "paused" : "running");
+  if (stats.Offline) {
+    state.textContent = "offline, " + stats.OfflineQueued + " queued";
+    state.className = "badge paused";
+  }
+  $("pause").textContent = paused ? "Resume" : "Pause";
+
+  $("uploaded").textContent = stats.FilesUploaded + " files";
+  $("downloaded").textContent = stats.FilesDownloaded + " files";
+  $("errors").textContent = stats.SyncErrors;
+}
+
+function renderActivity(activity) {
+  $("queued").textContent = activity.QueuedUploads + " / " + activity.QueuedDownloads;
+
+  const now = Date.now();
+  if (previous) {
+    const seconds = (now - previous.at) / 1000;
+    const up = Math.max(activity.BytesSent - previous.sent, 0) / seconds;
+    const down = Math.max(activity.BytesReceived - previous.received, 0) / seconds;
+    $("rate-up").textContent = formatBytes(Math.round(up)) + "/s";
+    $("rate-down").textContent = formatBytes(Math.round(down)) + "/s";
+  }
+  previous = { at: now, sent: activity.BytesSent, received: activity.BytesReceived };
+
+  fillTable($("transfers"), activity.Transfers, 4, (row, transfer) => {
+    cell(row, transfer.Direction === "download" ? "↓" : "↑");
+    cell(row, transfer.LocalPath || transfer.RemotePath);
+    const progress = document.createElement("progress");
+    progress.max = Math.max(transfer.Size, 1);
+    progress.value = transfer.Size > 0 ? transfer.Transferred : 0;
+    row.insertCell().appendChild(progress);
+    cell(row, formatBytes(transfer.Transferred) + " / " + formatBytes(transfer.Size));
+  });
+
+  const errors = (activity.RecentErrors || []).slice().reverse();
+  fillTable($("recent-errors"), errors, 4, (row, failure) => {
+    cell(row, formatTime(failure.Timestamp));
+    cell(row, failure.Direction);
+    cell(row, failure.LocalPath || failure.RemotePath);
+    cell(row, failure.Error, "status-error");
+  });
+}
+
+function renderDirectories(directories) {
+  fillTable($("directories"), directories, 6, (row, dir) => {
+    cell(row, dir.LocalPath);
+    cell(row, dir.RemotePath);
+    cell(row, dir.SyncMode);
+    if (!dir.Enabled) {
+      cell(row, "disabled");
+    } else if (dir.Syncing) {
+      cell(row, "syncing", "status-syncing");
+    } else if (dir.LastError) {
+      cell(row, "failed: " + dir.LastError, "status-error");
+    } else {
+      cell(row, dir.LastSync && formatTime(dir.LastSync) !== "never" ? "ok" : "pending", "status-ok");
+    }
+    cell(row, formatTime(dir.LastSync));
+
+    const button = document.createElement("button");
+    button.type = "button";
+    button.textContent = "Sync";
+    button.disabled = !dir.Enabled;
+    button.addEventListener("click", () => run(api("POST", "/v1/sync", { path: dir.LocalPath })));
+
+    const toggle = document.createElement("button");
+    toggle.type = "button";
+    toggle.textContent = dir.Enabled ? "Disable" : "Enable";
+    const action = dir.Enabled ? "/v1/directories/disable" : "/v1/directories/enable";
+    toggle.addEventListener("click", () => run(api("POST", action, { path: dir.LocalPath })));
+
+    const actions = row.insertCell();
+    actions.append(button, " ", toggle);
+  });
+}
+
+// drawSeries plots two series of per-interval values on a canvas
+function drawSeries(canvas, first, second, format) {
+  const context = canvas.getContext("2d");
+  const width = canvas.width;
+  const height = canvas.height;
+  const styles = getComputedStyle(document.documentElement);
+  context.clearRect(0, 0, width, height);
+
+  const top = Math.max(1, ...first, ...second);
+  context.fillStyle = styles.getPropertyValue("--muted");
+  context.font = "11px system-ui, sans-serif";
+  context.fillText(format(top), 4, 12);
+
+  const plot = (values, color) => {
+    if (values.length < 2) {
+      return;
+    }
+    context.strokeStyle = color;
+    context.lineWidth = 2;
+    context.beginPath();
+    values.forEach((value, i) => {
+      const x = (i / (values.length - 1)) * width;
+      const y = height - 4 - (value / top) * (height - 20);
+      if (i === 0) {
+        context.moveTo(x, y);
+      } else {
+        context.lineTo(x, y);
+      }
+    });
+    context.stroke();
+  };
+  plot(first, styles.getPropertyValue("--accent"));
+  plot(second, styles.getPropertyValue("--accent-2"));
+}
+
+function renderHistory(history) {
+  const rates = (field) => history.slice(1).map((point, i) => {
+    const seconds = (new Date(point.time) - new Date(history[i].time)) / 1000;
+    return seconds > 0 ? Math.max(point[field] - history[i][field], 0) / seconds : 0;
+  });
+  drawSeries($("bandwidth-graph"), rates("bytes_sent"), rates("bytes_received"),
+    (value) => formatBytes(Math.round(value)) + "/s");
+
+  const uploads = history.slice(1).map((point, i) => Math.max(point.files_uploaded - history[i].files_uploaded, 0));
+  const queued = history.slice(1).map((point) => point.queued_uploads);
+  drawSeries($("files-graph"), uploads, queued, (value) => String(Math.round(value)));
+}
+
+async function refresh() {
+  try {
+    const [stats, activity, directories, history] = await Promise.all([
+      api("GET", "/v1/stats"),
+      api("GET", "/v1/activity"),
+      api("GET", "/v1/directories"),
+      api("GET", "/history"),
+    ]);
+    renderState(stats);
+    renderActivity(activity);
+    renderDirectories(directories);
+    renderHistory(history);
+    showMessage("");
+  } catch (err) {
+    $("state").textContent = "offline";
+    $("state").className = "badge offline";
+    showMessage("Failed to reach the agent: " + err.message);
+  }
+}
+
+async function run(request) {
+  try {
+    await request;
+    showMessage("");
+  } catch (err) {
+    showMessage(err.message);
+  }
+  refresh();
+}
+
+$("sync-all").addEventListener("click", () => run(api("POST", "/v1/sync", { path: "" })));
+$("pause").addEventListener("click", () => run(api("POST", paused ? "/v1/resume" : "/v1/pause")));
+
+refresh();
+setInterval(refresh, refreshInterval);
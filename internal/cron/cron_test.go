@@ -0,0 +1,64 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// A Sunday
+	from := time.Date(2026, 3, 15, 12, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{expr: "* * * * *", want: time.Date(2026, 3, 15, 12, 31, 0, 0, time.UTC)},
+		{expr: "*/15 * * * *", want: time.Date(2026, 3, 15, 12, 45, 0, 0, time.UTC)},
+		{expr: "30 12 * * *", want: time.Date(2026, 3, 16, 12, 30, 0, 0, time.UTC)},
+		{expr: "0 2 * * *", want: time.Date(2026, 3, 16, 2, 0, 0, 0, time.UTC)},
+		{expr: "@hourly", want: time.Date(2026, 3, 15, 13, 0, 0, 0, time.UTC)},
+		{expr: "@monthly", want: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "@yearly", want: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 9 * * mon-fri", want: time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{expr: "0 0 * * 7", want: time.Date(2026, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{expr: "5/20 * * * *", want: time.Date(2026, 3, 15, 12, 45, 0, 0, time.UTC)},
+		{expr: "0 0 1,15 * *", want: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		// A restricted day of month or day of week is enough
+		{expr: "0 0 20 * mon", want: time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// recentErrorLimit is the number of failed transfers kept for Activity
+const recentErrorLimit = 20
+
+// recentSyncErrorLimit is the number of errors kept for GetStats
+const recentSyncErrorLimit = 100
+
+// activeTransfer is a transfer attempt in progress
+type activeTransfer struct {
+	status      interfaces.TransferStatus
+	transferred *atomic.Int64
+}
+
+// activityTracker records the transfers in progress and recent failures
+type activityTracker struct {
+	transfers map[uint64]*activeTransfer
+	nextID    uint64
+	sent      int64 // bytes of finished upload attempts
+	received  int64 // bytes of finished download attempts
+	errors    []interfaces.TransferError
+	mutex     sync.Mutex
+}
+
+// trackTransfer registers a transfer attempt whose progress is counted in
+// transferred. The returned function must be called when it ends.
+func (e *Engine) trackTransfer(task syncTask, direction string, size int64, transferred *atomic.Int64) func() {
+	a := &e.activity
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.transfers == nil {
+		a.transfers = make(map[uint64]*activeTransfer)
+	}
+	id := a.nextID
+	a.nextID++
+	a.transfers[id] = &activeTransfer{
+		status: interfaces.TransferStatus{
+			LocalPath:  task.localPath,
+			RemotePath: task.remotePath,
+			Direction:  direction,
+			Size:       size,
+			StartedAt:  e.clock.Now(),
+		},
+		transferred: transferred,
+	}
+
+	return func() {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		delete(a.transfers, id)
+		if direction == "upload" {
+			a.sent += transferred.Load()
+		} else {
+			a.received += transferred.Load()
+		}
+	}
+}
+
+// recordTransferError keeps a failed transfer for Activity, dropping the
+// oldest once recentErrorLimit is reached
+func (e *Engine) recordTransferError(task syncTask, direction string, err error) {
+	a := &e.activity
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.errors = append(a.errors, interfaces.TransferError{
+		LocalPath:  task.localPath,
+		RemotePath: task.remotePath,
+		Direction:  direction,
+		Error:      err.Error(),
+		Timestamp:  e.clock.Now(),
+	})
+	if len(a.errors) > recentErrorLimit {
+		a.errors = a.errors[len(a.errors)-recentErrorLimit:]
+	}
+}
+
+// Activity returns the transfers in progress, queue depths, bytes moved and
+// the most recent failed transfers
+func (e *Engine) Activity() interfaces.Activity {
+	a := &e.activity
+	a.mutex.Lock()
+	activity := interfaces.Activity{
+		Transfers:       make([]interfaces.TransferStatus, 0, len(a.transfers)),
+		QueuedUploads:   e.uploadQueue.len(),
+		QueuedDownloads: len(e.downloadQueue),
+		BytesSent:       a.sent,
+		BytesReceived:   a.received,
+		RecentErrors:    append([]interfaces.TransferError(nil), a.errors...),
+	}
+	for _, transfer := range a.transfers {
+		status := transfer.status
+		status.Transferred = transfer.transferred.Load()
+		if status.Direction == "upload" {
+			activity.BytesSent += status.Transferred
+		} else {
+			activity.BytesReceived += status.Transferred
+		}
+		activity.Transfers = append(activity.Transfers, status)
+	}
+	a.mutex.Unlock()
+
+	sort.Slice(activity.Transfers, func(i, j int) bool {
+		return activity.Transfers[i].StartedAt.Before(activity.Transfers[j].StartedAt)
+	})
+	return activity
+}
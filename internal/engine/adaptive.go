@@ -0,0 +1,217 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// latencyAlpha weights new samples in the smoothed transfer latency
+	latencyAlpha = 0.2
+
+	// latencyRiseFactor is how far smoothed latency may rise above its
+	// baseline before concurrency is reduced
+	latencyRiseFactor = 2.0
+
+	// baselineDecay lets the latency baseline drift upwards after each
+	// sample so it follows lasting changes in the network
+	baselineDecay = 1.01
+
+	// decreaseCooldown spaces out reductions so one congestion event
+	// halves concurrency only once
+	decreaseCooldown = 5 * time.Second
+)
+
+// aimdController adjusts the number of workers of one transfer direction
+// with additive increase and multiplicative decrease
+type aimdController struct {
+	direction    string
+	min          int
+	max          int
+	limit        int
+	successes    int
+	latency      float64 // smoothed seconds per transfer unit
+	baseline     float64 // lowest recent smoothed latency
+	lastDecrease time.Time
+	mutex        sync.Mutex
+}
+
+// newAIMDController creates a controller starting at the minimum limit
+func newAIMDController(direction string, minimum, maximum int) *aimdController {
+	return &aimdController{
+		direction: direction,
+		min:       minimum,
+		max:       maximum,
+		limit:     minimum,
+	}
+}
+
+// current returns the current worker limit
+func (c *aimdController) current() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.limit
+}
+
+// setMax changes the ceiling, clamping the current limit
+func (c *aimdController) setMax(maximum int) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.max = maximum
+	if c.min > c.max {
+		c.min = c.max
+	}
+	if c.limit > c.max {
+		c.limit = c.max
+	}
+	return c.limit
+}
+
+// observe feeds the outcome of one transfer attempt into the controller and
+// returns the new limit and the reason it changed, if it did
+func (c *aimdController) observe(now time.Time, duration time.Duration, size int64, err error) (int, string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		if isThrottled(err) {
+			return c.decrease(now, "throttled by provider")
+		}
+		return c.limit, ""
+	}
+
+	// Normalize by size so large files do not look like rising latency
+	units := 1 + float64(size)/(1024*1024)
+	sample := duration.Seconds() / units
+	if c.latency == 0 {
+		c.latency = sample
+	} else {
+		c.latency = latencyAlpha*sample + (1-latencyAlpha)*c.latency
+	}
+	if c.baseline == 0 || c.latency < c.baseline {
+		c.baseline = c.latency
+	} else {
+		c.baseline = min(c.baseline*baselineDecay, c.latency)
+	}
+
+	if c.latency > c.baseline*latencyRiseFactor {
+		return c.decrease(now, "latency rising")
+	}
+
+	// Grow by one worker after a full window of successful transfers
+	c.successes++
+	if c.successes >= c.limit && c.limit < c.max {
+		c.successes = 0
+		c.limit++
+		return c.limit, "transfers healthy"
+	}
+	return c.limit, ""
+}
+
+// decrease halves the limit unless it was reduced very recently. The
+// caller holds c.mutex.
+func (c *aimdController) decrease(now time.Time, reason string) (int, string) {
+	if now.Sub(c.lastDecrease) < decreaseCooldown || c.limit <= c.min {
+		return c.limit, ""
+	}
+	c.lastDecrease = now
+	c.successes = 0
+	c.limit = max(c.limit/2, c.min)
+	return c.limit, reason
+}
+
+// isThrottled reports whether err is the provider asking to slow down
+func isThrottled(err error) bool {
+	return errors.Is(err, interfaces.ErrThrottled)
+}
+
+// SetAdaptiveConcurrency enables AIMD scaling of transfer workers between
+// minimum and the configured maximum concurrency. Workers start at the
+// minimum and grow while transfers succeed with stable latency.
+func (e *Engine) SetAdaptiveConcurrency(minimum int) error {
+	if minimum < 1 {
+		return fmt.Errorf("minimum concurrency must be at least 1, got %d", minimum)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.uploadAIMD = newAIMDController("upload", min(minimum, e.maxConcurrentUploads), e.maxConcurrentUploads)
+	e.downloadAIMD = newAIMDController("download", min(minimum, e.maxConcurrentDownloads), e.maxConcurrentDownloads)
+	return nil
+}
+
+// workerTargets returns the number of upload and download workers to run,
+// following the adaptive limits when enabled and reduced while resource
+// budgets are nearly used. The caller holds e.mutex.
+func (e *Engine) workerTargets() (uploads, downloads int) {
+	uploads, downloads = e.maxConcurrentUploads, e.maxConcurrentDownloads
+	if e.uploadAIMD != nil {
+		uploads = e.uploadAIMD.current()
+	}
+	if e.downloadAIMD != nil {
+		downloads = e.downloadAIMD.current()
+	}
+	return e.resourceScaled(uploads), e.resourceScaled(downloads)
+}
+
+// observeTransfer reports a transfer attempt to the adaptive controller of
+// its direction and resizes the worker pool when the limit changes
+func (e *Engine) observeTransfer(direction string, duration time.Duration, size int64, err error) {
+	e.mutex.RLock()
+	controller, pool := e.uploadAIMD, e.uploadPool
+	if direction == "download" {
+		controller, pool = e.downloadAIMD, e.downloadPool
+	}
+	running := e.running
+	e.mutex.RUnlock()
+
+	if controller == nil || !running {
+		return
+	}
+
+	previous := pool.size()
+	limit, reason := controller.observe(e.clock.Now(), duration, size, err)
+	e.mutex.RLock()
+	limit = e.resourceScaled(limit)
+	e.mutex.RUnlock()
+	if reason == "" || limit == previous {
+		return
+	}
+
+	pool.resize(limit)
+	e.metrics.RecordConcurrency(direction, limit)
+	e.logger.Info("Adjusted transfer concurrency",
+		zap.String("direction", direction),
+		zap.Int("from", previous),
+		zap.Int("to", limit),
+		zap.String("reason", reason))
+}
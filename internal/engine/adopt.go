@@ -0,0 +1,127 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"os"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// adoptedUnchanged reports whether a recorded object still describes both
+// the local file and the remote object. Adopted objects are older than the
+// local files they match, so the modification time comparison of a full
+// sync would upload them again.
+func (e *Engine) adoptedUnchanged(localInfo os.FileInfo, remoteInfo interfaces.FileInfo) bool {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return false
+	}
+
+	record, ok := store.Get(remoteInfo.Key)
+	if !ok || record.LocalSize() != localInfo.Size() || !record.ModTime.Equal(localInfo.ModTime()) {
+		return false
+	}
+	return record.Size == remoteInfo.Size &&
+		(remoteInfo.MD5Hash == "" || remoteInfo.MD5Hash == record.MD5Hash)
+}
+
+// sameContent hashes the local file and reports whether the remote object
+// holds the same content. The listed ETag is compared first and the stored
+// checksum metadata when the ETag is not a plain MD5 hash, as for
+// multipart or KMS encrypted uploads.
+func (e *Engine) sameContent(ctx context.Context, dir interfaces.SyncDirectory, localPath string, remoteInfo interfaces.FileInfo) (string, bool) {
+	localHash, err := e.hashFile(localPath)
+	if err != nil {
+		e.logger.Debug("Failed to hash file for comparison",
+			zap.String("local_path", localPath),
+			errorField(err))
+		return "", false
+	}
+	if localHash == remoteInfo.MD5Hash {
+		return localHash, true
+	}
+
+	opCtx, cancel := e.operationContext(ctx)
+	metadata, err := e.provider.GetMetadata(opCtx, remoteInfo.Key)
+	cancel()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+	if err != nil {
+		e.logger.Debug("Failed to get remote checksum for comparison",
+			zap.String("remote_path", remoteInfo.Key),
+			errorField(err))
+		return localHash, false
+	}
+	return localHash, localHash == metadata.MD5Hash
+}
+
+// adoptRemote records an existing remote object the agent has no state for
+// when it holds the same content as the local file, so objects uploaded by
+// other tools are not uploaded again. It reports whether the object was
+// adopted.
+func (e *Engine) adoptRemote(ctx context.Context, dir interfaces.SyncDirectory, localPath string, localInfo os.FileInfo, remoteInfo interfaces.FileInfo) bool {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil || localInfo.Size() != remoteInfo.Size {
+		return false
+	}
+	if _, ok := store.Get(remoteInfo.Key); ok {
+		return false
+	}
+
+	localHash, same := e.sameContent(ctx, dir, localPath, remoteInfo)
+	if !same {
+		return false
+	}
+
+	// The file must not have changed while it was being hashed
+	if info, err := e.fs.Stat(localPath); err != nil || !info.ModTime().Equal(localInfo.ModTime()) || info.Size() != localInfo.Size() {
+		return false
+	}
+
+	store.Put(state.ObjectRecord{
+		Key:        remoteInfo.Key,
+		LocalPath:  localPath,
+		Size:       localInfo.Size(),
+		MD5Hash:    localHash,
+		ModTime:    localInfo.ModTime(),
+		UploadedAt: remoteInfo.ModTime,
+
+		PartialHash: e.partialHash(dir, localPath, localInfo),
+	})
+
+	e.mutex.Lock()
+	e.stats.FilesAdopted++
+	e.mutex.Unlock()
+
+	e.logger.Debug("Adopted remote object",
+		zap.String("local_path", localPath),
+		zap.String("remote_path", remoteInfo.Key))
+	return true
+}
@@ -0,0 +1,271 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// StubSuffix is appended to the name of a file replaced by an archive stub
+const StubSuffix = ".cloudawsync-stub"
+
+// Stub is the content of a stub file left in place of an archived file
+type Stub struct {
+	Key        string      `json:"key"`
+	Size       int64       `json:"size"`
+	MD5Hash    string      `json:"md5_hash"`
+	ModTime    time.Time   `json:"mod_time"`
+	Mode       os.FileMode `json:"mode"`
+	ArchivedAt time.Time   `json:"archived_at"`
+}
+
+// ArchiveReport describes the files selected for archiving in a directory
+type ArchiveReport struct {
+	LocalPath string
+	DryRun    bool
+	Archived  []string
+	Skipped   map[string]string // path -> reason the file was kept
+	Bytes     int64
+}
+
+// IsStub reports whether path names an archive stub
+func IsStub(path string) bool {
+	return strings.HasSuffix(path, StubSuffix)
+}
+
+// ReadStub reads an archive stub file
+func ReadStub(path string) (*Stub, error) {
+	return readStub(utils.OSFileSystem{}, path)
+}
+
+// readStub reads an archive stub file from fs
+func readStub(fs interfaces.FileSystem, path string) (*Stub, error) {
+	data, err := readFile(fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stub: %w", err)
+	}
+
+	var stub Stub
+	if err := json.Unmarshal(data, &stub); err != nil {
+		return nil, fmt.Errorf("failed to parse stub: %w", err)
+	}
+	if stub.Key == "" {
+		return nil, fmt.Errorf("stub %s has no remote key", path)
+	}
+	return &stub, nil
+}
+
+// ArchiveDirectory removes local files older than the directory's archive
+// age once their upload is confirmed by comparing the remote checksum with
+// a fresh local hash. Files are replaced with stubs when configured. Nothing
+// is removed when dryRun is set or the directory's policy is dry-run.
+func (e *Engine) ArchiveDirectory(ctx context.Context, dir interfaces.SyncDirectory, dryRun bool) (*ArchiveReport, error) {
+	policy := dir.Archive
+	report := &ArchiveReport{
+		LocalPath: dir.LocalPath,
+		DryRun:    dryRun || policy.DryRun,
+		Skipped:   make(map[string]string),
+	}
+	if policy.After <= 0 {
+		return report, nil
+	}
+
+	cutoff := e.clock.Now().Add(-policy.After)
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if !e.shouldSyncFile(localPath, dir) || !info.Mode().IsRegular() ||
+			e.excludedByRules(info, dir.FileRules) != "" {
+			return nil
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		if e.cachedHydration(localPath, info) {
+			// Hydrated files leave through cache eviction instead
+			return nil
+		}
+
+		remotePath, _, err := e.remoteKey(dir, localPath)
+		if err != nil {
+			report.Skipped[localPath] = err.Error()
+			return nil
+		}
+		md5Hash, err := e.confirmUploaded(ctx, dir, localPath, remotePath, info)
+		if err != nil {
+			report.Skipped[localPath] = err.Error()
+			return nil
+		}
+
+		report.Archived = append(report.Archived, localPath)
+		report.Bytes += info.Size()
+
+		entry := audit.Entry{
+			Action:    "archive",
+			Key:       remotePath,
+			LocalPath: localPath,
+			Reason:    fmt.Sprintf("older than %s", policy.After),
+			DryRun:    report.DryRun,
+		}
+		if !report.DryRun {
+			if err := e.archiveFile(localPath, remotePath, md5Hash, info, policy.Stub); err != nil {
+				entry.SetError(err)
+				report.Archived = report.Archived[:len(report.Archived)-1]
+				report.Bytes -= info.Size()
+				report.Skipped[localPath] = err.Error()
+				e.logger.Error("Failed to archive local file",
+					zap.String("local_path", localPath),
+					errorField(err))
+			}
+		}
+		e.audit(ctx, entry)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(report.Archived)
+
+	e.logger.Info("Archive pass completed",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("archived", len(report.Archived)),
+		zap.Int64("bytes", report.Bytes),
+		zap.Int("skipped", len(report.Skipped)),
+		zap.Bool("dry_run", report.DryRun))
+
+	return report, nil
+}
+
+// confirmUploaded checks that the remote object holds exactly the local
+// file's content, returning the verified hash
+func (e *Engine) confirmUploaded(ctx context.Context, dir interfaces.SyncDirectory, localPath, remotePath string, info os.FileInfo) (string, error) {
+	e.inFlightMutex.Lock()
+	_, busy := e.inFlight[localPath]
+	e.inFlightMutex.Unlock()
+	if busy {
+		return "", fmt.Errorf("upload in progress")
+	}
+
+	opCtx, cancel := e.operationContext(ctx)
+	metadata, err := e.provider.GetMetadata(opCtx, remotePath)
+	cancel()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+	if err != nil {
+		return "", fmt.Errorf("remote object not confirmed: %w", err)
+	}
+	if metadata.MD5Hash == "" {
+		return "", fmt.Errorf("remote object has no checksum")
+	}
+	if metadata.Size != info.Size() {
+		return "", fmt.Errorf("remote size %d differs from local size %d", metadata.Size, info.Size())
+	}
+
+	localHash, err := e.hashFile(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash local file: %w", err)
+	}
+	if localHash != metadata.MD5Hash {
+		return "", fmt.Errorf("remote checksum differs from local content")
+	}
+
+	// The file must not have changed while it was being hashed
+	current, err := e.fs.Lstat(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat local file: %w", err)
+	}
+	if current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+		return "", fmt.Errorf("file changed during verification")
+	}
+
+	return localHash, nil
+}
+
+// archiveFile writes a stub if requested and removes the local file
+func (e *Engine) archiveFile(localPath, remotePath, md5Hash string, info os.FileInfo, stub bool) error {
+	if stub {
+		data, err := json.MarshalIndent(Stub{
+			Key:        remotePath,
+			Size:       info.Size(),
+			MD5Hash:    md5Hash,
+			ModTime:    info.ModTime(),
+			Mode:       info.Mode().Perm(),
+			ArchivedAt: e.clock.Now().UTC(),
+		}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode stub: %w", err)
+		}
+		if err := e.writeFileAtomic(localPath+StubSuffix, data, 0644); err != nil {
+			return fmt.Errorf("failed to write stub: %w", err)
+		}
+		if dir, ok := e.directoryFor(localPath); ok {
+			e.chownAs(dir.LocalPath, localPath+StubSuffix)
+		}
+	}
+
+	if err := e.fs.Remove(localPath); err != nil {
+		if stub {
+			e.fs.Remove(localPath + StubSuffix)
+		}
+		return fmt.Errorf("failed to remove local file: %w", err)
+	}
+
+	e.markArchived(remotePath, md5Hash, info)
+	return nil
+}
+
+// markArchived records in the state store that a remote object is the only
+// copy of an archived file, protecting it from unseen-file retention
+func (e *Engine) markArchived(remotePath, md5Hash string, info os.FileInfo) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	record, ok := store.Get(remotePath)
+	if !ok {
+		record = state.ObjectRecord{
+			Key:     remotePath,
+			Size:    info.Size(),
+			MD5Hash: md5Hash,
+			ModTime: info.ModTime(),
+		}
+	}
+	record.ArchivedAt = e.clock.Now()
+	store.Put(record)
+}
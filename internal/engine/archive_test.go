@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestArchiveDirectory(t *testing.T) {
+	tests := []struct {
+		name      string
+		dryRun    bool
+		stub      bool
+		wantStubs bool
+	}{
+		{name: "removes"},
+		{name: "leaves stubs", stub: true, wantStubs: true},
+		{name: "dry run", dryRun: true, stub: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := newTestEngine(t)
+			ctx := context.Background()
+			dir := interfaces.SyncDirectory{
+				LocalPath:  "/data",
+				RemotePath: "data",
+				Recursive:  true,
+				Archive:    interfaces.ArchivePolicy{After: 30 * 24 * time.Hour, Stub: tt.stub},
+			}
+			old := te.clock.Now().Add(-60 * 24 * time.Hour)
+
+			archived := "/data/old/report.txt"
+			te.fs.WriteFile(archived, []byte("final report"), old)
+			te.putUploaded("data/old/report.txt", archived, []byte("final report"))
+			recent := "/data/recent.txt"
+			te.fs.WriteFile(recent, []byte("draft"), te.clock.Now())
+			te.putUploaded("data/recent.txt", recent, []byte("draft"))
+			changed := "/data/changed.txt"
+			te.fs.WriteFile(changed, []byte("local edit"), old)
+			te.putUploaded("data/changed.txt", changed, []byte("other edit"))
+			missing := "/data/missing.txt"
+			te.fs.WriteFile(missing, []byte("never uploaded"), old)
+
+			report, err := te.ArchiveDirectory(ctx, dir, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(report.Archived, []string{archived}) {
+				t.Errorf("archived = %v, want %v", report.Archived, []string{archived})
+			}
+			if report.Bytes != int64(len("final report")) {
+				t.Errorf("archived bytes = %d", report.Bytes)
+			}
+			for _, path := range []string{changed, missing} {
+				if _, ok := report.Skipped[path]; !ok {
+					t.Errorf("%s not reported as skipped", path)
+				}
+			}
+			if _, ok := report.Skipped[recent]; ok {
+				t.Errorf("recent file considered for archiving")
+			}
+
+			for _, path := range []string{recent, changed, missing} {
+				if _, err := te.fs.Stat(path); err != nil {
+					t.Errorf("kept file: %v", err)
+				}
+			}
+			if _, err := te.fs.Stat(archived); os.IsNotExist(err) == tt.dryRun {
+				t.Errorf("archived file present = %v, dry run %v", err == nil, tt.dryRun)
+			}
+
+			stub, err := readStub(te.fs, archived+StubSuffix)
+			if !tt.wantStubs {
+				if err == nil {
+					t.Error("unexpected stub written")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if stub.Key != "data/old/report.txt" || stub.Size != int64(len("final report")) || !stub.ModTime.Equal(old) {
+				t.Errorf("stub = %+v", stub)
+			}
+
+			record, _ := te.store.Get("data/old/report.txt")
+			if record.ArchivedAt.IsZero() == !tt.dryRun {
+				t.Errorf("record archived at %v, dry run %v", record.ArchivedAt, tt.dryRun)
+			}
+		})
+	}
+}
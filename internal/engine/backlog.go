@@ -0,0 +1,368 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// defaultBacklogMemory is how many held file events are kept in memory
+// before spilling to disk
+const defaultBacklogMemory = 10000
+
+// eventBacklog holds file events that found the upload queue full until
+// there is room, instead of dropping them. Events of a path held in memory
+// are coalesced. Beyond memoryLimit events, further ones are appended to a
+// spill file and read back in order once those in memory are handled.
+type eventBacklog struct {
+	mutex       sync.Mutex
+	memoryLimit int
+	spillPath   string // "" keeps every event in memory
+	events      map[string]interfaces.FileEvent
+	order       []string // paths held in memory, oldest first
+	spill       *os.File
+	spillOffset int64 // start of the events not yet read back
+	spilled     int   // events in the spill file not yet read back
+	ready       chan struct{}
+}
+
+// newEventBacklog creates an empty backlog
+func newEventBacklog(memoryLimit int, spillPath string) *eventBacklog {
+	if memoryLimit <= 0 {
+		memoryLimit = defaultBacklogMemory
+	}
+	return &eventBacklog{
+		memoryLimit: memoryLimit,
+		spillPath:   spillPath,
+		events:      make(map[string]interfaces.FileEvent),
+		ready:       make(chan struct{}, 1),
+	}
+}
+
+// load picks up the events a previous run left in the spill file
+func (b *eventBacklog) load() error {
+	if b.spillPath == "" {
+		return nil
+	}
+	file, err := os.OpenFile(b.spillPath, os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open event backlog: %w", err)
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) > 0 {
+			b.spilled++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to read event backlog: %w", err)
+	}
+	b.spill = file
+	if b.spilled > 0 {
+		b.signal()
+	}
+	return nil
+}
+
+// add holds an event. It reports whether the backlog was empty before.
+func (b *eventBacklog) add(event interfaces.FileEvent) (bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	wasEmpty := len(b.order) == 0 && b.spilled == 0
+	defer b.signal()
+
+	if _, ok := b.events[event.Path]; ok {
+		b.events[event.Path] = event
+		return wasEmpty, nil
+	}
+	if b.spillPath == "" || (b.spilled == 0 && len(b.order) < b.memoryLimit) {
+		b.hold(event)
+		return wasEmpty, nil
+	}
+
+	if err := b.appendSpill(event); err != nil {
+		// Held in memory rather than lost
+		b.hold(event)
+		return wasEmpty, err
+	}
+	return wasEmpty, nil
+}
+
+// hold keeps an event in memory
+func (b *eventBacklog) hold(event interfaces.FileEvent) {
+	b.events[event.Path] = event
+	b.order = append(b.order, event.Path)
+}
+
+// appendSpill writes an event to the end of the spill file
+func (b *eventBacklog) appendSpill(event interfaces.FileEvent) error {
+	if b.spill == nil {
+		file, err := os.OpenFile(b.spillPath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create event backlog: %w", err)
+		}
+		b.spill = file
+	}
+	line, err := json.Marshal(state.JournaledEvent{
+		Path:       event.Path,
+		Operation:  event.Operation,
+		DetectedAt: event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := b.spill.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to write event backlog: %w", err)
+	}
+	if _, err := b.spill.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write event backlog: %w", err)
+	}
+	b.spilled++
+	return nil
+}
+
+// next removes and returns the oldest held event
+func (b *eventBacklog) next() (interfaces.FileEvent, bool, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var err error
+	if len(b.order) == 0 && b.spilled > 0 {
+		err = b.readSpill()
+	}
+	if len(b.order) == 0 {
+		return interfaces.FileEvent{}, false, err
+	}
+	path := b.order[0]
+	b.order = b.order[1:]
+	event := b.events[path]
+	delete(b.events, path)
+	return event, true, err
+}
+
+// readSpill moves up to memoryLimit events from the spill file into
+// memory, emptying the file once all were read back
+func (b *eventBacklog) readSpill() error {
+	if _, err := b.spill.Seek(b.spillOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read event backlog: %w", err)
+	}
+	reader := bufio.NewReader(b.spill)
+	for b.spilled > 0 && len(b.order) < b.memoryLimit {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A truncated last line cannot be read back
+			b.spilled = 0
+			break
+		}
+		b.spillOffset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		b.spilled--
+
+		var event state.JournaledEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		if _, ok := b.events[event.Path]; !ok {
+			b.order = append(b.order, event.Path)
+		}
+		b.events[event.Path] = interfaces.FileEvent{
+			Path:      event.Path,
+			Operation: event.Operation,
+			Timestamp: event.DetectedAt,
+		}
+	}
+
+	if b.spilled == 0 {
+		b.spillOffset = 0
+		if err := b.spill.Truncate(0); err != nil {
+			return fmt.Errorf("failed to empty event backlog: %w", err)
+		}
+	}
+	return nil
+}
+
+// len returns the number of held events
+func (b *eventBacklog) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.order) + b.spilled
+}
+
+// signal wakes the worker draining the backlog
+func (b *eventBacklog) signal() {
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+}
+
+// close writes the events still held to the spill file, so they are
+// handled after a restart, or removes the file when none are left
+func (b *eventBacklog) close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.spillPath == "" {
+		return nil
+	}
+
+	var remaining []byte
+	if b.spill != nil {
+		if _, err := b.spill.Seek(b.spillOffset, io.SeekStart); err == nil {
+			remaining, _ = io.ReadAll(b.spill)
+		}
+		b.spill.Close()
+		b.spill = nil
+	}
+	if len(b.order) == 0 && len(bytes.TrimSpace(remaining)) == 0 {
+		if err := os.Remove(b.spillPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, path := range b.order {
+		event := b.events[path]
+		line, err := json.Marshal(state.JournaledEvent{
+			Path:       event.Path,
+			Operation:  event.Operation,
+			DetectedAt: event.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	buf.Write(remaining)
+
+	tmp := b.spillPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.spillPath)
+}
+
+// SetEventBacklog sets how many file events found the upload queue full
+// are held in memory, and the file further ones spill to. Events a
+// previous run left in the file are handled once the engine starts. It
+// must be called before Start.
+func (e *Engine) SetEventBacklog(memoryLimit int, spillPath string) error {
+	backlog := newEventBacklog(memoryLimit, spillPath)
+	if err := backlog.load(); err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.backlog = backlog
+	return nil
+}
+
+// EventBacklog returns the number of file events waiting for room in the
+// upload queue
+func (e *Engine) EventBacklog() int {
+	return e.eventBacklog().len()
+}
+
+// eventBacklog returns the backlog of held file events
+func (e *Engine) eventBacklog() *eventBacklog {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.backlog
+}
+
+// holdEvent puts a file event that found the upload queue full into the
+// backlog
+func (e *Engine) holdEvent(event interfaces.FileEvent) {
+	wasEmpty, err := e.eventBacklog().add(event)
+	if err != nil {
+		e.logger.Error("Failed to spill file event to disk, holding it in memory",
+			zap.String("path", event.Path),
+			errorField(err))
+	}
+	if wasEmpty {
+		e.logger.Warn("Upload queue full, holding file changes until there is room",
+			zap.String("path", event.Path))
+	} else {
+		e.logger.Debug("Held file change for a full upload queue",
+			zap.String("path", event.Path))
+	}
+}
+
+// backlogWorker feeds held file events to the upload queue as it has room,
+// oldest first
+func (e *Engine) backlogWorker(ctx context.Context) {
+	defer e.wg.Done()
+
+	backlog := e.eventBacklog()
+	drained := 0
+	for {
+		event, ok, err := backlog.next()
+		if err != nil {
+			e.logger.Error("Failed to read held file events", errorField(err))
+		}
+		if !ok {
+			if drained > 0 {
+				e.logger.Info("Held file changes queued for upload",
+					zap.Int("events", drained))
+				drained = 0
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.stopChan:
+				return
+			case <-backlog.ready:
+			}
+			continue
+		}
+
+		e.processFileEvent(ctx, event, true)
+		if ctx.Err() != nil {
+			// Kept for the next start
+			if _, err := backlog.add(event); err != nil {
+				e.logger.Error("Failed to hold file event", zap.String("path", event.Path), errorField(err))
+			}
+			return
+		}
+		drained++
+	}
+}
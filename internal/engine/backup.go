@@ -0,0 +1,847 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// Backup layout below a directory's remote path:
+//
+//	data/<first two hash chars>/<md5>   file content, stored once per hash
+//	generations/<generation id>.json   manifest of one point-in-time backup
+const (
+	backupDataPrefix       = "data"
+	backupGenerationPrefix = "generations"
+	generationIDFormat     = "20060102T150405Z"
+)
+
+// ManifestEntry describes one file in a backup generation
+type ManifestEntry struct {
+	Path    string      `json:"path"` // relative to the directory root
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    os.FileMode `json:"mode"`
+	MD5Hash string      `json:"md5_hash"`
+
+	// LinkTarget is the path of an earlier entry this file is a hard
+	// link to. Restores link the two instead of downloading twice.
+	LinkTarget string `json:"link_target,omitempty"`
+
+	// Chunks lists the IDs of the file's chunks in order, in the chunked
+	// backup format
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// BackupManifest lists the files captured by a backup generation
+type BackupManifest struct {
+	ID        string          `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	LocalPath string          `json:"local_path"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// BackupResult summarizes a backup run
+type BackupResult struct {
+	GenerationID  string // empty when nothing changed since the last generation
+	Files         int
+	UploadedObjs  int
+	UploadedSize  int64
+	Pruned        []string
+	ParityStripes int // parity stripes written
+	Duration      time.Duration
+}
+
+// backupMutexes serializes backup runs per directory
+var backupMutexes sync.Map
+
+// Backup creates a new point-in-time generation of a backup-mode directory.
+// File content is stored once per hash, so unchanged files cost nothing in
+// later generations. No generation is created when nothing changed since the
+// previous one. Old generations are pruned according to the retention policy.
+func (e *Engine) Backup(ctx context.Context, dir interfaces.SyncDirectory) (*BackupResult, error) {
+	lock, _ := backupMutexes.LoadOrStore(dir.LocalPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	start := e.clock.Now()
+	result := &BackupResult{}
+
+	generations, err := e.ListGenerations(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reuse hashes from the previous generation for unchanged files
+	var previous *BackupManifest
+	if len(generations) > 0 {
+		previous, err = e.loadManifest(ctx, dir, generations[len(generations)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.takeSnapshot(ctx, dir); err != nil {
+		return nil, err
+	}
+
+	manifest, err := e.buildManifest(ctx, dir, previous)
+	if err != nil {
+		return nil, err
+	}
+	result.Files = len(manifest.Files)
+
+	if previous != nil && sameFiles(previous.Files, manifest.Files) {
+		e.logger.Debug("No changes since last backup generation",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("generation", previous.ID))
+		result.ParityStripes = e.protectBackup(ctx, dir, manifest)
+		result.Duration = e.clock.Now().Sub(start)
+		return result, nil
+	}
+
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		if err := e.uploadChunks(ctx, dir, manifest, previous, result); err != nil {
+			return nil, err
+		}
+	} else {
+		stored, err := e.storedHashes(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.uploadBackupData(ctx, dir, manifest, stored, result); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.saveManifest(ctx, dir, manifest); err != nil {
+		return nil, err
+	}
+	result.GenerationID = manifest.ID
+
+	pruned, err := e.PruneGenerations(ctx, dir, false)
+	if err != nil {
+		e.logger.Error("Failed to prune backup generations",
+			zap.String("local_path", dir.LocalPath),
+			errorField(err))
+	}
+	result.Pruned = pruned
+	result.ParityStripes = e.protectBackup(ctx, dir, manifest)
+	result.Duration = e.clock.Now().Sub(start)
+
+	e.logger.Info("Backup generation created",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("generation", manifest.ID),
+		zap.Int("files", result.Files),
+		zap.Int("uploaded_objects", result.UploadedObjs),
+		zap.Int64("uploaded_bytes", result.UploadedSize),
+		zap.Int("pruned", len(result.Pruned)),
+		zap.Int("parity_stripes", result.ParityStripes),
+		zap.Duration("duration", result.Duration))
+
+	return result, nil
+}
+
+// protectBackup adds parity to content not yet protected when the
+// directory has a parity policy, reading content from the local files of
+// the manifest where possible. It returns the number of stripes written.
+func (e *Engine) protectBackup(ctx context.Context, dir interfaces.SyncDirectory, manifest *BackupManifest) int {
+	if dir.Parity.IsZero() {
+		return 0
+	}
+
+	sources := make(map[string]string)
+	if dir.BackupFormat != interfaces.BackupFormatChunked {
+		for _, entry := range manifest.Files {
+			sources[path.Join(backupDataPrefix, entry.MD5Hash[:2], entry.MD5Hash)] = filepath.Join(dir.LocalPath, filepath.FromSlash(entry.Path))
+		}
+	}
+	written, err := e.protectContent(ctx, dir, sources)
+	if err != nil {
+		e.logger.Error("Failed to write parity stripes",
+			zap.String("local_path", dir.LocalPath),
+			errorField(err))
+	}
+	return written
+}
+
+// buildManifest scans a directory, hashing only files that changed since
+// the previous generation
+func (e *Engine) buildManifest(ctx context.Context, dir interfaces.SyncDirectory, previous *BackupManifest) (*BackupManifest, error) {
+	known := make(map[string]ManifestEntry)
+	if previous != nil {
+		for _, entry := range previous.Files {
+			known[entry.Path] = entry
+		}
+	}
+
+	// Hard links to one file are hashed once and restored as links
+	linkIDs := make(map[string]string)           // relative path to file identity
+	linkHashes := make(map[string]ManifestEntry) // file identity to first hashed entry
+
+	now := e.clock.Now().UTC()
+	manifest := &BackupManifest{
+		ID:        now.Format(generationIDFormat),
+		CreatedAt: now,
+		LocalPath: dir.LocalPath,
+	}
+
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, info os.FileInfo) error {
+		if !e.shouldSyncFile(localPath, dir) || e.excludedByRules(info, dir.FileRules) != "" {
+			return nil
+		}
+
+		rel := filepath.ToSlash(e.getRelativePath(localPath, dir.LocalPath))
+		entry := ManifestEntry{
+			Path:    rel,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			Mode:    info.Mode().Perm(),
+		}
+
+		id, links, ok := utils.FileIdentity(info)
+		linked := ok && links > 1
+		if linked {
+			linkIDs[rel] = id
+		}
+
+		if old, ok := known[rel]; ok && old.Size == entry.Size && old.ModTime.Equal(entry.ModTime) {
+			entry.MD5Hash = old.MD5Hash
+		} else if first, ok := linkHashes[id]; linked && ok && first.Size == entry.Size && first.ModTime.Equal(entry.ModTime) {
+			entry.MD5Hash = first.MD5Hash
+		} else {
+			source := localPath
+			if snapshotPath := e.snapshotSource(dir, localPath); snapshotPath != "" {
+				source = snapshotPath
+			}
+			hash, err := e.hashFile(source)
+			if err != nil {
+				e.logger.Warn("Skipping unreadable file in backup",
+					zap.String("path", localPath),
+					errorField(err))
+				return nil
+			}
+			entry.MD5Hash = hash
+		}
+		if _, ok := linkHashes[id]; linked && !ok {
+			linkHashes[id] = entry
+		}
+
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local files: %w", err)
+	}
+
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	// Point every later link to the first path of its file
+	firstPaths := make(map[string]string)
+	for i, entry := range manifest.Files {
+		id, ok := linkIDs[entry.Path]
+		if !ok {
+			continue
+		}
+		if first, ok := firstPaths[id]; ok {
+			manifest.Files[i].LinkTarget = first
+		} else {
+			firstPaths[id] = entry.Path
+		}
+	}
+	return manifest, nil
+}
+
+// storedHashes lists the content hashes already stored for a directory
+func (e *Engine) storedHashes(ctx context.Context, dir interfaces.SyncDirectory) (map[string]bool, error) {
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, backupDataPrefix)+"/")
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup data: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+
+	// Only objects laid out like dataKey count, so objects this tool did
+	// not write are neither reused nor collected
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		hash := path.Base(object.Key)
+		if isContentHash(hash) && path.Base(path.Dir(object.Key)) == hash[:2] {
+			stored[hash] = true
+		}
+	}
+	return stored, nil
+}
+
+// isContentHash reports whether name is a hex MD5 hash, the name of every
+// content object
+func isContentHash(name string) bool {
+	if len(name) != 32 {
+		return false
+	}
+	for _, c := range name {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// uploadBackupData uploads the content of every file whose hash is not yet
+// stored, using up to maxConcurrentUploads parallel transfers
+func (e *Engine) uploadBackupData(ctx context.Context, dir interfaces.SyncDirectory, manifest *BackupManifest, stored map[string]bool, result *BackupResult) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	uploads, _ := e.Concurrency()
+	sem := make(chan struct{}, max(uploads, 1))
+
+	for _, entry := range manifest.Files {
+		if stored[entry.MD5Hash] {
+			continue
+		}
+		stored[entry.MD5Hash] = true
+
+		localPath := filepath.Join(dir.LocalPath, filepath.FromSlash(entry.Path))
+		info, err := e.fs.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", localPath, err)
+		}
+		task := syncTask{
+			localPath:  localPath,
+			remotePath: dataKey(dir, entry.MD5Hash),
+			rootPath:   dir.LocalPath,
+			operation:  "upload",
+			fileInfo:   info,
+			sourcePath: e.snapshotSource(dir, localPath),
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := e.uploadWithRetry(ctx, task)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to back up %s: %w", task.localPath, err)
+				}
+				return
+			}
+			result.UploadedObjs++
+			result.UploadedSize += task.fileInfo.Size()
+		}()
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// uploadWithRetry uploads a task synchronously, retrying on failure
+func (e *Engine) uploadWithRetry(ctx context.Context, task syncTask) error {
+	if err := e.checkBudget(); err != nil {
+		return err
+	}
+	if err := e.checkQuota(task, task.fileInfo.Size()); err != nil {
+		return err
+	}
+
+	start := e.clock.Now()
+	var err error
+	retries := 0
+	for attempt := 0; attempt <= e.retryAttempts; attempt++ {
+		if attempt > 0 {
+			retries = attempt
+			e.logger.Warn("Retrying upload",
+				zap.String("local_path", task.localPath),
+				zap.Int("attempt", attempt))
+			if err = e.sleep(ctx, e.retryDelay); err != nil {
+				break
+			}
+		}
+
+		err = e.uploadFile(ctx, task)
+		e.recordRequests(task.rootPath, 1, 0, 0, 0)
+		if err == nil || ctx.Err() != nil || contentRejected(err) || interfaces.IsPermanent(err) {
+			break
+		}
+	}
+
+	e.metrics.RecordFileOperation("upload", e.clock.Now().Sub(start), err == nil)
+	if err != nil {
+		e.recordSyncError(task.localPath, "backup", err, retries)
+		e.recordTransferError(task, "upload", err)
+		return err
+	}
+
+	e.incrementFilesUploaded(ctx, task.fileInfo.Size())
+	e.recordUploadUsage(task, task.fileInfo.Size())
+	return nil
+}
+
+// saveManifest uploads a generation manifest, encrypted in the chunked
+// backup format
+func (e *Engine) saveManifest(ctx context.Context, dir interfaces.SyncDirectory, manifest *BackupManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	contentType := "application/json"
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		key, err := e.chunking()
+		if err != nil {
+			return err
+		}
+		data = key.Seal(data)
+		contentType = "application/octet-stream"
+	}
+
+	metadata := interfaces.FileMetadata{
+		Size:        int64(len(data)),
+		ModTime:     manifest.CreatedAt,
+		MD5Hash:     utils.CalculateMD5FromBytes(data),
+		ContentType: contentType,
+	}
+
+	opCtx, cancel := e.transferContext(ctx, int64(len(data)))
+	defer cancel()
+	if err := e.provider.Upload(opCtx, generationKey(dir, manifest.ID), bytes.NewReader(data), metadata, interfaces.TransferOptions{}); err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+	return nil
+}
+
+// loadManifest downloads and decodes a generation manifest
+func (e *Engine) loadManifest(ctx context.Context, dir interfaces.SyncDirectory, id string) (*BackupManifest, error) {
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+
+	body, _, err := e.provider.Download(opCtx, generationKey(dir, id), interfaces.TransferOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest %s: %w", id, err)
+	}
+	defer body.Close()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest %s: %w", id, err)
+	}
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		key, err := e.chunking()
+		if err != nil {
+			return nil, err
+		}
+		if data, err = key.Open(data); err != nil {
+			return nil, fmt.Errorf("failed to decrypt manifest %s: %w", id, err)
+		}
+	}
+
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %s: %w", id, err)
+	}
+	return &manifest, nil
+}
+
+// ListGenerations returns the IDs of a directory's backup generations,
+// oldest first
+func (e *Engine) ListGenerations(ctx context.Context, dir interfaces.SyncDirectory) ([]string, error) {
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, generationPrefix(dir))+"/")
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup generations: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+
+	var ids []string
+	for _, object := range objects {
+		id := path.Base(object.Key)
+		if dir.BackupFormat != interfaces.BackupFormatChunked {
+			var ok bool
+			if id, ok = strings.CutSuffix(id, ".json"); !ok {
+				continue
+			}
+		}
+		if _, err := time.Parse(generationIDFormat, id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// PruneGenerations deletes generations not kept by the directory's retention
+// policy, then removes content no remaining generation references. With
+// dryRun set nothing is deleted. It returns the pruned generation IDs.
+func (e *Engine) PruneGenerations(ctx context.Context, dir interfaces.SyncDirectory, dryRun bool) ([]string, error) {
+	if dir.Retention.IsZero() {
+		return nil, nil
+	}
+
+	ids, err := e.ListGenerations(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := selectGenerations(ids, dir.Retention)
+	var pruned []string
+	for _, id := range ids {
+		if !keep[id] {
+			pruned = append(pruned, id)
+		}
+	}
+	if len(pruned) == 0 || dryRun {
+		return pruned, nil
+	}
+
+	for _, id := range pruned {
+		opCtx, cancel := e.operationContext(ctx)
+		err := e.provider.Delete(opCtx, generationKey(dir, id))
+		cancel()
+		e.recordRequests(dir.LocalPath, 0, 0, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete generation %s: %w", id, err)
+		}
+		e.audit(ctx, audit.Entry{
+			Action:    "delete",
+			Key:       generationKey(dir, id),
+			LocalPath: dir.LocalPath,
+			Reason:    "backup generation outside retention policy",
+		})
+		e.logger.Info("Pruned backup generation",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("generation", id))
+	}
+
+	if err := e.collectGarbage(ctx, dir, keep); err != nil {
+		return pruned, err
+	}
+	return pruned, nil
+}
+
+// collectGarbage deletes content objects not referenced by any kept generation
+func (e *Engine) collectGarbage(ctx context.Context, dir interfaces.SyncDirectory, keep map[string]bool) error {
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		return e.collectChunkGarbage(ctx, dir, keep)
+	}
+
+	referenced := make(map[string]bool)
+	for id := range keep {
+		manifest, err := e.loadManifest(ctx, dir, id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range manifest.Files {
+			referenced[entry.MD5Hash] = true
+		}
+	}
+
+	stored, err := e.storedHashes(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	// Parity stripes including unreferenced content go first, so a check
+	// never rebuilds content that was removed on purpose
+	unreferenced := make(map[string]bool)
+	for hash := range stored {
+		if !referenced[hash] {
+			unreferenced[path.Join(backupDataPrefix, hash[:2], hash)] = true
+		}
+	}
+	if err := e.dropStripes(ctx, dir, unreferenced); err != nil {
+		return err
+	}
+
+	removed := 0
+	for hash := range stored {
+		if referenced[hash] {
+			continue
+		}
+		key := dataKey(dir, hash)
+		opCtx, cancel := e.operationContext(ctx)
+		err := e.provider.Delete(opCtx, key)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to delete unreferenced object %s: %w", key, err)
+		}
+		e.forgetObject(key)
+		e.audit(ctx, audit.Entry{
+			Action:    "delete",
+			Key:       key,
+			LocalPath: dir.LocalPath,
+			Reason:    "content not referenced by any kept generation",
+		})
+		removed++
+	}
+
+	if removed > 0 {
+		e.logger.Info("Removed unreferenced backup objects",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("objects", removed))
+	}
+	return nil
+}
+
+// RestoreGeneration downloads every file of a generation below target
+func (e *Engine) RestoreGeneration(ctx context.Context, dir interfaces.SyncDirectory, id, target string) (int, error) {
+	manifest, err := e.loadManifest(ctx, dir, id)
+	if err != nil {
+		return 0, err
+	}
+
+	// Request the restores of archived content up front, before any file
+	// is written
+	var chunks *chunkReader
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		if chunks, err = e.prepareChunkRestore(ctx, dir, manifest); err != nil {
+			return 0, fmt.Errorf("failed to restore generation %s: %w", id, err)
+		}
+	} else if err := e.prepareDataRestore(ctx, dir, manifest, target); err != nil {
+		return 0, fmt.Errorf("failed to restore generation %s: %w", id, err)
+	}
+
+	restored := 0
+	restoredPaths := make(map[string]string) // manifest path to restored file
+	for _, entry := range manifest.Files {
+		if err := ctx.Err(); err != nil {
+			return restored, err
+		}
+
+		localPath := filepath.Join(target, filepath.FromSlash(entry.Path))
+		if !strings.HasPrefix(localPath, filepath.Clean(target)+string(filepath.Separator)) {
+			return restored, fmt.Errorf("manifest entry %s escapes restore target", entry.Path)
+		}
+
+		if source, ok := restoredPaths[entry.LinkTarget]; ok && entry.LinkTarget != "" {
+			err := restoreHardLink(source, localPath)
+			if err == nil {
+				restoredPaths[entry.Path] = localPath
+				restored++
+				continue
+			}
+			e.logger.Warn("Failed to restore hard link, downloading content",
+				zap.String("path", localPath),
+				zap.String("link_target", source),
+				errorField(err))
+		}
+
+		if chunks != nil {
+			if err := chunks.restoreFile(ctx, entry, localPath); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+			}
+		} else {
+			task := syncTask{
+				localPath:  localPath,
+				remotePath: dataKey(dir, entry.MD5Hash),
+				rootPath:   dir.LocalPath,
+				operation:  "download",
+				metadata:   interfaces.FileMetadata{Size: entry.Size},
+			}
+			if err := e.downloadFile(ctx, task); err != nil {
+				return restored, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+			}
+			e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+		}
+
+		if err := e.fs.Chmod(localPath, entry.Mode); err != nil {
+			e.logger.Warn("Failed to set file mode", zap.String("path", localPath), errorField(err))
+		}
+		if err := e.fs.Chtimes(localPath, entry.ModTime, entry.ModTime); err != nil {
+			e.logger.Warn("Failed to set file modification time", zap.String("path", localPath), errorField(err))
+		}
+		restoredPaths[entry.Path] = localPath
+		restored++
+	}
+
+	e.logger.Info("Backup generation restored",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("generation", id),
+		zap.String("target", target),
+		zap.Int("files", restored))
+	return restored, nil
+}
+
+// prepareDataRestore requests the restores of archived content objects a
+// manifest references
+func (e *Engine) prepareDataRestore(ctx context.Context, dir interfaces.SyncDirectory, manifest *BackupManifest, target string) error {
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, backupDataPrefix)+"/")
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to list backup data: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+	archived := make(map[string]bool)
+	for _, object := range objects {
+		if object.Archived() {
+			archived[object.Key] = true
+		}
+	}
+
+	var contents []syncTask
+	for _, entry := range manifest.Files {
+		key := dataKey(dir, entry.MD5Hash)
+		if !archived[key] {
+			continue
+		}
+		delete(archived, key)
+		contents = append(contents, syncTask{
+			localPath:  filepath.Join(target, filepath.FromSlash(entry.Path)),
+			remotePath: key,
+			rootPath:   dir.LocalPath,
+			operation:  "download",
+		})
+	}
+	return e.planRestores(ctx, contents)
+}
+
+// restoreHardLink replaces path with a hard link to source
+func restoreHardLink(source, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace existing file: %w", err)
+	}
+	if err := os.Link(source, path); err != nil {
+		return fmt.Errorf("failed to create hard link: %w", err)
+	}
+	return nil
+}
+
+// selectGenerations returns the generations kept by a retention policy.
+// Within each daily, weekly and monthly bucket the newest generation is kept.
+func selectGenerations(ids []string, policy interfaces.RetentionPolicy) map[string]bool {
+	keep := make(map[string]bool)
+
+	newestFirst := make([]string, len(ids))
+	for i, id := range ids {
+		newestFirst[len(ids)-1-i] = id
+	}
+
+	for i := 0; i < policy.KeepLast && i < len(newestFirst); i++ {
+		keep[newestFirst[i]] = true
+	}
+
+	bucketed := func(limit int, bucket func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, id := range newestFirst {
+			if len(seen) >= limit {
+				return
+			}
+			t, err := time.Parse(generationIDFormat, id)
+			if err != nil {
+				continue
+			}
+			b := bucket(t)
+			if !seen[b] {
+				seen[b] = true
+				keep[id] = true
+			}
+		}
+	}
+
+	bucketed(policy.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+	bucketed(policy.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%02d", year, week)
+	})
+	bucketed(policy.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+
+	// Never prune the newest generation
+	if len(newestFirst) > 0 {
+		keep[newestFirst[0]] = true
+	}
+	return keep
+}
+
+// sameFiles reports whether two sorted manifests describe identical content
+func sameFiles(a, b []ManifestEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].MD5Hash != b[i].MD5Hash || a[i].Mode != b[i].Mode || a[i].LinkTarget != b[i].LinkTarget {
+			return false
+		}
+	}
+	return true
+}
+
+// backupKey returns a key below the directory's remote path
+func backupKey(dir interfaces.SyncDirectory, elem ...string) string {
+	return path.Join(append([]string{dir.RemotePath}, elem...)...)
+}
+
+// dataKey returns the content key for a hash
+func dataKey(dir interfaces.SyncDirectory, hash string) string {
+	return backupKey(dir, backupDataPrefix, hash[:2], hash)
+}
+
+// generationPrefix returns the prefix below which a directory's
+// generation manifests are stored
+func generationPrefix(dir interfaces.SyncDirectory) string {
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		return chunkSnapshotPrefix
+	}
+	return backupGenerationPrefix
+}
+
+// generationKey returns the manifest key for a generation
+func generationKey(dir interfaces.SyncDirectory, id string) string {
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		return backupKey(dir, chunkSnapshotPrefix, id)
+	}
+	return backupKey(dir, backupGenerationPrefix, id+".json")
+}
@@ -0,0 +1,183 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"path"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestSelectGenerations(t *testing.T) {
+	ids := []string{
+		"20260128T030000Z",
+		"20260214T030000Z",
+		"20260301T030000Z",
+		"20260309T030000Z",
+		"20260310T030000Z",
+		"20260314T030000Z",
+		"20260314T150000Z",
+		"20260315T030000Z",
+	}
+
+	tests := []struct {
+		name   string
+		policy interfaces.RetentionPolicy
+		want   []string
+	}{
+		{
+			name: "newest is always kept",
+			want: []string{"20260315T030000Z"},
+		},
+		{
+			name:   "keep last",
+			policy: interfaces.RetentionPolicy{KeepLast: 3},
+			want:   []string{"20260314T030000Z", "20260314T150000Z", "20260315T030000Z"},
+		},
+		{
+			name:   "keep daily takes the newest of each day",
+			policy: interfaces.RetentionPolicy{KeepDaily: 3},
+			want:   []string{"20260310T030000Z", "20260314T150000Z", "20260315T030000Z"},
+		},
+		{
+			// March 9 to 15 is one ISO week, the week before is empty
+			name:   "keep weekly",
+			policy: interfaces.RetentionPolicy{KeepWeekly: 2},
+			want:   []string{"20260301T030000Z", "20260315T030000Z"},
+		},
+		{
+			name:   "keep monthly",
+			policy: interfaces.RetentionPolicy{KeepMonthly: 12},
+			want:   []string{"20260128T030000Z", "20260214T030000Z", "20260315T030000Z"},
+		},
+		{
+			name:   "rules combine",
+			policy: interfaces.RetentionPolicy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2},
+			want:   []string{"20260214T030000Z", "20260314T150000Z", "20260315T030000Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep := selectGenerations(ids, tt.policy)
+			var got []string
+			for id := range keep {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectGenerations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupPruneAndRestore(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	dir := interfaces.SyncDirectory{
+		LocalPath:  "/data",
+		RemotePath: "backup",
+		SyncMode:   "backup",
+		Recursive:  true,
+		Retention:  interfaces.RetentionPolicy{KeepLast: 1},
+	}
+	modTime := te.clock.Now().Add(-time.Hour)
+	te.fs.WriteFile("/data/a.txt", []byte("alpha"), modTime)
+	te.fs.WriteFile("/data/copy.txt", []byte("alpha"), modTime)
+	te.fs.WriteFile("/data/sub/notes.txt", []byte("first notes"), modTime)
+
+	first, err := te.Backup(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GenerationID == "" || first.Files != 3 || first.UploadedObjs != 2 {
+		t.Fatalf("first backup = %+v, want a generation of 3 files with 2 objects", first)
+	}
+
+	te.clock.Advance(time.Hour)
+	unchanged, err := te.Backup(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.GenerationID != "" || unchanged.UploadedObjs != 0 {
+		t.Errorf("unchanged backup = %+v, want no generation", unchanged)
+	}
+
+	te.clock.Advance(24 * time.Hour)
+	notesTime := te.clock.Now()
+	te.fs.WriteFile("/data/sub/notes.txt", []byte("second notes"), notesTime)
+	second, err := te.Backup(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.GenerationID == "" || second.UploadedObjs != 1 {
+		t.Fatalf("second backup = %+v, want a generation with 1 object", second)
+	}
+	if !slices.Equal(second.Pruned, []string{first.GenerationID}) {
+		t.Errorf("pruned = %v, want %v", second.Pruned, []string{first.GenerationID})
+	}
+
+	generations, err := te.ListGenerations(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(generations, []string{second.GenerationID}) {
+		t.Errorf("generations = %v, want %v", generations, []string{second.GenerationID})
+	}
+	stored, err := te.storedHashes(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("stored content = %v, want the two hashes still referenced", stored)
+	}
+
+	restored, err := te.RestoreGeneration(ctx, dir, second.GenerationID, "/restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 3 {
+		t.Errorf("restored %d files, want 3", restored)
+	}
+	for name, want := range map[string]string{"a.txt": "alpha", "copy.txt": "alpha", "sub/notes.txt": "second notes"} {
+		data, err := te.fs.ReadFile(path.Join("/restore", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("restored %s = %q, want %q", name, data, want)
+		}
+	}
+	info, err := te.fs.Stat("/restore/sub/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(notesTime) {
+		t.Errorf("restored modification time = %v, want %v", info.ModTime(), notesTime)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// bandwidthLimiter paces transfers to a number of bytes per second, shared
+// by all uploads and downloads
+type bandwidthLimiter struct {
+	rate  int64
+	next  time.Time // when the bytes reserved so far have been sent
+	mutex sync.Mutex
+}
+
+// wait blocks until n more bytes may be transferred. A nil limiter never
+// waits.
+func (l *bandwidthLimiter) wait(ctx context.Context, clock interfaces.Clock, n int64) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.rate) * float64(time.Second)))
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(delay):
+		return nil
+	}
+}
+
+// SetBandwidthLimit limits the combined throughput of all transfers to
+// bytesPerSecond. Zero removes the limit. Transfers in progress pick up
+// the new limit immediately.
+func (e *Engine) SetBandwidthLimit(bytesPerSecond int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if bytesPerSecond <= 0 {
+		e.bandwidthLimiter = nil
+		return
+	}
+	e.bandwidthLimiter = &bandwidthLimiter{rate: bytesPerSecond}
+}
+
+// transferProgress returns the progress callback for a transfer. It counts
+// bytes into transferred for activity reporting and stall detection,
+// records bandwidth metrics and applies the bandwidth limit. The limit
+// stops waiting once ctx is done, letting the provider fail the transfer.
+func (e *Engine) transferProgress(ctx context.Context, direction string, transferred *atomic.Int64) interfaces.ProgressFunc {
+	return func(n int64) {
+		transferred.Add(n)
+		e.metrics.RecordBandwidth(n, direction)
+
+		e.mutex.RLock()
+		limiter := e.bandwidthLimiter
+		e.mutex.RUnlock()
+		limiter.wait(ctx, e.clock, n)
+	}
+}
+
+// SetReadRateLimit limits how fast each upload reads its local file, for
+// hashing and for sending, to bytesPerSecond. Every upload worker is paced
+// on its own, so total disk reads stay below the limit times the number of
+// workers. Zero removes the limit.
+func (e *Engine) SetReadRateLimit(bytesPerSecond int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.readRateLimit = max(bytesPerSecond, 0)
+}
+
+// newReadLimiter returns the limiter pacing the local reads of one upload,
+// or nil when reads are not limited
+func (e *Engine) newReadLimiter() *bandwidthLimiter {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.readRateLimit <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{rate: e.readRateLimit}
+}
+
+// pacedReader waits on a limiter after every read
+type pacedReader struct {
+	ctx     context.Context
+	clock   interfaces.Clock
+	limiter *bandwidthLimiter
+	reader  io.Reader
+}
+
+// Read reads from the underlying reader, then waits until the bytes read
+// fit the limiter's rate
+func (r *pacedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, r.clock, int64(n)); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
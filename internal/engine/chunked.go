@@ -0,0 +1,729 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/chunker"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// Chunked backup layout below a directory's remote path:
+//
+//	packs/<first two id chars>/<pack id>   encrypted chunks and their list
+//	index/<index id>                        encrypted lists of packs and chunks
+//	snapshots/<generation id>              encrypted generation manifests
+const (
+	chunkPackPrefix     = "packs"
+	chunkIndexPrefix    = "index"
+	chunkSnapshotPrefix = "snapshots"
+
+	// DefaultPackSize is the size packs are filled to before upload
+	DefaultPackSize = 16 * 1024 * 1024
+)
+
+// SetChunking configures the chunked backup format: chunks are cut with
+// params, encrypted with key and uploaded in packs of about packSize bytes.
+// Backups of chunked directories fail until a key is set.
+func (e *Engine) SetChunking(key *chunker.Key, params chunker.Params, packSize int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.chunkKey = key
+	e.chunkParams = params
+	e.packSize = packSize
+}
+
+// chunking returns the key of the chunked backup format
+func (e *Engine) chunking() (*chunker.Key, error) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.chunkKey == nil {
+		return nil, errors.New("chunked backups require chunking.key_file to be set")
+	}
+	return e.chunkKey, nil
+}
+
+// chunkSizes returns the chunk and pack sizes of the chunked backup format
+func (e *Engine) chunkSizes() (chunker.Params, int64) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	params, packSize := e.chunkParams, e.packSize
+	if params == (chunker.Params{}) {
+		params = chunker.DefaultParams
+	}
+	if packSize <= 0 {
+		packSize = DefaultPackSize
+	}
+	return params, packSize
+}
+
+// uploadChunks stores the content of a chunked backup generation. Files
+// whose content is in the previous generation reuse its chunk list; other
+// files are split into chunks and only chunks not stored yet are encrypted
+// and packed. The packs written are listed in a new index.
+func (e *Engine) uploadChunks(ctx context.Context, dir interfaces.SyncDirectory, manifest, previous *BackupManifest, result *BackupResult) error {
+	key, err := e.chunking()
+	if err != nil {
+		return err
+	}
+	params, packSize := e.chunkSizes()
+
+	index, _, err := e.loadChunkIndex(ctx, dir, key)
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool)
+	for id := range index.Locations() {
+		stored[id] = true
+	}
+
+	known := make(map[string][]string) // content hash to chunk IDs
+	if previous != nil {
+		for _, entry := range previous.Files {
+			known[entry.MD5Hash] = entry.Chunks
+		}
+	}
+
+	uploader := e.newPackUploader(ctx, dir, key, packSize, result)
+	reused := 0
+	for i, entry := range manifest.Files {
+		if chunks, ok := known[entry.MD5Hash]; ok {
+			manifest.Files[i].Chunks = chunks
+			continue
+		}
+		chunks, found, err := e.chunkFile(ctx, dir, entry, key, params, stored, uploader)
+		if err != nil {
+			uploader.wait()
+			return err
+		}
+		manifest.Files[i].Chunks = chunks
+		known[entry.MD5Hash] = chunks
+		reused += found
+	}
+
+	if err := uploader.flush(); err != nil {
+		uploader.wait()
+		return err
+	}
+	if err := uploader.wait(); err != nil {
+		return err
+	}
+	if len(uploader.index.Packs) > 0 {
+		if _, err := e.saveChunkIndex(ctx, dir, key, &uploader.index); err != nil {
+			return err
+		}
+	}
+
+	e.logger.Debug("Chunked backup content stored",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("packs", len(uploader.index.Packs)),
+		zap.Int("chunks_reused", reused))
+	return nil
+}
+
+// chunkFile splits one file into chunks, adding chunks not yet stored to
+// the uploader. It returns the file's chunk IDs and how many of them were
+// already stored.
+func (e *Engine) chunkFile(ctx context.Context, dir interfaces.SyncDirectory, entry ManifestEntry, key *chunker.Key, params chunker.Params, stored map[string]bool, uploader *packUploader) ([]string, int, error) {
+	localPath := filepath.Join(dir.LocalPath, filepath.FromSlash(entry.Path))
+	source := localPath
+	if snapshotPath := e.snapshotSource(dir, localPath); snapshotPath != "" {
+		source = snapshotPath
+	}
+	file, err := e.fs.Open(source)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	var ids []string
+	found := 0
+	chunks := chunker.New(file, params)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		data, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read %s: %w", localPath, err)
+		}
+
+		id := key.ID(data)
+		ids = append(ids, id)
+		if stored[id] {
+			found++
+			continue
+		}
+		stored[id] = true
+		if err := uploader.add(id, data); err != nil {
+			return nil, 0, err
+		}
+	}
+	return ids, found, nil
+}
+
+// packUploader fills packs and uploads each full pack in the background,
+// using up to maxConcurrentUploads parallel transfers
+type packUploader struct {
+	e        *Engine
+	ctx      context.Context
+	dir      interfaces.SyncDirectory
+	packer   *chunker.Packer
+	packSize int64
+	result   *BackupResult // may be nil
+	sem      chan struct{}
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	err   error
+	index chunker.Index // packs uploaded so far
+}
+
+// newPackUploader returns an uploader of packs sealed with key
+func (e *Engine) newPackUploader(ctx context.Context, dir interfaces.SyncDirectory, key *chunker.Key, packSize int64, result *BackupResult) *packUploader {
+	uploads, _ := e.Concurrency()
+	return &packUploader{
+		e:        e,
+		ctx:      ctx,
+		dir:      dir,
+		packer:   chunker.NewPacker(key),
+		packSize: packSize,
+		result:   result,
+		sem:      make(chan struct{}, max(uploads, 1)),
+	}
+}
+
+// add seals a chunk into the current pack, uploading the pack once full
+func (u *packUploader) add(id string, data []byte) error {
+	u.packer.Add(id, data)
+	if u.packer.Size() >= u.packSize {
+		return u.flush()
+	}
+	return u.failed()
+}
+
+// addSealed copies a sealed chunk into the current pack, uploading the
+// pack once full
+func (u *packUploader) addSealed(blob chunker.Blob, sealed []byte) error {
+	u.packer.AddSealed(blob, sealed)
+	if u.packer.Size() >= u.packSize {
+		return u.flush()
+	}
+	return u.failed()
+}
+
+// flush starts the upload of the current pack unless it is empty
+func (u *packUploader) flush() error {
+	if u.packer.Count() == 0 {
+		return u.failed()
+	}
+	id, data, blobs, err := u.packer.Finish()
+	if err != nil {
+		return err
+	}
+
+	u.sem <- struct{}{}
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		defer func() { <-u.sem }()
+
+		err := u.e.uploadBackupObject(u.ctx, u.dir, packKey(u.dir, id), data)
+
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if err != nil {
+			if u.err == nil {
+				u.err = fmt.Errorf("failed to upload pack %s: %w", id, err)
+			}
+			return
+		}
+		u.index.Packs = append(u.index.Packs, chunker.PackInfo{ID: id, Size: int64(len(data)), Blobs: blobs})
+		if u.result != nil {
+			u.result.UploadedObjs++
+			u.result.UploadedSize += int64(len(data))
+		}
+	}()
+	return u.failed()
+}
+
+// failed returns the first upload error so far
+func (u *packUploader) failed() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.err
+}
+
+// wait blocks until every started upload finished
+func (u *packUploader) wait() error {
+	u.wg.Wait()
+	return u.failed()
+}
+
+// uploadBackupObject uploads generated content, retrying on failure
+func (e *Engine) uploadBackupObject(ctx context.Context, dir interfaces.SyncDirectory, key string, data []byte) error {
+	return e.uploadBackupContent(ctx, dir, key, int64(len(data)), utils.CalculateMD5FromBytes(data), bytes.NewReader(data))
+}
+
+// uploadBackupContent uploads size bytes of body, retrying on failure.
+// Every attempt reads body from the start.
+func (e *Engine) uploadBackupContent(ctx context.Context, dir interfaces.SyncDirectory, key string, size int64, hash string, body io.ReadSeeker) error {
+	if err := e.checkBudget(); err != nil {
+		return err
+	}
+
+	metadata := interfaces.FileMetadata{
+		Size:        size,
+		ModTime:     e.clock.Now(),
+		MD5Hash:     hash,
+		ContentType: "application/octet-stream",
+	}
+
+	start := e.clock.Now()
+	var err error
+	for attempt := 0; attempt <= e.retryAttempts; attempt++ {
+		if attempt > 0 {
+			e.logger.Warn("Retrying upload",
+				zap.String("remote_path", key),
+				zap.Int("attempt", attempt),
+				errorField(err))
+			if err := e.sleep(ctx, e.retryDelay); err != nil {
+				return err
+			}
+		}
+
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			break
+		}
+		opCtx, cancel := e.transferContext(ctx, size)
+		err = e.provider.Upload(opCtx, key, body, metadata, interfaces.TransferOptions{})
+		cancel()
+		e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+
+	e.metrics.RecordFileOperation("upload", e.clock.Now().Sub(start), err == nil)
+	if err != nil {
+		return err
+	}
+	e.incrementFilesUploaded(ctx, size)
+	return nil
+}
+
+// loadChunkIndex downloads and merges every index file of a directory. It
+// also returns the keys of the index files read.
+func (e *Engine) loadChunkIndex(ctx context.Context, dir interfaces.SyncDirectory, key *chunker.Key) (*chunker.Index, []string, error) {
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, chunkIndexPrefix)+"/")
+	cancel()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list chunk index: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+
+	index := &chunker.Index{}
+	var keys []string
+	for _, object := range objects {
+		opCtx, cancel := e.operationContext(ctx)
+		body, _, err := e.provider.Download(opCtx, object.Key, interfaces.TransferOptions{})
+		if err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf("failed to download index %s: %w", object.Key, err)
+		}
+		sealed, err := io.ReadAll(body)
+		body.Close()
+		cancel()
+		e.recordRequests(dir.LocalPath, 0, 1, 0, int64(len(sealed)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to download index %s: %w", object.Key, err)
+		}
+
+		data, err := key.Open(sealed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decrypt index %s: %w", object.Key, err)
+		}
+		var part chunker.Index
+		if err := json.Unmarshal(data, &part); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode index %s: %w", object.Key, err)
+		}
+		index.Packs = append(index.Packs, part.Packs...)
+		keys = append(keys, object.Key)
+	}
+	return index, keys, nil
+}
+
+// saveChunkIndex encrypts and uploads an index file, returning its key
+func (e *Engine) saveChunkIndex(ctx context.Context, dir interfaces.SyncDirectory, key *chunker.Key, index *chunker.Index) (string, error) {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode chunk index: %w", err)
+	}
+	sealed := key.Seal(data)
+	sum := sha256.Sum256(sealed)
+	indexKey := backupKey(dir, chunkIndexPrefix, hex.EncodeToString(sum[:]))
+	if err := e.uploadBackupObject(ctx, dir, indexKey, sealed); err != nil {
+		return "", fmt.Errorf("failed to upload chunk index: %w", err)
+	}
+	return indexKey, nil
+}
+
+// collectChunkGarbage removes packs holding no chunk of a kept generation.
+// Packs where unreferenced chunks make up at least half of the stored bytes
+// are rewritten with only their referenced chunks. The index is then
+// replaced by a single file describing the remaining packs.
+func (e *Engine) collectChunkGarbage(ctx context.Context, dir interfaces.SyncDirectory, keep map[string]bool) error {
+	key, err := e.chunking()
+	if err != nil {
+		return err
+	}
+	_, packSize := e.chunkSizes()
+
+	referenced := make(map[string]bool)
+	for id := range keep {
+		manifest, err := e.loadManifest(ctx, dir, id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range manifest.Files {
+			for _, chunk := range entry.Chunks {
+				referenced[chunk] = true
+			}
+		}
+	}
+
+	index, indexKeys, err := e.loadChunkIndex(ctx, dir, key)
+	if err != nil {
+		return err
+	}
+
+	// Keep packs that are mostly in use first, so repacking copies only
+	// chunks stored nowhere else
+	var kept chunker.Index
+	var repack, obsolete []chunker.PackInfo
+	covered := make(map[string]bool)
+	for _, pack := range index.Packs {
+		var used, unused int64
+		for _, blob := range pack.Blobs {
+			if referenced[blob.ID] {
+				used += blob.Length
+			} else {
+				unused += blob.Length
+			}
+		}
+		switch {
+		case used == 0:
+			obsolete = append(obsolete, pack)
+		case unused >= used:
+			repack = append(repack, pack)
+		default:
+			kept.Packs = append(kept.Packs, pack)
+			for _, blob := range pack.Blobs {
+				covered[blob.ID] = true
+			}
+		}
+	}
+	if len(repack) == 0 && len(obsolete) == 0 {
+		return nil
+	}
+
+	uploader := e.newPackUploader(ctx, dir, key, packSize, nil)
+	for _, pack := range repack {
+		if err := e.repackChunks(ctx, dir, pack, referenced, covered, uploader); err != nil {
+			uploader.wait()
+			return err
+		}
+		obsolete = append(obsolete, pack)
+	}
+	if err := uploader.flush(); err != nil {
+		uploader.wait()
+		return err
+	}
+	if err := uploader.wait(); err != nil {
+		return err
+	}
+	kept.Packs = append(kept.Packs, uploader.index.Packs...)
+
+	// The new index must be stored before the packs and index files it
+	// replaces are deleted
+	newIndex, err := e.saveChunkIndex(ctx, dir, key, &kept)
+	if err != nil {
+		return err
+	}
+	removed := make(map[string]bool)
+	for _, pack := range obsolete {
+		removed[path.Join(chunkPackPrefix, pack.ID[:2], pack.ID)] = true
+	}
+	if err := e.dropStripes(ctx, dir, removed); err != nil {
+		return err
+	}
+	for _, indexKey := range indexKeys {
+		if indexKey == newIndex {
+			continue
+		}
+		if err := e.deleteBackupObject(ctx, dir, indexKey, "chunk index replaced by a consolidated index"); err != nil {
+			return err
+		}
+	}
+	for _, pack := range obsolete {
+		if err := e.deleteBackupObject(ctx, dir, packKey(dir, pack.ID), "pack not referenced by any kept generation"); err != nil {
+			return err
+		}
+	}
+
+	e.logger.Info("Removed unreferenced backup packs",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("packs", len(obsolete)),
+		zap.Int("repacked", len(repack)),
+		zap.Int("packs_written", len(uploader.index.Packs)))
+	return nil
+}
+
+// repackChunks copies the referenced chunks of a pack not stored in another
+// kept pack into the uploader. Chunks stay sealed while copied.
+func (e *Engine) repackChunks(ctx context.Context, dir interfaces.SyncDirectory, pack chunker.PackInfo, referenced, covered map[string]bool, uploader *packUploader) error {
+	opCtx, cancel := e.transferContext(ctx, pack.Size)
+	defer cancel()
+	body, _, err := e.provider.Download(opCtx, packKey(dir, pack.ID), interfaces.TransferOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to download pack %s: %w", pack.ID, err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("failed to download pack %s: %w", pack.ID, err)
+	}
+
+	for _, blob := range pack.Blobs {
+		if !referenced[blob.ID] || covered[blob.ID] {
+			continue
+		}
+		if blob.Offset+blob.Length > int64(len(data)) {
+			return fmt.Errorf("chunk %s lies outside pack %s", blob.ID, pack.ID)
+		}
+		covered[blob.ID] = true
+		if err := uploader.addSealed(blob, data[blob.Offset:blob.Offset+blob.Length]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteBackupObject deletes an object of a backup directory and records
+// the deletion in the audit log
+func (e *Engine) deleteBackupObject(ctx context.Context, dir interfaces.SyncDirectory, key, reason string) error {
+	opCtx, cancel := e.operationContext(ctx)
+	err := e.provider.Delete(opCtx, key)
+	cancel()
+	e.recordRequests(dir.LocalPath, 0, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	e.forgetObject(key)
+	e.audit(ctx, audit.Entry{
+		Action:    "delete",
+		Key:       key,
+		LocalPath: dir.LocalPath,
+		Reason:    reason,
+	})
+	return nil
+}
+
+// chunkReader reads the chunks of a generation during a restore
+type chunkReader struct {
+	e         *Engine
+	dir       interfaces.SyncDirectory
+	key       *chunker.Key
+	locations map[string]chunker.Location
+
+	// Last pack downloaded whole, for providers without ranged reads
+	packID   string
+	packData []byte
+}
+
+// prepareChunkRestore loads the chunk index and requests the restores of
+// archived packs the manifest references
+func (e *Engine) prepareChunkRestore(ctx context.Context, dir interfaces.SyncDirectory, manifest *BackupManifest) (*chunkReader, error) {
+	key, err := e.chunking()
+	if err != nil {
+		return nil, err
+	}
+	index, _, err := e.loadChunkIndex(ctx, dir, key)
+	if err != nil {
+		return nil, err
+	}
+	reader := &chunkReader{e: e, dir: dir, key: key, locations: index.Locations()}
+
+	needed := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		for _, chunk := range entry.Chunks {
+			location, ok := reader.locations[chunk]
+			if !ok {
+				return nil, fmt.Errorf("chunk %s of %s is missing from the index", chunk, entry.Path)
+			}
+			needed[packKey(dir, location.Pack)] = true
+		}
+	}
+
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, chunkPackPrefix)+"/")
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup packs: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+
+	var packs []syncTask
+	for _, object := range objects {
+		if !object.Archived() || !needed[object.Key] {
+			continue
+		}
+		packs = append(packs, syncTask{
+			localPath:  dir.LocalPath,
+			remotePath: object.Key,
+			rootPath:   dir.LocalPath,
+			operation:  "download",
+		})
+	}
+	if err := e.planRestores(ctx, packs); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// restoreFile writes the chunks of a manifest entry to path, checking the
+// content hash before the file replaces anything at path
+func (r *chunkReader) restoreFile(ctx context.Context, entry ManifestEntry, localPath string) error {
+	fs := r.e.fs
+	if err := fs.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	file, err := fs.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer fs.Remove(file.Name())
+	defer file.Close()
+
+	hasher := md5.New()
+	writer := io.MultiWriter(file, hasher)
+	for _, id := range entry.Chunks {
+		data, err := r.read(ctx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+	}
+
+	if hash := hex.EncodeToString(hasher.Sum(nil)); hash != entry.MD5Hash {
+		return fmt.Errorf("restored content hash %s does not match %s", hash, entry.MD5Hash)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := fs.Rename(file.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to move restored file into place: %w", err)
+	}
+	return nil
+}
+
+// read returns the plaintext of a chunk, checking it against its ID
+func (r *chunkReader) read(ctx context.Context, id string) ([]byte, error) {
+	location, ok := r.locations[id]
+	if !ok {
+		return nil, fmt.Errorf("chunk %s is missing from the index", id)
+	}
+
+	sealed, err := r.readSealed(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s from pack %s: %w", id, location.Pack, err)
+	}
+	data, err := r.key.Open(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+	}
+	if r.key.ID(data) != id {
+		return nil, fmt.Errorf("chunk %s does not match its content", id)
+	}
+	return data, nil
+}
+
+// readSealed fetches the sealed bytes of a chunk, with a ranged request
+// when the provider supports one
+func (r *chunkReader) readSealed(ctx context.Context, location chunker.Location) ([]byte, error) {
+	key := packKey(r.dir, location.Pack)
+	if ranger, ok := r.e.provider.(interfaces.RangeProvider); ok {
+		opCtx, cancel := r.e.transferContext(ctx, location.Length)
+		defer cancel()
+		data, err := r.e.downloadPart(opCtx, ranger, key, location.Offset, location.Length, interfaces.TransferOptions{})
+		r.e.recordRequests(r.dir.LocalPath, 0, 1, 0, int64(len(data)))
+		return data, err
+	}
+
+	if r.packID != location.Pack {
+		opCtx, cancel := r.e.operationContext(ctx)
+		defer cancel()
+		body, _, err := r.e.provider.Download(opCtx, key, interfaces.TransferOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(body)
+		body.Close()
+		r.e.recordRequests(r.dir.LocalPath, 0, 1, 0, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		r.packID, r.packData = location.Pack, data
+	}
+	if location.Offset+location.Length > int64(len(r.packData)) {
+		return nil, fmt.Errorf("chunk lies outside the pack")
+	}
+	return r.packData[location.Offset : location.Offset+location.Length], nil
+}
+
+// packKey returns the key of a pack
+func packKey(dir interfaces.SyncDirectory, id string) string {
+	return backupKey(dir, chunkPackPrefix, id[:2], id)
+}
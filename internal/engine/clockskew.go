@@ -0,0 +1,145 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// skewResolution is how precisely the clock skew is known: it is measured
+// from Date headers, which have second resolution
+const skewResolution = time.Second
+
+// SetClockSkewTolerance sets how far apart, after correcting for the
+// measured clock skew, local and remote modification times must be for
+// the newer one to be trusted. Closer files are compared by content.
+func (e *Engine) SetClockSkewTolerance(tolerance time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.clockSkewTolerance = tolerance
+}
+
+// needsUpload reports whether the local file should replace the remote
+// object. Objects record the modification time of the file they were
+// uploaded from, which is compared exactly when known. Otherwise the
+// remote modification time comes from the storage service's clock and is
+// converted to the local clock first. A local file modified within the
+// skew tolerance of the remote object cannot be told newer or older, so
+// the recorded time is fetched, or failing that the content compared.
+// Times closer than the skew can be measured are always treated this way.
+// Large files of directories with partial hashing are first compared with
+// the partial hash of their state record, whatever their times.
+func (e *Engine) needsUpload(ctx context.Context, dir interfaces.SyncDirectory, localPath string, localInfo os.FileInfo, remoteInfo interfaces.FileInfo) bool {
+	if e.contentFiltered(dir, localPath) {
+		// Filtered objects differ from the file, only the recorded
+		// modification time tells whether it changed
+		sourceModTime := remoteInfo.SourceModTime
+		if sourceModTime.IsZero() {
+			sourceModTime = e.sourceModTime(ctx, dir, remoteInfo.Key)
+		}
+		return sourceModTime.IsZero() || localInfo.ModTime().After(sourceModTime)
+	}
+	if localInfo.Size() != remoteInfo.Size {
+		return true
+	}
+	if unchanged, ok := e.partialHashUnchanged(dir, localPath, localInfo, remoteInfo); ok {
+		return !unchanged
+	}
+	if !remoteInfo.SourceModTime.IsZero() {
+		return localInfo.ModTime().After(remoteInfo.SourceModTime)
+	}
+
+	e.mutex.RLock()
+	tolerance := max(e.clockSkewTolerance, skewResolution)
+	e.mutex.RUnlock()
+
+	difference := localInfo.ModTime().Sub(e.localTime(remoteInfo.ModTime))
+	switch {
+	case difference > tolerance:
+		return true
+	case difference < -tolerance:
+		return false
+	}
+	if sourceModTime := e.sourceModTime(ctx, dir, remoteInfo.Key); !sourceModTime.IsZero() {
+		return localInfo.ModTime().After(sourceModTime)
+	}
+	_, same := e.sameContent(ctx, dir, localPath, remoteInfo)
+	return !same
+}
+
+// sourceModTime returns the modification time of the file key was
+// uploaded from, or the zero time when the object does not record it or
+// its metadata cannot be read
+func (e *Engine) sourceModTime(ctx context.Context, dir interfaces.SyncDirectory, key string) time.Time {
+	opCtx, cancel := e.operationContext(ctx)
+	metadata, err := e.provider.GetMetadata(opCtx, key)
+	cancel()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+	if err != nil {
+		e.logger.Debug("Failed to get remote modification time for comparison",
+			zap.String("remote_path", key),
+			errorField(err))
+		return time.Time{}
+	}
+	return metadata.SourceModTime
+}
+
+// localTime converts a timestamp of the storage service to the local clock
+func (e *Engine) localTime(remote time.Time) time.Time {
+	return remote.Add(-e.clockSkew())
+}
+
+// clockSkew returns how far the storage service's clock is ahead of the
+// local one, or zero before it was measured. A skew beyond the tolerance
+// is logged once until it falls back within it.
+func (e *Engine) clockSkew() time.Duration {
+	measurer, ok := e.provider.(interfaces.ClockSkewProvider)
+	if !ok {
+		return 0
+	}
+	skew, measured := measurer.ClockSkew()
+	if !measured {
+		return 0
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	exceeded := skew.Abs() > max(e.clockSkewTolerance, skewResolution)
+	if exceeded != e.skewReported {
+		e.skewReported = exceeded
+		if exceeded {
+			e.logger.Warn("Local clock differs from the storage service, correcting modification times",
+				zap.Duration("clock_skew", skew),
+				zap.Duration("tolerance", e.clockSkewTolerance))
+		} else {
+			e.logger.Info("Local clock agrees with the storage service again",
+				zap.Duration("clock_skew", skew))
+		}
+	}
+	return skew
+}
@@ -0,0 +1,452 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// defaultFilterTimeout limits content filter commands without a timeout
+const defaultFilterTimeout = time.Minute
+
+// defaultRedaction replaces matches of redact filters without a replacement
+const defaultRedaction = "[REDACTED]"
+
+// sniffLength is how many leading bytes identify the content type of a
+// file whose extension is not known
+const sniffLength = 512
+
+// errMalformedExif is returned when the Exif data of an image cannot be
+// parsed, so its location data cannot be removed
+var errMalformedExif = errors.New("malformed Exif data")
+
+// contentFilter transforms file content on its way to the storage service
+type contentFilter interface {
+	apply(ctx context.Context, path, contentType string, r io.Reader, w io.Writer) error
+}
+
+// newContentFilter creates the filter described by config
+func newContentFilter(config interfaces.ContentFilter) (contentFilter, error) {
+	switch {
+	case config.Command != "":
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = defaultFilterTimeout
+		}
+		return commandFilter{command: config.Command, timeout: timeout}, nil
+	case config.Builtin == interfaces.ContentFilterStripGPS:
+		return stripGPSFilter{}, nil
+	case config.Builtin == interfaces.ContentFilterRedact:
+		filter := redactFilter{replacement: []byte(config.Replacement)}
+		if config.Replacement == "" {
+			filter.replacement = []byte(defaultRedaction)
+		}
+		for _, pattern := range config.Redact {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redact pattern %q: %w", pattern, err)
+			}
+			filter.patterns = append(filter.patterns, re)
+		}
+		return filter, nil
+	}
+	return nil, fmt.Errorf("unknown content filter %q", config.Builtin)
+}
+
+// contentFiltersFor returns the content filters of dir that apply to the
+// file at path. contentType is only called when a filter matches by
+// content type.
+func contentFiltersFor(dir interfaces.SyncDirectory, path string, contentType func() string) []interfaces.ContentFilter {
+	var filters []interfaces.ContentFilter
+	name := filepath.Base(path)
+	detected := ""
+	for _, filter := range dir.ContentFilters {
+		if len(filter.Patterns) > 0 && !matchesAnyPattern(filter.Patterns, name) {
+			continue
+		}
+		if len(filter.ContentTypes) > 0 {
+			if detected == "" {
+				detected = contentType()
+			}
+			if !matchesContentType(filter.ContentTypes, detected) {
+				continue
+			}
+		}
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// matchesAnyPattern reports whether name matches one of the glob patterns
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesContentType reports whether contentType is one of types, which
+// may end in "/*" to match every subtype
+func matchesContentType(types []string, contentType string) bool {
+	for _, t := range types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(contentType, strings.ToLower(prefix)+"/") {
+				return true
+			}
+		} else if strings.EqualFold(t, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileContentType returns the content type of the file at path by its
+// extension or, when the extension is not known, by its first bytes
+func (e *Engine) fileContentType(path string) string {
+	if contentType := e.getContentType(path); contentType != "application/octet-stream" {
+		return contentType
+	}
+	file, err := e.fs.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	return sniffContentType(file)
+}
+
+// sniffContentType returns the content type of the data read from r,
+// without parameters such as the charset
+func sniffContentType(r io.Reader) string {
+	head := make([]byte, sniffLength)
+	n, _ := io.ReadFull(r, head)
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// contentFiltered reports whether uploads of the file at path are changed
+// by content filters of dir, so the remote object differs from the file
+func (e *Engine) contentFiltered(dir interfaces.SyncDirectory, path string) bool {
+	if len(dir.ContentFilters) == 0 {
+		return false
+	}
+	return len(contentFiltersFor(dir, path, func() string { return e.fileContentType(path) })) > 0
+}
+
+// filterContent runs the content filters of the directory of task that
+// match its file over file, in order, and returns the result in a
+// temporary file. It returns nil when no filter applies. The release
+// function closes and removes the temporary file.
+func (e *Engine) filterContent(ctx context.Context, task syncTask, file interfaces.File) (interfaces.File, func(), error) {
+	dir, ok := e.directoryFor(task.localPath)
+	if !ok || len(dir.ContentFilters) == 0 {
+		return nil, nil, nil
+	}
+
+	contentType := func() string {
+		if contentType := e.getContentType(task.localPath); contentType != "application/octet-stream" {
+			return contentType
+		}
+		defer file.Seek(0, io.SeekStart)
+		return sniffContentType(file)
+	}
+	configs := contentFiltersFor(dir, task.localPath, contentType)
+	if len(configs) == 0 {
+		return nil, nil, nil
+	}
+	detected := contentType()
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to reset file pointer: %w", err)
+	}
+
+	var input io.Reader = file
+	var output interfaces.File
+	var outputPath string
+	release := func() {
+		if output != nil {
+			output.Close()
+			e.fs.Remove(outputPath)
+		}
+	}
+	for _, config := range configs {
+		filter, err := newContentFilter(config)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+
+		path := filepath.Join(os.TempDir(), fmt.Sprintf(".cloudawsync-filter-%d-%d", os.Getpid(), e.snapshotSeq.Add(1)))
+		next, err := e.fs.Create(path)
+		if err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to create filtered copy: %w", err)
+		}
+		err = filter.apply(ctx, task.localPath, detected, input, next)
+		release()
+		output, outputPath = next, path
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		if _, err := output.Seek(0, io.SeekStart); err != nil {
+			release()
+			return nil, nil, fmt.Errorf("failed to reset filtered copy: %w", err)
+		}
+		input = output
+	}
+	return output, release, nil
+}
+
+// commandFilter pipes content through a shell command
+type commandFilter struct {
+	command string
+	timeout time.Duration
+}
+
+func (f commandFilter) apply(ctx context.Context, path, contentType string, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", f.command)
+	cmd.Env = append(os.Environ(),
+		"CLOUDAWSYNC_FILE_PATH="+path,
+		"CLOUDAWSYNC_CONTENT_TYPE="+contentType)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(stderr.String()); out != "" {
+			return fmt.Errorf("content filter command failed: %w: %s", err, out)
+		}
+		return fmt.Errorf("content filter command failed: %w", err)
+	}
+	return nil
+}
+
+// redactFilter replaces matches of regular expressions line by line, so
+// matches cannot span lines
+type redactFilter struct {
+	patterns    []*regexp.Regexp
+	replacement []byte
+}
+
+func (f redactFilter) apply(ctx context.Context, path, contentType string, r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	writer := bufio.NewWriter(w)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			text, newline := bytes.CutSuffix(line, []byte("\n"))
+			for _, pattern := range f.patterns {
+				text = pattern.ReplaceAllLiteral(text, f.replacement)
+			}
+			writer.Write(text)
+			if newline {
+				writer.WriteByte('\n')
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read content: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write filtered content: %w", err)
+	}
+	return nil
+}
+
+// stripGPSFilter removes the GPS block from the Exif data of JPEG images.
+// Other content is copied unchanged.
+type stripGPSFilter struct{}
+
+func (stripGPSFilter) apply(ctx context.Context, path, contentType string, r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	if head, err := reader.Peek(2); err != nil || head[0] != 0xFF || head[1] != 0xD8 {
+		_, err := io.Copy(w, reader)
+		return err
+	}
+	reader.Discard(2)
+	if _, err := w.Write([]byte{0xFF, 0xD8}); err != nil {
+		return err
+	}
+
+	for {
+		marker, err := readJPEGMarker(reader)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{0xFF, marker}); err != nil {
+			return err
+		}
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			// Entropy-coded image data follows the start of scan
+			_, err := io.Copy(w, reader)
+			return err
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			continue // no length or payload
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		size := int(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return fmt.Errorf("invalid JPEG segment length %d", size)
+		}
+		payload := make([]byte, size-2)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		if exif, ok := bytes.CutPrefix(payload, []byte("Exif\x00\x00")); ok && marker == 0xE1 {
+			if err := removeExifGPS(exif); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(length[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// readJPEGMarker reads the next segment marker, skipping fill bytes
+func readJPEGMarker(reader *bufio.Reader) (byte, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read JPEG marker: %w", err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("invalid JPEG marker 0x%02x", b)
+	}
+	for b == 0xFF {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, fmt.Errorf("failed to read JPEG marker: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// exifGPSTag is the IFD0 entry pointing to the GPS block
+const exifGPSTag = 0x8825
+
+// exifTypeSizes are the sizes of the TIFF field types by type number
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// removeExifGPS clears the GPS block of the TIFF structure in tiff and
+// removes the IFD0 entry pointing to it. The length of tiff is unchanged,
+// so offsets to other data stay valid.
+func removeExifGPS(tiff []byte) error {
+	if len(tiff) < 8 {
+		return errMalformedExif
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errMalformedExif
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return errMalformedExif
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	end := ifd + 2 + count*12 + 4 // entries and the next IFD offset
+	if end > len(tiff) {
+		return errMalformedExif
+	}
+
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if order.Uint16(tiff[entry:]) != exifGPSTag {
+			continue
+		}
+		if err := clearIFD(tiff, order, int(order.Uint32(tiff[entry+8:]))); err != nil {
+			return err
+		}
+		copy(tiff[entry:end], tiff[entry+12:end])
+		clear(tiff[end-12 : end])
+		order.PutUint16(tiff[ifd:], uint16(count-1))
+		return nil
+	}
+	return nil
+}
+
+// clearIFD zeroes the IFD at offset in tiff, with the values its entries
+// point to
+func clearIFD(tiff []byte, order binary.ByteOrder, offset int) error {
+	if offset < 8 || offset+2 > len(tiff) {
+		return errMalformedExif
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	end := offset + 2 + count*12 + 4
+	if end > len(tiff) {
+		return errMalformedExif
+	}
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		size := exifTypeSizes[order.Uint16(tiff[entry+2:])] * int(order.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			value := int(order.Uint32(tiff[entry+8:]))
+			if value < 8 || size > len(tiff)-value {
+				return errMalformedExif
+			}
+			clear(tiff[value : value+size])
+		}
+	}
+	clear(tiff[offset:end])
+	return nil
+}
@@ -0,0 +1,79 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newSyncID returns a random correlation ID for a sync, or for an upload
+// not started by one, logged with every step from scan to upload
+func newSyncID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// withSyncID returns ctx carrying the correlation ID of task, assigning
+// one to uploads queued outside a sync, such as those of change events
+func withSyncID(ctx context.Context, task *syncTask) context.Context {
+	if task.syncID == "" {
+		task.syncID = newSyncID()
+	}
+	return interfaces.WithSyncID(ctx, task.syncID)
+}
+
+// loggedError logs an error with the IDs of the storage service request
+// that failed, if any
+type loggedError struct {
+	err error
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (l loggedError) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("error", l.err.Error())
+	requestID, extendedRequestID := interfaces.RequestIDs(l.err)
+	if requestID != "" {
+		enc.AddString("request_id", requestID)
+	}
+	if extendedRequestID != "" {
+		enc.AddString("extended_request_id", extendedRequestID)
+	}
+	return nil
+}
+
+// errorField returns a log field for err that, like zap.Error, is skipped
+// when err is nil and also holds the IDs S3 server access logs record for
+// the failed request
+func errorField(err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.Inline(loggedError{err: err})
+}
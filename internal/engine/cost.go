@@ -0,0 +1,303 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// errBudgetExceeded is returned when transfers are paused by the budget
+var errBudgetExceeded = errors.New("monthly transfer budget exceeded")
+
+const bytesPerGB = 1024 * 1024 * 1024
+
+// StorageClassRates holds default S3 storage prices in USD per GB-month
+var StorageClassRates = map[string]float64{
+	"STANDARD":            0.023,
+	"INTELLIGENT_TIERING": 0.023,
+	"STANDARD_IA":         0.0125,
+	"ONEZONE_IA":          0.01,
+	"GLACIER_IR":          0.004,
+	"GLACIER":             0.0036,
+	"DEEP_ARCHIVE":        0.00099,
+}
+
+// CostModel describes the prices used to estimate S3 spend in USD
+type CostModel struct {
+	PutPer1000        float64 // PUT, COPY, POST and LIST requests
+	GetPer1000        float64 // GET and HEAD requests
+	ListPer1000       float64
+	StoragePerGBMonth float64
+	EgressPerGB       float64
+}
+
+// DefaultCostModel returns S3 standard pricing for the given storage class
+func DefaultCostModel(storageClass string) CostModel {
+	rate, ok := StorageClassRates[strings.ToUpper(storageClass)]
+	if !ok {
+		rate = StorageClassRates["STANDARD"]
+	}
+	return CostModel{
+		PutPer1000:        0.005,
+		GetPer1000:        0.0004,
+		ListPer1000:       0.005,
+		StoragePerGBMonth: rate,
+		EgressPerGB:       0.09,
+	}
+}
+
+// CostEstimate is the estimated spend for a directory in the current month
+type CostEstimate struct {
+	PutRequests  int64
+	GetRequests  int64
+	ListRequests int64
+	EgressBytes  int64
+	StorageBytes int64
+	Requests     float64 // USD
+	Egress       float64 // USD
+	Storage      float64 // USD, projected for the full month
+	Total        float64 // USD
+}
+
+// costTracker accumulates request counts per directory for the current month
+type costTracker struct {
+	model        CostModel
+	budget       float64
+	pauseOnLimit bool
+	month        time.Month
+	year         int
+	scopes       map[string]*CostEstimate
+	overBudget   bool
+}
+
+// SetCostModel enables cost estimation. If budget is greater than zero a
+// warning is logged when the estimated monthly spend reaches it, and
+// transfers are paused until the next month when pause is set.
+func (e *Engine) SetCostModel(model CostModel, budget float64, pause bool) {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	now := e.clock.Now()
+	e.costs = &costTracker{
+		model:        model,
+		budget:       budget,
+		pauseOnLimit: pause,
+		month:        now.Month(),
+		year:         now.Year(),
+		scopes:       make(map[string]*CostEstimate),
+	}
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		e.restoreCostsLocked(store)
+	}
+}
+
+// restoreCosts loads the cost counters recorded in store, so the month's
+// estimates and a reached budget survive restarts
+func (e *Engine) restoreCosts(store *state.Store) {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	if e.costs != nil {
+		e.restoreCostsLocked(store)
+	}
+}
+
+// restoreCostsLocked loads the counters of the current month from store.
+// Counters of earlier months only keep their stored bytes. The caller must
+// hold costMutex.
+func (e *Engine) restoreCostsLocked(store *state.Store) {
+	for _, counters := range store.Costs() {
+		estimate := &CostEstimate{StorageBytes: counters.StorageBytes}
+		if counters.Year == e.costs.year && counters.Month == e.costs.month {
+			estimate.PutRequests = counters.PutRequests
+			estimate.GetRequests = counters.GetRequests
+			estimate.ListRequests = counters.ListRequests
+			estimate.EgressBytes = counters.EgressBytes
+		}
+		e.costs.scopes[counters.Scope] = estimate
+	}
+	for scope, estimate := range e.costs.scopes {
+		e.updateCost(scope, estimate)
+	}
+}
+
+// recordRequests adds provider requests and egress for a directory
+func (e *Engine) recordRequests(scope string, puts, gets, lists, egressBytes int64) {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	if e.costs == nil {
+		return
+	}
+	e.rollCostMonth()
+
+	estimate := e.costs.scope(scope)
+	estimate.PutRequests += puts
+	estimate.GetRequests += gets
+	estimate.ListRequests += lists
+	estimate.EgressBytes += egressBytes
+	e.updateCost(scope, estimate)
+}
+
+// recordStorageCost updates the stored bytes used for storage projections
+func (e *Engine) recordStorageCost(scope string, bytes int64) {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	if e.costs == nil {
+		return
+	}
+	e.rollCostMonth()
+
+	estimate := e.costs.scope(scope)
+	estimate.StorageBytes = bytes
+	e.updateCost(scope, estimate)
+}
+
+// checkBudget returns an error if transfers are paused by the budget
+func (e *Engine) checkBudget() error {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	if e.costs == nil {
+		return nil
+	}
+	e.rollCostMonth()
+
+	if e.costs.overBudget && e.costs.pauseOnLimit {
+		return fmt.Errorf("%w: budget $%.2f", errBudgetExceeded, e.costs.budget)
+	}
+	return nil
+}
+
+// GetCostEstimates returns the current month's estimated spend per directory
+func (e *Engine) GetCostEstimates() map[string]CostEstimate {
+	e.costMutex.Lock()
+	defer e.costMutex.Unlock()
+
+	if e.costs == nil {
+		return nil
+	}
+	e.rollCostMonth()
+
+	result := make(map[string]CostEstimate, len(e.costs.scopes))
+	for scope, estimate := range e.costs.scopes {
+		result[scope] = *estimate
+	}
+	return result
+}
+
+// scope returns the estimate for a scope, creating it if needed
+func (c *costTracker) scope(name string) *CostEstimate {
+	estimate, ok := c.scopes[name]
+	if !ok {
+		estimate = &CostEstimate{}
+		c.scopes[name] = estimate
+	}
+	return estimate
+}
+
+// rollCostMonth resets request counters when a new month starts.
+// The caller must hold costMutex.
+func (e *Engine) rollCostMonth() {
+	now := e.clock.Now()
+	if now.Month() == e.costs.month && now.Year() == e.costs.year {
+		return
+	}
+
+	e.costs.month = now.Month()
+	e.costs.year = now.Year()
+	for scope, estimate := range e.costs.scopes {
+		reset := &CostEstimate{StorageBytes: estimate.StorageBytes}
+		e.costs.scopes[scope] = reset
+		e.saveCost(scope, reset)
+	}
+	if e.costs.overBudget {
+		e.logger.Info("New billing month started, transfer budget reset")
+	}
+	e.costs.overBudget = false
+}
+
+// updateCost recomputes a scope's estimate and evaluates the budget.
+// The caller must hold costMutex.
+func (e *Engine) updateCost(scope string, estimate *CostEstimate) {
+	model := e.costs.model
+	estimate.Requests = float64(estimate.PutRequests)/1000*model.PutPer1000 +
+		float64(estimate.GetRequests)/1000*model.GetPer1000 +
+		float64(estimate.ListRequests)/1000*model.ListPer1000
+	estimate.Egress = float64(estimate.EgressBytes) / bytesPerGB * model.EgressPerGB
+	estimate.Storage = float64(estimate.StorageBytes) / bytesPerGB * model.StoragePerGBMonth
+	estimate.Total = estimate.Requests + estimate.Egress + estimate.Storage
+
+	e.metrics.RecordEstimatedCost(scope, estimate.Total)
+	e.saveCost(scope, estimate)
+
+	var total float64
+	for _, s := range e.costs.scopes {
+		total += s.Total
+	}
+
+	e.mutex.Lock()
+	e.stats.EstimatedCost = total
+	e.mutex.Unlock()
+
+	if e.costs.budget > 0 && total >= e.costs.budget && !e.costs.overBudget {
+		e.costs.overBudget = true
+		e.logger.Warn("Estimated monthly spend reached budget",
+			zap.Float64("estimated_cost", total),
+			zap.Float64("budget", e.costs.budget),
+			zap.Bool("transfers_paused", e.costs.pauseOnLimit))
+	}
+}
+
+// saveCost writes the counters of a scope to the state store, if one is
+// set. The caller must hold costMutex.
+func (e *Engine) saveCost(scope string, estimate *CostEstimate) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	store.PutCost(state.CostCounters{
+		Scope:        scope,
+		Year:         e.costs.year,
+		Month:        e.costs.month,
+		PutRequests:  estimate.PutRequests,
+		GetRequests:  estimate.GetRequests,
+		ListRequests: estimate.ListRequests,
+		EgressBytes:  estimate.EgressBytes,
+		StorageBytes: estimate.StorageBytes,
+	})
+}
@@ -0,0 +1,244 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// defaultDeletionMaxPercent is the share of a directory's objects whose
+// files may be found deleted in one scan before deletions are held back
+const defaultDeletionMaxPercent = 50
+
+// trashPrefix returns the remote prefix that trashed files of dir are
+// moved below
+func trashPrefix(dir interfaces.SyncDirectory) string {
+	if path := strings.Trim(dir.Deletions.TrashPath, "/"); path != "" {
+		return path + "/"
+	}
+	return ".trash/" + remoteDirPrefix(dir)
+}
+
+// handleLocalDeletions applies dir's deletion policy to the objects left
+// in remaining by a full scan that the state database records as uploaded
+// from a file, meaning the file was deleted since. Objects trashed or
+// deleted are removed from remaining. listed is the number of objects the
+// scan listed, against which a mass deletion, such as of an unmounted
+// disk, is recognised and held back. Files below paths the scan could not
+// read are not known to be deleted and are left alone.
+func (e *Engine) handleLocalDeletions(ctx context.Context, dir interfaces.SyncDirectory, remaining map[string]interfaces.FileInfo, listed int) {
+	policy := dir.Deletions
+	if policy.Action == interfaces.DeletionIgnore {
+		return
+	}
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	unreadable := e.UnreadableFiles(dir.LocalPath)
+	var deleted []string
+	unknown := 0
+	for key, info := range remaining {
+		if info.IsDir {
+			continue
+		}
+		record, ok := store.Get(key)
+		if !ok || !record.ArchivedAt.IsZero() {
+			continue
+		}
+		if belowUnreadable(record.LocalPath, unreadable) {
+			unknown++
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+	sort.Strings(deleted)
+	if unknown > 0 {
+		e.logger.Debug("Not handling deletions below unreadable paths",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("files", unknown))
+	}
+	e.setLocalDeletions(dir, len(deleted))
+	if len(deleted) == 0 {
+		return
+	}
+
+	maxPercent := policy.MaxPercent
+	if maxPercent <= 0 {
+		maxPercent = defaultDeletionMaxPercent
+	}
+	if policy.Action != interfaces.DeletionReport && len(deleted)*100 > listed*maxPercent {
+		e.logger.Error("Too many files deleted locally, not propagating deletions",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("deleted", len(deleted)),
+			zap.Int("objects", listed),
+			zap.Int("max_percent", maxPercent))
+		return
+	}
+
+	now := e.clock.Now()
+	handled := 0
+	for _, key := range deleted {
+		if ctx.Err() != nil {
+			return
+		}
+		record, _ := store.Get(key)
+		event := interfaces.SyncEvent{
+			Type:       interfaces.EventLocalDeleted,
+			Directory:  dir.LocalPath,
+			LocalPath:  record.LocalPath,
+			RemotePath: key,
+			Size:       remaining[key].Size,
+		}
+
+		if policy.Action == interfaces.DeletionReport {
+			// Each deletion is reported once, not by every scan
+			if since := store.MarkUnseen(key, now); !since.Equal(now) {
+				continue
+			}
+			e.logger.Info("File deleted locally, remote copy kept",
+				zap.String("local_path", record.LocalPath),
+				zap.String("remote_path", key))
+			e.publish(event)
+			handled++
+			continue
+		}
+
+		err := e.removeDeleted(ctx, dir, key)
+		entry := audit.Entry{
+			Action:    string(policy.Action),
+			Key:       key,
+			LocalPath: record.LocalPath,
+			Reason:    "deleted locally",
+		}
+		if err != nil {
+			entry.SetError(err)
+			e.audit(ctx, entry)
+			e.logger.Error("Failed to propagate local deletion",
+				zap.String("local_path", record.LocalPath),
+				zap.String("remote_path", key),
+				zap.String("action", string(policy.Action)),
+				errorField(err))
+			event.Error = err.Error()
+			e.publish(event)
+			continue
+		}
+		e.audit(ctx, entry)
+		e.publish(event)
+		delete(remaining, key)
+		handled++
+	}
+
+	if handled > 0 {
+		e.logger.Info("Handled files deleted locally",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("action", string(policy.Action)),
+			zap.Int("files", handled))
+	}
+	if policy.Action != interfaces.DeletionReport {
+		e.setLocalDeletions(dir, len(deleted)-handled)
+	}
+}
+
+// belowUnreadable reports whether path or one of its parent directories is
+// in unreadable
+func belowUnreadable(path string, unreadable map[string]string) bool {
+	for {
+		if _, ok := unreadable[path]; ok {
+			return true
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return false
+		}
+		path = parent
+	}
+}
+
+// removeDeleted trashes or deletes the remote copy of a file deleted
+// locally and forgets its state record
+func (e *Engine) removeDeleted(ctx context.Context, dir interfaces.SyncDirectory, key string) error {
+	if dir.Deletions.Action == interfaces.DeletionTrash {
+		copier, ok := e.provider.(interfaces.CopyProvider)
+		if !ok {
+			return fmt.Errorf("storage provider cannot move objects to the trash")
+		}
+		target := trashPrefix(dir) + strings.TrimPrefix(key, remoteDirPrefix(dir))
+		opCtx, cancel := e.operationContext(ctx)
+		err := copier.Copy(opCtx, key, target)
+		cancel()
+		// The copy is billed as a PUT, the DELETE below is free
+		e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to move to %s: %w", target, err)
+		}
+	}
+
+	opCtx, cancel := e.operationContext(ctx)
+	err := e.provider.Delete(opCtx, key)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+	e.forgetObject(key)
+	e.mutex.Lock()
+	e.stats.FilesDeleted++
+	e.mutex.Unlock()
+	e.countDeleted(ctx)
+	return nil
+}
+
+// setLocalDeletions records the number of files of dir found deleted
+// locally whose remote copies remain
+func (e *Engine) setLocalDeletions(dir interfaces.SyncDirectory, count int) {
+	e.dirStatusMutex.Lock()
+	defer e.dirStatusMutex.Unlock()
+	status, ok := e.dirStatus[dir.LocalPath]
+	if !ok {
+		status = &interfaces.DirectoryStatus{}
+		e.dirStatus[dir.LocalPath] = status
+	}
+	status.LocalDeletions = count
+}
+
+// clearUnseen records that the file of a remote object exists again, so
+// a later deletion of it is reported
+func (e *Engine) clearUnseen(key string) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		store.ClearUnseen(key)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// remainingObjects returns the listing entries of keys, as left by a scan
+// that found no local file for them
+func remainingObjects(keys ...string) map[string]interfaces.FileInfo {
+	remaining := make(map[string]interfaces.FileInfo, len(keys))
+	for _, key := range keys {
+		remaining[key] = interfaces.FileInfo{Key: key, Size: 4}
+	}
+	return remaining
+}
+
+func TestHandleLocalDeletionsSkipsUnreadablePaths(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	dir := interfaces.SyncDirectory{
+		LocalPath:  "/data",
+		RemotePath: "data",
+		Deletions:  interfaces.DeletionPolicy{Action: interfaces.DeletionDelete, MaxPercent: 100},
+	}
+
+	te.putUploaded("data/gone.txt", "/data/gone.txt", []byte("gone"))
+	te.putUploaded("data/private/a.txt", "/data/private/a.txt", []byte("keep"))
+	te.putUploaded("data/private/deep/b.txt", "/data/private/deep/b.txt", []byte("keep"))
+	te.putUploaded("data/locked.txt", "/data/locked.txt", []byte("keep"))
+	te.markUnreadable(ctx, "/data/private", errors.New("permission denied"))
+	te.markUnreadable(ctx, "/data/locked.txt", errors.New("permission denied"))
+
+	remaining := remainingObjects("data/gone.txt", "data/private/a.txt", "data/private/deep/b.txt", "data/locked.txt")
+	te.handleLocalDeletions(ctx, dir, remaining, len(remaining))
+
+	want := []string{"data/locked.txt", "data/private/a.txt", "data/private/deep/b.txt"}
+	if got := te.provider.Keys(); !slices.Equal(got, want) {
+		t.Errorf("remote keys = %v, want %v", got, want)
+	}
+	if _, ok := te.store.Get("data/private/a.txt"); !ok {
+		t.Error("record below an unreadable directory was forgotten")
+	}
+	if _, ok := remaining["data/gone.txt"]; ok {
+		t.Error("deleted object left in remaining")
+	}
+}
+
+func TestHandleLocalDeletionsMaxPercent(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     interfaces.DeletionAction
+		maxPercent int
+		wantKeys   int
+	}{
+		{name: "default holds back", action: interfaces.DeletionDelete, wantKeys: 4},
+		{name: "below limit holds back", action: interfaces.DeletionDelete, maxPercent: 74, wantKeys: 4},
+		{name: "at limit deletes", action: interfaces.DeletionDelete, maxPercent: 75, wantKeys: 1},
+		{name: "report ignores limit", action: interfaces.DeletionReport, maxPercent: 10, wantKeys: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := newTestEngine(t)
+			dir := interfaces.SyncDirectory{
+				LocalPath:  "/data",
+				RemotePath: "data",
+				Deletions:  interfaces.DeletionPolicy{Action: tt.action, MaxPercent: tt.maxPercent},
+			}
+			for _, name := range []string{"a", "b", "c", "d"} {
+				te.putUploaded("data/"+name, "/data/"+name, []byte(name))
+			}
+
+			// Three of four listed objects lost their local file
+			remaining := remainingObjects("data/a", "data/b", "data/c")
+			te.handleLocalDeletions(context.Background(), dir, remaining, 4)
+
+			if got := len(te.provider.Keys()); got != tt.wantKeys {
+				t.Errorf("remote objects = %d, want %d", got, tt.wantKeys)
+			}
+		})
+	}
+}
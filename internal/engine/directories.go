@@ -0,0 +1,303 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// directoryContext is cancelled when its directory is removed. It is kept
+// after removal so that tasks still queued for the directory are dropped.
+type directoryContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newDirectoryContext creates the context of a newly added directory
+func newDirectoryContext() *directoryContext {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &directoryContext{ctx: ctx, cancel: cancel}
+}
+
+// taskContext derives a context from ctx that is also cancelled when the
+// directory at root is removed. The returned function releases it.
+func (e *Engine) taskContext(ctx context.Context, root string) (context.Context, func()) {
+	e.mutex.RLock()
+	dirCtx, ok := e.dirContexts[root]
+	e.mutex.RUnlock()
+	if !ok {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(dirCtx.ctx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// directoryRemoved reports whether the directory at root was removed
+func (e *Engine) directoryRemoved(root string) bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	dirCtx, ok := e.dirContexts[root]
+	return ok && dirCtx.ctx.Err() != nil
+}
+
+// configuredDirectory returns the directory with the given local path
+func (e *Engine) configuredDirectory(localPath string) (interfaces.SyncDirectory, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	for _, dir := range e.directories {
+		if dir.LocalPath == localPath {
+			return dir, true
+		}
+	}
+	return interfaces.SyncDirectory{}, false
+}
+
+// EnableDirectory resumes syncing a disabled directory. Realtime
+// directories are added to the file watcher when the engine is running.
+func (e *Engine) EnableDirectory(localPath string) error {
+	return e.setDirectoryEnabled(localPath, true)
+}
+
+// DisableDirectory stops file events and scheduled syncs of a directory
+// until it is enabled again. Transfers already queued still complete.
+func (e *Engine) DisableDirectory(localPath string) error {
+	return e.setDirectoryEnabled(localPath, false)
+}
+
+// setDirectoryEnabled enables or disables the directory at localPath
+func (e *Engine) setDirectoryEnabled(localPath string, enabled bool) error {
+	e.mutex.Lock()
+	index := slices.IndexFunc(e.directories, func(dir interfaces.SyncDirectory) bool {
+		return dir.LocalPath == localPath
+	})
+	if index < 0 {
+		e.mutex.Unlock()
+		return fmt.Errorf("directory %s is not configured", localPath)
+	}
+	e.directories[index].Enabled = enabled
+	dir := e.directories[index]
+	e.mutex.Unlock()
+
+	if enabled {
+		e.logger.Info("Enabled directory", zap.String("local_path", localPath))
+	} else {
+		e.logger.Info("Disabled directory", zap.String("local_path", localPath))
+	}
+
+	if enabled && (dir.SyncMode == interfaces.SyncModeRealtime || dir.SyncMode == interfaces.SyncModeBoth) {
+		if err := e.watchDirectory(localPath); err != nil {
+			return fmt.Errorf("failed to watch directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// watchDirectory adds a directory to the file watcher of a running
+// engine, starting the watcher if it was not needed so far
+func (e *Engine) watchDirectory(localPath string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.running || e.watcher == nil || e.watched[localPath] {
+		return nil
+	}
+
+	if e.watching {
+		watcher, ok := e.watcher.(interfaces.DirectoryWatcher)
+		if !ok {
+			return fmt.Errorf("file watcher cannot add directories while running")
+		}
+		if err := watcher.AddDirectory(localPath); err != nil {
+			return err
+		}
+	} else {
+		events, err := e.watcher.Watch(e.runCtx, []string{localPath})
+		if err != nil {
+			return err
+		}
+		e.watching = true
+		e.wg.Add(1)
+		go e.handleFileEvents(e.runCtx, events)
+	}
+	e.watched[localPath] = true
+
+	e.logger.Info("Added directory to file watcher", zap.String("path", localPath))
+	return nil
+}
+
+// SyncNow syncs the enabled directory at localPath, returning once its
+// changes are found and queued
+func (e *Engine) SyncNow(ctx context.Context, localPath string) error {
+	dir, ok := e.configuredDirectory(localPath)
+	if !ok {
+		return fmt.Errorf("directory %s is not configured", localPath)
+	}
+	if !dir.Enabled {
+		return fmt.Errorf("directory %s is disabled", localPath)
+	}
+	return e.Sync(ctx, dir)
+}
+
+// RemoveDirectory stops syncing the directory at localPath. It is removed
+// from the file watcher, its queued transfers are dropped and running ones
+// cancelled, and its deferred and offline uploads are forgotten. With
+// deleteRemote the objects below its remote path are deleted as well and
+// their number returned.
+func (e *Engine) RemoveDirectory(ctx context.Context, localPath string, deleteRemote bool) (int, error) {
+	e.mutex.Lock()
+	index := slices.IndexFunc(e.directories, func(dir interfaces.SyncDirectory) bool {
+		return dir.LocalPath == localPath
+	})
+	if index < 0 {
+		e.mutex.Unlock()
+		return 0, fmt.Errorf("directory %s is not configured", localPath)
+	}
+	dir := e.directories[index]
+	e.directories = slices.Delete(e.directories, index, index+1)
+	e.stats.ActiveDirectories = len(e.directories)
+	if dirCtx, ok := e.dirContexts[localPath]; ok {
+		dirCtx.cancel()
+	}
+	watched := e.watched[localPath]
+	delete(e.watched, localPath)
+	e.mutex.Unlock()
+	e.uploadQueue.removePolicy(localPath)
+
+	if watched {
+		if watcher, ok := e.watcher.(interfaces.DirectoryWatcher); ok {
+			if err := watcher.RemoveDirectory(localPath); err != nil {
+				e.logger.Warn("Failed to remove directory from file watcher",
+					zap.String("local_path", localPath),
+					errorField(err))
+			}
+		}
+	}
+
+	e.forgetDirectoryTasks(localPath)
+	e.releaseSnapshot(localPath)
+	e.forgetQuota(localPath)
+
+	e.dirStatusMutex.Lock()
+	delete(e.dirStatus, localPath)
+	e.dirStatusMutex.Unlock()
+
+	e.logger.Info("Removed directory from sync",
+		zap.String("local_path", localPath),
+		zap.String("remote_path", dir.RemotePath))
+
+	if !deleteRemote {
+		return 0, nil
+	}
+	deleted, err := e.deleteRemotePrefix(ctx, remoteDirPrefix(dir))
+	if err != nil {
+		return deleted, fmt.Errorf("failed to delete remote objects of %s: %w", localPath, err)
+	}
+	e.logger.Info("Deleted remote objects of removed directory",
+		zap.String("local_path", localPath),
+		zap.Int("objects", deleted))
+	return deleted, nil
+}
+
+// forgetDirectoryTasks drops the deferred, offline, unreadable and
+// quarantined uploads of files below root
+func (e *Engine) forgetDirectoryTasks(root string) {
+	e.throttleMutex.Lock()
+	for path := range e.throttled {
+		if withinDirectory(path, root) {
+			delete(e.throttled, path)
+		}
+	}
+	e.throttleMutex.Unlock()
+
+	e.offlineMutex.Lock()
+	var offline []string
+	for path, task := range e.offlineQueue {
+		if task.rootPath == root {
+			offline = append(offline, path)
+		}
+	}
+	e.offlineMutex.Unlock()
+	for _, path := range offline {
+		e.forgetOffline(path)
+	}
+
+	e.unreadableMutex.Lock()
+	removed := 0
+	for path := range e.unreadable {
+		if withinDirectory(path, root) {
+			delete(e.unreadable, path)
+			removed++
+		}
+	}
+	count := len(e.unreadable)
+	e.unreadableMutex.Unlock()
+	if removed > 0 {
+		e.recordUnreadable(count)
+	}
+
+	e.forgetQuarantine(root)
+}
+
+// deleteRemotePrefix deletes every object below prefix, forgetting their
+// recorded state
+func (e *Engine) deleteRemotePrefix(ctx context.Context, prefix string) (int, error) {
+	if strings.Trim(prefix, "/") == "" {
+		return 0, fmt.Errorf("refusing to delete the whole bucket")
+	}
+
+	listCtx, cancel := e.listContext(ctx)
+	files, err := e.provider.List(listCtx, prefix)
+	cancel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list remote files: %w", err)
+	}
+
+	deleted := 0
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		opCtx, cancel := e.operationContext(ctx)
+		err := e.provider.Delete(opCtx, file.Key)
+		cancel()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete %s: %w", file.Key, err)
+		}
+		e.forgetObject(file.Key)
+		deleted++
+	}
+	return deleted, nil
+}
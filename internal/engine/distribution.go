@@ -0,0 +1,753 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+const (
+	releasesDir          = "releases"             // below the remote path, holds bundles and their manifests
+	releasePointerName   = "current.json"         // below the remote path, names the current release
+	releaseMarkerName    = ".cloudawsync-release" // in an applied directory, names its release
+	releaseFormatVersion = 1
+
+	defaultKeepReleases     = 5
+	defaultPostApplyTimeout = time.Minute
+
+	// Siblings of a subscriber directory holding the release being
+	// applied and the one it replaced
+	releaseStagingSuffix  = ".cloudawsync-staging"
+	releasePreviousSuffix = ".cloudawsync-previous"
+)
+
+// ReleasePointer names the current release of a distributed directory. It
+// is uploaded after the release, so subscribers never see a partial one.
+type ReleasePointer struct {
+	Version     int       `json:"version"`
+	Release     string    `json:"release"`  // e.g. 20250102T150405Z-1a2b3c4d
+	Manifest    string    `json:"manifest"` // key of the ReleaseManifest
+	Digest      string    `json:"digest"`   // of the file names, sizes, times and modes published
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ReleaseManifest describes the bundle of one release and the files in it
+type ReleaseManifest struct {
+	Version    int           `json:"version"`
+	Release    string        `json:"release"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Bundle     string        `json:"bundle"` // key of the zstd-compressed tar archive
+	BundleSize int64         `json:"bundle_size"`
+	BundleMD5  string        `json:"bundle_md5"`
+	Files      []ReleaseFile `json:"files"`
+}
+
+// ReleaseFile is a file of a release, by path relative to the directory
+type ReleaseFile struct {
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	MD5Hash string      `json:"md5_hash"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+// releaseSource is a local file to be published
+type releaseSource struct {
+	path    string
+	relPath string // slash-separated
+	info    os.FileInfo
+}
+
+func releasePointerKey(dir interfaces.SyncDirectory) string {
+	return remoteDirPrefix(dir) + releasePointerName
+}
+
+func releasePrefix(dir interfaces.SyncDirectory) string {
+	return remoteDirPrefix(dir) + releasesDir + "/"
+}
+
+// publishRelease uploads the files of a publisher directory as a new
+// release when they changed since the current one. The bundle and its
+// manifest are uploaded before the pointer, and releases beyond
+// keep_releases are removed.
+func (e *Engine) publishRelease(ctx context.Context, dir interfaces.SyncDirectory) error {
+	sources, digest, err := e.releaseSources(ctx, dir)
+	if err != nil {
+		return err
+	}
+	current, err := e.loadReleasePointer(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.Digest == digest {
+		e.logger.Debug("Release unchanged",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("release", current.Release))
+		return nil
+	}
+
+	now := e.clock.Now()
+	release := now.UTC().Format(generationIDFormat) + "-" + digest[:8]
+	bundle, err := os.CreateTemp("", ".cloudawsync-release-*")
+	if err != nil {
+		return fmt.Errorf("failed to create release bundle: %w", err)
+	}
+	defer os.Remove(bundle.Name())
+	defer bundle.Close()
+
+	manifest := &ReleaseManifest{
+		Version:   releaseFormatVersion,
+		Release:   release,
+		CreatedAt: now,
+		Bundle:    releasePrefix(dir) + release + ".tar.zst",
+		Files:     make([]ReleaseFile, 0, len(sources)),
+	}
+	bundleHash := md5.New()
+	if err := writeReleaseBundle(ctx, io.MultiWriter(bundle, bundleHash), sources, manifest); err != nil {
+		return err
+	}
+	info, err := bundle.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get bundle info: %w", err)
+	}
+	manifest.BundleSize = info.Size()
+	manifest.BundleMD5 = fmt.Sprintf("%x", bundleHash.Sum(nil))
+	if _, err := bundle.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset bundle: %w", err)
+	}
+
+	metadata := interfaces.FileMetadata{
+		Size:        manifest.BundleSize,
+		ModTime:     now,
+		MD5Hash:     manifest.BundleMD5,
+		ContentType: "application/zstd",
+	}
+	opCtx, cancel := e.transferContext(ctx, manifest.BundleSize)
+	err = e.provider.Upload(opCtx, manifest.Bundle, bundle, metadata, interfaces.TransferOptions{})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to upload release bundle: %w", err)
+	}
+	// Uploads are not egress, only downloads are billed for transfer
+	e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+
+	manifestKey := releasePrefix(dir) + release + ".json"
+	if err := e.uploadReleaseJSON(ctx, dir, manifestKey, manifest, now); err != nil {
+		return err
+	}
+	pointer := ReleasePointer{
+		Version:     releaseFormatVersion,
+		Release:     release,
+		Manifest:    manifestKey,
+		Digest:      digest,
+		PublishedAt: now,
+	}
+	if err := e.uploadReleaseJSON(ctx, dir, releasePointerKey(dir), pointer, now); err != nil {
+		return err
+	}
+
+	e.logger.Info("Release published",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("release", release),
+		zap.Int("files", len(manifest.Files)),
+		zap.Int64("bundle_size", manifest.BundleSize))
+	e.audit(ctx, audit.Entry{
+		Action:    "publish_release",
+		Key:       manifestKey,
+		LocalPath: dir.LocalPath,
+		Reason:    release,
+	})
+	e.publish(interfaces.SyncEvent{
+		Type:       interfaces.EventReleasePublished,
+		Directory:  dir.LocalPath,
+		RemotePath: manifestKey,
+		Size:       manifest.BundleSize,
+	})
+	e.incrementFilesUploaded(ctx, manifest.BundleSize)
+
+	return e.pruneReleases(ctx, dir, release)
+}
+
+// releaseSources lists the files of a publisher directory by relative
+// path, with a digest of their names, sizes, modification times and modes
+// that tells whether anything changed since the last release
+func (e *Engine) releaseSources(ctx context.Context, dir interfaces.SyncDirectory) ([]releaseSource, string, error) {
+	var sources []releaseSource
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, info os.FileInfo) error {
+		if !e.shouldSyncFile(localPath, dir) || e.excludedByRules(info, dir.FileRules) != "" {
+			return nil
+		}
+		sources = append(sources, releaseSource{
+			path:    localPath,
+			relPath: filepath.ToSlash(e.getRelativePath(localPath, dir.LocalPath)),
+			info:    info,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan local files: %w", err)
+	}
+	slices.SortFunc(sources, func(a, b releaseSource) int {
+		return strings.Compare(a.relPath, b.relPath)
+	})
+
+	hash := sha256.New()
+	for _, source := range sources {
+		fmt.Fprintf(hash, "%s\x00%d\x00%d\x00%o\n", source.relPath, source.info.Size(), source.info.ModTime().UnixNano(), source.info.Mode().Perm())
+	}
+	return sources, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// writeReleaseBundle writes sources to w as a zstd-compressed tar archive
+// and adds them to manifest. A file that changed since it was listed
+// fails the release, which is retried with the next sync.
+func writeReleaseBundle(ctx context.Context, w io.Writer, sources []releaseSource, manifest *ReleaseManifest) error {
+	encoder, err := zstd.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle compressor: %w", err)
+	}
+	archive := tar.NewWriter(encoder)
+
+	for _, source := range sources {
+		if err := ctx.Err(); err != nil {
+			encoder.Close()
+			return err
+		}
+		entry, err := writeReleaseFile(archive, source)
+		if err != nil {
+			encoder.Close()
+			return err
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+
+	if err := archive.Close(); err != nil {
+		encoder.Close()
+		return fmt.Errorf("failed to write release bundle: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to write release bundle: %w", err)
+	}
+	return nil
+}
+
+// writeReleaseFile adds one file to a release bundle
+func writeReleaseFile(archive *tar.Writer, source releaseSource) (ReleaseFile, error) {
+	file, err := os.Open(source.path)
+	if err != nil {
+		return ReleaseFile{}, fmt.Errorf("failed to open %s: %w", source.path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return ReleaseFile{}, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if info.Size() != source.info.Size() || !info.ModTime().Equal(source.info.ModTime()) {
+		return ReleaseFile{}, fmt.Errorf("%s changed while the release was being built", source.path)
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     source.relPath,
+		Size:     info.Size(),
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Format:   tar.FormatPAX,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return ReleaseFile{}, fmt.Errorf("failed to write release bundle: %w", err)
+	}
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(archive, hash), file)
+	if err != nil {
+		return ReleaseFile{}, fmt.Errorf("failed to add %s to release bundle: %w", source.path, err)
+	}
+	if written != info.Size() {
+		return ReleaseFile{}, fmt.Errorf("%s changed while the release was being built", source.path)
+	}
+
+	return ReleaseFile{
+		Path:    source.relPath,
+		Size:    written,
+		MD5Hash: fmt.Sprintf("%x", hash.Sum(nil)),
+		Mode:    info.Mode().Perm(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+// uploadReleaseJSON uploads v as a JSON document at key
+func (e *Engine) uploadReleaseJSON(ctx context.Context, dir interfaces.SyncDirectory, key string, v any, now time.Time) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", key, err)
+	}
+	metadata := interfaces.FileMetadata{
+		Size:        int64(len(data)),
+		ModTime:     now,
+		MD5Hash:     utils.CalculateMD5FromBytes(data),
+		ContentType: "application/json",
+	}
+
+	opCtx, cancel := e.transferContext(ctx, int64(len(data)))
+	defer cancel()
+	if err := e.provider.Upload(opCtx, key, bytes.NewReader(data), metadata, interfaces.TransferOptions{}); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+	return nil
+}
+
+// downloadReleaseJSON decodes the JSON document at key into v. It reports
+// false when there is no such object.
+func (e *Engine) downloadReleaseJSON(ctx context.Context, dir interfaces.SyncDirectory, key string, v any) (bool, error) {
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+
+	body, _, err := e.provider.Download(opCtx, key, interfaces.TransferOptions{})
+	if err != nil {
+		if errors.Is(err, interfaces.ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer body.Close()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return false, fmt.Errorf("failed to decode %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// loadReleasePointer returns the current release of dir, or nil when none
+// was published
+func (e *Engine) loadReleasePointer(ctx context.Context, dir interfaces.SyncDirectory) (*ReleasePointer, error) {
+	var pointer ReleasePointer
+	found, err := e.downloadReleaseJSON(ctx, dir, releasePointerKey(dir), &pointer)
+	if err != nil || !found {
+		return nil, err
+	}
+	if pointer.Version > releaseFormatVersion {
+		return nil, fmt.Errorf("release pointer of %s has unsupported version %d", dir.LocalPath, pointer.Version)
+	}
+	return &pointer, nil
+}
+
+// pruneReleases removes the bundles and manifests of all but the newest
+// keep_releases releases, never the current one
+func (e *Engine) pruneReleases(ctx context.Context, dir interfaces.SyncDirectory, current string) error {
+	keep := dir.Distribution.KeepReleases
+	if keep <= 0 {
+		keep = defaultKeepReleases
+	}
+
+	listCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(listCtx, releasePrefix(dir))
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to list releases: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+
+	keys := make(map[string][]string)
+	for _, object := range objects {
+		name := strings.TrimPrefix(object.Key, releasePrefix(dir))
+		release := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".tar.zst")
+		keys[release] = append(keys[release], object.Key)
+	}
+	releases := slices.Sorted(maps.Keys(keys))
+	slices.Reverse(releases)
+
+	var removed int
+	for i, release := range releases {
+		if i < keep || release == current {
+			continue
+		}
+		for _, key := range keys[release] {
+			opCtx, cancel := e.operationContext(ctx)
+			err := e.provider.Delete(opCtx, key)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("failed to remove old release %s: %w", release, err)
+			}
+			// DELETE requests are not billed, so none is recorded
+		}
+		removed++
+	}
+	if removed > 0 {
+		e.logger.Info("Old releases removed",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("removed", removed),
+			zap.Int("kept", keep))
+	}
+	return nil
+}
+
+// applyRelease replaces a subscriber directory with the current release
+// unless it is applied already or failed before. The release is
+// downloaded and verified into a staging directory next to it, which then
+// takes its place; the replaced directory is kept for rollback. When the
+// post_apply command fails, the replaced directory is put back.
+func (e *Engine) applyRelease(ctx context.Context, dir interfaces.SyncDirectory) error {
+	e.releaseMutex.Lock()
+	defer e.releaseMutex.Unlock()
+
+	pointer, err := e.loadReleasePointer(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if pointer == nil {
+		e.logger.Debug("No release published yet", zap.String("local_path", dir.LocalPath))
+		return nil
+	}
+	if appliedRelease(dir.LocalPath) == pointer.Release || e.failedReleases[dir.LocalPath] == pointer.Release {
+		return nil
+	}
+
+	var manifest ReleaseManifest
+	found, err := e.downloadReleaseJSON(ctx, dir, pointer.Manifest, &manifest)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("manifest of release %s not found", pointer.Release)
+	}
+	for _, file := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return fmt.Errorf("release %s contains invalid path %q", pointer.Release, file.Path)
+		}
+	}
+
+	staging := releaseSibling(dir.LocalPath, releaseStagingSuffix)
+	previous := releaseSibling(dir.LocalPath, releasePreviousSuffix)
+	if err := os.RemoveAll(staging); err != nil {
+		return fmt.Errorf("failed to remove old staging directory: %w", err)
+	}
+	if err := e.stageRelease(ctx, dir, &manifest, staging); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to stage release %s: %w", pointer.Release, err)
+	}
+
+	_, statErr := os.Lstat(dir.LocalPath)
+	hadLive := statErr == nil
+	if err := swapRelease(dir.LocalPath, staging, previous, hadLive); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("failed to apply release %s: %w", pointer.Release, err)
+	}
+
+	entry := audit.Entry{
+		Action:    "apply_release",
+		Key:       pointer.Manifest,
+		LocalPath: dir.LocalPath,
+		Reason:    pointer.Release,
+		Result:    "applied",
+	}
+	if err := e.runPostApply(ctx, dir, pointer.Release); err != nil {
+		if e.failedReleases == nil {
+			e.failedReleases = make(map[string]string)
+		}
+		e.failedReleases[dir.LocalPath] = pointer.Release
+		err = fmt.Errorf("post-apply command failed: %w", err)
+		entry.Result = "rolled_back"
+		if rollbackErr := rollbackRelease(dir.LocalPath, staging, previous, hadLive); rollbackErr != nil {
+			entry.Result = "failed"
+			err = fmt.Errorf("%w; rollback failed: %w", err, rollbackErr)
+		}
+		entry.SetError(err)
+		e.logger.Error("Release failed",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("release", pointer.Release),
+			zap.String("result", entry.Result),
+			errorField(err))
+		e.audit(ctx, entry)
+		e.publish(interfaces.SyncEvent{
+			Type:       interfaces.EventReleaseFailed,
+			Directory:  dir.LocalPath,
+			RemotePath: pointer.Manifest,
+			Error:      err.Error(),
+		})
+		return fmt.Errorf("release %s: %w", pointer.Release, err)
+	}
+
+	e.logger.Info("Release applied",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("release", pointer.Release),
+		zap.Int("files", len(manifest.Files)))
+	e.audit(ctx, entry)
+	e.publish(interfaces.SyncEvent{
+		Type:       interfaces.EventReleaseApplied,
+		Directory:  dir.LocalPath,
+		RemotePath: pointer.Manifest,
+		Size:       manifest.BundleSize,
+	})
+	e.incrementFilesDownloaded(ctx, manifest.BundleSize)
+	return nil
+}
+
+// stageRelease downloads the bundle of manifest and unpacks it into
+// staging, checking every file and the bundle against the manifest
+func (e *Engine) stageRelease(ctx context.Context, dir interfaces.SyncDirectory, manifest *ReleaseManifest, staging string) error {
+	expected := make(map[string]ReleaseFile, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file
+	}
+
+	opCtx, cancel := e.transferContext(ctx, manifest.BundleSize)
+	defer cancel()
+	body, _, err := e.provider.Download(opCtx, manifest.Bundle, interfaces.TransferOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to download bundle: %w", err)
+	}
+	defer body.Close()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, manifest.BundleSize)
+
+	bundleHash := md5.New()
+	bundle := io.TeeReader(body, bundleHash)
+	decoder, err := zstd.NewReader(bundle)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer decoder.Close()
+
+	if err := e.mkdirAllAs(dir.LocalPath, staging, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	archive := tar.NewReader(decoder)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read bundle: %w", err)
+		}
+		file, ok := expected[header.Name]
+		if !ok || header.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry %q in bundle", header.Name)
+		}
+		delete(expected, header.Name)
+		if err := e.unpackReleaseFile(dir, archive, file, staging); err != nil {
+			return err
+		}
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("bundle is missing %d files", len(expected))
+	}
+
+	// Read what remains of the compressed stream for its checksum
+	if _, err := io.Copy(io.Discard, bundle); err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if actual := fmt.Sprintf("%x", bundleHash.Sum(nil)); actual != manifest.BundleMD5 {
+		return fmt.Errorf("bundle MD5 hash mismatch: expected %s, got %s", manifest.BundleMD5, actual)
+	}
+
+	marker := filepath.Join(staging, releaseMarkerName)
+	if err := os.WriteFile(marker, []byte(manifest.Release+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record release: %w", err)
+	}
+	e.chownAs(dir.LocalPath, marker)
+	return nil
+}
+
+// unpackReleaseFile writes one file of a bundle below staging
+func (e *Engine) unpackReleaseFile(dir interfaces.SyncDirectory, r io.Reader, file ReleaseFile, staging string) error {
+	path := filepath.Join(staging, filepath.FromSlash(file.Path))
+	if err := e.mkdirAllAs(dir.LocalPath, filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, file.Mode.Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", file.Path, err)
+	}
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", file.Path, err)
+	}
+	if actual := fmt.Sprintf("%x", hash.Sum(nil)); written != file.Size || actual != file.MD5Hash {
+		return fmt.Errorf("%s does not match the release manifest", file.Path)
+	}
+
+	if err := os.Chtimes(path, file.ModTime, file.ModTime); err != nil {
+		e.logger.Warn("Failed to set file modification time",
+			zap.String("path", path),
+			errorField(err))
+	}
+	e.chownAs(dir.LocalPath, path)
+	return nil
+}
+
+// runPostApply runs the post_apply command of a subscriber directory in
+// the directory, with the release in CLOUDAWSYNC_RELEASE
+func (e *Engine) runPostApply(ctx context.Context, dir interfaces.SyncDirectory, release string) error {
+	if dir.Distribution.PostApply == "" {
+		return nil
+	}
+	timeout := dir.Distribution.Timeout
+	if timeout <= 0 {
+		timeout = defaultPostApplyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", dir.Distribution.PostApply)
+	cmd.Dir = dir.LocalPath
+	cmd.Env = append(os.Environ(),
+		"CLOUDAWSYNC_RELEASE="+release,
+		"CLOUDAWSYNC_RELEASE_DIR="+dir.LocalPath)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		if out := strings.TrimSpace(output.String()); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// releaseSibling returns the hidden path next to a subscriber directory
+// with the given suffix. Staging next to the directory keeps it on the
+// same filesystem, so it can be renamed into place.
+func releaseSibling(path, suffix string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+suffix)
+}
+
+// appliedRelease returns the release applied to the directory at path, or
+// "" when none is recorded
+func appliedRelease(path string) string {
+	data, err := os.ReadFile(filepath.Join(path, releaseMarkerName))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// swapRelease puts the staged release in place of live, keeping the
+// directory it replaces at previous. Where paths can be exchanged
+// atomically, live never disappears.
+func swapRelease(live, staging, previous string, hadLive bool) error {
+	if err := os.RemoveAll(previous); err != nil {
+		return fmt.Errorf("failed to remove previous release: %w", err)
+	}
+	if !hadLive {
+		return os.Rename(staging, live)
+	}
+
+	if err := utils.ExchangePaths(staging, live); err == nil {
+		// staging now holds the replaced directory
+		return os.Rename(staging, previous)
+	} else if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err := os.Rename(live, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, live); err != nil {
+		os.Rename(previous, live)
+		return err
+	}
+	return nil
+}
+
+// rollbackRelease puts back the directory swapRelease replaced and removes
+// the release that failed
+func rollbackRelease(live, staging, previous string, hadLive bool) error {
+	if !hadLive {
+		return os.RemoveAll(live)
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+
+	if err := utils.ExchangePaths(previous, live); err == nil {
+		return os.RemoveAll(previous)
+	} else if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+	if err := os.Rename(live, staging); err != nil {
+		return err
+	}
+	if err := os.Rename(previous, live); err != nil {
+		os.Rename(staging, live)
+		return err
+	}
+	return os.RemoveAll(staging)
+}
+
+// releasePollWorker checks a subscriber directory for new releases
+// between its scheduled syncs
+func (e *Engine) releasePollWorker(ctx context.Context, dir interfaces.SyncDirectory) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(dir.Distribution.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			current, ok := e.configuredDirectory(dir.LocalPath)
+			if !ok {
+				return
+			}
+			if !current.Enabled || e.isOffline() {
+				continue
+			}
+			if err := e.applyRelease(ctx, current); err != nil {
+				e.logger.Warn("Failed to apply release",
+					zap.String("local_path", dir.LocalPath),
+					errorField(err))
+			}
+		}
+	}
+}
@@ -24,15 +24,23 @@ package engine
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/chunker"
+	"CloudAWSync/internal/cron"
 	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+	"CloudAWSync/internal/utils"
 
 	"go.uber.org/zap"
 )
@@ -49,25 +57,205 @@ type Engine struct {
 	maxConcurrentDownloads int
 	retryAttempts          int
 	retryDelay             time.Duration
+	operationTimeout       time.Duration // per provider request, 0 disables
+	transferTimeoutPerMB   time.Duration // added to operationTimeout per MB transferred
+	minTransferSpeed       int64         // bytes per second, 0 disables stall detection
+	stallWindow            time.Duration // how long a transfer may stay below minTransferSpeed
 
 	// State
-	directories   []interfaces.SyncDirectory
-	uploadQueue   chan syncTask
-	downloadQueue chan syncTask
-	stopChan      chan struct{}
-	wg            sync.WaitGroup
-	mutex         sync.RWMutex
-	stats         interfaces.SyncStats
-	running       bool
+	directories         []interfaces.SyncDirectory
+	uploadQueue         *fairQueue
+	downloadQueue       chan syncTask
+	pendingDownloads    atomic.Int64      // queued or running downloads
+	releaseMutex        sync.Mutex        // serializes applying releases
+	failedReleases      map[string]string // release rolled back, by subscriber directory
+	stopChan            chan struct{}
+	uploadPool          *workerPool
+	downloadPool        *workerPool
+	uploadAIMD          *aimdController // nil unless adaptive concurrency is enabled
+	downloadAIMD        *aimdController
+	scanLimiter         *scanLimiter      // nil when scans are not rate limited
+	bandwidthLimiter    *bandwidthLimiter // nil when transfers are not rate limited
+	readRateLimit       int64             // local read bytes per second for each upload, 0 = unlimited
+	downloadChunkSize   int64             // range size for parallel downloads, 0 disables them
+	downloadParallelism int               // ranges fetched at once for one download
+	multipartThreshold  int64             // size from which uploads go in parts, 0 disables them
+	multipartPartSize   int64             // size of each part of a multipart upload
+	scanParallelism     int
+	directoryLimit      int // directories synced at once by SyncDirectories
+
+	// Resource budgets and the number of times concurrency has been
+	// halved to stay within them
+	memoryLimit     int64   // bytes of resident memory, 0 = unlimited
+	cpuLimit        float64 // percent of one core, 0 = unlimited
+	resourceLevel   int
+	resourceSampler func() (resourceSample, error) // nil samples this process
+
+	// Local paths skipped because they cannot be read, with the last error
+	unreadable      map[string]string
+	unreadableMutex sync.Mutex
+	wg              sync.WaitGroup
+	mutex           sync.RWMutex
+	stats           interfaces.SyncStats
+	syncErrors      []interfaces.SyncError // recent failures, oldest first
+	running         bool
+	runCtx          context.Context // derived from the context passed to Start
+	runCancel       context.CancelFunc
+
+	// Realtime directories handed to the file watcher
+	watched  map[string]bool
+	watching bool
+
+	// Cancelled when a directory is removed, ending its transfers
+	dirContexts map[string]*directoryContext
+
+	// In-flight upload tracking keyed by local path
+	inFlight      map[string]*inFlightUpload
+	inFlightMutex sync.Mutex
+
+	// Realtime uploads held back by directory throttles and the earliest
+	// time each recently uploaded path may be uploaded again
+	throttled         map[string]*throttledUpload
+	nextUploadAllowed map[string]time.Time
+	throttleRunning   bool
+	throttleMutex     sync.Mutex
+
+	// Consecutive upload failures by local path, and the quarantine used
+	// when no state store is set
+	uploadFailures   map[string]int
+	quarantine       map[string]*state.QuarantinedFile
+	quarantineAfter  int           // failures before a file is quarantined, 0 disables
+	quarantineExpiry time.Duration // 0 keeps files quarantined until cleared
+	quarantineMutex  sync.Mutex
+
+	// Snapshots taken by directory snapshot commands, keyed by local path.
+	// Uploads reading a snapshot hold the read lock.
+	snapshots     map[string]interfaces.SyncDirectory
+	snapshotMutex sync.RWMutex
+	snapshotSeq   atomic.Int64
+
+	// Remote storage usage and quotas keyed by scope
+	quotas             map[string]*quotaState
+	quotaMutex         sync.Mutex
+	quotaCheckInterval time.Duration
+
+	// Estimated spend, nil when cost estimation is disabled
+	costs     *costTracker
+	costMutex sync.Mutex
+
+	// Persistent record of uploaded objects, nil when disabled
+	stateStore         *state.Store
+	hydrationCacheSize int64
+	scrubInterval      time.Duration
+	scrubSampleSize    int
+	rescanInterval     time.Duration // full scans of realtime directories, 0 = disabled
+	malware            MalwareScanning
+
+	// Accepted file events not handled yet, nil when disabled
+	journal *state.Journal
+
+	// Backends copying missed objects from each other, nil when the
+	// provider writes a single backend
+	mirrors              interfaces.MirrorProvider
+	mirrorRepairInterval time.Duration
+
+	// Directories synced since their manifest was written, with the time
+	// of the sync
+	manifests      bool
+	staleManifests map[string]time.Time
+
+	// Longest remote key and how longer keys are handled, 0 = no limit
+	maxKeyBytes int
+	longKeys    string
+
+	// How often a summary of recent transfers is logged, 0 = never
+	summaryInterval time.Duration
+
+	// Sync progress of each directory keyed by local path
+	dirStatus      map[string]*interfaces.DirectoryStatus
+	dirStatusMutex sync.Mutex
+
+	// Recent sync runs by sync ID, and their IDs by directory, oldest first
+	runs     map[string]*state.SyncRun
+	runOrder map[string][]string
+	runMutex sync.Mutex
+
+	// Closed while transfers may run, replaced by Pause
+	resumed    chan struct{}
+	pauseMutex sync.Mutex
+
+	// Transfers in progress and recent failures, reported by Activity
+	activity activityTracker
+
+	// Subscribers to sync events
+	events eventBus
+
+	// Connectivity to the storage service and uploads held while it is
+	// unreachable, keyed by local path
+	offline                   bool
+	offlineSince              time.Time
+	offlineQueue              map[string]syncTask
+	offlineMutex              sync.Mutex
+	connectivityCheckInterval time.Duration
+
+	// File events that found the upload queue full, queued once it has room
+	backlog *eventBacklog
+
+	// Difference between local and remote modification times below which
+	// files are compared by content, and whether the measured clock skew
+	// was last reported as exceeding it
+	clockSkewTolerance time.Duration
+	skewReported       bool
+
+	// Destination for audit entries, nil when auditing is disabled
+	auditLog *audit.Log
+
+	// Rules read from the ignore files of each directory, keyed by local
+	// path
+	ignoreLists map[string]*ignoreList
+	ignoreMutex sync.Mutex
+
+	// Restores of archived objects requested by downloads, keyed by remote
+	// path. Restores are requested only when restoreDays is set.
+	restores             map[string]pendingRestore
+	restoreMutex         sync.Mutex
+	restoreDays          int
+	restoreTier          string
+	restoreCheckInterval time.Duration
+
+	// Encryption and chunk sizes of chunked backup directories, nil key
+	// until SetChunking is called
+	chunkKey    *chunker.Key
+	chunkParams chunker.Params
+	packSize    int64
+
+	// Parity stripe descriptions by key, which never change once written
+	stripeCache sync.Map
+
+	// Time and local files as seen by the sync path, replaceable in tests
+	clock interfaces.Clock
+	fs    interfaces.FileSystem
+}
+
+// inFlightUpload tracks an upload that is queued or being processed
+type inFlightUpload struct {
+	running bool
+	pending *syncTask // follow-up requested while running
 }
 
 // syncTask represents a synchronization task
 type syncTask struct {
-	localPath  string
-	remotePath string
-	operation  string // upload, download, delete
-	fileInfo   os.FileInfo
-	metadata   interfaces.FileMetadata
+	localPath    string
+	remotePath   string
+	rootPath     string // local path of the owning sync directory
+	operation    string // upload, download, delete
+	fileInfo     os.FileInfo
+	metadata     interfaces.FileMetadata
+	remoteExists bool   // metadata describes the existing remote object
+	sourcePath   string // snapshot copy read instead of localPath
+	originalKey  string // full key when remotePath was shortened
+	syncID       string // correlation ID of the sync that queued the task
+	etag         string // listed ETag of the object a download fetches
 }
 
 // NewEngine creates a new sync engine
@@ -81,7 +269,7 @@ func NewEngine(
 	retryAttempts int,
 	retryDelay time.Duration,
 ) *Engine {
-	return &Engine{
+	e := &Engine{
 		provider:               provider,
 		watcher:                watcher,
 		metrics:                metrics,
@@ -90,10 +278,35 @@ func NewEngine(
 		maxConcurrentDownloads: maxConcurrentDownloads,
 		retryAttempts:          retryAttempts,
 		retryDelay:             retryDelay,
-		uploadQueue:            make(chan syncTask, 100),
+		uploadQueue:            newFairQueue(uploadQueueSize),
 		downloadQueue:          make(chan syncTask, 100),
 		stopChan:               make(chan struct{}),
+		inFlight:               make(map[string]*inFlightUpload),
+		quotas:                 make(map[string]*quotaState),
+		unreadable:             make(map[string]string),
+		dirStatus:              make(map[string]*interfaces.DirectoryStatus),
+		runs:                   make(map[string]*state.SyncRun),
+		runOrder:               make(map[string][]string),
+		resumed:                make(chan struct{}),
+		offlineQueue:           make(map[string]syncTask),
+		backlog:                newEventBacklog(defaultBacklogMemory, ""),
+		throttled:              make(map[string]*throttledUpload),
+		nextUploadAllowed:      make(map[string]time.Time),
+		snapshots:              make(map[string]interfaces.SyncDirectory),
+		uploadFailures:         make(map[string]int),
+		quarantine:             make(map[string]*state.QuarantinedFile),
+		watched:                make(map[string]bool),
+		dirContexts:            make(map[string]*directoryContext),
+		staleManifests:         make(map[string]time.Time),
+		ignoreLists:            make(map[string]*ignoreList),
+		restores:               make(map[string]pendingRestore),
+		clock:                  utils.SystemClock{},
+		fs:                     utils.OSFileSystem{},
 	}
+	close(e.resumed)
+	e.uploadPool = newWorkerPool(e.uploadWorker, &e.wg)
+	e.downloadPool = newWorkerPool(e.downloadWorker, &e.wg)
+	return e
 }
 
 // Start starts the sync engine
@@ -104,30 +317,154 @@ func (e *Engine) Start(ctx context.Context) error {
 		return fmt.Errorf("sync engine is already running")
 	}
 	e.running = true
-	e.mutex.Unlock()
+	// Stop cancels requests still in flight even if ctx outlives it
+	ctx, e.runCancel = context.WithCancel(ctx)
+	e.runCtx = ctx
 
 	e.logger.Info("Starting sync engine")
 
-	// Start upload workers
-	for i := 0; i < e.maxConcurrentUploads; i++ {
-		e.wg.Add(1)
-		go e.uploadWorker(ctx, i)
-	}
-
-	// Start download workers
-	for i := 0; i < e.maxConcurrentDownloads; i++ {
-		e.wg.Add(1)
-		go e.downloadWorker(ctx, i)
-	}
+	// Start upload and download workers
+	uploads, downloads := e.workerTargets()
+	e.uploadPool.start(ctx, uploads)
+	e.downloadPool.start(ctx, downloads)
+	e.mutex.Unlock()
+	e.metrics.RecordConcurrency("upload", uploads)
+	e.metrics.RecordConcurrency("download", downloads)
 
 	// Start file watcher if we have realtime directories
 	if e.hasRealtimeDirectories() {
 		if err := e.startFileWatcher(ctx); err != nil {
-			e.logger.Error("Failed to start file watcher", zap.Error(err))
+			e.logger.Error("Failed to start file watcher", errorField(err))
 			return err
 		}
 	}
 
+	// Handle changes detected before the last stop
+	e.wg.Add(1)
+	go e.replayJournal(ctx)
+
+	// Queue changes held while the upload queue was full
+	e.wg.Add(1)
+	go e.backlogWorker(ctx)
+
+	// Refresh global storage usage if a global quota is configured
+	e.quotaMutex.Lock()
+	_, globalQuota := e.quotas[globalQuotaScope]
+	quotaInterval := e.quotaCheckInterval
+	e.quotaMutex.Unlock()
+	if globalQuota {
+		if quotaInterval <= 0 {
+			quotaInterval = time.Hour
+		}
+		e.wg.Add(1)
+		go e.quotaWorker(ctx, quotaInterval)
+	}
+
+	// Start periodic verification, remote polling, rescans and release
+	// polling for directories that request them
+	e.mutex.RLock()
+	for _, dir := range e.directories {
+		if dir.VerifyInterval > 0 {
+			e.wg.Add(1)
+			go e.verifyWorker(ctx, dir)
+		}
+		if dir.RemotePollInterval > 0 {
+			e.wg.Add(1)
+			go e.remotePollWorker(ctx, dir)
+		}
+		if interval := e.directoryRescanInterval(dir); interval > 0 {
+			e.wg.Add(1)
+			go e.rescanWorker(ctx, dir, interval)
+		}
+		if dir.Distribution.Role == interfaces.DistributionSubscribe && dir.Distribution.PollInterval > 0 {
+			e.wg.Add(1)
+			go e.releasePollWorker(ctx, dir)
+		}
+	}
+	e.mutex.RUnlock()
+
+	// Flush recorded state and scrub the remote periodically
+	e.mutex.RLock()
+	hasState := e.stateStore != nil
+	scrubInterval := e.scrubInterval
+	scrubSample := e.scrubSampleSize
+	e.mutex.RUnlock()
+	if hasState {
+		e.wg.Add(1)
+		go e.stateWorker(ctx)
+
+		if scrubInterval > 0 {
+			e.wg.Add(1)
+			go e.scrubWorker(ctx, scrubInterval, scrubSample)
+		}
+	}
+
+	// Copy objects missed by some backends from the others
+	e.mutex.RLock()
+	mirrors := e.mirrors
+	repairInterval := e.mirrorRepairInterval
+	e.mutex.RUnlock()
+	if mirrors != nil && repairInterval > 0 {
+		e.wg.Add(1)
+		go e.mirrorWorker(ctx, mirrors, repairInterval)
+	}
+
+	// Abort multipart uploads abandoned by files that changed or were
+	// deleted before they completed
+	e.mutex.RLock()
+	multipart := e.multipartThreshold > 0
+	e.mutex.RUnlock()
+	if uploader, ok := e.provider.(interfaces.MultipartProvider); ok && multipart {
+		e.wg.Add(1)
+		go e.multipartSweepWorker(ctx, uploader)
+	}
+
+	// Write directory manifests once synced changes are uploaded
+	e.mutex.RLock()
+	manifests := e.manifests
+	e.mutex.RUnlock()
+	if manifests {
+		e.wg.Add(1)
+		go e.manifestWorker(ctx)
+	}
+
+	// Check restores of archived objects until they are readable
+	e.mutex.RLock()
+	restoreInterval := e.restoreCheckInterval
+	e.mutex.RUnlock()
+	if restoreInterval > 0 {
+		e.wg.Add(1)
+		go e.restoreWorker(ctx, restoreInterval)
+	}
+
+	// Log what the agent did at intervals
+	e.mutex.RLock()
+	summaryInterval := e.summaryInterval
+	e.mutex.RUnlock()
+	if summaryInterval > 0 {
+		e.wg.Add(1)
+		go e.summaryWorker(ctx, summaryInterval)
+	}
+
+	// Keep the agent's own memory and CPU use within its budgets
+	e.mutex.RLock()
+	limited := e.memoryLimit > 0 || e.cpuLimit > 0
+	e.mutex.RUnlock()
+	if limited {
+		e.wg.Add(1)
+		go e.resourceWorker(ctx)
+	}
+
+	// Probe the storage service during outages and resume queued uploads
+	e.mutex.RLock()
+	checkInterval := e.connectivityCheckInterval
+	e.mutex.RUnlock()
+	if checkInterval <= 0 {
+		checkInterval = defaultConnectivityCheckInterval
+	}
+	e.wg.Add(1)
+	go e.connectivityWorker(ctx, checkInterval)
+
 	// Start scheduled sync if we have scheduled directories
 	if e.hasScheduledDirectories() {
 		e.wg.Add(1)
@@ -146,71 +483,177 @@ func (e *Engine) Stop() error {
 		return nil
 	}
 	e.running = false
+	cancel := e.runCancel
 	e.mutex.Unlock()
 
 	e.logger.Info("Stopping sync engine")
 
-	// Close stop channel
+	// Close stop channel and abort outstanding requests
 	close(e.stopChan)
+	cancel()
 
 	// Stop file watcher
 	if e.watcher != nil {
 		if err := e.watcher.Stop(); err != nil {
-			e.logger.Error("Failed to stop file watcher", zap.Error(err))
+			e.logger.Error("Failed to stop file watcher", errorField(err))
 		}
 	}
 
-	// Close queues
-	close(e.uploadQueue)
-	close(e.downloadQueue)
+	// Close the upload queue; download workers stop with stopChan, and the
+	// download queue stays open for syncs still queueing
+	e.uploadQueue.close()
 
 	// Wait for workers to finish
 	e.wg.Wait()
 
+	if err := e.eventBacklog().close(); err != nil {
+		e.logger.Error("Failed to save held file events", errorField(err))
+	}
+	e.releaseSnapshots()
+	e.saveState()
+
 	e.logger.Info("Sync engine stopped")
 	return nil
 }
 
-// AddDirectory adds a directory for synchronization
-func (e *Engine) AddDirectory(dir interfaces.SyncDirectory) {
+// SetClock replaces the clock used for retries, scheduling and
+// statistics. It must be called before Start.
+func (e *Engine) SetClock(clock interfaces.Clock) {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
+	e.clock = clock
+}
 
+// Clock returns the clock used for retries, scheduling and statistics
+func (e *Engine) Clock() interfaces.Clock {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.clock
+}
+
+// SetFileSystem replaces the file system scanned, uploaded from and
+// downloaded to. It must be called before Start.
+func (e *Engine) SetFileSystem(fs interfaces.FileSystem) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.fs = fs
+}
+
+// SetTimeouts configures the timeout applied to each provider operation.
+// Transfers get an additional allowance of perMB for every megabyte so
+// large files are not cut off while hung connections are still detected.
+func (e *Engine) SetTimeouts(operation, perMB time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.operationTimeout = operation
+	e.transferTimeoutPerMB = perMB
+}
+
+// AddDirectory adds a directory for synchronization. A running engine
+// starts watching it right away when it syncs in realtime.
+func (e *Engine) AddDirectory(dir interfaces.SyncDirectory) {
+	e.mutex.Lock()
 	e.directories = append(e.directories, dir)
 	e.stats.ActiveDirectories = len(e.directories)
+	e.dirContexts[dir.LocalPath] = newDirectoryContext()
+	e.uploadQueue.setPolicy(dir.LocalPath, dir.UploadWeight, dir.MaxConcurrentUploads)
+	e.mutex.Unlock()
 
 	e.logger.Info("Added directory for sync",
 		zap.String("local_path", dir.LocalPath),
 		zap.String("remote_path", dir.RemotePath),
 		zap.String("sync_mode", string(dir.SyncMode)))
+
+	if dir.Enabled && (dir.SyncMode == interfaces.SyncModeRealtime || dir.SyncMode == interfaces.SyncModeBoth) {
+		if err := e.watchDirectory(dir.LocalPath); err != nil {
+			e.logger.Warn("Failed to watch added directory",
+				zap.String("local_path", dir.LocalPath),
+				errorField(err))
+		}
+	}
 }
 
 // Sync performs synchronization for the specified directory
 func (e *Engine) Sync(ctx context.Context, dir interfaces.SyncDirectory) error {
-	if !dir.Enabled {
+	return e.runSync(ctx, dir, e.syncDirectory)
+}
+
+// runSync runs a sync of dir, using scan to find and queue the changed
+// files of non-backup directories
+func (e *Engine) runSync(ctx context.Context, dir interfaces.SyncDirectory, scan func(context.Context, interfaces.SyncDirectory) error) error {
+	if !dir.Enabled || e.directoryRemoved(dir.LocalPath) {
 		return nil
 	}
+	if e.isOffline() {
+		return fmt.Errorf("sync of %s deferred until connectivity returns: %w", dir.LocalPath, errOffline)
+	}
+
+	ctx, done := e.taskContext(ctx, dir.LocalPath)
+	defer done()
+	syncID := newSyncID()
+	ctx = interfaces.WithSyncID(ctx, syncID)
 
 	e.logger.Info("Starting sync for directory",
 		zap.String("local_path", dir.LocalPath),
-		zap.String("remote_path", dir.RemotePath))
+		zap.String("remote_path", dir.RemotePath),
+		zap.String("sync_id", syncID))
 
-	start := time.Now()
-	err := e.syncDirectory(ctx, dir)
-	duration := time.Since(start)
+	e.beginDirectorySync(dir.LocalPath)
+	e.beginRun(dir.LocalPath, syncID)
+	e.publish(interfaces.SyncEvent{Type: interfaces.EventSyncStarted, Directory: dir.LocalPath})
+	start := e.clock.Now()
+	var err error
+	if dir.SyncMode == interfaces.SyncModeBackup {
+		_, err = e.Backup(ctx, dir)
+	} else if dir.Distribution.Role == interfaces.DistributionPublish {
+		err = e.publishRelease(ctx, dir)
+	} else if dir.Distribution.Role == interfaces.DistributionSubscribe {
+		err = e.applyRelease(ctx, dir)
+	} else if dir.ReadOnly {
+		err = e.syncReadOnly(ctx, dir)
+	} else {
+		err = e.takeSnapshot(ctx, dir)
+		if err == nil {
+			err = scan(ctx, dir)
+		}
+		if err == nil && !dir.RemoteRetention.IsZero() {
+			_, err = e.ApplyRemoteRetention(ctx, dir, false)
+		}
+		if err == nil && dir.Archive.After > 0 {
+			_, err = e.ArchiveDirectory(ctx, dir, false)
+		}
+	}
+	e.endRun(syncID, err)
+	if e.directoryRemoved(dir.LocalPath) {
+		e.logger.Info("Sync stopped, directory was removed",
+			zap.String("local_path", dir.LocalPath))
+		return nil
+	}
+	duration := e.clock.Now().Sub(start)
+	e.endDirectorySync(dir.LocalPath, err)
 
 	e.metrics.RecordFileOperation("sync", duration, err == nil)
 
+	if isConnectivityError(err) {
+		e.goOffline(err)
+	}
 	if err != nil {
 		e.logger.Error("Sync failed for directory",
 			zap.String("local_path", dir.LocalPath),
-			zap.Error(err))
+			zap.String("sync_id", syncID),
+			errorField(err))
+		e.publish(interfaces.SyncEvent{Type: interfaces.EventSyncFailed, Directory: dir.LocalPath, Error: err.Error()})
 		return err
 	}
 
 	e.logger.Info("Sync completed for directory",
 		zap.String("local_path", dir.LocalPath),
+		zap.String("sync_id", syncID),
 		zap.Duration("duration", duration))
+	if dir.SyncMode != interfaces.SyncModeBackup && !dir.ReadOnly && dir.Distribution.Role == "" {
+		e.markManifestStale(dir.LocalPath)
+	}
+	e.publish(interfaces.SyncEvent{Type: interfaces.EventSyncCompleted, Directory: dir.LocalPath})
 
 	return nil
 }
@@ -219,59 +662,101 @@ func (e *Engine) Sync(ctx context.Context, dir interfaces.SyncDirectory) error {
 func (e *Engine) GetStats() interfaces.SyncStats {
 	e.mutex.RLock()
 	defer e.mutex.RUnlock()
-	return e.stats
+
+	stats := e.stats
+	stats.RecentErrors = slices.Clone(e.syncErrors)
+	if measurer, ok := e.provider.(interfaces.ClockSkewProvider); ok {
+		stats.ClockSkew, _ = measurer.ClockSkew()
+	}
+	return stats
 }
 
 // syncDirectory performs the actual synchronization for a directory
 func (e *Engine) syncDirectory(ctx context.Context, dir interfaces.SyncDirectory) error {
-	// Get local files
-	localFiles, err := e.getLocalFiles(dir.LocalPath, dir.Recursive)
-	if err != nil {
-		return fmt.Errorf("failed to get local files: %w", err)
-	}
-
 	// Get remote files
-	remoteFiles, err := e.provider.List(ctx, dir.RemotePath)
+	listCtx, cancel := e.listContext(ctx)
+	remoteFiles, err := e.provider.List(listCtx, remoteDirPrefix(dir))
+	cancel()
 	if err != nil {
 		return fmt.Errorf("failed to get remote files: %w", err)
 	}
 
-	// Create maps for efficient lookup
-	localFileMap := make(map[string]os.FileInfo)
-	for path, info := range localFiles {
-		localFileMap[path] = info
-	}
-
-	remoteFileMap := make(map[string]interfaces.FileInfo)
+	remoteFileMap := make(map[string]interfaces.FileInfo, len(remoteFiles))
 	for _, info := range remoteFiles {
 		remoteFileMap[info.Key] = info
 	}
-
-	// Determine what needs to be uploaded
-	for localPath, localInfo := range localFiles {
-		if !e.shouldSyncFile(localPath, dir.Filters) {
-			continue
+	listed := len(remoteFileMap)
+
+	e.updateDirectoryUsage(dir, remoteFiles)
+	e.recordArchivedObjects(dir, remoteFiles)
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(remoteFiles)/1000+1), 0)
+	remoteFiles = nil
+
+	// Stream local files through filter, compare and enqueue. The upload
+	// queue blocks the walk when full, bounding memory on large trees.
+	var adopted int
+	// Objects are removed from remoteFileMap as their local files are seen,
+	// leaving the remote-only ones
+	err = e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, localInfo os.FileInfo) error {
+		e.countScanned(ctx)
+		if !e.shouldSyncFile(localPath, dir) || e.excludedByRules(localInfo, dir.FileRules) != "" {
+			if remotePath, ok := e.localKey(dir, localPath); ok {
+				delete(remoteFileMap, remotePath)
+			}
+			return nil
 		}
 
-		relativePath := e.getRelativePath(localPath, dir.LocalPath)
-		remotePath := filepath.Join(dir.RemotePath, relativePath)
+		remotePath, originalKey, err := e.remoteKey(dir, localPath)
+		if err != nil {
+			e.skipKey(localPath, err)
+			return nil
+		}
 
 		remoteInfo, exists := remoteFileMap[remotePath]
+		delete(remoteFileMap, remotePath)
+		if exists && dir.Deletions.Action == interfaces.DeletionReport {
+			e.clearUnseen(remotePath)
+		}
+		if exists && e.adoptedUnchanged(localInfo, remoteInfo) {
+			return nil
+		}
+		if exists && dir.AdoptRemote && e.adoptRemote(ctx, dir, localPath, localInfo, remoteInfo) {
+			adopted++
+			return nil
+		}
+		if exists && !e.needsUpload(ctx, dir, localPath, localInfo, remoteInfo) {
+			return nil
+		}
+		if exists {
+			e.detectConflict(dir.LocalPath, localPath, remoteInfo)
+		}
 
-		if !exists || e.needsUpload(localInfo, remoteInfo) {
-			task := syncTask{
-				localPath:  localPath,
-				remotePath: remotePath,
-				operation:  "upload",
-				fileInfo:   localInfo,
-			}
-
-			select {
-			case e.uploadQueue <- task:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+		task := syncTask{
+			localPath:    localPath,
+			remotePath:   remotePath,
+			rootPath:     dir.LocalPath,
+			operation:    "upload",
+			fileInfo:     localInfo,
+			metadata:     interfaces.FileMetadata{Size: remoteInfo.Size},
+			sourcePath:   e.snapshotSource(dir, localPath),
+			remoteExists: exists,
+			originalKey:  originalKey,
 		}
+		_, err = e.enqueueUpload(ctx, task, true)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan local files: %w", err)
+	}
+	e.pruneUnreadable(dir.LocalPath)
+	e.handleLocalDeletions(ctx, dir, remoteFileMap, listed)
+	e.recordRemoteOnly(dir, remoteFileMap, false)
+
+	if adopted > 0 {
+		e.logger.Info("Adopted existing remote objects",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("files_adopted", adopted))
+		e.saveState()
 	}
 
 	// Determine what needs to be downloaded (if bidirectional sync)
@@ -281,137 +766,355 @@ func (e *Engine) syncDirectory(ctx context.Context, dir interfaces.SyncDirectory
 }
 
 // uploadWorker processes upload tasks
-func (e *Engine) uploadWorker(ctx context.Context, workerID int) {
+func (e *Engine) uploadWorker(ctx context.Context, workerID int, quit <-chan struct{}) {
 	defer e.wg.Done()
 
 	e.logger.Debug("Upload worker started", zap.Int("worker_id", workerID))
 
 	for {
+		// Wait while transfers are paused
 		select {
 		case <-ctx.Done():
 			return
 		case <-e.stopChan:
 			return
-		case task, ok := <-e.uploadQueue:
-			if !ok {
+		case <-quit:
+			e.logger.Debug("Upload worker stopped", zap.Int("worker_id", workerID))
+			return
+		case <-e.resumedChan():
+		}
+
+		task, ok, wait := e.uploadQueue.take()
+		if !ok {
+			if wait == nil {
 				return
 			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.stopChan:
+				return
+			case <-quit:
+				e.logger.Debug("Upload worker stopped", zap.Int("worker_id", workerID))
+				return
+			case <-wait:
+			}
+			continue
+		}
+
+		root := task.rootPath
+		e.startInFlight(task.localPath)
+		for {
+			started := e.clock.Now()
 			e.processUploadTask(ctx, task, workerID)
+			e.eventsHandled(ctx, task.localPath, started)
+
+			// Run a coalesced follow-up upload if the file changed
+			// while it was being uploaded
+			next, again := e.finishInFlight(task.localPath)
+			if !again {
+				break
+			}
+			task = next
 		}
+		e.uploadQueue.done(root)
 	}
 }
 
 // downloadWorker processes download tasks
-func (e *Engine) downloadWorker(ctx context.Context, workerID int) {
+func (e *Engine) downloadWorker(ctx context.Context, workerID int, quit <-chan struct{}) {
 	defer e.wg.Done()
 
 	e.logger.Debug("Download worker started", zap.Int("worker_id", workerID))
 
 	for {
+		// Wait while transfers are paused
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-quit:
+			e.logger.Debug("Download worker stopped", zap.Int("worker_id", workerID))
+			return
+		case <-e.resumedChan():
+		}
+
 		select {
 		case <-ctx.Done():
 			return
 		case <-e.stopChan:
 			return
+		case <-quit:
+			e.logger.Debug("Download worker stopped", zap.Int("worker_id", workerID))
+			return
 		case task, ok := <-e.downloadQueue:
 			if !ok {
 				return
 			}
 			e.processDownloadTask(ctx, task, workerID)
+			e.pendingDownloads.Add(-1)
 		}
 	}
 }
 
 // processUploadTask processes a single upload task
 func (e *Engine) processUploadTask(ctx context.Context, task syncTask, workerID int) {
-	start := time.Now()
+	start := e.clock.Now()
+	ctx = withSyncID(ctx, &task)
 
 	e.logger.Debug("Processing upload task",
 		zap.Int("worker_id", workerID),
 		zap.String("local_path", task.localPath),
-		zap.String("remote_path", task.remotePath))
+		zap.String("remote_path", task.remotePath),
+		zap.String("sync_id", task.syncID))
+
+	ctx, done := e.taskContext(ctx, task.rootPath)
+	defer done()
+	if e.directoryRemoved(task.rootPath) {
+		e.logger.Debug("Dropped upload of removed directory",
+			zap.String("local_path", task.localPath))
+		return
+	}
+
+	if e.isOffline() {
+		e.queueOffline(task)
+		return
+	}
+	queued := false
+	defer func() {
+		if !queued {
+			e.forgetOffline(task.localPath)
+		}
+	}()
+
+	if err := e.checkBudget(); err != nil {
+		e.logger.Warn("Skipping upload",
+			zap.String("local_path", task.localPath),
+			errorField(err))
+		e.recordSyncError(task.localPath, "upload", err, 0)
+		e.countFailed(task.syncID)
+		return
+	}
+
+	task = e.withReplacedSize(task)
+	if err := e.checkQuota(task, task.fileInfo.Size()); err != nil {
+		e.logger.Warn("Skipping upload",
+			zap.String("local_path", task.localPath),
+			errorField(err))
+		e.recordSyncError(task.localPath, "upload", err, 0)
+		e.countFailed(task.syncID)
+		return
+	}
 
 	var err error
+	retries := 0
 	for attempt := 0; attempt <= e.retryAttempts; attempt++ {
+		if attempt > 0 && e.isOffline() {
+			// Another transfer found the storage service unreachable
+			break
+		}
 		if attempt > 0 {
+			retries = attempt
 			e.logger.Warn("Retrying upload",
 				zap.String("local_path", task.localPath),
-				zap.Int("attempt", attempt))
-			time.Sleep(e.retryDelay)
+				zap.String("sync_id", task.syncID),
+				zap.Int("attempt", attempt),
+				errorField(err))
+			if err = e.sleep(ctx, e.retryDelay); err != nil {
+				break
+			}
 		}
 
+		attemptStart := e.clock.Now()
 		err = e.uploadFile(ctx, task)
-		if err == nil {
+		e.recordRequests(task.rootPath, 1, 0, 0, 0)
+		e.observeTransfer("upload", e.clock.Now().Sub(attemptStart), task.fileInfo.Size(), err)
+		if err == nil || errors.Is(err, errUnreadable) || errors.Is(err, interfaces.ErrCircuitOpen) || contentRejected(err) || interfaces.IsPermanent(err) {
 			break
 		}
 	}
 
-	duration := time.Since(start)
+	if err != nil && e.directoryRemoved(task.rootPath) {
+		e.logger.Debug("Cancelled upload of removed directory",
+			zap.String("local_path", task.localPath))
+		return
+	}
+
+	duration := e.clock.Now().Sub(start)
 	e.metrics.RecordFileOperation("upload", duration, err == nil)
 
+	if isConnectivityError(err) || (err != nil && e.isOffline()) {
+		e.goOffline(err)
+		e.queueOffline(task)
+		queued = true
+		return
+	}
+	if contentRejected(err) {
+		// The file was quarantined or contains secrets
+		e.recordSyncError(task.localPath, "upload", err, 0)
+		e.recordTransferError(task, "upload", err)
+		e.countFailed(task.syncID)
+		e.publishTransfer(task, interfaces.EventTransferFailed, task.fileInfo.Size(), err)
+		return
+	}
+	if errors.Is(err, errUnreadable) {
+		// Skipped until the next scan or change event
+		e.markUnreadable(ctx, task.localPath, err)
+		e.recordUploadFailure(ctx, task, err)
+		e.countFailed(task.syncID)
+		return
+	}
 	if err != nil {
 		e.logger.Error("Upload failed after retries",
 			zap.String("local_path", task.localPath),
-			zap.Error(err))
-		e.incrementSyncErrors()
+			zap.String("sync_id", task.syncID),
+			errorField(err))
+		e.recordSyncError(task.localPath, "upload", err, retries)
+		e.recordTransferError(task, "upload", err)
+		e.recordUploadFailure(ctx, task, err)
+		e.countFailed(task.syncID)
+		e.publishTransfer(task, interfaces.EventTransferFailed, task.fileInfo.Size(), err)
 	} else {
 		e.logger.Info("Upload completed",
 			zap.String("local_path", task.localPath),
 			zap.String("remote_path", task.remotePath),
+			zap.String("sync_id", task.syncID),
 			zap.Duration("duration", duration))
-		e.incrementFilesUploaded()
+		e.incrementFilesUploaded(ctx, task.fileInfo.Size())
+		e.recordUploadUsage(task, task.fileInfo.Size())
+		e.clearUnreadable(task.localPath)
+		e.recordUploadSuccess(task.localPath)
+		e.publishTransfer(task, interfaces.EventUploadCompleted, task.fileInfo.Size(), nil)
 	}
 }
 
 // processDownloadTask processes a single download task
 func (e *Engine) processDownloadTask(ctx context.Context, task syncTask, workerID int) {
-	start := time.Now()
+	start := e.clock.Now()
+	ctx = withSyncID(ctx, &task)
 
 	e.logger.Debug("Processing download task",
 		zap.Int("worker_id", workerID),
 		zap.String("local_path", task.localPath),
-		zap.String("remote_path", task.remotePath))
+		zap.String("remote_path", task.remotePath),
+		zap.String("sync_id", task.syncID))
+
+	ctx, done := e.taskContext(ctx, task.rootPath)
+	defer done()
+	if e.directoryRemoved(task.rootPath) {
+		e.logger.Debug("Dropped download of removed directory",
+			zap.String("remote_path", task.remotePath))
+		return
+	}
+
+	if e.isInfectedDownload(task) {
+		return
+	}
+
+	if err := e.checkBudget(); err != nil {
+		e.logger.Warn("Skipping download",
+			zap.String("remote_path", task.remotePath),
+			errorField(err))
+		e.recordSyncError(task.localPath, "download", err, 0)
+		e.countFailed(task.syncID)
+		return
+	}
 
 	var err error
+	retries := 0
 	for attempt := 0; attempt <= e.retryAttempts; attempt++ {
 		if attempt > 0 {
+			retries = attempt
 			e.logger.Warn("Retrying download",
 				zap.String("remote_path", task.remotePath),
-				zap.Int("attempt", attempt))
-			time.Sleep(e.retryDelay)
+				zap.String("sync_id", task.syncID),
+				zap.Int("attempt", attempt),
+				errorField(err))
+			if err = e.sleep(ctx, e.retryDelay); err != nil {
+				break
+			}
 		}
 
-		err = e.downloadFile(ctx, task)
-		if err == nil {
+		attemptStart := e.clock.Now()
+		err = e.downloadReplacing(ctx, task)
+		e.recordRequests(task.rootPath, 0, 1, 0, 0)
+		e.observeTransfer("download", e.clock.Now().Sub(attemptStart), task.metadata.Size, err)
+		if err == nil || errors.Is(err, interfaces.ErrObjectArchived) || errors.Is(err, errInfected) || interfaces.IsPermanent(err) {
 			break
 		}
 	}
 
-	duration := time.Since(start)
+	if err != nil && e.directoryRemoved(task.rootPath) {
+		e.logger.Debug("Cancelled download of removed directory",
+			zap.String("remote_path", task.remotePath))
+		return
+	}
+
+	duration := e.clock.Now().Sub(start)
 	e.metrics.RecordFileOperation("download", duration, err == nil)
 
+	if isConnectivityError(err) {
+		e.goOffline(err)
+	}
 	if err != nil {
 		e.logger.Error("Download failed after retries",
 			zap.String("remote_path", task.remotePath),
-			zap.Error(err))
-		e.incrementSyncErrors()
+			zap.String("sync_id", task.syncID),
+			errorField(err))
+		e.recordSyncError(task.localPath, "download", err, retries)
+		e.recordTransferError(task, "download", err)
+		if errors.Is(err, errInfected) {
+			e.quarantineDownload(task, err)
+		}
+		e.countFailed(task.syncID)
+		e.publishTransfer(task, interfaces.EventTransferFailed, task.metadata.Size, err)
 	} else {
 		e.logger.Info("Download completed",
 			zap.String("local_path", task.localPath),
 			zap.String("remote_path", task.remotePath),
 			zap.Duration("duration", duration))
-		e.incrementFilesDownloaded()
+		e.incrementFilesDownloaded(ctx, task.metadata.Size)
+		e.publishTransfer(task, interfaces.EventDownloadCompleted, task.metadata.Size, nil)
 	}
 }
 
 // uploadFile uploads a single file
 func (e *Engine) uploadFile(ctx context.Context, task syncTask) error {
-	file, err := os.Open(task.localPath)
-	if err != nil {
+	if err := e.checkReadable(task.rootPath, task.localPath, task.fileInfo); err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	if e.copyHardLink(ctx, task) {
+		return nil
+	}
+
+	file, release, err := e.openForUpload(ctx, task)
+	if os.IsNotExist(err) || errors.Is(err, context.Canceled) {
 		return fmt.Errorf("failed to open file: %w", err)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w: %w", errUnreadable, err)
+	}
+	defer release()
 	defer file.Close()
 
+	if err := e.scanUpload(ctx, task, file); err != nil {
+		return err
+	}
+
+	filtered, releaseFiltered, err := e.filterContent(ctx, task, file)
+	if err != nil {
+		return fmt.Errorf("failed to filter content: %w", err)
+	}
+	if filtered != nil {
+		defer releaseFiltered()
+		file = filtered
+	}
+
+	if err := e.scanSecrets(ctx, task, file); err != nil {
+		return err
+	}
+
 	// Get file info to determine size
 	fileInfo, err := file.Stat()
 	if err != nil {
@@ -420,11 +1123,19 @@ func (e *Engine) uploadFile(ctx context.Context, task syncTask) error {
 	fileSize := fileInfo.Size()
 
 	// Calculate MD5 hash
+	readLimiter := e.newReadLimiter()
+	var source io.Reader = file
+	if readLimiter != nil {
+		source = &pacedReader{ctx: ctx, clock: e.clock, limiter: readLimiter, reader: file}
+	}
 	hasher := md5.New()
-	_, err = io.Copy(hasher, file)
-	if err != nil {
+	_, err = io.Copy(hasher, source)
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return fmt.Errorf("failed to calculate MD5: %w", err)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to calculate MD5: %w: %w", errUnreadable, err)
+	}
 
 	// Reset file pointer
 	if _, err := file.Seek(0, 0); err != nil {
@@ -437,114 +1148,202 @@ func (e *Engine) uploadFile(ctx context.Context, task syncTask) error {
 		MD5Hash:     fmt.Sprintf("%x", hasher.Sum(nil)),
 		ContentType: e.getContentType(task.localPath),
 		Permissions: task.fileInfo.Mode().String(),
+		OriginalKey: task.originalKey,
 	}
 
-	// Record bandwidth
-	e.metrics.RecordBandwidth(fileSize, "upload")
-
-	err = e.provider.Upload(ctx, task.remotePath, file, metadata)
+	uploadCtx, cancel := e.transferContext(ctx, fileSize)
+	defer cancel()
+
+	var transferred atomic.Int64
+	uploadCtx, stopWatch := e.watchStall(uploadCtx, &transferred, task.localPath, "upload")
+	defer stopWatch()
+	defer e.trackTransfer(task, "upload", fileSize, &transferred)()
+
+	progress := e.transferProgress(uploadCtx, "upload", &transferred)
+	if readLimiter != nil {
+		// The provider reads the file itself, so reads are paced as
+		// progress is reported
+		send := progress
+		progress = func(n int64) {
+			send(n)
+			readLimiter.wait(uploadCtx, e.clock, n)
+		}
+	}
+	options := interfaces.TransferOptions{Progress: progress}
+	if uploader, ok := e.multipartUploader(fileSize); ok {
+		err = e.uploadMultipart(uploadCtx, uploader, task, file, metadata, options)
+	} else {
+		err = e.provider.Upload(uploadCtx, task.remotePath, file, metadata, options)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return fmt.Errorf("failed to upload file: %w", stallError(uploadCtx, err))
 	}
 
+	e.recordUploadState(task, fileSize, metadata.MD5Hash, filtered != nil)
+
 	return nil
 }
 
-// downloadFile downloads a single file
+// partialDownloadSuffix marks files being downloaded; the leading dot of
+// their names keeps scans from seeing them
+const partialDownloadSuffix = ".cloudawsync-part"
+
+// downloadFile downloads a single file. The content is written to a new
+// file with a random name next to the local one and moved into place once
+// complete and scanned, so readers never see a partial file.
 func (e *Engine) downloadFile(ctx context.Context, task syncTask) error {
-	reader, metadata, err := e.provider.Download(ctx, task.remotePath)
+	// The context must outlive Download since the body is streamed afterwards
+	var expectedSize int64
+	if task.fileInfo != nil {
+		expectedSize = task.fileInfo.Size()
+	} else {
+		expectedSize = task.metadata.Size
+	}
+	downloadCtx, cancel := e.transferContext(ctx, expectedSize)
+	defer cancel()
+
+	var transferred atomic.Int64
+	downloadCtx, stopWatch := e.watchStall(downloadCtx, &transferred, task.remotePath, "download")
+	defer stopWatch()
+
+	options := interfaces.TransferOptions{Progress: e.transferProgress(downloadCtx, "download", &transferred)}
+	body, metadata, err := e.openDownload(downloadCtx, task, expectedSize, options)
+	if errors.Is(err, interfaces.ErrObjectArchived) {
+		return fmt.Errorf("failed to download file: %w", e.archivedError(ctx, task))
+	}
 	if err != nil {
-		return fmt.Errorf("failed to download file: %w", err)
+		return fmt.Errorf("failed to download file: %w", stallError(downloadCtx, err))
 	}
-	defer reader.Close()
+	defer body.Close()
+	defer e.trackTransfer(task, "download", max(metadata.Size, expectedSize), &transferred)()
 
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(task.localPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := e.mkdirAllAs(task.rootPath, dir, 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	file, err := os.Create(task.localPath)
+	file, err := e.fs.CreateTemp(dir, "."+filepath.Base(task.localPath)+".*"+partialDownloadSuffix)
 	if err != nil {
 		return fmt.Errorf("failed to create local file: %w", err)
 	}
+	target := task.localPath
+	task.localPath = file.Name()
+	moved := false
+	defer func() {
+		if moved {
+			return
+		}
+		if err := e.fs.Remove(task.localPath); err != nil && !os.IsNotExist(err) {
+			e.logger.Warn("Failed to remove partial download",
+				zap.String("path", task.localPath),
+				errorField(err))
+		}
+	}()
 	defer file.Close()
+	e.chownAs(task.rootPath, task.localPath)
 
 	// Calculate MD5 while copying
 	hasher := md5.New()
 	writer := io.MultiWriter(file, hasher)
 
-	size, err := io.Copy(writer, reader)
+	size, err := io.Copy(writer, body)
 	if err != nil {
-		return fmt.Errorf("failed to copy file data: %w", err)
+		return fmt.Errorf("failed to copy file data: %w", stallError(downloadCtx, err))
 	}
 
-	// Verify MD5 hash
+	// Verify MD5 hash, falling back to the hash known by the caller
 	actualHash := fmt.Sprintf("%x", hasher.Sum(nil))
-	if metadata.MD5Hash != "" && metadata.MD5Hash != actualHash {
+	expectedHash := metadata.MD5Hash
+	if expectedHash == "" {
+		expectedHash = task.metadata.MD5Hash
+	}
+	if expectedHash != "" && expectedHash != actualHash {
 		return fmt.Errorf("MD5 hash mismatch: expected %s, got %s",
-			metadata.MD5Hash, actualHash)
+			expectedHash, actualHash)
 	}
 
-	// Set file modification time
-	if !metadata.ModTime.IsZero() {
-		if err := os.Chtimes(task.localPath, metadata.ModTime, metadata.ModTime); err != nil {
+	// Set file modification time, preferring that of the uploaded file
+	modTime := metadata.SourceModTime
+	if modTime.IsZero() {
+		modTime = metadata.ModTime
+	}
+	if !modTime.IsZero() {
+		if err := e.fs.Chtimes(task.localPath, modTime, modTime); err != nil {
 			e.logger.Warn("Failed to set file modification time",
 				zap.String("path", task.localPath),
-				zap.Error(err))
+				errorField(err))
 		}
 	}
 
-	// Record bandwidth
-	e.metrics.RecordBandwidth(size, "download")
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write local file: %w", err)
+	}
+
+	e.recordRequests(task.rootPath, 0, 0, 0, size)
 
+	if err := e.scanDownload(ctx, task); err != nil {
+		return err
+	}
+	if err := e.fs.Rename(task.localPath, target); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	moved = true
 	return nil
 }
 
-// Helper methods for getting file information and managing state
-
-func (e *Engine) getLocalFiles(rootPath string, recursive bool) (map[string]os.FileInfo, error) {
-	files := make(map[string]os.FileInfo)
+// sleep waits for d on the engine clock, returning early with the context's
+// error when ctx is done
+func (e *Engine) sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-e.clock.After(d):
+		return nil
+	}
+}
 
-	walkFn := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// operationContext derives a context bounded by the operation timeout
+func (e *Engine) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return e.transferContext(ctx, 0)
+}
 
-		if info.IsDir() {
-			return nil
-		}
+// listContext derives a context for a paginated listing. The operation
+// timeout applies to each page request rather than the whole listing,
+// which takes longer the more objects a prefix holds.
+func (e *Engine) listContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	e.mutex.RLock()
+	timeout := e.operationTimeout
+	e.mutex.RUnlock()
 
-		files[path] = info
-		return nil
+	ctx, cancel := context.WithCancel(ctx)
+	if timeout <= 0 {
+		return ctx, cancel
 	}
+	return interfaces.WithPageTimeout(ctx, timeout), cancel
+}
 
-	if recursive {
-		err := filepath.Walk(rootPath, walkFn)
-		return files, err
-	}
+// transferContext derives a context whose timeout is scaled by transfer size
+func (e *Engine) transferContext(ctx context.Context, size int64) (context.Context, context.CancelFunc) {
+	e.mutex.RLock()
+	timeout := e.operationTimeout
+	perMB := e.transferTimeoutPerMB
+	e.mutex.RUnlock()
 
-	// Non-recursive: only process files in the root directory
-	entries, err := os.ReadDir(rootPath)
-	if err != nil {
-		return nil, err
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			info, err := entry.Info()
-			if err != nil {
-				continue
-			}
-			path := filepath.Join(rootPath, entry.Name())
-			files[path] = info
-		}
+	if size > 0 && perMB > 0 {
+		megabytes := (size + (1<<20 - 1)) >> 20
+		timeout += time.Duration(megabytes) * perMB
 	}
-
-	return files, nil
+	return context.WithTimeout(ctx, timeout)
 }
 
-func (e *Engine) shouldSyncFile(path string, filters []string) bool {
+// Helper methods for getting file information and managing state
+
+func (e *Engine) shouldSyncFile(path string, dir interfaces.SyncDirectory) bool {
 	filename := filepath.Base(path)
 
 	// Skip hidden files
@@ -552,14 +1351,19 @@ func (e *Engine) shouldSyncFile(path string, filters []string) bool {
 		return false
 	}
 
+	// Skip archive stubs
+	if IsStub(filename) {
+		return false
+	}
+
 	// Apply filters
-	for _, filter := range filters {
+	for _, filter := range dir.Filters {
 		if matched, _ := filepath.Match(filter, filename); matched {
 			return false
 		}
 	}
 
-	return true
+	return !e.ignoredByFiles(dir, path)
 }
 
 func (e *Engine) getRelativePath(fullPath, rootPath string) string {
@@ -570,10 +1374,47 @@ func (e *Engine) getRelativePath(fullPath, rootPath string) string {
 	return relPath
 }
 
-func (e *Engine) needsUpload(localInfo os.FileInfo, remoteInfo interfaces.FileInfo) bool {
-	// Compare modification times and sizes
-	return localInfo.ModTime().After(remoteInfo.ModTime) ||
-		localInfo.Size() != remoteInfo.Size
+// remoteDirPrefix returns the listing prefix selecting the remote objects
+// of dir, and not those of directories whose name merely starts with it
+func remoteDirPrefix(dir interfaces.SyncDirectory) string {
+	return strings.TrimSuffix(dir.RemotePath, "/") + "/"
+}
+
+// remoteChangeSlack allows for clock differences between the agent and the
+// storage service when comparing upload times
+const remoteChangeSlack = time.Minute
+
+// detectConflict reports a local change about to overwrite a remote object
+// that was modified after the agent last uploaded it. The local version
+// still wins; the conflict is logged and published as an event.
+func (e *Engine) detectConflict(rootPath, localPath string, remoteInfo interfaces.FileInfo) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	record, ok := store.Get(remoteInfo.Key)
+	if !ok {
+		return
+	}
+	if remoteInfo.Size == record.Size && !e.localTime(remoteInfo.ModTime).After(record.UploadedAt.Add(remoteChangeSlack)) {
+		return
+	}
+
+	e.logger.Warn("Remote object changed since last upload, overwriting with local version",
+		zap.String("local_path", localPath),
+		zap.String("remote_path", remoteInfo.Key),
+		zap.Time("uploaded_at", record.UploadedAt),
+		zap.Time("remote_mod_time", remoteInfo.ModTime))
+	e.publish(interfaces.SyncEvent{
+		Type:       interfaces.EventConflict,
+		Directory:  rootPath,
+		LocalPath:  localPath,
+		RemotePath: remoteInfo.Key,
+		Size:       remoteInfo.Size,
+	})
 }
 
 func (e *Engine) getContentType(path string) string {
@@ -619,7 +1460,8 @@ func (e *Engine) hasScheduledDirectories() bool {
 	defer e.mutex.RUnlock()
 
 	for _, dir := range e.directories {
-		if dir.SyncMode == interfaces.SyncModeScheduled || dir.SyncMode == interfaces.SyncModeBoth {
+		if dir.SyncMode == interfaces.SyncModeScheduled || dir.SyncMode == interfaces.SyncModeBoth ||
+			dir.SyncMode == interfaces.SyncModeBackup {
 			return true
 		}
 	}
@@ -653,6 +1495,13 @@ func (e *Engine) startFileWatcher(ctx context.Context) error {
 		return err
 	}
 
+	e.mutex.Lock()
+	e.watching = true
+	for _, dir := range dirs {
+		e.watched[dir] = true
+	}
+	e.mutex.Unlock()
+
 	e.wg.Add(1)
 	go e.handleFileEvents(ctx, events)
 
@@ -681,12 +1530,15 @@ func (e *Engine) handleFileEvents(ctx context.Context, events <-chan interfaces.
 				zap.String("path", event.Path),
 				zap.String("operation", event.Operation),
 				zap.Bool("is_dir", event.IsDir))
-			e.processFileEvent(ctx, event)
+			e.processFileEvent(ctx, event, false)
 		}
 	}
 }
 
-func (e *Engine) processFileEvent(ctx context.Context, event interfaces.FileEvent) {
+// processFileEvent queues the upload of a changed file. With block it
+// waits for room in the upload queue, otherwise events that find it full
+// are held in the backlog.
+func (e *Engine) processFileEvent(ctx context.Context, event interfaces.FileEvent, block bool) {
 	if event.IsDir {
 		e.logger.Debug("Skipping directory event", zap.String("path", event.Path))
 		return // Skip directory events
@@ -713,11 +1565,16 @@ func (e *Engine) processFileEvent(ctx context.Context, event interfaces.FileEven
 		return
 	}
 
-	if !e.shouldSyncFile(event.Path, matchedDir.Filters) {
+	if !e.shouldSyncFile(event.Path, *matchedDir) {
 		e.logger.Debug("File filtered out", zap.String("path", event.Path))
 		return
 	}
 
+	if info, err := e.fs.Lstat(event.Path); err == nil && e.cachedHydration(event.Path, info) {
+		e.logger.Debug("Skipping unmodified hydrated file", zap.String("path", event.Path))
+		return
+	}
+
 	switch event.Operation {
 	case "create", "modify":
 		e.logger.Info("Processing file change event",
@@ -725,36 +1582,61 @@ func (e *Engine) processFileEvent(ctx context.Context, event interfaces.FileEven
 			zap.String("operation", event.Operation))
 
 		// Queue for upload
-		if info, err := os.Stat(event.Path); err == nil {
-			relativePath := e.getRelativePath(event.Path, matchedDir.LocalPath)
-			remotePath := filepath.Join(matchedDir.RemotePath, relativePath)
+		if info, err := e.fs.Stat(event.Path); err == nil {
+			if reason := e.excludedByRules(info, matchedDir.FileRules); reason != "" {
+				e.logger.Debug("File excluded by file rules",
+					zap.String("path", event.Path),
+					zap.String("reason", reason))
+				return
+			}
+
+			remotePath, originalKey, err := e.remoteKey(*matchedDir, event.Path)
+			if err != nil {
+				e.skipKey(event.Path, err)
+				return
+			}
 
 			task := syncTask{
-				localPath:  event.Path,
-				remotePath: remotePath,
-				operation:  "upload",
-				fileInfo:   info,
+				localPath:   event.Path,
+				remotePath:  remotePath,
+				rootPath:    matchedDir.LocalPath,
+				operation:   "upload",
+				fileInfo:    info,
+				originalKey: originalKey,
 			}
+			e.journalEvent(event)
 
-			select {
-			case e.uploadQueue <- task:
+			if e.deferUpload(ctx, task, matchedDir.Throttle) {
+				e.logger.Debug("Deferred upload of throttled file",
+					zap.String("local_path", event.Path))
+				return
+			}
+
+			if !block && e.eventBacklog().len() > 0 {
+				// Changes held earlier are queued first
+				e.holdEvent(event)
+				return
+			}
+			queued, err := e.enqueueUpload(ctx, task, block)
+			switch {
+			case err == errUploadQueueFull:
+				e.holdEvent(event)
+			case err != nil:
+				return
+			case queued:
 				e.logger.Info("Queued file for upload",
 					zap.String("local_path", event.Path),
 					zap.String("remote_path", remotePath))
-			case <-ctx.Done():
-				return
-			default:
-				e.logger.Warn("Upload queue full, dropping task",
-					zap.String("path", event.Path))
 			}
 		} else {
 			e.logger.Error("Failed to stat file after event",
 				zap.String("path", event.Path),
-				zap.Error(err))
+				errorField(err))
 		}
 	case "delete":
 		e.logger.Info("File deletion detected",
 			zap.String("path", event.Path))
+		e.clearUnreadable(event.Path)
 		// Queue for deletion (if implemented)
 		// For now, we'll skip deletion sync for safety
 	default:
@@ -764,61 +1646,285 @@ func (e *Engine) processFileEvent(ctx context.Context, event interfaces.FileEven
 	}
 }
 
+// errUploadQueueFull is returned by a non-blocking enqueue when the queue is full
+var errUploadQueueFull = errors.New("upload queue full")
+
+// enqueueUpload queues an upload task unless the same path is already
+// queued or uploading. A request for a path that is currently uploading is
+// coalesced into a single follow-up upload run once the current one ends.
+// Quarantined files are not queued. It reports whether a new task was
+// placed on the queue.
+func (e *Engine) enqueueUpload(ctx context.Context, task syncTask, block bool) (bool, error) {
+	if task.syncID == "" {
+		task.syncID = interfaces.SyncID(ctx)
+	}
+	if e.isQuarantined(task) {
+		return false, nil
+	}
+
+	e.inFlightMutex.Lock()
+	if state, ok := e.inFlight[task.localPath]; ok {
+		if state.running {
+			state.pending = &task
+		}
+		e.inFlightMutex.Unlock()
+		e.logger.Debug("Coalesced duplicate upload request",
+			zap.String("local_path", task.localPath),
+			zap.Bool("in_progress", state.running))
+		return false, nil
+	}
+	e.inFlight[task.localPath] = &inFlightUpload{}
+	e.inFlightMutex.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			e.clearInFlight(task.localPath)
+			return false, err
+		}
+		pushed, wait := e.uploadQueue.push(task)
+		if pushed {
+			e.countQueued(task.syncID)
+			return true, nil
+		}
+		if !block {
+			e.clearInFlight(task.localPath)
+			return false, errUploadQueueFull
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// QueueUpload queues a single file for upload to remotePath, waiting for
+// room in the upload queue. rootPath attributes the transfer in statistics.
+func (e *Engine) QueueUpload(ctx context.Context, localPath, remotePath, rootPath string) error {
+	info, err := e.fs.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	_, err = e.enqueueUpload(ctx, syncTask{
+		localPath:  localPath,
+		remotePath: remotePath,
+		rootPath:   rootPath,
+		operation:  "upload",
+		fileInfo:   info,
+	}, true)
+	return err
+}
+
+// PendingUploads returns the number of uploads queued or in progress
+func (e *Engine) PendingUploads() int {
+	e.inFlightMutex.Lock()
+	defer e.inFlightMutex.Unlock()
+	return len(e.inFlight)
+}
+
+// idleCheckInterval is how often WaitIdle checks for finished transfers
+const idleCheckInterval = 200 * time.Millisecond
+
+// WaitIdle waits until every queued upload and download has been processed
+// and then writes the manifests of the directories synced, for callers that
+// stop the engine after a sync. Uploads deferred while offline are not
+// waited for.
+func (e *Engine) WaitIdle(ctx context.Context) error {
+	ticker := e.clock.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for e.PendingUploads() > 0 || e.pendingDownloads.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+
+	e.mutex.RLock()
+	manifests := e.manifests
+	e.mutex.RUnlock()
+	if manifests {
+		e.writeStaleManifests(ctx)
+	}
+	return nil
+}
+
+// startInFlight marks a queued upload as running
+func (e *Engine) startInFlight(path string) {
+	e.inFlightMutex.Lock()
+	defer e.inFlightMutex.Unlock()
+
+	if state, ok := e.inFlight[path]; ok {
+		state.running = true
+	} else {
+		e.inFlight[path] = &inFlightUpload{running: true}
+	}
+}
+
+// finishInFlight completes an upload, returning a coalesced follow-up task
+// if another upload of the same path was requested while it ran
+func (e *Engine) finishInFlight(path string) (syncTask, bool) {
+	e.inFlightMutex.Lock()
+	defer e.inFlightMutex.Unlock()
+
+	state, ok := e.inFlight[path]
+	if ok && state.pending != nil {
+		next := *state.pending
+		state.pending = nil
+		return next, true
+	}
+	delete(e.inFlight, path)
+	return syncTask{}, false
+}
+
+// clearInFlight removes tracking for a path that was never queued
+func (e *Engine) clearInFlight(path string) {
+	e.inFlightMutex.Lock()
+	delete(e.inFlight, path)
+	e.inFlightMutex.Unlock()
+}
+
+// scheduleCheckInterval is how often scheduled directories are checked
+// for a due sync; cron schedules have minute resolution
+const scheduleCheckInterval = time.Minute
+
+// defaultScheduleInterval is how often scheduled directories without a
+// schedule are synced
+const defaultScheduleInterval = 5 * time.Minute
+
 func (e *Engine) scheduledSyncWorker(ctx context.Context) {
 	defer e.wg.Done()
 
-	// For simplicity, we'll use a basic interval-based scheduler
-	// In a production system, you'd want to use a proper cron scheduler
-	ticker := time.NewTicker(5 * time.Minute) // Check every 5 minutes
+	ticker := e.clock.NewTicker(scheduleCheckInterval)
 	defer ticker.Stop()
 
+	next := make(map[string]time.Time)
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-e.stopChan:
 			return
-		case <-ticker.C:
-			e.runScheduledSync(ctx)
+		case <-ticker.C():
+			e.runScheduledSync(ctx, e.dueScheduledDirectories(next))
 		}
 	}
 }
 
-func (e *Engine) runScheduledSync(ctx context.Context) {
+// dueScheduledDirectories returns the enabled scheduled directories whose
+// sync is due. next holds when each directory is due again and is updated;
+// a directory seen for the first time waits for its schedule.
+func (e *Engine) dueScheduledDirectories(next map[string]time.Time) []interfaces.SyncDirectory {
+	now := e.clock.Now()
+
 	e.mutex.RLock()
-	var scheduledDirs []interfaces.SyncDirectory
+	defer e.mutex.RUnlock()
+
+	var due []interfaces.SyncDirectory
 	for _, dir := range e.directories {
-		if (dir.SyncMode == interfaces.SyncModeScheduled || dir.SyncMode == interfaces.SyncModeBoth) && dir.Enabled {
-			scheduledDirs = append(scheduledDirs, dir)
+		if (dir.SyncMode != interfaces.SyncModeScheduled && dir.SyncMode != interfaces.SyncModeBoth &&
+			dir.SyncMode != interfaces.SyncModeBackup) || !dir.Enabled {
+			continue
+		}
+		at, ok := next[dir.LocalPath]
+		if ok && now.Before(at) {
+			continue
+		}
+		if ok {
+			due = append(due, dir)
 		}
+		next[dir.LocalPath] = e.nextScheduledSync(dir, now)
+	}
+	return due
+}
+
+// nextScheduledSync returns when a scheduled directory is next due after
+// now: at the next firing of its cron schedule, or defaultScheduleInterval
+// later when it has none or its schedule cannot be used
+func (e *Engine) nextScheduledSync(dir interfaces.SyncDirectory, now time.Time) time.Time {
+	if dir.Schedule == "" {
+		return now.Add(defaultScheduleInterval)
+	}
+	schedule, err := cron.Parse(dir.Schedule)
+	if err != nil {
+		e.logger.Warn("Invalid schedule, syncing at the default interval",
+			zap.String("directory", dir.LocalPath),
+			zap.String("schedule", dir.Schedule),
+			zap.Duration("interval", defaultScheduleInterval),
+			errorField(err))
+		return now.Add(defaultScheduleInterval)
+	}
+	at := schedule.Next(now)
+	if at.IsZero() {
+		e.logger.Warn("Schedule never fires, syncing at the default interval",
+			zap.String("directory", dir.LocalPath),
+			zap.String("schedule", dir.Schedule),
+			zap.Duration("interval", defaultScheduleInterval))
+		return now.Add(defaultScheduleInterval)
+	}
+	return at
+}
+
+// runScheduledSync syncs the scheduled directories that are due
+func (e *Engine) runScheduledSync(ctx context.Context, dirs []interfaces.SyncDirectory) {
+	if len(dirs) == 0 {
+		return
 	}
-	e.mutex.RUnlock()
 
-	for _, dir := range scheduledDirs {
-		if err := e.Sync(ctx, dir); err != nil {
+	failures := e.SyncDirectories(ctx, dirs, e.Sync)
+	for _, dir := range dirs {
+		if err, ok := failures[dir.LocalPath]; ok {
 			e.logger.Error("Scheduled sync failed",
 				zap.String("directory", dir.LocalPath),
-				zap.Error(err))
+				errorField(err))
 		}
 	}
 }
 
-func (e *Engine) incrementFilesUploaded() {
+// incrementFilesUploaded counts an upload in the statistics and in the
+// sync run ctx belongs to
+func (e *Engine) incrementFilesUploaded(ctx context.Context, bytes int64) {
 	e.mutex.Lock()
 	e.stats.FilesUploaded++
-	e.stats.LastSyncTime = time.Now()
+	e.stats.BytesUploaded += bytes
+	e.stats.LastSyncTime = e.clock.Now()
 	e.mutex.Unlock()
+	e.updateRun(interfaces.SyncID(ctx), true, func(run *state.SyncRun) {
+		run.FilesUploaded++
+		run.BytesUploaded += bytes
+	})
 }
 
-func (e *Engine) incrementFilesDownloaded() {
+// incrementFilesDownloaded counts a download in the statistics and in the
+// sync run ctx belongs to
+func (e *Engine) incrementFilesDownloaded(ctx context.Context, bytes int64) {
 	e.mutex.Lock()
 	e.stats.FilesDownloaded++
-	e.stats.LastSyncTime = time.Now()
+	e.stats.BytesDownloaded += bytes
+	e.stats.LastSyncTime = e.clock.Now()
 	e.mutex.Unlock()
+	e.updateRun(interfaces.SyncID(ctx), true, func(run *state.SyncRun) {
+		run.FilesDownloaded++
+		run.BytesDownloaded += bytes
+	})
 }
 
-func (e *Engine) incrementSyncErrors() {
+// recordSyncError counts a failed operation and keeps it for GetStats,
+// dropping the oldest once recentSyncErrorLimit is reached
+func (e *Engine) recordSyncError(path, operation string, err error, retries int) {
 	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
 	e.stats.SyncErrors++
-	e.mutex.Unlock()
+	e.syncErrors = append(e.syncErrors, interfaces.SyncError{
+		Path:      path,
+		Operation: operation,
+		Error:     err,
+		Timestamp: e.clock.Now(),
+		Retries:   retries,
+	})
+	if len(e.syncErrors) > recentSyncErrorLimit {
+		e.syncErrors = e.syncErrors[len(e.syncErrors)-recentSyncErrorLimit:]
+	}
 }
@@ -0,0 +1,77 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/metrics"
+	"CloudAWSync/internal/state"
+	fakes "CloudAWSync/internal/testing"
+
+	"go.uber.org/zap"
+)
+
+// testEngine is an engine wired to in-memory fakes and a state store in a
+// temporary directory
+type testEngine struct {
+	*Engine
+	provider *fakes.MemoryProvider
+	clock    *fakes.FakeClock
+	fs       *fakes.MemoryFS
+	store    *state.Store
+}
+
+// newTestEngine creates an engine running on fakes, starting its clock at
+// the middle of a month
+func newTestEngine(t *testing.T) *testEngine {
+	t.Helper()
+
+	provider := fakes.NewMemoryProvider()
+	clock := fakes.NewFakeClock(time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC))
+	fs := fakes.NewMemoryFS(clock)
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEngine(provider, nil, metrics.NewSimpleCollector(zap.NewNop()), zap.NewNop(), 1, 1, 1, time.Millisecond)
+	e.SetClock(clock)
+	e.SetFileSystem(fs)
+	e.SetStateStore(store)
+	return &testEngine{Engine: e, provider: provider, clock: clock, fs: fs, store: store}
+}
+
+// putUploaded stores an object and records it as uploaded from localPath
+func (te *testEngine) putUploaded(key, localPath string, data []byte) {
+	now := te.clock.Now()
+	te.provider.Put(key, data, now)
+	te.store.Put(state.ObjectRecord{
+		Key:        key,
+		LocalPath:  localPath,
+		Size:       int64(len(data)),
+		ModTime:    now,
+		UploadedAt: now,
+	})
+}
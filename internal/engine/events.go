@@ -0,0 +1,94 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"sync"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// eventBus fans sync events out to subscribers
+type eventBus struct {
+	subscribers map[int]chan interfaces.SyncEvent
+	nextID      int
+	mutex       sync.Mutex
+}
+
+// Subscribe returns a channel receiving every sync event published from
+// now on, buffering up to buffer events. Events are dropped for a
+// subscriber whose buffer is full rather than slowing the engine down.
+// The returned function ends the subscription and closes the channel.
+func (e *Engine) Subscribe(buffer int) (<-chan interfaces.SyncEvent, func()) {
+	bus := &e.events
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[int]chan interfaces.SyncEvent)
+	}
+	id := bus.nextID
+	bus.nextID++
+	events := make(chan interfaces.SyncEvent, buffer)
+	bus.subscribers[id] = events
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			bus.mutex.Lock()
+			defer bus.mutex.Unlock()
+			delete(bus.subscribers, id)
+			close(events)
+		})
+	}
+}
+
+// publish sends an event to every subscriber without blocking
+func (e *Engine) publish(event interfaces.SyncEvent) {
+	event.Time = e.clock.Now()
+
+	bus := &e.events
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	for _, events := range bus.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// publishTransfer publishes the outcome of a transfer task
+func (e *Engine) publishTransfer(task syncTask, eventType interfaces.SyncEventType, size int64, err error) {
+	event := interfaces.SyncEvent{
+		Type:       eventType,
+		Directory:  task.rootPath,
+		LocalPath:  task.localPath,
+		RemotePath: task.remotePath,
+		Size:       size,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	e.publish(event)
+}
@@ -0,0 +1,82 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// hashFile calculates the MD5 hash of a file on the engine's file system
+func (e *Engine) hashFile(path string) (string, error) {
+	file, err := e.fs.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// readFile reads a whole file from fs
+func readFile(fs interfaces.FileSystem, path string) ([]byte, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// writeFileAtomic writes data to a new file next to path and moves it
+// into place, so readers see either the old or the new content
+func (e *Engine) writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := e.fs.CreateTemp(filepath.Dir(path), ".tmp_"+filepath.Base(path)+"_*")
+	if err != nil {
+		return err
+	}
+	temp := file.Name()
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = e.fs.Chmod(temp, perm)
+	}
+	if err == nil {
+		err = e.fs.Rename(temp, path)
+	}
+	if err != nil {
+		e.fs.Remove(temp)
+	}
+	return err
+}
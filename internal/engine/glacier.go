@@ -0,0 +1,275 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// pendingRestore is a restore of an archived object requested by a download
+type pendingRestore struct {
+	rootPath    string // directory the download was for
+	localPath   string // file the download was for
+	requestedAt time.Time
+}
+
+// SetGlacierRestore makes downloads of objects in archival storage classes
+// request a restore, keeping the restored copy for days and retrieving it
+// with tier. Requested restores are checked every checkInterval until the
+// object is readable. Zero days leaves archived objects unreadable.
+func (e *Engine) SetGlacierRestore(days int, tier string, checkInterval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.restoreDays = days
+	e.restoreTier = tier
+	e.restoreCheckInterval = checkInterval
+}
+
+// archivedError explains a download that found its object in archival
+// storage. With restores enabled, a restore is requested unless one is
+// already pending, and the object is checked until it is readable. The
+// returned error wraps interfaces.ErrObjectArchived.
+func (e *Engine) archivedError(ctx context.Context, task syncTask) error {
+	e.mutex.RLock()
+	days := e.restoreDays
+	tier := e.restoreTier
+	e.mutex.RUnlock()
+	if days <= 0 {
+		return fmt.Errorf("%w, enable glacier_restore to restore it", interfaces.ErrObjectArchived)
+	}
+
+	e.restoreMutex.Lock()
+	pending, ok := e.restores[task.remotePath]
+	e.restoreMutex.Unlock()
+	if ok {
+		return fmt.Errorf("%w, restore requested %s ago is in progress",
+			interfaces.ErrObjectArchived, e.clock.Now().Sub(pending.requestedAt).Round(time.Second))
+	}
+
+	restorer, ok := e.provider.(interfaces.RestoreProvider)
+	if !ok {
+		return fmt.Errorf("%w, the storage provider cannot restore objects", interfaces.ErrObjectArchived)
+	}
+
+	opCtx, cancel := e.operationContext(ctx)
+	err := restorer.Restore(opCtx, task.remotePath, days, tier)
+	cancel()
+	e.recordRequests(task.rootPath, 1, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("%w, %w", interfaces.ErrObjectArchived, err)
+	}
+
+	e.restoreMutex.Lock()
+	e.restores[task.remotePath] = pendingRestore{
+		rootPath:    task.rootPath,
+		localPath:   task.localPath,
+		requestedAt: e.clock.Now(),
+	}
+	count := len(e.restores)
+	e.restoreMutex.Unlock()
+	e.setRestoresPending(count)
+
+	e.logger.Info("Requested restore of archived object",
+		zap.String("remote_path", task.remotePath),
+		zap.String("tier", tier),
+		zap.Int("days", days))
+	return fmt.Errorf("%w, restore requested with %s retrieval", interfaces.ErrObjectArchived, tier)
+}
+
+// planRestores checks the objects of tasks before any is downloaded, so a
+// restore of a backup generation requests the restores of all its archived
+// objects at once rather than failing at the first. It returns an error
+// naming how many objects are not readable yet.
+func (e *Engine) planRestores(ctx context.Context, tasks []syncTask) error {
+	var archived, requested int
+	var firstErr error
+	for _, task := range tasks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		opCtx, cancel := e.operationContext(ctx)
+		metadata, err := e.provider.GetMetadata(opCtx, task.remotePath)
+		cancel()
+		e.recordRequests(task.rootPath, 0, 1, 0, 0)
+		if err != nil {
+			return fmt.Errorf("failed to get metadata of %s: %w", task.remotePath, err)
+		}
+		if !metadata.Archived {
+			continue
+		}
+
+		archived++
+		err = e.archivedError(ctx, task)
+		if firstErr == nil {
+			firstErr = err
+		}
+		if e.restorePending(task.remotePath) {
+			requested++
+		}
+	}
+	if archived == 0 {
+		return nil
+	}
+
+	e.logger.Warn("Objects to download are in archival storage",
+		zap.Int("objects", len(tasks)),
+		zap.Int("archived", archived),
+		zap.Int("restores_pending", requested))
+	if requested == 0 {
+		return fmt.Errorf("%d of %d objects are archived: %w", archived, len(tasks), firstErr)
+	}
+	return fmt.Errorf("%d of %d objects are archived, %d restore(s) pending, retry once they complete: %w",
+		archived, len(tasks), requested, interfaces.ErrObjectArchived)
+}
+
+// restorePending reports whether a restore of key was requested
+func (e *Engine) restorePending(key string) bool {
+	e.restoreMutex.Lock()
+	defer e.restoreMutex.Unlock()
+	_, ok := e.restores[key]
+	return ok
+}
+
+// restoreWorker checks requested restores every interval
+func (e *Engine) restoreWorker(ctx context.Context, interval time.Duration) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			if !e.isOffline() {
+				e.checkRestores(ctx)
+			}
+		}
+	}
+}
+
+// checkRestores drops the requested restores whose objects are readable
+// or gone, publishing an event for each completed restore
+func (e *Engine) checkRestores(ctx context.Context) {
+	e.restoreMutex.Lock()
+	pending := make(map[string]pendingRestore, len(e.restores))
+	for key, restore := range e.restores {
+		pending[key] = restore
+	}
+	e.restoreMutex.Unlock()
+
+	for key, restore := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+
+		opCtx, cancel := e.operationContext(ctx)
+		metadata, err := e.provider.GetMetadata(opCtx, key)
+		cancel()
+		e.recordRequests(restore.rootPath, 0, 1, 0, 0)
+		if err != nil {
+			if !errors.Is(err, interfaces.ErrNotFound) {
+				e.logger.Debug("Failed to check restore",
+					zap.String("remote_path", key),
+					errorField(err))
+				continue
+			}
+		} else if metadata.Archived {
+			continue
+		}
+
+		e.restoreMutex.Lock()
+		delete(e.restores, key)
+		count := len(e.restores)
+		e.restoreMutex.Unlock()
+		e.setRestoresPending(count)
+
+		if err != nil {
+			e.logger.Info("Archived object removed before its restore completed",
+				zap.String("remote_path", key))
+			continue
+		}
+		e.logger.Info("Archived object restored",
+			zap.String("remote_path", key),
+			zap.String("local_path", restore.localPath),
+			zap.Time("readable_until", metadata.RestoredUntil),
+			zap.Duration("waited", e.clock.Now().Sub(restore.requestedAt)))
+		e.publish(interfaces.SyncEvent{
+			Type:       interfaces.EventRestoreCompleted,
+			Directory:  restore.rootPath,
+			LocalPath:  restore.localPath,
+			RemotePath: key,
+			Size:       metadata.Size,
+		})
+	}
+}
+
+// setRestoresPending records the number of requested restores in the
+// statistics
+func (e *Engine) setRestoresPending(count int) {
+	e.mutex.Lock()
+	e.stats.RestoresPending = count
+	archived := e.stats.ArchivedObjects
+	e.mutex.Unlock()
+	e.metrics.RecordArchivedObjects(archived, count)
+}
+
+// recordArchivedObjects counts the listed objects of dir in archival
+// storage classes, reported per directory and in total in the statistics
+func (e *Engine) recordArchivedObjects(dir interfaces.SyncDirectory, remoteFiles []interfaces.FileInfo) {
+	archived := 0
+	for _, file := range remoteFiles {
+		if !file.IsDir && file.Archived() {
+			archived++
+		}
+	}
+
+	e.dirStatusMutex.Lock()
+	status, ok := e.dirStatus[dir.LocalPath]
+	if !ok {
+		status = &interfaces.DirectoryStatus{}
+		e.dirStatus[dir.LocalPath] = status
+	}
+	status.ArchivedObjects = archived
+	total := 0
+	for _, status := range e.dirStatus {
+		total += status.ArchivedObjects
+	}
+	e.dirStatusMutex.Unlock()
+
+	e.mutex.Lock()
+	e.stats.ArchivedObjects = total
+	pending := e.stats.RestoresPending
+	e.mutex.Unlock()
+	e.metrics.RecordArchivedObjects(total, pending)
+}
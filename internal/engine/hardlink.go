@@ -0,0 +1,102 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// copyHardLink stores a hard-linked file by copying the object already
+// uploaded for another link to the same file, so its content crosses the
+// network once. It reports false when the content must be uploaded: the
+// file has no other links, none was uploaded with the current content,
+// or the provider cannot copy objects.
+func (e *Engine) copyHardLink(ctx context.Context, task syncTask) bool {
+	id, links, ok := utils.FileIdentity(task.fileInfo)
+	if !ok || links < 2 {
+		return false
+	}
+	copier, ok := e.provider.(interfaces.CopyProvider)
+	if !ok {
+		return false
+	}
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return false
+	}
+
+	group, ok := store.GetLink(id)
+	if !ok || group.Size != task.fileInfo.Size() || !group.ModTime.Equal(task.fileInfo.ModTime()) {
+		return false
+	}
+	source := group.Keys[0]
+	if source == task.remotePath {
+		return false
+	}
+	if record, ok := store.Get(source); !ok || record.MD5Hash != group.MD5Hash {
+		return false
+	}
+
+	opCtx, cancel := e.operationContext(ctx)
+	err := copier.Copy(opCtx, source, task.remotePath)
+	cancel()
+	if err != nil {
+		e.logger.Warn("Failed to copy hard link, uploading content",
+			zap.String("local_path", task.localPath),
+			zap.String("source", source),
+			errorField(err))
+		return false
+	}
+
+	e.logger.Debug("Copied hard link from uploaded object",
+		zap.String("local_path", task.localPath),
+		zap.String("remote_path", task.remotePath),
+		zap.String("source", source))
+	e.recordUploadState(task, group.Size, group.MD5Hash, false)
+	return true
+}
+
+// recordHardLink adds an uploaded file to the link group of its inode, or
+// removes a stale membership when the file has no other links
+func (e *Engine) recordHardLink(task syncTask, size int64, md5Hash string) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil || task.fileInfo == nil {
+		return
+	}
+
+	id, links, ok := utils.FileIdentity(task.fileInfo)
+	if !ok || links < 2 {
+		store.Unlink(task.remotePath)
+		return
+	}
+	store.PutLink(id, task.remotePath, size, task.fileInfo.ModTime(), md5Hash)
+}
@@ -0,0 +1,266 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// SetHydrationCache sets the total size of hydrated files kept locally
+// before the least recently used are turned back into stubs. Zero keeps
+// hydrated files indefinitely.
+func (e *Engine) SetHydrationCache(maxBytes int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.hydrationCacheSize = maxBytes
+}
+
+// Hydrate downloads the archived file behind a stub in dir and puts it back
+// in place of the stub. path may name either the stub or the original file.
+// Hydrated files count towards the hydration cache and are re-stubbed when
+// it overflows. It returns the path of the hydrated file.
+func (e *Engine) Hydrate(ctx context.Context, dir interfaces.SyncDirectory, path string) (string, error) {
+	start := e.clock.Now()
+	original := strings.TrimSuffix(filepath.Clean(path), StubSuffix)
+	stubPath := original + StubSuffix
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+
+	if _, err := e.fs.Lstat(original); err == nil {
+		if store != nil {
+			if file, ok := store.GetHydrated(original); ok {
+				// Already hydrated, refresh its position in the cache
+				file.AccessedAt = e.clock.Now()
+				store.PutHydrated(file)
+			}
+		}
+		return original, nil
+	}
+
+	stub, err := readStub(e.fs, stubPath)
+	if err != nil {
+		return "", fmt.Errorf("%s is not an archived file: %w", original, err)
+	}
+
+	task := syncTask{
+		localPath:  original,
+		remotePath: stub.Key,
+		rootPath:   dir.LocalPath,
+		operation:  "download",
+		metadata:   interfaces.FileMetadata{Size: stub.Size, MD5Hash: stub.MD5Hash},
+	}
+	if err := e.downloadFile(ctx, task); err != nil {
+		e.metrics.RecordFileOperation("hydrate", e.clock.Now().Sub(start), false)
+		e.recordSyncError(original, "hydrate", err, 0)
+		return "", fmt.Errorf("failed to download archived file: %w", err)
+	}
+
+	if err := e.fs.Chmod(original, stub.Mode); err != nil {
+		e.logger.Warn("Failed to restore file mode",
+			zap.String("path", original),
+			errorField(err))
+	}
+	if err := e.fs.Chtimes(original, e.clock.Now(), stub.ModTime); err != nil {
+		e.logger.Warn("Failed to restore file modification time",
+			zap.String("path", original),
+			errorField(err))
+	}
+	if err := e.fs.Remove(stubPath); err != nil {
+		e.logger.Warn("Failed to remove stub after hydration",
+			zap.String("path", stubPath),
+			errorField(err))
+	}
+
+	if store != nil {
+		now := e.clock.Now()
+		store.PutHydrated(state.HydratedFile{
+			Path:       original,
+			Key:        stub.Key,
+			Size:       stub.Size,
+			MD5Hash:    stub.MD5Hash,
+			ModTime:    stub.ModTime,
+			Mode:       stub.Mode,
+			HydratedAt: now,
+			AccessedAt: now,
+		})
+		if record, ok := store.Get(stub.Key); ok {
+			record.ArchivedAt = time.Time{}
+			store.Put(record)
+		}
+	}
+
+	e.incrementFilesDownloaded(ctx, stub.Size)
+	e.metrics.RecordFileOperation("hydrate", e.clock.Now().Sub(start), true)
+	e.logger.Info("Hydrated archived file",
+		zap.String("local_path", original),
+		zap.String("remote_path", stub.Key),
+		zap.Int64("size", stub.Size),
+		zap.Duration("duration", e.clock.Now().Sub(start)))
+
+	e.evictHydrated(ctx, dir, original)
+	e.saveState()
+	return original, nil
+}
+
+// cachedHydration reports whether a local file is an unmodified member of
+// the hydration cache. Records of files changed since hydration are dropped
+// so the file is treated as ordinary local content from then on.
+func (e *Engine) cachedHydration(path string, info os.FileInfo) bool {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return false
+	}
+
+	file, ok := store.GetHydrated(path)
+	if !ok {
+		return false
+	}
+	if info.Size() != file.Size || !info.ModTime().Equal(file.ModTime) {
+		store.DeleteHydrated(path)
+		return false
+	}
+	return true
+}
+
+// evictHydrated turns the least recently used hydrated files back into
+// stubs until the hydration cache fits its configured size. The file at
+// keep, the one just requested, is never evicted.
+func (e *Engine) evictHydrated(ctx context.Context, dir interfaces.SyncDirectory, keep string) {
+	e.mutex.RLock()
+	store := e.stateStore
+	limit := e.hydrationCacheSize
+	e.mutex.RUnlock()
+	if store == nil || limit <= 0 {
+		return
+	}
+
+	type cachedFile struct {
+		file       state.HydratedFile
+		info       os.FileInfo
+		lastAccess time.Time
+	}
+
+	var cached []cachedFile
+	var total int64
+	for _, file := range store.HydratedFiles() {
+		info, err := e.fs.Lstat(file.Path)
+		if err != nil {
+			store.DeleteHydrated(file.Path)
+			continue
+		}
+		if !e.cachedHydration(file.Path, info) {
+			continue
+		}
+
+		lastAccess := utils.AccessTime(info)
+		if file.AccessedAt.After(lastAccess) {
+			lastAccess = file.AccessedAt
+		}
+		cached = append(cached, cachedFile{file: file, info: info, lastAccess: lastAccess})
+		total += info.Size()
+	}
+
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].lastAccess.Before(cached[j].lastAccess)
+	})
+
+	for _, entry := range cached {
+		if total <= limit {
+			return
+		}
+		if entry.file.Path == keep {
+			continue
+		}
+		owner := dir
+		if !withinDirectory(entry.file.Path, dir.LocalPath) {
+			if found, ok := e.directoryFor(entry.file.Path); ok {
+				owner = found
+			}
+		}
+
+		md5Hash, err := e.confirmUploaded(ctx, owner, entry.file.Path, entry.file.Key, entry.info)
+		if err != nil {
+			e.logger.Warn("Keeping hydrated file, upload not confirmed",
+				zap.String("local_path", entry.file.Path),
+				errorField(err))
+			continue
+		}
+
+		auditEntry := audit.Entry{
+			Action:    "evict",
+			Key:       entry.file.Key,
+			LocalPath: entry.file.Path,
+			Reason:    fmt.Sprintf("hydration cache exceeds %s", utils.FormatBytes(limit)),
+		}
+		if err := e.archiveFile(entry.file.Path, entry.file.Key, md5Hash, entry.info, true); err != nil {
+			auditEntry.SetError(err)
+			e.logger.Error("Failed to evict hydrated file",
+				zap.String("local_path", entry.file.Path),
+				errorField(err))
+		} else {
+			store.DeleteHydrated(entry.file.Path)
+			total -= entry.info.Size()
+			e.logger.Info("Evicted hydrated file",
+				zap.String("local_path", entry.file.Path),
+				zap.Int64("size", entry.info.Size()))
+		}
+		e.audit(ctx, auditEntry)
+	}
+}
+
+// directoryFor returns the configured directory containing path
+func (e *Engine) directoryFor(path string) (interfaces.SyncDirectory, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	var match interfaces.SyncDirectory
+	found := false
+	for _, dir := range e.directories {
+		if withinDirectory(path, dir.LocalPath) && len(dir.LocalPath) > len(match.LocalPath) {
+			match = dir
+			found = true
+		}
+	}
+	return match, found
+}
+
+// withinDirectory reports whether path lies below root
+func withinDirectory(path, root string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
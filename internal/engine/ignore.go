@@ -0,0 +1,345 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// ignoreRecheckInterval is how often ignore files are checked for changes.
+// The file watcher skips hidden files, so edits to .stignore are not seen
+// as events.
+const ignoreRecheckInterval = 10 * time.Second
+
+// ignoreRule is one translated line of an ignore file
+type ignoreRule struct {
+	pattern *regexp.Regexp // matched against slash separated relative paths
+	include bool           // negated rule keeping matching paths
+	dirOnly bool           // matches directories only
+}
+
+// ignoreList holds the rules of a directory's ignore files in the order
+// they were read. The first matching rule decides.
+type ignoreList struct {
+	rules   []ignoreRule
+	sources map[string]time.Time // ignore file to its modification time, zero when missing
+	checked time.Time
+}
+
+// ignoredByFiles reports whether path is excluded by the ignore files of
+// dir. A directory excluded by a rule excludes everything below it.
+func (e *Engine) ignoredByFiles(dir interfaces.SyncDirectory, path string) bool {
+	if len(dir.IgnoreFiles) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(dir.LocalPath, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+
+	list := e.ignoreListFor(dir)
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		if list.excludes(strings.Join(parts[:i+1], "/"), i < len(parts)-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludes applies the first rule matching path
+func (l *ignoreList) excludes(path string, isDir bool) bool {
+	for _, rule := range l.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			return !rule.include
+		}
+	}
+	return false
+}
+
+// ignoreListFor returns the rules of dir's ignore files, reading them again
+// when they changed
+func (e *Engine) ignoreListFor(dir interfaces.SyncDirectory) *ignoreList {
+	e.ignoreMutex.Lock()
+	defer e.ignoreMutex.Unlock()
+
+	now := e.clock.Now()
+	list := e.ignoreLists[dir.LocalPath]
+	if list != nil && now.Sub(list.checked) < ignoreRecheckInterval {
+		return list
+	}
+	if list == nil || e.ignoreFilesChanged(list) {
+		list = e.readIgnoreFiles(dir)
+		e.ignoreLists[dir.LocalPath] = list
+	}
+	list.checked = now
+	return list
+}
+
+// ignoreFilesChanged reports whether an ignore file was created, removed
+// or modified since list was read
+func (e *Engine) ignoreFilesChanged(list *ignoreList) bool {
+	for path, modTime := range list.sources {
+		var current time.Time
+		if info, err := e.fs.Stat(path); err == nil {
+			current = info.ModTime()
+		}
+		if !current.Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// readIgnoreFiles translates the ignore files of dir into rules. Files
+// named .stignore use Syncthing syntax, others rsync filter syntax. Missing
+// files contribute no rules and lines that cannot be translated are logged
+// and skipped.
+func (e *Engine) readIgnoreFiles(dir interfaces.SyncDirectory) *ignoreList {
+	list := &ignoreList{sources: make(map[string]time.Time)}
+	for _, name := range dir.IgnoreFiles {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir.LocalPath, name)
+		}
+
+		info, err := e.fs.Stat(path)
+		if err != nil {
+			list.sources[path] = time.Time{}
+			continue
+		}
+		list.sources[path] = info.ModTime()
+
+		data, err := e.readFile(path)
+		if err != nil {
+			e.logger.Warn("Failed to read ignore file",
+				zap.String("path", path),
+				errorField(err))
+			continue
+		}
+
+		parse := parseRsyncFilter
+		if strings.HasSuffix(filepath.Base(path), ".stignore") {
+			parse = parseStignore
+		}
+		rules, problems := parse(data)
+		for _, problem := range problems {
+			e.logger.Warn("Skipping ignore file line",
+				zap.String("path", path),
+				zap.String("problem", problem))
+		}
+		list.rules = append(list.rules, rules...)
+	}
+
+	e.logger.Info("Loaded ignore files",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("rules", len(list.rules)))
+	return list
+}
+
+// readFile returns the content of a file on the engine's file system
+func (e *Engine) readFile(path string) ([]byte, error) {
+	file, err := e.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// parseStignore translates a Syncthing .stignore file. Patterns are
+// excluded unless prefixed with !, (?i) makes them case insensitive and
+// (?d) is accepted and ignored. #include is not supported.
+func parseStignore(data []byte) ([]ignoreRule, []string) {
+	var rules []ignoreRule
+	var problems []string
+	forEachLine(data, func(number int, line string) {
+		if line == "" || strings.HasPrefix(line, "//") {
+			return
+		}
+		if strings.HasPrefix(line, "#") {
+			problems = append(problems, fmt.Sprintf("line %d: directive %q is not supported", number, line))
+			return
+		}
+
+		include, foldCase := false, false
+		for {
+			switch {
+			case strings.HasPrefix(line, "!"):
+				include, line = true, line[1:]
+				continue
+			case strings.HasPrefix(line, "(?i)"):
+				foldCase, line = true, line[4:]
+				continue
+			case strings.HasPrefix(line, "(?d)"):
+				line = line[4:]
+				continue
+			}
+			break
+		}
+
+		rule, err := newIgnoreRule(line, include, foldCase)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", number, err))
+			return
+		}
+		rules = append(rules, rule)
+	})
+	return rules, problems
+}
+
+// parseRsyncFilter translates an rsync filter or exclude file. Lines are
+// "- pattern", "+ pattern", "exclude pattern", "include pattern" or a bare
+// pattern to exclude; "!" clears the rules read so far. Merge, protect,
+// hide and show rules and rule modifiers are not supported.
+func parseRsyncFilter(data []byte) ([]ignoreRule, []string) {
+	var rules []ignoreRule
+	var problems []string
+	forEachLine(data, func(number int, line string) {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			return
+		}
+		if line == "!" || line == "clear" {
+			rules = nil
+			return
+		}
+
+		include := false
+		token, pattern, hasPattern := strings.Cut(line, " ")
+		switch token {
+		case "-", "exclude":
+		case "+", "include":
+			include = true
+		case ":", ".", "merge", "dir-merge", "P", "protect", "R", "risk", "H", "hide", "S", "show":
+			problems = append(problems, fmt.Sprintf("line %d: %q rules are not supported", number, token))
+			return
+		default:
+			if hasPattern && len(token) > 1 && (token[0] == '-' || token[0] == '+') && strings.Trim(token[1:], "!/,CenprsxwWi") == "" {
+				problems = append(problems, fmt.Sprintf("line %d: rule modifiers in %q are not supported", number, token))
+				return
+			}
+			pattern = line
+		}
+
+		rule, err := newIgnoreRule(strings.TrimSuffix(pattern, "/***"), include, false)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", number, err))
+			return
+		}
+		rules = append(rules, rule)
+	})
+	return rules, problems
+}
+
+// forEachLine calls fn with every line of data, trimmed of surrounding
+// whitespace and numbered from 1
+func forEachLine(data []byte, fn func(number int, line string)) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		fn(number, strings.TrimSpace(scanner.Text()))
+	}
+}
+
+// newIgnoreRule compiles a glob pattern. A leading / anchors the pattern to
+// the directory root, otherwise it matches the last path components at any
+// depth. A trailing / matches directories only. * and ? do not match /,
+// ** matches across directories.
+func newIgnoreRule(pattern string, include, foldCase bool) (ignoreRule, error) {
+	rule := ignoreRule{include: include}
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, fmt.Errorf("empty pattern")
+	}
+
+	var expr strings.Builder
+	if foldCase {
+		expr.WriteString("(?i)")
+	}
+	if anchored {
+		expr.WriteString("^")
+	} else {
+		expr.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return rule, fmt.Errorf("unterminated character class in %q", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			if c == '\\' && i+1 < len(pattern) {
+				i++
+			}
+			r, size := utf8.DecodeRuneInString(pattern[i:])
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			i += size - 1
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return rule, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	rule.pattern = re
+	return rule, nil
+}
@@ -0,0 +1,126 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// SetEventJournal records accepted file events in journal before they are
+// queued. Events still pending when the engine starts are replayed. It
+// must be called before Start.
+func (e *Engine) SetEventJournal(journal *state.Journal) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.journal = journal
+}
+
+// journalEvent durably records an accepted event. Failing to write it
+// still lets the event be queued, it is only not replayed after a crash.
+func (e *Engine) journalEvent(event interfaces.FileEvent) {
+	e.mutex.RLock()
+	journal := e.journal
+	e.mutex.RUnlock()
+	if journal == nil {
+		return
+	}
+
+	detectedAt := event.Timestamp
+	if detectedAt.IsZero() {
+		detectedAt = e.clock.Now()
+	}
+	err := journal.Append(state.JournaledEvent{
+		Path:       event.Path,
+		Operation:  event.Operation,
+		DetectedAt: detectedAt,
+	})
+	if err != nil {
+		e.logger.Warn("Failed to journal file event",
+			zap.String("path", event.Path),
+			errorField(err))
+	}
+}
+
+// eventsHandled marks the journaled events of path detected before an
+// upload started at startedAt as handled. Uploads interrupted by a stop or
+// held until connectivity returns leave them pending.
+func (e *Engine) eventsHandled(ctx context.Context, path string, startedAt time.Time) {
+	e.mutex.RLock()
+	journal := e.journal
+	e.mutex.RUnlock()
+	if journal == nil || ctx.Err() != nil {
+		return
+	}
+
+	e.offlineMutex.Lock()
+	_, offline := e.offlineQueue[path]
+	e.offlineMutex.Unlock()
+	if offline {
+		return
+	}
+
+	if err := journal.Handled(path, startedAt); err != nil {
+		e.logger.Warn("Failed to record handled file event",
+			zap.String("path", path),
+			errorField(err))
+	}
+}
+
+// replayJournal handles the events that were still pending when the
+// engine last stopped, as if they had just been detected
+func (e *Engine) replayJournal(ctx context.Context) {
+	defer e.wg.Done()
+
+	e.mutex.RLock()
+	journal := e.journal
+	e.mutex.RUnlock()
+	if journal == nil {
+		return
+	}
+
+	events := journal.Replay()
+	for _, event := range events {
+		if ctx.Err() != nil {
+			// The journal file still holds every replayed event
+			return
+		}
+		e.processFileEvent(ctx, interfaces.FileEvent{
+			Path:      event.Path,
+			Operation: event.Operation,
+			Timestamp: event.DetectedAt,
+		}, true)
+	}
+	if err := journal.EndReplay(); err != nil {
+		e.logger.Warn("Failed to compact event journal", errorField(err))
+	}
+	if len(events) > 0 {
+		e.logger.Info("Replayed file events pending before restart",
+			zap.Int("events", len(events)),
+			zap.Int("queued", journal.Pending()))
+	}
+}
@@ -0,0 +1,132 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/layout"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// errKeyTooLong is returned for files whose remote key exceeds the key
+// length limit when long keys are not shortened
+var errKeyTooLong = errors.New("remote key too long")
+
+// SetKeyLimit sets the longest remote key and the scheme applied to longer
+// keys (utils.LongKeyHash, utils.LongKeyTruncate or utils.LongKeyFail).
+// Zero disables the limit.
+func (e *Engine) SetKeyLimit(maxBytes int, scheme string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.maxKeyBytes = maxBytes
+	e.longKeys = scheme
+}
+
+// remoteKey returns the remote key of a file in dir, with its name
+// encoded by the directory's key encoding. Keys over the limit are
+// shortened, in which case the full key is returned as well so it can be
+// recorded with the object.
+func (e *Engine) remoteKey(dir interfaces.SyncDirectory, localPath string) (string, string, error) {
+	relativePath, err := utils.EncodeKey(filepath.ToSlash(e.getRelativePath(localPath, dir.LocalPath)), dir.KeyEncoding)
+	if err != nil {
+		return "", "", err
+	}
+	key := filepath.Join(dir.RemotePath, filepath.FromSlash(relativePath))
+	var original string
+	if dir.KeyLayout != "" {
+		laidOut, err := e.layoutKey(dir, localPath, relativePath)
+		if err != nil {
+			return "", "", err
+		}
+		if !strings.Contains(dir.KeyLayout, "{path}") {
+			// The object's metadata keeps the file's path, which the key
+			// no longer shows
+			original = key
+		}
+		key = filepath.Join(dir.RemotePath, filepath.FromSlash(laidOut))
+	}
+
+	e.mutex.RLock()
+	maxBytes := e.maxKeyBytes
+	scheme := e.longKeys
+	e.mutex.RUnlock()
+	if maxBytes <= 0 || len(key) <= maxBytes {
+		return key, original, nil
+	}
+	if original == "" {
+		original = key
+	}
+	if scheme == utils.LongKeyFail {
+		return "", original, fmt.Errorf("%w: %d bytes exceeds the limit of %d", errKeyTooLong, len(key), maxBytes)
+	}
+	return utils.ShortenKey(key, remoteDirPrefix(dir), maxBytes, scheme), original, nil
+}
+
+// layoutKey returns the key of a file below the directory's remote path
+// as placed by its key layout. encoded is the file's relative path with
+// the key encoding applied.
+func (e *Engine) layoutKey(dir interfaces.SyncDirectory, localPath, encoded string) (string, error) {
+	var modTime time.Time
+	if layout.UsesDate(dir.KeyLayout) {
+		info, err := e.fs.Stat(localPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to get modification time for key layout: %w", err)
+		}
+		modTime = info.ModTime()
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("failed to get hostname for key layout: %w", err)
+	}
+
+	relativePath := filepath.ToSlash(e.getRelativePath(localPath, dir.LocalPath))
+	vars := layout.KeyVariables(relativePath, encoded, modTime, hostname, layout.DirectoryID(dir.Name, dir.LocalPath))
+	return layout.Expand(dir.KeyLayout, vars)
+}
+
+// localRelativePath returns the local path, relative to dir, of a path
+// relative to the directory's remote path
+func localRelativePath(dir interfaces.SyncDirectory, remoteRelative string) (string, error) {
+	decoded, err := utils.DecodeKey(filepath.ToSlash(remoteRelative), dir.KeyEncoding)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(decoded), nil
+}
+
+// skipKey reports a file left out of a scan because it has no valid
+// remote key
+func (e *Engine) skipKey(localPath string, err error) {
+	e.logger.Warn("Skipping file without a valid remote key",
+		zap.String("local_path", localPath),
+		errorField(err))
+	e.recordSyncError(localPath, "upload", err, 0)
+}
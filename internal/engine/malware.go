@@ -0,0 +1,188 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// errInfected marks files that were not transferred because they contain
+// malware
+var errInfected = errors.New("malware found")
+
+// MalwareScanning configures malware scans of transferred files
+type MalwareScanning struct {
+	Scanner       interfaces.MalwareScanner // nil disables scanning
+	QuarantineDir string                    // infected local files are moved here
+	Uploads       bool                      // scan files before they are uploaded
+	Downloads     bool                      // scan files after they are downloaded
+	MaxSize       int64                     // larger files are not scanned, 0 = no limit
+}
+
+// SetMalwareScanning sets how transferred files are scanned for malware
+func (e *Engine) SetMalwareScanning(scanning MalwareScanning) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.malware = scanning
+}
+
+// scanUpload scans the content of task's file read from file before it is
+// uploaded and rewinds file. An infected file is moved to the quarantine
+// directory and errInfected returned.
+func (e *Engine) scanUpload(ctx context.Context, task syncTask, file interfaces.File) error {
+	e.mutex.RLock()
+	scanning := e.malware
+	e.mutex.RUnlock()
+	if scanning.Scanner == nil || !scanning.Uploads {
+		return nil
+	}
+
+	err := e.scanFile(ctx, scanning, "upload", task, file, task.fileInfo.Size())
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil && err == nil {
+		return fmt.Errorf("failed to reset file pointer: %w", seekErr)
+	}
+	return err
+}
+
+// scanDownload scans a file after it was downloaded. An infected file is
+// moved to the quarantine directory and errInfected returned; a file that
+// could not be scanned is removed so it is not used unchecked.
+func (e *Engine) scanDownload(ctx context.Context, task syncTask) error {
+	e.mutex.RLock()
+	scanning := e.malware
+	e.mutex.RUnlock()
+	if scanning.Scanner == nil || !scanning.Downloads {
+		return nil
+	}
+
+	file, err := e.fs.Open(task.localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open downloaded file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	err = e.scanFile(ctx, scanning, "download", task, file, info.Size())
+	file.Close()
+	if err != nil && !errors.Is(err, errInfected) {
+		if removeErr := e.fs.Remove(task.localPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			e.logger.Warn("Failed to remove download that could not be scanned",
+				zap.String("local_path", task.localPath),
+				errorField(removeErr))
+		}
+	}
+	return err
+}
+
+// scanFile scans the content read from r, records the outcome in the
+// audit log and quarantines task's local file when it is infected.
+// direction is "upload" or "download".
+func (e *Engine) scanFile(ctx context.Context, scanning MalwareScanning, direction string, task syncTask, r io.Reader, size int64) error {
+	entry := audit.Entry{
+		Action:    "malware_scan",
+		Key:       task.remotePath,
+		LocalPath: task.localPath,
+		Reason:    direction,
+	}
+	if scanning.MaxSize > 0 && size > scanning.MaxSize {
+		e.logger.Debug("File too large for malware scan",
+			zap.String("local_path", task.localPath),
+			zap.Int64("size", size),
+			zap.Int64("max_scan_size", scanning.MaxSize))
+		entry.Result = "skipped"
+		e.audit(ctx, entry)
+		return nil
+	}
+
+	result, err := scanning.Scanner.Scan(ctx, r)
+	if err != nil {
+		return fmt.Errorf("failed to scan for malware: %w", err)
+	}
+	if !result.Infected {
+		entry.Result = "clean"
+		e.audit(ctx, entry)
+		return nil
+	}
+
+	entry.Result = "infected"
+	entry.Signature = result.Signature
+	quarantined, err := e.quarantineInfected(scanning.QuarantineDir, task.localPath)
+	if err != nil {
+		entry.SetError(err)
+		e.logger.Error("Malware found, failed to quarantine file",
+			zap.String("local_path", task.localPath),
+			zap.String("direction", direction),
+			zap.String("signature", result.Signature),
+			errorField(err))
+	} else {
+		entry.QuarantinedTo = quarantined
+		e.logger.Warn("Malware found, file quarantined",
+			zap.String("local_path", task.localPath),
+			zap.String("direction", direction),
+			zap.String("signature", result.Signature),
+			zap.String("quarantined_to", quarantined))
+	}
+	e.audit(ctx, entry)
+	return fmt.Errorf("%w (%s)", errInfected, result.Signature)
+}
+
+// quarantineInfected moves the file at path into dir under a name made
+// unique by the current time, readable by the owner only, and returns
+// its new path
+func (e *Engine) quarantineInfected(dir, path string) (string, error) {
+	if dir == "" {
+		return "", fmt.Errorf("no quarantine directory configured")
+	}
+	if err := e.fs.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	target := filepath.Join(dir, e.clock.Now().UTC().Format("20060102T150405.000000000Z")+"-"+filepath.Base(path))
+	if err := e.fs.Rename(path, target); err != nil {
+		// The quarantine directory may be on another filesystem
+		if err := e.copyToTemp(path, target); err != nil {
+			e.fs.Remove(target)
+			return "", fmt.Errorf("failed to move file to quarantine: %w", err)
+		}
+		if err := e.fs.Remove(path); err != nil {
+			return "", fmt.Errorf("failed to remove quarantined file: %w", err)
+		}
+	}
+	if err := e.fs.Chmod(target, 0600); err != nil {
+		e.logger.Warn("Failed to restrict quarantined file",
+			zap.String("path", target),
+			errorField(err))
+	}
+	return target, nil
+}
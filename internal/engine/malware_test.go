@@ -0,0 +1,95 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"CloudAWSync/internal/interfaces"
+	fakes "CloudAWSync/internal/testing"
+)
+
+// infectedScanner reports content containing "EICAR" as infected
+type infectedScanner struct{}
+
+func (infectedScanner) Scan(ctx context.Context, r io.Reader) (interfaces.ScanResult, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return interfaces.ScanResult{}, err
+	}
+	if bytes.Contains(data, []byte("EICAR")) {
+		return interfaces.ScanResult{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return interfaces.ScanResult{}, nil
+}
+
+func TestInfectedDownloadSkippedUntilObjectChanges(t *testing.T) {
+	te := newTestEngine(t)
+	te.SetMalwareScanning(MalwareScanning{
+		Scanner:       infectedScanner{},
+		QuarantineDir: "/quarantine",
+		Downloads:     true,
+	})
+	ctx := context.Background()
+	te.fs.MkdirAll("/data", 0755)
+
+	download := func(etag string) {
+		t.Helper()
+		te.processDownloadTask(ctx, syncTask{
+			localPath:  "/data/report.pdf",
+			remotePath: "data/report.pdf",
+			rootPath:   "/data",
+			operation:  "download",
+			metadata:   interfaces.FileMetadata{Size: 5},
+			etag:       etag,
+		}, 0)
+	}
+
+	te.provider.Put("data/report.pdf", []byte("EICAR"), te.clock.Now())
+	download("etag-1")
+	if got := te.provider.Calls(fakes.OpDownload); got != 1 {
+		t.Fatalf("downloads = %d, want 1", got)
+	}
+	file, ok := te.store.Quarantined("/data/report.pdf")
+	if !ok || file.ETag != "etag-1" || file.RemotePath != "data/report.pdf" {
+		t.Fatalf("quarantine entry = %+v, %v", file, ok)
+	}
+
+	// The same object is not downloaded again
+	download("etag-1")
+	if got := te.provider.Calls(fakes.OpDownload); got != 1 {
+		t.Errorf("downloads after a repeated scan = %d, want 1", got)
+	}
+
+	// A replaced object is downloaded and scanned again
+	te.provider.Put("data/report.pdf", []byte("EICAR again"), te.clock.Now())
+	download("etag-2")
+	if got := te.provider.Calls(fakes.OpDownload); got != 2 {
+		t.Errorf("downloads after the object changed = %d, want 2", got)
+	}
+	if file, _ := te.store.Quarantined("/data/report.pdf"); file.ETag != "etag-2" {
+		t.Errorf("quarantined ETag = %q, want etag-2", file.ETag)
+	}
+}
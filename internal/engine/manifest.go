@@ -0,0 +1,281 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// manifestPrefix holds one manifest per directory. It lies outside every
+// directory's remote path, so syncs never treat manifests as files.
+const manifestPrefix = ".cloudawsync/manifests"
+
+// manifestCheckInterval is how often synced directories are checked for
+// finished uploads before their manifest is written
+const manifestCheckInterval = 10 * time.Second
+
+// manifestVersion is the format version of DirectoryManifest
+const manifestVersion = 1
+
+// DirectoryManifest lists the remote copies of a directory's files after a
+// sync, letting other agents and restores find them without listing
+type DirectoryManifest struct {
+	Version    int             `json:"version"`
+	Generation string          `json:"generation"` // time of the sync, e.g. 20250102T150405Z
+	CreatedAt  time.Time       `json:"created_at"`
+	LocalPath  string          `json:"local_path"`
+	RemotePath string          `json:"remote_path"`
+	Files      []ManifestEntry `json:"files"` // paths relative to RemotePath
+}
+
+// SetManifests makes the engine write a manifest of each directory once
+// the uploads queued by a sync have finished
+func (e *Engine) SetManifests(enabled bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.manifests = enabled
+}
+
+// manifestKey returns the key of a directory's manifest
+func manifestKey(dir interfaces.SyncDirectory) string {
+	return path.Join(manifestPrefix, strings.Trim(filepath.ToSlash(dir.RemotePath), "/")+".json")
+}
+
+// markManifestStale schedules a new manifest for the directory at root
+func (e *Engine) markManifestStale(root string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.manifests {
+		e.staleManifests[root] = e.clock.Now()
+	}
+}
+
+// manifestWorker writes the manifests of synced directories once none of
+// their uploads are queued or running
+func (e *Engine) manifestWorker(ctx context.Context) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(manifestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			e.writeStaleManifests(ctx)
+		}
+	}
+}
+
+// writeStaleManifests writes the manifest of every directory synced since
+// its last manifest whose uploads have finished
+func (e *Engine) writeStaleManifests(ctx context.Context) {
+	e.mutex.RLock()
+	roots := make(map[string]time.Time, len(e.staleManifests))
+	for root, syncedAt := range e.staleManifests {
+		roots[root] = syncedAt
+	}
+	e.mutex.RUnlock()
+
+	for root, syncedAt := range roots {
+		if e.uploadsPending(root) || e.isOffline() {
+			continue
+		}
+		dir, ok := e.configuredDirectory(root)
+		if !ok {
+			e.mutex.Lock()
+			delete(e.staleManifests, root)
+			e.mutex.Unlock()
+			continue
+		}
+
+		if _, err := e.WriteManifest(ctx, dir, syncedAt); err != nil {
+			e.logger.Error("Failed to write directory manifest",
+				zap.String("local_path", root),
+				errorField(err))
+			continue
+		}
+
+		e.mutex.Lock()
+		if e.staleManifests[root].Equal(syncedAt) {
+			delete(e.staleManifests, root)
+		}
+		e.mutex.Unlock()
+	}
+}
+
+// uploadsPending reports whether uploads of files below root are queued
+// or running
+func (e *Engine) uploadsPending(root string) bool {
+	e.inFlightMutex.Lock()
+	defer e.inFlightMutex.Unlock()
+
+	for localPath := range e.inFlight {
+		if withinDirectory(localPath, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteManifest uploads a manifest of the directory's remote files for the
+// sync at syncedAt. Files are taken from the state store when one is set
+// and from a remote listing otherwise.
+func (e *Engine) WriteManifest(ctx context.Context, dir interfaces.SyncDirectory, syncedAt time.Time) (*DirectoryManifest, error) {
+	manifest := &DirectoryManifest{
+		Version:    manifestVersion,
+		Generation: syncedAt.UTC().Format(generationIDFormat),
+		CreatedAt:  e.clock.Now(),
+		LocalPath:  dir.LocalPath,
+		RemotePath: dir.RemotePath,
+		Files:      []ManifestEntry{},
+	}
+
+	prefix := remoteDirPrefix(dir)
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		for _, record := range store.Records() {
+			if strings.HasPrefix(record.Key, prefix) {
+				manifest.Files = append(manifest.Files, ManifestEntry{
+					Path:    strings.TrimPrefix(record.Key, prefix),
+					Size:    record.Size,
+					ModTime: record.ModTime,
+					MD5Hash: record.MD5Hash,
+				})
+			}
+		}
+	} else {
+		listCtx, cancel := e.listContext(ctx)
+		remoteFiles, err := e.provider.List(listCtx, prefix)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get remote files: %w", err)
+		}
+		e.recordRequests(dir.LocalPath, 0, 0, int64(len(remoteFiles)/1000+1), 0)
+
+		for _, file := range remoteFiles {
+			if !file.IsDir {
+				manifest.Files = append(manifest.Files, ManifestEntry{
+					Path:    strings.TrimPrefix(file.Key, prefix),
+					Size:    file.Size,
+					ModTime: file.ModTime,
+					MD5Hash: file.MD5Hash,
+				})
+			}
+		}
+	}
+	slices.SortFunc(manifest.Files, func(a, b ManifestEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	metadata := interfaces.FileMetadata{
+		Size:        int64(len(data)),
+		ModTime:     manifest.CreatedAt,
+		MD5Hash:     utils.CalculateMD5FromBytes(data),
+		ContentType: "application/json",
+	}
+
+	opCtx, cancel := e.transferContext(ctx, int64(len(data)))
+	defer cancel()
+	if err := e.provider.Upload(opCtx, manifestKey(dir), bytes.NewReader(data), metadata, interfaces.TransferOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 1, 0, 0, 0)
+
+	e.logger.Info("Directory manifest written",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("generation", manifest.Generation),
+		zap.Int("files", len(manifest.Files)))
+	return manifest, nil
+}
+
+// LoadManifest downloads the latest manifest of a directory
+func (e *Engine) LoadManifest(ctx context.Context, dir interfaces.SyncDirectory) (*DirectoryManifest, error) {
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+
+	body, _, err := e.provider.Download(opCtx, manifestKey(dir), interfaces.TransferOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest of %s: %w", dir.LocalPath, err)
+	}
+	defer body.Close()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+
+	var manifest DirectoryManifest
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest of %s: %w", dir.LocalPath, err)
+	}
+	if manifest.Version > manifestVersion {
+		return nil, fmt.Errorf("manifest of %s has unsupported version %d", dir.LocalPath, manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// VerifyManifest compares a local directory against its latest manifest
+// instead of a remote listing, checking that the sync it describes is
+// complete
+func (e *Engine) VerifyManifest(ctx context.Context, dir interfaces.SyncDirectory) (*VerifyReport, error) {
+	start := e.clock.Now()
+	manifest, err := e.LoadManifest(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteFileMap := make(map[string]interfaces.FileInfo, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		key := filepath.Join(dir.RemotePath, entry.Path)
+		remoteFileMap[key] = interfaces.FileInfo{
+			Key:     key,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			MD5Hash: entry.MD5Hash,
+		}
+	}
+
+	report := &VerifyReport{LocalPath: dir.LocalPath, RemotePath: dir.RemotePath, Manifest: manifest.Generation}
+	if err := e.compareRemote(ctx, dir, remoteFileMap, report, start); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
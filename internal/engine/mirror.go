@@ -0,0 +1,72 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// SetMirrorRepair makes the engine ask mirrors to copy the objects some
+// backends missed every interval. A zero interval disables repairs.
+func (e *Engine) SetMirrorRepair(mirrors interfaces.MirrorProvider, interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.mirrors = mirrors
+	e.mirrorRepairInterval = interval
+}
+
+// mirrorWorker repairs mirrored objects on an interval. Repairs are skipped
+// while the storage service is unreachable.
+func (e *Engine) mirrorWorker(ctx context.Context, mirrors interfaces.MirrorProvider, interval time.Duration) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			if e.isOffline() {
+				continue
+			}
+			repaired, err := mirrors.RepairMirrors(ctx)
+			if err != nil {
+				e.logger.Warn("Mirror repair incomplete",
+					zap.Int("repaired", repaired),
+					errorField(err))
+				continue
+			}
+			if repaired > 0 {
+				e.logger.Info("Repaired mirrored objects", zap.Int("repaired", repaired))
+			}
+		}
+	}
+}
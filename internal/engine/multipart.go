@@ -0,0 +1,395 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// multipartPrefix holds the resume records of unfinished multipart
+	// uploads. It lies outside every directory's remote path, so syncs
+	// never treat records as files.
+	multipartPrefix = ".cloudawsync/uploads"
+
+	// minPartSize is the smallest part S3 accepts, except for the last
+	minPartSize = 5 * 1024 * 1024
+
+	// maxPartSize is the largest part S3 accepts
+	maxPartSize = 5 * 1024 * 1024 * 1024
+
+	// maxParts is the most parts an S3 multipart upload may have
+	maxParts = 10000
+
+	// resumeSaveInterval spaces out updates of a resume record's part map
+	// while parts are uploaded
+	resumeSaveInterval = 30 * time.Second
+
+	// multipartStaleAfter is how long a resume record may go without an
+	// update before its upload counts as abandoned, such as by a file that
+	// changed or was deleted, and is aborted
+	multipartStaleAfter = 24 * time.Hour
+
+	// multipartSweepInterval is how often abandoned uploads are looked for
+	multipartSweepInterval = 6 * time.Hour
+
+	// multipartScope is the cost scope for requests on resume records
+	// that belong to no directory
+	multipartScope = "multipart"
+)
+
+// MultipartResume is the resume record of an unfinished multipart upload.
+// It is stored in the bucket under the content's hash and size, so any
+// host uploading the same content to the same key, such as another host
+// sharing a NAS, continues the upload instead of starting over.
+type MultipartResume struct {
+	Key       string                    `json:"key"`
+	UploadID  string                    `json:"upload_id"`
+	Size      int64                     `json:"size"`
+	MD5Hash   string                    `json:"md5"`
+	PartSize  int64                     `json:"part_size"`
+	Parts     []interfaces.UploadedPart `json:"parts"` // sorted by number
+	Host      string                    `json:"host"`  // host that last updated the record
+	UpdatedAt time.Time                 `json:"updated_at"`
+}
+
+// SetMultipart uploads files of at least threshold bytes in parts of
+// partSize when the provider supports multipart uploads. A threshold of
+// zero keeps uploads single-request.
+func (e *Engine) SetMultipart(threshold, partSize int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.multipartThreshold = threshold
+	e.multipartPartSize = partSize
+}
+
+// multipartUploader returns the provider's multipart uploader when a file
+// of size is to be uploaded in parts
+func (e *Engine) multipartUploader(size int64) (interfaces.MultipartProvider, bool) {
+	e.mutex.RLock()
+	threshold := e.multipartThreshold
+	e.mutex.RUnlock()
+	if threshold <= 0 || size < threshold {
+		return nil, false
+	}
+	uploader, ok := e.provider.(interfaces.MultipartProvider)
+	return uploader, ok
+}
+
+// multipartResumeKey returns the key of the resume record of an upload of
+// content with md5Hash and size
+func multipartResumeKey(md5Hash string, size int64) string {
+	return path.Join(multipartPrefix, fmt.Sprintf("%s-%d.json", md5Hash, size))
+}
+
+// partSize returns the part size of a multipart upload of size bytes,
+// grown when needed to stay within the S3 part limits
+func (e *Engine) partSize(size int64) int64 {
+	e.mutex.RLock()
+	partSize := e.multipartPartSize
+	e.mutex.RUnlock()
+	partSize = max(partSize, minPartSize)
+	return max(partSize, (size+maxParts-1)/maxParts)
+}
+
+// uploadMultipart uploads file in parts, continuing an unfinished upload
+// of the same content to the same key that this or another host left
+// behind. The resume record is kept up to date while parts are uploaded
+// and removed once the object is complete.
+func (e *Engine) uploadMultipart(ctx context.Context, uploader interfaces.MultipartProvider, task syncTask, file interfaces.File, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	resumeKey := multipartResumeKey(metadata.MD5Hash, metadata.Size)
+	resume, stored := e.resumeMultipart(ctx, uploader, task, resumeKey, metadata)
+	if resume == nil {
+		uploadID, err := uploader.CreateMultipartUpload(ctx, task.remotePath, metadata)
+		e.recordRequests(task.rootPath, 1, 0, 0, 0)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return e.provider.Upload(ctx, task.remotePath, file, metadata, options)
+		}
+		if err != nil {
+			return err
+		}
+		resume = &MultipartResume{
+			Key:      task.remotePath,
+			UploadID: uploadID,
+			Size:     metadata.Size,
+			MD5Hash:  metadata.MD5Hash,
+			PartSize: e.partSize(metadata.Size),
+		}
+		stored = make(map[int]interfaces.UploadedPart)
+		e.saveResume(ctx, task, resumeKey, resume, stored)
+	}
+
+	buffer := make([]byte, resume.PartSize)
+	lastSaved := e.clock.Now()
+	for offset, number := int64(0), 1; offset < resume.Size; offset, number = offset+resume.PartSize, number+1 {
+		length := min(resume.PartSize, resume.Size-offset)
+		if part, ok := stored[number]; ok && part.Size == length {
+			continue
+		}
+
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek to part %d: %w", number, err)
+		}
+		if _, err := io.ReadFull(file, buffer[:length]); err != nil {
+			return fmt.Errorf("failed to read part %d: %w: %w", number, errUnreadable, err)
+		}
+		etag, err := uploader.UploadPart(ctx, task.remotePath, resume.UploadID, number, bytes.NewReader(buffer[:length]), length, options)
+		e.recordRequests(task.rootPath, 1, 0, 0, 0)
+		if err != nil {
+			// Let the next attempt, here or on another host, continue
+			e.saveResume(context.WithoutCancel(ctx), task, resumeKey, resume, stored)
+			return err
+		}
+		stored[number] = interfaces.UploadedPart{Number: number, ETag: etag, Size: length}
+
+		if now := e.clock.Now(); now.Sub(lastSaved) >= resumeSaveInterval {
+			e.saveResume(ctx, task, resumeKey, resume, stored)
+			lastSaved = now
+		}
+	}
+
+	if err := uploader.CompleteMultipartUpload(ctx, task.remotePath, resume.UploadID, sortedParts(stored)); err != nil {
+		return err
+	}
+	if err := e.provider.Delete(ctx, resumeKey); err != nil {
+		e.logger.Warn("Failed to remove multipart resume record",
+			zap.String("key", resumeKey),
+			errorField(err))
+	}
+	return nil
+}
+
+// resumeMultipart returns the unfinished upload of the same content to
+// task's key recorded at resumeKey, with the parts the storage service
+// holds, or nil when there is none to continue
+func (e *Engine) resumeMultipart(ctx context.Context, uploader interfaces.MultipartProvider, task syncTask, resumeKey string, metadata interfaces.FileMetadata) (*MultipartResume, map[int]interfaces.UploadedPart) {
+	resume, err := e.readResume(ctx, task.rootPath, resumeKey)
+	if err != nil {
+		if !errors.Is(err, interfaces.ErrNotFound) {
+			e.logger.Warn("Ignoring unreadable multipart resume record",
+				zap.String("key", resumeKey),
+				errorField(err))
+		}
+		return nil, nil
+	}
+	if resume.Key != task.remotePath || resume.Size != metadata.Size || resume.MD5Hash != metadata.MD5Hash {
+		// The same content uploaded to another key. This upload's record
+		// replaces it, so abort it unless another host is still at it.
+		if e.clock.Now().Sub(resume.UpdatedAt) >= multipartStaleAfter {
+			e.abortResume(ctx, uploader, resume, "superseded")
+		}
+		return nil, nil
+	}
+	if resume.PartSize != e.partSize(resume.Size) || resume.PartSize > maxPartSize {
+		// Parts of another size cannot be continued, start over
+		e.abortResume(ctx, uploader, resume, "part size changed")
+		return nil, nil
+	}
+
+	// The service's part list is authoritative, the record's part map may
+	// lag behind
+	parts, err := uploader.ListParts(ctx, resume.Key, resume.UploadID)
+	e.recordRequests(task.rootPath, 0, 0, 1, 0)
+	if err != nil {
+		if !errors.Is(err, interfaces.ErrNotFound) {
+			e.logger.Warn("Failed to list parts of unfinished multipart upload",
+				zap.String("remote_path", resume.Key),
+				errorField(err))
+		}
+		return nil, nil
+	}
+	stored := make(map[int]interfaces.UploadedPart, len(parts))
+	var storedBytes int64
+	for _, part := range parts {
+		stored[part.Number] = part
+		storedBytes += part.Size
+	}
+
+	e.logger.Info("Continuing unfinished multipart upload",
+		zap.String("local_path", task.localPath),
+		zap.String("remote_path", resume.Key),
+		zap.String("started_by", resume.Host),
+		zap.Int("parts", len(stored)),
+		zap.Int64("stored_bytes", storedBytes),
+		zap.Int64("size", resume.Size))
+	return resume, stored
+}
+
+// readResume downloads and decodes the resume record at key
+func (e *Engine) readResume(ctx context.Context, scope, key string) (*MultipartResume, error) {
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+	body, _, err := e.provider.Download(opCtx, key, interfaces.TransferOptions{})
+	e.recordRequests(scope, 0, 1, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read multipart resume record: %w", err)
+	}
+	defer body.Close()
+
+	var resume MultipartResume
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&resume); err != nil {
+		return nil, fmt.Errorf("failed to parse multipart resume record: %w", err)
+	}
+	return &resume, nil
+}
+
+// abortResume aborts the upload of a resume record that will not be
+// continued, discarding its stored parts
+func (e *Engine) abortResume(ctx context.Context, uploader interfaces.MultipartProvider, resume *MultipartResume, reason string) {
+	opCtx, cancel := e.operationContext(ctx)
+	// Aborting is a DELETE request, which is not billed
+	err := uploader.AbortMultipartUpload(opCtx, resume.Key, resume.UploadID)
+	cancel()
+	if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+		e.logger.Warn("Failed to abort multipart upload",
+			zap.String("remote_path", resume.Key),
+			zap.String("reason", reason),
+			errorField(err))
+		return
+	}
+	e.logger.Info("Aborted multipart upload",
+		zap.String("remote_path", resume.Key),
+		zap.String("started_by", resume.Host),
+		zap.Time("updated_at", resume.UpdatedAt),
+		zap.String("reason", reason))
+}
+
+// sweepMultipartUploads aborts the uploads of resume records not updated
+// for multipartStaleAfter and removes the records. They are left by files
+// that changed or were deleted before their upload completed.
+func (e *Engine) sweepMultipartUploads(ctx context.Context, uploader interfaces.MultipartProvider) {
+	opCtx, cancel := e.listContext(ctx)
+	records, err := e.provider.List(opCtx, multipartPrefix+"/")
+	cancel()
+	e.recordRequests(multipartScope, 0, 0, 1, 0)
+	if err != nil {
+		e.logger.Warn("Failed to list multipart resume records", errorField(err))
+		return
+	}
+
+	now := e.clock.Now()
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		if record.IsDir || now.Sub(record.ModTime) < multipartStaleAfter {
+			continue
+		}
+		resume, err := e.readResume(ctx, multipartScope, record.Key)
+		switch {
+		case errors.Is(err, interfaces.ErrNotFound):
+			continue
+		case err != nil:
+			e.logger.Warn("Removing unreadable multipart resume record",
+				zap.String("key", record.Key),
+				errorField(err))
+		case now.Sub(resume.UpdatedAt) < multipartStaleAfter:
+			continue
+		default:
+			e.abortResume(ctx, uploader, resume, "abandoned")
+		}
+
+		opCtx, cancel := e.operationContext(ctx)
+		err = e.provider.Delete(opCtx, record.Key)
+		cancel()
+		if err != nil && !errors.Is(err, interfaces.ErrNotFound) {
+			e.logger.Warn("Failed to remove multipart resume record",
+				zap.String("key", record.Key),
+				errorField(err))
+		}
+	}
+}
+
+// multipartSweepWorker looks for abandoned multipart uploads at start and
+// every multipartSweepInterval
+func (e *Engine) multipartSweepWorker(ctx context.Context, uploader interfaces.MultipartProvider) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(multipartSweepInterval)
+	defer ticker.Stop()
+
+	e.sweepMultipartUploads(ctx, uploader)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			e.sweepMultipartUploads(ctx, uploader)
+		}
+	}
+}
+
+// saveResume stores the resume record of an upload with the parts
+// uploaded so far. Failures are logged, they only cost a later attempt
+// the parts uploaded.
+func (e *Engine) saveResume(ctx context.Context, task syncTask, resumeKey string, resume *MultipartResume, stored map[int]interfaces.UploadedPart) {
+	resume.Parts = sortedParts(stored)
+	resume.Host, _ = os.Hostname()
+	resume.UpdatedAt = e.clock.Now().UTC()
+
+	data, err := json.Marshal(resume)
+	if err != nil {
+		e.logger.Warn("Failed to encode multipart resume record", errorField(err))
+		return
+	}
+	metadata := interfaces.FileMetadata{
+		Size:        int64(len(data)),
+		ModTime:     resume.UpdatedAt,
+		MD5Hash:     utils.CalculateMD5FromBytes(data),
+		ContentType: "application/json",
+	}
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+	err = e.provider.Upload(opCtx, resumeKey, bytes.NewReader(data), metadata, interfaces.TransferOptions{})
+	e.recordRequests(task.rootPath, 1, 0, 0, 0)
+	if err != nil {
+		e.logger.Warn("Failed to store multipart resume record",
+			zap.String("key", resumeKey),
+			errorField(err))
+	}
+}
+
+// sortedParts returns the parts of stored sorted by number
+func sortedParts(stored map[int]interfaces.UploadedPart) []interfaces.UploadedPart {
+	parts := make([]interfaces.UploadedPart, 0, len(stored))
+	for _, part := range stored {
+		parts = append(parts, part)
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts
+}
@@ -0,0 +1,302 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// errOffline is returned by syncs started while the storage service is
+// unreachable
+var errOffline = errors.New("storage service unreachable")
+
+// connectivityProbeKey is the object looked up to check whether the
+// storage service is reachable again. It does not need to exist.
+const connectivityProbeKey = ".cloudawsync-connectivity"
+
+// defaultConnectivityCheckInterval is used when no interval is configured
+const defaultConnectivityCheckInterval = 30 * time.Second
+
+// SetConnectivityCheckInterval sets how often the storage service is
+// probed while it is unreachable. It must be called before Start.
+func (e *Engine) SetConnectivityCheckInterval(interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.connectivityCheckInterval = interval
+}
+
+// isConnectivityError reports whether err means the storage service could
+// not be reached at all, as opposed to rejecting the request. An open
+// circuit breaker counts, so uploads are queued until a probe gets
+// through.
+func isConnectivityError(err error) bool {
+	return errors.Is(err, interfaces.ErrTemporaryNetwork) || errors.Is(err, interfaces.ErrCircuitOpen)
+}
+
+// isOffline reports whether the storage service is currently unreachable
+func (e *Engine) isOffline() bool {
+	e.offlineMutex.Lock()
+	defer e.offlineMutex.Unlock()
+	return e.offline
+}
+
+// goOffline switches to the offline state after err showed the storage
+// service is unreachable. Uploads are queued until a probe succeeds.
+func (e *Engine) goOffline(err error) {
+	e.offlineMutex.Lock()
+	if e.offline {
+		e.offlineMutex.Unlock()
+		return
+	}
+	e.offline = true
+	e.offlineSince = e.clock.Now()
+	e.offlineMutex.Unlock()
+
+	e.logger.Warn("Storage service unreachable, queueing changes until connectivity returns",
+		errorField(err))
+	e.recordConnectivity()
+}
+
+// goOnline leaves the offline state, returning how long the outage lasted
+func (e *Engine) goOnline() time.Duration {
+	e.offlineMutex.Lock()
+	outage := e.clock.Now().Sub(e.offlineSince)
+	e.offline = false
+	e.offlineSince = time.Time{}
+	queued := len(e.offlineQueue)
+	e.offlineMutex.Unlock()
+
+	e.logger.Info("Storage service reachable again",
+		zap.Duration("outage", outage),
+		zap.Int("queued_uploads", queued))
+
+	e.mutex.Lock()
+	e.stats.Offline = false
+	e.stats.OfflineQueued = queued
+	e.mutex.Unlock()
+	e.metrics.RecordConnectivity(false, outage, queued)
+	return outage
+}
+
+// recordConnectivity publishes the connectivity state and queue length
+func (e *Engine) recordConnectivity() {
+	e.offlineMutex.Lock()
+	offline := e.offline
+	var outage time.Duration
+	if offline {
+		outage = e.clock.Now().Sub(e.offlineSince)
+	}
+	queued := len(e.offlineQueue)
+	e.offlineMutex.Unlock()
+
+	e.mutex.Lock()
+	e.stats.Offline = offline
+	e.stats.OfflineQueued = queued
+	e.mutex.Unlock()
+	e.metrics.RecordConnectivity(offline, outage, queued)
+}
+
+// queueOffline keeps an upload until connectivity returns. It is also
+// written to the state store, when enabled, so it survives a restart.
+func (e *Engine) queueOffline(task syncTask) {
+	e.offlineMutex.Lock()
+	e.offlineQueue[task.localPath] = task
+	e.offlineMutex.Unlock()
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		store.PutPending(state.PendingUpload{
+			LocalPath:  task.localPath,
+			RemotePath: task.remotePath,
+			RootPath:   task.rootPath,
+			QueuedAt:   e.clock.Now(),
+		})
+	}
+
+	e.logger.Debug("Queued upload until connectivity returns",
+		zap.String("local_path", task.localPath))
+	e.recordConnectivity()
+}
+
+// forgetOffline removes a path from the offline queue once its upload has
+// finished, successfully or with an error unrelated to connectivity
+func (e *Engine) forgetOffline(path string) {
+	e.offlineMutex.Lock()
+	_, queued := e.offlineQueue[path]
+	delete(e.offlineQueue, path)
+	e.offlineMutex.Unlock()
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		store.DeletePending(path)
+	}
+
+	if queued {
+		e.recordConnectivity()
+	}
+}
+
+// loadOfflineQueue restores uploads queued before a restart
+func (e *Engine) loadOfflineQueue() int {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return 0
+	}
+
+	pending := store.PendingUploads()
+	e.offlineMutex.Lock()
+	for _, upload := range pending {
+		e.offlineQueue[upload.LocalPath] = syncTask{
+			localPath:  upload.LocalPath,
+			remotePath: upload.RemotePath,
+			rootPath:   upload.RootPath,
+			operation:  "upload",
+		}
+	}
+	e.offlineMutex.Unlock()
+	return len(pending)
+}
+
+// flushOfflineQueue queues every upload held while offline. Entries stay
+// in the offline queue until their upload finishes.
+func (e *Engine) flushOfflineQueue(ctx context.Context) {
+	e.offlineMutex.Lock()
+	tasks := make([]syncTask, 0, len(e.offlineQueue))
+	for _, task := range e.offlineQueue {
+		tasks = append(tasks, task)
+	}
+	e.offlineMutex.Unlock()
+
+	for _, task := range tasks {
+		info, err := e.fs.Stat(task.localPath)
+		if os.IsNotExist(err) {
+			// Deleted while offline, nothing left to upload
+			e.forgetOffline(task.localPath)
+			continue
+		}
+		if err != nil {
+			e.logger.Warn("Failed to stat queued upload",
+				zap.String("local_path", task.localPath),
+				errorField(err))
+			continue
+		}
+		task.fileInfo = info
+
+		if _, err := e.enqueueUpload(ctx, task, true); err != nil {
+			return
+		}
+	}
+}
+
+// syncAfterOutage syncs every enabled directory to pick up changes whose events
+// were missed while the storage service was unreachable
+func (e *Engine) syncAfterOutage(ctx context.Context) {
+	e.mutex.RLock()
+	var dirs []interfaces.SyncDirectory
+	for _, dir := range e.directories {
+		if dir.Enabled {
+			dirs = append(dirs, dir)
+		}
+	}
+	e.mutex.RUnlock()
+
+	failures := e.SyncDirectories(ctx, dirs, func(ctx context.Context, dir interfaces.SyncDirectory) error {
+		if e.isOffline() {
+			return errOffline
+		}
+		return e.Sync(ctx, dir)
+	})
+	if e.isOffline() {
+		return
+	}
+	for _, dir := range dirs {
+		if err, ok := failures[dir.LocalPath]; ok {
+			e.logger.Error("Reconciliation after outage failed",
+				zap.String("directory", dir.LocalPath),
+				errorField(err))
+		}
+	}
+}
+
+// probeConnectivity checks whether the storage service answers requests
+func (e *Engine) probeConnectivity(ctx context.Context) error {
+	probeCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+
+	_, err := e.provider.Exists(probeCtx, connectivityProbeKey)
+	if isConnectivityError(err) {
+		return fmt.Errorf("failed to reach storage service: %w", err)
+	}
+	return nil
+}
+
+// connectivityWorker flushes uploads restored from a previous run, then
+// probes the storage service while it is unreachable. When it answers
+// again, queued uploads are flushed and every directory is synced.
+func (e *Engine) connectivityWorker(ctx context.Context, interval time.Duration) {
+	defer e.wg.Done()
+
+	if restored := e.loadOfflineQueue(); restored > 0 {
+		e.logger.Info("Resuming uploads queued before restart", zap.Int("count", restored))
+		e.recordConnectivity()
+		e.flushOfflineQueue(ctx)
+	}
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			if !e.isOffline() {
+				continue
+			}
+			if err := e.probeConnectivity(ctx); err != nil {
+				e.logger.Debug("Storage service still unreachable", errorField(err))
+				e.recordConnectivity()
+				continue
+			}
+			e.goOnline()
+			e.flushOfflineQueue(ctx)
+			e.syncAfterOutage(ctx)
+		}
+	}
+}
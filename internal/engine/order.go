@@ -0,0 +1,224 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// errDependencyFailed marks directories skipped because a directory they
+// are synced after failed
+var errDependencyFailed = errors.New("dependency failed")
+
+// SetDirectoryParallelism sets how many directories SyncDirectories syncs
+// at once. Values below 1 sync one directory at a time.
+func (e *Engine) SetDirectoryParallelism(parallelism int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.directoryLimit = parallelism
+}
+
+// SyncDirectories runs sync for each of dirs in the order set by their
+// priority and after settings, and returns the errors by local path.
+// Higher priority tiers finish before the next tier starts. Within a tier
+// a directory starts once the directories it is synced after have
+// finished, including their uploads, with ties broken by config order and
+// at most the configured number of directories running at once.
+// Directories synced after one that failed are skipped.
+func (e *Engine) SyncDirectories(ctx context.Context, dirs []interfaces.SyncDirectory, sync func(context.Context, interfaces.SyncDirectory) error) map[string]error {
+	e.mutex.RLock()
+	limit := max(e.directoryLimit, 1)
+	e.mutex.RUnlock()
+
+	deps := directoryDependencies(dirs)
+	hasDependents := make([]bool, len(dirs))
+	for _, indexes := range deps {
+		for _, j := range indexes {
+			hasDependents[j] = true
+		}
+	}
+
+	type result struct {
+		index int
+		err   error
+	}
+	failures := make(map[string]error)
+	done := make([]bool, len(dirs))
+	tiers := priorityTiers(dirs)
+	for t, pending := range tiers {
+		lastTier := t == len(tiers)-1
+		results := make(chan result)
+		running := 0
+
+		for len(pending) > 0 || running > 0 {
+			progressed := false
+			for k := 0; k < len(pending) && running < limit && ctx.Err() == nil; {
+				i := pending[k]
+				ready, failed := true, ""
+				for _, j := range deps[i] {
+					if !done[j] {
+						ready = false
+					} else if _, ok := failures[dirs[j].LocalPath]; ok && failed == "" {
+						failed = dirs[j].LocalPath
+					}
+				}
+				if failed != "" {
+					failures[dirs[i].LocalPath] = fmt.Errorf("skipped sync of %s after %s: %w", dirs[i].LocalPath, failed, errDependencyFailed)
+					done[i] = true
+					pending = slices.Delete(pending, k, k+1)
+					progressed = true
+					continue
+				}
+				if !ready {
+					k++
+					continue
+				}
+
+				pending = slices.Delete(pending, k, k+1)
+				running++
+				progressed = true
+				drain := hasDependents[i] || !lastTier
+				go func(i int) {
+					err := sync(ctx, dirs[i])
+					if err == nil && drain {
+						err = e.waitDirectoryIdle(ctx, dirs[i].LocalPath)
+					}
+					results <- result{index: i, err: err}
+				}(i)
+			}
+
+			if running == 0 {
+				if len(pending) == 0 {
+					break
+				}
+				if ctx.Err() != nil {
+					for _, i := range pending {
+						failures[dirs[i].LocalPath] = ctx.Err()
+						done[i] = true
+					}
+					break
+				}
+				if !progressed {
+					// Only reachable through a dependency cycle, which
+					// config validation rejects
+					e.logger.Warn("Directory dependency cycle, syncing in config order",
+						zap.Strings("directories", directoryPaths(dirs, pending)))
+					for _, i := range pending {
+						deps[i] = nil
+					}
+				}
+				continue
+			}
+
+			r := <-results
+			running--
+			done[r.index] = true
+			if r.err != nil {
+				failures[dirs[r.index].LocalPath] = r.err
+			}
+		}
+	}
+	return failures
+}
+
+// priorityTiers groups the indexes of dirs by priority, highest first,
+// keeping config order within a tier
+func priorityTiers(dirs []interfaces.SyncDirectory) [][]int {
+	var priorities []int
+	for _, dir := range dirs {
+		if !slices.Contains(priorities, dir.Priority) {
+			priorities = append(priorities, dir.Priority)
+		}
+	}
+	slices.Sort(priorities)
+	slices.Reverse(priorities)
+
+	tiers := make([][]int, len(priorities))
+	for i, dir := range dirs {
+		t := slices.Index(priorities, dir.Priority)
+		tiers[t] = append(tiers[t], i)
+	}
+	return tiers
+}
+
+// directoryDependencies returns for each of dirs the indexes of the
+// directories in dirs it is synced after. References to directories that
+// are not part of dirs or are in a lower priority tier are ignored.
+func directoryDependencies(dirs []interfaces.SyncDirectory) [][]int {
+	deps := make([][]int, len(dirs))
+	for i, dir := range dirs {
+		for _, ref := range dir.After {
+			for j, other := range dirs {
+				if j != i && other.IsReferencedBy(ref) && other.Priority >= dir.Priority && !slices.Contains(deps[i], j) {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// directoryPaths returns the local paths of the dirs at indexes
+func directoryPaths(dirs []interfaces.SyncDirectory, indexes []int) []string {
+	paths := make([]string, len(indexes))
+	for k, i := range indexes {
+		paths[k] = dirs[i].LocalPath
+	}
+	return paths
+}
+
+// waitDirectoryIdle waits until no upload of a file below root is queued
+// or running
+func (e *Engine) waitDirectoryIdle(ctx context.Context, root string) error {
+	ticker := e.clock.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for e.uploadsPendingBelow(root) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C():
+		}
+	}
+	return nil
+}
+
+// uploadsPendingBelow reports whether an upload of a file below root is
+// queued or running
+func (e *Engine) uploadsPendingBelow(root string) bool {
+	e.inFlightMutex.Lock()
+	defer e.inFlightMutex.Unlock()
+
+	for path := range e.inFlight {
+		if withinDirectory(path, root) {
+			return true
+		}
+	}
+	return false
+}
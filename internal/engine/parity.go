@@ -0,0 +1,722 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"github.com/klauspost/reedsolomon"
+	"go.uber.org/zap"
+)
+
+// Parity layout below a backup directory's remote path:
+//
+//	parity/<stripe id>.json   members of a stripe with their sizes and hashes
+//	parity/<stripe id>.<n>    parity shard n of the stripe
+const backupParityPrefix = "parity"
+
+// ParityStripe describes content objects protected by shared parity
+// shards. Members shorter than the shard size are padded with zeros for
+// encoding.
+type ParityStripe struct {
+	ID           string         `json:"id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ParityShards int            `json:"parity_shards"`
+	ShardSize    int64          `json:"shard_size"`
+	Members      []StripeMember `json:"members"`
+	ParityHashes []string       `json:"parity_hashes"` // MD5 of each parity shard
+}
+
+// StripeMember is one content object of a parity stripe
+type StripeMember struct {
+	Key     string `json:"key"` // relative to the directory's remote path
+	Size    int64  `json:"size"`
+	MD5Hash string `json:"md5_hash"`
+}
+
+// ParityReport summarizes a parity check of one directory
+type ParityReport struct {
+	LocalPath     string
+	Stripes       int
+	Protected     int      // content objects in a stripe
+	Unprotected   int      // content objects in no stripe yet
+	Skipped       int      // stripes with archived objects, which cannot be read
+	Damaged       []string // lost or corrupted content and parity objects
+	Repaired      []string
+	Unrecoverable []string // damaged objects of stripes with more losses than parity shards
+}
+
+// OK reports whether every damaged object was repaired
+func (r *ParityReport) OK() bool {
+	return len(r.Damaged) == len(r.Repaired)
+}
+
+// protectContent groups the content objects of a backup directory not yet
+// in a parity stripe into stripes of the directory's parity policy. Stripes
+// with fewer members than the policy allows are rebuilt together with the
+// new objects, and stripes of an earlier policy are rebuilt too. Content is
+// read from sources, mapping content keys to local files, when listed
+// there. It returns the number of stripes written.
+func (e *Engine) protectContent(ctx context.Context, dir interfaces.SyncDirectory, sources map[string]string) (int, error) {
+	policy := dir.Parity
+	stripes, _, err := e.loadStripes(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := e.listBackupObjects(ctx, dir, contentPrefix(dir))
+	if err != nil {
+		return 0, err
+	}
+
+	protected := make(map[string]bool)
+	for _, stripe := range stripes {
+		for _, member := range stripe.Members {
+			protected[member.Key] = true
+		}
+	}
+	var pending []interfaces.FileInfo
+	for _, object := range objects {
+		if !protected[relativeBackupKey(dir, object.Key)] && object.Size > 0 && !object.Archived() {
+			pending = append(pending, object)
+		}
+	}
+
+	// Rebuild partial stripes only when there is something to add to them
+	stored := make(map[string]interfaces.FileInfo, len(objects))
+	for _, object := range objects {
+		stored[relativeBackupKey(dir, object.Key)] = object
+	}
+	var replaced []*ParityStripe
+	for _, stripe := range stripes {
+		outdated := stripe.ParityShards != policy.ParityShards || len(stripe.Members) > policy.DataShards
+		partial := len(stripe.Members) < policy.DataShards && len(pending) > 0
+		if !outdated && !partial {
+			continue
+		}
+		members := make([]interfaces.FileInfo, 0, len(stripe.Members))
+		for _, member := range stripe.Members {
+			object, ok := stored[member.Key]
+			if !ok || object.Archived() {
+				// Leave the stripe for a parity check to repair
+				members = nil
+				break
+			}
+			members = append(members, object)
+		}
+		if members != nil {
+			pending = append(pending, members...)
+			replaced = append(replaced, stripe)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	// Similar sizes in one stripe keep the zero padding small
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Size < pending[j].Size
+	})
+
+	written := 0
+	for len(pending) > 0 {
+		n := min(policy.DataShards, len(pending))
+		group := pending[:n]
+		pending = pending[n:]
+
+		err := e.writeStripe(ctx, dir, group, policy.ParityShards, sources)
+		if err != nil && sources != nil && ctx.Err() == nil {
+			e.logger.Warn("Failed to encode parity from local files, reading stored content",
+				zap.String("local_path", dir.LocalPath),
+				errorField(err))
+			err = e.writeStripe(ctx, dir, group, policy.ParityShards, nil)
+		}
+		if err != nil {
+			return written, err
+		}
+		written++
+	}
+
+	for _, stripe := range replaced {
+		if err := e.deleteStripe(ctx, dir, stripe, "parity stripe rebuilt"); err != nil {
+			return written, err
+		}
+	}
+
+	e.logger.Info("Parity stripes written",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("stripes", written),
+		zap.Int("replaced", len(replaced)))
+	return written, nil
+}
+
+// writeStripe encodes and uploads the parity shards and the description of
+// one stripe
+func (e *Engine) writeStripe(ctx context.Context, dir interfaces.SyncDirectory, group []interfaces.FileInfo, parityShards int, sources map[string]string) error {
+	var shardSize int64
+	for _, object := range group {
+		shardSize = max(shardSize, object.Size)
+	}
+	encoder, err := reedsolomon.NewStream(len(group), parityShards)
+	if err != nil {
+		return fmt.Errorf("failed to create parity encoder: %w", err)
+	}
+
+	readers := make([]io.Reader, len(group))
+	members := make([]*stripeReader, len(group))
+	for i, object := range group {
+		rel := relativeBackupKey(dir, object.Key)
+		body, err := e.openStripeObject(ctx, dir, rel, object.Size, sources[rel])
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		members[i] = newStripeReader(body, object.Size, shardSize)
+		readers[i] = members[i]
+	}
+
+	shards, err := newShardFiles(parityShards)
+	if err != nil {
+		return err
+	}
+	defer shards.remove()
+
+	if err := encoder.Encode(readers, shards.writers()); err != nil {
+		return fmt.Errorf("failed to encode parity: %w", err)
+	}
+
+	stripe := &ParityStripe{
+		CreatedAt:    e.clock.Now().UTC(),
+		ParityShards: parityShards,
+		ShardSize:    shardSize,
+	}
+	for i, object := range group {
+		rel := relativeBackupKey(dir, object.Key)
+		if err := members[i].check(dir, rel); err != nil {
+			return err
+		}
+		stripe.Members = append(stripe.Members, StripeMember{Key: rel, Size: object.Size, MD5Hash: members[i].md5Hash()})
+	}
+	stripe.ID = stripeID(stripe.Members)
+
+	for i := range parityShards {
+		hash, err := shards.upload(ctx, e, dir, i, parityKey(dir, stripe.ID, i), shardSize)
+		if err != nil {
+			return err
+		}
+		stripe.ParityHashes = append(stripe.ParityHashes, hash)
+	}
+
+	// The description is stored last, so a stripe is only used once all its
+	// parity shards exist
+	data, err := json.MarshalIndent(stripe, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode parity stripe: %w", err)
+	}
+	if err := e.uploadBackupObject(ctx, dir, stripeKey(dir, stripe.ID), data); err != nil {
+		return fmt.Errorf("failed to upload parity stripe: %w", err)
+	}
+	return nil
+}
+
+// CheckParity reads every parity stripe of a backup directory, finding
+// lost and corrupted content and parity objects. With repair set, damaged
+// objects of stripes with no more losses than parity shards are rebuilt
+// and uploaded again.
+func (e *Engine) CheckParity(ctx context.Context, dir interfaces.SyncDirectory, repair bool) (*ParityReport, error) {
+	stripes, parityObjects, err := e.loadStripes(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := e.listBackupObjects(ctx, dir, contentPrefix(dir))
+	if err != nil {
+		return nil, err
+	}
+	content := make(map[string]interfaces.FileInfo, len(objects))
+	for _, object := range objects {
+		content[relativeBackupKey(dir, object.Key)] = object
+	}
+
+	report := &ParityReport{LocalPath: dir.LocalPath, Stripes: len(stripes)}
+	protected := make(map[string]bool)
+	for _, stripe := range stripes {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		for _, member := range stripe.Members {
+			protected[member.Key] = true
+		}
+		if err := e.checkStripe(ctx, dir, stripe, content, parityObjects, repair, report); err != nil {
+			return report, fmt.Errorf("failed to check parity stripe %s: %w", stripe.ID, err)
+		}
+	}
+	report.Protected = len(protected)
+	for rel, object := range content {
+		if !protected[rel] && object.Size > 0 {
+			report.Unprotected++
+		}
+	}
+
+	e.logger.Info("Parity checked",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("stripes", report.Stripes),
+		zap.Int("damaged", len(report.Damaged)),
+		zap.Int("repaired", len(report.Repaired)),
+		zap.Int("unrecoverable", len(report.Unrecoverable)))
+	return report, nil
+}
+
+// checkStripe finds the damaged shards of a stripe and repairs them when
+// asked to
+func (e *Engine) checkStripe(ctx context.Context, dir interfaces.SyncDirectory, stripe *ParityStripe, content, parityObjects map[string]interfaces.FileInfo, repair bool, report *ParityReport) error {
+	n := len(stripe.Members)
+	if len(stripe.ParityHashes) != stripe.ParityShards {
+		return fmt.Errorf("stripe lists %d parity hashes for %d shards", len(stripe.ParityHashes), stripe.ParityShards)
+	}
+	keys := make([]string, 0, n+stripe.ParityShards) // relative keys in shard order
+	sizes := make([]int64, 0, n+stripe.ParityShards)
+	hashes := make([]string, 0, n+stripe.ParityShards)
+	lost := make([]bool, n+stripe.ParityShards)
+	for i, member := range stripe.Members {
+		object, ok := content[member.Key]
+		if ok && object.Archived() {
+			report.Skipped++
+			return nil
+		}
+		keys = append(keys, member.Key)
+		sizes = append(sizes, member.Size)
+		hashes = append(hashes, member.MD5Hash)
+		lost[i] = !ok || object.Size != member.Size
+	}
+	for i := range stripe.ParityShards {
+		rel := relativeBackupKey(dir, parityKey(dir, stripe.ID, i))
+		object, ok := parityObjects[rel]
+		if ok && object.Archived() {
+			report.Skipped++
+			return nil
+		}
+		keys = append(keys, rel)
+		sizes = append(sizes, stripe.ShardSize)
+		hashes = append(hashes, stripe.ParityHashes[i])
+		lost[n+i] = !ok || object.Size != stripe.ShardSize
+	}
+
+	// Read every remaining shard to find corruption
+	for i := range keys {
+		if lost[i] {
+			continue
+		}
+		body, err := e.openStripeObject(ctx, dir, keys[i], sizes[i], "")
+		if err != nil {
+			return err
+		}
+		reader := newStripeReader(body, sizes[i], sizes[i])
+		_, err = io.Copy(io.Discard, reader)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", keys[i], err)
+		}
+		lost[i] = reader.read != sizes[i] || reader.md5Hash() != hashes[i]
+	}
+
+	var damaged []string
+	for i, isLost := range lost {
+		if isLost {
+			damaged = append(damaged, backupKey(dir, keys[i]))
+		}
+	}
+	if len(damaged) == 0 {
+		return nil
+	}
+	report.Damaged = append(report.Damaged, damaged...)
+	e.logger.Warn("Parity stripe is damaged",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("stripe", stripe.ID),
+		zap.Strings("objects", damaged))
+
+	if !repair {
+		return nil
+	}
+	if len(damaged) > stripe.ParityShards {
+		report.Unrecoverable = append(report.Unrecoverable, damaged...)
+		return nil
+	}
+	if err := e.repairStripe(ctx, dir, stripe, keys, sizes, hashes, lost); err != nil {
+		e.logger.Error("Failed to repair parity stripe",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("stripe", stripe.ID),
+			errorField(err))
+		report.Unrecoverable = append(report.Unrecoverable, damaged...)
+		return nil
+	}
+	report.Repaired = append(report.Repaired, damaged...)
+	return nil
+}
+
+// repairStripe rebuilds the lost shards of a stripe from the others and
+// uploads them. Rebuilt shards are checked against their recorded hash
+// before they are stored.
+func (e *Engine) repairStripe(ctx context.Context, dir interfaces.SyncDirectory, stripe *ParityStripe, keys []string, sizes []int64, hashes []string, lost []bool) error {
+	encoder, err := reedsolomon.NewStream(len(stripe.Members), stripe.ParityShards)
+	if err != nil {
+		return fmt.Errorf("failed to create parity encoder: %w", err)
+	}
+
+	valid := make([]io.Reader, len(keys))
+	for i := range keys {
+		if lost[i] {
+			continue
+		}
+		body, err := e.openStripeObject(ctx, dir, keys[i], sizes[i], "")
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		valid[i] = newStripeReader(body, sizes[i], stripe.ShardSize)
+	}
+
+	shards, err := newShardFiles(len(keys))
+	if err != nil {
+		return err
+	}
+	defer shards.remove()
+	fill := make([]io.Writer, len(keys))
+	for i := range keys {
+		if lost[i] {
+			fill[i] = shards.writer(i)
+		}
+	}
+	if err := encoder.Reconstruct(valid, fill); err != nil {
+		return fmt.Errorf("failed to reconstruct shards: %w", err)
+	}
+
+	for i := range keys {
+		if !lost[i] {
+			continue
+		}
+		if hash, err := shards.md5Prefix(i, sizes[i]); err != nil {
+			return err
+		} else if hash != hashes[i] {
+			return fmt.Errorf("rebuilt %s does not match its recorded hash", keys[i])
+		}
+		if _, err := shards.upload(ctx, e, dir, i, backupKey(dir, keys[i]), sizes[i]); err != nil {
+			return err
+		}
+		e.logger.Info("Repaired object from parity",
+			zap.String("local_path", dir.LocalPath),
+			zap.String("key", backupKey(dir, keys[i])))
+	}
+	return nil
+}
+
+// dropStripes deletes the parity stripes that include one of the removed
+// content keys. The remaining members are protected again by the next
+// backup.
+func (e *Engine) dropStripes(ctx context.Context, dir interfaces.SyncDirectory, removed map[string]bool) error {
+	if len(removed) == 0 {
+		return nil
+	}
+	stripes, _, err := e.loadStripes(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, stripe := range stripes {
+		for _, member := range stripe.Members {
+			if removed[member.Key] {
+				if err := e.deleteStripe(ctx, dir, stripe, "parity stripe of removed content"); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// deleteStripe deletes the description of a stripe, then its parity shards
+func (e *Engine) deleteStripe(ctx context.Context, dir interfaces.SyncDirectory, stripe *ParityStripe, reason string) error {
+	if err := e.deleteBackupObject(ctx, dir, stripeKey(dir, stripe.ID), reason); err != nil {
+		return err
+	}
+	e.stripeCache.Delete(stripeKey(dir, stripe.ID))
+	for i := range stripe.ParityShards {
+		if err := e.deleteBackupObject(ctx, dir, parityKey(dir, stripe.ID, i), reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadStripes downloads the description of every parity stripe of a
+// directory. It also returns the parity objects by relative key.
+func (e *Engine) loadStripes(ctx context.Context, dir interfaces.SyncDirectory) ([]*ParityStripe, map[string]interfaces.FileInfo, error) {
+	objects, err := e.listBackupObjects(ctx, dir, backupParityPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parityObjects := make(map[string]interfaces.FileInfo, len(objects))
+	var stripes []*ParityStripe
+	for _, object := range objects {
+		parityObjects[relativeBackupKey(dir, object.Key)] = object
+		if !strings.HasSuffix(object.Key, ".json") {
+			continue
+		}
+		// Stripes never change once written
+		if cached, ok := e.stripeCache.Load(object.Key); ok {
+			stripes = append(stripes, cached.(*ParityStripe))
+			continue
+		}
+
+		opCtx, cancel := e.operationContext(ctx)
+		body, _, err := e.provider.Download(opCtx, object.Key, interfaces.TransferOptions{})
+		if err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf("failed to download parity stripe %s: %w", object.Key, err)
+		}
+		var stripe ParityStripe
+		err = json.NewDecoder(body).Decode(&stripe)
+		body.Close()
+		cancel()
+		e.recordRequests(dir.LocalPath, 0, 1, 0, object.Size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode parity stripe %s: %w", object.Key, err)
+		}
+		e.stripeCache.Store(object.Key, &stripe)
+		stripes = append(stripes, &stripe)
+	}
+	return stripes, parityObjects, nil
+}
+
+// openStripeObject opens a stored object of a backup directory, or the
+// local file with the same content when local is set
+func (e *Engine) openStripeObject(ctx context.Context, dir interfaces.SyncDirectory, rel string, size int64, local string) (io.ReadCloser, error) {
+	if local != "" {
+		if file, err := e.fs.Open(local); err == nil {
+			return file, nil
+		}
+	}
+	downloadCtx, cancel := e.transferContext(ctx, size)
+	body, _, err := e.provider.Download(downloadCtx, backupKey(dir, rel), interfaces.TransferOptions{})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to download %s: %w", rel, err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 1, 0, size)
+	return deadlineBody{ReadCloser: body, cancel: cancel}, nil
+}
+
+// deadlineBody is a download body whose request deadline is released when
+// it is closed
+type deadlineBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close closes the body and releases its deadline
+func (b deadlineBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// listBackupObjects lists the objects below a prefix of a backup directory
+func (e *Engine) listBackupObjects(ctx context.Context, dir interfaces.SyncDirectory, prefix string) ([]interfaces.FileInfo, error) {
+	opCtx, cancel := e.listContext(ctx)
+	objects, err := e.provider.List(opCtx, backupKey(dir, prefix)+"/")
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", backupKey(dir, prefix), err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(objects)/1000+1), 0)
+	return objects, nil
+}
+
+// stripeReader reads a stripe member padded with zeros to the shard size,
+// hashing the member's content
+type stripeReader struct {
+	reader io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+	read   int64
+}
+
+// newStripeReader returns a reader of size bytes of body followed by zeros
+// up to shardSize
+func newStripeReader(body io.Reader, size, shardSize int64) *stripeReader {
+	r := &stripeReader{md5: md5.New(), sha256: sha256.New()}
+	content := io.TeeReader(io.LimitReader(body, size), io.MultiWriter(r.md5, r.sha256, (*countWriter)(&r.read)))
+	r.reader = io.MultiReader(content, io.LimitReader(zeros{}, shardSize-size))
+	return r
+}
+
+func (r *stripeReader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// md5Hash returns the hex MD5 of the content read so far
+func (r *stripeReader) md5Hash() string {
+	return hex.EncodeToString(r.md5.Sum(nil))
+}
+
+// check verifies the content read against the hash its key is named by
+func (r *stripeReader) check(dir interfaces.SyncDirectory, rel string) error {
+	want := path.Base(rel)
+	got := r.md5Hash()
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		got = hex.EncodeToString(r.sha256.Sum(nil))
+	}
+	if got != want {
+		return fmt.Errorf("content of %s does not match its key (hash %s)", rel, got)
+	}
+	return nil
+}
+
+// countWriter counts the bytes written to it
+type countWriter int64
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	*c += countWriter(len(p))
+	return len(p), nil
+}
+
+// zeros is an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// shardFiles holds encoded shards in temporary files
+type shardFiles struct {
+	files []*os.File
+}
+
+// newShardFiles creates n temporary shard files
+func newShardFiles(n int) (*shardFiles, error) {
+	s := &shardFiles{files: make([]*os.File, n)}
+	for i := range n {
+		file, err := os.CreateTemp("", "cloudawsync-shard-*")
+		if err != nil {
+			s.remove()
+			return nil, fmt.Errorf("failed to create shard file: %w", err)
+		}
+		s.files[i] = file
+	}
+	return s, nil
+}
+
+// writer returns the writer of shard i
+func (s *shardFiles) writer(i int) io.Writer {
+	return s.files[i]
+}
+
+// writers returns the writers of every shard
+func (s *shardFiles) writers() []io.Writer {
+	writers := make([]io.Writer, len(s.files))
+	for i, file := range s.files {
+		writers[i] = file
+	}
+	return writers
+}
+
+// md5Prefix returns the hex MD5 of the first size bytes of shard i
+func (s *shardFiles) md5Prefix(i int, size int64) (string, error) {
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(s.files[i], 0, size)); err != nil {
+		return "", fmt.Errorf("failed to read shard file: %w", err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// upload stores the first size bytes of shard i at key, returning their
+// hex MD5
+func (s *shardFiles) upload(ctx context.Context, e *Engine, dir interfaces.SyncDirectory, i int, key string, size int64) (string, error) {
+	hash, err := s.md5Prefix(i, size)
+	if err != nil {
+		return "", err
+	}
+	if err := e.uploadBackupContent(ctx, dir, key, size, hash, io.NewSectionReader(s.files[i], 0, size)); err != nil {
+		return "", fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return hash, nil
+}
+
+// remove closes and deletes the shard files
+func (s *shardFiles) remove() {
+	for _, file := range s.files {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}
+}
+
+// stripeID derives the ID of a stripe from its members
+func stripeID(members []StripeMember) string {
+	hasher := sha256.New()
+	for _, member := range members {
+		fmt.Fprintf(hasher, "%s\x00%s\x00", member.Key, member.MD5Hash)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:32]
+}
+
+// contentPrefix returns the prefix holding a backup directory's content
+func contentPrefix(dir interfaces.SyncDirectory) string {
+	if dir.BackupFormat == interfaces.BackupFormatChunked {
+		return chunkPackPrefix
+	}
+	return backupDataPrefix
+}
+
+// relativeBackupKey returns a key relative to the directory's remote path
+func relativeBackupKey(dir interfaces.SyncDirectory, key string) string {
+	if root := backupKey(dir); root != "" {
+		return strings.TrimPrefix(key, root+"/")
+	}
+	return key
+}
+
+// stripeKey returns the key of a stripe's description
+func stripeKey(dir interfaces.SyncDirectory, id string) string {
+	return backupKey(dir, backupParityPrefix, id+".json")
+}
+
+// parityKey returns the key of parity shard i of a stripe
+func parityKey(dir interfaces.SyncDirectory, id string, i int) string {
+	return backupKey(dir, backupParityPrefix, id+"."+strconv.Itoa(i))
+}
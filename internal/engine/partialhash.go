@@ -0,0 +1,109 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// defaultPartialHashSample is how much of each end of a file is hashed
+// when the directory does not set a sample size
+const defaultPartialHashSample = 4 << 20
+
+// partialHashSample returns the bytes hashed at each end of a file of dir
+func partialHashSample(dir interfaces.SyncDirectory) int64 {
+	if dir.PartialHash.SampleSize > 0 {
+		return dir.PartialHash.SampleSize
+	}
+	return defaultPartialHashSample
+}
+
+// partialHash returns the partial hash recorded for a file of dir, or ""
+// when the file is too small, partial hashing is disabled, or the file no
+// longer matches info. The sample size is part of the value, so changing
+// it makes older values inconclusive rather than different.
+func (e *Engine) partialHash(dir interfaces.SyncDirectory, localPath string, info os.FileInfo) string {
+	if dir.PartialHash.MinSize <= 0 || info.Size() < dir.PartialHash.MinSize {
+		return ""
+	}
+	sample := partialHashSample(dir)
+	hash, err := utils.CalculatePartialMD5(localPath, sample)
+	if err != nil {
+		e.logger.Debug("Failed to compute partial hash",
+			zap.String("local_path", localPath),
+			errorField(err))
+		return ""
+	}
+
+	// The file must not have changed since info was taken
+	current, err := e.fs.Stat(localPath)
+	if err != nil || current.Size() != info.Size() || !current.ModTime().Equal(info.ModTime()) {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", sample, hash)
+}
+
+// partialHashUnchanged compares a large local file whose modification time
+// no longer matches its state record by its partial hash. ok is false when
+// that is inconclusive and the file has to be hashed whole. A file found
+// unchanged has its record updated to the new modification time, so later
+// scans skip it on size and time alone.
+func (e *Engine) partialHashUnchanged(dir interfaces.SyncDirectory, localPath string, localInfo os.FileInfo, remoteInfo interfaces.FileInfo) (unchanged, ok bool) {
+	if dir.PartialHash.MinSize <= 0 || localInfo.Size() < dir.PartialHash.MinSize {
+		return false, false
+	}
+	record, found := e.recordedUpload(remoteInfo.Key)
+	if !found || record.Filtered || record.PartialHash == "" || record.Size != localInfo.Size() ||
+		record.Size != remoteInfo.Size || (remoteInfo.MD5Hash != "" && remoteInfo.MD5Hash != record.MD5Hash) {
+		return false, false
+	}
+	if !strings.HasPrefix(record.PartialHash, fmt.Sprintf("%d:", partialHashSample(dir))) {
+		return false, false
+	}
+
+	hash := e.partialHash(dir, localPath, localInfo)
+	if hash == "" {
+		return false, false
+	}
+	if hash != record.PartialHash {
+		return false, true
+	}
+
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		record.ModTime = localInfo.ModTime()
+		store.Put(record)
+	}
+	e.logger.Debug("Large file unchanged by partial hash",
+		zap.String("local_path", localPath),
+		zap.Int64("size", localInfo.Size()))
+	return true, true
+}
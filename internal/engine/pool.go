@@ -0,0 +1,131 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// workerFunc processes tasks until ctx is done, the engine stops or quit
+// is closed
+type workerFunc func(ctx context.Context, workerID int, quit <-chan struct{})
+
+// workerPool runs a resizable set of workers. Removed workers finish their
+// current task before exiting.
+type workerPool struct {
+	run    workerFunc
+	ctx    context.Context
+	wg     *sync.WaitGroup
+	quits  []chan struct{}
+	nextID int
+	mutex  sync.Mutex
+}
+
+// newWorkerPool creates an empty pool running run in each worker
+func newWorkerPool(run workerFunc, wg *sync.WaitGroup) *workerPool {
+	return &workerPool{run: run, wg: wg}
+}
+
+// start records the context workers run under and starts size workers
+func (p *workerPool) start(ctx context.Context, size int) {
+	p.mutex.Lock()
+	p.ctx = ctx
+	p.mutex.Unlock()
+	p.resize(size)
+}
+
+// resize starts or stops workers until size are running
+func (p *workerPool) resize(size int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.ctx == nil {
+		return
+	}
+
+	for len(p.quits) < size {
+		quit := make(chan struct{})
+		p.quits = append(p.quits, quit)
+		p.wg.Add(1)
+		go p.run(p.ctx, p.nextID, quit)
+		p.nextID++
+	}
+	for len(p.quits) > size {
+		last := len(p.quits) - 1
+		close(p.quits[last])
+		p.quits = p.quits[:last]
+	}
+}
+
+// size returns the number of running workers
+func (p *workerPool) size() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.quits)
+}
+
+// SetConcurrency changes the number of upload and download workers. It
+// takes effect immediately on a running engine; workers being removed
+// finish their current transfer first.
+func (e *Engine) SetConcurrency(uploads, downloads int) error {
+	if uploads < 1 || downloads < 1 {
+		return fmt.Errorf("concurrency must be at least 1 (uploads %d, downloads %d)", uploads, downloads)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.maxConcurrentUploads = uploads
+	e.maxConcurrentDownloads = downloads
+
+	// With adaptive concurrency the new values are ceilings
+	if e.uploadAIMD != nil {
+		e.uploadAIMD.setMax(uploads)
+	}
+	if e.downloadAIMD != nil {
+		e.downloadAIMD.setMax(downloads)
+	}
+
+	if e.running {
+		uploadWorkers, downloadWorkers := e.workerTargets()
+		e.uploadPool.resize(uploadWorkers)
+		e.downloadPool.resize(downloadWorkers)
+		e.metrics.RecordConcurrency("upload", uploadWorkers)
+		e.metrics.RecordConcurrency("download", downloadWorkers)
+	}
+
+	e.logger.Info("Transfer concurrency updated",
+		zap.Int("max_concurrent_uploads", uploads),
+		zap.Int("max_concurrent_downloads", downloads))
+	return nil
+}
+
+// Concurrency returns the configured number of upload and download workers
+func (e *Engine) Concurrency() (uploads, downloads int) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.maxConcurrentUploads, e.maxConcurrentDownloads
+}
@@ -0,0 +1,301 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// SetQuarantine quarantines files whose upload fails after consecutive
+// times. Quarantined files are skipped by scans and change events until
+// expiry has passed, the file changes or the entry is cleared. A zero
+// after disables quarantining and a zero expiry keeps files quarantined
+// until cleared.
+func (e *Engine) SetQuarantine(after int, expiry time.Duration) {
+	e.quarantineMutex.Lock()
+	defer e.quarantineMutex.Unlock()
+	e.quarantineAfter = after
+	e.quarantineExpiry = expiry
+}
+
+// recordUploadFailure counts a failed upload of a file, quarantining it
+// once it has failed too often in a row
+func (e *Engine) recordUploadFailure(ctx context.Context, task syncTask, err error) {
+	if interfaces.IsTransient(err) || errors.Is(err, interfaces.ErrQuotaExceeded) {
+		// Failures of the whole backend say nothing about the file
+		return
+	}
+	e.quarantineMutex.Lock()
+	if e.quarantineAfter <= 0 {
+		e.quarantineMutex.Unlock()
+		return
+	}
+	e.uploadFailures[task.localPath]++
+	failures := e.uploadFailures[task.localPath]
+	if failures < e.quarantineAfter {
+		e.quarantineMutex.Unlock()
+		return
+	}
+	delete(e.uploadFailures, task.localPath)
+
+	now := e.clock.Now()
+	file := state.QuarantinedFile{
+		LocalPath:     task.localPath,
+		Reason:        err.Error(),
+		Failures:      failures,
+		Size:          task.fileInfo.Size(),
+		ModTime:       task.fileInfo.ModTime(),
+		QuarantinedAt: now,
+	}
+	if e.quarantineExpiry > 0 {
+		file.RetryAfter = now.Add(e.quarantineExpiry)
+	}
+	e.quarantineMutex.Unlock()
+
+	e.putQuarantined(file)
+
+	fields := []zap.Field{
+		zap.String("local_path", task.localPath),
+		zap.Int("failures", failures),
+		zap.String("sync_id", task.syncID),
+		errorField(err),
+	}
+	if !file.RetryAfter.IsZero() {
+		fields = append(fields, zap.Time("retry_after", file.RetryAfter))
+	}
+	e.logger.Warn("Quarantined file after repeated upload failures", fields...)
+	entry := audit.Entry{
+		Action:    "skip",
+		LocalPath: task.localPath,
+		Reason:    "quarantined",
+	}
+	entry.SetError(err)
+	e.audit(ctx, entry)
+}
+
+// recordUploadSuccess resets the failure count of an uploaded file
+func (e *Engine) recordUploadSuccess(path string) {
+	e.quarantineMutex.Lock()
+	delete(e.uploadFailures, path)
+	e.quarantineMutex.Unlock()
+}
+
+// isQuarantined reports whether the upload of task must be skipped. An
+// entry whose retry time has passed, or whose file has changed since it was
+// quarantined, is released so the upload is attempted again; a file
+// released on expiry returns to quarantine on its next failure.
+func (e *Engine) isQuarantined(task syncTask) bool {
+	file, ok := e.getQuarantined(task.localPath)
+	if !ok || file.ETag != "" {
+		return false
+	}
+
+	reason := ""
+	switch {
+	case !file.RetryAfter.IsZero() && !e.clock.Now().Before(file.RetryAfter):
+		reason = "expired"
+		e.quarantineMutex.Lock()
+		e.uploadFailures[task.localPath] = file.Failures
+		e.quarantineMutex.Unlock()
+	case task.fileInfo != nil && (task.fileInfo.Size() != file.Size || !task.fileInfo.ModTime().Equal(file.ModTime)):
+		reason = "changed"
+	default:
+		e.logger.Debug("Skipping quarantined file",
+			zap.String("local_path", task.localPath),
+			zap.String("reason", file.Reason))
+		return true
+	}
+
+	e.deleteQuarantined(task.localPath)
+	e.logger.Info("Retrying quarantined file",
+		zap.String("local_path", task.localPath),
+		zap.String("reason", reason))
+	return false
+}
+
+// quarantineDownload records the object of a download found infected, so
+// that it is not downloaded again until it changes. Objects without a
+// listed ETag are downloaded and scanned again.
+func (e *Engine) quarantineDownload(task syncTask, err error) {
+	if task.etag == "" {
+		return
+	}
+	e.putQuarantined(state.QuarantinedFile{
+		LocalPath:     task.localPath,
+		RemotePath:    task.remotePath,
+		ETag:          task.etag,
+		Reason:        err.Error(),
+		Failures:      1,
+		Size:          task.metadata.Size,
+		ModTime:       task.metadata.ModTime,
+		QuarantinedAt: e.clock.Now(),
+	})
+	e.logger.Warn("Quarantined infected remote object until it changes",
+		zap.String("local_path", task.localPath),
+		zap.String("remote_path", task.remotePath),
+		zap.String("etag", task.etag))
+}
+
+// isInfectedDownload reports whether the download of task must be skipped
+// because its object was found infected. The entry is released when the
+// object has changed since.
+func (e *Engine) isInfectedDownload(task syncTask) bool {
+	file, ok := e.getQuarantined(task.localPath)
+	if !ok || file.ETag == "" || task.etag == "" {
+		return false
+	}
+	if file.ETag == task.etag && file.RemotePath == task.remotePath {
+		e.logger.Debug("Skipping download of infected object",
+			zap.String("remote_path", task.remotePath),
+			zap.String("reason", file.Reason))
+		return true
+	}
+
+	e.deleteQuarantined(task.localPath)
+	e.logger.Info("Downloading changed object that was infected",
+		zap.String("local_path", task.localPath),
+		zap.String("remote_path", task.remotePath))
+	return false
+}
+
+// Quarantined returns every quarantined file sorted by local path
+func (e *Engine) Quarantined() []state.QuarantinedFile {
+	if store := e.quarantineStore(); store != nil {
+		return store.QuarantinedFiles()
+	}
+
+	e.quarantineMutex.Lock()
+	defer e.quarantineMutex.Unlock()
+
+	files := make([]state.QuarantinedFile, 0, len(e.quarantine))
+	for _, file := range e.quarantine {
+		files = append(files, *file)
+	}
+	slices.SortFunc(files, func(a, b state.QuarantinedFile) int {
+		return strings.Compare(a.LocalPath, b.LocalPath)
+	})
+	return files
+}
+
+// ClearQuarantine releases the given local paths from quarantine, or every
+// quarantined file when none are given, and returns the number released.
+// Released files are uploaded again on the next scan or change event.
+func (e *Engine) ClearQuarantine(paths []string) int {
+	if len(paths) == 0 {
+		for _, file := range e.Quarantined() {
+			paths = append(paths, file.LocalPath)
+		}
+	}
+
+	cleared := 0
+	for _, path := range paths {
+		e.quarantineMutex.Lock()
+		delete(e.uploadFailures, path)
+		e.quarantineMutex.Unlock()
+		if e.deleteQuarantined(path) {
+			cleared++
+		}
+	}
+	if cleared > 0 {
+		e.logger.Info("Cleared quarantined files", zap.Int("count", cleared))
+	}
+	return cleared
+}
+
+// forgetQuarantine drops the failure counts and quarantine entries of the
+// files below root
+func (e *Engine) forgetQuarantine(root string) {
+	e.quarantineMutex.Lock()
+	for path := range e.uploadFailures {
+		if withinDirectory(path, root) {
+			delete(e.uploadFailures, path)
+		}
+	}
+	e.quarantineMutex.Unlock()
+
+	for _, file := range e.Quarantined() {
+		if withinDirectory(file.LocalPath, root) {
+			e.deleteQuarantined(file.LocalPath)
+		}
+	}
+}
+
+// quarantineStore returns the state store holding the quarantine, or nil
+// when entries are kept in memory
+func (e *Engine) quarantineStore() *state.Store {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.stateStore
+}
+
+// getQuarantined returns the quarantine entry of a local path
+func (e *Engine) getQuarantined(path string) (state.QuarantinedFile, bool) {
+	if store := e.quarantineStore(); store != nil {
+		return store.Quarantined(path)
+	}
+
+	e.quarantineMutex.Lock()
+	defer e.quarantineMutex.Unlock()
+	file, ok := e.quarantine[path]
+	if !ok {
+		return state.QuarantinedFile{}, false
+	}
+	return *file, true
+}
+
+// putQuarantined stores a quarantine entry
+func (e *Engine) putQuarantined(file state.QuarantinedFile) {
+	if store := e.quarantineStore(); store != nil {
+		store.PutQuarantined(file)
+		return
+	}
+
+	e.quarantineMutex.Lock()
+	defer e.quarantineMutex.Unlock()
+	e.quarantine[file.LocalPath] = &file
+}
+
+// deleteQuarantined removes a quarantine entry, reporting whether one
+// existed
+func (e *Engine) deleteQuarantined(path string) bool {
+	if store := e.quarantineStore(); store != nil {
+		return store.DeleteQuarantined(path)
+	}
+
+	e.quarantineMutex.Lock()
+	defer e.quarantineMutex.Unlock()
+	if _, ok := e.quarantine[path]; !ok {
+		return false
+	}
+	delete(e.quarantine, path)
+	return true
+}
@@ -0,0 +1,273 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// errQuotaExceeded is returned when an upload would exceed a storage quota
+var errQuotaExceeded = errors.New("remote storage quota exceeded")
+
+// globalQuotaScope is the usage scope covering the whole remote prefix
+const globalQuotaScope = "global"
+
+// quotaState tracks remote usage and limits for a scope
+type quotaState struct {
+	usage      interfaces.StorageUsage
+	maxBytes   int64
+	maxObjects int64
+	exceeded   bool
+}
+
+// SetQuota configures a global remote storage quota. Usage is refreshed
+// from the provider every checkInterval. Zero limits disable the quota.
+func (e *Engine) SetQuota(maxBytes, maxObjects int64, checkInterval time.Duration) {
+	e.quotaMutex.Lock()
+	defer e.quotaMutex.Unlock()
+
+	if maxBytes <= 0 && maxObjects <= 0 {
+		delete(e.quotas, globalQuotaScope)
+		return
+	}
+
+	state := e.quotaFor(globalQuotaScope)
+	state.maxBytes = maxBytes
+	state.maxObjects = maxObjects
+	e.quotaCheckInterval = checkInterval
+}
+
+// quotaFor returns the quota state for a scope, creating it if needed.
+// The caller must hold quotaMutex.
+func (e *Engine) quotaFor(scope string) *quotaState {
+	state, ok := e.quotas[scope]
+	if !ok {
+		state = &quotaState{}
+		e.quotas[scope] = state
+	}
+	return state
+}
+
+// updateDirectoryUsage records the remote usage of a directory computed from
+// a full listing and applies the directory's configured limits
+func (e *Engine) updateDirectoryUsage(dir interfaces.SyncDirectory, remoteFiles []interfaces.FileInfo) {
+	var usage interfaces.StorageUsage
+	for _, file := range remoteFiles {
+		if file.IsDir {
+			continue
+		}
+		usage.Bytes += file.Size
+		usage.Objects++
+	}
+
+	e.recordStorageCost(dir.LocalPath, usage.Bytes)
+
+	e.quotaMutex.Lock()
+	state := e.quotaFor(dir.LocalPath)
+	state.usage = usage
+	state.maxBytes = dir.QuotaBytes
+	state.maxObjects = dir.QuotaObjects
+	e.evaluateQuota(dir.LocalPath, state)
+	e.quotaMutex.Unlock()
+}
+
+// refreshGlobalUsage queries the provider for total remote usage
+func (e *Engine) refreshGlobalUsage(ctx context.Context) {
+	e.quotaMutex.Lock()
+	_, enabled := e.quotas[globalQuotaScope]
+	e.quotaMutex.Unlock()
+	if !enabled {
+		return
+	}
+
+	opCtx, cancel := e.listContext(ctx)
+	usage, err := e.provider.StorageUsage(opCtx, "")
+	cancel()
+	if err != nil {
+		e.logger.Error("Failed to refresh remote storage usage", errorField(err))
+		return
+	}
+
+	e.quotaMutex.Lock()
+	if state, ok := e.quotas[globalQuotaScope]; ok {
+		state.usage = usage
+		e.evaluateQuota(globalQuotaScope, state)
+	}
+	e.quotaMutex.Unlock()
+}
+
+// quotaWorker periodically refreshes global usage from the provider
+func (e *Engine) quotaWorker(ctx context.Context, interval time.Duration) {
+	defer e.wg.Done()
+
+	e.refreshGlobalUsage(ctx)
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			e.refreshGlobalUsage(ctx)
+		}
+	}
+}
+
+// checkQuota reports whether an upload of the given size fits within the
+// global quota and the quota of the task's directory
+func (e *Engine) checkQuota(task syncTask, size int64) error {
+	e.quotaMutex.Lock()
+	defer e.quotaMutex.Unlock()
+
+	for _, scope := range []string{globalQuotaScope, task.rootPath} {
+		state, ok := e.quotas[scope]
+		if !ok {
+			continue
+		}
+
+		delta, objects := e.uploadDelta(task, size)
+		if state.maxBytes > 0 && state.usage.Bytes+delta > state.maxBytes {
+			return fmt.Errorf("%w for %s: %d of %d bytes used", errQuotaExceeded, scope, state.usage.Bytes, state.maxBytes)
+		}
+		if state.maxObjects > 0 && state.usage.Objects+objects > state.maxObjects {
+			return fmt.Errorf("%w for %s: %d of %d objects used", errQuotaExceeded, scope, state.usage.Objects, state.maxObjects)
+		}
+	}
+	return nil
+}
+
+// recordUploadUsage adds a completed upload to the tracked usage
+func (e *Engine) recordUploadUsage(task syncTask, size int64) {
+	e.quotaMutex.Lock()
+	defer e.quotaMutex.Unlock()
+
+	delta, objects := e.uploadDelta(task, size)
+	for _, scope := range []string{globalQuotaScope, task.rootPath} {
+		if state, ok := e.quotas[scope]; ok {
+			state.usage.Bytes += delta
+			state.usage.Objects += objects
+			e.evaluateQuota(scope, state)
+		}
+	}
+}
+
+// withReplacedSize fills in the size of the object an upload replaces
+// from the state record of its last upload, for tasks queued without
+// remote metadata such as those of change events. It must run before the
+// upload, which updates the record.
+func (e *Engine) withReplacedSize(task syncTask) syncTask {
+	if task.remoteExists {
+		return task
+	}
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return task
+	}
+	if record, ok := store.Get(task.remotePath); ok {
+		task.remoteExists = true
+		task.metadata.Size = record.Size
+	}
+	return task
+}
+
+// uploadDelta estimates the change in usage caused by uploading a task.
+// Uploads of unknown remote state are counted as new objects.
+func (e *Engine) uploadDelta(task syncTask, size int64) (int64, int64) {
+	if task.remoteExists {
+		return size - task.metadata.Size, 0
+	}
+	return size, 1
+}
+
+// evaluateQuota updates the exceeded flag for a scope, logging transitions.
+// The caller must hold quotaMutex.
+func (e *Engine) evaluateQuota(scope string, state *quotaState) {
+	exceeded := (state.maxBytes > 0 && state.usage.Bytes >= state.maxBytes) ||
+		(state.maxObjects > 0 && state.usage.Objects >= state.maxObjects)
+
+	if exceeded && !state.exceeded {
+		e.logger.Error("Remote storage quota reached, uploads paused",
+			zap.String("scope", scope),
+			zap.Int64("bytes", state.usage.Bytes),
+			zap.Int64("max_bytes", state.maxBytes),
+			zap.Int64("objects", state.usage.Objects),
+			zap.Int64("max_objects", state.maxObjects))
+	} else if !exceeded && state.exceeded {
+		e.logger.Info("Remote storage usage back under quota",
+			zap.String("scope", scope))
+	}
+	state.exceeded = exceeded
+
+	e.metrics.RecordStorageUsage(scope, state.usage, exceeded)
+
+	anyExceeded := false
+	for _, s := range e.quotas {
+		anyExceeded = anyExceeded || s.exceeded
+	}
+	e.mutex.Lock()
+	e.stats.QuotaExceeded = anyExceeded
+	e.mutex.Unlock()
+}
+
+// forgetQuota drops the usage and limits tracked for a scope
+func (e *Engine) forgetQuota(scope string) {
+	e.quotaMutex.Lock()
+	defer e.quotaMutex.Unlock()
+
+	if _, ok := e.quotas[scope]; !ok {
+		return
+	}
+	delete(e.quotas, scope)
+
+	anyExceeded := false
+	for _, s := range e.quotas {
+		anyExceeded = anyExceeded || s.exceeded
+	}
+	e.mutex.Lock()
+	e.stats.QuotaExceeded = anyExceeded
+	e.mutex.Unlock()
+}
+
+// GetStorageUsage returns the tracked remote usage per scope
+func (e *Engine) GetStorageUsage() map[string]interfaces.StorageUsage {
+	e.quotaMutex.Lock()
+	defer e.quotaMutex.Unlock()
+
+	result := make(map[string]interfaces.StorageUsage, len(e.quotas))
+	for scope, state := range e.quotas {
+		result[scope] = state.usage
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestEventUploadUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous []byte // uploaded before the change event, nil for none
+		want     interfaces.StorageUsage
+	}{
+		{name: "new file", want: interfaces.StorageUsage{Bytes: 110, Objects: 11}},
+		{name: "replaced file", previous: []byte("old"), want: interfaces.StorageUsage{Bytes: 107, Objects: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := newTestEngine(t)
+			te.SetQuota(1000, 100, 0)
+			te.quotaMutex.Lock()
+			te.quotas[globalQuotaScope].usage = interfaces.StorageUsage{Bytes: 100, Objects: 10}
+			te.quotaMutex.Unlock()
+
+			if tt.previous != nil {
+				te.putUploaded("data/report.txt", "/data/report.txt", tt.previous)
+			}
+			te.fs.WriteFile("/data/report.txt", []byte("new report"), te.clock.Now())
+			info, err := te.fs.Stat("/data/report.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Change events queue uploads without remote metadata
+			te.processUploadTask(context.Background(), syncTask{
+				localPath:  "/data/report.txt",
+				remotePath: "data/report.txt",
+				rootPath:   "/data",
+				operation:  "upload",
+				fileInfo:   info,
+			}, 0)
+
+			if got := te.GetStorageUsage()[globalQuotaScope]; got != tt.want {
+				t.Errorf("usage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
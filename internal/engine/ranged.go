@@ -0,0 +1,187 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// SetRangedDownloads splits downloads larger than chunkSize into ranged
+// requests when the provider supports them, fetching up to parallelism
+// parts of a file at once. A chunk size of zero or a parallelism below two
+// keeps downloads single-stream.
+func (e *Engine) SetRangedDownloads(chunkSize int64, parallelism int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.downloadChunkSize = chunkSize
+	e.downloadParallelism = parallelism
+}
+
+// openDownload starts downloading task's object, in parallel parts when it
+// is large enough and the provider supports ranged requests. The returned
+// body yields the content in order either way.
+func (e *Engine) openDownload(ctx context.Context, task syncTask, expectedSize int64, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	e.mutex.RLock()
+	chunkSize := e.downloadChunkSize
+	parallelism := e.resourceScaled(e.downloadParallelism)
+	e.mutex.RUnlock()
+
+	ranger, ok := e.provider.(interfaces.RangeProvider)
+	if !ok || chunkSize <= 0 || parallelism < 2 || expectedSize <= chunkSize {
+		return e.provider.Download(ctx, task.remotePath, options)
+	}
+
+	metadata, err := e.provider.GetMetadata(ctx, task.remotePath)
+	if err != nil {
+		return nil, interfaces.FileMetadata{}, fmt.Errorf("failed to get metadata: %w", err)
+	}
+	if metadata.Archived {
+		return nil, metadata, interfaces.ErrObjectArchived
+	}
+	if metadata.Size <= chunkSize {
+		return e.provider.Download(ctx, task.remotePath, options)
+	}
+
+	parts := (metadata.Size + chunkSize - 1) / chunkSize
+	e.logger.Debug("Downloading in parts",
+		zap.String("remote_path", task.remotePath),
+		zap.Int64("size", metadata.Size),
+		zap.Int64("parts", parts),
+		zap.Int("parallelism", parallelism))
+	e.recordRequests(task.rootPath, 0, parts, 0, 0)
+
+	reader, writer := io.Pipe()
+	go e.downloadParts(ctx, ranger, task.remotePath, metadata.Size, chunkSize, parallelism, options, writer)
+	return reader, metadata, nil
+}
+
+// partResult is a downloaded part of an object, or the error that ended
+// its retries
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// downloadParts fetches an object in chunkSize ranges, up to parallelism at
+// once, and writes them to writer in order. At most parallelism parts are
+// held in memory. Closing the reading side stops the download.
+func (e *Engine) downloadParts(ctx context.Context, ranger interfaces.RangeProvider, key string, size, chunkSize int64, parallelism int, options interfaces.TransferOptions, writer *io.PipeWriter) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each slot is taken before a part starts and released once the part
+	// is written, so the parts in flight stay within parallelism
+	slots := make(chan struct{}, parallelism)
+	results := make(chan chan partResult, parallelism)
+
+	go func() {
+		defer close(results)
+		for offset := int64(0); offset < size; offset += chunkSize {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			result := make(chan partResult, 1)
+			results <- result
+			length := min(chunkSize, size-offset)
+			go func() {
+				data, err := e.downloadPart(ctx, ranger, key, offset, length, options)
+				result <- partResult{data: data, err: err}
+			}()
+		}
+	}()
+
+	for result := range results {
+		part := <-result
+		if part.err != nil {
+			writer.CloseWithError(part.err)
+			return
+		}
+		if _, err := writer.Write(part.data); err != nil {
+			// The reader was closed
+			return
+		}
+		<-slots
+	}
+	if err := ctx.Err(); err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+	writer.Close()
+}
+
+// downloadPart fetches one range of an object, retrying failures with the
+// engine's retry settings. Progress of failed attempts is reported too, as
+// those bytes were transferred.
+func (e *Engine) downloadPart(ctx context.Context, ranger interfaces.RangeProvider, key string, offset, length int64, options interfaces.TransferOptions) ([]byte, error) {
+	var err error
+	for attempt := 0; attempt <= e.retryAttempts; attempt++ {
+		if attempt > 0 {
+			e.logger.Warn("Retrying download part",
+				zap.String("remote_path", key),
+				zap.Int64("offset", offset),
+				zap.Int("attempt", attempt),
+				errorField(err))
+			if err := e.sleep(ctx, e.retryDelay); err != nil {
+				return nil, err
+			}
+		}
+
+		var data []byte
+		data, err = e.fetchPart(ctx, ranger, key, offset, length, options)
+		if err == nil {
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("failed to download bytes %d-%d: %w", offset, offset+length-1, err)
+}
+
+// fetchPart makes a single ranged request and checks its length
+func (e *Engine) fetchPart(ctx context.Context, ranger interfaces.RangeProvider, key string, offset, length int64, options interfaces.TransferOptions) ([]byte, error) {
+	body, err := ranger.DownloadRange(ctx, key, offset, length, options)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var buffer bytes.Buffer
+	buffer.Grow(int(length))
+	if _, err := buffer.ReadFrom(body); err != nil {
+		return nil, err
+	}
+	if int64(buffer.Len()) != length {
+		return nil, fmt.Errorf("range returned %d bytes, expected %d", buffer.Len(), length)
+	}
+	return buffer.Bytes(), nil
+}
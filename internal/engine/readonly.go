@@ -0,0 +1,286 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// syncReadOnly brings a read-only directory in line with its remote
+// prefix. Files are compared with their state records: objects changed
+// remotely are downloaded and files whose object was removed are deleted,
+// while files changed locally are flagged or reverted. Nothing is uploaded.
+func (e *Engine) syncReadOnly(ctx context.Context, dir interfaces.SyncDirectory) error {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return fmt.Errorf("read-only directories require a state store")
+	}
+
+	remoteFiles, err := e.listSparse(ctx, dir)
+	if err != nil {
+		return err
+	}
+	e.updateDirectoryUsage(dir, remoteFiles)
+
+	remoteFileMap := make(map[string]interfaces.FileInfo, len(remoteFiles))
+	for _, info := range remoteFiles {
+		if !info.IsDir {
+			remoteFileMap[info.Key] = info
+		}
+	}
+	records := make(map[string]state.ObjectRecord)
+	for _, record := range store.Records() {
+		if withinDirectory(record.LocalPath, dir.LocalPath) {
+			records[record.Key] = record
+		}
+	}
+
+	prefix := remoteDirPrefix(dir)
+	revert := dir.LocalChanges == interfaces.LocalChangesRevert
+	var downloads, removed int
+	download := func(localPath string, remoteInfo interfaces.FileInfo) error {
+		downloads++
+		return e.enqueueDownload(ctx, syncTask{
+			localPath:  localPath,
+			remotePath: remoteInfo.Key,
+			rootPath:   dir.LocalPath,
+			operation:  "download",
+			metadata:   interfaces.FileMetadata{Size: remoteInfo.Size, ModTime: remoteInfo.ModTime},
+			etag:       remoteInfo.MD5Hash,
+		})
+	}
+
+	// Local files are removed from remoteFileMap and records as they are
+	// seen, leaving the objects and records without a local file
+	err = e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, localInfo os.FileInfo) error {
+		e.countScanned(ctx)
+		if !e.shouldSyncFile(localPath, dir) {
+			return nil
+		}
+		key, ok := e.localKey(dir, localPath)
+		if !ok {
+			return nil
+		}
+		remoteInfo, exists := remoteFileMap[key]
+		delete(remoteFileMap, key)
+		record, recorded := records[key]
+		delete(records, key)
+		changed := recorded && (localInfo.Size() != record.Size || !localInfo.ModTime().Equal(record.ModTime))
+
+		if !inSparse(dir, strings.TrimPrefix(key, prefix)) {
+			// Files downloaded before the sparse patterns were narrowed go,
+			// other files outside them are left alone
+			if recorded && !changed {
+				if err := e.fs.Remove(localPath); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove %s: %w", localPath, err)
+				}
+				e.countDeleted(ctx)
+				removed++
+			}
+			if recorded {
+				e.forgetObject(key)
+			}
+			return nil
+		}
+
+		switch {
+		case exists && recorded && !changed:
+			if remoteInfo.Size != record.Size || !remoteInfo.ModTime.Equal(record.RemoteModTime) {
+				return download(localPath, remoteInfo)
+			}
+		case exists && !recorded && e.adoptReadOnly(ctx, dir, localPath, localInfo, remoteInfo):
+		case exists:
+			e.reportLocalChange(ctx, dir, localPath, key, "modified", revert)
+			if revert {
+				return download(localPath, remoteInfo)
+			}
+		case recorded && !changed:
+			// The object was removed remotely
+			if err := e.fs.Remove(localPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", localPath, err)
+			}
+			e.countDeleted(ctx)
+			e.forgetObject(key)
+			removed++
+		default:
+			e.reportLocalChange(ctx, dir, localPath, key, "added", revert)
+			if revert {
+				if err := e.fs.Remove(localPath); err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to remove %s: %w", localPath, err)
+				}
+				e.countDeleted(ctx)
+				e.forgetObject(key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan local files: %w", err)
+	}
+
+	for key, remoteInfo := range remoteFileMap {
+		relPath, ok := strings.CutPrefix(key, prefix)
+		if !ok || (!dir.Recursive && strings.Contains(relPath, "/")) {
+			continue
+		}
+		localPath := manifestLocalPath(dir, relPath)
+		if !withinDirectory(localPath, dir.LocalPath) || !e.shouldSyncFile(localPath, dir) {
+			continue
+		}
+		if _, recorded := records[key]; recorded {
+			e.reportLocalChange(ctx, dir, localPath, key, "deleted", revert)
+			if !revert {
+				continue
+			}
+		}
+		if err := download(localPath, remoteInfo); err != nil {
+			return err
+		}
+	}
+
+	// Records whose file and object are both gone
+	for key := range records {
+		if _, exists := remoteFileMap[key]; !exists {
+			e.forgetObject(key)
+		}
+	}
+
+	if downloads > 0 || removed > 0 {
+		e.logger.Info("Applied remote changes to read-only directory",
+			zap.String("local_path", dir.LocalPath),
+			zap.Int("downloads_queued", downloads),
+			zap.Int("files_removed", removed))
+	}
+	return nil
+}
+
+// adoptReadOnly records a file of a read-only directory that has no state
+// record but holds the content of its object, and reports whether it did
+func (e *Engine) adoptReadOnly(ctx context.Context, dir interfaces.SyncDirectory, localPath string, localInfo os.FileInfo, remoteInfo interfaces.FileInfo) bool {
+	if localInfo.Size() != remoteInfo.Size {
+		return false
+	}
+	hash, same := e.sameContent(ctx, dir, localPath, remoteInfo)
+	if !same {
+		return false
+	}
+	e.recordReadOnly(remoteInfo.Key, localPath, hash, localInfo, remoteInfo.ModTime)
+	return true
+}
+
+// reportLocalChange logs, audits and publishes a change made to a file of
+// a read-only directory. kind is "modified", "added" or "deleted".
+func (e *Engine) reportLocalChange(ctx context.Context, dir interfaces.SyncDirectory, localPath, key, kind string, revert bool) {
+	result := "flagged"
+	if revert {
+		result = "reverted"
+	}
+	e.logger.Warn("Local change in read-only directory",
+		zap.String("local_path", localPath),
+		zap.String("change", kind),
+		zap.String("result", result))
+	e.audit(ctx, audit.Entry{
+		Action:    "local_change",
+		Key:       key,
+		LocalPath: localPath,
+		Reason:    kind,
+		Result:    result,
+	})
+	e.publish(interfaces.SyncEvent{
+		Type:       interfaces.EventLocalChange,
+		Directory:  dir.LocalPath,
+		LocalPath:  localPath,
+		RemotePath: key,
+	})
+}
+
+// enqueueDownload queues a download, waiting while the queue is full
+func (e *Engine) enqueueDownload(ctx context.Context, task syncTask) error {
+	if task.syncID == "" {
+		task.syncID = interfaces.SyncID(ctx)
+	}
+	e.pendingDownloads.Add(1)
+	select {
+	case e.downloadQueue <- task:
+		e.countQueued(task.syncID)
+		return nil
+	case <-ctx.Done():
+		e.pendingDownloads.Add(-1)
+		return ctx.Err()
+	case <-e.stopChan:
+		e.pendingDownloads.Add(-1)
+		return fmt.Errorf("engine stopped")
+	}
+}
+
+// downloadReplacing downloads the object of task over its local file,
+// which downloadFile only replaces once complete, then records it in the
+// state store
+func (e *Engine) downloadReplacing(ctx context.Context, task syncTask) error {
+	target := task.localPath
+	if err := e.downloadFile(ctx, task); err != nil {
+		return err
+	}
+
+	info, err := e.fs.Stat(target)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	hash, err := e.hashFile(target)
+	if err != nil {
+		return fmt.Errorf("failed to calculate MD5: %w", err)
+	}
+	e.recordReadOnly(task.remotePath, target, hash, info, task.metadata.ModTime)
+	return nil
+}
+
+// recordReadOnly records the local copy of an object in a read-only
+// directory, with the object's last modified time to notice remote changes
+func (e *Engine) recordReadOnly(key, localPath, md5Hash string, info os.FileInfo, remoteModTime time.Time) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+	store.Put(state.ObjectRecord{
+		Key:           key,
+		LocalPath:     localPath,
+		Size:          info.Size(),
+		MD5Hash:       md5Hash,
+		ModTime:       info.ModTime(),
+		UploadedAt:    e.clock.Now(),
+		RemoteModTime: remoteModTime,
+	})
+}
@@ -0,0 +1,112 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// Reconcile catches up on changes made to dir while the agent was not
+// running. Local files are compared with the state store by size and
+// modification time, and only files that changed or were never uploaded
+// are queued, without listing the remote. Directories without recorded
+// state, with a directory quota, or in backup mode get a full Sync instead.
+func (e *Engine) Reconcile(ctx context.Context, dir interfaces.SyncDirectory) error {
+	return e.runSync(ctx, dir, e.reconcileDirectory)
+}
+
+// reconcileDirectory queues the files of dir that differ from their state
+// store records, falling back to syncDirectory when there are none
+func (e *Engine) reconcileDirectory(ctx context.Context, dir interfaces.SyncDirectory) error {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+
+	// Directory quotas need the remote listing to track usage
+	if store == nil || dir.QuotaBytes > 0 || dir.QuotaObjects > 0 {
+		return e.syncDirectory(ctx, dir)
+	}
+
+	records := make(map[string]state.ObjectRecord)
+	for _, record := range store.Records() {
+		if withinDirectory(record.LocalPath, dir.LocalPath) {
+			records[record.Key] = record
+		}
+	}
+	if len(records) == 0 {
+		e.logger.Info("No recorded state for directory, running full sync",
+			zap.String("local_path", dir.LocalPath))
+		return e.syncDirectory(ctx, dir)
+	}
+
+	var checked, changed int
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, localInfo os.FileInfo) error {
+		e.countScanned(ctx)
+		if !e.shouldSyncFile(localPath, dir) || e.excludedByRules(localInfo, dir.FileRules) != "" {
+			return nil
+		}
+		checked++
+
+		remotePath, originalKey, err := e.remoteKey(dir, localPath)
+		if err != nil {
+			e.skipKey(localPath, err)
+			return nil
+		}
+
+		record, ok := records[remotePath]
+		if ok && record.LocalSize() == localInfo.Size() && record.ModTime.Equal(localInfo.ModTime()) {
+			return nil
+		}
+		changed++
+
+		task := syncTask{
+			localPath:    localPath,
+			remotePath:   remotePath,
+			rootPath:     dir.LocalPath,
+			operation:    "upload",
+			fileInfo:     localInfo,
+			metadata:     interfaces.FileMetadata{Size: record.Size},
+			remoteExists: ok,
+			sourcePath:   e.snapshotSource(dir, localPath),
+			originalKey:  originalKey,
+		}
+		_, err = e.enqueueUpload(ctx, task, true)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan local files: %w", err)
+	}
+	e.pruneUnreadable(dir.LocalPath)
+
+	e.logger.Info("Reconciled directory with recorded state",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("files_checked", checked),
+		zap.Int("files_changed", changed))
+	return nil
+}
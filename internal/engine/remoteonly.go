@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// RemoteOnlyReports reports the remote objects with no local file of
+// every enabled directory that is not backed up, sorted by local path
+func (e *Engine) RemoteOnlyReports(ctx context.Context, withKeys bool) ([]interfaces.RemoteOnlyReport, error) {
+	e.mutex.RLock()
+	directories := slices.Clone(e.directories)
+	e.mutex.RUnlock()
+	sort.Slice(directories, func(i, j int) bool {
+		return directories[i].LocalPath < directories[j].LocalPath
+	})
+
+	var reports []interfaces.RemoteOnlyReport
+	for _, dir := range directories {
+		if !dir.Enabled || dir.SyncMode == interfaces.SyncModeBackup {
+			continue
+		}
+		report, err := e.RemoteOnly(ctx, dir, withKeys)
+		if err != nil {
+			return reports, fmt.Errorf("failed to report remote-only objects of %s: %w", dir.LocalPath, err)
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// RemoteOnly reports the remote objects of dir with no local file. Local
+// files count whether or not they are synced, and an archive stub stands
+// in for its file. Keys are listed when withKeys is set.
+func (e *Engine) RemoteOnly(ctx context.Context, dir interfaces.SyncDirectory, withKeys bool) (*interfaces.RemoteOnlyReport, error) {
+	listCtx, cancel := e.listContext(ctx)
+	remoteFiles, err := e.provider.List(listCtx, remoteDirPrefix(dir))
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote files: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(remoteFiles)/1000+1), 0)
+
+	localKeys, err := e.localKeys(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]interfaces.FileInfo, len(remoteFiles))
+	for _, info := range remoteFiles {
+		if !localKeys[info.Key] {
+			remaining[info.Key] = info
+		}
+	}
+	return e.recordRemoteOnly(dir, remaining, withKeys), nil
+}
+
+// localKeys returns the remote keys of every local file of dir
+func (e *Engine) localKeys(ctx context.Context, dir interfaces.SyncDirectory) (map[string]bool, error) {
+	keys := make(map[string]bool)
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, _ os.FileInfo) error {
+		if remotePath, ok := e.localKey(dir, localPath); ok {
+			keys[remotePath] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get local files: %w", err)
+	}
+	return keys, nil
+}
+
+// localKey returns the remote key of a local file, or of the file an
+// archive stub stands in for
+func (e *Engine) localKey(dir interfaces.SyncDirectory, localPath string) (string, bool) {
+	remotePath, _, err := e.remoteKey(dir, strings.TrimSuffix(localPath, StubSuffix))
+	return remotePath, err == nil
+}
+
+// recordRemoteOnly reports the objects left in remaining once the keys of
+// the local files were removed, and records their number in the
+// directory's status and the statistics
+func (e *Engine) recordRemoteOnly(dir interfaces.SyncDirectory, remaining map[string]interfaces.FileInfo, withKeys bool) *interfaces.RemoteOnlyReport {
+	report := &interfaces.RemoteOnlyReport{LocalPath: dir.LocalPath, RemotePath: dir.RemotePath}
+	for key, info := range remaining {
+		if info.IsDir {
+			continue
+		}
+		report.Objects++
+		report.Bytes += info.Size
+		if withKeys {
+			report.Keys = append(report.Keys, key)
+		}
+	}
+	sort.Strings(report.Keys)
+
+	e.dirStatusMutex.Lock()
+	status, ok := e.dirStatus[dir.LocalPath]
+	if !ok {
+		status = &interfaces.DirectoryStatus{}
+		e.dirStatus[dir.LocalPath] = status
+	}
+	status.RemoteOnly = report.Objects
+	status.RemoteOnlyBytes = report.Bytes
+	total := 0
+	for _, status := range e.dirStatus {
+		total += status.RemoteOnly
+	}
+	e.dirStatusMutex.Unlock()
+
+	e.mutex.Lock()
+	e.stats.RemoteOnly = total
+	e.mutex.Unlock()
+	return report
+}
@@ -0,0 +1,224 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// remoteVersion identifies one version of a directory's manifest
+type remoteVersion struct {
+	metadata interfaces.FileMetadata
+	files    map[string]ManifestEntry // by path relative to the remote path
+}
+
+// unchanged reports whether metadata describes the version already seen
+func (v *remoteVersion) unchanged(metadata interfaces.FileMetadata) bool {
+	if v.files == nil {
+		return false
+	}
+	if v.metadata.ETag != "" && metadata.ETag != "" {
+		return v.metadata.ETag == metadata.ETag
+	}
+	return v.metadata.MD5Hash == metadata.MD5Hash &&
+		v.metadata.Size == metadata.Size &&
+		v.metadata.ModTime.Equal(metadata.ModTime)
+}
+
+// remotePollWorker periodically checks a directory's manifest for changes
+// made by other agents
+func (e *Engine) remotePollWorker(ctx context.Context, dir interfaces.SyncDirectory) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(dir.RemotePollInterval)
+	defer ticker.Stop()
+
+	var seen remoteVersion
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			current, ok := e.configuredDirectory(dir.LocalPath)
+			if !ok {
+				return
+			}
+			if !current.Enabled || e.isOffline() {
+				continue
+			}
+			if err := e.pollRemote(ctx, current, &seen); err != nil {
+				e.logger.Warn("Failed to poll remote changes",
+					zap.String("directory", dir.LocalPath),
+					errorField(err))
+			}
+		}
+	}
+}
+
+// pollRemote checks the directory's manifest with a conditional request and
+// publishes the files changed or deleted by others since the version in
+// seen. The first manifest found only becomes the baseline.
+func (e *Engine) pollRemote(ctx context.Context, dir interfaces.SyncDirectory, seen *remoteVersion) error {
+	key := manifestKey(dir)
+
+	opCtx, cancel := e.operationContext(ctx)
+	var metadata interfaces.FileMetadata
+	var changed bool
+	var err error
+	if conditional, ok := e.provider.(interfaces.ConditionalProvider); ok && seen.files != nil {
+		metadata, changed, err = conditional.GetMetadataIfChanged(opCtx, key, seen.metadata.ETag)
+	} else {
+		metadata, err = e.provider.GetMetadata(opCtx, key)
+		changed = err == nil && !seen.unchanged(metadata)
+	}
+	if err != nil {
+		// A missing manifest is not an error, the directory may just not
+		// have been synced with manifests enabled yet
+		cancel()
+		if errors.Is(err, interfaces.ErrNotFound) {
+			e.logger.Debug("No remote manifest to poll", zap.String("directory", dir.LocalPath))
+			return nil
+		}
+		return fmt.Errorf("failed to check manifest: %w", err)
+	}
+	cancel()
+	e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+	if !changed {
+		return nil
+	}
+
+	manifest, err := e.LoadManifest(ctx, dir)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]ManifestEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		files[entry.Path] = entry
+	}
+
+	baseline := seen.files == nil
+	previous := seen.files
+	seen.metadata = metadata
+	seen.files = files
+	if baseline {
+		e.logger.Debug("Remote manifest baseline loaded",
+			zap.String("directory", dir.LocalPath),
+			zap.String("generation", manifest.Generation),
+			zap.Int("files", len(files)))
+		return nil
+	}
+
+	var changedFiles, deletedFiles int
+	for relPath, entry := range files {
+		if !inSparse(dir, relPath) {
+			continue
+		}
+		if old, ok := previous[relPath]; ok && old.MD5Hash == entry.MD5Hash && old.Size == entry.Size {
+			continue
+		}
+		if e.matchesLocal(dir, entry) {
+			continue
+		}
+		changedFiles++
+		e.publish(interfaces.SyncEvent{
+			Type:       interfaces.EventRemoteChanged,
+			Directory:  dir.LocalPath,
+			LocalPath:  manifestLocalPath(dir, relPath),
+			RemotePath: remoteDirPrefix(dir) + relPath,
+			Size:       entry.Size,
+		})
+	}
+	for relPath := range previous {
+		if _, ok := files[relPath]; ok || !inSparse(dir, relPath) || !e.knownLocally(dir, relPath) {
+			continue
+		}
+		deletedFiles++
+		e.publish(interfaces.SyncEvent{
+			Type:       interfaces.EventRemoteDeleted,
+			Directory:  dir.LocalPath,
+			LocalPath:  manifestLocalPath(dir, relPath),
+			RemotePath: remoteDirPrefix(dir) + relPath,
+		})
+	}
+
+	if changedFiles > 0 || deletedFiles > 0 {
+		e.logger.Info("Remote changes detected",
+			zap.String("directory", dir.LocalPath),
+			zap.String("generation", manifest.Generation),
+			zap.Int("changed", changedFiles),
+			zap.Int("deleted", deletedFiles))
+	}
+	return nil
+}
+
+// matchesLocal reports whether a manifest entry describes what this agent
+// uploaded or holds locally, so its own manifests cause no events
+func (e *Engine) matchesLocal(dir interfaces.SyncDirectory, entry ManifestEntry) bool {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		record, ok := store.Get(remoteDirPrefix(dir) + entry.Path)
+		return ok && record.MD5Hash == entry.MD5Hash && record.Size == entry.Size
+	}
+
+	localPath := manifestLocalPath(dir, entry.Path)
+	info, err := e.fs.Stat(localPath)
+	if err != nil || info.Size() != entry.Size {
+		return false
+	}
+	hash, err := e.hashFile(localPath)
+	return err == nil && hash == entry.MD5Hash
+}
+
+// knownLocally reports whether a file removed from the manifest is still
+// recorded or present here, meaning someone else deleted it
+func (e *Engine) knownLocally(dir interfaces.SyncDirectory, relPath string) bool {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		_, ok := store.Get(remoteDirPrefix(dir) + relPath)
+		return ok
+	}
+
+	_, err := e.fs.Stat(manifestLocalPath(dir, relPath))
+	return err == nil
+}
+
+// manifestLocalPath returns the local path of a file listed in a manifest,
+// decoding its name with the directory's key encoding
+func manifestLocalPath(dir interfaces.SyncDirectory, relPath string) string {
+	if decoded, err := localRelativePath(dir, relPath); err == nil {
+		relPath = decoded
+	}
+	return filepath.Join(dir.LocalPath, relPath)
+}
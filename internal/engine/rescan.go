@@ -0,0 +1,87 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// SetRescanInterval sets how often realtime directories are scanned in
+// full to pick up changes whose file events were missed, for example
+// while the watcher queue overflowed. Directories may override it; 0
+// disables rescans of directories without their own interval.
+func (e *Engine) SetRescanInterval(interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rescanInterval = interval
+}
+
+// directoryRescanInterval returns how often dir is rescanned, 0 if never.
+// The caller must hold e.mutex.
+func (e *Engine) directoryRescanInterval(dir interfaces.SyncDirectory) time.Duration {
+	if dir.SyncMode != interfaces.SyncModeRealtime {
+		return 0
+	}
+	if dir.RescanInterval > 0 {
+		return dir.RescanInterval
+	}
+	return e.rescanInterval
+}
+
+// rescanWorker periodically runs a full sync of a realtime directory,
+// which otherwise only uploads the files its events report
+func (e *Engine) rescanWorker(ctx context.Context, dir interfaces.SyncDirectory, interval time.Duration) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			current, ok := e.configuredDirectory(dir.LocalPath)
+			if !ok {
+				return
+			}
+			if !current.Enabled || e.isOffline() {
+				continue
+			}
+			e.logger.Info("Rescanning realtime directory",
+				zap.String("directory", dir.LocalPath),
+				zap.Duration("interval", interval))
+			if err := e.Sync(ctx, current); err != nil {
+				e.logger.Error("Periodic rescan failed",
+					zap.String("directory", dir.LocalPath),
+					errorField(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"go.uber.org/zap"
+)
+
+const (
+	// resourceCheckInterval is how often the agent's own resource usage
+	// is sampled against its budgets
+	resourceCheckInterval = 5 * time.Second
+
+	// resourceHighWater is the fraction of a budget at which work is
+	// reduced by one step
+	resourceHighWater = 0.9
+
+	// resourceLowWater is the fraction of every budget usage must drop
+	// below before one step of work is restored
+	resourceLowWater = 0.7
+)
+
+// resourceSample is the agent's memory and CPU usage at one point in time
+type resourceSample struct {
+	rss uint64  // resident memory in bytes
+	cpu float64 // percent of one core since the previous sample
+}
+
+// SetResourceLimits sets the memory and CPU budgets of the agent.
+// memoryBytes caps resident memory and cpuPercent caps CPU use, where 100
+// is one full core. When usage approaches a budget, transfer workers, scan
+// parallelism and ranged download parallelism are halved step by step and
+// restored once usage falls again. Zero disables a budget.
+func (e *Engine) SetResourceLimits(memoryBytes int64, cpuPercent float64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.memoryLimit = memoryBytes
+	e.cpuLimit = cpuPercent
+}
+
+// resourceWorker samples resource usage until the engine stops
+func (e *Engine) resourceWorker(ctx context.Context) {
+	defer e.wg.Done()
+
+	sample := e.resourceSampler
+	if sample == nil {
+		sampler, err := newProcessSampler()
+		if err != nil {
+			e.logger.Error("Resource budgets disabled", errorField(err))
+			return
+		}
+		sample = sampler
+	}
+
+	ticker := e.clock.NewTicker(resourceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			usage, err := sample()
+			if err != nil {
+				e.logger.Debug("Failed to sample resource usage", errorField(err))
+				continue
+			}
+			e.adjustResourceLevel(usage)
+		}
+	}
+}
+
+// newProcessSampler returns a function sampling the usage of this process
+func newProcessSampler() (func() (resourceSample, error), error) {
+	proc, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect own process: %w", err)
+	}
+	// The first CPU reading only sets the starting point
+	proc.Percent(0)
+
+	return func() (resourceSample, error) {
+		memory, err := proc.MemoryInfo()
+		if err != nil {
+			return resourceSample{}, fmt.Errorf("failed to read memory usage: %w", err)
+		}
+		cpu, err := proc.Percent(0)
+		if err != nil {
+			return resourceSample{}, fmt.Errorf("failed to read CPU usage: %w", err)
+		}
+		return resourceSample{rss: memory.RSS, cpu: cpu}, nil
+	}, nil
+}
+
+// adjustResourceLevel compares a usage sample with the budgets, reducing
+// work by one step when a budget is nearly used and restoring one step once
+// usage is well below every budget
+func (e *Engine) adjustResourceLevel(usage resourceSample) {
+	e.mutex.Lock()
+	pressure := 0.0
+	memoryPressure := false
+	if e.memoryLimit > 0 {
+		ratio := float64(usage.rss) / float64(e.memoryLimit)
+		memoryPressure = ratio >= resourceHighWater
+		pressure = max(pressure, ratio)
+	}
+	if e.cpuLimit > 0 {
+		pressure = max(pressure, usage.cpu/e.cpuLimit)
+	}
+
+	previous := e.resourceLevel
+	switch {
+	case pressure >= resourceHighWater && e.resourceLevel < e.maxResourceLevel():
+		e.resourceLevel++
+	case pressure < resourceLowWater && e.resourceLevel > 0:
+		e.resourceLevel--
+	}
+	level := e.resourceLevel
+
+	uploads, downloads := e.workerTargets()
+	if level != previous && e.running {
+		e.uploadPool.resize(uploads)
+		e.downloadPool.resize(downloads)
+	}
+	e.mutex.Unlock()
+
+	if memoryPressure {
+		// Return freed memory to the system before the budget is reached
+		debug.FreeOSMemory()
+	}
+	if level == previous {
+		return
+	}
+
+	e.metrics.RecordConcurrency("upload", uploads)
+	e.metrics.RecordConcurrency("download", downloads)
+	fields := []zap.Field{
+		zap.Uint64("rss_bytes", usage.rss),
+		zap.Float64("cpu_percent", usage.cpu),
+		zap.Int("level", level),
+		zap.Int("upload_workers", uploads),
+		zap.Int("download_workers", downloads),
+	}
+	if level > previous {
+		e.logger.Warn("Resource budget nearly used, reducing concurrency", fields...)
+	} else {
+		e.logger.Info("Resource usage back under budget, restoring concurrency", fields...)
+	}
+}
+
+// maxResourceLevel returns the level at which every scaled setting is
+// down to one. The caller holds e.mutex.
+func (e *Engine) maxResourceLevel() int {
+	largest := max(e.maxConcurrentUploads, e.maxConcurrentDownloads, e.scanParallelism, e.downloadParallelism)
+	if largest < 2 {
+		return 0
+	}
+	return bits.Len(uint(largest)) - 1
+}
+
+// resourceScaled reduces a concurrency setting by the current resource
+// level, halving it for each level but never below one. The caller holds
+// e.mutex.
+func (e *Engine) resourceScaled(n int) int {
+	if e.resourceLevel == 0 || n <= 1 {
+		return n
+	}
+	return max(n>>e.resourceLevel, 1)
+}
@@ -0,0 +1,253 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// RetentionAction is a removal selected by a remote retention rule
+type RetentionAction struct {
+	Key       string
+	VersionID string // empty for current objects
+	Reason    string
+	Size      int64
+}
+
+// RetentionReport describes the removals selected for a directory
+type RetentionReport struct {
+	LocalPath string
+	DryRun    bool
+	Actions   []RetentionAction
+	Failed    int
+}
+
+// SetAuditLog sets the log receiving an entry for every destructive action
+func (e *Engine) SetAuditLog(log *audit.Log) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.auditLog = log
+}
+
+// audit records an audit entry for work done with ctx, tagged with the
+// sync it belongs to, logging failures to write it
+func (e *Engine) audit(ctx context.Context, entry audit.Entry) {
+	if entry.SyncID == "" {
+		entry.SyncID = interfaces.SyncID(ctx)
+	}
+
+	e.mutex.RLock()
+	log := e.auditLog
+	e.mutex.RUnlock()
+
+	if err := log.Record(entry); err != nil {
+		e.logger.Error("Failed to write audit entry", errorField(err))
+	}
+}
+
+// ApplyRemoteRetention evaluates a directory's remote retention rules and
+// deletes the selected objects and versions. Nothing is deleted when dryRun
+// is set or the directory's rules are marked dry-run; the report lists what
+// would be removed either way.
+func (e *Engine) ApplyRemoteRetention(ctx context.Context, dir interfaces.SyncDirectory, dryRun bool) (*RetentionReport, error) {
+	rules := dir.RemoteRetention
+	report := &RetentionReport{LocalPath: dir.LocalPath, DryRun: dryRun || rules.DryRun}
+	if rules.IsZero() {
+		return report, nil
+	}
+
+	if rules.DeleteUnseenAfter > 0 {
+		actions, err := e.unseenObjects(ctx, dir, rules.DeleteUnseenAfter)
+		if err != nil {
+			return nil, err
+		}
+		report.Actions = append(report.Actions, actions...)
+	}
+
+	if rules.KeepVersions > 0 {
+		actions, err := e.excessVersions(ctx, dir, rules.KeepVersions)
+		if err != nil {
+			return nil, err
+		}
+		report.Actions = append(report.Actions, actions...)
+	}
+
+	for _, action := range report.Actions {
+		entry := audit.Entry{
+			Action:    "delete",
+			Key:       action.Key,
+			VersionID: action.VersionID,
+			LocalPath: dir.LocalPath,
+			Reason:    action.Reason,
+			DryRun:    report.DryRun,
+		}
+		if action.VersionID != "" {
+			entry.Action = "delete_version"
+		}
+
+		if !report.DryRun {
+			if err := e.deleteRetained(ctx, action); err != nil {
+				report.Failed++
+				entry.SetError(err)
+				e.logger.Error("Failed to remove remote object",
+					zap.String("remote_path", action.Key),
+					zap.String("version_id", action.VersionID),
+					errorField(err))
+			}
+		}
+		e.audit(ctx, entry)
+	}
+
+	e.logger.Info("Remote retention evaluated",
+		zap.String("local_path", dir.LocalPath),
+		zap.Int("selected", len(report.Actions)),
+		zap.Int("failed", report.Failed),
+		zap.Bool("dry_run", report.DryRun))
+
+	return report, nil
+}
+
+// unseenObjects selects remote objects whose local file has been missing
+// for longer than maxAge. Absence is tracked in the state store.
+func (e *Engine) unseenObjects(ctx context.Context, dir interfaces.SyncDirectory, maxAge time.Duration) ([]RetentionAction, error) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		e.logger.Warn("Skipping delete_unseen_after rule, no state database configured",
+			zap.String("local_path", dir.LocalPath))
+		return nil, nil
+	}
+
+	localKeys, err := e.localKeys(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	listCtx, cancel := e.listContext(ctx)
+	remoteFiles, err := e.provider.List(listCtx, remoteDirPrefix(dir))
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote files: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(remoteFiles)/1000+1), 0)
+
+	now := e.clock.Now()
+	var actions []RetentionAction
+	for _, remote := range remoteFiles {
+		if remote.IsDir {
+			continue
+		}
+		if record, ok := store.Get(remote.Key); localKeys[remote.Key] || (ok && !record.ArchivedAt.IsZero()) {
+			store.ClearUnseen(remote.Key)
+			continue
+		}
+
+		since := store.MarkUnseen(remote.Key, now)
+		if now.Sub(since) >= maxAge {
+			actions = append(actions, RetentionAction{
+				Key:    remote.Key,
+				Reason: fmt.Sprintf("not present locally since %s", since.UTC().Format(time.RFC3339)),
+				Size:   remote.Size,
+			})
+		}
+	}
+	return actions, nil
+}
+
+// excessVersions selects noncurrent versions beyond the newest keep
+// versions of each object
+func (e *Engine) excessVersions(ctx context.Context, dir interfaces.SyncDirectory, keep int) ([]RetentionAction, error) {
+	versioned, ok := e.provider.(interfaces.VersionedProvider)
+	if !ok {
+		e.logger.Warn("Skipping keep_versions rule, provider does not support versioning",
+			zap.String("local_path", dir.LocalPath))
+		return nil, nil
+	}
+
+	listCtx, cancel := e.listContext(ctx)
+	versions, err := versioned.ListVersions(listCtx, remoteDirPrefix(dir))
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list object versions: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(versions)/1000+1), 0)
+
+	byKey := make(map[string][]interfaces.ObjectVersion)
+	for _, version := range versions {
+		byKey[version.Key] = append(byKey[version.Key], version)
+	}
+
+	var actions []RetentionAction
+	for key, list := range byKey {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].LastModified.After(list[j].LastModified)
+		})
+		for i, version := range list {
+			if i < keep || version.IsLatest {
+				continue
+			}
+			actions = append(actions, RetentionAction{
+				Key:       key,
+				VersionID: version.VersionID,
+				Reason:    fmt.Sprintf("exceeds %d kept versions", keep),
+				Size:      version.Size,
+			})
+		}
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Key != actions[j].Key {
+			return actions[i].Key < actions[j].Key
+		}
+		return actions[i].VersionID < actions[j].VersionID
+	})
+	return actions, nil
+}
+
+// deleteRetained removes an object or object version selected by retention
+func (e *Engine) deleteRetained(ctx context.Context, action RetentionAction) error {
+	opCtx, cancel := e.operationContext(ctx)
+	defer cancel()
+
+	if action.VersionID != "" {
+		return e.provider.(interfaces.VersionedProvider).DeleteVersion(opCtx, action.Key, action.VersionID)
+	}
+
+	if err := e.provider.Delete(opCtx, action.Key); err != nil {
+		return err
+	}
+	e.forgetObject(action.Key)
+	e.mutex.Lock()
+	e.stats.FilesDeleted++
+	e.mutex.Unlock()
+	e.countDeleted(ctx)
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestApplyRemoteRetentionDeletesUnseenObjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		dryRun   bool
+		wantKeys []string
+	}{
+		{name: "deletes", wantKeys: []string{"data/archived.txt", "data/kept.txt"}},
+		{name: "dry run", dryRun: true, wantKeys: []string{"data/archived.txt", "data/gone.txt", "data/kept.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			te := newTestEngine(t)
+			ctx := context.Background()
+			dir := interfaces.SyncDirectory{
+				LocalPath:       "/data",
+				RemotePath:      "data",
+				Recursive:       true,
+				RemoteRetention: interfaces.RemoteRetention{DeleteUnseenAfter: 48 * time.Hour},
+			}
+
+			te.fs.MkdirAll("/data", 0755)
+			te.fs.WriteFile("/data/kept.txt", []byte("kept"), te.clock.Now())
+			te.putUploaded("data/kept.txt", "/data/kept.txt", []byte("kept"))
+			te.putUploaded("data/gone.txt", "/data/gone.txt", []byte("gone"))
+			te.putUploaded("data/archived.txt", "/data/archived.txt", []byte("archived"))
+			te.markArchived("data/archived.txt", "", nil)
+
+			// Absence is only noticed by the first pass
+			report, err := te.ApplyRemoteRetention(ctx, dir, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Actions) != 0 {
+				t.Fatalf("first pass selected %v", report.Actions)
+			}
+
+			te.clock.Advance(48 * time.Hour)
+			report, err = te.ApplyRemoteRetention(ctx, dir, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Actions) != 1 || report.Actions[0].Key != "data/gone.txt" {
+				t.Fatalf("second pass selected %v, want data/gone.txt", report.Actions)
+			}
+			if report.DryRun != tt.dryRun || report.Failed != 0 {
+				t.Errorf("report dry run %v failed %d", report.DryRun, report.Failed)
+			}
+			if got := te.provider.Keys(); !slices.Equal(got, tt.wantKeys) {
+				t.Errorf("remote keys = %v, want %v", got, tt.wantKeys)
+			}
+			if _, ok := te.store.Get("data/gone.txt"); ok == !tt.dryRun {
+				t.Errorf("state record of data/gone.txt kept = %v", ok)
+			}
+		})
+	}
+}
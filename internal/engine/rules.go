@@ -0,0 +1,106 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+	"sync"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+)
+
+// ownerIDs caches user and group names resolved to numeric IDs
+var ownerIDs sync.Map
+
+// excludedByRules returns why a file is skipped by the size, age and
+// ownership rules of its directory, or "" when it is synced
+func (e *Engine) excludedByRules(info os.FileInfo, rules interfaces.FileRules) string {
+	if rules.IsZero() {
+		return ""
+	}
+
+	size := info.Size()
+	if rules.MinSize > 0 && size < rules.MinSize {
+		return "smaller than min_size"
+	}
+	if rules.MaxSize > 0 && size > rules.MaxSize {
+		return "larger than max_size"
+	}
+
+	age := e.clock.Now().Sub(info.ModTime())
+	if rules.ModifiedWithin > 0 && age > rules.ModifiedWithin {
+		return "not modified within modified_within"
+	}
+	if rules.OlderThan > 0 && age < rules.OlderThan {
+		return "modified more recently than older_than"
+	}
+
+	if len(rules.Owners) == 0 && len(rules.Groups) == 0 {
+		return ""
+	}
+	uid, gid, ok := utils.FileOwner(info)
+	if !ok {
+		return "owner unknown"
+	}
+	if len(rules.Owners) > 0 && !slices.ContainsFunc(rules.Owners, func(owner string) bool {
+		return resolveOwnerID(owner, false) == uid
+	}) {
+		return "owner not in owners"
+	}
+	if len(rules.Groups) > 0 && !slices.ContainsFunc(rules.Groups, func(group string) bool {
+		return resolveOwnerID(group, true) == gid
+	}) {
+		return "group not in groups"
+	}
+	return ""
+}
+
+// resolveOwnerID returns the numeric ID of a user or group given by name
+// or ID, or "" when the name is unknown
+func resolveOwnerID(name string, group bool) string {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name
+	}
+
+	key := "user:" + name
+	if group {
+		key = "group:" + name
+	}
+	if id, ok := ownerIDs.Load(key); ok {
+		return id.(string)
+	}
+
+	var id string
+	if group {
+		if g, err := user.LookupGroup(name); err == nil {
+			id = g.Gid
+		}
+	} else if u, err := user.Lookup(name); err == nil {
+		id = u.Uid
+	}
+	ownerIDs.Store(key, id)
+	return id
+}
@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+)
+
+// runAs returns the identity configured for the directory at root, or nil
+// when files are created as the agent's own user
+func (e *Engine) runAs(root string) *utils.Identity {
+	dir, ok := e.configuredDirectory(root)
+	if !ok || dir.RunAs == "" {
+		return nil
+	}
+	id, err := utils.LookupIdentity(dir.RunAs)
+	if err != nil {
+		e.logger.Warn("Failed to resolve run_as user",
+			zap.String("local_path", root),
+			zap.String("run_as", dir.RunAs),
+			errorField(err))
+		return nil
+	}
+	return &id
+}
+
+// checkReadable fails with errUnreadable when the run_as user of the
+// directory at root could not read the file at path itself, so that
+// running as root does not upload files their owner kept private
+func (e *Engine) checkReadable(root, path string, info os.FileInfo) error {
+	if info == nil {
+		return nil
+	}
+	if id := e.runAs(root); id != nil && !utils.CanRead(path, info, *id) {
+		return fmt.Errorf("%w: not readable by %s", errUnreadable, id.Name)
+	}
+	return nil
+}
+
+// mkdirAllAs creates path and its missing parents, giving the directories
+// it created to the run_as user of the directory at root. Below root the
+// directories are walked one at a time and symbolic links are refused, so
+// that the user cannot redirect the agent's writes outside the directory.
+func (e *Engine) mkdirAllAs(root, path string, perm os.FileMode) error {
+	id := e.runAs(root)
+	if id == nil {
+		return e.fs.MkdirAll(path, perm)
+	}
+
+	base, rel := root, ""
+	if r, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(r) {
+		rel = r
+	} else {
+		base = path
+	}
+
+	var missing []string
+	for dir := base; ; dir = filepath.Dir(dir) {
+		if _, err := e.fs.Lstat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if err := e.fs.MkdirAll(base, perm); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		e.chown(missing[i], id)
+	}
+	if rel == "" || rel == "." {
+		return nil
+	}
+
+	dir := base
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		info, err := e.fs.Lstat(dir)
+		switch {
+		case os.IsNotExist(err):
+			if err := e.fs.MkdirAll(dir, perm); err != nil {
+				return err
+			}
+			e.chown(dir, id)
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("refusing to write below symbolic link %s", dir)
+		case !info.IsDir():
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// chownAs gives a file the agent created to the run_as user of the
+// directory at root
+func (e *Engine) chownAs(root, path string) {
+	if id := e.runAs(root); id != nil {
+		e.chown(path, id)
+	}
+}
+
+// chown changes the owner of path to id, logging failures. Only root may
+// give files away, other users keep owning what they create.
+func (e *Engine) chown(path string, id *utils.Identity) {
+	fs, ok := e.fs.(interfaces.OwnerFileSystem)
+	if !ok || !utils.IsPrivileged() {
+		return
+	}
+	if err := fs.Lchown(path, id.UID, id.GID); err != nil {
+		e.logger.Warn("Failed to set file owner",
+			zap.String("path", path),
+			zap.String("run_as", id.Name),
+			errorField(err))
+	}
+}
@@ -0,0 +1,172 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"sort"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+)
+
+// runHistory is the number of sync runs kept for each directory
+const runHistory = 100
+
+// restoreRuns loads the sync runs recorded in store, so the history
+// survives restarts
+func (e *Engine) restoreRuns(store *state.Store) {
+	e.runMutex.Lock()
+	defer e.runMutex.Unlock()
+
+	for _, run := range store.Runs() {
+		if run.FinishedAt.IsZero() {
+			// The agent stopped during the scan
+			run.FinishedAt = run.StartedAt
+			run.Error = "interrupted"
+		}
+		e.addRun(&run)
+	}
+}
+
+// addRun keeps a run record, dropping the oldest of its directory beyond
+// runHistory. runMutex must be held.
+func (e *Engine) addRun(run *state.SyncRun) {
+	e.runs[run.ID] = run
+	order := append(e.runOrder[run.Directory], run.ID)
+	if len(order) > runHistory {
+		for _, id := range order[:len(order)-runHistory] {
+			delete(e.runs, id)
+		}
+		order = order[len(order)-runHistory:]
+	}
+	e.runOrder[run.Directory] = order
+}
+
+// beginRun starts the record of a sync run of a directory
+func (e *Engine) beginRun(directory, syncID string) {
+	run := &state.SyncRun{ID: syncID, Directory: directory, StartedAt: e.clock.Now()}
+	e.runMutex.Lock()
+	e.addRun(run)
+	e.runMutex.Unlock()
+	e.saveRun(syncID)
+}
+
+// endRun records the end of the scan of a run. Files scanned but not
+// queued count as skipped.
+func (e *Engine) endRun(syncID string, err error) {
+	e.updateRun(syncID, true, func(run *state.SyncRun) {
+		run.FinishedAt = e.clock.Now()
+		run.ScanDuration = run.FinishedAt.Sub(run.StartedAt)
+		run.FilesSkipped = max(run.FilesScanned-run.FilesQueued, 0)
+		if err != nil {
+			run.Error = err.Error()
+		}
+	})
+}
+
+// updateRun applies change to the run with the given ID, if it is one,
+// and moves its end to now once its scan ended. With save the record is
+// also written to the state store.
+func (e *Engine) updateRun(syncID string, save bool, change func(run *state.SyncRun)) {
+	if syncID == "" {
+		return
+	}
+	e.runMutex.Lock()
+	run, ok := e.runs[syncID]
+	if ok {
+		change(run)
+		if !run.FinishedAt.IsZero() {
+			run.FinishedAt = e.clock.Now()
+		}
+	}
+	e.runMutex.Unlock()
+	if ok && save {
+		e.saveRun(syncID)
+	}
+}
+
+// saveRun writes the record of a run to the state store
+func (e *Engine) saveRun(syncID string) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	e.runMutex.Lock()
+	run, ok := e.runs[syncID]
+	var record state.SyncRun
+	if ok {
+		record = *run
+	}
+	e.runMutex.Unlock()
+	if ok {
+		store.PutRun(record, runHistory)
+	}
+}
+
+// countScanned counts a file examined by the scan of the run ctx belongs to
+func (e *Engine) countScanned(ctx context.Context) {
+	e.updateRun(interfaces.SyncID(ctx), false, func(run *state.SyncRun) { run.FilesScanned++ })
+}
+
+// countQueued counts a transfer queued by a run
+func (e *Engine) countQueued(syncID string) {
+	e.updateRun(syncID, false, func(run *state.SyncRun) { run.FilesQueued++ })
+}
+
+// countFailed counts a failed transfer of a run
+func (e *Engine) countFailed(syncID string) {
+	e.updateRun(syncID, true, func(run *state.SyncRun) { run.Errors++ })
+}
+
+// countDeleted counts a file or object removed by the run ctx belongs to
+func (e *Engine) countDeleted(ctx context.Context) {
+	e.updateRun(interfaces.SyncID(ctx), true, func(run *state.SyncRun) { run.FilesDeleted++ })
+}
+
+// SyncRuns returns the recorded sync runs of a directory, or of every
+// directory when directory is empty, newest first. A positive limit
+// returns that many runs at most.
+func (e *Engine) SyncRuns(directory string, limit int) []state.SyncRun {
+	e.runMutex.Lock()
+	var runs []state.SyncRun
+	for dir, order := range e.runOrder {
+		if directory != "" && dir != directory {
+			continue
+		}
+		for _, id := range order {
+			runs = append(runs, *e.runs[id])
+		}
+	}
+	e.runMutex.Unlock()
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs
+}
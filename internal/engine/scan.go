@@ -0,0 +1,331 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// scanLimiter paces directory scanning to a fixed number of files per second
+type scanLimiter struct {
+	interval time.Duration
+	next     time.Time
+	mutex    sync.Mutex
+}
+
+// wait blocks until the next file may be scanned. A nil limiter never waits.
+func (l *scanLimiter) wait(ctx context.Context, clock interfaces.Clock) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := clock.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clock.After(delay):
+		return nil
+	}
+}
+
+// SetScanRateLimit limits how many files per second directory scans visit,
+// shared by all scans. Zero removes the limit.
+func (e *Engine) SetScanRateLimit(filesPerSecond int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if filesPerSecond <= 0 {
+		e.scanLimiter = nil
+		return
+	}
+	e.scanLimiter = &scanLimiter{interval: time.Second / time.Duration(filesPerSecond)}
+}
+
+// scannedFile is a file found by a parallel scan
+type scannedFile struct {
+	path string
+	info os.FileInfo
+}
+
+// scanner holds what one directory scan needs
+type scanner struct {
+	fs         interfaces.FileSystem
+	clock      interfaces.Clock
+	limiter    *scanLimiter
+	unreadable func(path string, err error) // a path was skipped
+	readable   func(path string)            // a directory was read
+}
+
+// SetScanParallelism sets how many directories a recursive scan reads
+// concurrently. Values below 2 scan with a single goroutine.
+func (e *Engine) SetScanParallelism(parallelism int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.scanParallelism = parallelism
+}
+
+// walkLocalFiles streams the files below rootPath to fn without holding the
+// tree in memory. Only the root directory is visited when recursive is
+// false. Files removed while the scan runs are skipped, as are files and
+// subdirectories that cannot be read; those are recorded and retried by
+// the next scan. fn is never called concurrently; files arrive in lexical
+// order unless the scan is parallel.
+func (e *Engine) walkLocalFiles(ctx context.Context, rootPath string, recursive bool, fn func(path string, info os.FileInfo) error) error {
+	e.mutex.RLock()
+	parallelism := e.resourceScaled(e.scanParallelism)
+	s := &scanner{
+		fs:         e.fs,
+		clock:      e.clock,
+		limiter:    e.scanLimiter,
+		unreadable: func(path string, err error) { e.markUnreadable(ctx, path, err) },
+		readable:   e.clearUnreadable,
+	}
+	e.mutex.RUnlock()
+
+	rootInfo, err := s.fs.Stat(rootPath)
+	if err != nil {
+		return err
+	}
+	if !rootInfo.IsDir() {
+		return fn(rootPath, rootInfo)
+	}
+
+	// An unreadable root fails the scan instead of being skipped
+	entries, err := s.fs.ReadDir(rootPath)
+	if err != nil {
+		return err
+	}
+
+	if recursive && parallelism > 1 {
+		return s.walkParallel(ctx, rootPath, entries, parallelism, fn)
+	}
+	return s.walkSerial(ctx, rootPath, entries, recursive, fn)
+}
+
+// readDir lists dir, recording it as unreadable on failure
+func (s *scanner) readDir(dir string) ([]fs.DirEntry, bool) {
+	entries, err := s.fs.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	if err != nil {
+		s.unreadable(dir, err)
+		return nil, false
+	}
+	s.readable(dir)
+	return entries, true
+}
+
+// fileInfo waits for the rate limiter and returns the entry's information,
+// or nil when the file vanished or cannot be read
+func (s *scanner) fileInfo(ctx context.Context, path string, entry fs.DirEntry) (os.FileInfo, error) {
+	if err := s.limiter.wait(ctx, s.clock); err != nil {
+		return nil, err
+	}
+
+	info, err := entry.Info()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		s.unreadable(path, err)
+		return nil, nil
+	}
+	return info, nil
+}
+
+// walkSerial visits the entries of dir depth first in lexical order
+func (s *scanner) walkSerial(ctx context.Context, dir string, entries []fs.DirEntry, recursive bool, fn func(path string, info os.FileInfo) error) error {
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if !recursive {
+				continue
+			}
+			if children, ok := s.readDir(path); ok {
+				if err := s.walkSerial(ctx, path, children, recursive, fn); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		info, err := s.fileInfo(ctx, path, entry)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			continue
+		}
+		if err := fn(path, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkParallel reads directories with a pool of workers and hands every
+// file to fn from the calling goroutine. The first error stops the scan.
+func (s *scanner) walkParallel(ctx context.Context, rootPath string, rootEntries []fs.DirEntry, parallelism int, fn func(path string, info os.FileInfo) error) error {
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mutex   sync.Mutex
+		cond    = sync.NewCond(&mutex)
+		dirs    []string
+		pending = 1 // directories queued or being read
+		walkErr error
+	)
+	fail := func(err error) {
+		mutex.Lock()
+		if walkErr == nil {
+			walkErr = err
+		}
+		mutex.Unlock()
+		cancel()
+	}
+
+	// Wake idle workers when the scan is cancelled
+	stop := context.AfterFunc(walkCtx, func() {
+		mutex.Lock()
+		cond.Broadcast()
+		mutex.Unlock()
+	})
+	defer stop()
+
+	results := make(chan scannedFile, parallelism*64)
+
+	// scan reads one directory and queues its subdirectories
+	scan := func(dir string, entries []fs.DirEntry) error {
+		subdirs, err := s.scanDirectory(walkCtx, dir, entries, results)
+
+		mutex.Lock()
+		dirs = append(dirs, subdirs...)
+		pending += len(subdirs) - 1
+		cond.Broadcast()
+		mutex.Unlock()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := scan(rootPath, rootEntries); err != nil {
+			fail(err)
+		}
+	}()
+
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mutex.Lock()
+				for len(dirs) == 0 && pending > 0 && walkCtx.Err() == nil {
+					cond.Wait()
+				}
+				if len(dirs) == 0 || walkCtx.Err() != nil {
+					mutex.Unlock()
+					return
+				}
+				// Depth first keeps the queue of unread directories short
+				dir := dirs[len(dirs)-1]
+				dirs = dirs[:len(dirs)-1]
+				mutex.Unlock()
+
+				entries, _ := s.readDir(dir)
+				if err := scan(dir, entries); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	for file := range results {
+		if walkCtx.Err() != nil {
+			continue // drain until the workers exit
+		}
+		if err := fn(file.path, file.info); err != nil {
+			fail(err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if walkErr != nil {
+		return walkErr
+	}
+	return ctx.Err()
+}
+
+// scanDirectory sends the files among the entries of dir to results and
+// returns its subdirectories
+func (s *scanner) scanDirectory(ctx context.Context, dir string, entries []fs.DirEntry, results chan<- scannedFile) ([]string, error) {
+	var subdirs []string
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+
+		info, err := s.fileInfo(ctx, path, entry)
+		if err != nil {
+			return subdirs, err
+		}
+		if info == nil {
+			continue
+		}
+
+		select {
+		case results <- scannedFile{path: path, info: info}:
+		case <-ctx.Done():
+			return subdirs, ctx.Err()
+		}
+	}
+	return subdirs, nil
+}
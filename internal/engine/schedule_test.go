@@ -0,0 +1,69 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestDueScheduledDirectories(t *testing.T) {
+	te := newTestEngine(t)
+	te.directories = []interfaces.SyncDirectory{
+		{LocalPath: "/nightly", SyncMode: interfaces.SyncModeScheduled, Schedule: "0 2 * * *", Enabled: true},
+		{LocalPath: "/default", SyncMode: interfaces.SyncModeBackup, Enabled: true},
+		{LocalPath: "/realtime", SyncMode: interfaces.SyncModeRealtime, Enabled: true},
+		{LocalPath: "/disabled", SyncMode: interfaces.SyncModeScheduled, Schedule: "* * * * *"},
+	}
+	next := make(map[string]time.Time)
+
+	due := func() []string {
+		var paths []string
+		for _, dir := range te.dueScheduledDirectories(next) {
+			paths = append(paths, dir.LocalPath)
+		}
+		return paths
+	}
+	steps := []struct {
+		advance time.Duration
+		want    []string
+	}{
+		// Directories wait for their schedule after the start
+		{advance: 0},
+		{advance: 4 * time.Minute},
+		{advance: time.Minute, want: []string{"/default"}},
+		{advance: time.Minute},
+		// 2:00 the next day
+		{advance: 13*time.Hour + 54*time.Minute, want: []string{"/nightly", "/default"}},
+		{advance: time.Minute},
+		{advance: 24 * time.Hour, want: []string{"/nightly", "/default"}},
+	}
+	for i, step := range steps {
+		te.clock.Advance(step.advance)
+		if got := due(); !slices.Equal(got, step.want) {
+			t.Errorf("step %d at %v: due = %v, want %v", i, te.clock.Now(), got, step.want)
+		}
+	}
+}
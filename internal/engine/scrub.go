@@ -0,0 +1,350 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// stateFlushInterval is how often pending state changes are written to disk
+const stateFlushInterval = 30 * time.Second
+
+// ScrubReport describes the result of a remote integrity scrub
+type ScrubReport struct {
+	Checked      int
+	Sampled      int
+	Missing      []string // recorded objects no longer present remotely
+	Modified     []string // remote size or checksum changed outside the agent
+	Corrupted    []string // downloaded content does not match the recorded hash
+	Unverifiable []string // remote object has no usable checksum and was not sampled
+	Archived     int      // objects in archival storage, checked but never sampled
+	Errors       []string
+	Duration     time.Duration
+}
+
+// OK reports whether the scrub found no problems
+func (r *ScrubReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Modified) == 0 &&
+		len(r.Corrupted) == 0 && len(r.Errors) == 0
+}
+
+// issues returns the problem counts by kind for metrics
+func (r *ScrubReport) issues() map[string]int {
+	return map[string]int{
+		"missing":      len(r.Missing),
+		"modified":     len(r.Modified),
+		"corrupted":    len(r.Corrupted),
+		"unverifiable": len(r.Unverifiable),
+		"error":        len(r.Errors),
+	}
+}
+
+// SetStateStore sets the persistent store used to record uploaded objects
+func (e *Engine) SetStateStore(store *state.Store) {
+	e.mutex.Lock()
+	e.stateStore = store
+	e.mutex.Unlock()
+	if store != nil {
+		e.restoreRuns(store)
+		e.restoreCosts(store)
+	}
+}
+
+// SetScrub enables periodic remote integrity scrubs. Every recorded object
+// is checked with a metadata request and sampleSize objects are downloaded
+// and re-hashed on each run. A zero interval disables scrubbing.
+func (e *Engine) SetScrub(interval time.Duration, sampleSize int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.scrubInterval = interval
+	e.scrubSampleSize = sampleSize
+}
+
+// recordUploadState stores the uploaded object in the state store, along
+// with its hard link group
+func (e *Engine) recordUploadState(task syncTask, size int64, md5Hash string, filtered bool) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	record := state.ObjectRecord{
+		Key:        task.remotePath,
+		LocalPath:  task.localPath,
+		Size:       size,
+		MD5Hash:    md5Hash,
+		ModTime:    task.fileInfo.ModTime(),
+		UploadedAt: e.clock.Now(),
+	}
+	if filtered {
+		record.Filtered = true
+		record.SourceSize = task.fileInfo.Size()
+	} else if dir, ok := e.directoryFor(task.localPath); ok {
+		record.PartialHash = e.partialHash(dir, task.localPath, task.fileInfo)
+	}
+	store.Put(record)
+	e.recordHardLink(task, size, md5Hash)
+}
+
+// recordedUpload returns the state record of the object at key, if state
+// is kept and the object was recorded
+func (e *Engine) recordedUpload(key string) (state.ObjectRecord, bool) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return state.ObjectRecord{}, false
+	}
+	return store.Get(key)
+}
+
+// forgetObject removes a deleted remote object from the state store
+func (e *Engine) forgetObject(key string) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store != nil {
+		store.Delete(key)
+	}
+}
+
+// saveState writes pending state changes to disk
+func (e *Engine) saveState() {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return
+	}
+
+	if err := store.Save(); err != nil {
+		e.logger.Error("Failed to save state", zap.String("path", store.Path()), errorField(err))
+	}
+}
+
+// stateWorker periodically flushes the state store
+func (e *Engine) stateWorker(ctx context.Context) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			e.saveState()
+		}
+	}
+}
+
+// Scrub checks every object recorded in the state store against the
+// remote, detecting objects that were deleted or modified outside the agent.
+// Up to sampleSize objects are downloaded and re-hashed to detect bit rot.
+func (e *Engine) Scrub(ctx context.Context, sampleSize int) (*ScrubReport, error) {
+	e.mutex.RLock()
+	store := e.stateStore
+	e.mutex.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("scrub requires a state store")
+	}
+
+	start := e.clock.Now()
+	report := &ScrubReport{}
+	var intact []state.ObjectRecord
+
+	for _, record := range store.Records() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Checked++
+
+		opCtx, cancel := e.operationContext(ctx)
+		metadata, err := e.provider.GetMetadata(opCtx, record.Key)
+		cancel()
+		e.recordRequests(scrubScope, 0, 1, 0, 0)
+		if err != nil {
+			if errors.Is(err, interfaces.ErrNotFound) {
+				report.Missing = append(report.Missing, record.Key)
+			} else {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", record.Key, err))
+			}
+			continue
+		}
+
+		if metadata.Size != record.Size ||
+			(metadata.MD5Hash != "" && record.MD5Hash != "" && metadata.MD5Hash != record.MD5Hash) {
+			report.Modified = append(report.Modified, record.Key)
+			continue
+		}
+
+		if metadata.MD5Hash == "" {
+			report.Unverifiable = append(report.Unverifiable, record.Key)
+		} else {
+			store.MarkVerified(record.Key, e.clock.Now())
+		}
+		// Archived content cannot be downloaded without a costly restore
+		if metadata.Archived {
+			report.Archived++
+			continue
+		}
+		intact = append(intact, record)
+	}
+
+	// Download a random sample to detect corruption the metadata cannot show
+	rand.Shuffle(len(intact), func(i, j int) { intact[i], intact[j] = intact[j], intact[i] })
+	if sampleSize < len(intact) {
+		intact = intact[:sampleSize]
+	}
+	for _, record := range intact {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Sampled++
+
+		hash, err := e.downloadHash(ctx, record)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", record.Key, err))
+			continue
+		}
+		if hash != record.MD5Hash {
+			report.Corrupted = append(report.Corrupted, record.Key)
+			continue
+		}
+
+		report.Unverifiable = removeString(report.Unverifiable, record.Key)
+		store.MarkVerified(record.Key, e.clock.Now())
+	}
+
+	report.Duration = e.clock.Now().Sub(start)
+	e.saveState()
+
+	e.metrics.RecordScrubResult(report.issues())
+	e.metrics.RecordFileOperation("scrub", report.Duration, report.OK())
+	e.logScrubReport(report)
+
+	return report, nil
+}
+
+// scrubScope is the cost scope for scrub requests
+const scrubScope = "scrub"
+
+// downloadHash downloads an object and returns the MD5 of its content
+func (e *Engine) downloadHash(ctx context.Context, record state.ObjectRecord) (string, error) {
+	downloadCtx, cancel := e.transferContext(ctx, record.Size)
+	defer cancel()
+
+	body, _, err := e.provider.Download(downloadCtx, record.Key, interfaces.TransferOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to download object: %w", err)
+	}
+	defer body.Close()
+
+	hasher := md5.New()
+	size, err := io.Copy(hasher, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+
+	e.metrics.RecordBandwidth(size, "download")
+	e.recordRequests(scrubScope, 0, 1, 0, size)
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// logScrubReport logs a summary of a scrub and each problem found
+func (e *Engine) logScrubReport(report *ScrubReport) {
+	fields := []zap.Field{
+		zap.Int("checked", report.Checked),
+		zap.Int("sampled", report.Sampled),
+		zap.Int("missing", len(report.Missing)),
+		zap.Int("modified", len(report.Modified)),
+		zap.Int("corrupted", len(report.Corrupted)),
+		zap.Int("unverifiable", len(report.Unverifiable)),
+		zap.Int("archived", report.Archived),
+		zap.Int("errors", len(report.Errors)),
+		zap.Duration("duration", report.Duration),
+	}
+
+	if report.OK() {
+		e.logger.Info("Remote integrity scrub passed", fields...)
+		return
+	}
+
+	e.logger.Error("Remote integrity scrub found problems", fields...)
+	for _, key := range report.Missing {
+		e.logger.Error("Remote object missing", zap.String("remote_path", key))
+	}
+	for _, key := range report.Modified {
+		e.logger.Error("Remote object modified outside the agent", zap.String("remote_path", key))
+	}
+	for _, key := range report.Corrupted {
+		e.logger.Error("Remote object content corrupted", zap.String("remote_path", key))
+	}
+}
+
+// scrubWorker runs remote integrity scrubs on an interval
+func (e *Engine) scrubWorker(ctx context.Context, interval time.Duration, sampleSize int) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			if _, err := e.Scrub(ctx, sampleSize); err != nil {
+				e.logger.Error("Scheduled scrub failed", errorField(err))
+			}
+		}
+	}
+}
+
+// removeString returns list without the first occurrence of value
+func removeString(list []string, value string) []string {
+	for i, item := range list {
+		if item == value {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
@@ -0,0 +1,125 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	fakes "CloudAWSync/internal/testing"
+)
+
+func TestUploadRetryWaitsOnClock(t *testing.T) {
+	te := newTestEngine(t)
+	te.fs.MkdirAll("/data", 0755)
+	te.fs.WriteFile("/data/report.txt", []byte("report"), te.clock.Now())
+	info, err := te.fs.Stat("/data/report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	te.provider.FailNext(fakes.OpUpload, errors.New("internal error"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		te.processUploadTask(context.Background(), syncTask{
+			localPath:  "/data/report.txt",
+			remotePath: "data/report.txt",
+			rootPath:   "/data",
+			operation:  "upload",
+			fileInfo:   info,
+		}, 0)
+	}()
+
+	// The retry waits for the retry delay on the engine clock
+	deadline := time.Now().Add(5 * time.Second)
+	for te.clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("upload did not wait on the clock before retrying")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := te.provider.Calls(fakes.OpUpload); got != 1 {
+		t.Fatalf("uploads before the retry delay = %d, want 1", got)
+	}
+	te.clock.Advance(time.Millisecond)
+	<-done
+
+	if got := te.provider.Calls(fakes.OpUpload); got != 2 {
+		t.Errorf("uploads = %d, want 2", got)
+	}
+	if data, ok := te.provider.Object("data/report.txt"); !ok || string(data) != "report" {
+		t.Errorf("uploaded object = %q, %v", data, ok)
+	}
+}
+
+func TestArchiveAndHydrateOnFileSystem(t *testing.T) {
+	te := newTestEngine(t)
+	ctx := context.Background()
+	dir := interfaces.SyncDirectory{
+		LocalPath:  "/data",
+		RemotePath: "data",
+		Recursive:  true,
+		Archive:    interfaces.ArchivePolicy{After: 24 * time.Hour, Stub: true},
+	}
+	modTime := te.clock.Now().Add(-48 * time.Hour)
+	te.fs.MkdirAll("/data", 0755)
+	te.fs.WriteFile("/data/old.txt", []byte("old content"), modTime)
+	te.fs.Chmod("/data/old.txt", 0640)
+	te.putUploaded("data/old.txt", "/data/old.txt", []byte("old content"))
+
+	report, err := te.ArchiveDirectory(ctx, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Archived) != 1 {
+		t.Fatalf("archived = %v, skipped = %v", report.Archived, report.Skipped)
+	}
+	if _, err := te.fs.Stat("/data/old.txt"); err == nil {
+		t.Fatal("archived file still present")
+	}
+	if _, err := readStub(te.fs, "/data/old.txt"+StubSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := te.Hydrate(ctx, dir, "/data/old.txt"+StubSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := te.fs.ReadFile(path)
+	if err != nil || string(data) != "old content" {
+		t.Fatalf("hydrated content = %q, %v", data, err)
+	}
+	info, err := te.fs.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(modTime) {
+		t.Errorf("hydrated file mode %v modified %v, want 0640 and %v", info.Mode().Perm(), info.ModTime(), modTime)
+	}
+	if _, err := te.fs.Stat("/data/old.txt" + StubSuffix); err == nil {
+		t.Error("stub left after hydration")
+	}
+}
@@ -0,0 +1,229 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// secretScanLine is the longest line scanned at once; longer lines are
+// scanned in pieces
+const secretScanLine = 64 * 1024
+
+// maxSecretFindings limits how many findings are reported per file
+const maxSecretFindings = 10
+
+// errSecretDetected marks files that were not uploaded because they
+// contain likely secrets
+var errSecretDetected = errors.New("secrets detected")
+
+// secretRule is a named regular expression matching a kind of secret
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// builtinSecretRules are checked by every secret scan
+var builtinSecretRules = []secretRule{
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16}\b`)},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+	{"env-secret", regexp.MustCompile(`^\s*(?:export\s+)?[A-Z0-9_]*(?:SECRET|PASSWORD|PASSWD|TOKEN|API_KEY|APIKEY|PRIVATE_KEY|CREDENTIALS)[A-Z0-9_]*\s*=\s*["']?[^\s"'$]`)},
+	{"github-token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+}
+
+// secretFinding is a likely secret, identified by rule and line so the
+// secret itself is never logged
+type secretFinding struct {
+	rule string
+	line int
+}
+
+// secretScanner finds likely secrets in content
+type secretScanner struct {
+	rules []secretRule
+	allow []*regexp.Regexp
+}
+
+// newSecretScanner creates a scanner for the built-in rules and the
+// patterns and allowed matches of config
+func newSecretScanner(config interfaces.SecretScan) (*secretScanner, error) {
+	scanner := &secretScanner{rules: builtinSecretRules}
+	for i, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern '%s': %w", pattern, err)
+		}
+		scanner.rules = append(scanner.rules, secretRule{name: fmt.Sprintf("pattern[%d]", i), pattern: re})
+	}
+	for _, pattern := range config.AllowMatches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed match '%s': %w", pattern, err)
+		}
+		scanner.allow = append(scanner.allow, re)
+	}
+	return scanner, nil
+}
+
+// scan returns the likely secrets in r. Binary content, recognized by a
+// zero byte near its start, is not scanned.
+func (s *secretScanner) scan(r io.Reader) ([]secretFinding, error) {
+	reader := bufio.NewReaderSize(r, secretScanLine)
+	head, err := reader.Peek(sniffLength)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var findings []secretFinding
+	for line := 1; ; {
+		text, err := reader.ReadSlice('\n')
+		for _, rule := range s.rules {
+			if s.matches(rule.pattern, text) {
+				findings = append(findings, secretFinding{rule: rule.name, line: line})
+				if len(findings) == maxSecretFindings {
+					return findings, nil
+				}
+			}
+		}
+		switch {
+		case err == nil:
+			line++
+		case errors.Is(err, bufio.ErrBufferFull):
+			// The rest of the line is scanned next
+		case err == io.EOF:
+			return findings, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// matches reports whether pattern matches text other than at allowed
+// matches
+func (s *secretScanner) matches(pattern *regexp.Regexp, text []byte) bool {
+	for _, loc := range pattern.FindAllIndex(text, -1) {
+		if !anyMatch(s.allow, text[loc[0]:loc[1]]) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyMatch reports whether one of patterns matches match
+func anyMatch(patterns []*regexp.Regexp, match []byte) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretScanAllowed reports whether path is exempt from the secret scan of
+// dir by its name or its path relative to the directory
+func secretScanAllowed(dir interfaces.SyncDirectory, path string) bool {
+	if matchesAnyPattern(dir.SecretScan.AllowPaths, filepath.Base(path)) {
+		return true
+	}
+	rel, err := filepath.Rel(dir.LocalPath, path)
+	return err == nil && matchesAnyPattern(dir.SecretScan.AllowPaths, filepath.ToSlash(rel))
+}
+
+// scanSecrets scans the content about to be uploaded for task, read from
+// file, and rewinds file. Findings are logged and audited; with the block
+// action errSecretDetected is returned.
+func (e *Engine) scanSecrets(ctx context.Context, task syncTask, file interfaces.File) error {
+	dir, ok := e.directoryFor(task.localPath)
+	if !ok || !dir.SecretScan.Enabled || secretScanAllowed(dir, task.localPath) {
+		return nil
+	}
+
+	scanner, err := newSecretScanner(dir.SecretScan)
+	if err != nil {
+		return err
+	}
+	findings, err := scanner.scan(file)
+	if err != nil {
+		return fmt.Errorf("failed to scan for secrets: %w: %w", errUnreadable, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset file pointer: %w", err)
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	described := make([]string, len(findings))
+	for i, finding := range findings {
+		described[i] = fmt.Sprintf("%s at line %d", finding.rule, finding.line)
+	}
+	summary := strings.Join(described, ", ")
+	block := dir.SecretScan.Action == interfaces.SecretScanBlock
+
+	entry := audit.Entry{
+		Action:    "secret_scan",
+		Key:       task.remotePath,
+		LocalPath: task.localPath,
+		Reason:    summary,
+		Result:    "warned",
+	}
+	if block {
+		entry.Result = "blocked"
+		e.logger.Warn("Secrets detected, upload blocked",
+			zap.String("local_path", task.localPath),
+			zap.Strings("findings", described))
+	} else {
+		e.logger.Warn("Secrets detected, uploading anyway",
+			zap.String("local_path", task.localPath),
+			zap.Strings("findings", described))
+	}
+	e.audit(ctx, entry)
+
+	if block {
+		return fmt.Errorf("%w (%s)", errSecretDetected, summary)
+	}
+	return nil
+}
+
+// contentRejected reports whether err stopped a transfer because of what
+// the file contains, which retrying does not change
+func contentRejected(err error) bool {
+	return errors.Is(err, errInfected) || errors.Is(err, errSecretDetected)
+}
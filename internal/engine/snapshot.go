@@ -0,0 +1,308 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultSnapshotTimeout bounds lock waits and snapshot commands
+	defaultSnapshotTimeout = time.Minute
+
+	// snapshotLockPoll is how often a locked file is tried again
+	snapshotLockPoll = 100 * time.Millisecond
+
+	// snapshotCopyAttempts is how often a file changing during its copy is
+	// copied again before the upload is skipped
+	snapshotCopyAttempts = 3
+)
+
+var (
+	errFileLocked   = errors.New("file is locked by another process")
+	errFileChanging = errors.New("file changed while being copied")
+)
+
+// snapshotTimeout returns the lock wait and command limit of a policy
+func snapshotTimeout(policy interfaces.SnapshotPolicy) time.Duration {
+	if policy.Timeout > 0 {
+		return policy.Timeout
+	}
+	return defaultSnapshotTimeout
+}
+
+// snapshotApplies reports whether the lock or copy mode of policy covers
+// the file at path
+func snapshotApplies(policy interfaces.SnapshotPolicy, path string) bool {
+	if len(policy.Patterns) == 0 {
+		return true
+	}
+	name := filepath.Base(path)
+	for _, pattern := range policy.Patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// openForUpload opens the content to upload for task. Scans of a directory
+// with a snapshot command read from the snapshot, which is not replaced
+// until the returned release function is called.
+func (e *Engine) openForUpload(ctx context.Context, task syncTask) (interfaces.File, func(), error) {
+	if task.sourcePath != "" {
+		e.snapshotMutex.RLock()
+		file, err := e.fs.Open(task.sourcePath)
+		if err != nil {
+			e.snapshotMutex.RUnlock()
+			return nil, nil, err
+		}
+		return file, e.snapshotMutex.RUnlock, nil
+	}
+
+	dir, _ := e.directoryFor(task.localPath)
+	policy := dir.Snapshot
+	if snapshotApplies(policy, task.localPath) {
+		switch policy.Mode {
+		case interfaces.SnapshotModeLock:
+			return e.openLocked(ctx, task.localPath, policy)
+		case interfaces.SnapshotModeCopy:
+			return e.openCopy(task.localPath, policy)
+		}
+	}
+
+	file, err := e.fs.Open(task.localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() {}, nil
+}
+
+// openLocked opens path once no other process holds an exclusive flock on
+// it, keeping a shared lock until the file is closed
+func (e *Engine) openLocked(ctx context.Context, path string, policy interfaces.SnapshotPolicy) (interfaces.File, func(), error) {
+	deadline := e.clock.Now().Add(snapshotTimeout(policy))
+	for {
+		file, err := e.fs.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fd, ok := file.(interface{ Fd() uintptr })
+		if !ok {
+			return file, func() {}, nil
+		}
+		locked, err := utils.TryLockShared(fd.Fd())
+		if locked {
+			return file, func() {}, nil
+		}
+		file.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to lock file: %w", err)
+		}
+
+		if !e.clock.Now().Before(deadline) {
+			return nil, nil, errFileLocked
+		}
+		if err := e.sleep(ctx, snapshotLockPoll); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// openCopy copies path to a temporary file and opens the copy, trying
+// again when the file changes while it is copied. The release function
+// removes the copy.
+func (e *Engine) openCopy(path string, policy interfaces.SnapshotPolicy) (interfaces.File, func(), error) {
+	tempDir := policy.TempDir
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+
+	for attempt := 0; attempt < snapshotCopyAttempts; attempt++ {
+		before, err := e.fs.Stat(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		copyPath := filepath.Join(tempDir, fmt.Sprintf(".cloudawsync-copy-%d-%d", os.Getpid(), e.snapshotSeq.Add(1)))
+		if err := e.copyToTemp(path, copyPath); err != nil {
+			e.fs.Remove(copyPath)
+			return nil, nil, err
+		}
+
+		after, err := e.fs.Stat(path)
+		if err != nil {
+			e.fs.Remove(copyPath)
+			return nil, nil, err
+		}
+		if before.Size() != after.Size() || !before.ModTime().Equal(after.ModTime()) {
+			e.fs.Remove(copyPath)
+			e.logger.Debug("File changed while being copied",
+				zap.String("local_path", path),
+				zap.Int("attempt", attempt+1))
+			continue
+		}
+
+		file, err := e.fs.Open(copyPath)
+		if err != nil {
+			e.fs.Remove(copyPath)
+			return nil, nil, fmt.Errorf("failed to open copy: %w", err)
+		}
+		return file, func() { e.fs.Remove(copyPath) }, nil
+	}
+	return nil, nil, errFileChanging
+}
+
+// copyToTemp copies the content of path to copyPath
+func (e *Engine) copyToTemp(path, copyPath string) error {
+	src, err := e.fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := e.fs.Create(copyPath)
+	if err != nil {
+		return fmt.Errorf("failed to create copy: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to write copy: %w", err)
+	}
+	return nil
+}
+
+// takeSnapshot replaces the snapshot of a directory using a snapshot
+// command before it is scanned, waiting for uploads still reading the
+// previous one
+func (e *Engine) takeSnapshot(ctx context.Context, dir interfaces.SyncDirectory) error {
+	if dir.Snapshot.Mode != interfaces.SnapshotModeCommand {
+		return nil
+	}
+
+	e.snapshotMutex.Lock()
+	defer e.snapshotMutex.Unlock()
+
+	if previous, ok := e.snapshots[dir.LocalPath]; ok {
+		delete(e.snapshots, dir.LocalPath)
+		if err := e.runSnapshotCommand(ctx, previous, previous.Snapshot.ReleaseCommand); err != nil {
+			return fmt.Errorf("failed to release snapshot: %w", err)
+		}
+	}
+	if err := e.runSnapshotCommand(ctx, dir, dir.Snapshot.Command); err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	e.snapshots[dir.LocalPath] = dir
+
+	e.logger.Info("Created snapshot",
+		zap.String("local_path", dir.LocalPath),
+		zap.String("snapshot_path", dir.Snapshot.Path))
+	return nil
+}
+
+// releaseSnapshots removes all snapshots still held
+func (e *Engine) releaseSnapshots() {
+	e.snapshotMutex.Lock()
+	defer e.snapshotMutex.Unlock()
+
+	for root, dir := range e.snapshots {
+		delete(e.snapshots, root)
+		if err := e.runSnapshotCommand(context.Background(), dir, dir.Snapshot.ReleaseCommand); err != nil {
+			e.logger.Error("Failed to release snapshot",
+				zap.String("local_path", root),
+				errorField(err))
+		}
+	}
+}
+
+// releaseSnapshot removes the snapshot of the directory at root, if any
+func (e *Engine) releaseSnapshot(root string) {
+	e.snapshotMutex.Lock()
+	defer e.snapshotMutex.Unlock()
+
+	dir, ok := e.snapshots[root]
+	if !ok {
+		return
+	}
+	delete(e.snapshots, root)
+	if err := e.runSnapshotCommand(context.Background(), dir, dir.Snapshot.ReleaseCommand); err != nil {
+		e.logger.Error("Failed to release snapshot",
+			zap.String("local_path", root),
+			errorField(err))
+	}
+}
+
+// runSnapshotCommand runs a snapshot command through the shell with the
+// directory and snapshot paths in its environment
+func (e *Engine) runSnapshotCommand(ctx context.Context, dir interfaces.SyncDirectory, command string) error {
+	if command == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout(dir.Snapshot))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"CLOUDAWSYNC_LOCAL_PATH="+dir.LocalPath,
+		"CLOUDAWSYNC_SNAPSHOT_PATH="+dir.Snapshot.Path)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("%w: %s", err, out)
+		}
+		return err
+	}
+	return nil
+}
+
+// snapshotSource returns where a scan reads localPath from, or "" when the
+// directory has no snapshot
+func (e *Engine) snapshotSource(dir interfaces.SyncDirectory, localPath string) string {
+	if dir.Snapshot.Mode != interfaces.SnapshotModeCommand {
+		return ""
+	}
+
+	e.snapshotMutex.RLock()
+	defer e.snapshotMutex.RUnlock()
+
+	if _, ok := e.snapshots[dir.LocalPath]; !ok {
+		return ""
+	}
+	return filepath.Join(dir.Snapshot.Path, e.getRelativePath(localPath, dir.LocalPath))
+}
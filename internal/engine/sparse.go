@@ -0,0 +1,85 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// inSparse reports whether a path relative to the directory's remote path
+// is selected by its sparse patterns. A pattern selects the paths it
+// matches and everything below them. Without patterns every path is.
+func inSparse(dir interfaces.SyncDirectory, relPath string) bool {
+	if len(dir.Sparse) == 0 {
+		return true
+	}
+	for _, pattern := range dir.Sparse {
+		pattern = strings.Trim(pattern, "/")
+		for candidate := relPath; candidate != "."; candidate = path.Dir(candidate) {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listSparse lists the remote objects of a directory selected by its
+// sparse patterns. Patterns without wildcards are listed on their own
+// instead of the whole remote path, which may be much larger.
+func (e *Engine) listSparse(ctx context.Context, dir interfaces.SyncDirectory) ([]interfaces.FileInfo, error) {
+	prefix := remoteDirPrefix(dir)
+	prefixes := []string{prefix}
+	if len(dir.Sparse) > 0 && !strings.ContainsAny(strings.Join(dir.Sparse, ""), `*?[\`) {
+		prefixes = prefixes[:0]
+		for _, pattern := range dir.Sparse {
+			prefixes = append(prefixes, prefix+strings.Trim(pattern, "/"))
+		}
+	}
+
+	var files []interfaces.FileInfo
+	seen := make(map[string]bool)
+	for _, listPrefix := range prefixes {
+		listCtx, cancel := e.listContext(ctx)
+		listed, err := e.provider.List(listCtx, listPrefix)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get remote files: %w", err)
+		}
+		e.recordRequests(dir.LocalPath, 0, 0, int64(len(listed)/1000+1), 0)
+
+		for _, info := range listed {
+			relPath, ok := strings.CutPrefix(info.Key, prefix)
+			if !ok || seen[info.Key] || !inSparse(dir, relPath) {
+				continue
+			}
+			seen[info.Key] = true
+			files = append(files, info)
+		}
+	}
+	return files, nil
+}
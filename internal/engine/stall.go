@@ -0,0 +1,113 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// errTransferStalled is the cancellation cause for transfers that fall
+// below the configured minimum speed
+var errTransferStalled = errors.New("transfer stalled")
+
+// SetStallDetection aborts transfers whose throughput stays below
+// minBytesPerSec for the given window. Zero values disable detection.
+func (e *Engine) SetStallDetection(minBytesPerSec int64, window time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.minTransferSpeed = minBytesPerSec
+	e.stallWindow = window
+}
+
+// watchStall monitors a transfer's byte counter and cancels the returned
+// context with errTransferStalled if progress is too slow. The returned
+// stop function must be called once the transfer completes.
+func (e *Engine) watchStall(ctx context.Context, count *atomic.Int64, path, direction string) (context.Context, func()) {
+	e.mutex.RLock()
+	minSpeed := e.minTransferSpeed
+	window := e.stallWindow
+	e.mutex.RUnlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	if minSpeed <= 0 || window <= 0 {
+		return ctx, func() { cancel(nil) }
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := e.clock.NewTicker(window)
+		defer ticker.Stop()
+
+		minBytes := int64(float64(minSpeed) * window.Seconds())
+		last := count.Load()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C():
+				current := count.Load()
+				if current-last < minBytes {
+					e.logger.Warn("Transfer stalled, aborting",
+						zap.String("path", path),
+						zap.String("direction", direction),
+						zap.Int64("bytes_in_window", current-last),
+						zap.Duration("window", window))
+					e.recordStall(direction)
+					cancel(errTransferStalled)
+					return
+				}
+				last = current
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// stallError converts a cancellation caused by stall detection into a
+// descriptive error, leaving other errors unchanged
+func stallError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), errTransferStalled) {
+		return fmt.Errorf("%w: %v", errTransferStalled, err)
+	}
+	return err
+}
+
+// recordStall records a stalled transfer in stats and metrics
+func (e *Engine) recordStall(direction string) {
+	e.mutex.Lock()
+	e.stats.TransferStalls++
+	e.mutex.Unlock()
+	e.metrics.RecordTransferStall(direction)
+}
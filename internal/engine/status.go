@@ -0,0 +1,128 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"sort"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// Pause stops workers from starting new transfers until Resume is called.
+// Transfers already running finish, and scans keep queueing changes.
+func (e *Engine) Pause() {
+	e.pauseMutex.Lock()
+	defer e.pauseMutex.Unlock()
+
+	select {
+	case <-e.resumed:
+	default:
+		return // already paused
+	}
+	e.resumed = make(chan struct{})
+	e.setPaused(true)
+	e.logger.Info("Transfers paused")
+}
+
+// Resume lets workers start transfers again after Pause
+func (e *Engine) Resume() {
+	e.pauseMutex.Lock()
+	defer e.pauseMutex.Unlock()
+
+	select {
+	case <-e.resumed:
+		return // not paused
+	default:
+	}
+	close(e.resumed)
+	e.setPaused(false)
+	e.logger.Info("Transfers resumed")
+}
+
+// resumedChan returns a channel that is closed while transfers may run
+func (e *Engine) resumedChan() <-chan struct{} {
+	e.pauseMutex.Lock()
+	defer e.pauseMutex.Unlock()
+	return e.resumed
+}
+
+// setPaused records the pause state in the statistics
+func (e *Engine) setPaused(paused bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.stats.Paused = paused
+}
+
+// beginDirectorySync records that a sync of a directory started
+func (e *Engine) beginDirectorySync(localPath string) {
+	e.dirStatusMutex.Lock()
+	defer e.dirStatusMutex.Unlock()
+
+	status, ok := e.dirStatus[localPath]
+	if !ok {
+		status = &interfaces.DirectoryStatus{}
+		e.dirStatus[localPath] = status
+	}
+	status.Syncing = true
+}
+
+// endDirectorySync records the outcome of a directory sync
+func (e *Engine) endDirectorySync(localPath string, err error) {
+	e.dirStatusMutex.Lock()
+	defer e.dirStatusMutex.Unlock()
+
+	status := e.dirStatus[localPath]
+	status.Syncing = false
+	status.LastSync = e.clock.Now()
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// DirectoryStatuses returns the configuration and last sync outcome of
+// every directory, sorted by local path
+func (e *Engine) DirectoryStatuses() []interfaces.DirectoryStatus {
+	e.mutex.RLock()
+	directories := append([]interfaces.SyncDirectory(nil), e.directories...)
+	e.mutex.RUnlock()
+
+	e.dirStatusMutex.Lock()
+	defer e.dirStatusMutex.Unlock()
+
+	statuses := make([]interfaces.DirectoryStatus, 0, len(directories))
+	for _, dir := range directories {
+		status := interfaces.DirectoryStatus{}
+		if recorded, ok := e.dirStatus[dir.LocalPath]; ok {
+			status = *recorded
+		}
+		status.LocalPath = dir.LocalPath
+		status.RemotePath = dir.RemotePath
+		status.SyncMode = dir.SyncMode
+		status.Enabled = dir.Enabled
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].LocalPath < statuses[j].LocalPath
+	})
+	return statuses
+}
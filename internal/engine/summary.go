@@ -0,0 +1,98 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// SetSummaryInterval sets how often a one-line summary of the transfers
+// since the previous summary is logged. Zero disables summaries.
+func (e *Engine) SetSummaryInterval(interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.summaryInterval = interval
+}
+
+// summaryWorker logs a summary of the agent's work every interval
+func (e *Engine) summaryWorker(ctx context.Context, interval time.Duration) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := e.GetStats()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			current := e.GetStats()
+			e.logSummary(interval, previous, current, e.Activity())
+			previous = current
+		}
+	}
+}
+
+// logSummary logs the transfers between two statistics snapshots and the
+// current queues as a sentence, for admins reading the log, and as fields
+func (e *Engine) logSummary(interval time.Duration, previous, current interfaces.SyncStats, activity interfaces.Activity) {
+	uploaded := current.FilesUploaded - previous.FilesUploaded
+	uploadedBytes := current.BytesUploaded - previous.BytesUploaded
+	downloaded := current.FilesDownloaded - previous.FilesDownloaded
+	downloadedBytes := current.BytesDownloaded - previous.BytesDownloaded
+	failures := current.SyncErrors - previous.SyncErrors
+	held := e.EventBacklog()
+	pending := activity.QueuedUploads + activity.QueuedDownloads + current.OfflineQueued + held
+
+	message := fmt.Sprintf("Last %s: uploaded %d files / %s, downloaded %d files / %s, %d errors, queues: %d pending, %d in progress",
+		interval, uploaded, utils.FormatBytes(uploadedBytes), downloaded, utils.FormatBytes(downloadedBytes),
+		failures, pending, len(activity.Transfers))
+	if current.Paused {
+		message += ", paused"
+	}
+	if current.Offline {
+		message += ", offline"
+	}
+
+	e.logger.Info(message,
+		zap.Duration("interval", interval),
+		zap.Int64("files_uploaded", uploaded),
+		zap.Int64("bytes_uploaded", uploadedBytes),
+		zap.Int64("files_downloaded", downloaded),
+		zap.Int64("bytes_downloaded", downloadedBytes),
+		zap.Int64("errors", failures),
+		zap.Int("queued_uploads", activity.QueuedUploads),
+		zap.Int("queued_downloads", activity.QueuedDownloads),
+		zap.Int("offline_queued", current.OfflineQueued),
+		zap.Int("held_events", held),
+		zap.Int("transfers_in_progress", len(activity.Transfers)))
+}
@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// throttleTick is how often deferred uploads are checked
+const throttleTick = time.Second
+
+// throttledUpload is a realtime upload waiting for its file to settle or
+// for the minimum interval since its previous upload to pass
+type throttledUpload struct {
+	task        syncTask
+	lastChange  time.Time
+	quietTime   time.Duration
+	minInterval time.Duration
+}
+
+// throttleSettings returns the quiet time and minimum upload interval that
+// apply to path
+func throttleSettings(throttle interfaces.Throttle, path string) (quietTime, minInterval time.Duration) {
+	minInterval = throttle.MinInterval
+	name := filepath.Base(path)
+	for _, rule := range throttle.Rules {
+		if matched, _ := filepath.Match(rule.Pattern, name); matched {
+			if rule.MinInterval > 0 {
+				minInterval = rule.MinInterval
+			}
+			return rule.QuietTime, minInterval
+		}
+	}
+	return 0, minInterval
+}
+
+// deferUpload holds a realtime upload back when the throttle of its
+// directory applies to the file, reporting whether it did. Later changes
+// to a held file replace the task and restart its quiet time.
+func (e *Engine) deferUpload(ctx context.Context, task syncTask, throttle interfaces.Throttle) bool {
+	if throttle.IsZero() {
+		return false
+	}
+	quietTime, minInterval := throttleSettings(throttle, task.localPath)
+	if quietTime <= 0 && minInterval <= 0 {
+		return false
+	}
+
+	e.throttleMutex.Lock()
+	defer e.throttleMutex.Unlock()
+
+	e.throttled[task.localPath] = &throttledUpload{
+		task:        task,
+		lastChange:  e.clock.Now(),
+		quietTime:   quietTime,
+		minInterval: minInterval,
+	}
+	if !e.throttleRunning {
+		e.throttleRunning = true
+		e.wg.Add(1)
+		go e.throttleWorker(ctx)
+	}
+	return true
+}
+
+// throttleWorker queues deferred uploads once they are due
+func (e *Engine) throttleWorker(ctx context.Context) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(throttleTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			e.releaseThrottled(ctx)
+		}
+	}
+}
+
+// releaseThrottled queues the deferred uploads that are due and forgets
+// upload times older than their minimum interval
+func (e *Engine) releaseThrottled(ctx context.Context) {
+	now := e.clock.Now()
+
+	e.throttleMutex.Lock()
+	var due []*throttledUpload
+	for path, upload := range e.throttled {
+		if now.Before(upload.lastChange.Add(upload.quietTime)) {
+			continue
+		}
+		if next, ok := e.nextUploadAllowed[path]; ok && now.Before(next) {
+			continue
+		}
+		delete(e.throttled, path)
+		due = append(due, upload)
+	}
+	for path, next := range e.nextUploadAllowed {
+		if !now.Before(next) {
+			delete(e.nextUploadAllowed, path)
+		}
+	}
+	e.throttleMutex.Unlock()
+
+	for _, upload := range due {
+		path := upload.task.localPath
+		info, err := e.fs.Stat(path)
+		if err != nil {
+			e.logger.Debug("Dropping deferred upload of missing file",
+				zap.String("local_path", path),
+				errorField(err))
+			continue
+		}
+		upload.task.fileInfo = info
+
+		queued, err := e.enqueueUpload(ctx, upload.task, false)
+		if err == errUploadQueueFull {
+			// Retried on the next tick
+			e.throttleMutex.Lock()
+			if _, changed := e.throttled[path]; !changed {
+				e.throttled[path] = upload
+			}
+			e.throttleMutex.Unlock()
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		if upload.minInterval > 0 {
+			e.throttleMutex.Lock()
+			e.nextUploadAllowed[path] = now.Add(upload.minInterval)
+			e.throttleMutex.Unlock()
+		}
+		if queued {
+			e.logger.Info("Queued deferred upload",
+				zap.String("local_path", path),
+				zap.String("remote_path", upload.task.remotePath))
+		}
+	}
+}
+
+// DeferredUploads returns the number of realtime uploads currently held
+// back by a throttle
+func (e *Engine) DeferredUploads() int {
+	e.throttleMutex.Lock()
+	defer e.throttleMutex.Unlock()
+	return len(e.throttled)
+}
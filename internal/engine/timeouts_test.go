@@ -0,0 +1,65 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+func TestListContextBoundsPages(t *testing.T) {
+	te := newTestEngine(t)
+	te.SetTimeouts(30*time.Second, 0)
+
+	listCtx, cancel := te.listContext(context.Background())
+	defer cancel()
+	if _, ok := listCtx.Deadline(); ok {
+		t.Error("listing has an overall deadline")
+	}
+
+	pageCtx, pageCancel := interfaces.PageContext(listCtx)
+	defer pageCancel()
+	deadline, ok := pageCtx.Deadline()
+	if !ok {
+		t.Fatal("page request has no deadline")
+	}
+	if remaining := time.Until(deadline); remaining <= 0 || remaining > 30*time.Second {
+		t.Errorf("page deadline in %v, want within the operation timeout", remaining)
+	}
+
+	cancel()
+	if pageCtx.Err() == nil {
+		t.Error("cancelling the listing did not cancel its page request")
+	}
+
+	te.SetTimeouts(0, 0)
+	unbounded, unboundedCancel := te.listContext(context.Background())
+	defer unboundedCancel()
+	pageCtx, pageCancel = interfaces.PageContext(unbounded)
+	defer pageCancel()
+	if _, ok := pageCtx.Deadline(); ok {
+		t.Error("page request has a deadline without an operation timeout")
+	}
+}
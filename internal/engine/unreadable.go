@@ -0,0 +1,123 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"CloudAWSync/internal/audit"
+
+	"go.uber.org/zap"
+)
+
+// errUnreadable marks upload failures caused by the local file not being
+// readable. They are skipped without retries until the next scan.
+var errUnreadable = errors.New("local file unreadable")
+
+// markUnreadable records a path skipped because it cannot be read. Only
+// the first failure of a path is logged and audited.
+func (e *Engine) markUnreadable(ctx context.Context, path string, err error) {
+	e.unreadableMutex.Lock()
+	_, known := e.unreadable[path]
+	e.unreadable[path] = err.Error()
+	count := len(e.unreadable)
+	e.unreadableMutex.Unlock()
+
+	if known {
+		return
+	}
+
+	e.logger.Warn("Skipping unreadable path",
+		zap.String("path", path),
+		errorField(err))
+	e.audit(ctx, audit.Entry{
+		Action:    "skip",
+		LocalPath: path,
+		Reason:    "unreadable",
+		Error:     err.Error(),
+	})
+	e.recordUnreadable(count)
+}
+
+// clearUnreadable forgets a path once it has been read successfully
+func (e *Engine) clearUnreadable(path string) {
+	e.unreadableMutex.Lock()
+	_, known := e.unreadable[path]
+	delete(e.unreadable, path)
+	count := len(e.unreadable)
+	e.unreadableMutex.Unlock()
+
+	if !known {
+		return
+	}
+
+	e.logger.Info("Previously unreadable path is readable again", zap.String("path", path))
+	e.recordUnreadable(count)
+}
+
+// pruneUnreadable forgets unreadable paths below root that no longer exist
+func (e *Engine) pruneUnreadable(root string) {
+	e.unreadableMutex.Lock()
+	var paths []string
+	for path := range e.unreadable {
+		if withinDirectory(path, root) {
+			paths = append(paths, path)
+		}
+	}
+	e.unreadableMutex.Unlock()
+
+	for _, path := range paths {
+		if _, err := e.fs.Lstat(path); os.IsNotExist(err) {
+			e.unreadableMutex.Lock()
+			delete(e.unreadable, path)
+			count := len(e.unreadable)
+			e.unreadableMutex.Unlock()
+			e.recordUnreadable(count)
+		}
+	}
+}
+
+// recordUnreadable publishes the number of unreadable paths
+func (e *Engine) recordUnreadable(count int) {
+	e.mutex.Lock()
+	e.stats.UnreadableFiles = count
+	e.mutex.Unlock()
+	e.metrics.RecordUnreadableFiles(count)
+}
+
+// UnreadableFiles returns the local paths currently skipped because they
+// cannot be read, with the error of the last attempt, optionally limited to
+// those below root
+func (e *Engine) UnreadableFiles(root string) map[string]string {
+	e.unreadableMutex.Lock()
+	defer e.unreadableMutex.Unlock()
+
+	paths := make(map[string]string)
+	for path, err := range e.unreadable {
+		if root == "" || withinDirectory(path, root) {
+			paths[path] = err
+		}
+	}
+	return paths
+}
@@ -0,0 +1,190 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"slices"
+	"sync"
+)
+
+// uploadQueueSize is the number of uploads that may wait in the queue
+const uploadQueueSize = 100
+
+// fairQueue holds queued uploads in one FIFO per sync directory and hands
+// them to workers in weighted round-robin order, so that a directory with
+// many queued files cannot occupy every worker while others wait. A
+// directory may also be limited to a number of concurrent uploads.
+type fairQueue struct {
+	mutex    sync.Mutex
+	queues   map[string][]syncTask // by root path
+	roots    []string              // roots with queued tasks, in turn order
+	turn     int                   // index in roots of the directory being served
+	served   int                   // tasks taken from roots[turn] this turn
+	running  map[string]int        // tasks taken and not yet done, by root path
+	policies map[string]fairPolicy
+	size     int
+	capacity int
+	closed   bool
+	changed  chan struct{} // closed and replaced when a task or room may be available
+}
+
+// fairPolicy is the share of the upload workers a directory receives
+type fairPolicy struct {
+	weight int // tasks taken per turn
+	limit  int // concurrent uploads, 0 = unlimited
+}
+
+// newFairQueue creates an empty queue holding up to capacity tasks
+func newFairQueue(capacity int) *fairQueue {
+	return &fairQueue{
+		queues:   make(map[string][]syncTask),
+		running:  make(map[string]int),
+		policies: make(map[string]fairPolicy),
+		capacity: capacity,
+		changed:  make(chan struct{}),
+	}
+}
+
+// setPolicy sets how many tasks of the directory at root are taken per
+// turn and how many may run at once. Weights below 1 count as 1 and
+// limits below 1 leave the directory unlimited.
+func (q *fairQueue) setPolicy(root string, weight, limit int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.policies[root] = fairPolicy{weight: max(weight, 1), limit: max(limit, 0)}
+	q.broadcast()
+}
+
+// removePolicy forgets the policy of the directory at root
+func (q *fairQueue) removePolicy(root string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.policies, root)
+	q.broadcast()
+}
+
+// push adds task unless the queue is full or closed. When it is not added,
+// wait is closed once room may be available.
+func (q *fairQueue) push(task syncTask) (pushed bool, wait <-chan struct{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.size >= q.capacity {
+		return false, q.changed
+	}
+	if len(q.queues[task.rootPath]) == 0 {
+		q.roots = append(q.roots, task.rootPath)
+	}
+	q.queues[task.rootPath] = append(q.queues[task.rootPath], task)
+	q.size++
+	q.broadcast()
+	return true, nil
+}
+
+// take removes the next task in turn order, skipping directories at their
+// concurrency limit. The task must be released with done. When no task can
+// be taken, wait is closed once one may be available, or is nil if the
+// queue has been closed.
+func (q *fairQueue) take() (task syncTask, ok bool, wait <-chan struct{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return syncTask{}, false, nil
+	}
+	if len(q.roots) == 0 {
+		return syncTask{}, false, q.changed
+	}
+
+	// One extra step lets a single directory start a new turn
+	for range len(q.roots) + 1 {
+		root := q.roots[q.turn]
+		policy := q.policy(root)
+		if q.served >= policy.weight || (policy.limit > 0 && q.running[root] >= policy.limit) {
+			q.turn = (q.turn + 1) % len(q.roots)
+			q.served = 0
+			continue
+		}
+
+		task = q.queues[root][0]
+		q.queues[root] = q.queues[root][1:]
+		q.size--
+		q.served++
+		q.running[root]++
+		if len(q.queues[root]) == 0 {
+			delete(q.queues, root)
+			q.roots = slices.Delete(q.roots, q.turn, q.turn+1)
+			q.served = 0
+			if q.turn >= len(q.roots) {
+				q.turn = 0
+			}
+		}
+		q.broadcast()
+		return task, true, nil
+	}
+	return syncTask{}, false, q.changed
+}
+
+// done releases a task of the directory at root taken by take
+func (q *fairQueue) done(root string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.running[root]--
+	if q.running[root] <= 0 {
+		delete(q.running, root)
+	}
+	if q.policy(root).limit > 0 {
+		q.broadcast()
+	}
+}
+
+// len returns the number of queued tasks
+func (q *fairQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+// close wakes every waiting worker and makes take report the queue closed
+func (q *fairQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.broadcast()
+}
+
+// policy returns the policy of the directory at root; directories without
+// one get a weight of 1 and no limit. The caller must hold q.mutex.
+func (q *fairQueue) policy(root string) fairPolicy {
+	if policy, ok := q.policies[root]; ok {
+		return policy
+	}
+	return fairPolicy{weight: 1}
+}
+
+// broadcast wakes everything waiting on the queue. The caller must hold
+// q.mutex.
+func (q *fairQueue) broadcast() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,230 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// VerifyReport describes the differences between a local directory and
+// its remote copy found by Verify
+type VerifyReport struct {
+	LocalPath    string
+	RemotePath   string
+	Matched      int
+	Missing      []string // local files with no remote object
+	Extra        []string // remote objects with no local file
+	Corrupted    []string // remote checksum differs from local content
+	Unverifiable []string // remote object has no usable checksum
+	Errors       []string // local files that could not be hashed
+	Manifest     string   // generation of the manifest compared against, empty when listed
+	Duration     time.Duration
+}
+
+// OK reports whether the local and remote trees match
+func (r *VerifyReport) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 &&
+		len(r.Corrupted) == 0 && len(r.Errors) == 0
+}
+
+// Verify compares a local directory against its remote copy by hashing
+// every local file and checking it against the remote checksum. Nothing
+// is transferred.
+func (e *Engine) Verify(ctx context.Context, dir interfaces.SyncDirectory) (*VerifyReport, error) {
+	start := e.clock.Now()
+	report := &VerifyReport{LocalPath: dir.LocalPath, RemotePath: dir.RemotePath}
+
+	listCtx, cancel := e.listContext(ctx)
+	remoteFiles, err := e.provider.List(listCtx, remoteDirPrefix(dir))
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remote files: %w", err)
+	}
+	e.recordRequests(dir.LocalPath, 0, 0, int64(len(remoteFiles)/1000+1), 0)
+
+	remoteFileMap := make(map[string]interfaces.FileInfo, len(remoteFiles))
+	for _, info := range remoteFiles {
+		if !info.IsDir {
+			remoteFileMap[info.Key] = info
+		}
+	}
+
+	if err := e.compareRemote(ctx, dir, remoteFileMap, report, start); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// compareRemote hashes the directory's local files, checks them against
+// remoteFileMap and completes the report
+func (e *Engine) compareRemote(ctx context.Context, dir interfaces.SyncDirectory, remoteFileMap map[string]interfaces.FileInfo, report *VerifyReport, start time.Time) error {
+	err := e.walkLocalFiles(ctx, dir.LocalPath, dir.Recursive, func(localPath string, info os.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if IsStub(localPath) {
+			// The remote object is the only copy of an archived file
+			original := strings.TrimSuffix(localPath, StubSuffix)
+			if remotePath, _, err := e.remoteKey(dir, original); err == nil {
+				delete(remoteFileMap, remotePath)
+			}
+			return nil
+		}
+		if !e.shouldSyncFile(localPath, dir) || e.excludedByRules(info, dir.FileRules) != "" {
+			return nil
+		}
+
+		remotePath, _, err := e.remoteKey(dir, localPath)
+		remoteInfo, exists := remoteFileMap[remotePath]
+		if err != nil || !exists {
+			report.Missing = append(report.Missing, localPath)
+			return nil
+		}
+		delete(remoteFileMap, remotePath)
+
+		remoteHash := remoteInfo.MD5Hash
+		if remoteHash == "" {
+			// Multipart uploads need a HEAD request to read the stored hash
+			opCtx, cancel := e.operationContext(ctx)
+			metadata, err := e.provider.GetMetadata(opCtx, remotePath)
+			cancel()
+			e.recordRequests(dir.LocalPath, 0, 1, 0, 0)
+			if err == nil {
+				remoteHash = metadata.MD5Hash
+			}
+		}
+		if remoteHash == "" {
+			report.Unverifiable = append(report.Unverifiable, localPath)
+			return nil
+		}
+
+		if e.contentFiltered(dir, localPath) {
+			// Filtered uploads differ from the file, so the remote
+			// checksum is compared with the one recorded at upload
+			record, ok := e.recordedUpload(remotePath)
+			if !ok || !record.Filtered || record.SourceSize != info.Size() || !record.ModTime.Equal(info.ModTime()) {
+				report.Unverifiable = append(report.Unverifiable, localPath)
+			} else if record.MD5Hash != remoteHash {
+				report.Corrupted = append(report.Corrupted, localPath)
+			} else {
+				report.Matched++
+			}
+			return nil
+		}
+
+		localHash, err := e.hashFile(localPath)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", localPath, err))
+			return nil
+		}
+
+		if localHash != remoteHash {
+			report.Corrupted = append(report.Corrupted, localPath)
+		} else {
+			report.Matched++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get local files: %w", err)
+	}
+
+	for key := range remoteFileMap {
+		report.Extra = append(report.Extra, key)
+	}
+
+	sort.Strings(report.Missing)
+	sort.Strings(report.Extra)
+	sort.Strings(report.Corrupted)
+	sort.Strings(report.Unverifiable)
+	sort.Strings(report.Errors)
+	report.Duration = e.clock.Now().Sub(start)
+
+	e.metrics.RecordFileOperation("verify", report.Duration, report.OK())
+	e.logVerifyReport(report)
+	return nil
+}
+
+// logVerifyReport logs a summary of a verification run
+func (e *Engine) logVerifyReport(report *VerifyReport) {
+	fields := []zap.Field{
+		zap.String("local_path", report.LocalPath),
+		zap.String("remote_path", report.RemotePath),
+		zap.String("manifest", report.Manifest),
+		zap.Int("matched", report.Matched),
+		zap.Int("missing", len(report.Missing)),
+		zap.Int("extra", len(report.Extra)),
+		zap.Int("corrupted", len(report.Corrupted)),
+		zap.Int("unverifiable", len(report.Unverifiable)),
+		zap.Int("errors", len(report.Errors)),
+		zap.Duration("duration", report.Duration),
+	}
+
+	if report.OK() {
+		e.logger.Info("Verification passed", fields...)
+		return
+	}
+
+	e.logger.Warn("Verification found differences", fields...)
+	for _, path := range report.Corrupted {
+		e.logger.Warn("Checksum mismatch", zap.String("path", path))
+	}
+}
+
+// verifyWorker periodically verifies a directory (scrub)
+func (e *Engine) verifyWorker(ctx context.Context, dir interfaces.SyncDirectory) {
+	defer e.wg.Done()
+
+	ticker := e.clock.NewTicker(dir.VerifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stopChan:
+			return
+		case <-ticker.C():
+			current, ok := e.configuredDirectory(dir.LocalPath)
+			if !ok {
+				return
+			}
+			if !current.Enabled {
+				continue
+			}
+			if _, err := e.Verify(ctx, dir); err != nil {
+				e.logger.Error("Scheduled verification failed",
+					zap.String("directory", dir.LocalPath),
+					errorField(err))
+			}
+		}
+	}
+}
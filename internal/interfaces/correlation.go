@@ -0,0 +1,60 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package interfaces
+
+import (
+	"context"
+	"errors"
+)
+
+// syncIDKey is the context key of the sync correlation ID
+type syncIDKey struct{}
+
+// WithSyncID returns a copy of ctx carrying id, the correlation ID of the
+// sync the work done with it belongs to
+func WithSyncID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, syncIDKey{}, id)
+}
+
+// SyncID returns the sync correlation ID carried by ctx, or "" if none
+func SyncID(ctx context.Context) string {
+	id, _ := ctx.Value(syncIDKey{}).(string)
+	return id
+}
+
+// requestIDError is implemented by errors of storage API calls that
+// carry the identifiers the service assigned to the request
+type requestIDError interface {
+	ServiceRequestID() string
+	ServiceHostID() string
+}
+
+// RequestIDs returns the request ID and extended request ID the storage
+// service assigned to the failed call err comes from, which appear in its
+// server access logs, or empty strings when err carries none
+func RequestIDs(err error) (requestID, extendedRequestID string) {
+	var withIDs requestIDError
+	if !errors.As(err, &withIDs) {
+		return "", ""
+	}
+	return withIDs.ServiceRequestID(), withIDs.ServiceHostID()
+}
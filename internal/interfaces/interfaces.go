@@ -23,17 +23,33 @@ package interfaces
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"time"
 )
 
+// ProgressFunc is called as a transfer moves data with the number of bytes
+// moved since the previous call. It runs on the transferring goroutine, so
+// a callback that blocks slows the transfer down.
+type ProgressFunc func(n int64)
+
+// TransferOptions adjusts a single upload or download
+type TransferOptions struct {
+	Progress ProgressFunc // optional
+}
+
 // CloudProvider defines the interface for cloud storage providers
 type CloudProvider interface {
 	// Upload uploads a file to the cloud storage
-	Upload(ctx context.Context, key string, reader io.Reader, metadata FileMetadata) error
+	Upload(ctx context.Context, key string, reader io.Reader, metadata FileMetadata, options TransferOptions) error
 
-	// Download downloads a file from the cloud storage
-	Download(ctx context.Context, key string) (io.ReadCloser, FileMetadata, error)
+	// Download downloads a file from the cloud storage. Progress is
+	// reported as the returned body is read.
+	Download(ctx context.Context, key string, options TransferOptions) (io.ReadCloser, FileMetadata, error)
 
 	// Delete removes a file from the cloud storage
 	Delete(ctx context.Context, key string) error
@@ -46,6 +62,151 @@ type CloudProvider interface {
 
 	// Exists checks if a file exists in the cloud storage
 	Exists(ctx context.Context, key string) (bool, error)
+
+	// StorageUsage returns the total size and object count under a prefix
+	StorageUsage(ctx context.Context, prefix string) (StorageUsage, error)
+}
+
+// VersionedProvider is implemented by providers that can list and delete
+// individual versions of objects in a versioned bucket
+type VersionedProvider interface {
+	// ListVersions lists every version of the objects under prefix
+	ListVersions(ctx context.Context, prefix string) ([]ObjectVersion, error)
+
+	// DeleteVersion permanently deletes one version of an object
+	DeleteVersion(ctx context.Context, key, versionID string) error
+}
+
+// CopyProvider is implemented by providers that can copy an object
+// without transferring its content through the client
+type CopyProvider interface {
+	// Copy duplicates the object at srcKey to dstKey, including its metadata
+	Copy(ctx context.Context, srcKey, dstKey string) error
+}
+
+// MultipartProvider is implemented by providers that can upload an object
+// in parts, so an interrupted upload of a large file can continue from the
+// parts already stored, also on another host
+type MultipartProvider interface {
+	// CreateMultipartUpload starts an upload of the object at key with
+	// metadata and returns its ID
+	CreateMultipartUpload(ctx context.Context, key string, metadata FileMetadata) (string, error)
+
+	// UploadPart stores part number, counted from 1, of an upload and
+	// returns its ETag. Progress is reported as body is sent.
+	UploadPart(ctx context.Context, key, uploadID string, number int, body io.ReadSeeker, size int64, options TransferOptions) (string, error)
+
+	// ListParts returns the stored parts of an upload. It fails with
+	// ErrNotFound once the upload was completed or aborted.
+	ListParts(ctx context.Context, key, uploadID string) ([]UploadedPart, error)
+
+	// CompleteMultipartUpload assembles the parts, sorted by number, into
+	// the object
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []UploadedPart) error
+
+	// AbortMultipartUpload discards an upload and its stored parts
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// UploadedPart describes a stored part of a multipart upload
+type UploadedPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// RangeProvider is implemented by providers that can download part of an
+// object, letting large downloads be split into parallel requests
+type RangeProvider interface {
+	// DownloadRange returns length bytes of the object at key starting at
+	// offset. Progress is reported as the returned body is read.
+	DownloadRange(ctx context.Context, key string, offset, length int64, options TransferOptions) (io.ReadCloser, error)
+}
+
+// MirrorProvider is implemented by providers that write every object to
+// several backends and can fill in the copies a backend missed
+type MirrorProvider interface {
+	// RepairMirrors copies the objects missing from some backends from a
+	// backend holding them, returning the number of objects repaired
+	RepairMirrors(ctx context.Context) (int, error)
+}
+
+// ConditionalProvider is implemented by providers that can skip returning
+// an object's metadata when it has not changed
+type ConditionalProvider interface {
+	// GetMetadataIfChanged returns the object's metadata and true, or false
+	// without metadata when its ETag still equals etag
+	GetMetadataIfChanged(ctx context.Context, key, etag string) (FileMetadata, bool, error)
+}
+
+// RestoreProvider is implemented by providers with archival storage
+// classes, whose objects must be restored before they can be read
+type RestoreProvider interface {
+	// Restore requests a readable copy of an archived object, kept for
+	// days and retrieved with tier (Expedited, Standard or Bulk). A
+	// restore already in progress is not an error.
+	Restore(ctx context.Context, key string, days int, tier string) error
+}
+
+// ErrObjectArchived is returned when reading an object whose content is in
+// archival storage and has not been restored
+var ErrObjectArchived = errors.New("object is in archival storage and must be restored first")
+
+// ClockSkewProvider is implemented by providers that measure the clock of
+// the storage service, whose timestamps are compared with local ones
+type ClockSkewProvider interface {
+	// ClockSkew returns how far the service's clock is ahead of the local
+	// clock, and false until a response has been received
+	ClockSkew() (time.Duration, bool)
+}
+
+// ErrCircuitOpen is returned without contacting the storage service while
+// a backend's circuit breaker is open after repeated failures
+var ErrCircuitOpen = errors.New("circuit breaker open, storage backend failing")
+
+// Kinds of storage errors. Every provider wraps the service's error with
+// one of them where it applies, so the engine's retries, quarantine and
+// circuit breakers can branch on the kind with errors.Is whatever the
+// provider.
+var (
+	// ErrNotFound is returned for objects, versions or buckets that do not
+	// exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrPermissionDenied is returned for requests the credentials may not
+	// make
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrQuotaExceeded is returned for writes refused because the bucket or
+	// account has no space or quota left
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrThrottled is returned when the service asks the client to slow down
+	ErrThrottled = errors.New("request throttled")
+
+	// ErrTemporaryNetwork is returned when the service could not be reached
+	// or the connection to it failed
+	ErrTemporaryNetwork = errors.New("temporary network failure")
+)
+
+// IsPermanent reports whether err is an answer of the storage service
+// refusing the request itself, which fails again when retried unchanged
+// and says nothing about the health of the service
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrPermissionDenied) || errors.Is(err, ErrQuotaExceeded)
+}
+
+// IsTransient reports whether err is a failure of the storage service or
+// the way to it rather than of the request, which may succeed later
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrThrottled) || errors.Is(err, ErrTemporaryNetwork) || errors.Is(err, ErrCircuitOpen)
+}
+
+// HealthProvider is implemented by providers that switch between
+// backends and can report which one is in use
+type HealthProvider interface {
+	// Health reports the backend in use and recent failures
+	Health() ProviderHealth
 }
 
 // FileWatcher defines the interface for file system watchers
@@ -57,6 +218,108 @@ type FileWatcher interface {
 	Stop() error
 }
 
+// DirectoryWatcher is implemented by file watchers that can change the
+// watched directories after Watch was called
+type DirectoryWatcher interface {
+	// AddDirectory starts watching dir, sending its events to the channel
+	// returned by Watch
+	AddDirectory(dir string) error
+
+	// RemoveDirectory stops watching dir and its subdirectories
+	RemoveDirectory(dir string) error
+}
+
+// Clock is the source of time for the sync engine
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a ticker firing every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks at intervals
+type Ticker interface {
+	// C returns the channel on which ticks are delivered
+	C() <-chan time.Time
+
+	// Stop turns off the ticker
+	Stop()
+}
+
+// FileSystem is the local file system seen by the sync engine
+type FileSystem interface {
+	// Open opens a file for reading
+	Open(name string) (File, error)
+
+	// Create creates or truncates a file for writing
+	Create(name string) (File, error)
+
+	// CreateTemp creates a new file for writing in dir, named after pattern
+	// with its last "*" replaced by a random string. It fails rather than
+	// open an existing file or follow a symbolic link.
+	CreateTemp(dir, pattern string) (File, error)
+
+	// Stat returns file information, following symbolic links
+	Stat(name string) (os.FileInfo, error)
+
+	// Lstat returns file information without following symbolic links
+	Lstat(name string) (os.FileInfo, error)
+
+	// ReadDir returns the entries of a directory sorted by name
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// MkdirAll creates a directory and any missing parents
+	MkdirAll(path string, perm os.FileMode) error
+
+	// Remove removes a file or empty directory
+	Remove(name string) error
+
+	// Rename moves a file
+	Rename(oldpath, newpath string) error
+
+	// Chtimes changes the access and modification times of a file
+	Chtimes(name string, atime, mtime time.Time) error
+
+	// Chmod changes the permission bits of a file
+	Chmod(name string, mode os.FileMode) error
+}
+
+// OwnerFileSystem is implemented by file systems that can change the owner
+// of the files they create
+type OwnerFileSystem interface {
+	// Lchown changes the owner of a file without following symbolic links
+	Lchown(name string, uid, gid int) error
+}
+
+// File is an open file of a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+
+	// Name returns the name the file was opened with
+	Name() string
+
+	// Stat returns information about the open file
+	Stat() (os.FileInfo, error)
+}
+
+// MalwareScanner checks content for malware, such as a ClamAV daemon
+type MalwareScanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanResult is the outcome of a malware scan
+type ScanResult struct {
+	Infected  bool
+	Signature string // name of the malware found
+}
+
 // SyncEngine defines the interface for synchronization engines
 type SyncEngine interface {
 	// Sync performs synchronization for the specified directory
@@ -92,6 +355,58 @@ type MetricsCollector interface {
 	// RecordCPUUsage records CPU usage
 	RecordCPUUsage(percent float64)
 
+	// RecordTransferStall records a transfer aborted for being too slow
+	RecordTransferStall(direction string)
+
+	// RecordStorageUsage records remote storage usage and quota state for a scope
+	RecordStorageUsage(scope string, usage StorageUsage, quotaExceeded bool)
+
+	// RecordEstimatedCost records the estimated month-to-date spend for a scope
+	RecordEstimatedCost(scope string, dollars float64)
+
+	// RecordScrubResult records the number of problems of each kind found by
+	// the last remote integrity scrub
+	RecordScrubResult(issues map[string]int)
+
+	// RecordConcurrency records the number of transfer workers running in a
+	// direction (upload or download)
+	RecordConcurrency(direction string, workers int)
+
+	// RecordUnreadableFiles records how many local files or directories are
+	// currently skipped because they cannot be read
+	RecordUnreadableFiles(count int)
+
+	// RecordArchivedObjects records how many remote objects are in archival
+	// storage classes and how many of their restores are pending
+	RecordArchivedObjects(archived, restoresPending int)
+
+	// RecordConnectivity records whether the storage service is unreachable,
+	// how long the current outage has lasted and how many uploads are
+	// queued until it ends
+	RecordConnectivity(offline bool, outage time.Duration, queued int)
+
+	// RecordProviderRequest records one call to the storage service API
+	RecordProviderRequest(request ProviderRequest)
+
+	// RecordRequestPacing records the delay enforced between requests to a
+	// bucket that the storage service is throttling, 0 once it stops
+	RecordRequestPacing(bucket string, delay time.Duration)
+
+	// RecordWatcherEvent records a file system event received by the file
+	// watcher and whether it was filtered out
+	RecordWatcherEvent(filtered bool)
+
+	// RecordWatcherDrop records events lost before the file watcher saw
+	// them, at stage "kernel" when the kernel's event queue overflowed
+	RecordWatcherDrop(stage string)
+
+	// RecordWatcherFlush records a flush of batched watcher events
+	RecordWatcherFlush(events int)
+
+	// RecordWatches records how many directories are watched below a sync
+	// directory
+	RecordWatches(directory string, watches int)
+
 	// GetMetrics returns current metrics
 	GetMetrics() Metrics
 }
@@ -104,15 +419,40 @@ type FileMetadata struct {
 	ContentType string
 	Permissions string
 	Encrypted   bool
+	ETag        string // version token for conditional requests, empty if unknown
+	OriginalKey string // full key of an object stored under a shortened key
+
+	// SourceModTime is the modification time of the file the object was
+	// uploaded from, zero if the provider did not record it. ModTime is
+	// when the object was stored.
+	SourceModTime time.Time
+
+	StorageClass  string    // as reported by the provider, empty if unknown
+	Archived      bool      // content must be restored before it can be read
+	Restoring     bool      // a restore of the archived content is in progress
+	RestoredUntil time.Time // expiry of the readable copy of a restored object
 }
 
 // FileInfo represents information about a file
 type FileInfo struct {
-	Key     string
-	Size    int64
-	ModTime time.Time
-	MD5Hash string
-	IsDir   bool
+	Key          string
+	Size         int64
+	ModTime      time.Time
+	MD5Hash      string
+	IsDir        bool
+	StorageClass string // as reported by the provider, empty if unknown
+
+	// SourceModTime is the modification time of the uploaded file when
+	// the listing carries it, zero otherwise. S3 listings do not.
+	SourceModTime time.Time
+}
+
+// Archived reports whether the object is in an archival storage class,
+// whose content must be restored before it can be read. Listings do not
+// show restores, so the metadata of the object tells whether it is
+// readable now.
+func (f FileInfo) Archived() bool {
+	return f.StorageClass == "GLACIER" || f.StorageClass == "DEEP_ARCHIVE"
 }
 
 // FileEvent represents a file system event
@@ -125,13 +465,282 @@ type FileEvent struct {
 
 // SyncDirectory represents a directory to be synchronized
 type SyncDirectory struct {
-	LocalPath  string
-	RemotePath string
-	SyncMode   SyncMode
-	Schedule   string // cron expression for scheduled sync
-	Recursive  bool
-	Filters    []string // file patterns to include/exclude
-	Enabled    bool
+	LocalPath  string   `yaml:"local_path"`
+	RemotePath string   `yaml:"remote_path"`
+	SyncMode   SyncMode `yaml:"sync_mode"`
+	Schedule   string   `yaml:"schedule"`  // cron expression for scheduled sync
+	Recursive  bool     `yaml:"recursive"` // sync subdirectories
+	Filters    []string `yaml:"filters"`   // file patterns to include/exclude
+	Enabled    bool     `yaml:"enabled"`
+
+	Name     string   `yaml:"name,omitempty"`     // identifier other directories refer to in after
+	After    []string `yaml:"after,omitempty"`    // names or local paths of directories synced first
+	Priority int      `yaml:"priority,omitempty"` // tier of multi-directory syncs, higher tiers run first
+
+	IgnoreFiles []string `yaml:"ignore_files,omitempty"` // .stignore or rsync filter files translated into exclusions
+
+	FileRules FileRules      `yaml:"file_rules,omitempty"` // size, age and ownership limits
+	Throttle  Throttle       `yaml:"throttle,omitempty"`   // delays for frequently changing files
+	Snapshot  SnapshotPolicy `yaml:"snapshot,omitempty"`   // consistent reads of files being written
+
+	ContentFilters []ContentFilter `yaml:"content_filters,omitempty"` // transformations of uploaded content, applied in order
+	SecretScan     SecretScan      `yaml:"secret_scan,omitempty"`     // detection of secrets in uploaded content
+
+	KeyEncoding KeyEncoding `yaml:"key_encoding,omitempty"` // how file names become remote keys
+	KeyLayout   string      `yaml:"key_layout,omitempty"`   // template of keys below the remote path, default "{path}"
+	AdoptRemote bool        `yaml:"adopt_remote,omitempty"` // record remote objects with matching content instead of uploading again
+	PartialHash PartialHash `yaml:"partial_hash,omitempty"` // compare large files by their ends instead of hashing them whole
+
+	ReadOnly     bool     `yaml:"read_only,omitempty"`     // mirror the remote prefix locally and never upload
+	LocalChanges string   `yaml:"local_changes,omitempty"` // read-only directories: flag (default) or revert
+	Sparse       []string `yaml:"sparse,omitempty"`        // remote subpaths or patterns pulled by read-only and polling directories, default all
+
+	Distribution Distribution `yaml:"distribution,omitempty"` // publish or apply the directory as versioned releases
+
+	UploadWeight         int `yaml:"upload_weight,omitempty"`          // uploads taken per turn when directories share the workers, default 1
+	MaxConcurrentUploads int `yaml:"max_concurrent_uploads,omitempty"` // 0 = limited only by performance.max_concurrent_uploads
+
+	QuotaBytes   int64 `yaml:"quota_bytes,omitempty"`   // remote size limit, 0 = unlimited
+	QuotaObjects int64 `yaml:"quota_objects,omitempty"` // remote object limit, 0 = unlimited
+
+	VerifyInterval     time.Duration `yaml:"verify_interval,omitempty"`      // periodic verification (scrub), 0 = disabled
+	RemotePollInterval time.Duration `yaml:"remote_poll_interval,omitempty"` // check the remote manifest for changes, 0 = disabled
+	RescanInterval     time.Duration `yaml:"rescan_interval,omitempty"`      // full scan of a realtime directory, 0 = rescan.interval
+
+	Retention       RetentionPolicy `yaml:"retention,omitempty"`        // generations kept in backup mode
+	BackupFormat    BackupFormat    `yaml:"backup_format,omitempty"`    // how backup mode stores content
+	Parity          ParityPolicy    `yaml:"parity,omitempty"`           // erasure coding of backup content
+	RemoteRetention RemoteRetention `yaml:"remote_retention,omitempty"` // removal rules for mirrored objects
+	Deletions       DeletionPolicy  `yaml:"deletions,omitempty"`        // what full scans do with remote copies of files deleted locally
+	Archive         ArchivePolicy   `yaml:"archive,omitempty"`          // local removal after confirmed upload
+
+	RunAs string `yaml:"run_as,omitempty"` // "user" or "user:group" owning created files, when running as root
+}
+
+// IsReferencedBy reports whether ref, an entry of After, refers to d by
+// its name or its local path
+func (d SyncDirectory) IsReferencedBy(ref string) bool {
+	if d.Name != "" && ref == d.Name {
+		return true
+	}
+	return d.LocalPath != "" && filepath.Clean(ref) == filepath.Clean(d.LocalPath)
+}
+
+// FileRules limit the files of a directory that are synced by size, age
+// and ownership. Files outside any configured limit are skipped. Zero
+// values disable a rule.
+type FileRules struct {
+	MinSize        int64         `yaml:"min_size,omitempty"`        // bytes
+	MaxSize        int64         `yaml:"max_size,omitempty"`        // bytes
+	ModifiedWithin time.Duration `yaml:"modified_within,omitempty"` // skip files not modified this recently
+	OlderThan      time.Duration `yaml:"older_than,omitempty"`      // skip files modified more recently
+	Owners         []string      `yaml:"owners,omitempty"`          // user names or UIDs
+	Groups         []string      `yaml:"groups,omitempty"`          // group names or GIDs
+}
+
+// IsZero reports whether no rule is configured
+func (r FileRules) IsZero() bool {
+	return r.MinSize == 0 && r.MaxSize == 0 && r.ModifiedWithin == 0 && r.OlderThan == 0 &&
+		len(r.Owners) == 0 && len(r.Groups) == 0
+}
+
+// Key encoding modes
+const (
+	KeyEncodingNone    = ""        // names are used as keys unchanged
+	KeyEncodingPercent = "percent" // unsafe bytes become %XX
+	KeyEncodingReplace = "replace" // characters are replaced as listed in Replace
+)
+
+// KeyEncoding turns file names containing characters that break downstream
+// tools, such as newlines and control characters, into safe remote keys.
+// Keys are decoded back to the original names when read.
+type KeyEncoding struct {
+	Mode    string            `yaml:"mode,omitempty"`    // "", percent or replace
+	Replace map[string]string `yaml:"replace,omitempty"` // character -> replacement in replace mode
+}
+
+// Throttle delays realtime uploads of files that change frequently, such
+// as databases and logs, so that bursts of changes become one upload
+type Throttle struct {
+	MinInterval time.Duration  `yaml:"min_interval,omitempty"` // minimum time between uploads of the same file
+	Rules       []ThrottleRule `yaml:"rules,omitempty"`        // per pattern settings, first match wins
+}
+
+// ThrottleRule sets the throttling of files whose name matches Pattern
+type ThrottleRule struct {
+	Pattern     string        `yaml:"pattern"`                // glob matched against the file name
+	QuietTime   time.Duration `yaml:"quiet_time,omitempty"`   // upload once unchanged this long
+	MinInterval time.Duration `yaml:"min_interval,omitempty"` // overrides Throttle.MinInterval when set
+}
+
+// IsZero reports whether no throttling is configured
+func (t Throttle) IsZero() bool {
+	return t.MinInterval == 0 && len(t.Rules) == 0
+}
+
+// PartialHash lets scans compare large files whose modification time
+// changed by hashing only their size and first and last bytes against the
+// state record, instead of reading them whole. A change that leaves the
+// size and both ends intact goes unnoticed.
+type PartialHash struct {
+	MinSize    int64 `yaml:"min_size,omitempty"`    // bytes, files at least this large are hashed partially, 0 = disabled
+	SampleSize int64 `yaml:"sample_size,omitempty"` // bytes hashed at each end, default 4 MiB
+}
+
+// SnapshotMode selects how a consistent copy of a file is read for upload
+type SnapshotMode string
+
+const (
+	SnapshotModeNone    SnapshotMode = ""        // read files as they are
+	SnapshotModeLock    SnapshotMode = "lock"    // hold a shared flock while reading
+	SnapshotModeCopy    SnapshotMode = "copy"    // upload from a temporary copy
+	SnapshotModeCommand SnapshotMode = "command" // read scans from a filesystem snapshot
+)
+
+// SnapshotPolicy keeps databases and mailboxes from being uploaded while
+// half written
+type SnapshotPolicy struct {
+	Mode           SnapshotMode  `yaml:"mode,omitempty"`
+	Patterns       []string      `yaml:"patterns,omitempty"`        // files lock and copy apply to, default all
+	Command        string        `yaml:"command,omitempty"`         // creates the snapshot before each scan
+	ReleaseCommand string        `yaml:"release_command,omitempty"` // removes the previous snapshot
+	Path           string        `yaml:"path,omitempty"`            // where the snapshot of the directory appears
+	TempDir        string        `yaml:"temp_dir,omitempty"`        // where copies are staged, default system temp dir
+	Timeout        time.Duration `yaml:"timeout,omitempty"`         // lock wait and command time limit, default 1m
+}
+
+// Built-in content filters
+const (
+	ContentFilterStripGPS = "strip-gps" // removes the GPS block from the Exif data of JPEG images
+	ContentFilterRedact   = "redact"    // replaces matches of regular expressions in text, line by line
+)
+
+// ContentFilter transforms the content of matching files on their way to
+// the storage service, for example to remove location data from photos or
+// secrets from logs. The local file is not changed.
+type ContentFilter struct {
+	ContentTypes []string      `yaml:"content_types,omitempty"` // e.g. "image/jpeg" or "text/*", default all
+	Patterns     []string      `yaml:"patterns,omitempty"`      // file names, default all
+	Builtin      string        `yaml:"builtin,omitempty"`       // strip-gps or redact
+	Redact       []string      `yaml:"redact,omitempty"`        // regular expressions the redact filter replaces
+	Replacement  string        `yaml:"replacement,omitempty"`   // text replacing redacted matches, default [REDACTED]
+	Command      string        `yaml:"command,omitempty"`       // reads the content on stdin and writes the result to stdout
+	Timeout      time.Duration `yaml:"timeout,omitempty"`       // command time limit, default 1m
+}
+
+// How read-only directories handle files changed locally
+const (
+	LocalChangesFlag   = "flag"   // log, audit and publish the change, keep the file
+	LocalChangesRevert = "revert" // restore the remote version
+)
+
+// Roles of directories in a distribution
+const (
+	DistributionPublish   = "publish"   // upload the directory as versioned releases
+	DistributionSubscribe = "subscribe" // apply the current release to the directory
+)
+
+// Distribution distributes a directory to a fleet: a publisher uploads it
+// as versioned releases and subscribers replace their copy with the
+// current release in one step
+type Distribution struct {
+	Role         string        `yaml:"role,omitempty"`          // publish or subscribe
+	KeepReleases int           `yaml:"keep_releases,omitempty"` // publisher: releases kept remotely, default 5
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"` // subscriber: checks for new releases between syncs, 0 = disabled
+	PostApply    string        `yaml:"post_apply,omitempty"`    // subscriber: command run after applying a release; failure rolls back
+	Timeout      time.Duration `yaml:"timeout,omitempty"`       // post_apply time limit, default 1m
+}
+
+// Actions taken when a secret scan finds likely secrets
+const (
+	SecretScanWarn  = "warn"  // log and audit the finding, then upload
+	SecretScanBlock = "block" // do not upload the file
+)
+
+// SecretScan detects likely secrets such as cloud access keys, private
+// keys and .env assignments in files before they are uploaded
+type SecretScan struct {
+	Enabled      bool     `yaml:"enabled,omitempty"`
+	Action       string   `yaml:"action,omitempty"`        // warn (default) or block
+	Patterns     []string `yaml:"patterns,omitempty"`      // additional regular expressions reported as secrets
+	AllowPaths   []string `yaml:"allow_paths,omitempty"`   // file names or relative paths that are not scanned
+	AllowMatches []string `yaml:"allow_matches,omitempty"` // regular expressions of matches that are not secrets, e.g. example keys
+}
+
+// ArchivePolicy removes local files once their upload is confirmed,
+// keeping the only copy in remote storage
+type ArchivePolicy struct {
+	After  time.Duration `yaml:"after,omitempty"`   // minimum file age before archiving, 0 = disabled
+	Stub   bool          `yaml:"stub,omitempty"`    // leave a stub file describing the remote object
+	DryRun bool          `yaml:"dry_run,omitempty"` // report without removing files
+}
+
+// RemoteRetention describes rules for removing remote objects of a
+// mirrored directory
+type RemoteRetention struct {
+	DeleteUnseenAfter time.Duration `yaml:"delete_unseen_after,omitempty"` // delete remote files missing locally this long, 0 = never
+	KeepVersions      int           `yaml:"keep_versions,omitempty"`       // versions kept per object in versioned buckets, 0 = all
+	DryRun            bool          `yaml:"dry_run,omitempty"`             // report without deleting
+}
+
+// IsZero reports whether no retention rule is configured
+func (r RemoteRetention) IsZero() bool {
+	return r.DeleteUnseenAfter == 0 && r.KeepVersions == 0
+}
+
+// DeletionAction selects what full scans do with the remote copy of a
+// file that was uploaded and has since been deleted locally
+type DeletionAction string
+
+const (
+	DeletionIgnore DeletionAction = ""       // keep the remote copy, counted as remote-only
+	DeletionReport DeletionAction = "report" // keep the remote copy, log, audit and publish the deletion
+	DeletionTrash  DeletionAction = "trash"  // move the remote copy below the trash path
+	DeletionDelete DeletionAction = "delete" // delete the remote copy
+)
+
+// DeletionPolicy describes how a mirrored directory propagates local
+// deletions found by full scans
+type DeletionPolicy struct {
+	Action     DeletionAction `yaml:"action,omitempty"`
+	TrashPath  string         `yaml:"trash_path,omitempty"`  // remote path trashed files are moved below, default ".trash/<remote_path>"
+	MaxPercent int            `yaml:"max_percent,omitempty"` // skip a scan's deletions when more of the directory's objects are missing, default 50
+}
+
+// BackupFormat selects how backup mode stores file content
+type BackupFormat string
+
+const (
+	BackupFormatFiles   BackupFormat = ""        // one object per distinct file content
+	BackupFormatChunked BackupFormat = "chunked" // encrypted, deduplicated chunks collected into packs
+)
+
+// ParityPolicy adds Reed-Solomon parity to the content of a backup
+// directory. Content objects are grouped into stripes of up to DataShards
+// objects, each protected by ParityShards parity objects, so that any
+// ParityShards objects of a stripe can be lost or corrupted and rebuilt.
+type ParityPolicy struct {
+	DataShards   int `yaml:"data_shards,omitempty"`
+	ParityShards int `yaml:"parity_shards,omitempty"`
+}
+
+// IsZero reports whether parity is disabled
+func (p ParityPolicy) IsZero() bool {
+	return p.ParityShards == 0
+}
+
+// RetentionPolicy selects which backup generations are kept. The newest
+// generation is always kept; a zero policy keeps everything.
+type RetentionPolicy struct {
+	KeepLast    int `yaml:"keep_last,omitempty"`
+	KeepDaily   int `yaml:"keep_daily,omitempty"`
+	KeepWeekly  int `yaml:"keep_weekly,omitempty"`
+	KeepMonthly int `yaml:"keep_monthly,omitempty"`
+}
+
+// IsZero reports whether the policy keeps every generation
+func (p RetentionPolicy) IsZero() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 && p.KeepMonthly == 0
 }
 
 // SyncMode defines the synchronization mode
@@ -141,6 +750,7 @@ const (
 	SyncModeRealtime  SyncMode = "realtime"  // sync on file changes
 	SyncModeScheduled SyncMode = "scheduled" // sync on schedule
 	SyncModeBoth      SyncMode = "both"      // both realtime and scheduled
+	SyncModeBackup    SyncMode = "backup"    // point-in-time generations on schedule
 )
 
 // SyncStats represents synchronization statistics
@@ -151,19 +761,181 @@ type SyncStats struct {
 	BytesUploaded     int64
 	BytesDownloaded   int64
 	SyncErrors        int64
+	TransferStalls    int64
+	FilesAdopted      int64 // remote objects recorded as matching local files
+	UnreadableFiles   int   // local paths currently skipped as unreadable
+	ArchivedObjects   int   // remote objects in archival storage classes at the last listing
+	RestoresPending   int   // restores of archived objects requested and not yet complete
+	QuotaExceeded     bool
+	Paused            bool          // transfers paused through the control API
+	Offline           bool          // storage unreachable, uploads are queued
+	OfflineQueued     int           // uploads waiting for connectivity
+	EstimatedCost     float64       // USD, current month
+	ClockSkew         time.Duration // storage service clock ahead of the local clock
+	RemoteOnly        int           // remote objects with no local file at the last full scans
 	LastSyncTime      time.Time
 	ActiveDirectories int
+	RecentErrors      []SyncError // the errors counted in SyncErrors, oldest first
+}
+
+// ProviderHealth describes the state of a failover provider
+type ProviderHealth struct {
+	Active              string    // name of the backend in use
+	FailedOver          bool      // the secondary backend is in use
+	FailedOverAt        time.Time // zero unless failed over
+	ConsecutiveFailures int       // failed primary requests in a row
+	LastError           string    // last primary failure
+	PendingRepairs      int       // writes still to be copied to the primary
+}
+
+// CircuitHealth describes the circuit breaker of one storage backend
+type CircuitHealth struct {
+	Backend             string    // primary, secondary or a mirror name
+	State               string    // closed, open or half-open
+	OpenedAt            time.Time // zero while closed
+	ConsecutiveFailures int       // failed requests in a row
+	LastError           string    // last failure
+}
+
+// Health summarises whether the agent is able to sync
+type Health struct {
+	Status   string          // ok, degraded or unhealthy
+	Problems []string        // reasons the status is not ok
+	Provider *ProviderHealth // nil without a failover provider
+	Circuits []CircuitHealth // empty without circuit breakers
+}
+
+// SyncEventType names a kind of SyncEvent
+type SyncEventType string
+
+// Event types published by the sync engine
+const (
+	EventUploadCompleted   SyncEventType = "upload_completed"
+	EventDownloadCompleted SyncEventType = "download_completed"
+	EventTransferFailed    SyncEventType = "transfer_failed"
+	EventConflict          SyncEventType = "conflict"
+	EventSyncStarted       SyncEventType = "sync_started"
+	EventSyncCompleted     SyncEventType = "sync_completed"
+	EventSyncFailed        SyncEventType = "sync_failed"
+	EventRemoteChanged     SyncEventType = "remote_changed"
+	EventRemoteDeleted     SyncEventType = "remote_deleted"
+	EventRestoreCompleted  SyncEventType = "restore_completed"
+	EventLocalChange       SyncEventType = "local_change"
+	EventLocalDeleted      SyncEventType = "local_deleted"
+	EventReleasePublished  SyncEventType = "release_published"
+	EventReleaseApplied    SyncEventType = "release_applied"
+	EventReleaseFailed     SyncEventType = "release_failed"
+)
+
+// SyncEvent is something that happened in the sync engine
+type SyncEvent struct {
+	Type       SyncEventType
+	Time       time.Time
+	Directory  string // local path of the sync directory
+	LocalPath  string
+	RemotePath string
+	Size       int64
+	Error      string
+}
+
+// DirectoryStatus describes a sync directory and its last sync
+type DirectoryStatus struct {
+	LocalPath  string
+	RemotePath string
+	SyncMode   SyncMode
+	Enabled    bool
+	Syncing    bool      // a sync is running
+	LastSync   time.Time // end of the last sync, zero before the first
+	LastError  string    // error of the last sync, empty when it succeeded
+
+	ArchivedObjects int   // remote objects in archival storage classes at the last sync
+	RemoteOnly      int   // remote objects with no local file at the last full scan
+	RemoteOnlyBytes int64 // size of the RemoteOnly objects
+	LocalDeletions  int   // files found deleted locally whose remote copies the last full scan kept
+}
+
+// RemoteOnlyReport describes the remote objects of a directory that have
+// no local file, such as files deleted locally from an upload-only
+// directory
+type RemoteOnlyReport struct {
+	LocalPath  string
+	RemotePath string
+	Objects    int
+	Bytes      int64
+	Keys       []string // sorted, only when requested
+}
+
+// TransferStatus describes a transfer in progress
+type TransferStatus struct {
+	LocalPath   string
+	RemotePath  string
+	Direction   string // "upload" or "download"
+	Size        int64
+	Transferred int64
+	StartedAt   time.Time
+}
+
+// TransferError describes a transfer that failed after all retries
+type TransferError struct {
+	LocalPath  string
+	RemotePath string
+	Direction  string
+	Error      string
+	Timestamp  time.Time
+}
+
+// Activity is a snapshot of the work the sync engine is doing
+type Activity struct {
+	Transfers       []TransferStatus // sorted by start time
+	QueuedUploads   int
+	QueuedDownloads int
+	BytesSent       int64           // all upload attempts, including those in progress
+	BytesReceived   int64           // all download attempts, including those in progress
+	RecentErrors    []TransferError // oldest first
 }
 
 // Metrics represents system and application metrics
 type Metrics struct {
-	BandwidthUp      int64   // bytes per second
-	BandwidthDown    int64   // bytes per second
-	MemoryUsage      int64   // bytes
-	CPUUsage         float64 // percentage
-	DiskUsage        int64   // bytes
-	ActiveGoroutines int
-	SyncStats        SyncStats
+	BandwidthUp       int64   // bytes per second
+	BandwidthDown     int64   // bytes per second
+	MemoryUsage       int64   // bytes
+	CPUUsage          float64 // percentage
+	DiskUsage         int64   // bytes
+	ActiveGoroutines  int
+	ProviderRequests  int64 // storage API calls
+	ProviderErrors    int64 // storage API calls that failed
+	ProviderRetries   int64 // storage API attempts that were retried
+	ProviderThrottled int64 // storage API attempts rejected as throttled
+	WatcherEvents     int64 // file system events received by the watcher
+	WatcherDropped    int64 // kernel event queue overflows losing watcher events
+	SyncStats         SyncStats
+}
+
+// ProviderRequest describes one call to the storage service API,
+// including its retries
+type ProviderRequest struct {
+	Bucket    string
+	Operation string        // API name, e.g. PutObject
+	Duration  time.Duration // until the response headers, retries included
+	Attempts  int           // 1 when the call was not retried
+	Throttled int           // attempts rejected as throttled
+	ErrorCode string        // empty on success
+}
+
+// ObjectVersion describes one version of a remote object
+type ObjectVersion struct {
+	Key            string
+	VersionID      string
+	Size           int64
+	LastModified   time.Time
+	IsLatest       bool
+	IsDeleteMarker bool
+}
+
+// StorageUsage represents remote storage consumption
+type StorageUsage struct {
+	Bytes   int64
+	Objects int64
 }
 
 // SyncError represents a synchronization error
@@ -174,3 +946,44 @@ type SyncError struct {
 	Timestamp time.Time
 	Retries   int
 }
+
+// syncErrorJSON is the encoded form of a SyncError, with the error as text
+type syncErrorJSON struct {
+	Path      string
+	Operation string
+	Error     string
+	Timestamp time.Time
+	Retries   int
+}
+
+// MarshalJSON encodes the error as its message
+func (e SyncError) MarshalJSON() ([]byte, error) {
+	encoded := syncErrorJSON{
+		Path:      e.Path,
+		Operation: e.Operation,
+		Timestamp: e.Timestamp,
+		Retries:   e.Retries,
+	}
+	if e.Error != nil {
+		encoded.Error = e.Error.Error()
+	}
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON decodes a SyncError written by MarshalJSON
+func (e *SyncError) UnmarshalJSON(data []byte) error {
+	var decoded syncErrorJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = SyncError{
+		Path:      decoded.Path,
+		Operation: decoded.Operation,
+		Timestamp: decoded.Timestamp,
+		Retries:   decoded.Retries,
+	}
+	if decoded.Error != "" {
+		e.Error = errors.New(decoded.Error)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package interfaces
+
+import (
+	"context"
+	"time"
+)
+
+// pageTimeoutKey is the context key of the timeout of one listing page
+type pageTimeoutKey struct{}
+
+// WithPageTimeout returns a copy of ctx asking providers to bound each page
+// request of a paginated listing by timeout. A listing grows with the
+// number of objects, so a single timeout around it fails large prefixes
+// that are making steady progress.
+func WithPageTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, pageTimeoutKey{}, timeout)
+}
+
+// PageContext derives the context of one page request of a listing made
+// with ctx, applying the timeout set by WithPageTimeout if any
+func PageContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout, ok := ctx.Value(pageTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,109 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package layout expands the templates of remote paths and key layouts,
+// which place files below a directory's remote path by date, host,
+// directory or hash
+package layout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dateVariables are the key layout placeholders taken from the file's
+// modification time
+var dateVariables = []string{"yyyy", "mm", "dd", "hh"}
+
+// Expand replaces every {name} placeholder of template with its
+// value in vars, failing for names vars does not define
+func Expand(template string, vars map[string]string) (string, error) {
+	var b strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated placeholder in %q", template)
+		}
+		name := rest[start+1 : start+end]
+		value, ok := vars[name]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		b.WriteString(rest[:start])
+		b.WriteString(value)
+		rest = rest[start+end+1:]
+	}
+}
+
+// DirectoryID returns the identifier {dir_id} stands for: the directory's
+// name when it has one, otherwise a short hash of its local path that
+// stays the same across restarts
+func DirectoryID(name, localPath string) string {
+	if name != "" {
+		return name
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(localPath)))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// KeyVariables returns the placeholders of key layout templates.
+// relativePath is the file's path below the directory with slashes and
+// encoded the path with the directory's key encoding applied. Dates are
+// those of modTime in UTC.
+func KeyVariables(relativePath, encoded string, modTime time.Time, hostname, dirID string) map[string]string {
+	sum := sha256.Sum256([]byte(relativePath))
+	hash := hex.EncodeToString(sum[:])
+	modTime = modTime.UTC()
+	return map[string]string{
+		"path":     encoded,
+		"name":     path.Base(encoded),
+		"hash":     hash,
+		"hash2":    hash[:2],
+		"yyyy":     modTime.Format("2006"),
+		"mm":       modTime.Format("01"),
+		"dd":       modTime.Format("02"),
+		"hh":       modTime.Format("15"),
+		"hostname": hostname,
+		"dir_id":   dirID,
+	}
+}
+
+// UsesDate reports whether a key layout template places files by
+// their modification time
+func UsesDate(template string) bool {
+	for _, name := range dateVariables {
+		if strings.Contains(template, "{"+name+"}") {
+			return true
+		}
+	}
+	return false
+}
@@ -32,6 +32,7 @@ import (
 	"CloudAWSync/internal/interfaces"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
@@ -39,6 +40,17 @@ import (
 	"go.uber.org/zap"
 )
 
+// PrometheusOptions controls how metrics are named and labelled
+type PrometheusOptions struct {
+	Namespace    string            // prefix of every metric name, empty for none
+	ConstLabels  map[string]string // labels added to every series
+	PerDirectory bool              // export storage, quota and cost series for each directory
+	Pprof        bool              // serve runtime profiles to local clients
+}
+
+// globalScope is the storage scope covering every directory
+const globalScope = "global"
+
 // PrometheusCollector implements the MetricsCollector interface using Prometheus metrics
 type PrometheusCollector struct {
 	logger      *zap.Logger
@@ -46,6 +58,15 @@ type PrometheusCollector struct {
 	port        int
 	metricsPath string
 
+	// Metrics are registered with a registry owned by the collector so
+	// that several collectors can exist in one process
+	registry     *prometheus.Registry
+	namespace    string
+	constLabels  prometheus.Labels
+	perDirectory bool
+	pprof        bool
+	costs        map[string]float64 // estimated cost by scope when not exported per directory
+
 	// Prometheus metrics
 	bandwidthUp       prometheus.Counter
 	bandwidthDown     prometheus.Counter
@@ -63,21 +84,59 @@ type PrometheusCollector struct {
 	bytesUploaded   prometheus.Counter
 	bytesDownloaded prometheus.Counter
 	syncErrors      prometheus.Counter
+	transferStalls  *prometheus.CounterVec
+	storageBytes    *prometheus.GaugeVec
+	storageObjects  *prometheus.GaugeVec
+	quotaExceeded   *prometheus.GaugeVec
+	estimatedCost   *prometheus.GaugeVec
+	scrubIssues     *prometheus.GaugeVec
+	lastScrubTime   prometheus.Gauge
+	concurrency     *prometheus.GaugeVec
+	unreadableFiles prometheus.Gauge
+	archivedObjects prometheus.Gauge
+	restoresPending prometheus.Gauge
+	offline         prometheus.Gauge
+	offlineDuration prometheus.Gauge
+	offlineTotal    prometheus.Counter
+	offlineQueued   prometheus.Gauge
 	lastSyncTime    prometheus.Gauge
 
+	// Storage API calls by bucket and operation
+	providerRequests  *prometheus.CounterVec
+	providerDuration  *prometheus.HistogramVec
+	providerErrors    *prometheus.CounterVec
+	providerRetries   *prometheus.CounterVec
+	providerThrottled *prometheus.CounterVec
+	providerPacing    *prometheus.GaugeVec
+
+	// File watcher events and watches
+	watcherEvents   prometheus.Counter
+	watcherFiltered prometheus.Counter
+	watcherDropped  *prometheus.CounterVec
+	watcherFlushes  prometheus.Counter
+	watcherFlushed  prometheus.Counter
+	watches         *prometheus.GaugeVec
+
 	// Internal state
 	mutex           sync.RWMutex
+	lastOutage      time.Duration // outage length already added to offlineTotal
 	currentMetrics  interfaces.Metrics
 	collectInterval time.Duration
 	stopChan        chan struct{}
 }
 
 // NewPrometheusCollector creates a new Prometheus metrics collector
-func NewPrometheusCollector(logger *zap.Logger, port int, path string, collectInterval time.Duration) *PrometheusCollector {
+func NewPrometheusCollector(logger *zap.Logger, port int, path string, collectInterval time.Duration, opts PrometheusOptions) *PrometheusCollector {
 	collector := &PrometheusCollector{
 		logger:          logger,
 		port:            port,
 		metricsPath:     path,
+		registry:        prometheus.NewRegistry(),
+		namespace:       opts.Namespace,
+		constLabels:     prometheus.Labels(opts.ConstLabels),
+		perDirectory:    opts.PerDirectory,
+		pprof:           opts.Pprof,
+		costs:           make(map[string]float64),
 		collectInterval: collectInterval,
 		stopChan:        make(chan struct{}),
 	}
@@ -91,89 +150,359 @@ func NewPrometheusCollector(logger *zap.Logger, port int, path string, collectIn
 // initMetrics initializes Prometheus metrics
 func (p *PrometheusCollector) initMetrics() {
 	p.bandwidthUp = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_bandwidth_up_bytes_total",
-		Help: "Total bytes uploaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "bandwidth_up_bytes_total",
+		Help:        "Total bytes uploaded",
 	})
 
 	p.bandwidthDown = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_bandwidth_down_bytes_total",
-		Help: "Total bytes downloaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "bandwidth_down_bytes_total",
+		Help:        "Total bytes downloaded",
 	})
 
 	p.fileOperations = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "cloudawsync_file_operations_total",
-			Help: "Total file operations by type and status",
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "file_operations_total",
+			Help:        "Total file operations by type and status",
 		},
 		[]string{"operation", "status"},
 	)
 
 	p.operationDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    "cloudawsync_operation_duration_seconds",
-			Help:    "Duration of file operations in seconds",
-			Buckets: prometheus.DefBuckets,
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "operation_duration_seconds",
+			Help:        "Duration of file operations in seconds",
+			Buckets:     prometheus.DefBuckets,
 		},
 		[]string{"operation"},
 	)
 
 	p.memoryUsage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "cloudawsync_memory_usage_bytes",
-		Help: "Current memory usage in bytes",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "memory_usage_bytes",
+		Help:        "Current memory usage in bytes",
 	})
 
 	p.cpuUsage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "cloudawsync_cpu_usage_percent",
-		Help: "Current CPU usage percentage",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "cpu_usage_percent",
+		Help:        "Current CPU usage percentage",
 	})
 
 	p.diskUsage = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "cloudawsync_disk_usage_bytes",
-		Help: "Current disk usage in bytes",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "disk_usage_bytes",
+		Help:        "Current disk usage in bytes",
 	})
 
 	p.activeGoroutines = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "cloudawsync_active_goroutines",
-		Help: "Number of active goroutines",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "active_goroutines",
+		Help:        "Number of active goroutines",
 	})
 
 	p.filesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_files_uploaded_total",
-		Help: "Total files uploaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "files_uploaded_total",
+		Help:        "Total files uploaded",
 	})
 
 	p.filesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_files_downloaded_total",
-		Help: "Total files downloaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "files_downloaded_total",
+		Help:        "Total files downloaded",
 	})
 
 	p.filesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_files_deleted_total",
-		Help: "Total files deleted",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "files_deleted_total",
+		Help:        "Total files deleted",
 	})
 
 	p.bytesUploaded = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_bytes_uploaded_total",
-		Help: "Total bytes uploaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "bytes_uploaded_total",
+		Help:        "Total bytes uploaded",
 	})
 
 	p.bytesDownloaded = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_bytes_downloaded_total",
-		Help: "Total bytes downloaded",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "bytes_downloaded_total",
+		Help:        "Total bytes downloaded",
 	})
 
 	p.syncErrors = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "cloudawsync_sync_errors_total",
-		Help: "Total synchronization errors",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "sync_errors_total",
+		Help:        "Total synchronization errors",
+	})
+
+	p.transferStalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "transfer_stalls_total",
+			Help:        "Total transfers aborted for falling below the minimum speed",
+		},
+		[]string{"direction"},
+	)
+
+	p.storageBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "remote_storage_bytes",
+			Help:        "Remote storage used in bytes by scope",
+		},
+		[]string{"scope"},
+	)
+
+	p.storageObjects = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "remote_storage_objects",
+			Help:        "Remote object count by scope",
+		},
+		[]string{"scope"},
+	)
+
+	p.quotaExceeded = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "quota_exceeded",
+			Help:        "Whether the remote storage quota is exceeded (1) or not (0) by scope",
+		},
+		[]string{"scope"},
+	)
+
+	p.estimatedCost = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "estimated_cost_dollars",
+			Help:        "Estimated month-to-date S3 spend in USD by scope",
+		},
+		[]string{"scope"},
+	)
+
+	p.scrubIssues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "scrub_issues",
+			Help:        "Problems found by the last remote integrity scrub by kind",
+		},
+		[]string{"kind"},
+	)
+
+	p.concurrency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "transfer_concurrency",
+			Help:        "Number of transfer workers running by direction",
+		},
+		[]string{"direction"},
+	)
+
+	p.unreadableFiles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "unreadable_files",
+		Help:        "Number of local files and directories skipped because they cannot be read",
+	})
+
+	p.archivedObjects = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "archived_objects",
+		Help:        "Number of remote objects in archival storage classes at the last listing",
+	})
+
+	p.restoresPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "restores_pending",
+		Help:        "Number of requested restores of archived objects not yet complete",
+	})
+
+	p.offline = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "offline",
+		Help:        "Whether the storage service is unreachable (1) or not (0)",
+	})
+
+	p.offlineDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "offline_duration_seconds",
+		Help:        "Length of the current connectivity outage in seconds, 0 when online",
+	})
+
+	p.offlineTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "offline_seconds_total",
+		Help:        "Total time spent without connectivity to the storage service",
+	})
+
+	p.offlineQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "offline_queued_uploads",
+		Help:        "Number of uploads waiting for connectivity to return",
+	})
+
+	p.lastScrubTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "last_scrub_timestamp",
+		Help:        "Timestamp of the last completed remote integrity scrub",
 	})
 
 	p.lastSyncTime = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "cloudawsync_last_sync_timestamp",
-		Help: "Timestamp of last successful sync",
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "last_sync_timestamp",
+		Help:        "Timestamp of last successful sync",
+	})
+
+	p.providerRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_requests_total",
+			Help:        "Storage API calls, retries counted once",
+		},
+		[]string{"bucket", "operation"},
+	)
+
+	p.providerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_request_duration_seconds",
+			Help:        "Time until storage API calls returned a response, retries included",
+			Buckets:     []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+		},
+		[]string{"bucket", "operation"},
+	)
+
+	p.providerErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_request_errors_total",
+			Help:        "Storage API calls that failed by error code",
+		},
+		[]string{"bucket", "operation", "code"},
+	)
+
+	p.providerRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_request_retries_total",
+			Help:        "Storage API attempts that were retried",
+		},
+		[]string{"bucket", "operation"},
+	)
+
+	p.providerThrottled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_throttled_requests_total",
+			Help:        "Storage API attempts rejected as throttled, e.g. SlowDown",
+		},
+		[]string{"bucket", "operation"},
+	)
+
+	p.providerPacing = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "provider_request_delay_seconds",
+			Help:        "Delay enforced between requests to a throttling bucket",
+		},
+		[]string{"bucket"},
+	)
+
+	p.watcherEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "watcher_events_total",
+		Help:        "File system events received by the file watcher",
+	})
+
+	p.watcherFiltered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "watcher_events_filtered_total",
+		Help:        "File system events ignored by the file watcher's filters",
+	})
+
+	p.watcherDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "watcher_events_dropped_total",
+			Help:        "File system events lost before the file watcher saw them by stage",
+		},
+		[]string{"stage"},
+	)
+	// Exported from the start so increases can be alerted on
+	p.watcherDropped.WithLabelValues("kernel")
+
+	p.watcherFlushes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "watcher_batch_flushes_total",
+		Help:        "Flushes of batched file watcher events that sent at least one event",
+	})
+
+	p.watcherFlushed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   p.namespace,
+		ConstLabels: p.constLabels,
+		Name:        "watcher_batched_events_total",
+		Help:        "File watcher events sent by batch flushes",
 	})
 
-	// Register metrics with Prometheus
-	prometheus.MustRegister(
+	p.watches = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   p.namespace,
+			ConstLabels: p.constLabels,
+			Name:        "watcher_watches",
+			Help:        "Directories watched by the file watcher by sync directory",
+		},
+		[]string{"directory"},
+	)
+
+	// Register metrics with the collector's registry, along with the
+	// runtime metrics the default registry would provide
+	p.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		p.bandwidthUp,
 		p.bandwidthDown,
 		p.fileOperations,
@@ -188,7 +517,34 @@ func (p *PrometheusCollector) initMetrics() {
 		p.bytesUploaded,
 		p.bytesDownloaded,
 		p.syncErrors,
+		p.transferStalls,
+		p.storageBytes,
+		p.storageObjects,
+		p.quotaExceeded,
+		p.estimatedCost,
+		p.scrubIssues,
+		p.lastScrubTime,
+		p.concurrency,
+		p.unreadableFiles,
+		p.archivedObjects,
+		p.restoresPending,
+		p.offline,
+		p.offlineDuration,
+		p.offlineTotal,
+		p.offlineQueued,
 		p.lastSyncTime,
+		p.providerRequests,
+		p.providerDuration,
+		p.providerErrors,
+		p.providerRetries,
+		p.providerThrottled,
+		p.providerPacing,
+		p.watcherEvents,
+		p.watcherFiltered,
+		p.watcherDropped,
+		p.watcherFlushes,
+		p.watcherFlushed,
+		p.watches,
 	)
 }
 
@@ -206,7 +562,10 @@ func (p *PrometheusCollector) Start(ctx context.Context) error {
 
 	// Start HTTP server
 	mux := http.NewServeMux()
-	mux.Handle(p.metricsPath, promhttp.Handler())
+	mux.Handle(p.metricsPath, promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}))
+	if p.pprof {
+		registerPprof(mux)
+	}
 
 	p.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", p.port),
@@ -216,7 +575,8 @@ func (p *PrometheusCollector) Start(ctx context.Context) error {
 	go func() {
 		p.logger.Info("Starting metrics server",
 			zap.Int("port", p.port),
-			zap.String("path", p.metricsPath))
+			zap.String("path", p.metricsPath),
+			zap.Bool("pprof", p.pprof))
 
 		if err := p.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			p.logger.Error("Metrics server error", zap.Error(err))
@@ -313,6 +673,168 @@ func (p *PrometheusCollector) RecordCPUUsage(percent float64) {
 	p.mutex.Unlock()
 }
 
+// RecordTransferStall records a transfer aborted for being too slow
+func (p *PrometheusCollector) RecordTransferStall(direction string) {
+	p.transferStalls.WithLabelValues(direction).Inc()
+	p.mutex.Lock()
+	p.currentMetrics.SyncStats.TransferStalls++
+	p.mutex.Unlock()
+}
+
+// RecordStorageUsage records remote storage usage and quota state
+func (p *PrometheusCollector) RecordStorageUsage(scope string, usage interfaces.StorageUsage, quotaExceeded bool) {
+	if !p.perDirectory && scope != globalScope {
+		return
+	}
+	p.storageBytes.WithLabelValues(scope).Set(float64(usage.Bytes))
+	p.storageObjects.WithLabelValues(scope).Set(float64(usage.Objects))
+	exceeded := 0.0
+	if quotaExceeded {
+		exceeded = 1
+	}
+	p.quotaExceeded.WithLabelValues(scope).Set(exceeded)
+}
+
+// RecordEstimatedCost records the estimated month-to-date spend
+func (p *PrometheusCollector) RecordEstimatedCost(scope string, dollars float64) {
+	if p.perDirectory {
+		p.estimatedCost.WithLabelValues(scope).Set(dollars)
+		return
+	}
+
+	p.mutex.Lock()
+	p.costs[scope] = dollars
+	var total float64
+	for _, cost := range p.costs {
+		total += cost
+	}
+	p.mutex.Unlock()
+	p.estimatedCost.WithLabelValues("total").Set(total)
+}
+
+// RecordScrubResult records the problems found by the last integrity scrub
+func (p *PrometheusCollector) RecordScrubResult(issues map[string]int) {
+	for kind, count := range issues {
+		p.scrubIssues.WithLabelValues(kind).Set(float64(count))
+	}
+	p.lastScrubTime.SetToCurrentTime()
+}
+
+// RecordConcurrency records the number of transfer workers in a direction
+func (p *PrometheusCollector) RecordConcurrency(direction string, workers int) {
+	p.concurrency.WithLabelValues(direction).Set(float64(workers))
+}
+
+// RecordUnreadableFiles records the number of local paths skipped as unreadable
+func (p *PrometheusCollector) RecordUnreadableFiles(count int) {
+	p.unreadableFiles.Set(float64(count))
+	p.mutex.Lock()
+	p.currentMetrics.SyncStats.UnreadableFiles = count
+	p.mutex.Unlock()
+}
+
+// RecordArchivedObjects records the number of archived remote objects and
+// pending restores
+func (p *PrometheusCollector) RecordArchivedObjects(archived, restoresPending int) {
+	p.archivedObjects.Set(float64(archived))
+	p.restoresPending.Set(float64(restoresPending))
+	p.mutex.Lock()
+	p.currentMetrics.SyncStats.ArchivedObjects = archived
+	p.currentMetrics.SyncStats.RestoresPending = restoresPending
+	p.mutex.Unlock()
+}
+
+// RecordConnectivity records the connectivity state and outage length
+func (p *PrometheusCollector) RecordConnectivity(offline bool, outage time.Duration, queued int) {
+	p.mutex.Lock()
+	if outage > p.lastOutage {
+		p.offlineTotal.Add((outage - p.lastOutage).Seconds())
+	}
+	p.lastOutage = outage
+	if !offline {
+		p.lastOutage = 0
+	}
+	p.currentMetrics.SyncStats.Offline = offline
+	p.currentMetrics.SyncStats.OfflineQueued = queued
+	p.mutex.Unlock()
+
+	if offline {
+		p.offline.Set(1)
+		p.offlineDuration.Set(outage.Seconds())
+	} else {
+		p.offline.Set(0)
+		p.offlineDuration.Set(0)
+	}
+	p.offlineQueued.Set(float64(queued))
+}
+
+// RecordProviderRequest records one call to the storage service API
+func (p *PrometheusCollector) RecordProviderRequest(request interfaces.ProviderRequest) {
+	p.providerRequests.WithLabelValues(request.Bucket, request.Operation).Inc()
+	p.providerDuration.WithLabelValues(request.Bucket, request.Operation).Observe(request.Duration.Seconds())
+	if request.Attempts > 1 {
+		p.providerRetries.WithLabelValues(request.Bucket, request.Operation).Add(float64(request.Attempts - 1))
+	}
+	if request.Throttled > 0 {
+		p.providerThrottled.WithLabelValues(request.Bucket, request.Operation).Add(float64(request.Throttled))
+	}
+	if request.ErrorCode != "" {
+		p.providerErrors.WithLabelValues(request.Bucket, request.Operation, request.ErrorCode).Inc()
+	}
+
+	p.mutex.Lock()
+	p.currentMetrics.ProviderRequests++
+	p.currentMetrics.ProviderRetries += int64(request.Attempts - 1)
+	p.currentMetrics.ProviderThrottled += int64(request.Throttled)
+	if request.ErrorCode != "" {
+		p.currentMetrics.ProviderErrors++
+	}
+	p.mutex.Unlock()
+}
+
+// RecordRequestPacing records the delay enforced between throttled requests
+func (p *PrometheusCollector) RecordRequestPacing(bucket string, delay time.Duration) {
+	p.providerPacing.WithLabelValues(bucket).Set(delay.Seconds())
+}
+
+// RecordWatcherEvent records a file system event received by the watcher
+func (p *PrometheusCollector) RecordWatcherEvent(filtered bool) {
+	p.watcherEvents.Inc()
+	if filtered {
+		p.watcherFiltered.Inc()
+	}
+	p.mutex.Lock()
+	p.currentMetrics.WatcherEvents++
+	p.mutex.Unlock()
+}
+
+// RecordWatcherDrop records file system events lost before the watcher saw them
+func (p *PrometheusCollector) RecordWatcherDrop(stage string) {
+	p.watcherDropped.WithLabelValues(stage).Inc()
+	p.mutex.Lock()
+	p.currentMetrics.WatcherDropped++
+	p.mutex.Unlock()
+}
+
+// RecordWatcherFlush records a flush of batched watcher events
+func (p *PrometheusCollector) RecordWatcherFlush(events int) {
+	if events == 0 {
+		return
+	}
+	p.watcherFlushes.Inc()
+	p.watcherFlushed.Add(float64(events))
+}
+
+// RecordWatches records the directories watched below a sync directory.
+// A directory no longer watched has its series removed.
+func (p *PrometheusCollector) RecordWatches(directory string, watches int) {
+	if watches == 0 {
+		p.watches.DeleteLabelValues(directory)
+		return
+	}
+	p.watches.WithLabelValues(directory).Set(float64(watches))
+}
+
 // RecordBytesTransferred records bytes transferred for sync operations
 func (p *PrometheusCollector) RecordBytesTransferred(bytes int64, direction string) {
 	switch direction {
@@ -104,6 +104,112 @@ func (s *SimpleCollector) RecordCPUUsage(percent float64) {
 	s.mutex.Unlock()
 }
 
+// RecordTransferStall records a transfer aborted for being too slow
+func (s *SimpleCollector) RecordTransferStall(direction string) {
+	s.mutex.Lock()
+	s.metrics.SyncStats.TransferStalls++
+	s.mutex.Unlock()
+}
+
+// RecordStorageUsage records remote storage usage and quota state
+func (s *SimpleCollector) RecordStorageUsage(scope string, usage interfaces.StorageUsage, quotaExceeded bool) {
+	s.logger.Debug("Remote storage usage",
+		zap.String("scope", scope),
+		zap.Int64("bytes", usage.Bytes),
+		zap.Int64("objects", usage.Objects),
+		zap.Bool("quota_exceeded", quotaExceeded))
+}
+
+// RecordEstimatedCost records the estimated month-to-date spend
+func (s *SimpleCollector) RecordEstimatedCost(scope string, dollars float64) {
+	s.logger.Debug("Estimated cost",
+		zap.String("scope", scope),
+		zap.Float64("dollars", dollars))
+}
+
+// RecordScrubResult records the problems found by the last integrity scrub
+func (s *SimpleCollector) RecordScrubResult(issues map[string]int) {
+	fields := make([]zap.Field, 0, len(issues))
+	for kind, count := range issues {
+		fields = append(fields, zap.Int(kind, count))
+	}
+	s.logger.Debug("Scrub result", fields...)
+}
+
+// RecordConcurrency records the number of transfer workers in a direction
+func (s *SimpleCollector) RecordConcurrency(direction string, workers int) {
+	s.logger.Debug("Transfer concurrency",
+		zap.String("direction", direction),
+		zap.Int("workers", workers))
+}
+
+// RecordUnreadableFiles records the number of local paths skipped as unreadable
+func (s *SimpleCollector) RecordUnreadableFiles(count int) {
+	s.mutex.Lock()
+	s.metrics.SyncStats.UnreadableFiles = count
+	s.mutex.Unlock()
+}
+
+// RecordArchivedObjects records the number of archived remote objects and
+// pending restores
+func (s *SimpleCollector) RecordArchivedObjects(archived, restoresPending int) {
+	s.mutex.Lock()
+	s.metrics.SyncStats.ArchivedObjects = archived
+	s.metrics.SyncStats.RestoresPending = restoresPending
+	s.mutex.Unlock()
+}
+
+// RecordConnectivity records the connectivity state
+func (s *SimpleCollector) RecordConnectivity(offline bool, outage time.Duration, queued int) {
+	s.mutex.Lock()
+	s.metrics.SyncStats.Offline = offline
+	s.metrics.SyncStats.OfflineQueued = queued
+	s.mutex.Unlock()
+}
+
+// RecordProviderRequest records one call to the storage service API
+func (s *SimpleCollector) RecordProviderRequest(request interfaces.ProviderRequest) {
+	s.mutex.Lock()
+	s.metrics.ProviderRequests++
+	s.metrics.ProviderRetries += int64(request.Attempts - 1)
+	s.metrics.ProviderThrottled += int64(request.Throttled)
+	if request.ErrorCode != "" {
+		s.metrics.ProviderErrors++
+	}
+	s.mutex.Unlock()
+}
+
+// RecordRequestPacing records the delay enforced between throttled requests
+func (s *SimpleCollector) RecordRequestPacing(bucket string, delay time.Duration) {
+	s.logger.Debug("Request pacing",
+		zap.String("bucket", bucket),
+		zap.Duration("delay", delay))
+}
+
+// RecordWatcherEvent records a file system event received by the watcher
+func (s *SimpleCollector) RecordWatcherEvent(filtered bool) {
+	s.mutex.Lock()
+	s.metrics.WatcherEvents++
+	s.mutex.Unlock()
+}
+
+// RecordWatcherDrop records file system events lost before the watcher saw them
+func (s *SimpleCollector) RecordWatcherDrop(stage string) {
+	s.mutex.Lock()
+	s.metrics.WatcherDropped++
+	s.mutex.Unlock()
+}
+
+// RecordWatcherFlush records a flush of batched watcher events
+func (s *SimpleCollector) RecordWatcherFlush(events int) {}
+
+// RecordWatches records the directories watched below a sync directory
+func (s *SimpleCollector) RecordWatches(directory string, watches int) {
+	s.logger.Debug("Watched directories",
+		zap.String("directory", directory),
+		zap.Int("watches", watches))
+}
+
 // GetMetrics returns current metrics
 func (s *SimpleCollector) GetMetrics() interfaces.Metrics {
 	s.mutex.RLock()
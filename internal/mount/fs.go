@@ -0,0 +1,470 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package mount
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.uber.org/zap"
+)
+
+// Uploader queues a local file for upload to a remote key
+type Uploader interface {
+	QueueUpload(ctx context.Context, localPath, remotePath, rootPath string) error
+}
+
+// Options configures a mounted filesystem
+type Options struct {
+	Prefix    string        // remote prefix shown at the mount root
+	CacheDir  string        // holds downloaded and locally written files
+	CacheSize int64         // bytes of cached files kept, 0 = unlimited
+	ListTTL   time.Duration // how long a remote listing is reused
+
+	KeyEncoding interfaces.KeyEncoding // encoding of names in remote keys
+}
+
+// entry is a file or directory in the remote tree
+type entry struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+	local   bool // written through the mount and not yet listed remotely
+}
+
+// FS presents a remote prefix as a filesystem. Remote listings are cached
+// for ListTTL, file content is downloaded into CacheDir on first open and
+// writes are uploaded through the sync engine's upload queue on flush.
+type FS struct {
+	provider   interfaces.CloudProvider
+	uploader   Uploader
+	options    Options
+	logger     *zap.Logger
+	mountpoint string
+
+	mutex    sync.Mutex
+	entries  map[string]*entry // relative path -> entry, "" is the root
+	listedAt time.Time
+	open     map[string]int // cache files with open handles
+
+	// Remote keys of listed files by relative path, which differ from the
+	// path when names are encoded or keys shortened
+	keysMutex sync.Mutex
+	keys      map[string]string
+}
+
+// New creates a filesystem for the remote prefix in options
+func New(provider interfaces.CloudProvider, uploader Uploader, options Options, logger *zap.Logger) *FS {
+	return &FS{
+		provider: provider,
+		uploader: uploader,
+		options:  options,
+		logger:   logger,
+		entries:  map[string]*entry{"": {isDir: true}},
+		open:     make(map[string]int),
+		keys:     make(map[string]string),
+	}
+}
+
+// Mount mounts the filesystem at mountpoint and serves it until ctx is
+// cancelled or the filesystem is unmounted externally
+func (f *FS) Mount(ctx context.Context, mountpoint string) error {
+	if err := os.MkdirAll(f.options.CacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	f.mountpoint = mountpoint
+
+	if err := f.refresh(ctx, true); err != nil {
+		return err
+	}
+
+	timeout := time.Second
+	server, err := fs.Mount(mountpoint, &node{fsys: f}, &fs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName:      "cloudawsync",
+			Name:        "cloudawsync",
+			DirectMount: true, // falls back to fusermount when not privileged
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount filesystem: %w", err)
+	}
+
+	f.logger.Info("Remote prefix mounted",
+		zap.String("prefix", f.options.Prefix),
+		zap.String("mountpoint", mountpoint))
+
+	done := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		if err := server.Unmount(); err != nil {
+			return fmt.Errorf("failed to unmount filesystem: %w", err)
+		}
+		<-done
+	case <-done:
+	}
+
+	f.logger.Info("Remote prefix unmounted", zap.String("mountpoint", mountpoint))
+	return nil
+}
+
+// remoteKey returns the remote key of a path relative to the mount root
+func (f *FS) remoteKey(rel string) string {
+	f.keysMutex.Lock()
+	key, ok := f.keys[rel]
+	f.keysMutex.Unlock()
+	if ok {
+		return key
+	}
+	if encoded, err := utils.EncodeKey(rel, f.options.KeyEncoding); err == nil {
+		rel = encoded
+	}
+	if f.options.Prefix == "" {
+		return rel
+	}
+	return path.Join(f.options.Prefix, rel)
+}
+
+// cachePath returns the cache file holding the content of a remote key
+func (f *FS) cachePath(key string) string {
+	return filepath.Join(f.options.CacheDir, fmt.Sprintf("%x", md5.Sum([]byte(key))))
+}
+
+// refresh relists the remote prefix when the cached listing has expired.
+// Files written through the mount are kept until the listing shows an
+// upload at least as new as the local write.
+func (f *FS) refresh(ctx context.Context, force bool) error {
+	f.mutex.Lock()
+	fresh := !force && time.Since(f.listedAt) < f.options.ListTTL
+	f.mutex.Unlock()
+	if fresh {
+		return nil
+	}
+
+	root := ""
+	if f.options.Prefix != "" {
+		root = strings.TrimSuffix(f.options.Prefix, "/") + "/"
+	}
+	remoteFiles, err := f.provider.List(ctx, root)
+	if err != nil {
+		return fmt.Errorf("failed to list remote prefix: %w", err)
+	}
+
+	entries := map[string]*entry{"": {isDir: true}}
+	keys := make(map[string]string)
+	for _, info := range remoteFiles {
+		if info.IsDir || !strings.HasPrefix(info.Key, root) {
+			continue
+		}
+		rel := strings.TrimPrefix(info.Key, root)
+		if strings.HasSuffix(info.Key, utils.LongKeySuffix) {
+			if original, ok := f.originalKey(ctx, info.Key, root); ok {
+				rel = original
+			}
+		}
+		if decoded, err := utils.DecodeKey(rel, f.options.KeyEncoding); err == nil {
+			rel = decoded
+		}
+		if rel == "" {
+			continue
+		}
+		keys[rel] = info.Key
+		entries[rel] = &entry{size: info.Size, modTime: info.ModTime}
+		addParents(entries, rel)
+	}
+	f.keysMutex.Lock()
+	f.keys = keys
+	f.keysMutex.Unlock()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for rel, existing := range f.entries {
+		if !existing.local {
+			continue
+		}
+		if listed, ok := entries[rel]; !ok || listed.modTime.Before(existing.modTime) {
+			entries[rel] = existing
+			addParents(entries, rel)
+		}
+	}
+	f.entries = entries
+	f.listedAt = time.Now()
+	return nil
+}
+
+// originalKey returns the path relative to root of a file stored under a
+// shortened key, as recorded in the object's metadata
+func (f *FS) originalKey(ctx context.Context, key, root string) (string, bool) {
+	metadata, err := f.provider.GetMetadata(ctx, key)
+	if err != nil {
+		f.logger.Warn("Failed to read original key of shortened key",
+			zap.String("key", key),
+			zap.Error(err))
+		return "", false
+	}
+	if metadata.OriginalKey == "" || !strings.HasPrefix(metadata.OriginalKey, root) {
+		return "", false
+	}
+	return strings.TrimPrefix(metadata.OriginalKey, root), true
+}
+
+// addParents creates directory entries for every parent of rel
+func addParents(entries map[string]*entry, rel string) {
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		if _, ok := entries[dir]; ok {
+			return
+		}
+		entries[dir] = &entry{isDir: true}
+	}
+}
+
+// lookup returns a copy of the entry at rel
+func (f *FS) lookup(ctx context.Context, rel string) (entry, bool) {
+	if err := f.refresh(ctx, false); err != nil {
+		f.logger.Warn("Using stale remote listing", zap.Error(err))
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	e, ok := f.entries[rel]
+	if !ok {
+		return entry{}, false
+	}
+	return *e, true
+}
+
+// children returns the names and entries directly below dir
+func (f *FS) children(ctx context.Context, dir string) map[string]entry {
+	if err := f.refresh(ctx, false); err != nil {
+		f.logger.Warn("Using stale remote listing", zap.Error(err))
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	result := make(map[string]entry)
+	for rel, e := range f.entries {
+		if rel == "" {
+			continue
+		}
+		parent := path.Dir(rel)
+		if parent == "." {
+			parent = ""
+		}
+		if parent == dir {
+			result[path.Base(rel)] = *e
+		}
+	}
+	return result
+}
+
+// setEntry records a locally created or modified entry
+func (f *FS) setEntry(rel string, e entry) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.entries[rel] = &e
+	addParents(f.entries, rel)
+}
+
+// removeEntry forgets the entry at rel
+func (f *FS) removeEntry(rel string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.entries, rel)
+}
+
+// fetch makes sure the cache holds the current content of the file at rel
+// and returns the cache path
+func (f *FS) fetch(ctx context.Context, rel string, e entry) (string, error) {
+	key := f.remoteKey(rel)
+	cachePath := f.cachePath(key)
+
+	if info, err := os.Stat(cachePath); err == nil {
+		if info.Size() == e.size && !info.ModTime().Before(e.modTime) {
+			return cachePath, nil
+		}
+	}
+	if e.local {
+		// Written through the mount, the cache holds the only copy
+		return cachePath, nil
+	}
+
+	body, _, err := f.provider.Download(ctx, key, interfaces.TransferOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(f.options.CacheDir, ".download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Chtimes(tmp.Name(), time.Now(), e.modTime); err != nil {
+		return "", fmt.Errorf("failed to set cache file time: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		return "", fmt.Errorf("failed to store cache file: %w", err)
+	}
+
+	f.trimCache()
+	return cachePath, nil
+}
+
+// trimCache removes the least recently accessed cache files until the
+// cache fits its configured size. Open files are never removed.
+func (f *FS) trimCache() {
+	if f.options.CacheSize <= 0 {
+		return
+	}
+
+	dirEntries, err := os.ReadDir(f.options.CacheDir)
+	if err != nil {
+		f.logger.Warn("Failed to read cache directory", zap.Error(err))
+		return
+	}
+
+	type cached struct {
+		path       string
+		size       int64
+		lastAccess time.Time
+	}
+	var files []cached
+	var total int64
+	for _, dirEntry := range dirEntries {
+		if strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{
+			path:       filepath.Join(f.options.CacheDir, dirEntry.Name()),
+			size:       info.Size(),
+			lastAccess: utils.AccessTime(info),
+		})
+		total += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].lastAccess.Before(files[j].lastAccess)
+	})
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, file := range files {
+		if total <= f.options.CacheSize {
+			return
+		}
+		if f.open[file.path] > 0 || f.pendingLocal(file.path) {
+			continue
+		}
+		if err := os.Remove(file.path); err == nil {
+			total -= file.size
+		}
+	}
+}
+
+// pendingLocal reports whether a cache file holds content written through
+// the mount that is not listed remotely yet. The caller holds f.mutex.
+func (f *FS) pendingLocal(cachePath string) bool {
+	for rel, e := range f.entries {
+		if e.local && f.cachePath(f.remoteKey(rel)) == cachePath {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire and release track open handles on cache files
+func (f *FS) acquire(cachePath string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.open[cachePath]++
+}
+
+func (f *FS) release(cachePath string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.open[cachePath]--; f.open[cachePath] <= 0 {
+		delete(f.open, cachePath)
+	}
+}
+
+// writeBack queues the cached content of rel for upload
+func (f *FS) writeBack(ctx context.Context, rel, cachePath string) error {
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat cache file: %w", err)
+	}
+	f.setEntry(rel, entry{size: info.Size(), modTime: info.ModTime(), local: true})
+
+	key := f.remoteKey(rel)
+	if err := f.uploader.QueueUpload(ctx, cachePath, key, f.mountpoint); err != nil {
+		return fmt.Errorf("failed to queue upload of %s: %w", key, err)
+	}
+	f.logger.Debug("Queued write-back",
+		zap.String("remote_path", key),
+		zap.Int64("size", info.Size()))
+	return nil
+}
+
+// remove deletes the remote object and cached content of rel
+func (f *FS) remove(ctx context.Context, rel string) error {
+	key := f.remoteKey(rel)
+	if err := f.provider.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+	os.Remove(f.cachePath(key))
+	f.removeEntry(rel)
+	return nil
+}
@@ -0,0 +1,321 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package mount
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"go.uber.org/zap"
+)
+
+// node is a file or directory in the mounted tree
+type node struct {
+	fs.Inode
+	fsys *FS
+	rel  string // path relative to the mount root
+}
+
+var (
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeSetattrer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeCreater   = (*node)(nil)
+	_ fs.NodeMkdirer   = (*node)(nil)
+	_ fs.NodeUnlinker  = (*node)(nil)
+)
+
+// child returns the relative path of a child of n
+func (n *node) child(name string) string {
+	return path.Join(n.rel, name)
+}
+
+// newChild creates the inode for a child entry
+func (n *node) newChild(ctx context.Context, name string, e entry, out *fuse.EntryOut) *fs.Inode {
+	fillAttr(e, &out.Attr)
+	mode := uint32(fuse.S_IFREG)
+	if e.isDir {
+		mode = fuse.S_IFDIR
+	}
+	return n.NewInode(ctx, &node{fsys: n.fsys, rel: n.child(name)}, fs.StableAttr{Mode: mode})
+}
+
+// Lookup finds a child by name
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	e, ok := n.fsys.lookup(ctx, n.child(name))
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return n.newChild(ctx, name, e, out), 0
+}
+
+// Readdir lists a directory
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	children := n.fsys.children(ctx, n.rel)
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		mode := uint32(fuse.S_IFREG)
+		if children[name].isDir {
+			mode = fuse.S_IFDIR
+		}
+		list = append(list, fuse.DirEntry{Name: name, Mode: mode})
+	}
+	return fs.NewListDirStream(list), 0
+}
+
+// Getattr reports the size and modification time of an entry
+func (n *node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	if h, ok := fh.(*handle); ok {
+		return h.Getattr(ctx, out)
+	}
+	e, ok := n.fsys.lookup(ctx, n.rel)
+	if !ok {
+		return syscall.ENOENT
+	}
+	fillAttr(e, &out.Attr)
+	return 0
+}
+
+// Setattr supports truncation, the only attribute change kept remotely
+func (n *node) Setattr(ctx context.Context, fh fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	if size, ok := in.GetSize(); ok {
+		if h, ok := fh.(*handle); ok {
+			if err := h.file.Truncate(int64(size)); err != nil {
+				return fs.ToErrno(err)
+			}
+			h.markDirty()
+			return h.Getattr(ctx, out)
+		}
+
+		e, ok := n.fsys.lookup(ctx, n.rel)
+		if !ok || e.isDir {
+			return syscall.EINVAL
+		}
+		cachePath, err := n.fsys.fetch(ctx, n.rel, e)
+		if err != nil {
+			n.fsys.logger.Error("Failed to fetch file", zap.String("path", n.rel), zap.Error(err))
+			return syscall.EIO
+		}
+		if err := os.Truncate(cachePath, int64(size)); err != nil {
+			return fs.ToErrno(err)
+		}
+		if err := n.fsys.writeBack(ctx, n.rel, cachePath); err != nil {
+			n.fsys.logger.Error("Write-back failed", zap.String("path", n.rel), zap.Error(err))
+			return syscall.EIO
+		}
+	}
+	return n.Getattr(ctx, fh, out)
+}
+
+// Open downloads the file into the cache if needed and opens the cached copy
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	e, ok := n.fsys.lookup(ctx, n.rel)
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	if e.isDir {
+		return nil, 0, syscall.EISDIR
+	}
+
+	cachePath, err := n.fsys.fetch(ctx, n.rel, e)
+	if err != nil {
+		n.fsys.logger.Error("Failed to fetch file", zap.String("path", n.rel), zap.Error(err))
+		return nil, 0, syscall.EIO
+	}
+
+	file, err := os.OpenFile(cachePath, int(flags)&(os.O_RDONLY|os.O_WRONLY|os.O_RDWR|os.O_TRUNC), 0600)
+	if err != nil {
+		return nil, 0, fs.ToErrno(err)
+	}
+
+	h := n.fsys.newHandle(n.rel, cachePath, file)
+	if int(flags)&os.O_TRUNC != 0 {
+		h.markDirty()
+	}
+	return h, 0, 0
+}
+
+// Create creates a new file that is uploaded when first flushed
+func (n *node) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (*fs.Inode, fs.FileHandle, uint32, syscall.Errno) {
+	rel := n.child(name)
+	cachePath := n.fsys.cachePath(n.fsys.remoteKey(rel))
+
+	file, err := os.OpenFile(cachePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, nil, 0, fs.ToErrno(err)
+	}
+
+	e := entry{modTime: time.Now(), local: true}
+	n.fsys.setEntry(rel, e)
+
+	h := n.fsys.newHandle(rel, cachePath, file)
+	h.markDirty()
+	return n.newChild(ctx, name, e, out), h, 0, 0
+}
+
+// Mkdir creates a directory. Directories exist remotely only through the
+// files they contain.
+func (n *node) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	rel := n.child(name)
+	if _, exists := n.fsys.lookup(ctx, rel); exists {
+		return nil, syscall.EEXIST
+	}
+
+	e := entry{isDir: true, modTime: time.Now(), local: true}
+	n.fsys.setEntry(rel, e)
+	return n.newChild(ctx, name, e, out), 0
+}
+
+// Unlink deletes a remote file
+func (n *node) Unlink(ctx context.Context, name string) syscall.Errno {
+	if err := n.fsys.remove(ctx, n.child(name)); err != nil {
+		n.fsys.logger.Error("Failed to delete file", zap.String("path", n.child(name)), zap.Error(err))
+		return syscall.EIO
+	}
+	return 0
+}
+
+// fillAttr converts an entry to FUSE attributes
+func fillAttr(e entry, attr *fuse.Attr) {
+	if e.isDir {
+		attr.Mode = fuse.S_IFDIR | 0755
+	} else {
+		attr.Mode = fuse.S_IFREG | 0644
+		attr.Size = uint64(e.size)
+		attr.Blocks = (attr.Size + 511) / 512
+	}
+	attr.SetTimes(nil, &e.modTime, &e.modTime)
+}
+
+// handle is an open file backed by its cache copy. Written content is
+// queued for upload on flush.
+type handle struct {
+	fsys      *FS
+	rel       string
+	cachePath string
+	file      *os.File
+
+	mutex sync.Mutex
+	dirty bool
+}
+
+var (
+	_ fs.FileReader    = (*handle)(nil)
+	_ fs.FileWriter    = (*handle)(nil)
+	_ fs.FileFlusher   = (*handle)(nil)
+	_ fs.FileFsyncer   = (*handle)(nil)
+	_ fs.FileReleaser  = (*handle)(nil)
+	_ fs.FileGetattrer = (*handle)(nil)
+)
+
+// newHandle creates a handle for an opened cache file
+func (f *FS) newHandle(rel, cachePath string, file *os.File) *handle {
+	f.acquire(cachePath)
+	return &handle{fsys: f, rel: rel, cachePath: cachePath, file: file}
+}
+
+// markDirty records that the file needs to be uploaded
+func (h *handle) markDirty() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.dirty = true
+}
+
+// Read reads from the cached copy
+func (h *handle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	count, err := h.file.ReadAt(dest, off)
+	if err != nil && err != io.EOF {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:count]), 0
+}
+
+// Write writes to the cached copy
+func (h *handle) Write(ctx context.Context, data []byte, off int64) (uint32, syscall.Errno) {
+	count, err := h.file.WriteAt(data, off)
+	if count > 0 {
+		h.markDirty()
+	}
+	if err != nil {
+		return uint32(count), fs.ToErrno(err)
+	}
+	return uint32(count), 0
+}
+
+// Getattr reports the attributes of the cached copy
+func (h *handle) Getattr(ctx context.Context, out *fuse.AttrOut) syscall.Errno {
+	info, err := h.file.Stat()
+	if err != nil {
+		return fs.ToErrno(err)
+	}
+	fillAttr(entry{size: info.Size(), modTime: info.ModTime()}, &out.Attr)
+	return 0
+}
+
+// Flush queues written content for upload
+func (h *handle) Flush(ctx context.Context) syscall.Errno {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.dirty {
+		return 0
+	}
+	if err := h.file.Sync(); err != nil {
+		return fs.ToErrno(err)
+	}
+	if err := h.fsys.writeBack(ctx, h.rel, h.cachePath); err != nil {
+		h.fsys.logger.Error("Write-back failed", zap.String("path", h.rel), zap.Error(err))
+		return syscall.EIO
+	}
+	h.dirty = false
+	return 0
+}
+
+// Fsync flushes written content
+func (h *handle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
+	return h.Flush(ctx)
+}
+
+// Release closes the cached copy
+func (h *handle) Release(ctx context.Context) syscall.Errno {
+	errno := h.Flush(ctx)
+	h.file.Close()
+	h.fsys.release(h.cachePath)
+	return errno
+}
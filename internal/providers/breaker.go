@@ -0,0 +1,395 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops sending requests to a failing backend. After a
+// number of consecutive failures the circuit opens and requests fail at
+// once with interfaces.ErrCircuitOpen. Once the cooldown has passed a
+// single trial request is let through: success closes the circuit,
+// failure opens it for another cooldown. Optional capabilities are
+// forwarded and fail with errors.ErrUnsupported when the wrapped provider
+// lacks them.
+type CircuitBreaker struct {
+	name      string
+	provider  interfaces.CloudProvider
+	threshold int
+	cooldown  time.Duration
+	logger    *zap.Logger
+
+	mutex     sync.Mutex
+	state     string
+	failures  int
+	openedAt  time.Time
+	lastError string
+}
+
+// NewCircuitBreaker wraps the backend called name, opening the circuit
+// for cooldown after threshold consecutive failed requests
+func NewCircuitBreaker(name string, provider interfaces.CloudProvider, threshold int, cooldown time.Duration, logger *zap.Logger) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		name:      name,
+		provider:  provider,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger,
+		state:     CircuitClosed,
+	}
+}
+
+// Health reports the state of the circuit
+func (b *CircuitBreaker) Health() interfaces.CircuitHealth {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return interfaces.CircuitHealth{
+		Backend:             b.name,
+		State:               b.state,
+		OpenedAt:            b.openedAt,
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+	}
+}
+
+// allow reports whether a request may be sent, moving an open circuit
+// whose cooldown has passed to half-open and letting the caller's request
+// through as its trial
+func (b *CircuitBreaker) allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return fmt.Errorf("%s: %w", b.name, interfaces.ErrCircuitOpen)
+		}
+		b.state = CircuitHalfOpen
+		b.logger.Info("Circuit breaker half-open, sending trial request",
+			zap.String("backend", b.name))
+		return nil
+	case CircuitHalfOpen:
+		// A trial request is in flight
+		return fmt.Errorf("%s: %w", b.name, interfaces.ErrCircuitOpen)
+	default:
+		return nil
+	}
+}
+
+// observe records the outcome of a request that was allowed through
+func (b *CircuitBreaker) observe(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		// Says nothing about the backend; let another request try
+		if b.state == CircuitHalfOpen {
+			b.state = CircuitOpen
+		}
+		return
+	}
+
+	if err == nil || interfaces.IsPermanent(err) || isNotFound(err) || errors.Is(err, interfaces.ErrObjectArchived) {
+		if b.state != CircuitClosed {
+			b.logger.Info("Circuit breaker closed, backend answering again",
+				zap.String("backend", b.name),
+				zap.Duration("open_for", time.Since(b.openedAt)))
+		}
+		b.state = CircuitClosed
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	switch {
+	case b.state == CircuitHalfOpen:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+		b.logger.Warn("Circuit breaker trial request failed, staying open",
+			zap.String("backend", b.name),
+			zap.Duration("cooldown", b.cooldown),
+			zap.Error(err))
+	case b.state == CircuitClosed && b.failures >= b.threshold:
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+		b.logger.Warn("Circuit breaker open, backend failing",
+			zap.String("backend", b.name),
+			zap.Int("failures", b.failures),
+			zap.Duration("cooldown", b.cooldown),
+			zap.Error(err))
+	}
+}
+
+// call runs fn unless the circuit is open, recording its outcome
+func (b *CircuitBreaker) call(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.observe(err)
+	return err
+}
+
+// Upload uploads a file unless the circuit is open
+func (b *CircuitBreaker) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	return b.call(func() error {
+		return b.provider.Upload(ctx, key, reader, metadata, options)
+	})
+}
+
+// Download downloads a file unless the circuit is open
+func (b *CircuitBreaker) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	var body io.ReadCloser
+	var metadata interfaces.FileMetadata
+	err := b.call(func() (err error) {
+		body, metadata, err = b.provider.Download(ctx, key, options)
+		return err
+	})
+	return body, metadata, err
+}
+
+// DownloadRange downloads part of a file unless the circuit is open
+func (b *CircuitBreaker) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	ranger, ok := b.provider.(interfaces.RangeProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to download range: %w", errors.ErrUnsupported)
+	}
+	var body io.ReadCloser
+	err := b.call(func() (err error) {
+		body, err = ranger.DownloadRange(ctx, key, offset, length, options)
+		return err
+	})
+	return body, err
+}
+
+// Delete removes a file unless the circuit is open
+func (b *CircuitBreaker) Delete(ctx context.Context, key string) error {
+	return b.call(func() error {
+		return b.provider.Delete(ctx, key)
+	})
+}
+
+// Copy copies an object unless the circuit is open
+func (b *CircuitBreaker) Copy(ctx context.Context, srcKey, dstKey string) error {
+	copier, ok := b.provider.(interfaces.CopyProvider)
+	if !ok {
+		return fmt.Errorf("failed to copy object: %w", errors.ErrUnsupported)
+	}
+	return b.call(func() error {
+		return copier.Copy(ctx, srcKey, dstKey)
+	})
+}
+
+// CreateMultipartUpload starts a multipart upload unless the circuit is
+// open
+func (b *CircuitBreaker) CreateMultipartUpload(ctx context.Context, key string, metadata interfaces.FileMetadata) (string, error) {
+	uploader, ok := b.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return "", fmt.Errorf("failed to create multipart upload: %w", errors.ErrUnsupported)
+	}
+	var uploadID string
+	err := b.call(func() (err error) {
+		uploadID, err = uploader.CreateMultipartUpload(ctx, key, metadata)
+		return err
+	})
+	return uploadID, err
+}
+
+// UploadPart uploads a part unless the circuit is open
+func (b *CircuitBreaker) UploadPart(ctx context.Context, key, uploadID string, number int, body io.ReadSeeker, size int64, options interfaces.TransferOptions) (string, error) {
+	uploader, ok := b.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return "", fmt.Errorf("failed to upload part: %w", errors.ErrUnsupported)
+	}
+	var etag string
+	err := b.call(func() (err error) {
+		etag, err = uploader.UploadPart(ctx, key, uploadID, number, body, size, options)
+		return err
+	})
+	return etag, err
+}
+
+// ListParts lists the parts of a multipart upload unless the circuit is
+// open
+func (b *CircuitBreaker) ListParts(ctx context.Context, key, uploadID string) ([]interfaces.UploadedPart, error) {
+	uploader, ok := b.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to list parts: %w", errors.ErrUnsupported)
+	}
+	var parts []interfaces.UploadedPart
+	err := b.call(func() (err error) {
+		parts, err = uploader.ListParts(ctx, key, uploadID)
+		return err
+	})
+	return parts, err
+}
+
+// CompleteMultipartUpload completes a multipart upload unless the circuit
+// is open
+func (b *CircuitBreaker) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []interfaces.UploadedPart) error {
+	uploader, ok := b.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return fmt.Errorf("failed to complete multipart upload: %w", errors.ErrUnsupported)
+	}
+	return b.call(func() error {
+		return uploader.CompleteMultipartUpload(ctx, key, uploadID, parts)
+	})
+}
+
+// AbortMultipartUpload aborts a multipart upload unless the circuit is
+// open
+func (b *CircuitBreaker) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	uploader, ok := b.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return fmt.Errorf("failed to abort multipart upload: %w", errors.ErrUnsupported)
+	}
+	return b.call(func() error {
+		return uploader.AbortMultipartUpload(ctx, key, uploadID)
+	})
+}
+
+// Restore requests a restore of an archived object unless the circuit is
+// open
+func (b *CircuitBreaker) Restore(ctx context.Context, key string, days int, tier string) error {
+	restorer, ok := b.provider.(interfaces.RestoreProvider)
+	if !ok {
+		return fmt.Errorf("failed to request restore: %w", errors.ErrUnsupported)
+	}
+	return b.call(func() error {
+		return restorer.Restore(ctx, key, days, tier)
+	})
+}
+
+// List lists files unless the circuit is open
+func (b *CircuitBreaker) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	var files []interfaces.FileInfo
+	err := b.call(func() (err error) {
+		files, err = b.provider.List(ctx, prefix)
+		return err
+	})
+	return files, err
+}
+
+// ListVersions lists object versions unless the circuit is open
+func (b *CircuitBreaker) ListVersions(ctx context.Context, prefix string) ([]interfaces.ObjectVersion, error) {
+	versioned, ok := b.provider.(interfaces.VersionedProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to list versions: %w", errors.ErrUnsupported)
+	}
+	var versions []interfaces.ObjectVersion
+	err := b.call(func() (err error) {
+		versions, err = versioned.ListVersions(ctx, prefix)
+		return err
+	})
+	return versions, err
+}
+
+// DeleteVersion deletes one object version unless the circuit is open
+func (b *CircuitBreaker) DeleteVersion(ctx context.Context, key, versionID string) error {
+	versioned, ok := b.provider.(interfaces.VersionedProvider)
+	if !ok {
+		return fmt.Errorf("failed to delete version: %w", errors.ErrUnsupported)
+	}
+	return b.call(func() error {
+		return versioned.DeleteVersion(ctx, key, versionID)
+	})
+}
+
+// GetMetadata retrieves file metadata unless the circuit is open
+func (b *CircuitBreaker) GetMetadata(ctx context.Context, key string) (interfaces.FileMetadata, error) {
+	var metadata interfaces.FileMetadata
+	err := b.call(func() (err error) {
+		metadata, err = b.provider.GetMetadata(ctx, key)
+		return err
+	})
+	return metadata, err
+}
+
+// GetMetadataIfChanged returns the file's metadata unless it is unchanged
+// or the circuit is open. Providers without conditional requests always
+// report a change.
+func (b *CircuitBreaker) GetMetadataIfChanged(ctx context.Context, key, etag string) (interfaces.FileMetadata, bool, error) {
+	var metadata interfaces.FileMetadata
+	changed := true
+	err := b.call(func() (err error) {
+		if conditional, ok := b.provider.(interfaces.ConditionalProvider); ok {
+			metadata, changed, err = conditional.GetMetadataIfChanged(ctx, key, etag)
+			return err
+		}
+		metadata, err = b.provider.GetMetadata(ctx, key)
+		return err
+	})
+	return metadata, changed && err == nil, err
+}
+
+// Exists checks whether a file exists unless the circuit is open
+func (b *CircuitBreaker) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := b.call(func() (err error) {
+		exists, err = b.provider.Exists(ctx, key)
+		return err
+	})
+	return exists, err
+}
+
+// StorageUsage returns the usage of the objects under prefix unless the
+// circuit is open
+func (b *CircuitBreaker) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	var usage interfaces.StorageUsage
+	err := b.call(func() (err error) {
+		usage, err = b.provider.StorageUsage(ctx, prefix)
+		return err
+	})
+	return usage, err
+}
+
+// ClockSkew returns the clock skew measured by the wrapped provider. It
+// makes no request, so it is answered while the circuit is open.
+func (b *CircuitBreaker) ClockSkew() (time.Duration, bool) {
+	if measurer, ok := b.provider.(interfaces.ClockSkewProvider); ok {
+		return measurer.ClockSkew()
+	}
+	return 0, false
+}
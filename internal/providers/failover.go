@@ -0,0 +1,421 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// failoverProbeKey is the object looked up to check whether the primary
+// backend is reachable again. It does not need to exist.
+const failoverProbeKey = ".cloudawsync-failover-probe"
+
+// FailoverProvider uses a primary backend and switches to a secondary one
+// after a number of consecutive primary failures. Objects written while
+// failed over are recorded and copied back to the primary by
+// RepairMirrors, which also switches back once the primary answers again.
+type FailoverProvider struct {
+	primary       Backend
+	secondary     Backend
+	failoverAfter int
+	logger        *zap.Logger
+
+	mutex        sync.Mutex
+	gaps         gapStore
+	failedOver   bool
+	failedOverAt time.Time
+	failures     int
+	lastError    string
+}
+
+// NewFailoverProvider creates a provider switching from primary to
+// secondary after failoverAfter consecutive failed primary requests
+func NewFailoverProvider(primary, secondary Backend, failoverAfter int, logger *zap.Logger) *FailoverProvider {
+	if failoverAfter < 1 {
+		failoverAfter = 1
+	}
+	return &FailoverProvider{
+		primary:       primary,
+		secondary:     secondary,
+		failoverAfter: failoverAfter,
+		logger:        logger,
+		gaps:          &memoryGaps{gaps: make(map[string]state.MirrorGap)},
+	}
+}
+
+// SetStateStore persists the objects still to be copied to the primary in
+// store. Objects recorded in memory so far are moved to the store.
+func (f *FailoverProvider) SetStateStore(store *state.Store) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, gap := range f.gaps.MirrorGaps() {
+		store.PutMirrorGap(gap)
+	}
+	f.gaps = store
+}
+
+// Health reports which backend is in use
+func (f *FailoverProvider) Health() interfaces.ProviderHealth {
+	gaps := f.store().MirrorGaps()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	health := interfaces.ProviderHealth{
+		Active:              f.primary.Name,
+		FailedOver:          f.failedOver,
+		FailedOverAt:        f.failedOverAt,
+		ConsecutiveFailures: f.failures,
+		LastError:           f.lastError,
+		PendingRepairs:      len(gaps),
+	}
+	if f.failedOver {
+		health.Active = f.secondary.Name
+	}
+	return health
+}
+
+// store returns where objects missing from the primary are recorded
+func (f *FailoverProvider) store() gapStore {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.gaps
+}
+
+// active returns the backend requests go to
+func (f *FailoverProvider) active() (Backend, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.failedOver {
+		return f.secondary, true
+	}
+	return f.primary, false
+}
+
+// observe records the outcome of a primary request, failing over once
+// failoverAfter requests in a row failed. It reports whether requests now
+// go to the secondary.
+func (f *FailoverProvider) observe(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err == nil || isNotFound(err) || errors.Is(err, interfaces.ErrObjectArchived) {
+		f.failures = 0
+		return f.failedOver
+	}
+
+	f.failures++
+	f.lastError = err.Error()
+	if !f.failedOver && f.failures >= f.failoverAfter {
+		f.failedOver = true
+		f.failedOverAt = time.Now()
+		f.logger.Warn("Primary storage failing, switching to secondary",
+			zap.String("primary", f.primary.Name),
+			zap.String("secondary", f.secondary.Name),
+			zap.Int("failures", f.failures),
+			zap.Error(err))
+	}
+	return f.failedOver
+}
+
+// Upload uploads the object to the primary, or to the secondary while
+// failed over. When the upload that triggers the failover fails, it is
+// retried on the secondary if the reader can be rewound.
+func (f *FailoverProvider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	progress := furthestProgress(options.Progress, 2)
+
+	if _, failedOver := f.active(); !failedOver {
+		err := f.primary.Provider.Upload(ctx, key, reader, metadata, interfaces.TransferOptions{Progress: progress[0]})
+		if !f.observe(err) {
+			if err == nil {
+				f.store().DeleteMirrorGap(key)
+			}
+			return err
+		}
+
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return err
+		}
+		if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+			return err
+		}
+	}
+
+	if err := f.secondary.Provider.Upload(ctx, key, reader, metadata, interfaces.TransferOptions{Progress: progress[1]}); err != nil {
+		return fmt.Errorf("%s: %w", f.secondary.Name, err)
+	}
+	f.recordGap(key, false)
+	return nil
+}
+
+// recordGap records that the primary misses a write to key made on the
+// secondary
+func (f *FailoverProvider) recordGap(key string, deleted bool) {
+	gaps := f.store()
+	now := time.Now()
+	gap := state.MirrorGap{
+		Key:       key,
+		Missing:   []string{f.primary.Name},
+		Deleted:   deleted,
+		Since:     now,
+		UpdatedAt: now,
+	}
+	if previous, ok := gaps.MirrorGap(key); ok {
+		gap.Since = previous.Since
+	}
+	gaps.PutMirrorGap(gap)
+}
+
+// Delete deletes the object from the primary, or from the secondary while
+// failed over, recording that the primary still holds it
+func (f *FailoverProvider) Delete(ctx context.Context, key string) error {
+	if _, failedOver := f.active(); !failedOver {
+		err := f.primary.Provider.Delete(ctx, key)
+		if !f.observe(err) {
+			if err == nil {
+				f.store().DeleteMirrorGap(key)
+			}
+			return err
+		}
+	}
+
+	if err := f.secondary.Provider.Delete(ctx, key); err != nil && !isNotFound(err) {
+		return fmt.Errorf("%s: %w", f.secondary.Name, err)
+	}
+	f.recordGap(key, true)
+	return nil
+}
+
+// Copy copies the object on the backend in use
+func (f *FailoverProvider) Copy(ctx context.Context, srcKey, dstKey string) error {
+	backend, failedOver := f.active()
+	copier, ok := backend.Provider.(interfaces.CopyProvider)
+	if !ok {
+		return fmt.Errorf("failed to copy object: %w", errors.ErrUnsupported)
+	}
+
+	err := copier.Copy(ctx, srcKey, dstKey)
+	if !failedOver {
+		f.observe(err)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", backend.Name, err)
+	}
+	f.recordGap(dstKey, false)
+	return nil
+}
+
+// Restore requests a restore of the object on the backend it is read from
+func (f *FailoverProvider) Restore(ctx context.Context, key string, days int, tier string) error {
+	return f.read(key, func(provider interfaces.CloudProvider) error {
+		restorer, ok := provider.(interfaces.RestoreProvider)
+		if !ok {
+			return fmt.Errorf("failed to request restore: %w", errors.ErrUnsupported)
+		}
+		return restorer.Restore(ctx, key, days, tier)
+	})
+}
+
+// Download downloads the object from the backend in use, falling back to
+// the other one
+func (f *FailoverProvider) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	var body io.ReadCloser
+	var metadata interfaces.FileMetadata
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		body, metadata, err = provider.Download(ctx, key, options)
+		return err
+	})
+	return body, metadata, err
+}
+
+// DownloadRange downloads part of the object from the backend in use,
+// falling back to the other one
+func (f *FailoverProvider) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		ranger, ok := provider.(interfaces.RangeProvider)
+		if !ok {
+			return fmt.Errorf("failed to download range: %w", errors.ErrUnsupported)
+		}
+		var err error
+		body, err = ranger.DownloadRange(ctx, key, offset, length, options)
+		return err
+	})
+	return body, err
+}
+
+// List lists the objects of the backend in use. While failed over only
+// the objects on the secondary are listed.
+func (f *FailoverProvider) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	var files []interfaces.FileInfo
+	err := f.read("", func(provider interfaces.CloudProvider) error {
+		var err error
+		files, err = provider.List(ctx, prefix)
+		return err
+	})
+	return files, err
+}
+
+// GetMetadata returns the object's metadata from the backend in use,
+// falling back to the other one
+func (f *FailoverProvider) GetMetadata(ctx context.Context, key string) (interfaces.FileMetadata, error) {
+	var metadata interfaces.FileMetadata
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		metadata, err = provider.GetMetadata(ctx, key)
+		return err
+	})
+	return metadata, err
+}
+
+// Exists checks the object on the backend in use, falling back to the
+// other one
+func (f *FailoverProvider) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		exists, err = provider.Exists(ctx, key)
+		if err == nil && !exists && key != "" {
+			// Let a failed over read look for older objects on the primary
+			err = fmt.Errorf("object %s: %w", key, interfaces.ErrNotFound)
+		}
+		return err
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return exists, err
+}
+
+// StorageUsage returns the usage of the backend in use
+func (f *FailoverProvider) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	var usage interfaces.StorageUsage
+	err := f.read("", func(provider interfaces.CloudProvider) error {
+		var err error
+		usage, err = provider.StorageUsage(ctx, prefix)
+		return err
+	})
+	return usage, err
+}
+
+// ClockSkew returns the clock skew measured by the backend in use
+func (f *FailoverProvider) ClockSkew() (time.Duration, bool) {
+	backend, _ := f.active()
+	if measurer, ok := backend.Provider.(interfaces.ClockSkewProvider); ok {
+		return measurer.ClockSkew()
+	}
+	return 0, false
+}
+
+// read calls fn with the backend in use and falls back to the other one.
+// Keys written to the secondary and not yet copied back are read from the
+// secondary. While using the primary, a missing object is final; while
+// failed over, objects missing from the secondary are looked up on the
+// primary, since they may predate the failover.
+func (f *FailoverProvider) read(key string, fn func(provider interfaces.CloudProvider) error) error {
+	if key != "" {
+		if gap, ok := f.store().MirrorGap(key); ok && slices.Contains(gap.Missing, f.primary.Name) {
+			return fn(f.secondary.Provider)
+		}
+	}
+
+	backend, failedOver := f.active()
+	err := fn(backend.Provider)
+	if !failedOver {
+		if !f.observe(err) || err == nil || isNotFound(err) {
+			return err
+		}
+		// This request triggered the failover
+		return fn(f.secondary.Provider)
+	}
+
+	if err == nil || key == "" || !isNotFound(err) {
+		return err
+	}
+	if primaryErr := fn(f.primary.Provider); primaryErr == nil || isNotFound(primaryErr) {
+		return primaryErr
+	}
+	return err
+}
+
+// RepairMirrors switches back to the primary once it is reachable again
+// and copies the objects written to the secondary while failed over to
+// it, returning the number of objects copied or deleted
+func (f *FailoverProvider) RepairMirrors(ctx context.Context) (int, error) {
+	if _, failedOver := f.active(); failedOver {
+		if _, err := f.primary.Provider.Exists(ctx, failoverProbeKey); err != nil {
+			f.logger.Debug("Primary storage still failing", zap.Error(err))
+			return 0, nil
+		}
+
+		f.mutex.Lock()
+		outage := time.Since(f.failedOverAt)
+		f.failedOver = false
+		f.failedOverAt = time.Time{}
+		f.failures = 0
+		f.lastError = ""
+		f.mutex.Unlock()
+
+		f.logger.Info("Primary storage reachable again, switching back",
+			zap.String("primary", f.primary.Name),
+			zap.Duration("outage", outage))
+	}
+
+	backends := []Backend{f.primary, f.secondary}
+	repaired := 0
+	var lastErr error
+	for _, gap := range f.store().MirrorGaps() {
+		if err := ctx.Err(); err != nil {
+			return repaired, err
+		}
+		complete, err := repairGap(ctx, backends, f.store(), gap, f.logger)
+		if err != nil {
+			f.logger.Warn("Failed to copy object back to primary storage",
+				zap.String("key", gap.Key),
+				zap.Error(err))
+			lastErr = err
+		}
+		if complete {
+			repaired++
+		}
+	}
+	return repaired, lastErr
+}
@@ -0,0 +1,547 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/state"
+
+	"go.uber.org/zap"
+)
+
+// Backend is one storage service written by a FanoutProvider
+type Backend struct {
+	Name     string
+	Provider interfaces.CloudProvider
+}
+
+// gapStore records the objects missing from some backends. *state.Store
+// implements it.
+type gapStore interface {
+	PutMirrorGap(gap state.MirrorGap)
+	MirrorGap(key string) (state.MirrorGap, bool)
+	DeleteMirrorGap(key string)
+	MirrorGaps() []state.MirrorGap
+}
+
+// FanoutProvider writes every object to a primary backend and a number of
+// mirrors, so that the data survives the loss of any single storage
+// service. An upload succeeds when at least one backend stored the object;
+// the backends that missed it are recorded and filled in later by
+// RepairMirrors. Reads go to the primary and fall back to the mirrors.
+type FanoutProvider struct {
+	backends []Backend // primary first
+	gaps     gapStore
+	logger   *zap.Logger
+	mutex    sync.RWMutex
+}
+
+// NewFanoutProvider creates a provider writing to primary and every mirror.
+// Mirror gaps are kept in memory until SetStateStore is called.
+func NewFanoutProvider(primary Backend, mirrors []Backend, logger *zap.Logger) *FanoutProvider {
+	return &FanoutProvider{
+		backends: append([]Backend{primary}, mirrors...),
+		gaps:     &memoryGaps{gaps: make(map[string]state.MirrorGap)},
+		logger:   logger,
+	}
+}
+
+// SetStateStore persists mirror gaps in store. Gaps recorded in memory so
+// far are moved to the store.
+func (f *FanoutProvider) SetStateStore(store *state.Store) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, gap := range f.gaps.MirrorGaps() {
+		store.PutMirrorGap(gap)
+	}
+	f.gaps = store
+}
+
+// Backends returns the names of the backends, primary first
+func (f *FanoutProvider) Backends() []string {
+	names := make([]string, len(f.backends))
+	for i, backend := range f.backends {
+		names[i] = backend.Name
+	}
+	return names
+}
+
+// MirrorGaps returns the objects currently missing from some backends
+func (f *FanoutProvider) MirrorGaps() []state.MirrorGap {
+	return f.store().MirrorGaps()
+}
+
+// store returns where mirror gaps are recorded
+func (f *FanoutProvider) store() gapStore {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.gaps
+}
+
+// Upload uploads the object to every backend at once. The reader must be
+// seekable; readers that also implement io.ReaderAt are read by all
+// backends concurrently, others are uploaded to one backend after another.
+// Progress follows the backend furthest ahead.
+func (f *FanoutProvider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		return fmt.Errorf("failed to upload file: fanout uploads need a seekable reader")
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = seeker.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	progress := furthestProgress(options.Progress, len(f.backends))
+	errs := make([]error, len(f.backends))
+
+	if readerAt, ok := reader.(io.ReaderAt); ok {
+		var wg sync.WaitGroup
+		for i, backend := range f.backends {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				section := io.NewSectionReader(readerAt, 0, size)
+				errs[i] = backend.Provider.Upload(ctx, key, section, metadata, interfaces.TransferOptions{Progress: progress[i]})
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i, backend := range f.backends {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				errs[i] = fmt.Errorf("failed to rewind file: %w", err)
+				continue
+			}
+			errs[i] = backend.Provider.Upload(ctx, key, seeker, metadata, interfaces.TransferOptions{Progress: progress[i]})
+		}
+	}
+
+	return f.recordWrite(key, errs)
+}
+
+// recordWrite records which backends failed to store key, returning an
+// error only when none stored it
+func (f *FanoutProvider) recordWrite(key string, errs []error) error {
+	var missing []string
+	var named []error
+	failures := make(map[string]string)
+	for i, err := range errs {
+		if err != nil {
+			missing = append(missing, f.backends[i].Name)
+			failures[f.backends[i].Name] = err.Error()
+			named = append(named, fmt.Errorf("%s: %w", f.backends[i].Name, err))
+		}
+	}
+
+	gaps := f.store()
+	switch {
+	case len(missing) == len(f.backends):
+		return fmt.Errorf("failed to store object on any backend: %w", errors.Join(named...))
+	case len(missing) == 0:
+		gaps.DeleteMirrorGap(key)
+		return nil
+	}
+
+	now := time.Now()
+	gap := state.MirrorGap{
+		Key:       key,
+		Missing:   missing,
+		Errors:    failures,
+		Since:     now,
+		UpdatedAt: now,
+	}
+	if previous, ok := gaps.MirrorGap(key); ok {
+		gap.Since = previous.Since
+	}
+	gaps.PutMirrorGap(gap)
+
+	f.logger.Warn("Object stored on some backends only, missing copies will be repaired",
+		zap.String("key", key),
+		zap.Strings("missing", missing))
+	return nil
+}
+
+// Download downloads the object from the first backend holding it
+func (f *FanoutProvider) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	var body io.ReadCloser
+	var metadata interfaces.FileMetadata
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		body, metadata, err = provider.Download(ctx, key, options)
+		return err
+	})
+	return body, metadata, err
+}
+
+// DownloadRange downloads part of the object from the first backend
+// holding it
+func (f *FanoutProvider) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		ranger, ok := provider.(interfaces.RangeProvider)
+		if !ok {
+			return fmt.Errorf("failed to download range: %w", errors.ErrUnsupported)
+		}
+		var err error
+		body, err = ranger.DownloadRange(ctx, key, offset, length, options)
+		return err
+	})
+	return body, err
+}
+
+// Delete deletes the object from every backend
+func (f *FanoutProvider) Delete(ctx context.Context, key string) error {
+	errs := make([]error, len(f.backends))
+	var wg sync.WaitGroup
+	for i, backend := range f.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := backend.Provider.Delete(ctx, key); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", backend.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	f.store().DeleteMirrorGap(key)
+	return nil
+}
+
+// Copy copies the object on every backend holding it. Backends missing the
+// source object are recorded as missing the copy.
+func (f *FanoutProvider) Copy(ctx context.Context, srcKey, dstKey string) error {
+	gap, _ := f.store().MirrorGap(srcKey)
+	errs := make([]error, len(f.backends))
+	for i, backend := range f.backends {
+		copier, ok := backend.Provider.(interfaces.CopyProvider)
+		switch {
+		case slices.Contains(gap.Missing, backend.Name):
+			errs[i] = fmt.Errorf("source object %s is missing", srcKey)
+		case !ok:
+			errs[i] = fmt.Errorf("failed to copy object: %w", errors.ErrUnsupported)
+		default:
+			errs[i] = copier.Copy(ctx, srcKey, dstKey)
+		}
+	}
+	return f.recordWrite(dstKey, errs)
+}
+
+// Restore requests a restore of the object on the first backend holding
+// it, the one it is read from
+func (f *FanoutProvider) Restore(ctx context.Context, key string, days int, tier string) error {
+	return f.read(key, func(provider interfaces.CloudProvider) error {
+		restorer, ok := provider.(interfaces.RestoreProvider)
+		if !ok {
+			return fmt.Errorf("failed to request restore: %w", errors.ErrUnsupported)
+		}
+		return restorer.Restore(ctx, key, days, tier)
+	})
+}
+
+// List lists the objects of the first reachable backend
+func (f *FanoutProvider) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	var files []interfaces.FileInfo
+	err := f.read("", func(provider interfaces.CloudProvider) error {
+		var err error
+		files, err = provider.List(ctx, prefix)
+		return err
+	})
+	return files, err
+}
+
+// GetMetadata returns the object's metadata from the first backend
+// holding it
+func (f *FanoutProvider) GetMetadata(ctx context.Context, key string) (interfaces.FileMetadata, error) {
+	var metadata interfaces.FileMetadata
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		metadata, err = provider.GetMetadata(ctx, key)
+		return err
+	})
+	return metadata, err
+}
+
+// Exists checks the object on the first backend holding it
+func (f *FanoutProvider) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := f.read(key, func(provider interfaces.CloudProvider) error {
+		var err error
+		exists, err = provider.Exists(ctx, key)
+		return err
+	})
+	return exists, err
+}
+
+// StorageUsage returns the usage of the first reachable backend
+func (f *FanoutProvider) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	var usage interfaces.StorageUsage
+	err := f.read("", func(provider interfaces.CloudProvider) error {
+		var err error
+		usage, err = provider.StorageUsage(ctx, prefix)
+		return err
+	})
+	return usage, err
+}
+
+// ClockSkew returns the clock skew measured by the primary, whose
+// timestamps listings report
+func (f *FanoutProvider) ClockSkew() (time.Duration, bool) {
+	if measurer, ok := f.backends[0].Provider.(interfaces.ClockSkewProvider); ok {
+		return measurer.ClockSkew()
+	}
+	return 0, false
+}
+
+// read calls fn with each backend holding key in turn, primary first,
+// until one succeeds. A backend reporting that the object does not exist
+// ends the search, since the others hold the same objects.
+func (f *FanoutProvider) read(key string, fn func(provider interfaces.CloudProvider) error) error {
+	var missing []string
+	if key != "" {
+		gap, _ := f.store().MirrorGap(key)
+		missing = gap.Missing
+	}
+
+	var errs []error
+	for _, backend := range f.backends {
+		if slices.Contains(missing, backend.Name) {
+			continue
+		}
+		err := fn(backend.Provider)
+		if err == nil || isNotFound(err) {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", backend.Name, err))
+		if len(errs) < len(f.backends) {
+			f.logger.Debug("Backend read failed, trying the next one",
+				zap.String("backend", backend.Name),
+				zap.Error(err))
+		}
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no backend holds %s", key)
+	}
+	return errors.Join(errs...)
+}
+
+// RepairMirrors copies every object missing from some backends from a
+// backend holding it, returning the number of objects fully repaired
+func (f *FanoutProvider) RepairMirrors(ctx context.Context) (int, error) {
+	repaired := 0
+	var lastErr error
+	for _, gap := range f.store().MirrorGaps() {
+		if err := ctx.Err(); err != nil {
+			return repaired, err
+		}
+		complete, err := repairGap(ctx, f.backends, f.store(), gap, f.logger)
+		if err != nil {
+			f.logger.Warn("Failed to repair mirrored object",
+				zap.String("key", gap.Key),
+				zap.Strings("missing", gap.Missing),
+				zap.Error(err))
+			lastErr = err
+		}
+		if complete {
+			repaired++
+		}
+	}
+	return repaired, lastErr
+}
+
+// repairGap copies one object to the backends missing it through a
+// temporary file, or deletes it from them when it was deleted elsewhere,
+// reporting whether every backend is now up to date
+func repairGap(ctx context.Context, backends []Backend, gaps gapStore, gap state.MirrorGap, logger *zap.Logger) (bool, error) {
+	if gap.Deleted {
+		return finishRepair(gaps, gap, backends, logger, func(backend Backend) error {
+			err := backend.Provider.Delete(ctx, gap.Key)
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		})
+	}
+
+	index := slices.IndexFunc(backends, func(backend Backend) bool {
+		return !slices.Contains(gap.Missing, backend.Name)
+	})
+	if index < 0 {
+		gaps.DeleteMirrorGap(gap.Key)
+		return false, fmt.Errorf("no backend holds %s", gap.Key)
+	}
+	source := backends[index]
+
+	body, metadata, err := source.Provider.Download(ctx, gap.Key, interfaces.TransferOptions{})
+	if isNotFound(err) {
+		// Deleted since the gap was recorded
+		gaps.DeleteMirrorGap(gap.Key)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to download from %s: %w", source.Name, err)
+	}
+	defer body.Close()
+
+	temp, err := os.CreateTemp("", ".cloudawsync-mirror-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	size, err := io.Copy(temp, body)
+	if err != nil {
+		return false, fmt.Errorf("failed to download from %s: %w", source.Name, err)
+	}
+	metadata.Size = size
+
+	return finishRepair(gaps, gap, backends, logger, func(backend Backend) error {
+		if _, err := temp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return backend.Provider.Upload(ctx, gap.Key, temp, metadata, interfaces.TransferOptions{})
+	})
+}
+
+// finishRepair applies fix to every backend missing the object and
+// records the backends still missing it
+func finishRepair(gaps gapStore, gap state.MirrorGap, backends []Backend, logger *zap.Logger, fix func(backend Backend) error) (bool, error) {
+	var missing []string
+	failures := make(map[string]string)
+	for _, backend := range backends {
+		if !slices.Contains(gap.Missing, backend.Name) {
+			continue
+		}
+		if err := fix(backend); err != nil {
+			missing = append(missing, backend.Name)
+			failures[backend.Name] = err.Error()
+			continue
+		}
+		logger.Info("Repaired mirrored object",
+			zap.String("key", gap.Key),
+			zap.String("backend", backend.Name),
+			zap.Bool("deleted", gap.Deleted))
+	}
+
+	if len(missing) == 0 {
+		gaps.DeleteMirrorGap(gap.Key)
+		return true, nil
+	}
+	gap.Missing = missing
+	gap.Errors = failures
+	gap.UpdatedAt = time.Now()
+	gaps.PutMirrorGap(gap)
+	return false, fmt.Errorf("%d backend(s) still missing the object", len(missing))
+}
+
+// furthestProgress returns a progress callback for each of several
+// uploads of the same object, reporting the bytes sent by the upload
+// furthest ahead so the total never exceeds the object's size
+func furthestProgress(progress interfaces.ProgressFunc, uploads int) []interfaces.ProgressFunc {
+	callbacks := make([]interfaces.ProgressFunc, uploads)
+	if progress == nil {
+		return callbacks
+	}
+
+	sent := make([]int64, uploads)
+	var reported int64
+	var mutex sync.Mutex
+	for i := range callbacks {
+		callbacks[i] = func(n int64) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			sent[i] += n
+			if sent[i] > reported {
+				progress(sent[i] - reported)
+				reported = sent[i]
+			}
+		}
+	}
+	return callbacks
+}
+
+// isNotFound reports whether err says that an object does not exist
+func isNotFound(err error) bool {
+	return errors.Is(err, interfaces.ErrNotFound) || errorKind(err) == interfaces.ErrNotFound
+}
+
+// memoryGaps keeps mirror gaps in memory when no state store is set
+type memoryGaps struct {
+	gaps  map[string]state.MirrorGap
+	mutex sync.Mutex
+}
+
+// PutMirrorGap records the backends missing an object
+func (m *memoryGaps) PutMirrorGap(gap state.MirrorGap) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.gaps[gap.Key] = gap
+}
+
+// MirrorGap returns the backends missing the object at key
+func (m *memoryGaps) MirrorGap(key string) (state.MirrorGap, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	gap, ok := m.gaps[key]
+	return gap, ok
+}
+
+// DeleteMirrorGap forgets the gap of the object at key
+func (m *memoryGaps) DeleteMirrorGap(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.gaps, key)
+}
+
+// MirrorGaps returns every gap sorted by key
+func (m *memoryGaps) MirrorGaps() []state.MirrorGap {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	gaps := make([]state.MirrorGap, 0, len(m.gaps))
+	for _, gap := range m.gaps {
+		gaps = append(gaps, gap)
+	}
+	slices.SortFunc(gaps, func(a, b state.MirrorGap) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return gaps
+}
@@ -0,0 +1,476 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"go.uber.org/zap"
+)
+
+// minNameSecret is the minimum length of the secret names are encrypted with
+const minNameSecret = 32
+
+// NameCipher encrypts remote keys one path segment at a time. Encryption
+// is deterministic, using an HMAC of the name as the CTR nonce (the SIV
+// construction), so a name always encrypts to the same value, lookups by
+// key keep working and decryption detects tampering.
+type NameCipher struct {
+	block  cipher.Block
+	macKey []byte
+}
+
+// NewNameCipher derives the name encryption keys from secret
+func NewNameCipher(secret []byte) (*NameCipher, error) {
+	if len(secret) < minNameSecret {
+		return nil, fmt.Errorf("name encryption secret must be at least %d bytes, got %d", minNameSecret, len(secret))
+	}
+
+	keys, err := hkdf.Key(sha256.New, secret, nil, "cloudawsync name encryption", 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive name encryption keys: %w", err)
+	}
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create name cipher: %w", err)
+	}
+	return &NameCipher{block: block, macKey: keys[32:]}, nil
+}
+
+// EncryptName encrypts a single file or directory name
+func (c *NameCipher) EncryptName(name string) string {
+	iv := c.syntheticIV(name)
+	out := make([]byte, aes.BlockSize+len(name))
+	copy(out, iv)
+	cipher.NewCTR(c.block, iv).XORKeyStream(out[aes.BlockSize:], []byte(name))
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecryptName reverses EncryptName
+func (c *NameCipher) DecryptName(encrypted string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < aes.BlockSize {
+		return "", fmt.Errorf("name %q is not encrypted", encrypted)
+	}
+
+	iv := data[:aes.BlockSize]
+	name := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCTR(c.block, iv).XORKeyStream(name, data[aes.BlockSize:])
+	if !hmac.Equal(iv, c.syntheticIV(string(name))) {
+		return "", fmt.Errorf("name %q was not encrypted with this key", encrypted)
+	}
+	return string(name), nil
+}
+
+// EncryptKey encrypts every segment of a slash separated key, keeping the
+// separators so that directory prefixes still select their contents
+func (c *NameCipher) EncryptKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment != "" {
+			segments[i] = c.EncryptName(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// DecryptKey reverses EncryptKey
+func (c *NameCipher) DecryptKey(key string) (string, error) {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		name, err := c.DecryptName(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = name
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// syntheticIV returns the nonce used for name
+func (c *NameCipher) syntheticIV(name string) []byte {
+	mac := hmac.New(sha256.New, c.macKey)
+	mac.Write([]byte(name))
+	return mac.Sum(nil)[:aes.BlockSize]
+}
+
+// nameMapEntry is one line of a NameMap file
+type nameMapEntry struct {
+	Key  string `json:"key"`  // encrypted remote key
+	Name string `json:"name"` // plain remote key
+}
+
+// NameMap is a local record of every encrypted key written by the agent
+// and its plain name, stored as JSON lines. It lets names be recovered
+// without the secret and lists keys the cipher cannot decrypt.
+type NameMap struct {
+	path  string
+	names map[string]string // encrypted key to plain key
+	mutex sync.Mutex
+}
+
+// OpenNameMap loads the name map at path, which is created on the first
+// new entry if it does not exist
+func OpenNameMap(path string) (*NameMap, error) {
+	m := &NameMap{path: path, names: make(map[string]string)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open name map: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry nameMapEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse name map: %w", err)
+		}
+		m.names[entry.Key] = entry.Name
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read name map: %w", err)
+	}
+	return m, nil
+}
+
+// Record adds an entry unless the encrypted key is already known
+func (m *NameMap) Record(key, name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.names[key]; ok {
+		return nil
+	}
+
+	line, err := json.Marshal(nameMapEntry{Key: key, Name: name})
+	if err != nil {
+		return fmt.Errorf("failed to encode name map entry: %w", err)
+	}
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open name map: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write name map: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close name map: %w", err)
+	}
+
+	m.names[key] = name
+	return nil
+}
+
+// Lookup returns the plain name of an encrypted key
+func (m *NameMap) Lookup(key string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, ok := m.names[key]
+	return name, ok
+}
+
+// ObfuscatedProvider stores objects under encrypted keys in the wrapped
+// provider. Callers use plain keys; listings are decrypted. Optional
+// capabilities are forwarded and fail with errors.ErrUnsupported when the
+// wrapped provider lacks them.
+type ObfuscatedProvider struct {
+	provider interfaces.CloudProvider
+	cipher   *NameCipher
+	names    *NameMap // nil when no name map is kept
+	logger   *zap.Logger
+}
+
+// NewObfuscatedProvider wraps provider so that every key is encrypted with
+// nameCipher. names may be nil.
+func NewObfuscatedProvider(provider interfaces.CloudProvider, nameCipher *NameCipher, names *NameMap, logger *zap.Logger) *ObfuscatedProvider {
+	return &ObfuscatedProvider{
+		provider: provider,
+		cipher:   nameCipher,
+		names:    names,
+		logger:   logger,
+	}
+}
+
+// encrypt encrypts a key, recording it in the name map when written
+func (o *ObfuscatedProvider) encrypt(key string, record bool) string {
+	encrypted := o.cipher.EncryptKey(key)
+	if record && o.names != nil {
+		if err := o.names.Record(encrypted, key); err != nil {
+			o.logger.Warn("Failed to record encrypted name",
+				zap.String("key", key),
+				zap.Error(err))
+		}
+	}
+	return encrypted
+}
+
+// decrypt returns the plain form of an encrypted key, falling back to the
+// name map for keys the cipher cannot decrypt
+func (o *ObfuscatedProvider) decrypt(key string) (string, bool) {
+	if name, err := o.cipher.DecryptKey(key); err == nil {
+		return name, true
+	}
+	if o.names != nil {
+		if name, ok := o.names.Lookup(key); ok {
+			return name, true
+		}
+	}
+	o.logger.Debug("Skipping remote key that cannot be decrypted", zap.String("key", key))
+	return "", false
+}
+
+// Upload uploads a file under its encrypted key
+func (o *ObfuscatedProvider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	if metadata.OriginalKey != "" {
+		metadata.OriginalKey = o.cipher.EncryptKey(metadata.OriginalKey)
+	}
+	return o.provider.Upload(ctx, o.encrypt(key, true), reader, metadata, options)
+}
+
+// Download downloads the file stored under the encrypted key
+func (o *ObfuscatedProvider) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	body, metadata, err := o.provider.Download(ctx, o.encrypt(key, false), options)
+	return body, o.decryptMetadata(metadata), err
+}
+
+// decryptMetadata decrypts the original key recorded with an object stored
+// under a shortened key
+func (o *ObfuscatedProvider) decryptMetadata(metadata interfaces.FileMetadata) interfaces.FileMetadata {
+	if metadata.OriginalKey != "" {
+		metadata.OriginalKey, _ = o.decrypt(metadata.OriginalKey)
+	}
+	return metadata
+}
+
+// DownloadRange downloads part of the file stored under the encrypted key
+func (o *ObfuscatedProvider) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	ranger, ok := o.provider.(interfaces.RangeProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to download range: %w", errors.ErrUnsupported)
+	}
+	return ranger.DownloadRange(ctx, o.encrypt(key, false), offset, length, options)
+}
+
+// Delete removes the file stored under the encrypted key
+func (o *ObfuscatedProvider) Delete(ctx context.Context, key string) error {
+	return o.provider.Delete(ctx, o.encrypt(key, false))
+}
+
+// Copy copies an object between encrypted keys
+func (o *ObfuscatedProvider) Copy(ctx context.Context, srcKey, dstKey string) error {
+	copier, ok := o.provider.(interfaces.CopyProvider)
+	if !ok {
+		return fmt.Errorf("failed to copy object: %w", errors.ErrUnsupported)
+	}
+	return copier.Copy(ctx, o.encrypt(srcKey, false), o.encrypt(dstKey, true))
+}
+
+// CreateMultipartUpload starts a multipart upload under the encrypted key
+func (o *ObfuscatedProvider) CreateMultipartUpload(ctx context.Context, key string, metadata interfaces.FileMetadata) (string, error) {
+	uploader, ok := o.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return "", fmt.Errorf("failed to create multipart upload: %w", errors.ErrUnsupported)
+	}
+	if metadata.OriginalKey != "" {
+		metadata.OriginalKey = o.cipher.EncryptKey(metadata.OriginalKey)
+	}
+	return uploader.CreateMultipartUpload(ctx, o.encrypt(key, true), metadata)
+}
+
+// UploadPart uploads a part of the upload under the encrypted key
+func (o *ObfuscatedProvider) UploadPart(ctx context.Context, key, uploadID string, number int, body io.ReadSeeker, size int64, options interfaces.TransferOptions) (string, error) {
+	uploader, ok := o.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return "", fmt.Errorf("failed to upload part: %w", errors.ErrUnsupported)
+	}
+	return uploader.UploadPart(ctx, o.encrypt(key, false), uploadID, number, body, size, options)
+}
+
+// ListParts lists the parts of the upload under the encrypted key
+func (o *ObfuscatedProvider) ListParts(ctx context.Context, key, uploadID string) ([]interfaces.UploadedPart, error) {
+	uploader, ok := o.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to list parts: %w", errors.ErrUnsupported)
+	}
+	return uploader.ListParts(ctx, o.encrypt(key, false), uploadID)
+}
+
+// CompleteMultipartUpload completes the upload under the encrypted key
+func (o *ObfuscatedProvider) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []interfaces.UploadedPart) error {
+	uploader, ok := o.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return fmt.Errorf("failed to complete multipart upload: %w", errors.ErrUnsupported)
+	}
+	return uploader.CompleteMultipartUpload(ctx, o.encrypt(key, false), uploadID, parts)
+}
+
+// AbortMultipartUpload aborts the upload under the encrypted key
+func (o *ObfuscatedProvider) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	uploader, ok := o.provider.(interfaces.MultipartProvider)
+	if !ok {
+		return fmt.Errorf("failed to abort multipart upload: %w", errors.ErrUnsupported)
+	}
+	return uploader.AbortMultipartUpload(ctx, o.encrypt(key, false), uploadID)
+}
+
+// Restore requests a restore of the object under its encrypted key
+func (o *ObfuscatedProvider) Restore(ctx context.Context, key string, days int, tier string) error {
+	restorer, ok := o.provider.(interfaces.RestoreProvider)
+	if !ok {
+		return fmt.Errorf("failed to request restore: %w", errors.ErrUnsupported)
+	}
+	return restorer.Restore(ctx, o.encrypt(key, false), days, tier)
+}
+
+// List lists the files whose plain key starts with prefix. Encrypted
+// names only share prefixes at segment boundaries, so a prefix ending
+// inside a name lists its parent directory and filters the plain keys.
+func (o *ObfuscatedProvider) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	parent, _ := splitPrefix(prefix)
+	files, err := o.provider.List(ctx, o.encrypt(parent, false))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := files[:0]
+	for _, file := range files {
+		if name, ok := o.decrypt(file.Key); ok && strings.HasPrefix(name, prefix) {
+			file.Key = name
+			decrypted = append(decrypted, file)
+		}
+	}
+	return decrypted, nil
+}
+
+// ListVersions lists the object versions whose plain key starts with
+// prefix, like List
+func (o *ObfuscatedProvider) ListVersions(ctx context.Context, prefix string) ([]interfaces.ObjectVersion, error) {
+	versioned, ok := o.provider.(interfaces.VersionedProvider)
+	if !ok {
+		return nil, fmt.Errorf("failed to list versions: %w", errors.ErrUnsupported)
+	}
+	parent, _ := splitPrefix(prefix)
+	versions, err := versioned.ListVersions(ctx, o.encrypt(parent, false))
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := versions[:0]
+	for _, version := range versions {
+		if name, ok := o.decrypt(version.Key); ok && strings.HasPrefix(name, prefix) {
+			version.Key = name
+			decrypted = append(decrypted, version)
+		}
+	}
+	return decrypted, nil
+}
+
+// DeleteVersion deletes one version of the object under the encrypted key
+func (o *ObfuscatedProvider) DeleteVersion(ctx context.Context, key, versionID string) error {
+	versioned, ok := o.provider.(interfaces.VersionedProvider)
+	if !ok {
+		return fmt.Errorf("failed to delete version: %w", errors.ErrUnsupported)
+	}
+	return versioned.DeleteVersion(ctx, o.encrypt(key, false), versionID)
+}
+
+// GetMetadata retrieves metadata of the file under the encrypted key
+func (o *ObfuscatedProvider) GetMetadata(ctx context.Context, key string) (interfaces.FileMetadata, error) {
+	metadata, err := o.provider.GetMetadata(ctx, o.encrypt(key, false))
+	return o.decryptMetadata(metadata), err
+}
+
+// GetMetadataIfChanged returns the file's metadata under the encrypted key
+// unless it is unchanged. Providers without conditional requests always
+// report a change.
+func (o *ObfuscatedProvider) GetMetadataIfChanged(ctx context.Context, key, etag string) (interfaces.FileMetadata, bool, error) {
+	if conditional, ok := o.provider.(interfaces.ConditionalProvider); ok {
+		metadata, changed, err := conditional.GetMetadataIfChanged(ctx, o.encrypt(key, false), etag)
+		return o.decryptMetadata(metadata), changed, err
+	}
+	metadata, err := o.provider.GetMetadata(ctx, o.encrypt(key, false))
+	return o.decryptMetadata(metadata), err == nil, err
+}
+
+// Exists checks whether a file exists under the encrypted key
+func (o *ObfuscatedProvider) Exists(ctx context.Context, key string) (bool, error) {
+	return o.provider.Exists(ctx, o.encrypt(key, false))
+}
+
+// StorageUsage returns the usage of the objects whose plain key starts
+// with prefix. A prefix ending inside a name is counted from a listing.
+func (o *ObfuscatedProvider) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	if _, partial := splitPrefix(prefix); !partial {
+		return o.provider.StorageUsage(ctx, o.encrypt(prefix, false))
+	}
+
+	files, err := o.List(ctx, prefix)
+	if err != nil {
+		return interfaces.StorageUsage{}, err
+	}
+	var usage interfaces.StorageUsage
+	for _, file := range files {
+		usage.Bytes += file.Size
+		usage.Objects++
+	}
+	return usage, nil
+}
+
+// ClockSkew returns the clock skew measured by the wrapped provider
+func (o *ObfuscatedProvider) ClockSkew() (time.Duration, bool) {
+	if measurer, ok := o.provider.(interfaces.ClockSkewProvider); ok {
+		return measurer.ClockSkew()
+	}
+	return 0, false
+}
+
+// splitPrefix returns the part of prefix up to its last separator and
+// whether a partial name follows it
+func splitPrefix(prefix string) (parent string, partial bool) {
+	i := strings.LastIndex(prefix, "/")
+	return prefix[:i+1], i+1 < len(prefix)
+}
@@ -0,0 +1,606 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package providertest checks that a CloudProvider implementation behaves
+// the way the sync engine expects. Every backend should pass it:
+//
+//	func TestConformance(t *testing.T) {
+//		providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+//			return newTestProvider(t)
+//		})
+//	}
+package providertest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// LargeObjectSize is the size of the object used to check large transfers.
+// It is above the 5MB multipart threshold used by S3 clients.
+const LargeObjectSize = 12 * 1024 * 1024
+
+// Factory returns a provider to test. It is called once per subtest; the
+// suite writes only below a unique prefix and deletes what it creates.
+type Factory func(t *testing.T) interfaces.CloudProvider
+
+// Run runs the conformance suite against the providers made by factory.
+// The large object and multipart tests are skipped with -short, the copy
+// test when the provider does not implement interfaces.CopyProvider, the
+// range test when it does not implement interfaces.RangeProvider and the
+// multipart test when it does not implement interfaces.MultipartProvider.
+// Progress callbacks are checked on every provider.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s *suite)
+	}{
+		{"UploadDownload", testUploadDownload},
+		{"Metadata", testMetadata},
+		{"Overwrite", testOverwrite},
+		{"EmptyObject", testEmptyObject},
+		{"LargeObject", testLargeObject},
+		{"SpecialCharacters", testSpecialCharacters},
+		{"Missing", testMissing},
+		{"Delete", testDelete},
+		{"ListPrefix", testListPrefix},
+		{"StorageUsage", testStorageUsage},
+		{"Copy", testCopy},
+		{"Progress", testProgress},
+		{"Range", testRange},
+		{"Multipart", testMultipart},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &suite{
+				provider: factory(t),
+				base:     fmt.Sprintf("providertest-%d/", time.Now().UnixNano()),
+			}
+			t.Cleanup(func() { s.cleanup(t) })
+			test.run(t, s)
+		})
+	}
+}
+
+// suite holds the provider under test and the keys written to it
+type suite struct {
+	provider interfaces.CloudProvider
+	base     string
+	keys     []string
+}
+
+// key returns name below the suite's unique prefix
+func (s *suite) key(name string) string {
+	return s.base + name
+}
+
+// context returns a context bounded so a hung backend fails the test
+func (s *suite) context(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// upload stores data under key and fails the test on error
+func (s *suite) upload(t *testing.T, key string, data []byte) {
+	t.Helper()
+	s.uploadModified(t, key, data, time.Now())
+}
+
+// uploadModified stores data under key as a file last modified at
+// modTime and fails the test on error
+func (s *suite) uploadModified(t *testing.T, key string, data []byte, modTime time.Time) {
+	t.Helper()
+
+	metadata := interfaces.FileMetadata{
+		Size:        int64(len(data)),
+		ModTime:     modTime,
+		MD5Hash:     md5Hex(data),
+		ContentType: "application/octet-stream",
+		Permissions: "-rw-r--r--",
+	}
+	s.keys = append(s.keys, key)
+	if err := s.provider.Upload(s.context(t), key, bytes.NewReader(data), metadata, interfaces.TransferOptions{}); err != nil {
+		t.Fatalf("Upload(%q) failed: %v", key, err)
+	}
+}
+
+// download returns the content stored under key and fails the test on error
+func (s *suite) download(t *testing.T, key string) ([]byte, interfaces.FileMetadata) {
+	t.Helper()
+
+	body, metadata, err := s.provider.Download(s.context(t), key, interfaces.TransferOptions{})
+	if err != nil {
+		t.Fatalf("Download(%q) failed: %v", key, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("reading download of %q failed: %v", key, err)
+	}
+	return data, metadata
+}
+
+// list returns the sorted keys listed under prefix
+func (s *suite) list(t *testing.T, prefix string) []string {
+	t.Helper()
+
+	files, err := s.provider.List(s.context(t), prefix)
+	if err != nil {
+		t.Fatalf("List(%q) failed: %v", prefix, err)
+	}
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir {
+			keys = append(keys, file.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cleanup deletes every key the test wrote
+func (s *suite) cleanup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, key := range s.keys {
+		if err := s.provider.Delete(ctx, key); err != nil {
+			t.Logf("cleanup of %q failed: %v", key, err)
+		}
+	}
+}
+
+func testUploadDownload(t *testing.T, s *suite) {
+	key := s.key("hello.txt")
+	data := []byte("hello, world\n")
+	s.upload(t, key, data)
+
+	got, metadata := s.download(t, key)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Download returned %q, want %q", got, data)
+	}
+	if metadata.Size != int64(len(data)) {
+		t.Errorf("Download metadata size = %d, want %d", metadata.Size, len(data))
+	}
+}
+
+func testMetadata(t *testing.T, s *suite) {
+	key := s.key("metadata.bin")
+	data := []byte("metadata test content")
+	before := time.Now().Add(-time.Hour)
+	modTime := time.Now().Add(-time.Minute).Truncate(time.Second).Add(123456789)
+	s.uploadModified(t, key, data, modTime)
+
+	metadata, err := s.provider.GetMetadata(s.context(t), key)
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %v", err)
+	}
+	if metadata.Size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", metadata.Size, len(data))
+	}
+	if metadata.MD5Hash != md5Hex(data) {
+		t.Errorf("MD5 hash = %q, want %q", metadata.MD5Hash, md5Hex(data))
+	}
+	if metadata.ModTime.Before(before) {
+		t.Errorf("modification time %v is not recent", metadata.ModTime)
+	}
+	if !metadata.SourceModTime.IsZero() && !metadata.SourceModTime.Equal(modTime) {
+		t.Errorf("source modification time = %v, want %v or zero", metadata.SourceModTime, modTime)
+	}
+	_, downloaded := s.download(t, key)
+	if !downloaded.SourceModTime.Equal(metadata.SourceModTime) {
+		t.Errorf("downloaded source modification time = %v, want %v", downloaded.SourceModTime, metadata.SourceModTime)
+	}
+
+	files, err := s.provider.List(s.context(t), key)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Key != key {
+		t.Fatalf("List(%q) = %+v, want the single object", key, files)
+	}
+	if files[0].Size != int64(len(data)) {
+		t.Errorf("listed size = %d, want %d", files[0].Size, len(data))
+	}
+	if files[0].MD5Hash != "" && files[0].MD5Hash != md5Hex(data) {
+		t.Errorf("listed MD5 hash = %q, want %q or empty", files[0].MD5Hash, md5Hex(data))
+	}
+}
+
+func testOverwrite(t *testing.T, s *suite) {
+	key := s.key("overwrite.txt")
+	s.upload(t, key, []byte("first version"))
+	s.upload(t, key, []byte("second"))
+
+	got, _ := s.download(t, key)
+	if string(got) != "second" {
+		t.Errorf("Download after overwrite returned %q, want %q", got, "second")
+	}
+	if keys := s.list(t, s.base); len(keys) != 1 {
+		t.Errorf("List after overwrite returned %v, want one key", keys)
+	}
+}
+
+func testEmptyObject(t *testing.T, s *suite) {
+	key := s.key("empty")
+	s.upload(t, key, nil)
+
+	got, metadata := s.download(t, key)
+	if len(got) != 0 || metadata.Size != 0 {
+		t.Errorf("empty object returned %d bytes, size %d", len(got), metadata.Size)
+	}
+}
+
+func testLargeObject(t *testing.T, s *suite) {
+	if testing.Short() {
+		t.Skip("skipping large object in short mode")
+	}
+
+	data := make([]byte, LargeObjectSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	key := s.key("large.bin")
+	s.upload(t, key, data)
+
+	got, _ := s.download(t, key)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("large object differs after round trip (got %d bytes, md5 %s, want md5 %s)",
+			len(got), md5Hex(got), md5Hex(data))
+	}
+
+	metadata, err := s.provider.GetMetadata(s.context(t), key)
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %v", err)
+	}
+	if metadata.Size != LargeObjectSize {
+		t.Errorf("size = %d, want %d", metadata.Size, LargeObjectSize)
+	}
+	if metadata.MD5Hash != md5Hex(data) {
+		t.Errorf("MD5 hash = %q, want %q", metadata.MD5Hash, md5Hex(data))
+	}
+}
+
+func testSpecialCharacters(t *testing.T, s *suite) {
+	names := []string{
+		"with space.txt",
+		"plus+sign.txt",
+		"percent%20encoded.txt",
+		"hash#question?.txt",
+		"amp&equals=.txt",
+		"semi;colon,comma.txt",
+		"quote'single.txt",
+		"unicode-ñandú-日本語.txt",
+		"emoji-📁.txt",
+		"nested/dir with space/file.txt",
+	}
+
+	var want []string
+	for _, name := range names {
+		key := s.key(name)
+		want = append(want, key)
+		s.upload(t, key, []byte(name))
+	}
+	sort.Strings(want)
+
+	for _, key := range want {
+		got, _ := s.download(t, key)
+		if string(got) != strings.TrimPrefix(key, s.base) {
+			t.Errorf("Download(%q) returned %q", key, got)
+		}
+		exists, err := s.provider.Exists(s.context(t), key)
+		if err != nil || !exists {
+			t.Errorf("Exists(%q) = %v, %v; want true", key, exists, err)
+		}
+	}
+
+	if got := s.list(t, s.base); !slices.Equal(got, want) {
+		t.Errorf("List returned %q, want %q", got, want)
+	}
+}
+
+func testMissing(t *testing.T, s *suite) {
+	key := s.key("does-not-exist")
+	ctx := s.context(t)
+
+	exists, err := s.provider.Exists(ctx, key)
+	if err != nil {
+		t.Errorf("Exists on a missing key failed: %v", err)
+	}
+	if exists {
+		t.Error("Exists reported a missing key as present")
+	}
+	if _, err := s.provider.GetMetadata(ctx, key); !errors.Is(err, interfaces.ErrNotFound) {
+		t.Errorf("GetMetadata on a missing key returned %v, want interfaces.ErrNotFound", err)
+	}
+	body, _, err := s.provider.Download(ctx, key, interfaces.TransferOptions{})
+	if err == nil {
+		body.Close()
+		t.Error("Download of a missing key succeeded")
+	} else if !errors.Is(err, interfaces.ErrNotFound) {
+		t.Errorf("Download of a missing key returned %v, want interfaces.ErrNotFound", err)
+	}
+	if err := s.provider.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key failed: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, s *suite) {
+	keep, remove := s.key("keep.txt"), s.key("remove.txt")
+	s.upload(t, keep, []byte("keep"))
+	s.upload(t, remove, []byte("remove"))
+
+	if err := s.provider.Delete(s.context(t), remove); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	exists, err := s.provider.Exists(s.context(t), remove)
+	if err != nil || exists {
+		t.Errorf("Exists after Delete = %v, %v; want false", exists, err)
+	}
+	if got := s.list(t, s.base); !slices.Equal(got, []string{keep}) {
+		t.Errorf("List after Delete returned %q, want %q", got, []string{keep})
+	}
+}
+
+func testListPrefix(t *testing.T, s *suite) {
+	inside := []string{s.key("dir/a.txt"), s.key("dir/sub/b.txt"), s.key("dir/sub/deeper/c.txt")}
+	sibling := s.key("dirx/d.txt")
+	for _, key := range append(inside, sibling) {
+		s.upload(t, key, []byte(key))
+	}
+
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{s.key("dir/"), inside},
+		{s.key("dir"), append(append([]string{}, inside...), sibling)},
+		{s.key("dir/sub/"), inside[1:]},
+		{s.key("dir/a"), inside[:1]},
+		{s.key("none/"), nil},
+	}
+	for _, c := range cases {
+		want := append([]string{}, c.want...)
+		sort.Strings(want)
+		if got := s.list(t, c.prefix); !slices.Equal(got, want) {
+			t.Errorf("List(%q) returned %q, want %q", c.prefix, got, want)
+		}
+	}
+}
+
+func testStorageUsage(t *testing.T, s *suite) {
+	s.upload(t, s.key("usage/one"), []byte("12345"))
+	s.upload(t, s.key("usage/two"), []byte("1234567890"))
+	s.upload(t, s.key("other"), []byte("x"))
+
+	usage, err := s.provider.StorageUsage(s.context(t), s.key("usage/"))
+	if err != nil {
+		t.Fatalf("StorageUsage failed: %v", err)
+	}
+	if usage.Bytes != 15 || usage.Objects != 2 {
+		t.Errorf("StorageUsage = %+v, want 15 bytes in 2 objects", usage)
+	}
+}
+
+func testCopy(t *testing.T, s *suite) {
+	copier, ok := s.provider.(interfaces.CopyProvider)
+	if !ok {
+		t.Skip("provider does not implement CopyProvider")
+	}
+
+	src := s.key("copy/source with space.txt")
+	dst := s.key("copy/destination+plus.txt")
+	data := []byte("copied content")
+	s.upload(t, src, data)
+
+	s.keys = append(s.keys, dst)
+	if err := copier.Copy(s.context(t), src, dst); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	got, metadata := s.download(t, dst)
+	if !bytes.Equal(got, data) {
+		t.Errorf("Download of copy returned %q, want %q", got, data)
+	}
+	if metadata.MD5Hash != md5Hex(data) {
+		t.Errorf("copy MD5 hash = %q, want %q", metadata.MD5Hash, md5Hex(data))
+	}
+	if got, _ := s.download(t, src); !bytes.Equal(got, data) {
+		t.Errorf("source changed after copy: %q", got)
+	}
+
+	if err := copier.Copy(s.context(t), s.key("copy/missing"), s.key("copy/nowhere")); err == nil {
+		t.Error("Copy of a missing object succeeded")
+	}
+}
+
+func testMultipart(t *testing.T, s *suite) {
+	uploader, ok := s.provider.(interfaces.MultipartProvider)
+	if !ok {
+		t.Skip("provider does not implement MultipartProvider")
+	}
+	if testing.Short() {
+		t.Skip("skipping multipart upload in short mode")
+	}
+
+	// S3 requires every part but the last to be at least 5MB
+	const partSize = 5 * 1024 * 1024
+	data := make([]byte, partSize+1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	key := s.key("multipart.bin")
+	ctx := s.context(t)
+
+	metadata := interfaces.FileMetadata{Size: int64(len(data)), MD5Hash: md5Hex(data), ContentType: "application/octet-stream"}
+	uploadID, err := uploader.CreateMultipartUpload(ctx, key, metadata)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("wrapped provider does not support multipart uploads")
+	}
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	s.keys = append(s.keys, key)
+
+	// Upload the last part first, as a continued upload may
+	last := data[partSize:]
+	lastETag, err := uploader.UploadPart(ctx, key, uploadID, 2, bytes.NewReader(last), int64(len(last)), interfaces.TransferOptions{})
+	if err != nil {
+		t.Fatalf("UploadPart(2) failed: %v", err)
+	}
+	parts, err := uploader.ListParts(ctx, key, uploadID)
+	if err != nil {
+		t.Fatalf("ListParts failed: %v", err)
+	}
+	if len(parts) != 1 || parts[0].Number != 2 || parts[0].Size != int64(len(last)) || parts[0].ETag != lastETag {
+		t.Errorf("ListParts = %+v, want part 2 of %d bytes with ETag %s", parts, len(last), lastETag)
+	}
+
+	firstETag, err := uploader.UploadPart(ctx, key, uploadID, 1, bytes.NewReader(data[:partSize]), partSize, interfaces.TransferOptions{})
+	if err != nil {
+		t.Fatalf("UploadPart(1) failed: %v", err)
+	}
+	completed := []interfaces.UploadedPart{
+		{Number: 1, ETag: firstETag, Size: partSize},
+		{Number: 2, ETag: lastETag, Size: int64(len(last))},
+	}
+	if err := uploader.CompleteMultipartUpload(ctx, key, uploadID, completed); err != nil {
+		t.Fatalf("CompleteMultipartUpload failed: %v", err)
+	}
+
+	got, stored := s.download(t, key)
+	if !bytes.Equal(got, data) {
+		t.Errorf("multipart object differs after round trip (got %d bytes, want %d)", len(got), len(data))
+	}
+	if stored.MD5Hash != metadata.MD5Hash {
+		t.Errorf("multipart object MD5 hash = %q, want %q", stored.MD5Hash, metadata.MD5Hash)
+	}
+	if _, err := uploader.ListParts(ctx, key, uploadID); !errors.Is(err, interfaces.ErrNotFound) {
+		t.Errorf("ListParts of a completed upload returned %v, want interfaces.ErrNotFound", err)
+	}
+
+	abortKey := s.key("multipart-aborted.bin")
+	abortID, err := uploader.CreateMultipartUpload(ctx, abortKey, metadata)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	if err := uploader.AbortMultipartUpload(ctx, abortKey, abortID); err != nil {
+		t.Fatalf("AbortMultipartUpload failed: %v", err)
+	}
+	if _, err := uploader.ListParts(ctx, abortKey, abortID); !errors.Is(err, interfaces.ErrNotFound) {
+		t.Errorf("ListParts of an aborted upload returned %v, want interfaces.ErrNotFound", err)
+	}
+}
+
+func testProgress(t *testing.T, s *suite) {
+	key := s.key("progress.bin")
+	data := bytes.Repeat([]byte("progress"), 64*1024)
+	metadata := interfaces.FileMetadata{
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+		MD5Hash: md5Hex(data),
+	}
+
+	// Bodies may be read more than once, e.g. to sign the request, so
+	// uploads report at least the object size
+	var uploaded int64
+	s.keys = append(s.keys, key)
+	options := interfaces.TransferOptions{Progress: func(n int64) { uploaded += n }}
+	if err := s.provider.Upload(s.context(t), key, bytes.NewReader(data), metadata, options); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if uploaded < int64(len(data)) {
+		t.Errorf("upload progress reported %d bytes, want at least %d", uploaded, len(data))
+	}
+
+	var downloaded int64
+	options = interfaces.TransferOptions{Progress: func(n int64) { downloaded += n }}
+	body, _, err := s.provider.Download(s.context(t), key, options)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	defer body.Close()
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		t.Fatalf("reading download failed: %v", err)
+	}
+	if downloaded != int64(len(data)) {
+		t.Errorf("download progress reported %d bytes, want %d", downloaded, len(data))
+	}
+}
+
+func testRange(t *testing.T, s *suite) {
+	ranger, ok := s.provider.(interfaces.RangeProvider)
+	if !ok {
+		t.Skip("provider does not implement RangeProvider")
+	}
+
+	key := s.key("range.txt")
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	s.upload(t, key, data)
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 10, "0123456789"},
+		{10, 6, "abcdef"},
+		{30, 6, "uvwxyz"},
+		{30, 100, "uvwxyz"}, // past the end returns the remainder
+	}
+	for _, test := range tests {
+		body, err := ranger.DownloadRange(s.context(t), key, test.offset, test.length, interfaces.TransferOptions{})
+		if err != nil {
+			t.Errorf("DownloadRange(%d, %d) failed: %v", test.offset, test.length, err)
+			continue
+		}
+		got, err := io.ReadAll(body)
+		body.Close()
+		if err != nil {
+			t.Errorf("reading range %d+%d failed: %v", test.offset, test.length, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("DownloadRange(%d, %d) = %q, want %q", test.offset, test.length, got, test.want)
+		}
+	}
+
+	if body, err := ranger.DownloadRange(s.context(t), s.key("range-missing"), 0, 10, interfaces.TransferOptions{}); err == nil {
+		body.Close()
+		t.Error("DownloadRange of a missing key succeeded")
+	}
+}
+
+// md5Hex returns the hex encoded MD5 hash of data
+func md5Hex(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
@@ -0,0 +1,159 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// instanceMetadataHosts are never proxied, so credentials from the EC2 and
+// ECS metadata services keep working behind a proxy
+var instanceMetadataHosts = []string{"169.254.169.254", "169.254.170.2", "fd00:ec2::254"}
+
+// proxyCheckTimeout bounds the startup proxy check when no TLS handshake
+// timeout is configured
+const proxyCheckTimeout = 10 * time.Second
+
+// proxyFunc returns the proxy selection for storage requests. The
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply unless
+// cfg names a proxy; cfg.NoProxy adds to NO_PROXY.
+func proxyFunc(cfg HTTPConfig) func(*url.URL) (*url.URL, error) {
+	proxyConfig := httpproxy.FromEnvironment()
+	if cfg.Proxy != "" {
+		proxyConfig.HTTPProxy = cfg.Proxy
+		proxyConfig.HTTPSProxy = cfg.Proxy
+	}
+
+	var noProxy []string
+	if proxyConfig.NoProxy != "" {
+		noProxy = append(noProxy, proxyConfig.NoProxy)
+	}
+	noProxy = append(noProxy, cfg.NoProxy...)
+	noProxy = append(noProxy, instanceMetadataHosts...)
+	proxyConfig.NoProxy = strings.Join(noProxy, ",")
+
+	return proxyConfig.ProxyFunc()
+}
+
+// checkProxy verifies that the proxy chosen for target, if any, accepts
+// connections, so a wrong proxy address or credentials are reported as such
+// at startup instead of as a failed bucket check. HTTP and HTTPS proxies
+// are asked to CONNECT to target, which also checks authentication; SOCKS
+// proxies are only checked for reachability.
+func checkProxy(ctx context.Context, cfg HTTPConfig, target *url.URL) error {
+	proxyURL, err := proxyFunc(cfg)(target)
+	if err != nil {
+		return fmt.Errorf("invalid proxy configuration: %w", err)
+	}
+	if proxyURL == nil {
+		return nil
+	}
+
+	timeout := cfg.TLSHandshakeTimeout
+	if timeout <= 0 {
+		timeout = proxyCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	address := proxyAddress(proxyURL)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("proxy %s is unreachable: %w", proxyURL.Redacted(), err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		return nil
+	case "https":
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return fmt.Errorf("TLS handshake with proxy %s failed: %w", proxyURL.Redacted(), err)
+		}
+		conn = tlsConn
+	}
+
+	targetAddress := target.Host
+	if target.Port() == "" {
+		targetAddress = net.JoinHostPort(target.Hostname(), defaultPort(target.Scheme))
+	}
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", targetAddress, targetAddress)
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		return fmt.Errorf("failed to send request to proxy %s: %w", proxyURL.Redacted(), err)
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return fmt.Errorf("invalid response from proxy %s: %w", proxyURL.Redacted(), err)
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusProxyAuthRequired:
+		return fmt.Errorf("proxy %s rejected the configured credentials", proxyURL.Redacted())
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Redacted(), targetAddress, response.Status)
+	}
+	return nil
+}
+
+// proxyAddress returns the host:port of a proxy, filling in the default
+// port for its scheme
+func proxyAddress(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), defaultPort(proxyURL.Scheme))
+}
+
+// defaultPort returns the port used when a URL names none
+func defaultPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return "1080"
+	default:
+		return "80"
+	}
+}
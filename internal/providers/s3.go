@@ -25,18 +25,24 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"go.uber.org/zap"
 )
 
@@ -47,6 +53,7 @@ type S3Provider struct {
 	prefix string
 	logger *zap.Logger
 	config S3Config
+	skew   *clockSkew
 }
 
 // S3Config holds S3-specific configuration
@@ -60,12 +67,22 @@ type S3Config struct {
 	SessionToken         string
 	StorageClass         string
 	ServerSideEncryption bool
+	HTTP                 HTTPConfig
+	Metrics              interfaces.MetricsCollector // records every API call, nil disables
+	MaxRequestDelay      time.Duration               // longest spacing between throttled requests, 0 disables pacing
 }
 
-// NewS3Provider creates a new S3 provider
-func NewS3Provider(cfg S3Config, logger *zap.Logger) (*S3Provider, error) {
-	awsConfig, err := config.LoadDefaultConfig(context.TODO(),
+// NewS3Provider creates a new S3 provider, checking the proxy and bucket
+// access with ctx
+func NewS3Provider(ctx context.Context, cfg S3Config, logger *zap.Logger) (*S3Provider, error) {
+	httpClient, err := newHTTPClient(cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(ctx,
 		config.WithRegion(cfg.Region),
+		config.WithHTTPClient(httpClient),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
@@ -81,16 +98,31 @@ func NewS3Provider(cfg S3Config, logger *zap.Logger) (*S3Provider, error) {
 			}, nil
 		})
 	}
-
-	// Configure custom endpoint if provided
-	if cfg.Endpoint != "" {
-		awsConfig.EndpointResolverWithOptions = aws.EndpointResolverWithOptionsFunc(
-			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-				return aws.Endpoint{URL: cfg.Endpoint}, nil
-			})
+	if awsConfig.Credentials != nil {
+		awsConfig.Credentials = markCredentialErrors(awsConfig.Credentials)
 	}
 
-	client := s3.NewFromConfig(awsConfig)
+	skew := &clockSkew{}
+	client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, skew.middleware(), failureLog(cfg.Bucket, logger))
+		if cfg.MaxRequestDelay > 0 {
+			pacer := newRequestPacer(cfg.Bucket, cfg.MaxRequestDelay, cfg.Metrics, logger)
+			o.APIOptions = append(o.APIOptions, pacer.middleware())
+		}
+		if cfg.Metrics != nil {
+			o.APIOptions = append(o.APIOptions, requestMetrics(cfg.Bucket, cfg.Metrics))
+		}
+		if cfg.Endpoint == "" {
+			return
+		}
+		// S3-compatible servers such as MinIO rarely resolve bucket
+		// subdomains and many reject the SDK's default CRC32 trailing
+		// checksums. Content-MD5 still protects every upload.
+		o.BaseEndpoint = aws.String(cfg.Endpoint)
+		o.UsePathStyle = true
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
 
 	provider := &S3Provider{
 		client: client,
@@ -98,36 +130,107 @@ func NewS3Provider(cfg S3Config, logger *zap.Logger) (*S3Provider, error) {
 		prefix: cfg.Prefix,
 		logger: logger,
 		config: cfg,
+		skew:   skew,
+	}
+
+	// Check the proxy first so its failures are not reported as bucket errors
+	if err := checkProxy(ctx, cfg.HTTP, endpointURL(cfg)); err != nil {
+		return nil, err
 	}
 
 	// Verify bucket access
-	if err := provider.verifyBucketAccess(context.Background()); err != nil {
+	if err := provider.verifyBucketAccess(ctx); err != nil {
 		return nil, fmt.Errorf("failed to verify bucket access: %w", err)
 	}
 
 	return provider, nil
 }
 
+// ResolveCredentials returns the credentials the AWS SDK finds in the
+// environment, or in the named shared configuration profile when profile is
+// set
+func ResolveCredentials(ctx context.Context, profile string) (aws.Credentials, error) {
+	var options []func(*config.LoadOptions) error
+	if profile != "" {
+		options = append(options, config.WithSharedConfigProfile(profile))
+	}
+	awsConfig, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	credentials, err := awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// errCredentials marks failures to find or refresh credentials
+var errCredentials = errors.New("no usable AWS credentials")
+
+// markCredentialErrors wraps credential lookup failures of provider in
+// errCredentials so they are reported as authentication errors
+func markCredentialErrors(provider aws.CredentialsProvider) aws.CredentialsProvider {
+	return aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		credentials, err := provider.Retrieve(ctx)
+		if err != nil {
+			return credentials, fmt.Errorf("%w: %w", errCredentials, err)
+		}
+		return credentials, nil
+	})
+}
+
+// authErrorCodes are the S3 error codes returned for missing, invalid or
+// expired credentials and for requests the credentials may not make
+var authErrorCodes = map[string]bool{
+	"AccessDenied":          true,
+	"AccountProblem":        true,
+	"AllAccessDisabled":     true,
+	"ExpiredToken":          true,
+	"Forbidden":             true,
+	"InvalidAccessKeyId":    true,
+	"InvalidToken":          true,
+	"SignatureDoesNotMatch": true,
+	"TokenRefreshRequired":  true,
+}
+
+// IsAuthError reports whether err shows the storage service rejected the
+// credentials, or that no credentials could be found to sign the request
+func IsAuthError(err error) bool {
+	// Reads of archived objects are refused with 403 as well
+	if errors.Is(err, interfaces.ErrObjectArchived) {
+		return false
+	}
+	if errors.Is(err, errCredentials) {
+		return true
+	}
+	var signingErr *v4.SigningError
+	if errors.As(err, &signingErr) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && authErrorCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	var responseErr *awshttp.ResponseError
+	return errors.As(err, &responseErr) &&
+		(responseErr.HTTPStatusCode() == http.StatusUnauthorized || responseErr.HTTPStatusCode() == http.StatusForbidden)
+}
+
 // Upload uploads a file to S3
-func (s *S3Provider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata) error {
+func (s *S3Provider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
 	key = s.addPrefix(key)
 
 	// Prepare upload input
 	input := &s3.PutObjectInput{
 		Bucket:        aws.String(s.bucket),
 		Key:           aws.String(key),
-		Body:          reader,
+		Body:          utils.ProgressReader(reader, options.Progress),
 		ContentLength: aws.Int64(metadata.Size), // Explicitly set Content-Length
 	}
 
 	// Set metadata
-	input.Metadata = map[string]string{
-		"original-path": key,
-		"upload-time":   time.Now().UTC().Format(time.RFC3339),
-		"content-type":  metadata.ContentType,
-		"permissions":   metadata.Permissions,
-		"md5-hash":      metadata.MD5Hash, // Store hex-encoded hash in metadata
-	}
+	input.Metadata = objectMetadata(key, metadata)
 
 	// Set content type if available
 	if metadata.ContentType != "" {
@@ -165,7 +268,7 @@ func (s *S3Provider) Upload(ctx context.Context, key string, reader io.Reader, m
 			zap.String("key", key),
 			zap.Int64("size", metadata.Size),
 			zap.Error(err))
-		return fmt.Errorf("failed to upload file: %w", err)
+		return fmt.Errorf("failed to upload file: %w", classifyError(err))
 	}
 
 	s.logger.Info("Successfully uploaded file to S3",
@@ -176,8 +279,31 @@ func (s *S3Provider) Upload(ctx context.Context, key string, reader io.Reader, m
 	return nil
 }
 
+// objectMetadata returns the user metadata stored with the object at the
+// full key
+func objectMetadata(key string, metadata interfaces.FileMetadata) map[string]string {
+	userMetadata := map[string]string{
+		"original-path": key,
+		"upload-time":   time.Now().UTC().Format(time.RFC3339),
+		"content-type":  metadata.ContentType,
+		"permissions":   metadata.Permissions,
+		"md5-hash":      metadata.MD5Hash, // Store hex-encoded hash in metadata
+	}
+	if metadata.OriginalKey != "" {
+		// The key was shortened to fit the key length limit. Record the
+		// full key in its place, metadata is limited to 2 KB in total.
+		delete(userMetadata, "original-path")
+		userMetadata["long-key"] = base64.RawURLEncoding.EncodeToString([]byte(metadata.OriginalKey))
+	}
+	if !metadata.ModTime.IsZero() {
+		// LastModified is the upload time, keep the file's own
+		userMetadata["mtime"] = metadata.ModTime.UTC().Format(time.RFC3339Nano)
+	}
+	return userMetadata
+}
+
 // Download downloads a file from S3
-func (s *S3Provider) Download(ctx context.Context, key string) (io.ReadCloser, interfaces.FileMetadata, error) {
+func (s *S3Provider) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
 	key = s.addPrefix(key)
 
 	input := &s3.GetObjectInput{
@@ -187,15 +313,18 @@ func (s *S3Provider) Download(ctx context.Context, key string) (io.ReadCloser, i
 
 	result, err := s.client.GetObject(ctx, input)
 	if err != nil {
-		s.logger.Error("Failed to download file from S3",
+		err = classifyError(err)
+		s.logFailure(err, "Failed to download file from S3",
 			zap.String("key", key),
 			zap.Error(err))
 		return nil, interfaces.FileMetadata{}, fmt.Errorf("failed to download file: %w", err)
 	}
 
 	metadata := interfaces.FileMetadata{
-		Size:        aws.ToInt64(result.ContentLength),
-		ContentType: aws.ToString(result.ContentType),
+		Size:         aws.ToInt64(result.ContentLength),
+		ContentType:  aws.ToString(result.ContentType),
+		ETag:         aws.ToString(result.ETag),
+		StorageClass: string(result.StorageClass),
 	}
 
 	if result.LastModified != nil {
@@ -208,12 +337,38 @@ func (s *S3Provider) Download(ctx context.Context, key string) (io.ReadCloser, i
 			metadata.Permissions = perms
 		}
 	}
+	metadata.MD5Hash = objectMD5(result.Metadata, aws.ToString(result.ETag))
+	metadata.OriginalKey = originalKey(result.Metadata)
+	metadata.SourceModTime = sourceModTime(result.Metadata)
 
 	s.logger.Info("Successfully downloaded file from S3",
 		zap.String("key", key),
 		zap.Int64("size", metadata.Size))
 
-	return result.Body, metadata, nil
+	return utils.ProgressReadCloser(classifiedBody{result.Body}, options.Progress), metadata, nil
+}
+
+// DownloadRange downloads length bytes of an object starting at offset
+func (s *S3Provider) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	key = s.addPrefix(key)
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+
+	result, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		s.logger.Error("Failed to download range from S3",
+			zap.String("key", key),
+			zap.Int64("offset", offset),
+			zap.Int64("length", length),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to download range: %w", classifyError(err))
+	}
+
+	return utils.ProgressReadCloser(classifiedBody{result.Body}, options.Progress), nil
 }
 
 // Delete removes a file from S3
@@ -230,7 +385,7 @@ func (s *S3Provider) Delete(ctx context.Context, key string) error {
 		s.logger.Error("Failed to delete file from S3",
 			zap.String("key", key),
 			zap.Error(err))
-		return fmt.Errorf("failed to delete file: %w", err)
+		return fmt.Errorf("failed to delete file: %w", classifyError(err))
 	}
 
 	s.logger.Info("Successfully deleted file from S3",
@@ -239,6 +394,115 @@ func (s *S3Provider) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// ListVersions lists every version and delete marker under prefix
+func (s *S3Provider) ListVersions(ctx context.Context, prefix string) ([]interfaces.ObjectVersion, error) {
+	fullPrefix := s.addPrefix(prefix)
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(fullPrefix),
+	}
+
+	var versions []interfaces.ObjectVersion
+	paginator := s3.NewListObjectVersionsPaginator(s.client, input)
+
+	for paginator.HasMorePages() {
+		pageCtx, cancel := interfaces.PageContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			s.logger.Error("Failed to list object versions from S3",
+				zap.String("prefix", fullPrefix),
+				zap.Error(err))
+			return nil, fmt.Errorf("failed to list object versions: %w", classifyError(err))
+		}
+
+		for _, version := range page.Versions {
+			versions = append(versions, interfaces.ObjectVersion{
+				Key:          s.removePrefix(aws.ToString(version.Key)),
+				VersionID:    aws.ToString(version.VersionId),
+				Size:         aws.ToInt64(version.Size),
+				LastModified: aws.ToTime(version.LastModified),
+				IsLatest:     aws.ToBool(version.IsLatest),
+			})
+		}
+		for _, marker := range page.DeleteMarkers {
+			versions = append(versions, interfaces.ObjectVersion{
+				Key:            s.removePrefix(aws.ToString(marker.Key)),
+				VersionID:      aws.ToString(marker.VersionId),
+				LastModified:   aws.ToTime(marker.LastModified),
+				IsLatest:       aws.ToBool(marker.IsLatest),
+				IsDeleteMarker: true,
+			})
+		}
+	}
+
+	return versions, nil
+}
+
+// DeleteVersion permanently deletes a single object version
+func (s *S3Provider) DeleteVersion(ctx context.Context, key, versionID string) error {
+	key = s.addPrefix(key)
+
+	input := &s3.DeleteObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	}
+
+	if _, err := s.client.DeleteObject(ctx, input); err != nil {
+		s.logger.Error("Failed to delete object version from S3",
+			zap.String("key", key),
+			zap.String("version_id", versionID),
+			zap.Error(err))
+		return fmt.Errorf("failed to delete object version: %w", classifyError(err))
+	}
+
+	s.logger.Info("Successfully deleted object version from S3",
+		zap.String("key", key),
+		zap.String("version_id", versionID))
+
+	return nil
+}
+
+// Copy duplicates an object server-side, keeping its metadata
+func (s *S3Provider) Copy(ctx context.Context, srcKey, dstKey string) error {
+	srcKey = s.addPrefix(srcKey)
+	dstKey = s.addPrefix(dstKey)
+
+	segments := strings.Split(s.bucket+"/"+srcKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(s.bucket),
+		Key:               aws.String(dstKey),
+		CopySource:        aws.String(strings.Join(segments, "/")),
+		MetadataDirective: types.MetadataDirectiveCopy,
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.config.StorageClass)
+	}
+	if s.config.ServerSideEncryption {
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	}
+
+	if _, err := s.client.CopyObject(ctx, input); err != nil {
+		s.logger.Error("Failed to copy object in S3",
+			zap.String("source", srcKey),
+			zap.String("key", dstKey),
+			zap.Error(err))
+		return fmt.Errorf("failed to copy object: %w", classifyError(err))
+	}
+
+	s.logger.Info("Successfully copied object in S3",
+		zap.String("source", srcKey),
+		zap.String("key", dstKey))
+
+	return nil
+}
+
 // List lists files in S3 with optional prefix
 func (s *S3Provider) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
 	fullPrefix := s.addPrefix(prefix)
@@ -252,27 +516,32 @@ func (s *S3Provider) List(ctx context.Context, prefix string) ([]interfaces.File
 	paginator := s3.NewListObjectsV2Paginator(s.client, input)
 
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+		pageCtx, cancel := interfaces.PageContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
 		if err != nil {
 			s.logger.Error("Failed to list files from S3",
 				zap.String("prefix", fullPrefix),
 				zap.Error(err))
-			return nil, fmt.Errorf("failed to list files: %w", err)
+			return nil, fmt.Errorf("failed to list files: %w", classifyError(err))
 		}
 
 		for _, obj := range page.Contents {
 			key := s.removePrefix(aws.ToString(obj.Key))
 
 			fileInfo := interfaces.FileInfo{
-				Key:   key,
-				Size:  aws.ToInt64(obj.Size),
-				IsDir: strings.HasSuffix(key, "/"),
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				IsDir:        strings.HasSuffix(key, "/"),
+				StorageClass: string(obj.StorageClass),
 			}
 
 			if obj.LastModified != nil {
 				fileInfo.ModTime = *obj.LastModified
 			}
 
+			fileInfo.MD5Hash = etagMD5(aws.ToString(obj.ETag))
+
 			files = append(files, fileInfo)
 		}
 	}
@@ -295,15 +564,51 @@ func (s *S3Provider) GetMetadata(ctx context.Context, key string) (interfaces.Fi
 
 	result, err := s.client.HeadObject(ctx, input)
 	if err != nil {
-		s.logger.Error("Failed to get metadata from S3",
+		err = classifyError(err)
+		s.logFailure(err, "Failed to get metadata from S3",
 			zap.String("key", key),
 			zap.Error(err))
 		return interfaces.FileMetadata{}, fmt.Errorf("failed to get metadata: %w", err)
 	}
 
+	return headMetadata(result), nil
+}
+
+// GetMetadataIfChanged gets metadata for a file in S3 with a conditional
+// HEAD request, which S3 answers with 304 Not Modified while the object's
+// ETag equals etag
+func (s *S3Provider) GetMetadataIfChanged(ctx context.Context, key, etag string) (interfaces.FileMetadata, bool, error) {
+	if etag == "" {
+		metadata, err := s.GetMetadata(ctx, key)
+		return metadata, err == nil, err
+	}
+	key = s.addPrefix(key)
+
+	input := &s3.HeadObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		IfNoneMatch: aws.String(etag),
+	}
+
+	result, err := s.client.HeadObject(ctx, input)
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotModified {
+		return interfaces.FileMetadata{}, false, nil
+	}
+	if err != nil {
+		return interfaces.FileMetadata{}, false, fmt.Errorf("failed to get metadata: %w", classifyError(err))
+	}
+
+	return headMetadata(result), true, nil
+}
+
+// headMetadata converts the response of a HEAD request to file metadata
+func headMetadata(result *s3.HeadObjectOutput) interfaces.FileMetadata {
 	metadata := interfaces.FileMetadata{
-		Size:        aws.ToInt64(result.ContentLength),
-		ContentType: aws.ToString(result.ContentType),
+		Size:         aws.ToInt64(result.ContentLength),
+		ContentType:  aws.ToString(result.ContentType),
+		ETag:         aws.ToString(result.ETag),
+		StorageClass: string(result.StorageClass),
 	}
 
 	if result.LastModified != nil {
@@ -317,30 +622,212 @@ func (s *S3Provider) GetMetadata(ctx context.Context, key string) (interfaces.Fi
 		}
 	}
 
-	// Get ETag as MD5 hash (for non-multipart uploads)
-	if result.ETag != nil {
-		etag := strings.Trim(aws.ToString(result.ETag), `"`)
-		if !strings.Contains(etag, "-") { // Simple upload, ETag is MD5
-			metadata.MD5Hash = etag
+	metadata.MD5Hash = objectMD5(result.Metadata, aws.ToString(result.ETag))
+	metadata.OriginalKey = originalKey(result.Metadata)
+	metadata.SourceModTime = sourceModTime(result.Metadata)
+
+	// Objects in the Glacier classes or the archive tiers of Intelligent
+	// Tiering are readable only while a restored copy exists
+	ongoing, restoredUntil := parseRestore(aws.ToString(result.Restore))
+	archival := result.StorageClass == types.StorageClassGlacier ||
+		result.StorageClass == types.StorageClassDeepArchive ||
+		result.ArchiveStatus != ""
+	metadata.Restoring = ongoing
+	metadata.RestoredUntil = restoredUntil
+	metadata.Archived = archival && restoredUntil.IsZero()
+	return metadata
+}
+
+// parseRestore parses the x-amz-restore header of an archived object, as in
+// ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT".
+// It reports whether a restore is in progress and when the restored copy
+// expires, zero while there is none.
+func parseRestore(header string) (bool, time.Time) {
+	var ongoing bool
+	var expiry time.Time
+	for _, field := range strings.Split(header, `",`) {
+		name, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(value, `"`)
+		switch name {
+		case "ongoing-request":
+			ongoing = value == "true"
+		case "expiry-date":
+			if parsed, err := http.ParseTime(value); err == nil {
+				expiry = parsed
+			}
 		}
 	}
+	if ongoing {
+		expiry = time.Time{}
+	}
+	return ongoing, expiry
+}
+
+// archivedError marks the error S3 returns for reads of archived objects
+// that have not been restored with interfaces.ErrObjectArchived
+func archivedError(err error) error {
+	var invalidState *types.InvalidObjectState
+	var apiErr smithy.APIError
+	if errors.As(err, &invalidState) || (errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidObjectState") {
+		return fmt.Errorf("%w: %w", interfaces.ErrObjectArchived, err)
+	}
+	return err
+}
+
+// Restore requests a temporary copy of an object in a Glacier storage
+// class. Objects in the archive tiers of Intelligent Tiering move back to
+// the frequent access tier instead, so days and tier do not apply to them.
+func (s *S3Provider) Restore(ctx context.Context, key string, days int, tier string) error {
+	key = s.addPrefix(key)
+
+	head, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get metadata: %w", classifyError(err))
+	}
+
+	request := &types.RestoreRequest{}
+	if head.ArchiveStatus == "" {
+		request.Days = aws.Int32(int32(days))
+		request.GlacierJobParameters = &types.GlacierJobParameters{Tier: types.Tier(tier)}
+	}
+
+	_, err = s.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(s.bucket),
+		Key:            aws.String(key),
+		RestoreRequest: request,
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	if err != nil {
+		s.logger.Error("Failed to request restore from S3",
+			zap.String("key", key),
+			zap.Error(err))
+		return fmt.Errorf("failed to request restore: %w", classifyError(err))
+	}
+
+	s.logger.Info("Requested restore of archived object",
+		zap.String("key", key),
+		zap.String("storage_class", string(head.StorageClass)),
+		zap.String("tier", tier),
+		zap.Int("days", days))
+	return nil
+}
+
+// originalKey returns the full key recorded in the metadata of an object
+// stored under a shortened key, or "" for other objects
+func originalKey(userMetadata map[string]string) string {
+	encoded, ok := userMetadata["long-key"]
+	if !ok {
+		return ""
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ""
+	}
+	return string(key)
+}
+
+// sourceModTime returns the modification time of the uploaded file
+// recorded in object metadata, or the zero time for objects uploaded
+// without it
+func sourceModTime(userMetadata map[string]string) time.Time {
+	modTime, err := time.Parse(time.RFC3339Nano, userMetadata["mtime"])
+	if err != nil {
+		return time.Time{}
+	}
+	return modTime
+}
+
+// objectMD5 returns the hash recorded in object metadata at upload time,
+// which the server checked against Content-MD5, falling back to the ETag.
+// Some S3-compatible servers return ETags that are not MD5 hashes.
+func objectMD5(userMetadata map[string]string, etag string) string {
+	if hash := userMetadata["md5-hash"]; hash != "" {
+		return hash
+	}
+	return etagMD5(etag)
+}
 
-	return metadata, nil
+// etagMD5 returns the MD5 hash encoded in an ETag, or an empty string for
+// multipart uploads whose ETag is not a content hash
+func etagMD5(etag string) string {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" || strings.Contains(etag, "-") {
+		return ""
+	}
+	return etag
 }
 
-// Exists checks if a file exists in S3
+// Exists checks if a file exists in S3 with a HEAD request. A missing
+// object is not logged as an error, unlike in GetMetadata.
 func (s *S3Provider) Exists(ctx context.Context, key string) (bool, error) {
-	_, err := s.GetMetadata(ctx, key)
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.addPrefix(key)),
+	}
+
+	_, err := s.client.HeadObject(ctx, input)
+	err = classifyError(err)
+	if errors.Is(err, interfaces.ErrNotFound) {
+		return false, nil
+	}
 	if err != nil {
-		// Check if it's a "not found" error
-		if strings.Contains(err.Error(), "NotFound") {
-			return false, nil
-		}
-		return false, err
+		return false, fmt.Errorf("failed to check object: %w", err)
 	}
 	return true, nil
 }
 
+// StorageUsage returns the total size and object count under a prefix
+func (s *S3Provider) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	fullPrefix := s.addPrefix(prefix)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(fullPrefix),
+	}
+
+	var usage interfaces.StorageUsage
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+
+	for paginator.HasMorePages() {
+		pageCtx, cancel := interfaces.PageContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			s.logger.Error("Failed to compute storage usage",
+				zap.String("prefix", fullPrefix),
+				zap.Error(err))
+			return interfaces.StorageUsage{}, fmt.Errorf("failed to compute storage usage: %w", classifyError(err))
+		}
+
+		for _, obj := range page.Contents {
+			usage.Bytes += aws.ToInt64(obj.Size)
+			usage.Objects++
+		}
+	}
+
+	s.logger.Debug("Computed S3 storage usage",
+		zap.String("prefix", fullPrefix),
+		zap.Int64("bytes", usage.Bytes),
+		zap.Int64("objects", usage.Objects))
+
+	return usage, nil
+}
+
+// ClockSkew returns how far the clock of the storage service is ahead of
+// the local clock, as shown by the last response
+func (s *S3Provider) ClockSkew() (time.Duration, bool) {
+	return s.skew.Skew()
+}
+
 // verifyBucketAccess verifies that we can access the S3 bucket
 func (s *S3Provider) verifyBucketAccess(ctx context.Context) error {
 	input := &s3.HeadBucketInput{
@@ -349,7 +836,7 @@ func (s *S3Provider) verifyBucketAccess(ctx context.Context) error {
 
 	_, err := s.client.HeadBucket(ctx, input)
 	if err != nil {
-		return fmt.Errorf("cannot access bucket %s: %w", s.bucket, err)
+		return fmt.Errorf("cannot access bucket %s: %w", s.bucket, classifyError(err))
 	}
 
 	s.logger.Info("Successfully verified S3 bucket access",
@@ -358,21 +845,31 @@ func (s *S3Provider) verifyBucketAccess(ctx context.Context) error {
 	return nil
 }
 
-// addPrefix adds the configured prefix to a key
+// endpointURL returns the URL storage requests are sent to
+func endpointURL(cfg S3Config) *url.URL {
+	if cfg.Endpoint != "" {
+		if endpoint, err := url.Parse(cfg.Endpoint); err == nil && endpoint.Host != "" {
+			return endpoint
+		}
+	}
+	return &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", cfg.Region)}
+}
+
+// addPrefix adds the configured prefix to a key. The key is not cleaned so
+// list prefixes keep their trailing slash.
 func (s *S3Provider) addPrefix(key string) string {
-	if s.prefix == "" {
+	prefix := strings.Trim(s.prefix, "/")
+	if prefix == "" {
 		return key
 	}
-	return filepath.Join(s.prefix, key)
+	return prefix + "/" + key
 }
 
 // removePrefix removes the configured prefix from a key
 func (s *S3Provider) removePrefix(key string) string {
-	if s.prefix == "" {
+	prefix := strings.Trim(s.prefix, "/")
+	if prefix == "" {
 		return key
 	}
-	if strings.HasPrefix(key, s.prefix) {
-		return strings.TrimPrefix(key, s.prefix)
-	}
-	return key
+	return strings.TrimPrefix(key, prefix+"/")
 }
@@ -0,0 +1,84 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// clockSkewID names the middleware measuring the service's clock
+const clockSkewID = "CloudAWSyncClockSkew"
+
+// clockSkew tracks how far the storage service's clock is ahead of the
+// local one, measured from the Date header of every response
+type clockSkew struct {
+	mutex    sync.Mutex
+	offset   time.Duration
+	measured bool
+}
+
+// Skew returns the last measured offset and whether one was measured
+func (c *clockSkew) Skew() (time.Duration, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.offset, c.measured
+}
+
+// observe records the offset shown by a Date header received at received.
+// The header only has second resolution, so the service's time is taken
+// to be in the middle of that second.
+func (c *clockSkew) observe(date string, received time.Time) {
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return
+	}
+	offset := serverTime.Add(500 * time.Millisecond).Sub(received)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.offset = offset.Round(time.Second / 10)
+	c.measured = true
+}
+
+// middleware returns an API option measuring the clock skew from every
+// response the client receives, including error responses and retries
+func (c *clockSkew) middleware() func(*middleware.Stack) error {
+	measure := middleware.DeserializeMiddlewareFunc(clockSkewID, func(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (middleware.DeserializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleDeserialize(ctx, in)
+		if response, ok := out.RawResponse.(*smithyhttp.Response); ok {
+			if date := response.Header.Get("Date"); date != "" {
+				c.observe(date, time.Now())
+			}
+		}
+		return out, metadata, err
+	})
+
+	return func(stack *middleware.Stack) error {
+		return stack.Deserialize.Add(measure, middleware.After)
+	}
+}
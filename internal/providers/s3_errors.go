@@ -0,0 +1,165 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"CloudAWSync/internal/interfaces"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"go.uber.org/zap"
+)
+
+// notFoundCodes are the S3 error codes of missing objects, versions,
+// multipart uploads and buckets
+var notFoundCodes = map[string]bool{
+	"NotFound":      true,
+	"NoSuchBucket":  true,
+	"NoSuchKey":     true,
+	"NoSuchUpload":  true,
+	"NoSuchVersion": true,
+}
+
+// permissionDeniedCodes are the S3 error codes of requests the credentials
+// may not make
+var permissionDeniedCodes = map[string]bool{
+	"AccessDenied":      true,
+	"AccountProblem":    true,
+	"AllAccessDisabled": true,
+	"Forbidden":         true,
+}
+
+// quotaExceededCodes are the error codes S3-compatible services return
+// for writes beyond a bucket or account quota, or to a full disk
+var quotaExceededCodes = map[string]bool{
+	"QuotaExceeded":                  true,
+	"XMinioAdminBucketQuotaExceeded": true,
+	"XMinioStorageFull":              true,
+}
+
+// classifyError wraps an error of the S3 client with the interfaces error
+// of its kind, so callers can branch with errors.Is instead of matching
+// the message. Errors of other kinds are returned unchanged.
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if archived := archivedError(err); archived != err {
+		return archived
+	}
+	if kind := errorKind(err); kind != nil && !errors.Is(err, kind) {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return err
+}
+
+// errorKind returns the interfaces error matching err, or nil
+func errorKind(err error) error {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	var noSuchBucket *types.NoSuchBucket
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) || errors.As(err, &noSuchBucket) {
+		return interfaces.ErrNotFound
+	}
+	if isThrottle(err) {
+		return interfaces.ErrThrottled
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch code := apiErr.ErrorCode(); {
+		case notFoundCodes[code]:
+			return interfaces.ErrNotFound
+		case permissionDeniedCodes[code]:
+			return interfaces.ErrPermissionDenied
+		case quotaExceededCodes[code]:
+			return interfaces.ErrQuotaExceeded
+		}
+		return nil
+	}
+
+	// Responses to HEAD requests have no body to carry an error code
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) {
+		switch responseErr.HTTPStatusCode() {
+		case http.StatusNotFound:
+			return interfaces.ErrNotFound
+		case http.StatusForbidden:
+			return interfaces.ErrPermissionDenied
+		case http.StatusTooManyRequests:
+			return interfaces.ErrThrottled
+		case http.StatusInsufficientStorage:
+			return interfaces.ErrQuotaExceeded
+		}
+		return nil
+	}
+
+	if isNetworkError(err) {
+		return interfaces.ErrTemporaryNetwork
+	}
+	return nil
+}
+
+// isNetworkError reports whether err means the service could not be
+// reached or the connection to it broke
+func isNetworkError(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) ||
+		errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH)
+}
+
+// classifiedBody classifies the errors of reading a response body, which
+// a broken connection fails after the request succeeded
+type classifiedBody struct {
+	io.ReadCloser
+}
+
+func (b classifiedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = classifyError(err)
+	}
+	return n, err
+}
+
+// logFailure logs a failed request at error level, or at debug level for
+// missing objects, which callers look up to find out whether they exist
+func (s *S3Provider) logFailure(err error, message string, fields ...zap.Field) {
+	if errors.Is(err, interfaces.ErrNotFound) {
+		s.logger.Debug(message, fields...)
+		return
+	}
+	s.logger.Error(message, fields...)
+}
@@ -0,0 +1,405 @@
+//go:build integration
+
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"CloudAWSync/internal/engine"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/metrics"
+	"CloudAWSync/internal/providers"
+	"CloudAWSync/internal/providers/providertest"
+	fakes "CloudAWSync/internal/testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// Integration tests run against the endpoint in CLOUDAWSYNC_TEST_S3_ENDPOINT
+// or, when it is unset, a MinIO server started from the minio binary or
+// the minio/minio Docker image. Run them with
+//
+//	go test -tags integration ./internal/providers/
+
+const (
+	minioUser     = "cloudawsync"
+	minioPassword = "cloudawsync-secret"
+)
+
+// target is the S3-compatible server used by every test, nil when none
+// could be found or started
+var target *providers.S3Config
+
+func TestMain(m *testing.M) {
+	cfg, stop, err := startTarget()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "S3 integration target unavailable: %v\n", err)
+		if os.Getenv("CLOUDAWSYNC_TEST_S3_ENDPOINT") != "" {
+			os.Exit(1)
+		}
+	} else {
+		target = &cfg
+	}
+
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+// startTarget locates or starts the server and creates the test bucket
+func startTarget() (providers.S3Config, func(), error) {
+	cfg := providers.S3Config{
+		Region:          envOr("CLOUDAWSYNC_TEST_S3_REGION", "us-east-1"),
+		Bucket:          envOr("CLOUDAWSYNC_TEST_S3_BUCKET", "cloudawsync-test"),
+		Endpoint:        os.Getenv("CLOUDAWSYNC_TEST_S3_ENDPOINT"),
+		AccessKeyID:     envOr("CLOUDAWSYNC_TEST_S3_ACCESS_KEY", minioUser),
+		SecretAccessKey: envOr("CLOUDAWSYNC_TEST_S3_SECRET_KEY", minioPassword),
+	}
+
+	var stop func()
+	if cfg.Endpoint == "" {
+		endpoint, stopServer, err := startMinIO()
+		if err != nil {
+			return cfg, nil, err
+		}
+		cfg.Endpoint, stop = endpoint, stopServer
+	}
+
+	if err := createBucket(cfg); err != nil {
+		if stop != nil {
+			stop()
+		}
+		return cfg, nil, err
+	}
+	return cfg, stop, nil
+}
+
+// startMinIO runs a throwaway MinIO server on a free local port
+func startMinIO() (string, func(), error) {
+	port, err := freePort()
+	if err != nil {
+		return "", nil, err
+	}
+	endpoint := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	var cmd *exec.Cmd
+	var dataDir string
+	if binary, err := exec.LookPath("minio"); err == nil {
+		dataDir, err = os.MkdirTemp("", "cloudawsync-minio-")
+		if err != nil {
+			return "", nil, err
+		}
+		cmd = exec.Command(binary, "server", dataDir, "--address", fmt.Sprintf("127.0.0.1:%d", port), "--quiet")
+		cmd.Env = append(os.Environ(), "MINIO_ROOT_USER="+minioUser, "MINIO_ROOT_PASSWORD="+minioPassword)
+	} else if docker, err := exec.LookPath("docker"); err == nil {
+		cmd = exec.Command(docker, "run", "--rm", "--name", fmt.Sprintf("cloudawsync-minio-%d", port),
+			"-p", fmt.Sprintf("127.0.0.1:%d:9000", port),
+			"-e", "MINIO_ROOT_USER="+minioUser, "-e", "MINIO_ROOT_PASSWORD="+minioPassword,
+			"minio/minio", "server", "/data", "--quiet")
+	} else {
+		return "", nil, errors.New("set CLOUDAWSYNC_TEST_S3_ENDPOINT or install minio or docker")
+	}
+
+	if err := cmd.Start(); err != nil {
+		return "", nil, fmt.Errorf("failed to start MinIO: %w", err)
+	}
+	stop := func() {
+		cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() { cmd.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+		if dataDir != "" {
+			os.RemoveAll(dataDir)
+		}
+	}
+
+	// Docker may need to pull the image first
+	deadline := time.Now().Add(2 * time.Minute)
+	for time.Now().Before(deadline) {
+		response, err := http.Get(endpoint + "/minio/health/live")
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return endpoint, stop, nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	stop()
+	return "", nil, errors.New("MinIO did not become ready")
+}
+
+// createBucket creates the test bucket unless it already exists
+func createBucket(cfg providers.S3Config) error {
+	client := s3.New(s3.Options{
+		Region:       cfg.Region,
+		BaseEndpoint: aws.String(cfg.Endpoint),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey}, nil
+		}),
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(cfg.Bucket)})
+	var owned *types.BucketAlreadyOwnedByYou
+	var exists *types.BucketAlreadyExists
+	if err != nil && !errors.As(err, &owned) && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create bucket %s: %w", cfg.Bucket, err)
+	}
+	return nil
+}
+
+// newProvider connects an S3 provider to the target with the given prefix
+func newProvider(t *testing.T, prefix string) *providers.S3Provider {
+	t.Helper()
+	if target == nil {
+		t.Skip("no S3 integration target")
+	}
+
+	cfg := *target
+	cfg.Prefix = prefix
+	provider, err := providers.NewS3Provider(context.Background(), cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("failed to create S3 provider: %v", err)
+	}
+	return provider
+}
+
+func TestS3Conformance(t *testing.T) {
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		return newProvider(t, "")
+	})
+}
+
+func TestS3ConformanceWithPrefix(t *testing.T) {
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		return newProvider(t, "nested/prefix/")
+	})
+}
+
+// TestS3EndToEndSync syncs a local tree, changes one file and verifies the
+// remote copy after each pass
+func TestS3EndToEndSync(t *testing.T) {
+	provider := newProvider(t, "e2e")
+	localDir := t.TempDir()
+	remoteDir := fmt.Sprintf("sync-%d", time.Now().UnixNano())
+
+	files := map[string]string{
+		"report.txt":                 "quarterly numbers",
+		"photos/holiday 2025.jpg":    "not really a jpeg",
+		"docs/notes+ideas/ñandú.md":  "# notes",
+		"docs/notes+ideas/empty.txt": "",
+	}
+	for name, content := range files {
+		writeFile(t, filepath.Join(localDir, name), content)
+	}
+
+	e := engine.NewEngine(provider, nil, metrics.NewSimpleCollector(zap.NewNop()), zap.NewNop(), 2, 2, 1, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := e.Start(ctx); err != nil {
+		t.Fatalf("failed to start engine: %v", err)
+	}
+	defer e.Stop()
+
+	dir := interfaces.SyncDirectory{
+		LocalPath:  localDir,
+		RemotePath: remoteDir,
+		SyncMode:   interfaces.SyncModeScheduled,
+		Recursive:  true,
+		Enabled:    true,
+	}
+	t.Cleanup(func() { deleteAll(provider, remoteDir) })
+
+	syncAndVerify(ctx, t, e, dir, len(files))
+	if uploaded := e.GetStats().FilesUploaded; uploaded != int64(len(files)) {
+		t.Errorf("first sync uploaded %d files, want %d", uploaded, len(files))
+	}
+
+	// A second pass uploads only the changed file
+	changed := filepath.Join(localDir, "report.txt")
+	writeFile(t, changed, "revised quarterly numbers")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(changed, later, later); err != nil {
+		t.Fatal(err)
+	}
+	syncAndVerify(ctx, t, e, dir, len(files))
+	if uploaded := e.GetStats().FilesUploaded; uploaded != int64(len(files))+1 {
+		t.Errorf("second sync uploaded %d files, want 1", uploaded-int64(len(files)))
+	}
+}
+
+// TestS3AbandonedMultipartUpload checks that the engine aborts multipart
+// uploads whose resume record has not been updated for a day and removes
+// the record
+func TestS3AbandonedMultipartUpload(t *testing.T) {
+	provider := newProvider(t, fmt.Sprintf("multipart-%d", time.Now().UnixNano()))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	t.Cleanup(func() { deleteAll(provider, ".cloudawsync") })
+
+	const partSize = 5 * 1024 * 1024
+	uploadID, err := provider.CreateMultipartUpload(ctx, "changed.bin", interfaces.FileMetadata{Size: 2 * partSize})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload failed: %v", err)
+	}
+	part := bytes.Repeat([]byte("p"), partSize)
+	if _, err := provider.UploadPart(ctx, "changed.bin", uploadID, 1, bytes.NewReader(part), partSize, interfaces.TransferOptions{}); err != nil {
+		t.Fatalf("UploadPart failed: %v", err)
+	}
+
+	// The record of an upload whose file changed two days ago
+	record, err := json.Marshal(engine.MultipartResume{
+		Key:       "changed.bin",
+		UploadID:  uploadID,
+		Size:      2 * partSize,
+		MD5Hash:   "0123456789abcdef0123456789abcdef",
+		PartSize:  partSize,
+		UpdatedAt: time.Now().Add(-48 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordKey := ".cloudawsync/uploads/0123456789abcdef0123456789abcdef-10485760.json"
+	if err := provider.Upload(ctx, recordKey, bytes.NewReader(record), interfaces.FileMetadata{Size: int64(len(record))}, interfaces.TransferOptions{}); err != nil {
+		t.Fatalf("failed to store resume record: %v", err)
+	}
+
+	e := engine.NewEngine(provider, nil, metrics.NewSimpleCollector(zap.NewNop()), zap.NewNop(), 1, 1, 1, time.Second)
+	e.SetClock(fakes.NewFakeClock(time.Now().Add(25 * time.Hour)))
+	e.SetMultipart(partSize, partSize)
+	if err := e.Start(ctx); err != nil {
+		t.Fatalf("failed to start engine: %v", err)
+	}
+	defer e.Stop()
+
+	for {
+		exists, err := provider.Exists(ctx, recordKey)
+		if err != nil {
+			t.Fatalf("Exists failed: %v", err)
+		}
+		if !exists {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for the resume record to be removed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := provider.ListParts(ctx, "changed.bin", uploadID); !errors.Is(err, interfaces.ErrNotFound) {
+		t.Errorf("ListParts of the abandoned upload = %v, want ErrNotFound", err)
+	}
+}
+
+// syncAndVerify runs a sync, waits for the uploads and checks the remote
+// copy against the local files
+func syncAndVerify(ctx context.Context, t *testing.T, e *engine.Engine, dir interfaces.SyncDirectory, want int) {
+	t.Helper()
+
+	if err := e.Sync(ctx, dir); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	for e.PendingUploads() > 0 {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for uploads")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	report, err := e.Verify(ctx, dir)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if !report.OK() || report.Matched != want {
+		t.Errorf("verify after sync: matched %d of %d, missing %v, corrupted %v, extra %v, unverifiable %v, errors %v",
+			report.Matched, want, report.Missing, report.Corrupted, report.Extra, report.Unverifiable, report.Errors)
+	}
+}
+
+// writeFile creates a file and its parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deleteAll removes every object below prefix
+func deleteAll(provider interfaces.CloudProvider, prefix string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	files, err := provider.List(ctx, strings.TrimSuffix(prefix, "/")+"/")
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		provider.Delete(ctx, file.Key)
+	}
+}
+
+// freePort returns a TCP port that is currently unused
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// envOr returns the environment variable or fallback when it is unset
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,114 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// requestMetricsID names the middleware recording S3 API calls
+const requestMetricsID = "CloudAWSyncRequestMetrics"
+
+// requestMetrics returns an API option recording every call made by the
+// client, with its duration, retries, throttled attempts and error code
+func requestMetrics(bucket string, metrics interfaces.MetricsCollector) func(*middleware.Stack) error {
+	record := middleware.InitializeMiddlewareFunc(requestMetricsID, func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		request := interfaces.ProviderRequest{
+			Bucket:    bucket,
+			Operation: middleware.GetOperationName(ctx),
+			Duration:  time.Since(start),
+			Attempts:  1,
+			ErrorCode: errorCode(err),
+		}
+		if results, ok := retry.GetAttemptResults(metadata); ok && len(results.Results) > 0 {
+			request.Attempts = len(results.Results)
+			for _, result := range results.Results {
+				if isThrottle(result.Err) {
+					request.Throttled++
+				}
+			}
+		} else if isThrottle(err) {
+			request.Throttled = 1
+		}
+		metrics.RecordProviderRequest(request)
+
+		return out, metadata, err
+	})
+
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(record, middleware.Before)
+	}
+}
+
+// errorCode classifies an API error for metrics: the service's error
+// code, or the kind of failure when no response was received
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var apiErr smithy.APIError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr.ErrorCode()
+	case errors.Is(err, context.Canceled):
+		return "Canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timeout"
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return "Timeout"
+		}
+		return "NetworkError"
+	default:
+		return "Unknown"
+	}
+}
+
+// isThrottle reports whether err asked the client to slow down. S3 sends
+// SlowDown as a bare 503 to HEAD requests, which have no error body.
+func isThrottle(err error) bool {
+	if err == nil {
+		return false
+	}
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err) == aws.TrueTernary {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusServiceUnavailable
+}
@@ -0,0 +1,164 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"go.uber.org/zap"
+)
+
+// CreateMultipartUpload starts a multipart upload with the same metadata,
+// storage class and encryption as Upload
+func (s *S3Provider) CreateMultipartUpload(ctx context.Context, key string, metadata interfaces.FileMetadata) (string, error) {
+	key = s.addPrefix(key)
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		Metadata: objectMetadata(key, metadata),
+	}
+	if metadata.ContentType != "" {
+		input.ContentType = aws.String(metadata.ContentType)
+	}
+	if s.config.StorageClass != "" {
+		input.StorageClass = types.StorageClass(s.config.StorageClass)
+	}
+	if s.config.ServerSideEncryption {
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	}
+
+	result, err := s.client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", classifyError(err))
+	}
+	return aws.ToString(result.UploadId), nil
+}
+
+// UploadPart uploads one part of a multipart upload with its MD5 hash, so
+// S3 rejects parts corrupted in transit
+func (s *S3Provider) UploadPart(ctx context.Context, key, uploadID string, number int, body io.ReadSeeker, size int64, options interfaces.TransferOptions) (string, error) {
+	hasher := md5.New()
+	if _, err := io.Copy(hasher, body); err != nil {
+		return "", fmt.Errorf("failed to hash part %d: %w", number, err)
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind part %d: %w", number, err)
+	}
+
+	input := &s3.UploadPartInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.addPrefix(key)),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(int32(number)),
+		Body:          utils.ProgressReader(body, options.Progress),
+		ContentLength: aws.Int64(size),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(hasher.Sum(nil))),
+	}
+
+	result, err := s.client.UploadPart(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d: %w", number, classifyError(err))
+	}
+	return aws.ToString(result.ETag), nil
+}
+
+// ListParts lists the parts stored for a multipart upload
+func (s *S3Provider) ListParts(ctx context.Context, key, uploadID string) ([]interfaces.UploadedPart, error) {
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(s.addPrefix(key)),
+		UploadId: aws.String(uploadID),
+	}
+
+	var parts []interfaces.UploadedPart
+	paginator := s3.NewListPartsPaginator(s.client, input)
+	for paginator.HasMorePages() {
+		pageCtx, cancel := interfaces.PageContext(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts: %w", classifyError(err))
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, interfaces.UploadedPart{
+				Number: int(aws.ToInt32(part.PartNumber)),
+				ETag:   aws.ToString(part.ETag),
+				Size:   aws.ToInt64(part.Size),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the object
+func (s *S3Provider) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []interfaces.UploadedPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{
+			PartNumber: aws.Int32(int32(part.Number)),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return aws.ToInt32(completed[i].PartNumber) < aws.ToInt32(completed[j].PartNumber)
+	})
+
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.addPrefix(key)),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if _, err := s.client.CompleteMultipartUpload(ctx, input); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", classifyError(err))
+	}
+
+	s.logger.Info("Successfully uploaded file to S3 in parts",
+		zap.String("key", s.addPrefix(key)),
+		zap.Int("parts", len(parts)))
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *S3Provider) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	input := &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(s.addPrefix(key)),
+		UploadId: aws.String(uploadID),
+	}
+	if _, err := s.client.AbortMultipartUpload(ctx, input); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", classifyError(err))
+	}
+	return nil
+}
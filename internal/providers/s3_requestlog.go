@@ -0,0 +1,98 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"reflect"
+
+	"CloudAWSync/internal/interfaces"
+
+	"github.com/aws/smithy-go/middleware"
+	"go.uber.org/zap"
+)
+
+// failureLogID names the middleware logging failed S3 API calls
+const failureLogID = "CloudAWSyncFailureLog"
+
+// expectedErrorCodes are returned when checking for objects that need not
+// exist, so failures with them are only logged at debug level
+var expectedErrorCodes = map[string]bool{
+	"NotFound":      true,
+	"NoSuchKey":     true,
+	"NoSuchVersion": true,
+	"Canceled":      true,
+}
+
+// failureLog returns an API option logging every failed call made by the
+// client with the request ID and extended request ID S3 assigned to it and
+// the correlation ID of the sync it was made for, so agent logs can be
+// matched to S3 server access logs. Calls that got no response carry no
+// request IDs and are logged at debug level, as the engine reports those
+// as connectivity problems.
+func failureLog(bucket string, logger *zap.Logger) func(*middleware.Stack) error {
+	record := middleware.InitializeMiddlewareFunc(failureLogID, func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		out, metadata, err := next.HandleInitialize(ctx, in)
+		if err == nil {
+			return out, metadata, err
+		}
+
+		code := errorCode(err)
+		requestID, extendedRequestID := interfaces.RequestIDs(err)
+		log := logger.Warn
+		if requestID == "" || expectedErrorCodes[code] {
+			log = logger.Debug
+		}
+		log("S3 request failed",
+			zap.String("bucket", bucket),
+			zap.String("operation", middleware.GetOperationName(ctx)),
+			zap.String("key", objectKey(in.Parameters)),
+			zap.String("error_code", code),
+			zap.String("request_id", requestID),
+			zap.String("extended_request_id", extendedRequestID),
+			zap.String("sync_id", interfaces.SyncID(ctx)),
+			zap.Error(err))
+
+		return out, metadata, err
+	})
+
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(record, middleware.Before)
+	}
+}
+
+// objectKey returns the object key of an operation's input, or "" for
+// operations on the bucket or on many objects
+func objectKey(params any) string {
+	value := reflect.ValueOf(params)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return ""
+	}
+	field := value.Elem().FieldByName("Key")
+	if !field.IsValid() {
+		return ""
+	}
+	if key, ok := field.Interface().(*string); ok && key != nil {
+		return *key
+	}
+	return ""
+}
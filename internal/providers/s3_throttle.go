@@ -0,0 +1,162 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package providers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+
+	"github.com/aws/smithy-go/middleware"
+	"go.uber.org/zap"
+)
+
+// requestPacerID names the middleware spacing out S3 API attempts
+const requestPacerID = "CloudAWSyncRequestPacer"
+
+// minPacingDelay is the spacing set by the first throttled response; the
+// spacing falls back to none once recovery takes it below this
+const minPacingDelay = 10 * time.Millisecond
+
+// requestPacer spaces out the API attempts of every worker using a bucket
+// once the service starts throttling them. Each throttled response doubles
+// the delay between attempts, at most once per delay so a burst of
+// responses to concurrent requests counts once, and each successful one
+// shortens it by a sixteenth.
+type requestPacer struct {
+	mutex     sync.Mutex
+	bucket    string
+	maxDelay  time.Duration
+	delay     time.Duration // spacing between attempt starts, 0 when not throttled
+	next      time.Time     // earliest start of the next attempt
+	increased time.Time     // when the delay was last raised
+	metrics   interfaces.MetricsCollector
+	logger    *zap.Logger
+}
+
+// newRequestPacer creates a pacer for bucket that never spaces attempts
+// more than maxDelay apart. metrics may be nil.
+func newRequestPacer(bucket string, maxDelay time.Duration, metrics interfaces.MetricsCollector, logger *zap.Logger) *requestPacer {
+	return &requestPacer{
+		bucket:   bucket,
+		maxDelay: maxDelay,
+		metrics:  metrics,
+		logger:   logger,
+	}
+}
+
+// Delay returns the spacing currently enforced between attempts
+func (p *requestPacer) Delay() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.delay
+}
+
+// wait blocks until the next attempt may start or ctx is done
+func (p *requestPacer) wait(ctx context.Context) error {
+	p.mutex.Lock()
+	if p.delay == 0 {
+		p.mutex.Unlock()
+		return nil
+	}
+	now := time.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(p.delay)
+	p.mutex.Unlock()
+
+	if start.Equal(now) {
+		return nil
+	}
+	timer := time.NewTimer(start.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observe adjusts the delay to the outcome of one attempt. Errors other
+// than throttling say nothing about the request rate and are ignored.
+func (p *requestPacer) observe(err error) {
+	throttled := isThrottle(err)
+	if err != nil && !throttled {
+		return
+	}
+
+	p.mutex.Lock()
+	previous := p.delay
+	now := time.Now()
+	switch {
+	case throttled && now.Sub(p.increased) >= p.delay:
+		p.delay = min(max(2*p.delay, minPacingDelay), p.maxDelay)
+		p.increased = now
+	case !throttled && p.delay > 0:
+		p.delay -= p.delay / 16
+		if p.delay < minPacingDelay {
+			p.delay = 0
+		}
+	}
+	delay := p.delay
+	p.mutex.Unlock()
+
+	if delay == previous {
+		return
+	}
+	if p.metrics != nil {
+		p.metrics.RecordRequestPacing(p.bucket, delay)
+	}
+	switch {
+	case previous == 0:
+		p.logger.Warn("Storage service is throttling requests, slowing down",
+			zap.String("bucket", p.bucket),
+			zap.Duration("delay", delay))
+	case delay == 0:
+		p.logger.Info("Storage service throttling ended, requests no longer delayed",
+			zap.String("bucket", p.bucket))
+	}
+}
+
+// middleware returns an API option pacing every attempt made by the
+// client, retries included, and learning from its outcome
+func (p *requestPacer) middleware() func(*middleware.Stack) error {
+	pace := middleware.FinalizeMiddlewareFunc(requestPacerID, func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+		if err := p.wait(ctx); err != nil {
+			return middleware.FinalizeOutput{}, middleware.Metadata{}, err
+		}
+		out, metadata, err := next.HandleFinalize(ctx, in)
+		p.observe(err)
+		return out, metadata, err
+	})
+
+	return func(stack *middleware.Stack) error {
+		// After the retry middleware, so each attempt is paced, and before
+		// signing, so a long wait does not age the signature
+		return stack.Finalize.Insert(pace, "Retry", middleware.After)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/utils"
+)
+
+const (
+	// instanceLockName is the lock file held by the running agent, kept in
+	// the state directory
+	instanceLockName = "cloudawsync.lock"
+	// replaceTimeout is how long a replacing instance waits for the running
+	// one to stop
+	replaceTimeout = 30 * time.Second
+)
+
+// Instance describes the running agent, as recorded in its lock file
+type Instance struct {
+	PID           int       `json:"pid"`
+	ConfigPath    string    `json:"config_path,omitempty"`
+	ControlSocket string    `json:"control_socket,omitempty"`
+	GRPCSocket    string    `json:"grpc_socket,omitempty"`
+	Started       time.Time `json:"started"`
+}
+
+// AlreadyRunningError is returned when another instance holds the lock
+type AlreadyRunningError struct {
+	Path     string
+	Instance Instance
+}
+
+func (e *AlreadyRunningError) Error() string {
+	msg := fmt.Sprintf("another instance is already running (pid %d, lock %s", e.Instance.PID, e.Path)
+	if e.Instance.ControlSocket != "" {
+		msg += ", control socket " + e.Instance.ControlSocket
+	}
+	return msg + ")"
+}
+
+// InstanceLock is the single-instance lock held while the agent runs
+type InstanceLock struct {
+	path string
+	file *os.File
+}
+
+// InstanceLockPath returns the lock file for cfg in its state directory
+func InstanceLockPath(cfg *config.Config) string {
+	stateDir := cfg.StateDir
+	if stateDir == "" {
+		stateDir = config.DefaultStateDir()
+	}
+	return filepath.Join(stateDir, instanceLockName)
+}
+
+// AcquireInstanceLock takes the single-instance lock for cfg and records
+// this process in it. When another instance holds the lock an
+// *AlreadyRunningError is returned, or with replace that instance is asked
+// to stop and the lock is taken once it has exited.
+func AcquireInstanceLock(cfg *config.Config, configPath string, replace bool) (*InstanceLock, error) {
+	path := InstanceLockPath(cfg)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	locked, err := utils.TryLockExclusive(file.Fd())
+	if errors.Is(err, errors.ErrUnsupported) {
+		// Without file locks a second instance cannot be detected
+		locked, err = true, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	if !locked {
+		running, _ := readInstance(file)
+		if !replace {
+			file.Close()
+			return nil, &AlreadyRunningError{Path: path, Instance: running}
+		}
+		if err := stopInstance(file, running); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	instance := Instance{
+		PID:        os.Getpid(),
+		ConfigPath: configPath,
+		Started:    time.Now(),
+	}
+	if cfg.Control.Enabled {
+		instance.ControlSocket = cfg.Control.Socket
+		instance.GRPCSocket = cfg.Control.GRPCSocket
+	}
+	data, err := json.Marshal(instance)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to encode lock file: %w", err)
+	}
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt(append(data, '\n'), 0)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return &InstanceLock{path: path, file: file}, nil
+}
+
+// Release clears the lock file and releases the lock
+func (l *InstanceLock) Release() error {
+	if err := l.file.Truncate(0); err != nil {
+		l.file.Close()
+		return fmt.Errorf("failed to clear lock file: %w", err)
+	}
+	return l.file.Close()
+}
+
+// RunningInstance returns the instance holding the lock for cfg, reporting
+// false when no instance is running
+func RunningInstance(cfg *config.Config) (Instance, bool, error) {
+	file, err := os.Open(InstanceLockPath(cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return Instance{}, false, nil
+	}
+	if err != nil {
+		return Instance{}, false, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	defer file.Close()
+
+	free, err := utils.TryLockShared(file.Fd())
+	if errors.Is(err, errors.ErrUnsupported) {
+		return Instance{}, false, nil
+	}
+	if err != nil {
+		return Instance{}, false, fmt.Errorf("failed to check lock file: %w", err)
+	}
+	if free {
+		return Instance{}, false, nil
+	}
+	instance, err := readInstance(file)
+	return instance, true, err
+}
+
+// readInstance decodes the instance recorded in the lock file
+func readInstance(file *os.File) (Instance, error) {
+	var instance Instance
+	data, err := io.ReadAll(io.NewSectionReader(file, 0, 1<<16))
+	if err != nil {
+		return instance, fmt.Errorf("failed to read lock file: %w", err)
+	}
+	if err := json.Unmarshal(data, &instance); err != nil {
+		return instance, fmt.Errorf("failed to decode lock file: %w", err)
+	}
+	return instance, nil
+}
+
+// stopInstance asks the running instance to shut down and waits until it
+// releases the lock on file, which is then held by the caller
+func stopInstance(file *os.File, running Instance) error {
+	if running.PID <= 0 {
+		return fmt.Errorf("cannot replace the running instance, its pid is unknown")
+	}
+	process, err := os.FindProcess(running.PID)
+	if err == nil {
+		err = process.Signal(syscall.SIGTERM)
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to stop instance %d: %w", running.PID, err)
+	}
+
+	deadline := time.Now().Add(replaceTimeout)
+	for time.Now().Before(deadline) {
+		locked, err := utils.TryLockExclusive(file.Fd())
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", file.Name(), err)
+		}
+		if locked {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("instance %d did not stop within %s", running.PID, replaceTimeout)
+}
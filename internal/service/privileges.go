@@ -0,0 +1,102 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/utils"
+)
+
+// DropPrivileges switches the process to security.run_as once the
+// privileged setup is done. The state directories and the files opened so
+// far, including those of profiles, are given to that user first so it can
+// keep writing them, and missing
+// socket directories are created for it. Nothing happens when run_as is
+// unset or the process already runs as that user.
+func DropPrivileges(cfg *config.Config) error {
+	if cfg.Security.RunAs == "" {
+		return nil
+	}
+	id, err := utils.LookupIdentity(cfg.Security.RunAs)
+	if err != nil {
+		return fmt.Errorf("failed to resolve security.run_as: %w", err)
+	}
+	if os.Geteuid() == id.UID {
+		return nil
+	}
+	if !utils.IsPrivileged() {
+		return fmt.Errorf("security.run_as switches to %s, which requires starting as root", id.Name)
+	}
+
+	var paths []string
+	for _, profile := range cfg.ProfileConfigs() {
+		paths = append(paths, ownedPaths(profile)...)
+	}
+	paths = append(paths, ownedPaths(cfg)...)
+
+	for _, path := range paths {
+		if err := os.Lchown(path, id.UID, id.GID); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to give %s to %s: %w", path, id.Name, err)
+		}
+	}
+
+	if err := utils.DropPrivileges(id); err != nil {
+		return fmt.Errorf("failed to switch to %s: %w", id.Name, err)
+	}
+	return nil
+}
+
+// ownedPaths returns the files and directories of cfg the run_as user must
+// own, creating missing socket directories
+func ownedPaths(cfg *config.Config) []string {
+	var paths []string
+	if cfg.StateDir != "" {
+		paths = append(paths, cfg.StateDir)
+		entries, _ := os.ReadDir(cfg.StateDir)
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(cfg.StateDir, entry.Name()))
+		}
+	}
+	paths = append(paths, cfg.State.Path, cfg.Audit.Path, cfg.Security.NameMapPath, InstanceLockPath(cfg))
+	if filepath.IsAbs(cfg.Logging.OutputPath) {
+		paths = append(paths, cfg.Logging.OutputPath)
+	}
+
+	if cfg.Control.Enabled {
+		for _, socket := range []string{cfg.Control.Socket, cfg.Control.GRPCSocket} {
+			if socket == "" {
+				continue
+			}
+			// Existing directories such as /run are shared and keep their owner
+			dir := filepath.Dir(socket)
+			if _, err := os.Stat(dir); os.IsNotExist(err) && os.MkdirAll(dir, 0755) == nil {
+				paths = append(paths, dir)
+			}
+		}
+	}
+	return slices.DeleteFunc(paths, func(path string) bool { return path == "" })
+}
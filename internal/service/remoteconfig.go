@@ -0,0 +1,150 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/providers"
+
+	"go.uber.org/zap"
+)
+
+// maxRemoteConfigSize limits the size of a fetched remote configuration
+const maxRemoteConfigSize = 4 * 1024 * 1024
+
+// FetchRemoteConfig downloads a remote configuration and its signature
+// from s3://bucket/key, using the credentials and endpoint of the aws
+// section, or from an https:// URL. Both are read with one client. It is
+// the config.RemoteFetcher of the agent.
+func FetchRemoteConfig(ctx context.Context, cfg *config.Config, rawURL string) ([]byte, []byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	sigURL, err := config.SignatureURL(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if timeout := cfg.Performance.TimeoutDuration; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var open func(ctx context.Context, rawURL string) (io.ReadCloser, error)
+	switch u.Scheme {
+	case "s3":
+		open, err = s3Opener(ctx, cfg, u.Host)
+	case "https":
+		open, err = httpsOpener(cfg)
+	default:
+		return nil, nil, fmt.Errorf("unsupported remote configuration URL %s", rawURL)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	document, err := readRemoteObject(ctx, open, rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := readRemoteObject(ctx, open, sigURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	return document, signature, nil
+}
+
+// readRemoteObject reads the object at rawURL, up to maxRemoteConfigSize
+// bytes
+func readRemoteObject(ctx context.Context, open func(context.Context, string) (io.ReadCloser, error), rawURL string) ([]byte, error) {
+	body, err := open(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, maxRemoteConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	if len(data) > maxRemoteConfigSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", rawURL, maxRemoteConfigSize)
+	}
+	return data, nil
+}
+
+// s3Opener returns a function opening objects of a bucket that need not
+// be the one synced to
+func s3Opener(ctx context.Context, cfg *config.Config, bucket string) (func(context.Context, string) (io.ReadCloser, error), error) {
+	aws := cfg.AWS
+	aws.S3Bucket = bucket
+	aws.S3Prefix = ""
+	provider, err := providers.NewS3Provider(ctx, newS3Config(cfg, aws), zap.NewNop())
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.TrimPrefix(u.Path, "/")
+		body, _, err := provider.Download(ctx, key, interfaces.TransferOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to download s3://%s/%s: %w", bucket, key, err)
+		}
+		return body, nil
+	}, nil
+}
+
+// httpsOpener returns a function requesting URLs with the proxy and CA
+// bundle of the network section
+func httpsOpener(cfg *config.Config) (func(context.Context, string) (io.ReadCloser, error), error) {
+	client, err := providers.NewHTTPClient(newHTTPConfig(cfg.Network), 0)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, rawURL string) (io.ReadCloser, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return nil, fmt.Errorf("failed to fetch %s: %s", rawURL, response.Status)
+		}
+		return response.Body, nil
+	}, nil
+}
@@ -24,14 +24,26 @@ package service
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"CloudAWSync/internal/antivirus"
+	"CloudAWSync/internal/audit"
+	"CloudAWSync/internal/chunker"
 	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/control"
 	"CloudAWSync/internal/engine"
 	"CloudAWSync/internal/interfaces"
 	"CloudAWSync/internal/metrics"
+	"CloudAWSync/internal/mount"
 	"CloudAWSync/internal/providers"
+	"CloudAWSync/internal/state"
 	"CloudAWSync/internal/utils"
 	"CloudAWSync/internal/watcher"
 
@@ -44,10 +56,28 @@ type Service struct {
 	logger *zap.Logger
 
 	// Components
-	provider interfaces.CloudProvider
-	watcher  interfaces.FileWatcher
-	metrics  interfaces.MetricsCollector
-	engine   interfaces.SyncEngine
+	provider  interfaces.CloudProvider
+	watcher   interfaces.FileWatcher
+	metrics   interfaces.MetricsCollector
+	engine    interfaces.SyncEngine
+	state     *state.Store
+	audit     *audit.Log
+	journal   *state.Journal
+	control   *control.Server
+	grpc      *control.GRPCServer
+	dashboard *control.Dashboard
+
+	// Cipher of remote key names, nil unless names are obfuscated
+	nameCipher *providers.NameCipher
+
+	// Provider writing to every mirror, nil without mirrors
+	fanout *providers.FanoutProvider
+
+	// Provider switching to the secondary bucket, nil without failover
+	failover *providers.FailoverProvider
+
+	// Circuit breaker of each backend, empty unless enabled
+	breakers []*providers.CircuitBreaker
 
 	// State
 	running bool
@@ -56,13 +86,17 @@ type Service struct {
 	cancel  context.CancelFunc
 }
 
-// NewService creates a new CloudAWSync service
-func NewService(cfg *config.Config) (*Service, error) {
+// NewService creates a new CloudAWSync service. ctx bounds the requests
+// made while connecting to storage; cancelling it aborts startup.
+func NewService(ctx context.Context, cfg *config.Config) (*Service, error) {
 	// Initialize logger
 	logger, err := utils.InitLogger(cfg.Logging)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize logger: %w", err)
 	}
+	if cfg.Profile != "" {
+		logger = logger.With(zap.String("profile", cfg.Profile))
+	}
 
 	service := &Service{
 		config: cfg,
@@ -70,7 +104,7 @@ func NewService(cfg *config.Config) (*Service, error) {
 	}
 
 	// Initialize components
-	if err := service.initializeComponents(); err != nil {
+	if err := service.initializeComponents(ctx); err != nil {
 		return nil, fmt.Errorf("failed to initialize components: %w", err)
 	}
 
@@ -91,6 +125,15 @@ func (s *Service) Start() error {
 
 	s.logger.Info("Starting CloudAWSync service")
 
+	// Lower the agent's CPU and I/O priority before any work starts
+	performance := s.config.Performance
+	if err := utils.SetProcessPriority(performance.Nice, performance.IOClass, performance.IOPriority); err != nil {
+		s.logger.Warn("Failed to set process priority",
+			zap.Int("nice", performance.Nice),
+			zap.String("io_class", performance.IOClass),
+			zap.Error(err))
+	}
+
 	// Start metrics collector
 	if s.config.Metrics.Enabled && s.metrics != nil {
 		if err := s.metrics.Start(s.ctx); err != nil {
@@ -120,6 +163,42 @@ func (s *Service) Start() error {
 	}
 	s.logger.Info("Sync engine started successfully")
 
+	// Start control API
+	if s.config.Control.Enabled {
+		s.control = control.NewServer(s.config.Control.Socket, s, s.logger)
+		if err := s.control.Start(); err != nil {
+			s.logger.Error("Failed to start control server", zap.Error(err))
+			s.control = nil
+		}
+		if s.config.Control.GRPCSocket != "" {
+			s.grpc = control.NewGRPCServer(s.config.Control.GRPCSocket, s, s.logger)
+			if err := s.grpc.Start(); err != nil {
+				s.logger.Error("Failed to start gRPC control server", zap.Error(err))
+				s.grpc = nil
+			}
+		}
+	}
+
+	// Start web dashboard
+	if s.config.Dashboard.Enabled {
+		options := control.DashboardOptions{
+			Listen:   s.config.Dashboard.Listen,
+			Username: s.config.Dashboard.Username,
+			Password: s.config.Dashboard.Password,
+			History:  s.config.Dashboard.History,
+			CertFile: s.config.Dashboard.TLSCert,
+			KeyFile:  s.config.Dashboard.TLSKey,
+		}
+		if engineImpl, ok := s.engine.(*engine.Engine); ok {
+			options.Clock = engineImpl.Clock()
+		}
+		s.dashboard = control.NewDashboard(options, s, s.logger)
+		if err := s.dashboard.Start(); err != nil {
+			s.logger.Error("Failed to start dashboard", zap.Error(err))
+			s.dashboard = nil
+		}
+	}
+
 	// Perform initial sync for all directories in the background
 	go s.performInitialSync()
 
@@ -145,6 +224,28 @@ func (s *Service) Stop() error {
 		s.cancel()
 	}
 
+	// Stop control API
+	if s.control != nil {
+		if err := s.control.Stop(); err != nil {
+			s.logger.Error("Failed to stop control server", zap.Error(err))
+		}
+		s.control = nil
+	}
+	if s.grpc != nil {
+		if err := s.grpc.Stop(); err != nil {
+			s.logger.Error("Failed to stop gRPC control server", zap.Error(err))
+		}
+		s.grpc = nil
+	}
+
+	// Stop web dashboard
+	if s.dashboard != nil {
+		if err := s.dashboard.Stop(); err != nil {
+			s.logger.Error("Failed to stop dashboard", zap.Error(err))
+		}
+		s.dashboard = nil
+	}
+
 	// Stop sync engine
 	if s.engine != nil {
 		if err := s.engine.Stop(); err != nil {
@@ -182,6 +283,375 @@ func (s *Service) GetStats() interfaces.SyncStats {
 	return s.engine.GetStats()
 }
 
+// Health reports whether the agent can sync: unhealthy while the storage
+// service is unreachable, degraded while failed over to the secondary
+// bucket, over quota or paused
+func (s *Service) Health() interfaces.Health {
+	stats := s.GetStats()
+	var unhealthy, degraded []string
+	if stats.Offline {
+		unhealthy = append(unhealthy, fmt.Sprintf("storage service unreachable, %d upload(s) queued", stats.OfflineQueued))
+	}
+	if stats.QuotaExceeded {
+		degraded = append(degraded, "remote storage quota exceeded")
+	}
+	if stats.Paused {
+		degraded = append(degraded, "transfers paused")
+	}
+
+	health := interfaces.Health{Status: "ok"}
+	if s.failover != nil {
+		provider := s.failover.Health()
+		health.Provider = &provider
+		if provider.FailedOver {
+			degraded = append(degraded, fmt.Sprintf("failed over to %s since %s: %s",
+				provider.Active, provider.FailedOverAt.Format(time.RFC3339), provider.LastError))
+		}
+	}
+	for _, breaker := range s.breakers {
+		circuit := breaker.Health()
+		health.Circuits = append(health.Circuits, circuit)
+		if circuit.State == providers.CircuitClosed {
+			continue
+		}
+		problem := fmt.Sprintf("circuit breaker for %s %s since %s after %d failures: %s",
+			circuit.Backend, circuit.State, circuit.OpenedAt.Format(time.RFC3339), circuit.ConsecutiveFailures, circuit.LastError)
+		// Only the primary without a secondary leaves nothing to sync to
+		if circuit.Backend == "primary" && s.failover == nil {
+			unhealthy = append(unhealthy, problem)
+		} else {
+			degraded = append(degraded, problem)
+		}
+	}
+
+	health.Problems = append(unhealthy, degraded...)
+	switch {
+	case len(unhealthy) > 0:
+		health.Status = "unhealthy"
+	case len(degraded) > 0:
+		health.Status = "degraded"
+	}
+	return health
+}
+
+// GetActivity returns the transfers in progress, queue depths and recent
+// transfer failures
+func (s *Service) GetActivity() interfaces.Activity {
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		return engineImpl.Activity()
+	}
+	return interfaces.Activity{}
+}
+
+// GetStorageUsage returns tracked remote storage usage by scope
+func (s *Service) GetStorageUsage() map[string]interfaces.StorageUsage {
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		return engineImpl.GetStorageUsage()
+	}
+	return nil
+}
+
+// GetCostEstimates returns the estimated month-to-date spend per directory
+func (s *Service) GetCostEstimates() map[string]engine.CostEstimate {
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		return engineImpl.GetCostEstimates()
+	}
+	return nil
+}
+
+// Verify compares every enabled directory against its remote copy without
+// transferring data. With fromManifest, directories are compared against
+// their latest manifest instead of a remote listing. The service does not
+// need to be running.
+func (s *Service) Verify(ctx context.Context, fromManifest bool) ([]*engine.VerifyReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support verification")
+	}
+
+	var reports []*engine.VerifyReport
+	for _, dir := range s.config.Directories {
+		if !dir.Enabled {
+			continue
+		}
+		var report *engine.VerifyReport
+		var err error
+		switch {
+		case !fromManifest:
+			report, err = engineImpl.Verify(ctx, dir)
+		case dir.SyncMode == interfaces.SyncModeBackup:
+			continue
+		default:
+			report, err = engineImpl.VerifyManifest(ctx, dir)
+		}
+		if err != nil {
+			return reports, fmt.Errorf("failed to verify %s: %w", dir.LocalPath, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Scrub checks every object recorded in the state store against the remote
+// and re-hashes a sample of downloaded objects
+func (s *Service) Scrub(ctx context.Context) (*engine.ScrubReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support scrubbing")
+	}
+	return engineImpl.Scrub(ctx, s.config.Scrub.SampleSize)
+}
+
+// CheckParity checks the parity stripes of every enabled backup directory
+// with a parity policy, repairing damaged objects when repair is set
+func (s *Service) CheckParity(ctx context.Context, repair bool) ([]*engine.ParityReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support parity")
+	}
+
+	var reports []*engine.ParityReport
+	for _, dir := range s.config.Directories {
+		if !dir.Enabled || dir.SyncMode != interfaces.SyncModeBackup || dir.Parity.IsZero() {
+			continue
+		}
+		report, err := engineImpl.CheckParity(ctx, dir, repair)
+		if err != nil {
+			return reports, fmt.Errorf("failed to check parity of %s: %w", dir.LocalPath, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// RetentionReport evaluates the remote retention rules of every enabled
+// directory without deleting anything
+func (s *Service) RetentionReport(ctx context.Context) ([]*engine.RetentionReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support retention")
+	}
+
+	var reports []*engine.RetentionReport
+	for _, dir := range s.config.Directories {
+		if !dir.Enabled || dir.RemoteRetention.IsZero() {
+			continue
+		}
+		report, err := engineImpl.ApplyRemoteRetention(ctx, dir, true)
+		if err != nil {
+			return reports, fmt.Errorf("failed to evaluate retention for %s: %w", dir.LocalPath, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ArchiveReport lists the local files archive mode would remove from every
+// enabled directory without removing anything
+func (s *Service) ArchiveReport(ctx context.Context) ([]*engine.ArchiveReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support archiving")
+	}
+
+	var reports []*engine.ArchiveReport
+	for _, dir := range s.config.Directories {
+		if !dir.Enabled || dir.Archive.After <= 0 {
+			continue
+		}
+		report, err := engineImpl.ArchiveDirectory(ctx, dir, true)
+		if err != nil {
+			return reports, fmt.Errorf("failed to evaluate archive for %s: %w", dir.LocalPath, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// RemoteOnly reports the remote objects with no local file of every
+// enabled directory that is not backed up, listing their keys when
+// withKeys is set
+func (s *Service) RemoteOnly(ctx context.Context, withKeys bool) ([]interfaces.RemoteOnlyReport, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support remote-only reports")
+	}
+	return engineImpl.RemoteOnlyReports(ctx, withKeys)
+}
+
+// ListGenerations returns the backup generations of every backup-mode
+// directory keyed by local path, oldest first
+func (s *Service) ListGenerations(ctx context.Context) (map[string][]string, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support backups")
+	}
+
+	result := make(map[string][]string)
+	for _, dir := range s.config.Directories {
+		if dir.SyncMode != interfaces.SyncModeBackup {
+			continue
+		}
+		ids, err := engineImpl.ListGenerations(ctx, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list generations for %s: %w", dir.LocalPath, err)
+		}
+		result[dir.LocalPath] = ids
+	}
+	return result, nil
+}
+
+// RestoreGeneration restores a backup generation of the directory at
+// localPath into target, returning the number of files restored
+func (s *Service) RestoreGeneration(ctx context.Context, localPath, id, target string) (int, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return 0, fmt.Errorf("sync engine does not support backups")
+	}
+
+	dir, ok := s.findDirectory(localPath)
+	if !ok {
+		return 0, fmt.Errorf("directory %s is not configured", localPath)
+	}
+	if dir.SyncMode != interfaces.SyncModeBackup {
+		return 0, fmt.Errorf("directory %s is not in backup mode", localPath)
+	}
+	return engineImpl.RestoreGeneration(ctx, dir, id, target)
+}
+
+// Hydrate downloads the archived file at path, which may name the original
+// file or its stub, returning the path of the restored file
+func (s *Service) Hydrate(ctx context.Context, path string) (string, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return "", fmt.Errorf("sync engine does not support hydration")
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	dir, ok := s.directoryContaining(absPath)
+	if !ok {
+		return "", fmt.Errorf("%s is not inside a configured directory", absPath)
+	}
+	return engineImpl.Hydrate(ctx, dir, absPath)
+}
+
+// directoryContaining returns the enabled configured directory that most
+// closely contains path
+func (s *Service) directoryContaining(path string) (interfaces.SyncDirectory, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var match interfaces.SyncDirectory
+	found := false
+	for _, dir := range s.config.Directories {
+		root := filepath.Clean(dir.LocalPath)
+		if !dir.Enabled || !strings.HasPrefix(path, root+string(filepath.Separator)) {
+			continue
+		}
+		if len(root) > len(match.LocalPath) {
+			match = dir
+			found = true
+		}
+	}
+	return match, found
+}
+
+// ListRemote lists the remote objects below prefix with their plain keys,
+// relative to aws.s3_prefix. Encrypted names are decrypted.
+func (s *Service) ListRemote(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	files, err := s.provider.List(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote objects: %w", err)
+	}
+	slices.SortFunc(files, func(a, b interfaces.FileInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return files, nil
+}
+
+// StoredKey returns the key an object is stored under, which differs from
+// its plain key when names are obfuscated
+func (s *Service) StoredKey(key string) string {
+	if s.nameCipher == nil {
+		return key
+	}
+	return s.nameCipher.EncryptKey(key)
+}
+
+// keyEncoding returns the key encoding of the configured directory holding
+// the remote prefix, so mounted names match the local ones
+func (s *Service) keyEncoding(prefix string) interfaces.KeyEncoding {
+	prefix = strings.Trim(prefix, "/")
+	for _, dir := range s.config.Directories {
+		remotePath := strings.Trim(dir.RemotePath, "/")
+		if prefix == remotePath || strings.HasPrefix(prefix, remotePath+"/") {
+			return dir.KeyEncoding
+		}
+	}
+	return interfaces.KeyEncoding{}
+}
+
+// Mount serves the remote prefix at mountpoint until ctx is cancelled or the
+// filesystem is unmounted. Writes are uploaded through the sync engine, which
+// runs without configured directories for the duration of the mount.
+func (s *Service) Mount(ctx context.Context, prefix, mountpoint string) error {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return fmt.Errorf("sync engine does not support mounting")
+	}
+	if s.config.Mount.CacheDir == "" {
+		return fmt.Errorf("mount.cache_dir must be set")
+	}
+
+	absMountpoint, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return fmt.Errorf("failed to resolve mountpoint: %w", err)
+	}
+
+	// The engine outlives ctx so write-backs queued before unmount complete
+	engineCtx, cancelEngine := context.WithCancel(context.Background())
+	defer cancelEngine()
+	if err := s.engine.Start(engineCtx); err != nil {
+		return fmt.Errorf("failed to start sync engine: %w", err)
+	}
+
+	fsys := mount.New(s.provider, engineImpl, mount.Options{
+		Prefix:      strings.Trim(prefix, "/"),
+		CacheDir:    s.config.Mount.CacheDir,
+		CacheSize:   s.config.Mount.CacheSize,
+		ListTTL:     s.config.Mount.ListTTL,
+		KeyEncoding: s.keyEncoding(prefix),
+	}, s.logger)
+	mountErr := fsys.Mount(ctx, absMountpoint)
+
+	// Let queued write-backs finish before stopping the engine
+	for pending := engineImpl.PendingUploads(); pending > 0; pending = engineImpl.PendingUploads() {
+		s.logger.Info("Waiting for write-back uploads", zap.Int("pending", pending))
+		time.Sleep(time.Second)
+	}
+	if err := s.engine.Stop(); err != nil {
+		s.logger.Error("Failed to stop sync engine", zap.Error(err))
+	}
+	return mountErr
+}
+
+// findDirectory returns the configured directory with the given local path
+func (s *Service) findDirectory(localPath string) (interfaces.SyncDirectory, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, dir := range s.config.Directories {
+		if dir.LocalPath == localPath {
+			return dir, true
+		}
+	}
+	return interfaces.SyncDirectory{}, false
+}
+
 // GetMetrics returns service metrics
 func (s *Service) GetMetrics() interfaces.Metrics {
 	if s.metrics == nil {
@@ -190,13 +660,21 @@ func (s *Service) GetMetrics() interfaces.Metrics {
 	return s.metrics.GetMetrics()
 }
 
-// AddDirectory adds a directory for synchronization
+// AddDirectory adds a directory for synchronization. The directory is
+// validated against the rest of the configuration; it is not written to
+// the config file.
 func (s *Service) AddDirectory(dir interfaces.SyncDirectory) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	candidate := *s.config
+	candidate.Directories = append(slices.Clone(s.config.Directories), dir)
+	if err := candidate.Validate(); err != nil {
+		return fmt.Errorf("invalid directory: %w", err)
+	}
+
 	// Add to config
-	s.config.Directories = append(s.config.Directories, dir)
+	s.config.Directories = candidate.Directories
 
 	// Add to engine if running
 	if s.running && s.engine != nil {
@@ -213,27 +691,442 @@ func (s *Service) AddDirectory(dir interfaces.SyncDirectory) error {
 	return nil
 }
 
-// RemoveDirectory removes a directory from synchronization
-func (s *Service) RemoveDirectory(localPath string) error {
+// RemoveDirectory removes a directory from synchronization. The running
+// engine stops watching it and drops its queued transfers; with
+// deleteRemote its remote objects are deleted too and their number
+// returned. The config file is not changed.
+func (s *Service) RemoveDirectory(ctx context.Context, localPath string, deleteRemote bool) (int, error) {
+	localPath = filepath.Clean(localPath)
+
+	s.mutex.Lock()
+	index := slices.IndexFunc(s.config.Directories, func(dir interfaces.SyncDirectory) bool {
+		return dir.LocalPath == localPath
+	})
+	if index < 0 {
+		s.mutex.Unlock()
+		return 0, fmt.Errorf("directory %s is not configured", localPath)
+	}
+	if deleteRemote && !s.running {
+		s.mutex.Unlock()
+		return 0, fmt.Errorf("remote objects can only be deleted while the service is running")
+	}
+	s.config.Directories = slices.Delete(slices.Clone(s.config.Directories), index, index+1)
+	running := s.running
+	s.mutex.Unlock()
+
+	s.logger.Info("Directory removed from sync",
+		zap.String("local_path", localPath))
+
+	if !running || s.engine == nil {
+		return 0, nil
+	}
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return 0, fmt.Errorf("sync engine does not support removing directories")
+	}
+	return engineImpl.RemoveDirectory(ctx, localPath, deleteRemote)
+}
+
+// EnableDirectory resumes syncing the configured directory at localPath
+// and starts a sync to pick up changes made while it was disabled. The
+// change is not written to the config file.
+func (s *Service) EnableDirectory(localPath string) error {
+	localPath = filepath.Clean(localPath)
+	if err := s.setDirectoryEnabled(localPath, true); err != nil {
+		return err
+	}
+	return s.TriggerSync(localPath)
+}
+
+// DisableDirectory stops syncing the configured directory at localPath
+// until it is enabled again. The change is not written to the config file.
+func (s *Service) DisableDirectory(localPath string) error {
+	return s.setDirectoryEnabled(filepath.Clean(localPath), false)
+}
+
+// setDirectoryEnabled updates the configuration and the running engine
+func (s *Service) setDirectoryEnabled(localPath string, enabled bool) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	// Remove from config
-	for i, dir := range s.config.Directories {
-		if dir.LocalPath == localPath {
-			s.config.Directories = append(s.config.Directories[:i], s.config.Directories[i+1:]...)
-			break
+	index := slices.IndexFunc(s.config.Directories, func(dir interfaces.SyncDirectory) bool {
+		return dir.LocalPath == localPath
+	})
+	if index < 0 {
+		return fmt.Errorf("directory %s is not configured", localPath)
+	}
+	s.config.Directories[index].Enabled = enabled
+
+	if s.running && s.engine != nil {
+		engineImpl, ok := s.engine.(*engine.Engine)
+		if !ok {
+			return fmt.Errorf("sync engine does not support enabling directories")
 		}
+		if enabled {
+			return engineImpl.EnableDirectory(localPath)
+		}
+		return engineImpl.DisableDirectory(localPath)
 	}
+	return nil
+}
 
-	s.logger.Info("Directory removed from sync",
-		zap.String("local_path", localPath))
+// SyncNow syncs the enabled directory at localPath and waits until its
+// changes are found and queued for transfer
+func (s *Service) SyncNow(ctx context.Context, localPath string) error {
+	s.mutex.RLock()
+	running := s.running
+	s.mutex.RUnlock()
+	if !running {
+		return fmt.Errorf("service is not running")
+	}
+
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return fmt.Errorf("sync engine does not support on-demand syncs")
+	}
+	return engineImpl.SyncNow(ctx, filepath.Clean(localPath))
+}
+
+// SyncSummary describes the outcome of a single-shot sync
+type SyncSummary struct {
+	Directories []interfaces.DirectoryStatus
+	Stats       interfaces.SyncStats
+	Started     time.Time
+	Duration    time.Duration
+
+	failures []error // directory syncs that failed
+}
+
+// OK reports whether every directory synced and every transfer succeeded
+func (s *SyncSummary) OK() bool {
+	for _, dir := range s.Directories {
+		if dir.LastError != "" {
+			return false
+		}
+	}
+	return s.Stats.SyncErrors == 0 && s.Stats.OfflineQueued == 0
+}
+
+// AuthFailed reports whether the storage service rejected the credentials
+// during the sync
+func (s *SyncSummary) AuthFailed() bool {
+	for _, err := range s.failures {
+		if providers.IsAuthError(err) {
+			return true
+		}
+	}
+	for _, failure := range s.Stats.RecentErrors {
+		if providers.IsAuthError(failure.Error) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncOnce runs one full sync of every enabled directory, or only of the
+// directory at localPath, waits until its transfers have finished and
+// stops the sync engine. The service must not be running.
+func (s *Service) SyncOnce(ctx context.Context, localPath string) (*SyncSummary, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support single-shot syncs")
+	}
+
+	var dirs []interfaces.SyncDirectory
+	if localPath == "" {
+		for _, dir := range s.config.Directories {
+			if dir.Enabled {
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) == 0 {
+			return nil, fmt.Errorf("no enabled directories to sync")
+		}
+	} else {
+		dir, ok := s.findDirectory(filepath.Clean(localPath))
+		if !ok {
+			return nil, fmt.Errorf("directory %s is not configured", localPath)
+		}
+		if !dir.Enabled {
+			return nil, fmt.Errorf("directory %s is disabled", localPath)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	for _, dir := range dirs {
+		engineImpl.AddDirectory(dir)
+	}
+	start := time.Now()
+	if err := s.engine.Start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start sync engine: %w", err)
+	}
+
+	var failures []error
+	failed := engineImpl.SyncDirectories(ctx, dirs, s.engine.Sync)
+	for _, dir := range dirs {
+		if err, ok := failed[dir.LocalPath]; ok {
+			failures = append(failures, err)
+		}
+	}
+
+	waitErr := engineImpl.WaitIdle(ctx)
+	if err := s.engine.Stop(); err != nil {
+		s.logger.Error("Failed to stop sync engine", zap.Error(err))
+	}
+	if waitErr != nil {
+		return nil, fmt.Errorf("failed to wait for transfers: %w", waitErr)
+	}
+
+	return &SyncSummary{
+		Directories: engineImpl.DirectoryStatuses(),
+		Stats:       engineImpl.GetStats(),
+		Started:     start,
+		Duration:    time.Since(start),
+		failures:    failures,
+	}, nil
+}
+
+// Quarantined returns the files skipped after repeated upload failures
+func (s *Service) Quarantined() ([]state.QuarantinedFile, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not support quarantine")
+	}
+	return engineImpl.Quarantined(), nil
+}
+
+// SyncRuns returns the recorded sync runs of a directory, or of every
+// directory when directory is empty, newest first
+func (s *Service) SyncRuns(directory string, limit int) ([]state.SyncRun, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, fmt.Errorf("sync engine does not record sync runs")
+	}
+	return engineImpl.SyncRuns(directory, limit), nil
+}
+
+// ClearQuarantine releases the given files from quarantine, or every
+// quarantined file when none are given, returning the number released
+func (s *Service) ClearQuarantine(paths []string) (int, error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return 0, fmt.Errorf("sync engine does not support quarantine")
+	}
+	cleaned := make([]string, len(paths))
+	for i, path := range paths {
+		cleaned[i] = filepath.Clean(path)
+	}
+	return engineImpl.ClearQuarantine(cleaned), nil
+}
 
+// SetConcurrency changes the number of concurrent uploads and downloads
+// without restarting the sync engine
+func (s *Service) SetConcurrency(uploads, downloads int) error {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return fmt.Errorf("sync engine does not support runtime concurrency changes")
+	}
+	if err := engineImpl.SetConcurrency(uploads, downloads); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.config.Performance.MaxConcurrentUploads = uploads
+	s.config.Performance.MaxConcurrentDownloads = downloads
+	s.mutex.Unlock()
+	return nil
+}
+
+// GetDirectories returns the status of every sync directory
+func (s *Service) GetDirectories() []interfaces.DirectoryStatus {
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		return engineImpl.DirectoryStatuses()
+	}
+	return nil
+}
+
+// TriggerSync starts a sync of the enabled directory at localPath, or of
+// every enabled directory when localPath is empty, without waiting for it
+func (s *Service) TriggerSync(localPath string) error {
+	s.mutex.RLock()
+	ctx, running := s.ctx, s.running
+	s.mutex.RUnlock()
+	if !running {
+		return fmt.Errorf("service is not running")
+	}
+
+	var dirs []interfaces.SyncDirectory
+	if localPath == "" {
+		s.mutex.RLock()
+		dirs = append(dirs, s.config.Directories...)
+		s.mutex.RUnlock()
+	} else {
+		dir, ok := s.findDirectory(filepath.Clean(localPath))
+		if !ok {
+			return fmt.Errorf("directory %s is not configured", localPath)
+		}
+		if !dir.Enabled {
+			return fmt.Errorf("directory %s is disabled", localPath)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	dirs = slices.DeleteFunc(dirs, func(dir interfaces.SyncDirectory) bool { return !dir.Enabled })
+	go func() {
+		failures := s.syncDirectories(ctx, dirs, s.engine.Sync)
+		for _, dir := range dirs {
+			if err, ok := failures[dir.LocalPath]; ok {
+				s.logger.Error("Requested sync failed for directory",
+					zap.String("local_path", dir.LocalPath),
+					zap.Error(err))
+			}
+		}
+	}()
 	return nil
 }
 
+// syncDirectories runs sync for each of dirs in the order set by their
+// priority and after settings, and returns the errors by local path
+func (s *Service) syncDirectories(ctx context.Context, dirs []interfaces.SyncDirectory, sync func(context.Context, interfaces.SyncDirectory) error) map[string]error {
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		return engineImpl.SyncDirectories(ctx, dirs, sync)
+	}
+	failures := make(map[string]error)
+	for _, dir := range dirs {
+		if err := sync(ctx, dir); err != nil {
+			failures[dir.LocalPath] = err
+		}
+	}
+	return failures
+}
+
+// SubscribeEvents streams sync events until the returned function is
+// called
+func (s *Service) SubscribeEvents(buffer int) (<-chan interfaces.SyncEvent, func(), error) {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return nil, nil, fmt.Errorf("sync engine does not support event streaming")
+	}
+	events, cancel := engineImpl.Subscribe(buffer)
+	return events, cancel, nil
+}
+
+// PauseTransfers stops new transfers until ResumeTransfers is called
+func (s *Service) PauseTransfers() error {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return fmt.Errorf("sync engine does not support pausing")
+	}
+	engineImpl.Pause()
+	return nil
+}
+
+// ResumeTransfers lets transfers run again after PauseTransfers
+func (s *Service) ResumeTransfers() error {
+	engineImpl, ok := s.engine.(*engine.Engine)
+	if !ok {
+		return fmt.Errorf("sync engine does not support pausing")
+	}
+	engineImpl.Resume()
+	return nil
+}
+
+// Reload applies the settings of newConfig that can change while running.
+// Other changes are reported and take effect on the next restart.
+func (s *Service) Reload(newConfig *config.Config) error {
+	if err := newConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	performance := newConfig.Performance
+	if err := s.SetConcurrency(performance.MaxConcurrentUploads, performance.MaxConcurrentDownloads); err != nil {
+		return err
+	}
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		engineImpl.SetBandwidthLimit(performance.BandwidthLimit)
+		engineImpl.SetDirectoryParallelism(performance.DirectoryParallelism)
+		s.mutex.Lock()
+		s.config.Performance.BandwidthLimit = performance.BandwidthLimit
+		s.config.Performance.DirectoryParallelism = performance.DirectoryParallelism
+		s.mutex.Unlock()
+	}
+
+	s.reconcileDirectories(newConfig.Directories)
+	s.mutex.Lock()
+	s.config.RemoteDocument = newConfig.RemoteDocument
+	s.mutex.Unlock()
+
+	s.mutex.RLock()
+	restartNeeded := !reflect.DeepEqual(s.config, newConfig)
+	s.mutex.RUnlock()
+	if restartNeeded {
+		s.logger.Warn("Some configuration changes take effect only after a restart")
+	}
+
+	s.logger.Info("Configuration reloaded")
+	return nil
+}
+
+// reconcileDirectories adds, replaces and removes directories so that the
+// service syncs those of dirs. Remote objects of removed directories are
+// kept, and added directories are synced right away.
+func (s *Service) reconcileDirectories(dirs []interfaces.SyncDirectory) {
+	s.mutex.RLock()
+	current := make(map[string]interfaces.SyncDirectory, len(s.config.Directories))
+	for _, dir := range s.config.Directories {
+		current[dir.LocalPath] = dir
+	}
+	ctx, running := s.ctx, s.running
+	s.mutex.RUnlock()
+
+	wanted := make(map[string]interfaces.SyncDirectory, len(dirs))
+	for _, dir := range dirs {
+		wanted[dir.LocalPath] = dir
+	}
+
+	failed := false
+	for path, dir := range current {
+		if newDir, ok := wanted[path]; ok && reflect.DeepEqual(dir, newDir) {
+			continue
+		}
+		if _, err := s.RemoveDirectory(ctx, path, false); err != nil {
+			failed = true
+			s.logger.Error("Failed to remove directory on reload",
+				zap.String("local_path", path),
+				zap.Error(err))
+		}
+	}
+
+	for _, dir := range dirs {
+		if oldDir, ok := current[dir.LocalPath]; ok && reflect.DeepEqual(oldDir, dir) {
+			continue
+		}
+		if err := s.AddDirectory(dir); err != nil {
+			failed = true
+			s.logger.Error("Failed to add directory on reload",
+				zap.String("local_path", dir.LocalPath),
+				zap.Error(err))
+			continue
+		}
+		if running && dir.Enabled {
+			if err := s.TriggerSync(dir.LocalPath); err != nil {
+				s.logger.Warn("Failed to sync added directory",
+					zap.String("local_path", dir.LocalPath),
+					zap.Error(err))
+			}
+		}
+	}
+
+	// Keep the configured order once everything was applied
+	if !failed {
+		s.mutex.Lock()
+		s.config.Directories = slices.Clone(dirs)
+		s.mutex.Unlock()
+	}
+}
+
 // UpdateConfig updates the service configuration
-func (s *Service) UpdateConfig(newConfig *config.Config) error {
+func (s *Service) UpdateConfig(ctx context.Context, newConfig *config.Config) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -257,7 +1150,7 @@ func (s *Service) UpdateConfig(newConfig *config.Config) error {
 		}
 
 		// Reinitialize components
-		if err := s.initializeComponents(); err != nil {
+		if err := s.initializeComponents(ctx); err != nil {
 			s.logger.Error("Failed to reinitialize components", zap.Error(err))
 			s.config = oldConfig // Rollback
 			return err
@@ -271,7 +1164,7 @@ func (s *Service) UpdateConfig(newConfig *config.Config) error {
 		}
 	} else {
 		// Just reinitialize components
-		if err := s.initializeComponents(); err != nil {
+		if err := s.initializeComponents(ctx); err != nil {
 			s.logger.Error("Failed to reinitialize components", zap.Error(err))
 			s.config = oldConfig // Rollback
 			return err
@@ -291,38 +1184,57 @@ func (s *Service) Wait() {
 
 // performInitialSync runs a one-time sync for all enabled directories on startup.
 // This ensures that any files that already exist locally are uploaded if they
-// are missing or outdated in the cloud.
+// are missing or outdated in the cloud. Directories with recorded state are
+// reconciled against it instead of listing the remote.
 func (s *Service) performInitialSync() {
 	s.logger.Info("Performing initial startup sync for all configured directories")
 
-	var wg sync.WaitGroup
+	syncDir := s.engine.Sync
+	if engineImpl, ok := s.engine.(*engine.Engine); ok {
+		syncDir = engineImpl.Reconcile
+	}
+
+	var dirs []interfaces.SyncDirectory
 	for _, dir := range s.config.Directories {
 		if dir.Enabled {
-			wg.Add(1)
-			// Run each directory sync in its own goroutine for parallel execution
-			go func(d interfaces.SyncDirectory) {
-				defer wg.Done()
-				s.logger.Info("Starting initial sync for directory", zap.String("local_path", d.LocalPath))
-				if err := s.engine.Sync(s.ctx, d); err != nil {
-					s.logger.Error("Initial sync failed for directory",
-						zap.String("local_path", d.LocalPath),
-						zap.Error(err))
-				}
-			}(dir)
+			dirs = append(dirs, dir)
 		}
 	}
 
-	wg.Wait()
+	failures := s.syncDirectories(s.ctx, dirs, func(ctx context.Context, d interfaces.SyncDirectory) error {
+		s.logger.Info("Starting initial sync for directory", zap.String("local_path", d.LocalPath))
+		return syncDir(ctx, d)
+	})
+	for _, dir := range dirs {
+		if err, ok := failures[dir.LocalPath]; ok {
+			s.logger.Error("Initial sync failed for directory",
+				zap.String("local_path", dir.LocalPath),
+				zap.Error(err))
+		}
+	}
 	s.logger.Info("Initial startup sync process completed for all directories")
 }
 
 // initializeComponents initializes all service components
-func (s *Service) initializeComponents() error {
+func (s *Service) initializeComponents(ctx context.Context) error {
 	var err error
 
+	// The state directory holds the state database, event journal, name
+	// map and audit log, which only the agent's user may read
+	if s.config.StateDir != "" {
+		if err := os.MkdirAll(s.config.StateDir, 0700); err != nil {
+			return fmt.Errorf("failed to create state directory: %w", err)
+		}
+	}
+
+	// Initialize metrics collector first so provider requests are recorded
+	s.logger.Info("Creating metrics collector...")
+	s.metrics = s.createMetricsCollector()
+	s.logger.Info("Metrics collector created successfully")
+
 	// Initialize cloud provider
 	s.logger.Info("Creating cloud provider...")
-	s.provider, err = s.createCloudProvider()
+	s.provider, err = s.createCloudProvider(ctx)
 	if err != nil {
 		s.logger.Error("Failed to create cloud provider", zap.Error(err))
 		return fmt.Errorf("failed to create cloud provider: %w", err)
@@ -338,14 +1250,57 @@ func (s *Service) initializeComponents() error {
 	}
 	s.logger.Info("File watcher created successfully")
 
-	// Initialize metrics collector
-	s.logger.Info("Creating metrics collector...")
-	s.metrics = s.createMetricsCollector()
-	s.logger.Info("Metrics collector created successfully")
+	// Open persistent state
+	if s.config.State.Path != "" {
+		s.state, err = state.Open(s.config.State.Path)
+		if err != nil {
+			s.logger.Error("Failed to open state store", zap.Error(err))
+			return fmt.Errorf("failed to open state store: %w", err)
+		}
+		s.logger.Info("State store opened",
+			zap.String("path", s.config.State.Path),
+			zap.Int("objects", s.state.Len()))
+
+		if s.fanout != nil {
+			s.fanout.SetStateStore(s.state)
+		}
+		if s.failover != nil {
+			s.failover.SetStateStore(s.state)
+		}
+	}
+
+	// Open audit log
+	if s.audit != nil {
+		s.audit.Close()
+		s.audit = nil
+	}
+	if s.config.Audit.Path != "" {
+		s.audit, err = audit.Open(s.config.Audit.Path)
+		if err != nil {
+			s.logger.Error("Failed to open audit log", zap.Error(err))
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+	}
+
+	// Open the file event journal
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	if s.config.State.JournalPath != "" {
+		s.journal, err = state.OpenJournal(s.config.State.JournalPath)
+		if err != nil {
+			s.logger.Error("Failed to open event journal", zap.Error(err))
+			return fmt.Errorf("failed to open event journal: %w", err)
+		}
+		s.logger.Info("Event journal opened",
+			zap.String("path", s.config.State.JournalPath),
+			zap.Int("pending_events", s.journal.Pending()))
+	}
 
 	// Initialize sync engine
 	s.logger.Info("Creating sync engine...")
-	s.engine = s.createSyncEngine()
+	s.engine = s.createSyncEngine(ctx)
 	s.logger.Info("Sync engine created successfully")
 
 	s.logger.Info("All components initialized successfully")
@@ -353,20 +1308,103 @@ func (s *Service) initializeComponents() error {
 }
 
 // createCloudProvider creates the cloud provider based on configuration
-func (s *Service) createCloudProvider() (interfaces.CloudProvider, error) {
+func (s *Service) createCloudProvider(ctx context.Context) (interfaces.CloudProvider, error) {
+	s.breakers = nil
+
 	// For now, only S3 is supported
-	s3Config := providers.S3Config{
-		Region:               s.config.AWS.Region,
-		Bucket:               s.config.AWS.S3Bucket,
-		Prefix:               s.config.AWS.S3Prefix,
-		Endpoint:             s.config.AWS.Endpoint,
-		AccessKeyID:          s.config.AWS.AccessKeyID,
-		SecretAccessKey:      s.config.AWS.SecretAccessKey,
-		SessionToken:         s.config.AWS.SessionToken,
-		ServerSideEncryption: s.config.Security.EncryptionEnabled,
-	}
-
-	provider, err := providers.NewS3Provider(s3Config, s.logger)
+	primary, err := s.createBackend(ctx, "primary", s.config.AWS)
+	if err != nil {
+		return nil, err
+	}
+	provider := primary
+
+	s.fanout = nil
+	if mirrorConfigs := s.config.Replication.Mirrors; len(mirrorConfigs) > 0 {
+		mirrors := make([]providers.Backend, 0, len(mirrorConfigs))
+		for _, mirror := range mirrorConfigs {
+			mirrorProvider, err := s.createBackend(ctx, mirror.Name, mirror.AWSConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create mirror %s: %w", mirror.Name, err)
+			}
+			mirrors = append(mirrors, providers.Backend{Name: mirror.Name, Provider: mirrorProvider})
+		}
+		s.fanout = providers.NewFanoutProvider(providers.Backend{Name: "primary", Provider: primary}, mirrors, s.logger)
+		provider = s.fanout
+
+		s.logger.Info("Mirroring uploads", zap.Strings("backends", s.fanout.Backends()))
+	}
+
+	s.failover = nil
+	if s.config.Failover.Enabled {
+		secondary, err := s.createBackend(ctx, "secondary", s.config.Failover.Secondary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create secondary provider: %w", err)
+		}
+		s.failover = providers.NewFailoverProvider(
+			providers.Backend{Name: "primary", Provider: primary},
+			providers.Backend{Name: "secondary", Provider: secondary},
+			s.config.Failover.FailoverAfter, s.logger)
+		provider = s.failover
+	}
+
+	s.nameCipher = nil
+	if !s.config.Security.ObfuscateNames {
+		return provider, nil
+	}
+
+	secret, err := os.ReadFile(s.config.Security.NameKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read name key file: %w", err)
+	}
+	nameCipher, err := providers.NewNameCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	var names *providers.NameMap
+	if path := s.config.Security.NameMapPath; path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create name map directory: %w", err)
+		}
+		if names, err = providers.OpenNameMap(path); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Info("Remote key names are encrypted",
+		zap.String("name_map", s.config.Security.NameMapPath))
+	s.nameCipher = nameCipher
+	return providers.NewObfuscatedProvider(provider, nameCipher, names, s.logger), nil
+}
+
+// createBackend creates the provider of one storage backend, behind a
+// circuit breaker when enabled
+func (s *Service) createBackend(ctx context.Context, name string, aws config.AWSConfig) (interfaces.CloudProvider, error) {
+	provider, err := s.createS3Provider(ctx, aws)
+	if err != nil {
+		return nil, err
+	}
+
+	breaker := s.config.Network.CircuitBreaker
+	if !breaker.Enabled {
+		return provider, nil
+	}
+	guarded := providers.NewCircuitBreaker(name, provider, breaker.FailureThreshold, breaker.Cooldown, s.logger)
+	s.breakers = append(s.breakers, guarded)
+	return guarded, nil
+}
+
+// createS3Provider creates an S3 provider for one bucket, giving the
+// bucket check the operation timeout
+func (s *Service) createS3Provider(ctx context.Context, aws config.AWSConfig) (*providers.S3Provider, error) {
+	s3Config := newS3Config(s.config, aws)
+	s3Config.Metrics = s.metrics
+
+	if timeout := s.config.Performance.TimeoutDuration; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	provider, err := providers.NewS3Provider(ctx, s3Config, s.logger)
 	if err != nil {
 		return nil, err
 	}
@@ -374,10 +1412,42 @@ func (s *Service) createCloudProvider() (interfaces.CloudProvider, error) {
 	s.logger.Info("S3 provider initialized",
 		zap.String("region", s3Config.Region),
 		zap.String("bucket", s3Config.Bucket))
-
 	return provider, nil
 }
 
+// newS3Config returns the provider settings for the bucket described by
+// aws, with the network settings of cfg
+func newS3Config(cfg *config.Config, aws config.AWSConfig) providers.S3Config {
+	return providers.S3Config{
+		Region:               aws.Region,
+		Bucket:               aws.S3Bucket,
+		Prefix:               aws.S3Prefix,
+		Endpoint:             aws.Endpoint,
+		AccessKeyID:          aws.AccessKeyID,
+		SecretAccessKey:      aws.SecretAccessKey,
+		SessionToken:         aws.SessionToken,
+		StorageClass:         aws.StorageClass,
+		ServerSideEncryption: cfg.Security.EncryptionEnabled,
+		HTTP:                 newHTTPConfig(cfg.Network),
+		MaxRequestDelay:      cfg.Network.MaxRequestDelay,
+	}
+}
+
+// newHTTPConfig returns the HTTP client settings of the network section
+func newHTTPConfig(network config.NetworkConfig) providers.HTTPConfig {
+	return providers.HTTPConfig{
+		MaxIdleConns:        network.MaxIdleConns,
+		MaxIdleConnsPerHost: network.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     network.MaxConnsPerHost,
+		IdleConnTimeout:     network.IdleConnTimeout,
+		TLSHandshakeTimeout: network.TLSHandshakeTimeout,
+		DisableHTTP2:        !network.HTTP2,
+		CABundle:            network.CABundle,
+		Proxy:               network.Proxy,
+		NoProxy:             network.NoProxy,
+	}
+}
+
 // createFileWatcher creates the file watcher
 func (s *Service) createFileWatcher() (interfaces.FileWatcher, error) {
 	// Use batched watcher for better performance
@@ -390,6 +1460,9 @@ func (s *Service) createFileWatcher() (interfaces.FileWatcher, error) {
 	// Set filters from security config
 	filters := append(s.config.Security.DeniedExtensions, ".tmp", ".swp", "~")
 	watcher.SetFilters(filters)
+	if s.metrics != nil {
+		watcher.SetMetrics(s.metrics)
+	}
 
 	s.logger.Info("File watcher initialized with batching",
 		zap.Duration("batch_delay", batchDelay))
@@ -400,11 +1473,30 @@ func (s *Service) createFileWatcher() (interfaces.FileWatcher, error) {
 // createMetricsCollector creates the metrics collector
 func (s *Service) createMetricsCollector() interfaces.MetricsCollector {
 	if s.config.Metrics.Enabled {
+		labels := make(map[string]string, len(s.config.Metrics.ConstLabels)+1)
+		for name, value := range s.config.Metrics.ConstLabels {
+			labels[name] = value
+		}
+		if s.config.Metrics.HostnameLabel {
+			hostname, err := os.Hostname()
+			if err != nil {
+				s.logger.Warn("Failed to get hostname for metric labels", zap.Error(err))
+			} else {
+				labels["hostname"] = hostname
+			}
+		}
+
 		collector := metrics.NewPrometheusCollector(
 			s.logger,
 			s.config.Metrics.Port,
 			s.config.Metrics.Path,
 			s.config.Metrics.CollectInterval,
+			metrics.PrometheusOptions{
+				Namespace:    s.config.Metrics.Namespace,
+				ConstLabels:  labels,
+				PerDirectory: s.config.Metrics.PerDirectory,
+				Pprof:        s.config.Metrics.Pprof,
+			},
 		)
 		s.logger.Info("Prometheus metrics collector initialized",
 			zap.Int("port", s.config.Metrics.Port),
@@ -418,7 +1510,7 @@ func (s *Service) createMetricsCollector() interfaces.MetricsCollector {
 }
 
 // createSyncEngine creates the sync engine
-func (s *Service) createSyncEngine() interfaces.SyncEngine {
+func (s *Service) createSyncEngine(ctx context.Context) interfaces.SyncEngine {
 	engine := engine.NewEngine(
 		s.provider,
 		s.watcher,
@@ -429,10 +1521,140 @@ func (s *Service) createSyncEngine() interfaces.SyncEngine {
 		s.config.Performance.RetryAttempts,
 		s.config.Performance.RetryDelay,
 	)
+	engine.SetTimeouts(s.config.Performance.TimeoutDuration, s.config.Performance.TransferTimeoutPerMB)
+	engine.SetStallDetection(s.config.Performance.MinTransferSpeed, s.config.Performance.StallTimeout)
+	engine.SetBandwidthLimit(s.config.Performance.BandwidthLimit)
+	engine.SetReadRateLimit(s.config.Performance.ReadRateLimit)
+	engine.SetRangedDownloads(s.config.Performance.DownloadChunkSize, s.config.Performance.DownloadParallelism)
+	engine.SetMultipart(s.config.Performance.MultipartThreshold, s.config.Performance.UploadChunkSize)
+	engine.SetConnectivityCheckInterval(s.config.Network.ConnectivityCheckInterval)
+	engine.SetClockSkewTolerance(s.config.Network.ClockSkewTolerance)
+	if s.config.Performance.AdaptiveConcurrency {
+		if err := engine.SetAdaptiveConcurrency(s.config.Performance.MinConcurrentTransfers); err != nil {
+			s.logger.Error("Failed to enable adaptive concurrency", zap.Error(err))
+		}
+	}
+	engine.SetScanRateLimit(s.config.Performance.ScanRateLimit)
+	engine.SetRescanInterval(s.config.Rescan.Interval)
+	engine.SetSummaryInterval(s.config.Logging.SummaryInterval)
+	engine.SetScanParallelism(s.config.Performance.ScanParallelism)
+	engine.SetDirectoryParallelism(s.config.Performance.DirectoryParallelism)
+	engine.SetResourceLimits(s.config.Performance.MemoryLimit, s.config.Performance.CPULimit)
+	if s.config.Performance.MemoryLimit > 0 {
+		// Let the garbage collector work harder before the budget is reached
+		debug.SetMemoryLimit(s.config.Performance.MemoryLimit)
+	}
+	engine.SetQuota(s.config.Quota.MaxBytes, s.config.Quota.MaxObjects, s.config.Quota.CheckInterval)
+	if s.config.Cost.Enabled {
+		engine.SetCostModel(s.costModel(), s.config.Cost.MonthlyBudget, s.config.Cost.BudgetAction == "pause")
+	}
+	engine.SetAuditLog(s.audit)
+	if s.journal != nil {
+		engine.SetEventJournal(s.journal)
+	}
+	engine.SetQuarantine(s.config.State.QuarantineAfter, s.config.State.QuarantineExpiry)
+	if err := engine.SetEventBacklog(s.config.State.BacklogMemory, s.config.State.BacklogPath); err != nil {
+		s.logger.Error("Failed to load held file events", zap.Error(err))
+	}
+	engine.SetManifests(s.config.Manifest.Enabled)
+	if maxKeyBytes := s.config.Keys.MaxBytes; maxKeyBytes > 0 {
+		// The provider prepends the prefix to every key
+		if prefix := strings.Trim(s.config.AWS.S3Prefix, "/"); prefix != "" {
+			maxKeyBytes -= len(prefix) + 1
+		}
+		engine.SetKeyLimit(maxKeyBytes, s.config.Keys.LongKeys)
+	}
+	if s.config.Glacier.Enabled {
+		engine.SetGlacierRestore(s.config.Glacier.Days, s.config.Glacier.Tier, s.config.Glacier.CheckInterval)
+	}
+	if chunking := s.config.Chunking; chunking.KeyFile != "" {
+		key, err := loadChunkKey(chunking.KeyFile)
+		if err != nil {
+			s.logger.Error("Chunked backups are unavailable", zap.Error(err))
+		} else {
+			params := chunker.Params{MinSize: chunking.MinSize, AvgSize: chunking.AvgSize, MaxSize: chunking.MaxSize}
+			engine.SetChunking(key, params, chunking.PackSize)
+		}
+	}
+	if s.config.Antivirus.Enabled {
+		scanning, err := s.malwareScanning(ctx)
+		if err != nil {
+			s.logger.Error("Malware scanning is unavailable", zap.Error(err))
+		} else {
+			engine.SetMalwareScanning(scanning)
+		}
+	}
+	if s.state != nil {
+		engine.SetStateStore(s.state)
+		engine.SetScrub(s.config.Scrub.Interval, s.config.Scrub.SampleSize)
+		engine.SetHydrationCache(s.config.Hydration.CacheSize)
+	}
+	if s.fanout != nil {
+		engine.SetMirrorRepair(s.fanout, s.config.Replication.RepairInterval)
+	}
+	if s.failover != nil {
+		engine.SetMirrorRepair(s.failover, s.config.Failover.CheckInterval)
+	}
 
 	s.logger.Info("Sync engine initialized",
 		zap.Int("max_concurrent_uploads", s.config.Performance.MaxConcurrentUploads),
-		zap.Int("max_concurrent_downloads", s.config.Performance.MaxConcurrentDownloads))
+		zap.Int("max_concurrent_downloads", s.config.Performance.MaxConcurrentDownloads),
+		zap.Duration("timeout", s.config.Performance.TimeoutDuration))
 
 	return engine
 }
+
+// malwareScanning creates the clamd client files are scanned with
+func (s *Service) malwareScanning(ctx context.Context) (engine.MalwareScanning, error) {
+	av := s.config.Antivirus
+	scanner, err := antivirus.NewClient(av.ClamdAddress, av.Timeout)
+	if err != nil {
+		return engine.MalwareScanning{}, fmt.Errorf("failed to create clamd client: %w", err)
+	}
+	if err := scanner.Ping(ctx); err != nil {
+		// Transfers fail and are retried until clamd is reachable
+		s.logger.Warn("clamd is not reachable",
+			zap.String("address", av.ClamdAddress),
+			zap.Error(err))
+	}
+	return engine.MalwareScanning{
+		Scanner:       scanner,
+		QuarantineDir: av.QuarantineDir,
+		Uploads:       av.ScanUploads,
+		Downloads:     av.ScanDownloads,
+		MaxSize:       av.MaxScanSize,
+	}, nil
+}
+
+// loadChunkKey reads the secret of the chunked backup format
+func loadChunkKey(path string) (*chunker.Key, error) {
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk key file: %w", err)
+	}
+	return chunker.NewKey(secret)
+}
+
+// costModel builds the cost model from configuration, filling unset
+// prices from the defaults for the configured storage class
+func (s *Service) costModel() engine.CostModel {
+	model := engine.DefaultCostModel(s.config.AWS.StorageClass)
+	cost := s.config.Cost
+
+	if cost.PutPer1000 > 0 {
+		model.PutPer1000 = cost.PutPer1000
+	}
+	if cost.GetPer1000 > 0 {
+		model.GetPer1000 = cost.GetPer1000
+	}
+	if cost.ListPer1000 > 0 {
+		model.ListPer1000 = cost.ListPer1000
+	}
+	if cost.StoragePerGBMonth > 0 {
+		model.StoragePerGBMonth = cost.StoragePerGBMonth
+	}
+	if cost.EgressPerGB > 0 {
+		model.EgressPerGB = cost.EgressPerGB
+	}
+	return model
+}
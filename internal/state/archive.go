@@ -0,0 +1,418 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package state
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archive layout: a header of archiveMagic, the format version and flags,
+// followed for encrypted archives by the key derivation salt, then a zstd
+// compressed tar stream of the exported files. Encrypted archives split
+// the compressed stream into frames sealed with AES-GCM, each prefixed by
+// its sealed length. Nonces count the frames and the last frame is marked
+// in its additional data, so frames cannot be reordered or cut off.
+const (
+	archiveMagic   = "CASTATE\x00"
+	archiveVersion = 1
+	flagEncrypted  = 1
+	saltSize       = 16
+	frameSize      = 64 << 10
+	// MinKeySize is the minimum length of the secret archives are
+	// encrypted with
+	MinKeySize = 32
+)
+
+// ErrArchiveKey is returned when an encrypted archive is opened without
+// the key it was written with, or it was modified
+var ErrArchiveKey = errors.New("state archive failed authentication, wrong key or corrupted archive")
+
+// ArchiveFile is one file of a state archive: the name it is stored under
+// and its path on this machine
+type ArchiveFile struct {
+	Name string
+	Path string
+}
+
+// Export writes the files that exist among files to w as a compressed
+// archive, encrypted with secret unless it is nil, and returns the names
+// of the files written
+func Export(w io.Writer, files []ArchiveFile, secret []byte) ([]string, error) {
+	header := []byte(archiveMagic)
+	header = append(header, archiveVersion, 0)
+	var aead cipher.AEAD
+	if secret != nil {
+		salt := make([]byte, saltSize)
+		rand.Read(salt)
+		var err error
+		if aead, err = archiveCipher(secret, salt); err != nil {
+			return nil, err
+		}
+		header[len(header)-1] = flagEncrypted
+		header = append(header, salt...)
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	var sealed *sealWriter
+	out := w
+	if aead != nil {
+		sealed = &sealWriter{w: w, aead: aead}
+		out = sealed
+	}
+	compressed, err := zstd.NewWriter(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compressor: %w", err)
+	}
+	archive := tar.NewWriter(compressed)
+
+	var written []string
+	for _, file := range files {
+		ok, err := addFile(archive, file)
+		if err != nil {
+			compressed.Close()
+			return nil, err
+		}
+		if ok {
+			written = append(written, file.Name)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		compressed.Close()
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := compressed.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if sealed != nil {
+		if err := sealed.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return written, nil
+}
+
+// addFile adds file to archive, reporting false when it does not exist
+func addFile(archive *tar.Writer, file ArchiveFile) (bool, error) {
+	if file.Path == "" {
+		return false, nil
+	}
+	source, err := os.Open(file.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", file.Path, err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat %s: %w", file.Path, err)
+	}
+
+	header := &tar.Header{
+		Name:    file.Name,
+		Mode:    0600,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return false, fmt.Errorf("failed to add %s to archive: %w", file.Name, err)
+	}
+	// A file growing while it is read, like an active audit log, is cut
+	// at the size it had when the export started
+	if _, err := io.CopyN(archive, source, info.Size()); err != nil {
+		return false, fmt.Errorf("failed to add %s to archive: %w", file.Name, err)
+	}
+	return true, nil
+}
+
+// Import restores the files of the archive read from r to the paths of
+// the matching entries of files, decrypting it with secret, and returns
+// the names of the files restored. Plain archives are refused when a
+// secret is given. Archived files with no entry, or one with an empty
+// path, are skipped. Every file is read and authenticated before any is
+// replaced.
+func Import(r io.Reader, files []ArchiveFile, secret []byte) ([]string, error) {
+	in := bufio.NewReader(r)
+	header := make([]byte, len(archiveMagic)+2)
+	if _, err := io.ReadFull(in, header); err != nil || string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("not a state archive")
+	}
+	if version := header[len(archiveMagic)]; version > archiveVersion {
+		return nil, fmt.Errorf("state archive version %d is newer than supported version %d", version, archiveVersion)
+	}
+
+	var body io.Reader = in
+	if header[len(archiveMagic)+1]&flagEncrypted != 0 {
+		if secret == nil {
+			return nil, fmt.Errorf("state archive is encrypted, a key file is required")
+		}
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(in, salt); err != nil {
+			return nil, fmt.Errorf("failed to read archive header: %w", err)
+		}
+		aead, err := archiveCipher(secret, salt)
+		if err != nil {
+			return nil, err
+		}
+		body = &openReader{r: in, aead: aead}
+	} else if secret != nil {
+		// Anyone can write a plain archive, so one given where an
+		// encrypted archive is expected may have been substituted
+		return nil, fmt.Errorf("state archive is not encrypted but a key file was given")
+	}
+
+	decompressed, err := zstd.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create decompressor: %w", err)
+	}
+	defer decompressed.Close()
+
+	paths := make(map[string]string, len(files))
+	for _, file := range files {
+		paths[file.Name] = file.Path
+	}
+	// Files are extracted next to their destination and only moved into
+	// place once the whole archive has been read
+	extracted := make(map[string]string)
+	defer func() {
+		for _, tmp := range extracted {
+			os.Remove(tmp)
+		}
+	}()
+	var names []string
+	archive := tar.NewReader(decompressed)
+	for {
+		entry, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		path := paths[entry.Name]
+		if path == "" || extracted[entry.Name] != "" {
+			continue
+		}
+		tmp, err := extract(archive, path)
+		if tmp != "" {
+			extracted[entry.Name] = tmp
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, entry.Name)
+	}
+	// Reading to the end authenticates the last frame
+	if _, err := io.Copy(io.Discard, decompressed); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	for _, name := range names {
+		if err := os.Rename(extracted[name], paths[name]); err != nil {
+			return nil, fmt.Errorf("failed to replace %s: %w", paths[name], err)
+		}
+	}
+	return names, nil
+}
+
+// extract writes the current archive entry to a temporary file in the
+// directory of path, returning the file's name
+func extract(archive io.Reader, path string) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	tmp, err := os.CreateTemp(dir, ".import-*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+
+	if _, err := io.Copy(tmp, archive); err != nil {
+		tmp.Close()
+		return tmp.Name(), fmt.Errorf("failed to extract %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return tmp.Name(), fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return tmp.Name(), fmt.Errorf("failed to close %s: %w", path, err)
+	}
+	return tmp.Name(), nil
+}
+
+// archiveCipher derives the archive encryption key from secret and salt
+func archiveCipher(secret, salt []byte) (cipher.AEAD, error) {
+	if len(secret) < MinKeySize {
+		return nil, fmt.Errorf("archive key must be at least %d bytes, got %d", MinKeySize, len(secret))
+	}
+	key, err := hkdf.Key(sha256.New, secret, salt, "cloudawsync state archive", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive archive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// frameNonce returns the nonce of frame number index
+func frameNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+// frameData is the additional data of a frame, marking the last one
+func frameData(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// sealWriter encrypts what is written to it in frames. Close seals the
+// last frame, which may be empty.
+type sealWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	buffer []byte
+	index  uint64
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(frameSize-len(s.buffer), len(p))
+		s.buffer = append(s.buffer, p[:n]...)
+		p = p[n:]
+		// A full frame is only sealed once more data follows, so the
+		// last frame is never empty unless the stream is
+		if len(s.buffer) == frameSize && len(p) > 0 {
+			if err := s.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the buffered data as the last frame
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+// seal writes the buffered data as one frame
+func (s *sealWriter) seal(last bool) error {
+	sealed := s.aead.Seal(nil, frameNonce(s.aead, s.index), s.buffer, frameData(last))
+	s.index++
+	s.buffer = s.buffer[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := s.w.Write(length[:]); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := s.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// openReader decrypts the frames written by a sealWriter, failing when
+// the stream ends before the last frame or continues after it
+type openReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	plain bytes.Reader
+	index uint64
+	done  bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for o.plain.Len() == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	return o.plain.Read(p)
+}
+
+// next reads and opens the next frame
+func (o *openReader) next() error {
+	var length [4]byte
+	if _, err := io.ReadFull(o.r, length[:]); err != nil {
+		return fmt.Errorf("state archive is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < uint32(o.aead.Overhead()) || size > frameSize+uint32(o.aead.Overhead()) {
+		return ErrArchiveKey
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(o.r, sealed); err != nil {
+		return fmt.Errorf("state archive is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	nonce := frameNonce(o.aead, o.index)
+	plain, err := o.aead.Open(nil, nonce, sealed, frameData(false))
+	if err != nil {
+		plain, err = o.aead.Open(nil, nonce, sealed, frameData(true))
+		if err != nil {
+			return ErrArchiveKey
+		}
+		o.done = true
+		var extra [1]byte
+		if _, err := io.ReadFull(o.r, extra[:]); err == nil {
+			return ErrArchiveKey
+		}
+	}
+	o.index++
+	o.plain.Reset(plain)
+	return nil
+}
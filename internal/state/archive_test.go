@@ -0,0 +1,224 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package state
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// archiveFixture holds files to export and the paths to import them to
+type archiveFixture struct {
+	source   []ArchiveFile
+	target   []ArchiveFile
+	contents map[string][]byte
+}
+
+// newArchiveFixture writes a small state file and an audit log spanning
+// several encrypted frames
+func newArchiveFixture(t *testing.T) *archiveFixture {
+	t.Helper()
+	audit := make([]byte, 3*frameSize+123)
+	rand.Read(audit)
+	f := &archiveFixture{contents: map[string][]byte{
+		"state.json": []byte(`{"objects":{}}`),
+		"audit.log":  audit,
+	}}
+
+	sourceDir, targetDir := t.TempDir(), t.TempDir()
+	for _, name := range []string{"state.json", "audit.log", "names.json"} {
+		f.source = append(f.source, ArchiveFile{Name: name, Path: filepath.Join(sourceDir, name)})
+		f.target = append(f.target, ArchiveFile{Name: name, Path: filepath.Join(targetDir, name)})
+		if data, ok := f.contents[name]; ok {
+			if err := os.WriteFile(filepath.Join(sourceDir, name), data, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return f
+}
+
+// export writes the fixture's files to an archive
+func (f *archiveFixture) export(t *testing.T, secret []byte) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	written, err := Export(&archive, f.source, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(written, []string{"state.json", "audit.log"}) {
+		t.Fatalf("exported %v, want the existing files", written)
+	}
+	return archive.Bytes()
+}
+
+// checkTarget fails unless every target file holds want, or does not
+// exist when want has no entry for it
+func (f *archiveFixture) checkTarget(t *testing.T, want map[string][]byte) {
+	t.Helper()
+	for _, file := range f.target {
+		data, err := os.ReadFile(file.Path)
+		expected, ok := want[file.Name]
+		switch {
+		case !ok && !errors.Is(err, os.ErrNotExist):
+			t.Errorf("%s exists, want it absent", file.Name)
+		case ok && err != nil:
+			t.Errorf("%s: %v", file.Name, err)
+		case ok && !bytes.Equal(data, expected):
+			t.Errorf("%s holds %d bytes differing from the %d expected", file.Name, len(data), len(expected))
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Dir(f.target[0].Path))
+	if len(entries) != len(want) {
+		t.Errorf("target directory holds %d files, want %d", len(entries), len(want))
+	}
+}
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, MinKeySize)
+	rand.Read(key)
+	return key
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		name := "plain"
+		if encrypted {
+			name = "encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			f := newArchiveFixture(t)
+			var secret []byte
+			if encrypted {
+				secret = testKey(t)
+			}
+			archive := f.export(t, secret)
+			if encrypted && bytes.Contains(archive, []byte("state.json")) {
+				t.Error("encrypted archive holds file names in the clear")
+			}
+
+			restored, err := Import(bytes.NewReader(archive), f.target, secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(restored, []string{"state.json", "audit.log"}) {
+				t.Errorf("restored %v", restored)
+			}
+			f.checkTarget(t, f.contents)
+		})
+	}
+}
+
+func TestArchiveImportSkipsUnmappedFiles(t *testing.T) {
+	f := newArchiveFixture(t)
+	archive := f.export(t, nil)
+
+	targets := []ArchiveFile{f.target[0], {Name: "audit.log"}}
+	restored, err := Import(bytes.NewReader(archive), targets, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(restored, []string{"state.json"}) {
+		t.Errorf("restored %v, want only the mapped file", restored)
+	}
+	f.checkTarget(t, map[string][]byte{"state.json": f.contents["state.json"]})
+}
+
+func TestArchiveImportKeyMismatch(t *testing.T) {
+	f := newArchiveFixture(t)
+	key := testKey(t)
+
+	tests := []struct {
+		name    string
+		archive []byte
+		secret  []byte
+		wantErr error
+	}{
+		{name: "wrong key", archive: f.export(t, key), secret: testKey(t), wantErr: ErrArchiveKey},
+		{name: "missing key", archive: f.export(t, key)},
+		{name: "plain archive with key", archive: f.export(t, nil), secret: key},
+		{name: "short key", archive: f.export(t, key), secret: key[:MinKeySize-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(bytes.NewReader(tt.archive), f.target, tt.secret)
+			if err == nil {
+				t.Fatal("import succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			f.checkTarget(t, nil)
+		})
+	}
+}
+
+func TestArchiveImportTampered(t *testing.T) {
+	f := newArchiveFixture(t)
+	key := testKey(t)
+	archive := f.export(t, key)
+	headerSize := len(archiveMagic) + 2 + saltSize
+	lastFrame := headerSize
+	for offset := headerSize; offset < len(archive); {
+		lastFrame = offset
+		offset += 4 + int(binary.BigEndian.Uint32(archive[offset:]))
+	}
+	if lastFrame == headerSize {
+		t.Fatal("archive has a single frame")
+	}
+
+	flip := func(offset int) []byte {
+		tampered := bytes.Clone(archive)
+		tampered[offset] ^= 0x01
+		return tampered
+	}
+	tests := []struct {
+		name     string
+		tampered []byte
+	}{
+		{name: "magic", tampered: flip(0)},
+		{name: "encryption flag", tampered: flip(len(archiveMagic) + 1)},
+		{name: "salt", tampered: flip(headerSize - 1)},
+		{name: "frame length", tampered: flip(headerSize + 3)},
+		{name: "first frame", tampered: flip(headerSize + 100)},
+		{name: "last frame", tampered: flip(len(archive) - 1)},
+		{name: "truncated header", tampered: archive[:headerSize-4]},
+		{name: "truncated frame", tampered: archive[:len(archive)-10]},
+		{name: "last frame dropped", tampered: archive[:lastFrame]},
+		{name: "trailing data", tampered: append(bytes.Clone(archive), 0, 0, 0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Import(bytes.NewReader(tt.tampered), f.target, key); err == nil {
+				t.Fatal("import of a modified archive succeeded")
+			}
+			f.checkTarget(t, nil)
+		})
+	}
+}
@@ -0,0 +1,261 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// journalCompactAfter is the number of records appended before a journal
+// that is mostly handled is rewritten with its pending events only
+const journalCompactAfter = 1000
+
+// JournaledEvent is a detected file change accepted for upload
+type JournaledEvent struct {
+	Path       string    `json:"path"`
+	Operation  string    `json:"operation"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// journalRecord is a line of the journal file. It either adds an event or
+// marks the events of a path detected up to HandledAt as handled.
+type journalRecord struct {
+	Event     *JournaledEvent `json:"event,omitempty"`
+	Handled   string          `json:"handled,omitempty"`
+	HandledAt time.Time       `json:"handled_at,omitzero"`
+}
+
+// Journal is an append-only log of accepted file events. An event is
+// written to disk before it is queued and stays pending until it has been
+// handled, so changes detected before a crash or restart are replayed.
+type Journal struct {
+	path      string
+	file      *os.File
+	pending   map[string]JournaledEvent // by path, latest event
+	records   int                       // lines in the file
+	replaying bool                      // pending events were handed out for replay
+	mutex     sync.Mutex
+}
+
+// OpenJournal loads the journal at path, creating it if it does not exist
+// yet. A record cut short by a crash is ignored.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	journal := &Journal{path: path, pending: make(map[string]JournaledEvent)}
+	if err := journal.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	journal.file = file
+	return journal, nil
+}
+
+// load reads the records of the journal file into the pending events
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		j.records++
+		j.apply(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return nil
+}
+
+// apply updates the pending events with a record
+func (j *Journal) apply(record journalRecord) {
+	if event := record.Event; event != nil {
+		if current, ok := j.pending[event.Path]; !ok || !current.DetectedAt.After(event.DetectedAt) {
+			j.pending[event.Path] = *event
+		}
+		return
+	}
+	if event, ok := j.pending[record.Handled]; ok && !event.DetectedAt.After(record.HandledAt) {
+		delete(j.pending, record.Handled)
+	}
+}
+
+// Append durably records an accepted event before it is queued
+func (j *Journal) Append(event JournaledEvent) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.write(journalRecord{Event: &event}); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.apply(journalRecord{Event: &event})
+	return nil
+}
+
+// Handled marks the events of path detected up to handledAt as handled,
+// typically the time the upload that covers them started reading the
+// file. Later events stay pending.
+func (j *Journal) Handled(path string, handledAt time.Time) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	event, ok := j.pending[path]
+	if !ok || event.DetectedAt.After(handledAt) {
+		return nil
+	}
+	delete(j.pending, path)
+
+	// A lost record only replays a handled event, so it is not synced
+	if err := j.write(journalRecord{Handled: path, HandledAt: handledAt}); err != nil {
+		return err
+	}
+	if !j.replaying && j.records > journalCompactAfter && j.records > 4*len(j.pending) {
+		return j.compact()
+	}
+	return nil
+}
+
+// Replay returns the pending events, oldest first, to be handled again.
+// They are no longer pending in memory but stay on disk until EndReplay,
+// so events that are not accepted again are dropped only once every
+// accepted one has been appended anew.
+func (j *Journal) Replay() []JournaledEvent {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	events := make([]JournaledEvent, 0, len(j.pending))
+	for _, event := range j.pending {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(a, b int) bool {
+		return events[a].DetectedAt.Before(events[b].DetectedAt)
+	})
+	j.pending = make(map[string]JournaledEvent)
+	j.replaying = true
+	return events
+}
+
+// EndReplay rewrites the journal with the events pending after a replay
+func (j *Journal) EndReplay() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.replaying = false
+	return j.compact()
+}
+
+// Pending returns the number of events not handled yet
+func (j *Journal) Pending() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.pending)
+}
+
+// write appends a record to the journal file
+func (j *Journal) write(record journalRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal record: %w", err)
+	}
+	j.records++
+	return nil
+}
+
+// compact replaces the journal file with one holding only the pending
+// events. The file is replaced atomically so a crash keeps either one.
+func (j *Journal) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".journal-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, event := range j.pending {
+		data, err := json.Marshal(journalRecord{Event: &event})
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode journal record: %w", err)
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return fmt.Errorf("failed to replace journal: %w", err)
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.file.Close()
+	j.file = file
+	j.records = len(j.pending)
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
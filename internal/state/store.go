@@ -0,0 +1,813 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storeVersion is the on-disk format version of the state file
+const storeVersion = 1
+
+// ObjectRecord describes a remote object as last written by the agent
+type ObjectRecord struct {
+	Key        string    `json:"key"`
+	LocalPath  string    `json:"local_path"`
+	Size       int64     `json:"size"`
+	MD5Hash    string    `json:"md5_hash"`
+	ModTime    time.Time `json:"mod_time"`
+	UploadedAt time.Time `json:"uploaded_at"`
+	VerifiedAt time.Time `json:"verified_at,omitempty"`
+	ArchivedAt time.Time `json:"archived_at,omitempty"` // local copy removed by archive mode
+	Filtered   bool      `json:"filtered,omitempty"`    // content filters changed the uploaded content
+	SourceSize int64     `json:"source_size,omitempty"` // local file size of filtered uploads
+
+	PartialHash string `json:"partial_hash,omitempty"` // sample size and hash of the size and ends of large files
+
+	RemoteModTime time.Time `json:"remote_mod_time,omitempty"` // last modified time of the object a read-only directory downloaded
+}
+
+// LocalSize returns the size of the local file the object was uploaded
+// from
+func (r ObjectRecord) LocalSize() int64 {
+	if r.Filtered {
+		return r.SourceSize
+	}
+	return r.Size
+}
+
+// HydratedFile describes an archived file downloaded on demand. It is
+// turned back into a stub when evicted from the hydration cache.
+type HydratedFile struct {
+	Path       string      `json:"path"`
+	Key        string      `json:"key"`
+	Size       int64       `json:"size"`
+	MD5Hash    string      `json:"md5_hash"`
+	ModTime    time.Time   `json:"mod_time"`
+	Mode       os.FileMode `json:"mode"`
+	HydratedAt time.Time   `json:"hydrated_at"`
+	AccessedAt time.Time   `json:"accessed_at"` // last hydrate request
+}
+
+// LinkGroup records the remote objects holding the hard links to one
+// local file. The content is uploaded for the first key only; the other
+// keys are copied from it.
+type LinkGroup struct {
+	ID      string    `json:"id"` // device and inode shared by the links
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	MD5Hash string    `json:"md5_hash"`
+	Keys    []string  `json:"keys"`
+}
+
+// PendingUpload is an upload queued while the storage service was
+// unreachable. It is kept until the upload succeeds so that queued changes
+// survive a restart during an outage.
+type PendingUpload struct {
+	LocalPath  string    `json:"local_path"`
+	RemotePath string    `json:"remote_path"`
+	RootPath   string    `json:"root_path"` // local path of the owning sync directory
+	QueuedAt   time.Time `json:"queued_at"`
+}
+
+// QuarantinedFile is a local file that failed to upload repeatedly and is
+// skipped by scans until RetryAfter, until it changes or until the entry is
+// cleared. A zero RetryAfter keeps the file quarantined until cleared.
+// Entries with an ETag are remote objects found infected when downloaded,
+// skipped until the object changes or the entry is cleared.
+type QuarantinedFile struct {
+	LocalPath     string    `json:"local_path"`
+	RemotePath    string    `json:"remote_path,omitempty"`
+	ETag          string    `json:"etag,omitempty"`
+	Reason        string    `json:"reason"` // error of the last failed transfer
+	Failures      int       `json:"failures"`
+	Size          int64     `json:"size"`
+	ModTime       time.Time `json:"mod_time"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+	RetryAfter    time.Time `json:"retry_after,omitempty"`
+}
+
+// MirrorGap is an object that some backends of a fanout or failover
+// provider are missing, either because an upload to them failed or because
+// they were unreachable. It is kept until the object has been copied to
+// them. Deleted gaps are objects deleted while the backends were missed,
+// which still have to be deleted from them.
+type MirrorGap struct {
+	Key       string            `json:"key"`
+	Missing   []string          `json:"missing"`           // backend names
+	Deleted   bool              `json:"deleted,omitempty"` // remove the object instead of copying it
+	Errors    map[string]string `json:"errors,omitempty"`  // last error by backend
+	Since     time.Time         `json:"since"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// SyncRun describes one sync of a directory and what it changed. The
+// transfers a run queued are counted as they finish, which can be after
+// its scan ended.
+type SyncRun struct {
+	ID           string        `json:"id"` // the sync ID logged with every step of the run
+	Directory    string        `json:"directory"`
+	StartedAt    time.Time     `json:"started_at"`
+	ScanDuration time.Duration `json:"scan_duration"`         // zero while the scan runs
+	FinishedAt   time.Time     `json:"finished_at,omitempty"` // end of the scan or of its last transfer
+	Error        string        `json:"error,omitempty"`       // error that ended the scan
+
+	FilesScanned    int64 `json:"files_scanned"`
+	FilesSkipped    int64 `json:"files_skipped"` // scanned and left as they were
+	FilesQueued     int64 `json:"files_queued"`
+	FilesUploaded   int64 `json:"files_uploaded"`
+	BytesUploaded   int64 `json:"bytes_uploaded"`
+	FilesDownloaded int64 `json:"files_downloaded"`
+	BytesDownloaded int64 `json:"bytes_downloaded"`
+	FilesDeleted    int64 `json:"files_deleted"`
+	Errors          int64 `json:"errors"` // failed transfers
+}
+
+// Duration returns the time from the start of the run to the end of its
+// scan or last transfer, zero while the scan runs
+func (r SyncRun) Duration() time.Duration {
+	if r.FinishedAt.IsZero() {
+		return 0
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// CostCounters holds the requests and egress counted for one cost scope
+// in a billing month, so estimates survive a restart
+type CostCounters struct {
+	Scope        string     `json:"scope"`
+	Year         int        `json:"year"`
+	Month        time.Month `json:"month"`
+	PutRequests  int64      `json:"put_requests"`
+	GetRequests  int64      `json:"get_requests"`
+	ListRequests int64      `json:"list_requests"`
+	EgressBytes  int64      `json:"egress_bytes"`
+	StorageBytes int64      `json:"storage_bytes"`
+}
+
+// stateFile is the serialized form of a Store
+type stateFile struct {
+	Version  int                       `json:"version"`
+	Objects  map[string]*ObjectRecord  `json:"objects"`
+	Unseen   map[string]time.Time      `json:"unseen,omitempty"`
+	Hydrated map[string]*HydratedFile  `json:"hydrated,omitempty"`
+	Links    map[string]*LinkGroup     `json:"links,omitempty"`
+	Pending  map[string]*PendingUpload `json:"pending,omitempty"`
+
+	Quarantine map[string]*QuarantinedFile `json:"quarantine,omitempty"`
+	MirrorGaps map[string]*MirrorGap       `json:"mirror_gaps,omitempty"`
+	Runs       map[string][]*SyncRun       `json:"runs,omitempty"`
+	Costs      map[string]*CostCounters    `json:"costs,omitempty"`
+}
+
+// Store is a persistent index of objects uploaded by the agent, keyed by
+// remote key. Changes are kept in memory until Save is called.
+type Store struct {
+	path       string
+	objects    map[string]*ObjectRecord
+	unseen     map[string]time.Time // remote keys with no local file, by first detection
+	hydrated   map[string]*HydratedFile
+	links      map[string]*LinkGroup       // by LinkGroup.ID
+	linkOf     map[string]string           // remote key to link group ID
+	pending    map[string]*PendingUpload   // by local path
+	quarantine map[string]*QuarantinedFile // by local path
+	mirrorGaps map[string]*MirrorGap       // by remote key
+	runs       map[string][]*SyncRun       // by directory, oldest first
+	costs      map[string]*CostCounters    // by scope
+	dirty      bool
+	mutex      sync.RWMutex
+}
+
+// Open loads the state file at path, creating an empty store if the file
+// does not exist yet
+func Open(path string) (*Store, error) {
+	store := &Store{
+		path:       path,
+		objects:    make(map[string]*ObjectRecord),
+		unseen:     make(map[string]time.Time),
+		hydrated:   make(map[string]*HydratedFile),
+		links:      make(map[string]*LinkGroup),
+		linkOf:     make(map[string]string),
+		pending:    make(map[string]*PendingUpload),
+		quarantine: make(map[string]*QuarantinedFile),
+		mirrorGaps: make(map[string]*MirrorGap),
+		runs:       make(map[string][]*SyncRun),
+		costs:      make(map[string]*CostCounters),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	if file.Version > storeVersion {
+		return nil, fmt.Errorf("state file version %d is newer than supported version %d", file.Version, storeVersion)
+	}
+	if file.Objects != nil {
+		store.objects = file.Objects
+	}
+	if file.Unseen != nil {
+		store.unseen = file.Unseen
+	}
+	if file.Hydrated != nil {
+		store.hydrated = file.Hydrated
+	}
+	if file.Links != nil {
+		store.links = file.Links
+	}
+	if file.Pending != nil {
+		store.pending = file.Pending
+	}
+	if file.Quarantine != nil {
+		store.quarantine = file.Quarantine
+	}
+	if file.MirrorGaps != nil {
+		store.mirrorGaps = file.MirrorGaps
+	}
+	if file.Runs != nil {
+		store.runs = file.Runs
+	}
+	if file.Costs != nil {
+		store.costs = file.Costs
+	}
+	for id, group := range store.links {
+		for _, key := range group.Keys {
+			store.linkOf[key] = id
+		}
+	}
+
+	return store, nil
+}
+
+// Path returns the file backing the store
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Get returns the record for a remote key
+func (s *Store) Get(key string) (ObjectRecord, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, ok := s.objects[key]
+	if !ok {
+		return ObjectRecord{}, false
+	}
+	return *record, true
+}
+
+// Put adds or replaces the record for record.Key
+func (s *Store) Put(record ObjectRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.objects[record.Key] = &record
+	s.dirty = true
+}
+
+// Delete removes the record for a remote key
+func (s *Store) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, recorded := s.objects[key]
+	_, unseen := s.unseen[key]
+	if recorded || unseen {
+		delete(s.objects, key)
+		delete(s.unseen, key)
+		s.dirty = true
+	}
+	s.unlink(key)
+}
+
+// MarkVerified records the time an object was last confirmed intact
+func (s *Store) MarkVerified(key string, at time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if record, ok := s.objects[key]; ok {
+		record.VerifiedAt = at
+		s.dirty = true
+	}
+}
+
+// MarkUnseen records that a remote key has no local counterpart and
+// returns the time this was first observed
+func (s *Store) MarkUnseen(key string, now time.Time) time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if since, ok := s.unseen[key]; ok {
+		return since
+	}
+	s.unseen[key] = now
+	s.dirty = true
+	return now
+}
+
+// ClearUnseen records that a remote key has a local counterpart again
+func (s *Store) ClearUnseen(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.unseen[key]; ok {
+		delete(s.unseen, key)
+		s.dirty = true
+	}
+}
+
+// PutHydrated records a file downloaded into the hydration cache
+func (s *Store) PutHydrated(file HydratedFile) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.hydrated[file.Path] = &file
+	s.dirty = true
+}
+
+// GetHydrated returns the hydration record for a local path
+func (s *Store) GetHydrated(path string) (HydratedFile, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	file, ok := s.hydrated[path]
+	if !ok {
+		return HydratedFile{}, false
+	}
+	return *file, true
+}
+
+// DeleteHydrated removes a file from the hydration cache records
+func (s *Store) DeleteHydrated(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.hydrated[path]; ok {
+		delete(s.hydrated, path)
+		s.dirty = true
+	}
+}
+
+// HydratedFiles returns a copy of every hydration record
+func (s *Store) HydratedFiles() []HydratedFile {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	files := make([]HydratedFile, 0, len(s.hydrated))
+	for _, file := range s.hydrated {
+		files = append(files, *file)
+	}
+	return files
+}
+
+// PutLink records that key holds a hard link to the file identified by id.
+// A group whose size, modification time or hash differ is restarted with
+// key as its only member, since the shared content has changed.
+func (s *Store) PutLink(id, key string, size int64, modTime time.Time, md5Hash string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.linkOf[key] != id {
+		s.unlink(key)
+	}
+
+	group, ok := s.links[id]
+	if !ok || group.Size != size || !group.ModTime.Equal(modTime) || group.MD5Hash != md5Hash {
+		if ok {
+			for _, member := range group.Keys {
+				delete(s.linkOf, member)
+			}
+		}
+		group = &LinkGroup{ID: id, Size: size, ModTime: modTime, MD5Hash: md5Hash}
+		s.links[id] = group
+	}
+	if s.linkOf[key] != id {
+		group.Keys = append(group.Keys, key)
+		s.linkOf[key] = id
+	}
+	s.dirty = true
+}
+
+// Unlink removes key from its link group, if any
+func (s *Store) Unlink(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unlink(key)
+}
+
+// unlink removes key from its link group. The caller holds s.mutex.
+func (s *Store) unlink(key string) {
+	id, ok := s.linkOf[key]
+	if !ok {
+		return
+	}
+	delete(s.linkOf, key)
+
+	group := s.links[id]
+	group.Keys = slices.DeleteFunc(group.Keys, func(member string) bool { return member == key })
+	if len(group.Keys) == 0 {
+		delete(s.links, id)
+	}
+	s.dirty = true
+}
+
+// GetLink returns the link group of the file identified by id
+func (s *Store) GetLink(id string) (LinkGroup, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	group, ok := s.links[id]
+	if !ok {
+		return LinkGroup{}, false
+	}
+	copied := *group
+	copied.Keys = slices.Clone(group.Keys)
+	return copied, true
+}
+
+// LinkGroups returns a copy of every link group with more than one member,
+// sorted by ID
+func (s *Store) LinkGroups() []LinkGroup {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var groups []LinkGroup
+	for _, group := range s.links {
+		if len(group.Keys) < 2 {
+			continue
+		}
+		copied := *group
+		copied.Keys = slices.Clone(group.Keys)
+		groups = append(groups, copied)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+	return groups
+}
+
+// PutPending records an upload waiting for connectivity
+func (s *Store) PutPending(upload PendingUpload) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending[upload.LocalPath] = &upload
+	s.dirty = true
+}
+
+// DeletePending forgets the pending upload of a local path
+func (s *Store) DeletePending(path string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.pending[path]; ok {
+		delete(s.pending, path)
+		s.dirty = true
+	}
+}
+
+// PendingUploads returns a copy of every pending upload sorted by the
+// time it was queued
+func (s *Store) PendingUploads() []PendingUpload {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	uploads := make([]PendingUpload, 0, len(s.pending))
+	for _, upload := range s.pending {
+		uploads = append(uploads, *upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].QueuedAt.Before(uploads[j].QueuedAt)
+	})
+	return uploads
+}
+
+// PutQuarantined records a quarantined file
+func (s *Store) PutQuarantined(file QuarantinedFile) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.quarantine[file.LocalPath] = &file
+	s.dirty = true
+}
+
+// Quarantined returns the quarantine entry of a local path
+func (s *Store) Quarantined(path string) (QuarantinedFile, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	file, ok := s.quarantine[path]
+	if !ok {
+		return QuarantinedFile{}, false
+	}
+	return *file, true
+}
+
+// DeleteQuarantined releases a local path from quarantine, reporting
+// whether it was quarantined
+func (s *Store) DeleteQuarantined(path string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.quarantine[path]; !ok {
+		return false
+	}
+	delete(s.quarantine, path)
+	s.dirty = true
+	return true
+}
+
+// QuarantinedFiles returns a copy of every quarantine entry sorted by
+// local path
+func (s *Store) QuarantinedFiles() []QuarantinedFile {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	files := make([]QuarantinedFile, 0, len(s.quarantine))
+	for _, file := range s.quarantine {
+		files = append(files, *file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].LocalPath < files[j].LocalPath
+	})
+	return files
+}
+
+// PutMirrorGap records the backends missing an object
+func (s *Store) PutMirrorGap(gap MirrorGap) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.mirrorGaps[gap.Key] = &gap
+	s.dirty = true
+}
+
+// MirrorGap returns the backends missing the object at key
+func (s *Store) MirrorGap(key string) (MirrorGap, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	gap, ok := s.mirrorGaps[key]
+	if !ok {
+		return MirrorGap{}, false
+	}
+	return *gap, true
+}
+
+// DeleteMirrorGap records that every backend holds the object at key
+func (s *Store) DeleteMirrorGap(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.mirrorGaps[key]; ok {
+		delete(s.mirrorGaps, key)
+		s.dirty = true
+	}
+}
+
+// MirrorGaps returns a copy of every mirror gap sorted by key
+func (s *Store) MirrorGaps() []MirrorGap {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	gaps := make([]MirrorGap, 0, len(s.mirrorGaps))
+	for _, gap := range s.mirrorGaps {
+		gaps = append(gaps, *gap)
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i].Key < gaps[j].Key
+	})
+	return gaps
+}
+
+// PutRun adds or replaces the record of a sync run, keeping the newest
+// keep runs of its directory
+func (s *Store) PutRun(run SyncRun, keep int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	runs := s.runs[run.Directory]
+	replaced := false
+	for i, existing := range runs {
+		if existing.ID == run.ID {
+			runs[i] = &run
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		runs = append(runs, &run)
+	}
+	if len(runs) > keep {
+		runs = runs[len(runs)-keep:]
+	}
+	s.runs[run.Directory] = runs
+	s.dirty = true
+}
+
+// Runs returns a copy of every recorded sync run, oldest first within
+// each directory
+func (s *Store) Runs() []SyncRun {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var runs []SyncRun
+	for _, directory := range slices.Sorted(maps.Keys(s.runs)) {
+		for _, run := range s.runs[directory] {
+			runs = append(runs, *run)
+		}
+	}
+	return runs
+}
+
+// PutCost adds or replaces the cost counters of a scope
+func (s *Store) PutCost(counters CostCounters) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.costs[counters.Scope] = &counters
+	s.dirty = true
+}
+
+// Costs returns a copy of the cost counters of every scope sorted by scope
+func (s *Store) Costs() []CostCounters {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	costs := make([]CostCounters, 0, len(s.costs))
+	for _, scope := range slices.Sorted(maps.Keys(s.costs)) {
+		costs = append(costs, *s.costs[scope])
+	}
+	return costs
+}
+
+// Records returns a copy of every record sorted by key
+func (s *Store) Records() []ObjectRecord {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	records := make([]ObjectRecord, 0, len(s.objects))
+	for _, record := range s.objects {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
+
+// Len returns the number of records in the store
+func (s *Store) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.objects)
+}
+
+// RelocatePaths moves the records of from and every local path below it
+// to the same path below to, for state imported from a machine with a
+// different directory layout, and returns the number of records moved
+func (s *Store) RelocatePaths(from, to string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	relocate := func(path string) (string, bool) {
+		if path == from {
+			return to, true
+		}
+		if rest, ok := strings.CutPrefix(path, from); ok && strings.HasPrefix(rest, string(filepath.Separator)) {
+			return to + rest, true
+		}
+		return path, false
+	}
+
+	moved := 0
+	for _, record := range s.objects {
+		if path, ok := relocate(record.LocalPath); ok {
+			record.LocalPath = path
+			moved++
+		}
+	}
+	hydrated := make(map[string]*HydratedFile, len(s.hydrated))
+	for _, file := range s.hydrated {
+		if path, ok := relocate(file.Path); ok {
+			file.Path = path
+			moved++
+		}
+		hydrated[file.Path] = file
+	}
+	s.hydrated = hydrated
+	pending := make(map[string]*PendingUpload, len(s.pending))
+	for _, upload := range s.pending {
+		if path, ok := relocate(upload.LocalPath); ok {
+			upload.LocalPath = path
+			upload.RootPath, _ = relocate(upload.RootPath)
+			moved++
+		}
+		pending[upload.LocalPath] = upload
+	}
+	s.pending = pending
+	quarantine := make(map[string]*QuarantinedFile, len(s.quarantine))
+	for _, file := range s.quarantine {
+		if path, ok := relocate(file.LocalPath); ok {
+			file.LocalPath = path
+			moved++
+		}
+		quarantine[file.LocalPath] = file
+	}
+	s.quarantine = quarantine
+
+	if moved > 0 {
+		s.dirty = true
+	}
+	return moved
+}
+
+// Save writes the store to disk if it has changed. The file is replaced
+// atomically so a crash never leaves a truncated state file.
+func (s *Store) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(stateFile{
+		Version:    storeVersion,
+		Objects:    s.objects,
+		Unseen:     s.unseen,
+		Hydrated:   s.hydrated,
+		Links:      s.links,
+		Pending:    s.pending,
+		Quarantine: s.quarantine,
+		MirrorGaps: s.mirrorGaps,
+		Runs:       s.runs,
+		Costs:      s.costs,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".state-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
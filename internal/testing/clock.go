@@ -0,0 +1,145 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package testing
+
+import (
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// FakeClock is a Clock that only moves when Advance is called
+type FakeClock struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	mutex   sync.Mutex
+}
+
+// fakeWaiter is a pending After channel or ticker
+type fakeWaiter struct {
+	at      time.Time
+	period  time.Duration // zero for After
+	ch      chan time.Time
+	stopped bool
+}
+
+// NewFakeClock creates a clock standing at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// After returns a channel receiving the time once the clock has advanced
+// by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	waiter := &fakeWaiter{at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		waiter.ch <- c.now
+		return waiter.ch
+	}
+	c.waiters = append(c.waiters, waiter)
+	return waiter.ch
+}
+
+// NewTicker returns a ticker firing each time the clock passes another d
+func (c *FakeClock) NewTicker(d time.Duration) interfaces.Ticker {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	waiter := &fakeWaiter{at: c.now.Add(d), period: d, ch: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, waiter)
+	return &fakeTicker{clock: c, waiter: waiter}
+}
+
+// Advance moves the clock forward, firing due timers and tickers. Like
+// time.Ticker, a ticker whose channel is full drops ticks.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.stopped {
+			continue
+		}
+		if waiter.at.After(c.now) {
+			pending = append(pending, waiter)
+			continue
+		}
+
+		select {
+		case waiter.ch <- c.now:
+		default:
+		}
+		if waiter.period > 0 {
+			for !waiter.at.After(c.now) {
+				waiter.at = waiter.at.Add(waiter.period)
+			}
+			pending = append(pending, waiter)
+		}
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of pending After calls and running tickers,
+// letting tests wait until code under test is blocked on the clock
+func (c *FakeClock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := 0
+	for _, waiter := range c.waiters {
+		if !waiter.stopped {
+			count++
+		}
+	}
+	return count
+}
+
+// fakeTicker is a ticker driven by a FakeClock
+type fakeTicker struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+// C returns the tick channel
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.waiter.ch
+}
+
+// Stop turns off the ticker
+func (t *fakeTicker) Stop() {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	t.waiter.stopped = true
+}
@@ -0,0 +1,36 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package testing provides in-memory fakes of the sync engine's
+// dependencies so engine behavior can be tested without AWS, real time or
+// the host file system:
+//
+//	provider := testing.NewMemoryProvider()
+//	clock := testing.NewFakeClock(time.Unix(0, 0))
+//	fs := testing.NewMemoryFS(clock)
+//
+//	e := engine.NewEngine(provider, nil, metrics.NewSimpleCollector(zap.NewNop()), zap.NewNop(), 1, 1, 3, time.Second)
+//	e.SetClock(clock)
+//	e.SetFileSystem(fs)
+//
+// Import it under another name when the standard testing package is also
+// needed.
+package testing
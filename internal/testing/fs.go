@@ -0,0 +1,432 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package testing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+)
+
+// memoryNode is a file or directory of a MemoryFS
+type memoryNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// MemoryFS is a FileSystem held in memory. Paths are cleaned before use
+// and the root directory always exists.
+type MemoryFS struct {
+	clock interfaces.Clock
+	nodes map[string]*memoryNode
+	temps int // files created by CreateTemp, numbering their names
+	mutex sync.RWMutex
+}
+
+// NewMemoryFS creates an empty file system stamping modification times
+// from clock, or from the system clock when clock is nil
+func NewMemoryFS(clock interfaces.Clock) *MemoryFS {
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+	m := &MemoryFS{clock: clock, nodes: make(map[string]*memoryNode)}
+	m.nodes[string(filepath.Separator)] = &memoryNode{mode: fs.ModeDir | 0755, modTime: clock.Now()}
+	return m
+}
+
+// WriteFile stores data at name with the given modification time,
+// creating parent directories
+func (m *MemoryFS) WriteFile(name string, data []byte, modTime time.Time) error {
+	name = filepath.Clean(name)
+	if err := m.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if node, ok := m.nodes[name]; ok && node.mode.IsDir() {
+		return pathError("write", name, fs.ErrExist)
+	}
+	m.nodes[name] = &memoryNode{data: bytes.Clone(data), mode: 0644, modTime: modTime}
+	return nil
+}
+
+// ReadFile returns the content of name
+func (m *MemoryFS) ReadFile(name string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	node, ok := m.nodes[filepath.Clean(name)]
+	if !ok {
+		return nil, pathError("read", name, fs.ErrNotExist)
+	}
+	if node.mode.IsDir() {
+		return nil, pathError("read", name, errors.New("is a directory"))
+	}
+	return bytes.Clone(node.data), nil
+}
+
+// Open opens a file for reading
+func (m *MemoryFS) Open(name string) (interfaces.File, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+	return &memoryFile{fs: m, name: name, node: node}, nil
+}
+
+// Create creates or truncates a file for writing. The parent directory
+// must exist.
+func (m *MemoryFS) Create(name string) (interfaces.File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = filepath.Clean(name)
+	if parent, ok := m.nodes[filepath.Dir(name)]; !ok || !parent.mode.IsDir() {
+		return nil, pathError("open", name, fs.ErrNotExist)
+	}
+
+	node, ok := m.nodes[name]
+	switch {
+	case ok && node.mode.IsDir():
+		return nil, pathError("open", name, errors.New("is a directory"))
+	case ok:
+		node.data = nil
+		node.modTime = m.clock.Now()
+	default:
+		node = &memoryNode{mode: 0644, modTime: m.clock.Now()}
+		m.nodes[name] = node
+	}
+	return &memoryFile{fs: m, name: name, node: node, writable: true}, nil
+}
+
+// CreateTemp creates a new file in dir, replacing the last "*" of pattern
+// with a sequence number. The parent directory must exist.
+func (m *MemoryFS) CreateTemp(dir, pattern string) (interfaces.File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dir = filepath.Clean(dir)
+	if parent, ok := m.nodes[dir]; !ok || !parent.mode.IsDir() {
+		return nil, pathError("createtemp", dir, fs.ErrNotExist)
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for {
+		m.temps++
+		name := filepath.Join(dir, prefix+strconv.Itoa(m.temps)+suffix)
+		if _, ok := m.nodes[name]; ok {
+			continue
+		}
+		node := &memoryNode{mode: 0644, modTime: m.clock.Now()}
+		m.nodes[name] = node
+		return &memoryFile{fs: m, name: name, node: node, writable: true}, nil
+	}
+}
+
+// Stat returns file information
+func (m *MemoryFS) Stat(name string) (os.FileInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("stat", name, fs.ErrNotExist)
+	}
+	return node.info(name), nil
+}
+
+// Lstat returns file information. MemoryFS has no symbolic links.
+func (m *MemoryFS) Lstat(name string) (os.FileInfo, error) {
+	return m.Stat(name)
+}
+
+// ReadDir returns the entries of a directory sorted by name
+func (m *MemoryFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return nil, pathError("readdir", name, fs.ErrNotExist)
+	}
+	if !node.mode.IsDir() {
+		return nil, pathError("readdir", name, errors.New("not a directory"))
+	}
+
+	var entries []fs.DirEntry
+	for path, child := range m.nodes {
+		if path != name && filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(child.info(path)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// MkdirAll creates a directory and any missing parents
+func (m *MemoryFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if node, ok := m.nodes[dir]; ok {
+			if !node.mode.IsDir() {
+				return pathError("mkdir", dir, errors.New("not a directory"))
+			}
+			break
+		}
+		m.nodes[dir] = &memoryNode{mode: fs.ModeDir | perm, modTime: m.clock.Now()}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	return nil
+}
+
+// Remove removes a file or empty directory
+func (m *MemoryFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = filepath.Clean(name)
+	node, ok := m.nodes[name]
+	if !ok {
+		return pathError("remove", name, fs.ErrNotExist)
+	}
+	if node.mode.IsDir() && m.hasChildren(name) {
+		return pathError("remove", name, errors.New("directory not empty"))
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+// Rename moves a file or directory tree
+func (m *MemoryFS) Rename(oldpath, newpath string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	node, ok := m.nodes[oldpath]
+	if !ok {
+		return pathError("rename", oldpath, fs.ErrNotExist)
+	}
+	if parent, ok := m.nodes[filepath.Dir(newpath)]; !ok || !parent.mode.IsDir() {
+		return pathError("rename", newpath, fs.ErrNotExist)
+	}
+
+	if node.mode.IsDir() {
+		prefix := oldpath + string(filepath.Separator)
+		for path, child := range m.nodes {
+			if strings.HasPrefix(path, prefix) {
+				delete(m.nodes, path)
+				m.nodes[newpath+path[len(oldpath):]] = child
+			}
+		}
+	}
+	delete(m.nodes, oldpath)
+	m.nodes[newpath] = node
+	return nil
+}
+
+// Chtimes changes the modification time of a file. Access times are not
+// tracked.
+func (m *MemoryFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, ok := m.nodes[filepath.Clean(name)]
+	if !ok {
+		return pathError("chtimes", name, fs.ErrNotExist)
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// Chmod changes the permission bits of a file, keeping its type
+func (m *MemoryFS) Chmod(name string, mode os.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	node, ok := m.nodes[filepath.Clean(name)]
+	if !ok {
+		return pathError("chmod", name, fs.ErrNotExist)
+	}
+	node.mode = node.mode.Type() | mode.Perm()
+	return nil
+}
+
+// hasChildren reports whether a directory has entries. The caller holds
+// m.mutex.
+func (m *MemoryFS) hasChildren(dir string) bool {
+	for path := range m.nodes {
+		if path != dir && filepath.Dir(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// info describes the node stored at path
+func (n *memoryNode) info(path string) os.FileInfo {
+	return memoryInfo{
+		name:    filepath.Base(path),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+// memoryFile is an open MemoryFS file
+type memoryFile struct {
+	fs       *MemoryFS
+	name     string
+	node     *memoryNode
+	offset   int64
+	writable bool
+	closed   bool
+}
+
+// Read reads from the current offset
+func (f *memoryFile) Read(p []byte) (int, error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+
+	if f.closed {
+		return 0, pathError("read", f.name, fs.ErrClosed)
+	}
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// Write writes at the current offset, extending the file as needed
+func (f *memoryFile) Write(p []byte) (int, error) {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.closed {
+		return 0, pathError("write", f.name, fs.ErrClosed)
+	}
+	if !f.writable {
+		return 0, pathError("write", f.name, fs.ErrPermission)
+	}
+
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[f.offset:], p)
+	f.offset = end
+	f.node.modTime = f.fs.clock.Now()
+	return len(p), nil
+}
+
+// Seek sets the offset for the next Read or Write
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.node.data))
+	default:
+		return 0, pathError("seek", f.name, fs.ErrInvalid)
+	}
+	if offset < 0 {
+		return 0, pathError("seek", f.name, fs.ErrInvalid)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close closes the file
+func (f *memoryFile) Close() error {
+	f.fs.mutex.Lock()
+	defer f.fs.mutex.Unlock()
+
+	if f.closed {
+		return pathError("close", f.name, fs.ErrClosed)
+	}
+	f.closed = true
+	return nil
+}
+
+// Name returns the name the file was opened with
+func (f *memoryFile) Name() string {
+	return f.name
+}
+
+// Stat describes the open file
+func (f *memoryFile) Stat() (os.FileInfo, error) {
+	f.fs.mutex.RLock()
+	defer f.fs.mutex.RUnlock()
+	return f.node.info(f.name), nil
+}
+
+// memoryInfo implements os.FileInfo for MemoryFS nodes
+type memoryInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memoryInfo) Name() string       { return i.name }
+func (i memoryInfo) Size() int64        { return i.size }
+func (i memoryInfo) Mode() os.FileMode  { return i.mode }
+func (i memoryInfo) ModTime() time.Time { return i.modTime }
+func (i memoryInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memoryInfo) Sys() interface{}   { return nil }
+
+// pathError wraps err like the os package does
+func pathError(op, path string, err error) error {
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
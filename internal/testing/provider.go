@@ -0,0 +1,313 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package testing
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/utils"
+)
+
+// Provider operation names accepted by FailNext and Calls
+const (
+	OpUpload        = "upload"
+	OpDownload      = "download"
+	OpDownloadRange = "download_range"
+	OpDelete        = "delete"
+	OpCopy          = "copy"
+	OpList          = "list"
+	OpGetMetadata   = "get_metadata"
+	OpExists        = "exists"
+	OpUsage         = "storage_usage"
+)
+
+// memoryObject is an object held by a MemoryProvider
+type memoryObject struct {
+	data     []byte
+	metadata interfaces.FileMetadata
+}
+
+// MemoryProvider is a CloudProvider keeping objects in memory. Failures
+// can be injected per operation to exercise retry paths.
+type MemoryProvider struct {
+	objects  map[string]memoryObject
+	failures map[string][]error
+	calls    map[string]int
+	mutex    sync.Mutex
+}
+
+// NewMemoryProvider creates an empty in-memory provider
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{
+		objects:  make(map[string]memoryObject),
+		failures: make(map[string][]error),
+		calls:    make(map[string]int),
+	}
+}
+
+// Put stores an object directly, bypassing failure injection and call
+// counting
+func (p *MemoryProvider) Put(key string, data []byte, modTime time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.objects[key] = newMemoryObject(data, interfaces.FileMetadata{ModTime: modTime})
+}
+
+// Object returns the content stored under key
+func (p *MemoryProvider) Object(key string) ([]byte, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	object, ok := p.objects[key]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(object.data), true
+}
+
+// Keys returns the stored keys in sorted order
+func (p *MemoryProvider) Keys() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	keys := make([]string, 0, len(p.objects))
+	for key := range p.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FailNext makes the next calls of operation return errs, one per call
+func (p *MemoryProvider) FailNext(operation string, errs ...error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failures[operation] = append(p.failures[operation], errs...)
+}
+
+// Calls returns how often operation was called, including failed calls
+func (p *MemoryProvider) Calls(operation string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.calls[operation]
+}
+
+// begin counts a call and returns the injected failure for it, if any.
+// The caller holds p.mutex.
+func (p *MemoryProvider) begin(ctx context.Context, operation string) error {
+	p.calls[operation]++
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if queued := p.failures[operation]; len(queued) > 0 {
+		p.failures[operation] = queued[1:]
+		return queued[0]
+	}
+	return nil
+}
+
+// Upload stores the reader's content under key
+func (p *MemoryProvider) Upload(ctx context.Context, key string, reader io.Reader, metadata interfaces.FileMetadata, options interfaces.TransferOptions) error {
+	p.mutex.Lock()
+	err := p.begin(ctx, OpUpload)
+	p.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(utils.ProgressReader(reader, options.Progress))
+	if err != nil {
+		return fmt.Errorf("failed to read upload body: %w", err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	metadata.SourceModTime = metadata.ModTime
+	p.objects[key] = newMemoryObject(data, metadata)
+	return nil
+}
+
+// Download returns the content stored under key
+func (p *MemoryProvider) Download(ctx context.Context, key string, options interfaces.TransferOptions) (io.ReadCloser, interfaces.FileMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpDownload); err != nil {
+		return nil, interfaces.FileMetadata{}, err
+	}
+	object, ok := p.objects[key]
+	if !ok {
+		return nil, interfaces.FileMetadata{}, notFound(key)
+	}
+	body := io.NopCloser(bytes.NewReader(bytes.Clone(object.data)))
+	return utils.ProgressReadCloser(body, options.Progress), object.metadata, nil
+}
+
+// DownloadRange returns length bytes of the content stored under key
+// starting at offset
+func (p *MemoryProvider) DownloadRange(ctx context.Context, key string, offset, length int64, options interfaces.TransferOptions) (io.ReadCloser, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpDownloadRange); err != nil {
+		return nil, err
+	}
+	object, ok := p.objects[key]
+	if !ok {
+		return nil, notFound(key)
+	}
+	size := int64(len(object.data))
+	if offset < 0 || length <= 0 || offset >= size {
+		return nil, fmt.Errorf("invalid range %d+%d for %s of size %d", offset, length, key, size)
+	}
+	end := min(offset+length, size)
+	body := io.NopCloser(bytes.NewReader(bytes.Clone(object.data[offset:end])))
+	return utils.ProgressReadCloser(body, options.Progress), nil
+}
+
+// Delete removes key. Deleting a missing key succeeds, as with S3.
+func (p *MemoryProvider) Delete(ctx context.Context, key string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpDelete); err != nil {
+		return err
+	}
+	delete(p.objects, key)
+	return nil
+}
+
+// Copy duplicates the object at srcKey to dstKey
+func (p *MemoryProvider) Copy(ctx context.Context, srcKey, dstKey string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpCopy); err != nil {
+		return err
+	}
+	object, ok := p.objects[srcKey]
+	if !ok {
+		return notFound(srcKey)
+	}
+	p.objects[dstKey] = newMemoryObject(object.data, object.metadata)
+	return nil
+}
+
+// List returns the objects whose keys start with prefix, sorted by key
+func (p *MemoryProvider) List(ctx context.Context, prefix string) ([]interfaces.FileInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpList); err != nil {
+		return nil, err
+	}
+
+	var files []interfaces.FileInfo
+	for key, object := range p.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		files = append(files, interfaces.FileInfo{
+			Key:     key,
+			Size:    object.metadata.Size,
+			ModTime: object.metadata.ModTime,
+			MD5Hash: object.metadata.MD5Hash,
+
+			SourceModTime: object.metadata.SourceModTime,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	return files, nil
+}
+
+// GetMetadata returns the metadata stored with key
+func (p *MemoryProvider) GetMetadata(ctx context.Context, key string) (interfaces.FileMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpGetMetadata); err != nil {
+		return interfaces.FileMetadata{}, err
+	}
+	object, ok := p.objects[key]
+	if !ok {
+		return interfaces.FileMetadata{}, notFound(key)
+	}
+	return object.metadata, nil
+}
+
+// Exists reports whether key is stored
+func (p *MemoryProvider) Exists(ctx context.Context, key string) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpExists); err != nil {
+		return false, err
+	}
+	_, ok := p.objects[key]
+	return ok, nil
+}
+
+// StorageUsage totals the objects whose keys start with prefix
+func (p *MemoryProvider) StorageUsage(ctx context.Context, prefix string) (interfaces.StorageUsage, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.begin(ctx, OpUsage); err != nil {
+		return interfaces.StorageUsage{}, err
+	}
+
+	var usage interfaces.StorageUsage
+	for key, object := range p.objects {
+		if strings.HasPrefix(key, prefix) {
+			usage.Bytes += object.metadata.Size
+			usage.Objects++
+		}
+	}
+	return usage, nil
+}
+
+// newMemoryObject stores a copy of data with its size and hash filled in
+func newMemoryObject(data []byte, metadata interfaces.FileMetadata) memoryObject {
+	metadata.Size = int64(len(data))
+	metadata.MD5Hash = fmt.Sprintf("%x", md5.Sum(data))
+	if metadata.ModTime.IsZero() {
+		metadata.ModTime = time.Now()
+	}
+	return memoryObject{data: bytes.Clone(data), metadata: metadata}
+}
+
+// notFound is returned for operations on missing keys
+func notFound(key string) error {
+	return fmt.Errorf("object %s: %w", key, interfaces.ErrNotFound)
+}
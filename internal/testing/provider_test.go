@@ -0,0 +1,73 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package testing
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/providers"
+	"CloudAWSync/internal/providers/providertest"
+
+	"go.uber.org/zap"
+)
+
+func TestMemoryProviderConformance(t *testing.T) {
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		return NewMemoryProvider()
+	})
+}
+
+func TestObfuscatedProviderConformance(t *testing.T) {
+	nameCipher, err := providers.NewNameCipher(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		names, err := providers.OpenNameMap(filepath.Join(t.TempDir(), "names.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return providers.NewObfuscatedProvider(NewMemoryProvider(), nameCipher, names, zap.NewNop())
+	})
+}
+
+func TestFanoutProviderConformance(t *testing.T) {
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		return providers.NewFanoutProvider(
+			providers.Backend{Name: "primary", Provider: NewMemoryProvider()},
+			[]providers.Backend{{Name: "mirror", Provider: NewMemoryProvider()}},
+			zap.NewNop())
+	})
+}
+
+func TestFailoverProviderConformance(t *testing.T) {
+	providertest.Run(t, func(t *testing.T) interfaces.CloudProvider {
+		return providers.NewFailoverProvider(
+			providers.Backend{Name: "primary", Provider: NewMemoryProvider()},
+			providers.Backend{Name: "secondary", Provider: NewMemoryProvider()},
+			3, zap.NewNop())
+	})
+}
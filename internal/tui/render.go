@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package tui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"CloudAWSync/internal/utils"
+)
+
+// Limits keeping a frame within one screen
+const (
+	maxTransfers = 20
+	maxErrors    = 5
+	minWidth     = 60
+	amountsWidth = len("100% 1023.9 MB / 1023.9 MB")
+)
+
+// render writes one dashboard frame. Bandwidth is derived from the bytes
+// moved since previous, which is nil on the first frame.
+func render(w io.Writer, current, previous *sample, width int) {
+	width = max(width, minWidth)
+	stats, activity := current.stats, current.activity
+
+	lastSync := "never"
+	if !stats.LastSyncTime.IsZero() {
+		lastSync = current.at.Sub(stats.LastSyncTime).Truncate(time.Second).String() + " ago"
+	}
+	state := "running"
+	if stats.Paused {
+		state = "PAUSED"
+	}
+	if stats.Offline {
+		state = fmt.Sprintf("OFFLINE (%d queued)", stats.OfflineQueued)
+	}
+	fmt.Fprintf(w, "CloudAWSync - %s   %s   directories %d   last sync %s\n\n",
+		current.at.Format(time.TimeOnly), state, stats.ActiveDirectories, lastSync)
+
+	fmt.Fprintf(w, "Uploaded   %d files (%s)   Downloaded %d files (%s)   Deleted %d\n",
+		stats.FilesUploaded, utils.FormatBytes(stats.BytesUploaded),
+		stats.FilesDownloaded, utils.FormatBytes(stats.BytesDownloaded),
+		stats.FilesDeleted)
+	fmt.Fprintf(w, "Errors     %d   Stalls %d   Unreadable %d\n",
+		stats.SyncErrors, stats.TransferStalls, stats.UnreadableFiles)
+	if stats.ArchivedObjects > 0 || stats.RestoresPending > 0 {
+		fmt.Fprintf(w, "Archived   %d objects   %d restores pending\n",
+			stats.ArchivedObjects, stats.RestoresPending)
+	}
+	if stats.ClockSkew.Abs() >= time.Second {
+		fmt.Fprintf(w, "Clock skew %s relative to the storage service\n", stats.ClockSkew)
+	}
+	fmt.Fprintf(w, "Queued     %d uploads   %d downloads\n",
+		activity.QueuedUploads, activity.QueuedDownloads)
+	fmt.Fprintf(w, "Bandwidth  up %s   down %s\n\n",
+		rate(current, previous, func(a *sample) int64 { return a.activity.BytesSent }),
+		rate(current, previous, func(a *sample) int64 { return a.activity.BytesReceived }))
+
+	fmt.Fprintf(w, "ACTIVE TRANSFERS (%d)\n", len(activity.Transfers))
+	for i, transfer := range activity.Transfers {
+		if i == maxTransfers {
+			fmt.Fprintf(w, "  ... and %d more\n", len(activity.Transfers)-maxTransfers)
+			break
+		}
+		arrow := "↑"
+		path := transfer.LocalPath
+		if transfer.Direction == "download" {
+			arrow = "↓"
+			if path == "" {
+				path = transfer.RemotePath
+			}
+		}
+
+		var fraction float64
+		if transfer.Size > 0 {
+			fraction = min(float64(transfer.Transferred)/float64(transfer.Size), 1)
+		}
+		amounts := fmt.Sprintf("%3.0f%% %s / %s", fraction*100,
+			utils.FormatBytes(transfer.Transferred), utils.FormatBytes(transfer.Size))
+
+		// Split the space left after the arrow and amounts between path and bar
+		room := width - 4 - amountsWidth - 1
+		pathWidth := room * 3 / 5
+		barWidth := room - pathWidth - 3
+		fmt.Fprintf(w, "%s %s [%s] %s\n", arrow, padRight(truncateLeft(path, pathWidth), pathWidth),
+			progressBar(fraction, barWidth), amounts)
+	}
+	if len(activity.Transfers) == 0 {
+		fmt.Fprintln(w, "  idle")
+	}
+
+	errors := activity.RecentErrors
+	fmt.Fprintf(w, "\nRECENT ERRORS (%d)\n", len(errors))
+	if len(errors) > maxErrors {
+		errors = errors[len(errors)-maxErrors:]
+	}
+	for i := len(errors) - 1; i >= 0; i-- {
+		failure := errors[i]
+		path := failure.LocalPath
+		if path == "" {
+			path = failure.RemotePath
+		}
+		line := fmt.Sprintf("%s %-8s %s: %s", failure.Timestamp.Local().Format(time.TimeOnly),
+			failure.Direction, path, strings.ReplaceAll(failure.Error, "\n", " "))
+		fmt.Fprintln(w, truncateRight(line, width))
+	}
+	if len(errors) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+}
+
+// rate formats the bytes per second moved between two samples
+func rate(current, previous *sample, bytes func(*sample) int64) string {
+	if previous == nil {
+		return "-"
+	}
+	elapsed := current.at.Sub(previous.at).Seconds()
+	moved := bytes(current) - bytes(previous)
+	if elapsed <= 0 || moved < 0 {
+		// The agent restarted and its counters were reset
+		return "-"
+	}
+	return utils.FormatBytes(int64(float64(moved)/elapsed)) + "/s"
+}
+
+// progressBar draws a bar of width cells filled to fraction
+func progressBar(fraction float64, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	filled := int(fraction * float64(width))
+	return strings.Repeat("#", filled) + strings.Repeat("-", width-filled)
+}
+
+// truncateLeft shortens s to width runes, keeping its end, which for
+// paths is the most telling part
+func truncateLeft(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return "…" + string(runes[len(runes)-width+1:])
+}
+
+// padRight pads s with spaces to width runes
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", max(width-utf8.RuneCountInString(s), 0))
+}
+
+// truncateRight shortens s to width runes, keeping its start
+func truncateRight(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
@@ -0,0 +1,117 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+// Package tui renders a live, top-style view of a running agent from the
+// statistics and activity served by its control API.
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// ANSI sequences used to redraw the screen in place
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+)
+
+// Source provides the agent state shown by the dashboard. It is
+// implemented by control.Client.
+type Source interface {
+	Stats(ctx context.Context) (interfaces.SyncStats, error)
+	Activity(ctx context.Context) (interfaces.Activity, error)
+}
+
+// Options configures a dashboard
+type Options struct {
+	Interval time.Duration // refresh period
+	Width    func() int    // terminal width in columns, nil for 80
+}
+
+// Run redraws the dashboard every interval until ctx is done. Errors
+// reaching the agent are shown on screen and retried.
+func Run(ctx context.Context, source Source, out io.Writer, options Options) error {
+	if options.Interval <= 0 {
+		options.Interval = time.Second
+	}
+	if options.Width == nil {
+		options.Width = func() int { return 80 }
+	}
+
+	fmt.Fprint(out, hideCursor)
+	defer fmt.Fprint(out, showCursor)
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+
+	var previous *sample
+	for {
+		current, err := fetch(ctx, source)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var frame bytes.Buffer
+		frame.WriteString(clearScreen)
+		if err != nil {
+			fmt.Fprintf(&frame, "CloudAWSync - %s\n\nAgent unavailable: %v\n", time.Now().Format(time.TimeOnly), err)
+		} else {
+			render(&frame, current, previous, options.Width())
+			previous = current
+		}
+		if _, err := out.Write(frame.Bytes()); err != nil {
+			return fmt.Errorf("failed to draw dashboard: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample is the agent state read at one refresh
+type sample struct {
+	at       time.Time
+	stats    interfaces.SyncStats
+	activity interfaces.Activity
+}
+
+// fetch reads the statistics and activity from source
+func fetch(ctx context.Context, source Source) (*sample, error) {
+	stats, err := source.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	activity, err := source.Activity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sample{at: time.Now(), stats: stats, activity: activity}, nil
+}
@@ -0,0 +1,173 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxDedupeSeries bounds how many distinct messages are tracked at once
+const maxDedupeSeries = 1024
+
+// dedupeSeries counts the occurrences of one repeated message
+type dedupeSeries struct {
+	count      int
+	suppressed int
+	last       time.Time
+}
+
+// dedupeState is shared by a core and every core derived from it with With
+type dedupeState struct {
+	mutex  sync.Mutex
+	series map[string]*dedupeSeries
+}
+
+// DedupeCore wraps a core so that warnings and errors repeating the same
+// message and error are logged the first time and then only every Nth
+// time, carrying a count of the occurrences dropped in between
+type DedupeCore struct {
+	zapcore.Core
+	every  int
+	window time.Duration
+	state  *dedupeState
+}
+
+// NewDedupeCore wraps core, logging every nth repeat of an identical warning
+// or error seen again within window
+func NewDedupeCore(core zapcore.Core, every int, window time.Duration) *DedupeCore {
+	return &DedupeCore{
+		Core:   core,
+		every:  every,
+		window: window,
+		state:  &dedupeState{series: make(map[string]*dedupeSeries)},
+	}
+}
+
+// With returns a copy of the core with additional context fields
+func (d *DedupeCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *d
+	clone.Core = d.Core.With(fields)
+	return &clone
+}
+
+// Check routes warnings and errors through the core's Write so they can be
+// deduplicated, leaving lower levels to the wrapped core
+func (d *DedupeCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < zapcore.WarnLevel {
+		return d.Core.Check(entry, checked)
+	}
+	if d.Enabled(entry.Level) {
+		return checked.AddCore(entry, d)
+	}
+	return checked
+}
+
+// Write logs the entry unless it repeats a recent identical one that is
+// not yet due
+func (d *DedupeCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	errText := dedupeError(fields)
+	if errText == "" {
+		return d.Core.Write(entry, fields)
+	}
+	key := entry.Level.String() + "\x00" + entry.Message + "\x00" + errText
+
+	d.state.mutex.Lock()
+	series, ok := d.state.series[key]
+	if !ok || entry.Time.Sub(series.last) > d.window {
+		suppressed := 0
+		if ok {
+			suppressed = series.suppressed
+		}
+		if !ok && len(d.state.series) >= maxDedupeSeries {
+			d.pruneLocked(entry.Time)
+		}
+		series = &dedupeSeries{}
+		d.state.series[key] = series
+		series.suppressed = suppressed
+	}
+	series.count++
+	series.last = entry.Time
+	due := (series.count-1)%d.every == 0
+	repeated, suppressed := series.count, series.suppressed
+	if due {
+		series.suppressed = 0
+	} else {
+		series.suppressed++
+	}
+	d.state.mutex.Unlock()
+
+	if !due {
+		return nil
+	}
+	if repeated > 1 || suppressed > 0 {
+		fields = append(fields[:len(fields):len(fields)],
+			zap.Int("repeated", repeated),
+			zap.Int("suppressed", suppressed))
+	}
+	return d.Core.Write(entry, fields)
+}
+
+// pruneLocked forgets series that have not repeated within the window,
+// or all of them if every series is still active
+func (d *DedupeCore) pruneLocked(now time.Time) {
+	for key, series := range d.state.series {
+		if now.Sub(series.last) > d.window {
+			delete(d.state.series, key)
+		}
+	}
+	if len(d.state.series) >= maxDedupeSeries {
+		clear(d.state.series)
+	}
+}
+
+// dedupeError returns the error text of an entry with the entry's own
+// string values, such as the path, key or request ID it concerns, removed
+// so that the same failure on different files counts as identical
+func dedupeError(fields []zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		if field.Key == "error" || field.Type == zapcore.InlineMarshalerType {
+			field.AddTo(enc)
+		}
+	}
+	errText, _ := enc.Fields["error"].(string)
+	if errText == "" {
+		return ""
+	}
+
+	for key, value := range enc.Fields {
+		if text, ok := value.(string); ok && key != "error" && len(text) >= 3 {
+			errText = strings.ReplaceAll(errText, text, "")
+		}
+	}
+	for _, field := range fields {
+		if field.Type == zapcore.StringType && len(field.String) >= 3 {
+			errText = strings.ReplaceAll(errText, field.String, "")
+		}
+	}
+	return errText
+}
@@ -47,6 +47,11 @@ func CalculateMD5(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
+// CalculateMD5FromBytes calculates the MD5 hash of a byte slice
+func CalculateMD5FromBytes(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
 // CalculateSHA256 calculates the SHA256 hash of a file
 func CalculateSHA256(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -63,6 +68,40 @@ func CalculateSHA256(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
 }
 
+// CalculatePartialMD5 calculates the MD5 hash of the size of a file and
+// its first and last sample bytes. Files no larger than two samples are
+// hashed whole.
+func CalculatePartialMD5(filePath string, sample int64) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	size := info.Size()
+
+	hasher := md5.New()
+	fmt.Fprintf(hasher, "%d\n", size)
+	if size <= 2*sample {
+		if _, err := io.Copy(hasher, file); err != nil {
+			return "", err
+		}
+	} else {
+		if _, err := io.CopyN(hasher, file, sample); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(hasher, io.NewSectionReader(file, size-sample, sample)); err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
 // EnsureDir creates a directory if it doesn't exist
 func EnsureDir(dir string) error {
 	return os.MkdirAll(dir, 0755)
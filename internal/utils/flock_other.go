@@ -0,0 +1,36 @@
+//go:build !unix
+
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import "errors"
+
+// TryLockShared reports that file locking is unsupported on this platform
+func TryLockShared(fd uintptr) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// TryLockExclusive reports that file locking is unsupported on this platform
+func TryLockExclusive(fd uintptr) (bool, error) {
+	return false, errors.ErrUnsupported
+}
@@ -0,0 +1,114 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// OSFileSystem is the host file system
+type OSFileSystem struct{}
+
+// Open opens a file for reading
+func (OSFileSystem) Open(name string) (interfaces.File, error) {
+	return os.Open(name)
+}
+
+// Create creates or truncates a file for writing
+func (OSFileSystem) Create(name string) (interfaces.File, error) {
+	return os.Create(name)
+}
+
+// CreateTemp creates a new file for writing in dir, named after pattern
+// with its last "*" replaced by a random string. Unlike os.CreateTemp the
+// file gets the permissions os.Create would give it, and a symbolic link
+// planted at the chosen name is never followed.
+func (OSFileSystem) CreateTemp(dir, pattern string) (interfaces.File, error) {
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(rand.Uint64(), 36)+suffix)
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL|openNoFollow, 0666)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+}
+
+// Stat returns file information, following symbolic links
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// Lstat returns file information without following symbolic links
+func (OSFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+// ReadDir returns the entries of a directory sorted by name
+func (OSFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// MkdirAll creates a directory and any missing parents
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// Remove removes a file or empty directory
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// Rename moves a file
+func (OSFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// Chtimes changes the access and modification times of a file
+func (OSFileSystem) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// Chmod changes the permission bits of a file
+func (OSFileSystem) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+// Lchown changes the owner of a file without following symbolic links
+func (OSFileSystem) Lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
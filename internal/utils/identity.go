@@ -0,0 +1,103 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Identity is a user the agent acts as, with the groups it belongs to
+type Identity struct {
+	Name   string
+	UID    int
+	GID    int   // primary group, or the group named in the spec
+	Groups []int // supplementary groups
+}
+
+// LookupIdentity resolves a "user" or "user:group" spec, where either part
+// may be a name or a numeric ID
+func LookupIdentity(spec string) (Identity, error) {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if _, numErr := strconv.Atoi(userName); numErr != nil {
+			return Identity{}, fmt.Errorf("failed to look up user '%s': %w", userName, err)
+		}
+		if u, err = user.LookupId(userName); err != nil {
+			return Identity{}, fmt.Errorf("failed to look up user ID %s: %w", userName, err)
+		}
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return Identity{}, fmt.Errorf("user '%s' has no numeric ID on this platform", userName)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return Identity{}, fmt.Errorf("user '%s' has no numeric group ID on this platform", userName)
+	}
+
+	if hasGroup {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if _, numErr := strconv.Atoi(groupName); numErr != nil {
+				return Identity{}, fmt.Errorf("failed to look up group '%s': %w", groupName, err)
+			}
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return Identity{}, fmt.Errorf("failed to look up group ID %s: %w", groupName, err)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return Identity{}, fmt.Errorf("group '%s' has no numeric ID on this platform", groupName)
+		}
+	}
+
+	identity := Identity{Name: u.Username, UID: uid, GID: gid}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to list groups of user '%s': %w", userName, err)
+	}
+	for _, id := range groupIDs {
+		if n, err := strconv.Atoi(id); err == nil {
+			identity.Groups = append(identity.Groups, n)
+		}
+	}
+	return identity, nil
+}
+
+// inGroup reports whether gid is the identity's group or one of its
+// supplementary groups
+func (id Identity) inGroup(gid int) bool {
+	if gid == id.GID {
+		return true
+	}
+	for _, g := range id.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+//go:build !unix
+
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// DropPrivileges is not supported on this platform
+func DropPrivileges(id Identity) error {
+	return fmt.Errorf("dropping privileges is not supported on %s", runtime.GOOS)
+}
+
+// IsPrivileged reports false, as there is no root user on this platform
+func IsPrivileged() bool {
+	return false
+}
+
+// CanRead reports true, as permissions are not checked on this platform
+func CanRead(path string, info os.FileInfo, id Identity) bool {
+	return true
+}
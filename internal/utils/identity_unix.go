@@ -0,0 +1,91 @@
+//go:build unix
+
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// DropPrivileges switches every thread of the process to the identity's
+// user and groups. It cannot be undone.
+func DropPrivileges(id Identity) error {
+	groups := append([]int{id.GID}, id.Groups...)
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("failed to set supplementary groups: %w", err)
+	}
+	if err := syscall.Setgid(id.GID); err != nil {
+		return fmt.Errorf("failed to set group ID %d: %w", id.GID, err)
+	}
+	if err := syscall.Setuid(id.UID); err != nil {
+		return fmt.Errorf("failed to set user ID %d: %w", id.UID, err)
+	}
+	return nil
+}
+
+// IsPrivileged reports whether the process runs as root
+func IsPrivileged() bool {
+	return os.Geteuid() == 0
+}
+
+// CanRead reports whether the identity may read the file at path,
+// described by info, according to its owner, group and permission bits.
+// Every directory above the file must also let the identity search it.
+func CanRead(path string, info os.FileInfo, id Identity) bool {
+	if _, ok := info.Sys().(*syscall.Stat_t); !ok || id.UID == 0 {
+		return true
+	}
+	if !id.permits(info, 04) {
+		return false
+	}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err != nil || !id.permits(info, 01) {
+			return false
+		}
+		if dir == filepath.Dir(dir) {
+			return true
+		}
+	}
+}
+
+// permits reports whether the identity holds the permission bits of mask
+// (04 read, 02 write, 01 search) on the file described by info
+func (id Identity) permits(info os.FileInfo, mask os.FileMode) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return true
+	}
+	perm := info.Mode().Perm()
+	switch {
+	case int(stat.Uid) == id.UID:
+		return perm&(mask<<6) != 0
+	case id.inGroup(int(stat.Gid)):
+		return perm&(mask<<3) != 0
+	default:
+		return perm&mask != 0
+	}
+}
@@ -0,0 +1,267 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// journalSocket is the native protocol socket of systemd-journald
+const journalSocket = "/run/systemd/journal/socket"
+
+// journalFieldAliases maps log field keys to well-known journal field names
+// so that journalctl filtering works across components (e.g. SYNCDIR=/data)
+var journalFieldAliases = map[string]string{
+	"directory":  "SYNCDIR",
+	"sync_dir":   "SYNCDIR",
+	"local_path": "LOCAL_PATH",
+	"operation":  "OPERATION",
+}
+
+// journalReservedFields are the fields the core writes itself and those
+// the journal gives a meaning to. Log fields normalized to one of them are
+// prefixed like other invalid names, so they cannot replace the message
+// or the priority.
+var journalReservedFields = map[string]bool{
+	"MESSAGE":            true,
+	"MESSAGE_ID":         true,
+	"PRIORITY":           true,
+	"SYSLOG_IDENTIFIER":  true,
+	"SYSLOG_FACILITY":    true,
+	"SYSLOG_PID":         true,
+	"SYSLOG_TIMESTAMP":   true,
+	"CODE_FILE":          true,
+	"CODE_LINE":          true,
+	"CODE_FUNC":          true,
+	"ERRNO":              true,
+	"TID":                true,
+	"INVOCATION_ID":      true,
+	"USER_INVOCATION_ID": true,
+	"DOCUMENTATION":      true,
+	"LOGGER":             true,
+	"STACKTRACE":         true,
+}
+
+// JournaldCore is a zapcore.Core that writes entries to systemd-journald
+// using the native journal protocol, preserving structured fields
+type JournaldCore struct {
+	zapcore.LevelEnabler
+	conn       *net.UnixConn
+	addr       *net.UnixAddr
+	identifier string
+	fields     map[string]string
+	mutex      *sync.Mutex
+}
+
+// JournaldAvailable reports whether the journal socket is present
+func JournaldAvailable() bool {
+	_, err := os.Stat(journalSocket)
+	return err == nil
+}
+
+// NewJournaldCore creates a core that sends log entries to the journal
+func NewJournaldCore(level zapcore.LevelEnabler, identifier string) (*JournaldCore, error) {
+	return newJournaldCore(level, identifier, journalSocket)
+}
+
+// newJournaldCore creates a core sending entries to the socket at path
+func newJournaldCore(level zapcore.LevelEnabler, identifier, path string) (*JournaldCore, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal socket: %w", err)
+	}
+
+	return &JournaldCore{
+		LevelEnabler: level,
+		conn:         conn,
+		addr:         &net.UnixAddr{Name: path, Net: "unixgram"},
+		identifier:   identifier,
+		fields:       make(map[string]string),
+		mutex:        &sync.Mutex{},
+	}, nil
+}
+
+// With returns a copy of the core with additional context fields
+func (j *JournaldCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *j
+	clone.fields = make(map[string]string, len(j.fields)+len(fields))
+	for k, v := range j.fields {
+		clone.fields[k] = v
+	}
+	for k, v := range encodeJournalFields(fields) {
+		clone.fields[k] = v
+	}
+	return &clone
+}
+
+// Check adds the core to the checked entry if the level is enabled
+func (j *JournaldCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if j.Enabled(entry.Level) {
+		return checked.AddCore(entry, j)
+	}
+	return checked
+}
+
+// Write serializes the entry and sends it to the journal
+func (j *JournaldCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	var buf bytes.Buffer
+
+	writeJournalField(&buf, "MESSAGE", entry.Message)
+	writeJournalField(&buf, "PRIORITY", journalPriority(entry.Level))
+	writeJournalField(&buf, "SYSLOG_IDENTIFIER", j.identifier)
+	if entry.LoggerName != "" {
+		writeJournalField(&buf, "LOGGER", entry.LoggerName)
+	}
+	if entry.Caller.Defined {
+		writeJournalField(&buf, "CODE_FILE", entry.Caller.File)
+		writeJournalField(&buf, "CODE_LINE", fmt.Sprintf("%d", entry.Caller.Line))
+		writeJournalField(&buf, "CODE_FUNC", entry.Caller.Function)
+	}
+	if entry.Stack != "" {
+		writeJournalField(&buf, "STACKTRACE", entry.Stack)
+	}
+
+	merged := make(map[string]string, len(j.fields)+len(fields))
+	for k, v := range j.fields {
+		merged[k] = v
+	}
+	for k, v := range encodeJournalFields(fields) {
+		merged[k] = v
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeJournalField(&buf, k, merged[k])
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	_, err := j.conn.WriteToUnix(buf.Bytes(), j.addr)
+	if errors.Is(err, syscall.EMSGSIZE) {
+		// Too large for one datagram, such as an entry with a long stack
+		err = sendJournalFile(j.conn, j.addr, buf.Bytes())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write to journal: %w", err)
+	}
+	return nil
+}
+
+// Sync is a no-op since journal datagrams are not buffered
+func (j *JournaldCore) Sync() error {
+	return nil
+}
+
+// encodeJournalFields converts zap fields into journal field names and values
+func encodeJournalFields(fields []zapcore.Field) map[string]string {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+
+	result := make(map[string]string, len(enc.Fields))
+	for key, value := range enc.Fields {
+		result[journalFieldName(key)] = fmt.Sprint(value)
+	}
+	return result
+}
+
+// journalFieldName normalizes a key into a valid journal field name.
+// Journal field names may only contain uppercase letters, digits and
+// underscores and must not start with an underscore or a digit, and
+// reserved names are prefixed.
+func journalFieldName(key string) string {
+	if alias, ok := journalFieldAliases[key]; ok {
+		return alias
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	name = strings.TrimLeft(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') || journalReservedFields[name] {
+		name = "F_" + name
+	}
+	return name
+}
+
+// writeJournalField appends a field using the native journal protocol
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	// Multi-line values use the binary length-prefixed form
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalPriority maps zap levels to syslog priorities
+func journalPriority(level zapcore.Level) string {
+	switch level {
+	case zapcore.DebugLevel:
+		return "7"
+	case zapcore.InfoLevel:
+		return "6"
+	case zapcore.WarnLevel:
+		return "4"
+	case zapcore.ErrorLevel, zapcore.DPanicLevel:
+		return "3"
+	case zapcore.PanicLevel, zapcore.FatalLevel:
+		// crit rather than emerg, which many systems broadcast to every
+		// terminal
+		return "2"
+	default:
+		return "6"
+	}
+}
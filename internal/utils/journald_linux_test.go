@@ -0,0 +1,173 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sys/unix"
+)
+
+// fakeJournal receives entries sent with the native journal protocol
+type fakeJournal struct {
+	conn *net.UnixConn
+	core *JournaldCore
+}
+
+func newFakeJournal(t *testing.T) *fakeJournal {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	core, err := newJournaldCore(zapcore.DebugLevel, "cloudawsync-test", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { core.conn.Close() })
+	return &fakeJournal{conn: conn, core: core}
+}
+
+// receive reads the next entry, from a datagram or a passed memory file
+func (f *fakeJournal) receive(t *testing.T) map[string]string {
+	t.Helper()
+	f.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, 1<<20)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := f.conn.ReadMsgUnix(data, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = data[:n]
+	if oobn > 0 {
+		messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds, err := unix.ParseUnixRights(&messages[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := os.NewFile(uintptr(fds[0]), "entry")
+		defer file.Close()
+		seals, err := unix.FcntlInt(file.Fd(), unix.F_GET_SEALS, 0)
+		if err != nil || seals&unix.F_SEAL_WRITE == 0 {
+			t.Errorf("passed memory file is not sealed: %v", err)
+		}
+		// The journal maps the file, the offset is left where the sender
+		// finished writing
+		if data, err = io.ReadAll(io.NewSectionReader(file, 0, 1<<30)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return parseJournalEntry(t, data)
+}
+
+// parseJournalEntry decodes the native journal protocol
+func parseJournalEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			t.Fatalf("unterminated field %q", data)
+		}
+		line := string(data[:end])
+		data = data[end+1:]
+		if name, value, ok := strings.Cut(line, "="); ok {
+			fields[name] = value
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data)
+		fields[line] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournaldCoreReservedFields(t *testing.T) {
+	journal := newFakeJournal(t)
+	logger := zap.New(journal.core).With(zap.String("priority", "0"))
+	logger.Warn("upload failed",
+		zap.String("message", "forged"),
+		zap.String("_PID", "1"),
+		zap.String("local_path", "/data/a.txt"),
+		zap.String("remote path", "data/a.txt"))
+
+	fields := journal.receive(t)
+	want := map[string]string{
+		"MESSAGE":           "upload failed",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "cloudawsync-test",
+		"F_MESSAGE":         "forged",
+		"F_PRIORITY":        "0",
+		"PID":               "1",
+		"LOCAL_PATH":        "/data/a.txt",
+		"REMOTE_PATH":       "data/a.txt",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("%s = %q, want %q", name, fields[name], value)
+		}
+	}
+}
+
+func TestJournaldCoreStack(t *testing.T) {
+	journal := newFakeJournal(t)
+	logger := zap.New(journal.core, zap.AddStacktrace(zapcore.ErrorLevel))
+	logger.Error("sync failed")
+
+	fields := journal.receive(t)
+	if !strings.Contains(fields["STACKTRACE"], "TestJournaldCoreStack") {
+		t.Errorf("STACKTRACE = %q, want the caller's stack", fields["STACKTRACE"])
+	}
+}
+
+func TestJournaldCoreLargeEntry(t *testing.T) {
+	journal := newFakeJournal(t)
+	message := strings.Repeat("x", 4<<20)
+	if _, err := journal.core.conn.WriteToUnix([]byte(message), journal.core.addr); !errors.Is(err, unix.EMSGSIZE) {
+		t.Skipf("socket accepts %d byte datagrams: %v", len(message), err)
+	}
+
+	zap.New(journal.core).Info(message, zap.String("detail", "line one\nline two"))
+	fields := journal.receive(t)
+	if fields["MESSAGE"] != message {
+		t.Errorf("MESSAGE holds %d bytes, want %d", len(fields["MESSAGE"]), len(message))
+	}
+	if fields["DETAIL"] != "line one\nline two" {
+		t.Errorf("DETAIL = %q", fields["DETAIL"])
+	}
+}
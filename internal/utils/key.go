@@ -0,0 +1,184 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// MaxKeyBytes is the longest object key S3 accepts
+const MaxKeyBytes = 1024
+
+// LongKeySuffix ends every key shortened by ShortenKey, so readers know to
+// look up the original key in the object's metadata
+const LongKeySuffix = ".cloudawsync-long"
+
+// Schemes for keys longer than the key length limit
+const (
+	LongKeyHash     = "hash"     // replace the path below the directory with its hash
+	LongKeyTruncate = "truncate" // keep as much of the key as fits, followed by a hash
+	LongKeyFail     = "fail"     // skip the file and report an error
+)
+
+// ShortenKey returns a key of at most maxBytes standing in for key. prefix
+// is the part of key kept in every scheme, normally the remote directory,
+// so the shortened key still lists with the directory. The same key always
+// shortens to the same result.
+func ShortenKey(key, prefix string, maxBytes int, scheme string) string {
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+
+	if scheme == LongKeyTruncate {
+		tail := "~" + sum[:16] + LongKeySuffix
+		keep := maxBytes - len(tail)
+		if keep > len(prefix) {
+			// Cut at a character boundary so the key stays valid UTF-8
+			for keep > 0 && !utf8.RuneStart(key[keep]) {
+				keep--
+			}
+			return key[:keep] + tail
+		}
+	}
+	return prefix + sum + LongKeySuffix
+}
+
+// errNotReversible is returned for names whose encoded key would decode to
+// a different name
+var errNotReversible = errors.New("name cannot be encoded reversibly")
+
+// percentUnsafe lists the printable characters percent-encoded besides
+// control characters, which downstream tools commonly treat specially
+const percentUnsafe = `%\{}^[]<>#|"` + "`"
+
+// EncodeKey applies a key encoding to every segment of a slash-separated
+// key. It fails for names the encoding cannot turn back into the original.
+func EncodeKey(key string, encoding interfaces.KeyEncoding) (string, error) {
+	var encode func(string) string
+	switch encoding.Mode {
+	case interfaces.KeyEncodingNone:
+		return key, nil
+	case interfaces.KeyEncodingPercent:
+		encode = percentEncode
+	case interfaces.KeyEncodingReplace:
+		encode = func(segment string) string { return replaceEncode(segment, encoding.Replace) }
+	default:
+		return "", fmt.Errorf("unknown key encoding %q", encoding.Mode)
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = encode(segment)
+	}
+	encoded := strings.Join(segments, "/")
+
+	if decoded, err := DecodeKey(encoded, encoding); err != nil || decoded != key {
+		return "", fmt.Errorf("%w: %q", errNotReversible, key)
+	}
+	return encoded, nil
+}
+
+// DecodeKey reverses EncodeKey
+func DecodeKey(key string, encoding interfaces.KeyEncoding) (string, error) {
+	switch encoding.Mode {
+	case interfaces.KeyEncodingNone:
+		return key, nil
+	case interfaces.KeyEncodingPercent:
+		decoded, err := url.PathUnescape(key)
+		if err != nil {
+			return "", fmt.Errorf("failed to decode key %q: %w", key, err)
+		}
+		return decoded, nil
+	case interfaces.KeyEncodingReplace:
+		return replaceDecode(key, encoding.Replace), nil
+	default:
+		return "", fmt.Errorf("unknown key encoding %q", encoding.Mode)
+	}
+}
+
+// percentEncode encodes control characters, bytes that are not valid
+// UTF-8 and the characters in percentUnsafe as %XX
+func percentEncode(segment string) string {
+	var b strings.Builder
+	for i := 0; i < len(segment); {
+		r, size := utf8.DecodeRuneInString(segment[i:])
+		if (r == utf8.RuneError && size == 1) || unicode.IsControl(r) || strings.ContainsRune(percentUnsafe, r) {
+			for _, c := range []byte(segment[i : i+size]) {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		} else {
+			b.WriteString(segment[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// replaceEncode replaces the characters listed in replace
+func replaceEncode(segment string, replace map[string]string) string {
+	var b strings.Builder
+	for _, r := range segment {
+		if replacement, ok := replace[string(r)]; ok {
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// replaceDecode turns replacements back into the characters they stand
+// for, matching the longest replacement first
+func replaceDecode(key string, replace map[string]string) string {
+	replacements := make([]string, 0, len(replace))
+	originals := make(map[string]string, len(replace))
+	for original, replacement := range replace {
+		replacements = append(replacements, replacement)
+		originals[replacement] = original
+	}
+	sort.Slice(replacements, func(i, j int) bool { return len(replacements[i]) > len(replacements[j]) })
+
+	var b strings.Builder
+	for i := 0; i < len(key); {
+		matched := false
+		for _, replacement := range replacements {
+			if replacement != "" && strings.HasPrefix(key[i:], replacement) {
+				b.WriteString(originals[replacement])
+				i += len(replacement)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(key[i])
+			i++
+		}
+	}
+	return b.String()
+}
@@ -22,6 +22,7 @@ along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
 package utils
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
@@ -39,6 +40,18 @@ func InitLogger(cfg config.LoggingConfig) (*zap.Logger, error) {
 		level = zapcore.InfoLevel
 	}
 
+	// Journald writes structured fields natively and ignores the output path
+	if cfg.Format == "journald" {
+		if !JournaldAvailable() {
+			return nil, fmt.Errorf("journald format requested but %s is not available", journalSocket)
+		}
+		core, err := NewJournaldCore(level, "cloudawsync")
+		if err != nil {
+			return nil, err
+		}
+		return zap.New(dedupeLogs(core, cfg), zap.AddCaller(), zap.AddCallerSkip(1)), nil
+	}
+
 	// Configure encoder
 	var encoderConfig zapcore.EncoderConfig
 	if cfg.Format == "json" {
@@ -76,11 +89,20 @@ func InitLogger(cfg config.LoggingConfig) (*zap.Logger, error) {
 
 	// Create core and logger
 	core := zapcore.NewCore(encoder, writeSyncer, level)
-	logger := zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1))
+	logger := zap.New(dedupeLogs(core, cfg), zap.AddCaller(), zap.AddCallerSkip(1))
 
 	return logger, nil
 }
 
+// dedupeLogs wraps core with deduplication of repeated warnings and errors
+// when it is enabled
+func dedupeLogs(core zapcore.Core, cfg config.LoggingConfig) zapcore.Core {
+	if cfg.Dedupe.Every <= 0 {
+		return core
+	}
+	return NewDedupeCore(core, cfg.Dedupe.Every, cfg.Dedupe.Window)
+}
+
 // NewLogger creates a default logger for development
 func NewLogger() *zap.Logger {
 	config := zap.NewDevelopmentConfig()
@@ -0,0 +1,76 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// I/O scheduling classes understood by ioprio_set
+const (
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+var ioClasses = map[string]uintptr{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// SetProcessPriority sets the CPU nice level and I/O scheduling class of
+// every thread of this process. Threads started later inherit the
+// settings. A zero nice level or empty class leaves that setting alone;
+// level is the priority within the realtime and best-effort classes.
+func SetProcessPriority(nice int, ioClass string, level int) error {
+	class, ok := ioClasses[ioClass]
+	if ioClass != "" && !ok {
+		return fmt.Errorf("unknown I/O class %q", ioClass)
+	}
+
+	// Both settings apply to single threads on Linux
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("failed to list threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if nice != 0 {
+			if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, nice); err != nil {
+				return fmt.Errorf("failed to set nice level %d: %w", nice, err)
+			}
+		}
+		if ioClass != "" {
+			priority := class<<ioprioClassShift | uintptr(level)
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), priority); errno != 0 {
+				return fmt.Errorf("failed to set I/O class %s: %w", ioClass, errno)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"io"
+
+	"CloudAWSync/internal/interfaces"
+)
+
+// progressReader reports every read to a progress callback
+type progressReader struct {
+	reader   io.Reader
+	progress interfaces.ProgressFunc
+}
+
+// Read implements io.Reader
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.reader.Read(b)
+	if n > 0 {
+		p.progress(int64(n))
+	}
+	return n, err
+}
+
+// Seek implements io.Seeker when the underlying reader supports it, which
+// the S3 SDK requires to rewind request bodies on retry
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := p.reader.(io.Seeker)
+	if !ok {
+		return 0, fmt.Errorf("underlying reader does not support seeking")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// progressReadCloser is a progressReader that can be closed
+type progressReadCloser struct {
+	progressReader
+	closer io.Closer
+}
+
+// Close implements io.Closer
+func (p *progressReadCloser) Close() error {
+	return p.closer.Close()
+}
+
+// ProgressReader returns a reader calling progress with the size of every
+// read from reader. It returns reader itself when progress is nil.
+func ProgressReader(reader io.Reader, progress interfaces.ProgressFunc) io.Reader {
+	if progress == nil {
+		return reader
+	}
+	return &progressReader{reader: reader, progress: progress}
+}
+
+// ProgressReadCloser is ProgressReader for a body that must be closed
+func ProgressReadCloser(body io.ReadCloser, progress interfaces.ProgressFunc) io.ReadCloser {
+	if progress == nil {
+		return body
+	}
+	return &progressReadCloser{
+		progressReader: progressReader{reader: body, progress: progress},
+		closer:         body,
+	}
+}
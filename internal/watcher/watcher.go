@@ -23,10 +23,13 @@ package watcher
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"CloudAWSync/internal/interfaces"
@@ -35,13 +38,21 @@ import (
 	"go.uber.org/zap"
 )
 
+// watchCountInterval is how often the watches of each directory are
+// counted, catching subdirectories that were removed
+const watchCountInterval = time.Minute
+
 // FSWatcher implements the FileWatcher interface using fsnotify
 type FSWatcher struct {
 	watcher   *fsnotify.Watcher
 	logger    *zap.Logger
+	metrics   interfaces.MetricsCollector
 	eventChan chan interfaces.FileEvent
 	done      chan struct{}
 	filters   []string
+
+	rootsMutex sync.Mutex
+	roots      []string // watched directories, whose watches are counted
 }
 
 // NewFSWatcher creates a new file system watcher
@@ -74,6 +85,7 @@ func (w *FSWatcher) Watch(ctx context.Context, dirs []string) (<-chan interfaces
 		w.logger.Info("Successfully added directory to watcher",
 			zap.String("directory", dir))
 	}
+	w.recordWatches()
 
 	// Start event processing goroutine
 	go w.processEvents(ctx)
@@ -103,10 +115,86 @@ func (w *FSWatcher) SetFilters(filters []string) {
 	w.filters = filters
 }
 
+// SetMetrics sets the collector recording event counts and watches. It
+// must be called before Watch.
+func (w *FSWatcher) SetMetrics(metrics interfaces.MetricsCollector) {
+	w.metrics = metrics
+}
+
+// AddDirectory starts watching another directory after Watch was called
+func (w *FSWatcher) AddDirectory(dir string) error {
+	err := w.addDirectory(dir)
+	w.recordWatches()
+	return err
+}
+
+// RemoveDirectory stops watching a directory and its subdirectories
+func (w *FSWatcher) RemoveDirectory(dir string) error {
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+	for _, path := range w.watcher.WatchList() {
+		if path != dir && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		if err := w.watcher.Remove(path); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+			return fmt.Errorf("failed to remove watch for %s: %w", path, err)
+		}
+	}
+	w.rootsMutex.Lock()
+	for i, root := range w.roots {
+		if root == dir {
+			w.roots = append(w.roots[:i], w.roots[i+1:]...)
+			break
+		}
+	}
+	w.rootsMutex.Unlock()
+	if w.metrics != nil {
+		w.metrics.RecordWatches(dir, 0)
+	}
+	w.recordWatches()
+
+	w.logger.Info("Removed directory from watcher", zap.String("directory", dir))
+	return nil
+}
+
+// recordWatches counts the watches below each watched directory for
+// metrics. Nested directories count towards the innermost one.
+func (w *FSWatcher) recordWatches() {
+	if w.metrics == nil {
+		return
+	}
+
+	w.rootsMutex.Lock()
+	roots := append([]string(nil), w.roots...)
+	w.rootsMutex.Unlock()
+
+	counts := make(map[string]int, len(roots))
+	for _, path := range w.watcher.WatchList() {
+		match := ""
+		for _, root := range roots {
+			prefix := strings.TrimSuffix(root, string(filepath.Separator)) + string(filepath.Separator)
+			if (path == root || strings.HasPrefix(path, prefix)) && len(root) > len(match) {
+				match = root
+			}
+		}
+		if match != "" {
+			counts[match]++
+		}
+	}
+	for _, root := range roots {
+		w.metrics.RecordWatches(root, counts[root])
+	}
+}
+
 // addDirectory adds a directory to the watcher recursively
 func (w *FSWatcher) addDirectory(dir string) error {
 	w.logger.Info("Adding directory to watcher", zap.String("directory", dir))
 
+	w.rootsMutex.Lock()
+	if !slices.Contains(w.roots, dir) {
+		w.roots = append(w.roots, dir)
+	}
+	w.rootsMutex.Unlock()
+
 	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			w.logger.Warn("Error walking directory",
@@ -134,8 +222,13 @@ func (w *FSWatcher) addDirectory(dir string) error {
 func (w *FSWatcher) processEvents(ctx context.Context) {
 	defer w.logger.Info("Event processing stopped")
 
+	ticker := time.NewTicker(watchCountInterval)
+	defer ticker.Stop()
+
 	for {
 		select {
+		case <-ticker.C:
+			w.recordWatches()
 		case <-ctx.Done():
 			return
 		case <-w.done:
@@ -144,18 +237,26 @@ func (w *FSWatcher) processEvents(ctx context.Context) {
 			if !ok {
 				return
 			}
-			w.handleEvent(event)
+			w.handleEvent(ctx, event)
 		case err, ok := <-w.watcher.Errors:
 			if !ok {
 				return
 			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.logger.Error("Kernel event queue overflowed, changes were lost until the next scan",
+					zap.Error(err))
+				if w.metrics != nil {
+					w.metrics.RecordWatcherDrop("kernel")
+				}
+				continue
+			}
 			w.logger.Error("File watcher error", zap.Error(err))
 		}
 	}
 }
 
 // handleEvent handles a single file system event
-func (w *FSWatcher) handleEvent(event fsnotify.Event) {
+func (w *FSWatcher) handleEvent(ctx context.Context, event fsnotify.Event) {
 	w.logger.Debug("Raw file system event",
 		zap.String("path", event.Name),
 		zap.String("op", event.Op.String()))
@@ -163,6 +264,7 @@ func (w *FSWatcher) handleEvent(event fsnotify.Event) {
 	// Skip if file matches filter patterns
 	if w.shouldSkipFile(event.Name) {
 		w.logger.Debug("Skipping filtered file", zap.String("path", event.Name))
+		w.recordEvent(true)
 		return
 	}
 
@@ -189,6 +291,7 @@ func (w *FSWatcher) handleEvent(event fsnotify.Event) {
 			} else {
 				w.logger.Info("Added new directory to watcher",
 					zap.String("path", event.Name))
+				w.recordWatches()
 			}
 		}
 	case event.Op&fsnotify.Write == fsnotify.Write:
@@ -200,29 +303,38 @@ func (w *FSWatcher) handleEvent(event fsnotify.Event) {
 	case event.Op&fsnotify.Chmod == fsnotify.Chmod:
 		// Skip chmod events as they don't affect file content
 		w.logger.Debug("Skipping chmod event", zap.String("path", event.Name))
+		w.recordEvent(true)
 		return
 	default:
 		w.logger.Debug("Unknown file event",
 			zap.String("path", event.Name),
 			zap.String("op", event.Op.String()))
+		w.recordEvent(true)
 		return
 	}
+	w.recordEvent(false)
 
 	w.logger.Info("File event detected",
 		zap.String("path", fileEvent.Path),
 		zap.String("operation", fileEvent.Operation),
 		zap.Bool("isDir", fileEvent.IsDir))
 
-	// Send event to channel (non-blocking)
+	// Send event to channel, waiting for the reader rather than dropping
+	// the event. Meanwhile the kernel queues further events.
 	select {
 	case w.eventChan <- fileEvent:
 		w.logger.Debug("File event sent to channel",
 			zap.String("path", fileEvent.Path),
 			zap.String("operation", fileEvent.Operation))
-	default:
-		w.logger.Warn("Event channel full, dropping event",
-			zap.String("path", fileEvent.Path),
-			zap.String("operation", fileEvent.Operation))
+	case <-ctx.Done():
+	case <-w.done:
+	}
+}
+
+// recordEvent counts a received event for metrics
+func (w *FSWatcher) recordEvent(filtered bool) {
+	if w.metrics != nil {
+		w.metrics.RecordWatcherEvent(filtered)
 	}
 }
 
@@ -290,11 +402,27 @@ func (b *BatchedWatcher) Stop() error {
 	return b.watcher.Stop()
 }
 
+// AddDirectory starts watching another directory after Watch was called
+func (b *BatchedWatcher) AddDirectory(dir string) error {
+	return b.watcher.AddDirectory(dir)
+}
+
+// RemoveDirectory stops watching a directory and its subdirectories
+func (b *BatchedWatcher) RemoveDirectory(dir string) error {
+	return b.watcher.RemoveDirectory(dir)
+}
+
 // SetFilters sets file filters
 func (b *BatchedWatcher) SetFilters(filters []string) {
 	b.watcher.SetFilters(filters)
 }
 
+// SetMetrics sets the collector recording event counts, flushes and
+// watches. It must be called before Watch.
+func (b *BatchedWatcher) SetMetrics(metrics interfaces.MetricsCollector) {
+	b.watcher.SetMetrics(metrics)
+}
+
 // batchEvents batches file events to reduce redundant operations
 func (b *BatchedWatcher) batchEvents(ctx context.Context, input <-chan interfaces.FileEvent, output chan<- interfaces.FileEvent) {
 	defer close(output)
@@ -316,22 +444,32 @@ func (b *BatchedWatcher) batchEvents(ctx context.Context, input <-chan interface
 			// Store latest event for each path
 			eventMap[event.Path] = event
 		case <-ticker.C:
-			// Flush batched events
-			b.flushEvents(eventMap, output)
-			eventMap = make(map[string]interfaces.FileEvent)
+			// Flush batched events, keeping those the reader had no room
+			// for to be sent with the next batch
+			eventMap = b.flushEvents(eventMap, output)
 		}
 	}
 }
 
-// flushEvents sends all batched events
-func (b *BatchedWatcher) flushEvents(eventMap map[string]interfaces.FileEvent, output chan<- interfaces.FileEvent) {
-	for _, event := range eventMap {
+// flushEvents sends the batched events the output channel has room for
+// and returns the ones left, which are not dropped but held for the next
+// flush and coalesced with later events of their paths
+func (b *BatchedWatcher) flushEvents(eventMap map[string]interfaces.FileEvent, output chan<- interfaces.FileEvent) map[string]interfaces.FileEvent {
+	held := make(map[string]interfaces.FileEvent)
+	for path, event := range eventMap {
 		select {
 		case output <- event:
 		default:
-			b.logger.Warn("Batched event channel full, dropping event",
-				zap.String("path", event.Path),
-				zap.String("operation", event.Operation))
+			held[path] = event
 		}
 	}
+
+	if len(held) > 0 {
+		b.logger.Debug("Batched event channel full, holding events",
+			zap.Int("held", len(held)))
+	}
+	if metrics := b.watcher.metrics; metrics != nil {
+		metrics.RecordWatcherFlush(len(eventMap) - len(held))
+	}
+	return held
 }
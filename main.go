@@ -22,17 +22,26 @@ along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/control"
 	"CloudAWSync/internal/interfaces"
 	"CloudAWSync/internal/service"
+	"CloudAWSync/internal/tui"
 	"CloudAWSync/internal/utils"
 
 	"go.uber.org/zap"
@@ -50,11 +59,38 @@ var (
 	daemon         = flag.Bool("daemon", true, "Run as daemon (default: true)")
 	logLevel       = flag.String("log-level", "", "Override log level (debug, info, warn, error)")
 	generateConfig = flag.Bool("generate-config", false, "Generate sample configuration file")
+	generateUnit   = flag.Bool("generate-systemd", false, "Generate a systemd service file from the configuration and exit")
+	userUnit       = flag.Bool("user", false, "With -generate-systemd, generate a user unit for systemctl --user")
+	validateConfig = flag.Bool("validate-config", false, "Validate configuration file and report all problems")
+	dumpSchema     = flag.Bool("dump-config-schema", false, "Print all configuration keys with types and defaults")
+	verify         = flag.Bool("verify", false, "Compare local directories with remote copies and exit")
+	fromManifest   = flag.Bool("from-manifest", false, "With -verify, compare against directory manifests instead of listing the remote")
+	scrub          = flag.Bool("scrub", false, "Check remote objects against the state database and exit")
+	checkParity    = flag.Bool("check-parity", false, "Read the parity stripes of backup directories, report damaged objects and exit")
+	repairParity   = flag.Bool("repair-parity", false, "Like -check-parity, rebuilding damaged objects from parity")
+	archiveDry     = flag.Bool("archive-report", false, "Report local files archive mode would remove and exit")
+	retentionDry   = flag.Bool("retention-report", false, "Report remote objects selected by retention rules and exit")
+	directory      = flag.String("directory", "", "Local path of the configured directory to operate on")
+	listGens       = flag.Bool("list-generations", false, "List backup generations and exit")
+	restoreGen     = flag.String("restore-generation", "", "Restore a backup generation of -directory and exit")
+	restoreTarget  = flag.String("restore-target", "", "Directory to restore into (default: the directory itself)")
+	once           = flag.Bool("once", false, "Sync once, wait for transfers to finish, print a summary and exit")
+	summaryJSON    = flag.String("summary-json", "", "With -once, write a JSON report to this file (- for stdout)")
+	replace        = flag.Bool("replace", false, "Stop an instance already running with the same state directory and take over")
+	adopt          = flag.Bool("adopt", false, "Record remote objects matching local files in the state database instead of uploading them again")
+	profileName    = flag.String("profile", "", "Operate on the named profile of the configuration only")
+	environment    = flag.String("environment", "", "Apply the named environment of the configuration")
 )
 
 func main() {
 	flag.Parse()
 
+	// The environment is passed on through the process environment so that
+	// reloads and the configuration checks of install apply it as well
+	if *environment != "" {
+		os.Setenv(config.EnvironmentVariable, *environment)
+	}
+
 	if *showVersion {
 		fmt.Printf("%s version %s\n", appName, version)
 		os.Exit(0)
@@ -74,11 +110,37 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *dumpSchema {
+		if err := config.WriteSchema(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write config schema: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if *validateConfig {
+		os.Exit(runValidateConfig(*configPath))
+	}
+
+	// init and import-rsync run before a configuration exists
+	switch flag.Arg(0) {
+	case "init":
+		os.Exit(runInit(*configPath))
+	case "import-rsync":
+		os.Exit(runImportRsync(flag.Args()[1:]))
+	}
+
+	if *summaryJSON != "" && !*once {
+		fmt.Fprintln(os.Stderr, "-summary-json requires -once")
+		os.Exit(exitFailure)
+	}
+
 	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
+	cfg, err := config.LoadConfigFrom(context.Background(), *configPath, service.FetchRemoteConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
-		os.Exit(1)
+		reportFailedRun(err, time.Now(), exitConfig)
+		os.Exit(exitConfig)
 	}
 
 	// Override log level if specified
@@ -86,6 +148,39 @@ func main() {
 		cfg.Logging.Level = *logLevel
 	}
 
+	if *generateUnit {
+		if err := generateSystemDService(cfg, *configPath, *userUnit); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate systemd service file: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// A selected profile runs on its own, as the daemon would run it
+	if *profileName != "" {
+		if cfg, err = cfg.ProfileConfig(*profileName); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			reportFailedRun(err, time.Now(), exitConfig)
+			os.Exit(exitConfig)
+		}
+	}
+
+	// Adopting a bucket filled by another tool applies to every directory
+	if *adopt {
+		if cfg.State.Path == "" {
+			fmt.Fprintln(os.Stderr, "-adopt requires state.path to be set")
+			os.Exit(exitConfig)
+		}
+		for i := range cfg.Directories {
+			cfg.Directories[i].AdoptRemote = true
+		}
+		for _, profile := range cfg.Profiles {
+			for i := range profile.Directories {
+				profile.Directories[i].AdoptRemote = true
+			}
+		}
+	}
+
 	// Initialize logger
 	logger, err := utils.InitLogger(cfg.Logging)
 	if err != nil {
@@ -96,15 +191,96 @@ func main() {
 
 	logger.Info("Starting CloudAWSync",
 		zap.String("version", version),
-		zap.String("config_path", getConfigPath(*configPath)))
+		zap.String("config_path", getConfigPath(*configPath)),
+		zap.String("environment", cfg.Environment))
+	logConfigWarnings(cfg, logger)
+
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(cfg, flag.Args()))
+	}
+
+	// Interrupting startup or a single-shot run cancels its requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Create and start service
-	svc, err := service.NewService(cfg)
+	started := time.Now()
+	svc, err := service.NewService(ctx, cfg)
 	if err != nil {
-		logger.Fatal("Failed to create service", zap.Error(err))
+		logger.Error("Failed to create service", zap.Error(err))
+		fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+		reportFailedRun(err, started, failureExitCode(err))
+		os.Exit(failureExitCode(err))
+	}
+
+	if *verify {
+		os.Exit(runVerify(ctx, svc))
+	}
+
+	if *scrub {
+		os.Exit(runScrub(ctx, svc))
+	}
+
+	if *checkParity || *repairParity {
+		os.Exit(runCheckParity(ctx, svc, *repairParity))
+	}
+
+	if *archiveDry {
+		os.Exit(runArchiveReport(ctx, svc))
+	}
+
+	if *retentionDry {
+		os.Exit(runRetentionReport(ctx, svc))
+	}
+
+	if *listGens {
+		os.Exit(runListGenerations(ctx, svc))
+	}
+
+	if *restoreGen != "" {
+		os.Exit(runRestoreGeneration(ctx, svc, *directory, *restoreGen, *restoreTarget))
+	}
+
+	// Only one instance may sync with the same state
+	lock, err := service.AcquireInstanceLock(cfg, getConfigPath(*configPath), *replace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		var running *service.AlreadyRunningError
+		if errors.As(err, &running) {
+			fmt.Fprintln(os.Stderr, "Stop it first or start with -replace to take over")
+		}
+		reportFailedRun(err, started, exitFailure)
+		os.Exit(exitFailure)
+	}
+	defer lock.Release()
+
+	// Profiles run in the daemon beside the top-level directories
+	var profiles []*profileService
+	if !*once {
+		if profiles, err = newProfileServices(ctx, cfg, getConfigPath(*configPath), *replace); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			reportFailedRun(err, started, exitFailure)
+			lock.Release()
+			os.Exit(exitFailure)
+		}
+	}
+
+	// Root is only needed to set up, sync as security.run_as from here on
+	if err := service.DropPrivileges(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		reportFailedRun(err, started, exitFailure)
+		lock.Release()
+		os.Exit(exitFailure)
+	}
+
+	if *once {
+		exitCode := runOnce(ctx, svc, *directory, *summaryJSON)
+		lock.Release()
+		os.Exit(exitCode)
 	}
 
 	// Setup signal handling
+	stop()
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
@@ -115,6 +291,7 @@ func main() {
 			zap.String("signal", sig.String()))
 
 		// Stop the service
+		stopProfiles(profiles, logger)
 		if err := svc.Stop(); err != nil {
 			logger.Error("Error during shutdown", zap.Error(err))
 		}
@@ -125,6 +302,40 @@ func main() {
 	if err := svc.Start(); err != nil {
 		logger.Fatal("Failed to start service", zap.Error(err))
 	}
+	startProfiles(profiles, logger)
+
+	// Reload configuration on SIGHUP
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	var loaded atomic.Pointer[config.Config]
+	loaded.Store(cfg)
+	go func() {
+		for range reloadChan {
+			logger.Info("Received SIGHUP, reloading configuration")
+			newCfg, err := config.LoadConfigFrom(context.Background(), *configPath, service.FetchRemoteConfig)
+			if err != nil {
+				logger.Error("Failed to reload configuration", zap.Error(err))
+				continue
+			}
+			logConfigWarnings(newCfg, logger)
+			if *profileName != "" {
+				if newCfg, err = newCfg.ProfileConfig(*profileName); err != nil {
+					logger.Error("Failed to reload configuration", zap.Error(err))
+					continue
+				}
+			}
+			loaded.Store(newCfg)
+			if err := svc.Reload(newCfg); err != nil {
+				logger.Error("Failed to apply reloaded configuration", zap.Error(err))
+			}
+			reloadProfiles(profiles, newCfg, logger)
+		}
+	}()
+
+	// Reload when a new remote configuration is published
+	if cfg.RemoteConfig.URL != "" && cfg.RemoteConfig.Interval > 0 {
+		go watchRemoteConfig(&loaded, reloadChan, logger)
+	}
 
 	// Run as daemon
 	if *daemon {
@@ -142,6 +353,7 @@ func main() {
 
 	// Graceful shutdown
 	logger.Info("Shutting down service")
+	stopProfiles(profiles, logger)
 	if err := svc.Stop(); err != nil {
 		logger.Error("Error during shutdown", zap.Error(err))
 	}
@@ -153,21 +365,187 @@ func showUsage() {
 	fmt.Printf(`%s - Cloud File Synchronization Agent
 
 Usage: %s [options]
+       %s [options] init
+       %s import-rsync <crontab|script|rsnapshot.conf|->...
+       %s [options] install [-dry-run]
+       %s [options] uninstall [-dry-run] [-purge]
+       %s [options] get <path>...
+       %s [options] mount <s3://bucket/prefix|prefix> <mountpoint>
+       %s [options] ls [-l] [-stored-keys] [prefix]
+       %s [options] sync [-wait] [directory]
+       %s [options] enable|disable <directory>...
+       %s [options] errors
+       %s [options] health
+       %s [options] quarantine [list|clear [-all] [path...]]
+       %s [options] remote-only [-keys]
+       %s [options] runs [-n count] [directory]
+       %s [options] export-state [-key-file file] <archive|->
+       %s [options] import-state [-key-file file] [-map-path /old=/new]... [-force] <archive|->
 
 Options:
+  -adopt
+        Compare remote objects the state database has no record of with
+        the local files by checksum, recording matches instead of
+        uploading them again. For buckets filled by another tool.
+  -archive-report
+        Report local files archive mode would remove and exit
+  -check-parity
+        Read every parity stripe of backup directories with a parity
+        policy and report lost or corrupted objects, then exit
   -config string
         Path to configuration file (default: searches standard locations)
   -daemon
         Run as daemon (default: true)
+  -directory string
+        Local path of the configured directory to operate on
+  -dump-config-schema
+        Print all configuration keys with types and defaults
+  -environment string
+        Apply the named environment of the configuration, such as "home"
+        or "tethered" (default: $CLOUDAWSYNC_ENVIRONMENT or the environment
+        key of the configuration)
+  -from-manifest
+        With -verify, compare against directory manifests instead of
+        listing the remote
   -generate-config
         Generate sample configuration file
+  -generate-systemd
+        Generate a systemd service file from the configuration and exit.
+        Paths, user, resource limits and the config path are taken from
+        the loaded configuration.
   -help
         Show this help message
+  -list-generations
+        List backup generations and exit
   -log-level string
         Override log level (debug, info, warn, error)
+  -once
+        Sync every enabled directory (or only -directory) once, wait for
+        the uploads to finish, print a summary and exit, for cron and CI
+        jobs. See Exit Codes.
+  -profile string
+        Operate on the named profile only: run it as its own daemon, sync
+        it with -once or talk to it with the control commands
+  -replace
+        Stop an instance already running with the same state directory
+        and take over from it
+  -repair-parity
+        Like -check-parity, rebuilding damaged objects from the other
+        objects of their stripe and uploading them again
+  -restore-generation string
+        Restore a backup generation of -directory and exit
+  -restore-target string
+        Directory to restore into (default: the directory itself)
+  -retention-report
+        Report remote objects selected by retention rules and exit
+  -scrub
+        Check remote objects against the state database and exit
+  -summary-json string
+        With -once, write a JSON report of the run (files and bytes
+        transferred, failed directories and transfers) to this file, or
+        to stdout with -
+  -user
+        With -generate-systemd, generate a user unit for systemctl --user
+  -validate-config
+        Validate configuration file and report all problems
+  -verify
+        Compare local directories with remote copies and exit
   -version
         Show version information
 
+Commands:
+  init
+        Create a configuration file interactively: bucket, region,
+        credentials, directories and sync modes. Tests access to the bucket
+        and optionally generates a systemd service file. Writes to -config
+        or the default location.
+  import-rsync <file>...
+        Translate the rsync commands of a crontab or shell script, or the
+        backup points of an rsnapshot configuration, into directory entries
+        printed as YAML. Cron schedules, recursion, name excludes, exclude
+        and merge files, size limits and --link-dest (backup mode) are
+        carried over; other options and remote sources are reported as
+        warnings. Reads stdin for -.
+  install [-dry-run]
+        Install the agent as a system service: copy the binary into the
+        working directory, create the service user and group, copy the
+        configuration to /etc/cloudawsync, give the service user its log,
+        state and cache directories, write the unit file and enable the
+        service. -dry-run prints the steps without running them.
+  uninstall [-dry-run] [-purge]
+        Stop and disable the service and remove its unit file and binary.
+        -purge also removes configuration, state, logs and the service
+        user.
+  get <path>...
+        Download archived files in place of their stubs. Uses the control
+        socket of a running agent when available.
+  mount <s3://bucket/prefix|prefix> <mountpoint>
+        Mount a remote prefix with FUSE until interrupted. Reads are cached
+        locally and writes are uploaded when files are closed.
+  top [-interval 1s]
+        Show active transfers, queue depths, bandwidth and recent errors of
+        a running agent, refreshed until interrupted. Requires the control
+        socket.
+  events [type]...
+        Print sync events of a running agent as JSON lines until
+        interrupted, optionally only the given types. Requires the control
+        socket.
+  ls [-l] [-stored-keys] [prefix]
+        List remote objects below a prefix. Obfuscated names are decrypted;
+        -stored-keys also prints the key each object is stored under and -l
+        adds sizes and modification times.
+  sync [-wait] [directory]
+        Start a sync of a configured directory, or of all directories, in a
+        running agent. -wait returns once the directory's changes are
+        queued. Requires the control socket.
+  enable|disable <directory>...
+        Resume or stop syncing configured directories of a running agent
+        until it restarts. Enabling starts a sync. Requires the control
+        socket.
+  errors
+        Print the most recent failed transfers of a running agent with
+        their paths and retries. Requires the control socket.
+  health
+        Print whether a running agent can sync, the storage backend in use
+        and any problems. Exits with status 1 unless healthy, 2 when
+        degraded. Requires the control socket.
+  quarantine [list]
+        List files a running agent skips after repeated upload failures,
+        with the last error and when they will be retried.
+  quarantine clear [-all] [path...]
+        Release files from quarantine so they are uploaded again on the
+        next scan. -all releases every quarantined file.
+  remote-only [-keys]
+        Report the remote objects of each directory of a running agent
+        that have no local file, such as files deleted locally from an
+        upload-only directory, with their number and size. -keys lists
+        them. Nothing is deleted. Requires the control socket.
+  runs [-n count] [directory]
+        Print the most recent sync runs of a running agent, or of one of
+        its directories, with their sync ID and the files each scanned,
+        skipped, uploaded, downloaded and deleted (default: 10 runs).
+        Requires the control socket.
+  export-state [-key-file file] <archive|->
+        Write the state database (uploaded objects with their hashes and
+        modification times, queued and quarantined files), the name map
+        and the audit log to a zstd compressed archive, encrypted with the
+        secret in -key-file when given. For moving the agent to a new
+        machine without hashing every file again.
+  import-state [-key-file file] [-map-path /old=/new]... [-force] <archive|->
+        Restore an archive made by export-state to the paths of this
+        configuration. -map-path moves the records of local paths below
+        /old to /new when the directories live elsewhere on the new
+        machine. Existing state files are only replaced with -force. The
+        agent must not be running.
+
+Exit Codes:
+  0  success
+  1  the command could not run
+  2  some directories or files failed (-once), differences or problems
+     found (-verify, -scrub, -check-parity)
+  3  the configuration is missing or invalid
+  4  the storage service rejected the credentials
+
 Configuration File Locations (searched in order):
   1. Path specified by -config flag
   2. $XDG_CONFIG_HOME/cloudawsync/config.yaml
@@ -187,20 +565,49 @@ Examples:
   # Run with custom config file
   %s -config /path/to/config.yaml
 
+  # Set up a configuration interactively
+  %s init
+
   # Generate sample configuration
   %s -generate-config
 
+  # Check a configuration file for problems
+  %s -config /path/to/config.yaml -validate-config
+
   # Run in foreground with debug logging
   %s -daemon=false -log-level=debug
 
+  # Sync once from cron, exiting non-zero on failures
+  %s -once -directory /home/user/Documents
+
+  # Take over a bucket filled by another tool, uploading only differences
+  %s -once -adopt
+
+  # Download an archived file
+  %s get /home/user/Documents/report.pdf
+
+  # Browse a remote prefix without restoring it
+  %s mount s3://my-bucket/cloudawsync/documents /mnt/documents
+
+  # Install as a system service after previewing the steps
+  sudo %s -config /path/to/config.yaml install -dry-run
+  sudo %s -config /path/to/config.yaml install
+
+  # Pause syncing a directory without editing the configuration
+  %s disable /home/user/Documents
+
+  # Show the status of one profile of a shared daemon
+  %s -profile alice health
+
 SystemD Service:
-  To run as a systemd service, copy the generated service file to
-  /etc/systemd/system/ and enable it:
+  To run as a systemd service, run install as root. Alternatively generate
+  a service file with -generate-systemd (or -generate-systemd -user for a
+  user unit), copy it to /etc/systemd/system/ and enable it:
   
   sudo systemctl enable cloudawsync
   sudo systemctl start cloudawsync
 
-`, appName, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+`, appName, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 }
 
 func generateSampleConfig() error {
@@ -243,6 +650,836 @@ func generateSampleConfig() error {
 	return nil
 }
 
+// runValidateConfig validates a configuration file and prints every problem,
+// returning the process exit code
+func runValidateConfig(path string) int {
+	if path == "" {
+		path = config.DefaultConfigPath()
+	}
+
+	problems, err := config.ValidateFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to validate configuration: %v\n", err)
+		return exitConfig
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Configuration %s is valid\n", path)
+		return 0
+	}
+
+	for _, problem := range problems {
+		location := path
+		if problem.File != "" {
+			location = problem.File
+		}
+		if problem.Line > 0 {
+			location = fmt.Sprintf("%s:%d", location, problem.Line)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s\n", location, problem.Error())
+	}
+	fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+	return exitConfig
+}
+
+// logConfigWarnings logs the problems found while loading a configuration
+// that did not prevent it
+func logConfigWarnings(cfg *config.Config, logger *zap.Logger) {
+	for _, warning := range cfg.Warnings {
+		logger.Warn("Configuration warning", zap.String("warning", warning))
+	}
+}
+
+// watchRemoteConfig checks the remote configuration every interval and
+// triggers a reload when the published document or signature differs from
+// the one last fetched. The reload fetches and verifies it again; a
+// document it rejects is not reloaded again until it changes.
+func watchRemoteConfig(loaded *atomic.Pointer[config.Config], reload chan<- os.Signal, logger *zap.Logger) {
+	remote := loaded.Load().RemoteConfig
+	ticker := time.NewTicker(remote.Interval)
+	defer ticker.Stop()
+
+	seen := loaded.Load().RemoteFetched
+	for range ticker.C {
+		cfg := loaded.Load()
+		digest, err := cfg.FetchRemoteDigest(context.Background(), service.FetchRemoteConfig)
+		if err != nil {
+			logger.Warn("Failed to check remote configuration",
+				zap.String("url", remote.URL),
+				zap.Error(err))
+			continue
+		}
+		if digest == seen || digest == cfg.RemoteFetched {
+			seen = digest
+			continue
+		}
+		seen = digest
+		logger.Info("Remote configuration changed, reloading",
+			zap.String("url", remote.URL))
+		reload <- syscall.SIGHUP
+	}
+}
+
+// runVerify verifies all enabled directories and prints a report,
+// returning the process exit code
+func runVerify(ctx context.Context, svc *service.Service) int {
+	reports, err := svc.Verify(ctx, *fromManifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Verification failed: %v\n", err)
+		return 1
+	}
+
+	exitCode := 0
+	for _, report := range reports {
+		status := "OK"
+		if !report.OK() {
+			status = "DIFFERENCES FOUND"
+			exitCode = 2
+		}
+		fmt.Printf("%s -> %s: %s (%d matched)\n", report.LocalPath, report.RemotePath, status, report.Matched)
+		if report.Manifest != "" {
+			fmt.Printf("  against manifest %s\n", report.Manifest)
+		}
+		printPaths("missing remotely", report.Missing)
+		printPaths("only remote", report.Extra)
+		printPaths("checksum mismatch", report.Corrupted)
+		printPaths("unverifiable", report.Unverifiable)
+		printPaths("error", report.Errors)
+	}
+	return exitCode
+}
+
+// runOnce syncs every enabled directory, or only localPath, until its
+// transfers finish and prints a summary. The exit status is exitOK when
+// everything synced, exitPartial when some directories or files failed,
+// exitAuth when the credentials were rejected and exitFailure when the sync
+// could not run. With summaryPath a JSON report is written there as well,
+// and the printed summary goes to stderr when the report goes to stdout.
+func runOnce(ctx context.Context, svc *service.Service, localPath, summaryPath string) int {
+	out := io.Writer(os.Stdout)
+	if summaryPath == "-" {
+		out = os.Stderr
+	}
+
+	started := time.Now()
+	summary, err := svc.SyncOnce(ctx, localPath)
+	var exitCode int
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
+		exitCode = failureExitCode(err)
+	} else {
+		printSyncSummary(out, summary)
+		exitCode = syncExitCode(summary)
+	}
+
+	if summaryPath != "" {
+		if err := writeRunSummary(summaryPath, newRunSummary(summary, err, started, exitCode)); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			if exitCode == exitOK {
+				exitCode = exitFailure
+			}
+		}
+	}
+	return exitCode
+}
+
+// printSyncSummary prints the outcome of a single-shot sync
+func printSyncSummary(out io.Writer, summary *service.SyncSummary) {
+	for _, dir := range summary.Directories {
+		status := "OK"
+		if dir.LastError != "" {
+			status = "FAILED: " + dir.LastError
+		}
+		fmt.Fprintf(out, "%s -> %s: %s\n", dir.LocalPath, dir.RemotePath, status)
+	}
+
+	stats := summary.Stats
+	fmt.Fprintf(out, "Uploaded %d files (%s), downloaded %d files (%s), deleted %d in %s\n",
+		stats.FilesUploaded, utils.FormatBytes(stats.BytesUploaded),
+		stats.FilesDownloaded, utils.FormatBytes(stats.BytesDownloaded),
+		stats.FilesDeleted, summary.Duration.Round(time.Millisecond))
+	for _, failure := range stats.RecentErrors {
+		fmt.Fprintf(out, "  %s %s: %v\n", failure.Operation, failure.Path, failure.Error)
+	}
+	if int64(len(stats.RecentErrors)) < stats.SyncErrors {
+		fmt.Fprintf(out, "  %d earlier error(s) not shown\n", stats.SyncErrors-int64(len(stats.RecentErrors)))
+	}
+	if stats.FilesAdopted > 0 {
+		fmt.Fprintf(out, "Adopted %d existing remote object(s)\n", stats.FilesAdopted)
+	}
+	if stats.OfflineQueued > 0 {
+		fmt.Fprintf(out, "%d upload(s) not sent, storage unreachable\n", stats.OfflineQueued)
+	}
+}
+
+// runScrub checks remote objects against the state database and prints
+// a report, returning the process exit code
+func runScrub(ctx context.Context, svc *service.Service) int {
+	report, err := svc.Scrub(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Scrub failed: %v\n", err)
+		return 1
+	}
+
+	status := "OK"
+	exitCode := 0
+	if !report.OK() {
+		status = "PROBLEMS FOUND"
+		exitCode = 2
+	}
+	fmt.Printf("Scrub: %s (%d checked, %d sampled)\n", status, report.Checked, report.Sampled)
+	if report.Archived > 0 {
+		fmt.Printf("  %d archived object(s) checked by metadata only\n", report.Archived)
+	}
+	printPaths("missing", report.Missing)
+	printPaths("modified", report.Modified)
+	printPaths("corrupted", report.Corrupted)
+	printPaths("unverifiable", report.Unverifiable)
+	printPaths("error", report.Errors)
+	return exitCode
+}
+
+// runCheckParity checks, and with repair set repairs, the parity stripes of
+// backup directories
+func runCheckParity(ctx context.Context, svc *service.Service, repair bool) int {
+	reports, err := svc.CheckParity(ctx, repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Parity check failed: %v\n", err)
+		return 1
+	}
+	if len(reports) == 0 {
+		fmt.Println("No backup directory has a parity policy")
+		return 0
+	}
+
+	exitCode := 0
+	for _, report := range reports {
+		status := "OK"
+		if !report.OK() {
+			status = "PROBLEMS FOUND"
+			exitCode = 2
+		}
+		fmt.Printf("%s: %s (%d stripe(s), %d object(s) protected, %d not yet protected)\n",
+			report.LocalPath, status, report.Stripes, report.Protected, report.Unprotected)
+		if report.Skipped > 0 {
+			fmt.Printf("  %d stripe(s) with archived objects skipped\n", report.Skipped)
+		}
+		printPaths("damaged", report.Damaged)
+		printPaths("repaired", report.Repaired)
+		printPaths("unrecoverable", report.Unrecoverable)
+	}
+	return exitCode
+}
+
+// runArchiveReport prints the local files archive mode would remove
+func runArchiveReport(ctx context.Context, svc *service.Service) int {
+	reports, err := svc.ArchiveReport(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Archive report failed: %v\n", err)
+		return 1
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%s: %d file(s), %s would be archived\n",
+			report.LocalPath, len(report.Archived), utils.FormatBytes(report.Bytes))
+		printPaths("archive", report.Archived)
+		for path, reason := range report.Skipped {
+			fmt.Printf("  kept: %s (%s)\n", path, reason)
+		}
+	}
+	return 0
+}
+
+// runRetentionReport prints the objects retention rules would remove
+func runRetentionReport(ctx context.Context, svc *service.Service) int {
+	reports, err := svc.RetentionReport(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Retention report failed: %v\n", err)
+		return 1
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%s: %d object(s) would be removed\n", report.LocalPath, len(report.Actions))
+		for _, action := range report.Actions {
+			if action.VersionID != "" {
+				fmt.Printf("  %s (version %s): %s\n", action.Key, action.VersionID, action.Reason)
+			} else {
+				fmt.Printf("  %s: %s\n", action.Key, action.Reason)
+			}
+		}
+	}
+	return 0
+}
+
+// runListGenerations prints the backup generations of each backup directory
+func runListGenerations(ctx context.Context, svc *service.Service) int {
+	generations, err := svc.ListGenerations(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list generations: %v\n", err)
+		return 1
+	}
+
+	for localPath, ids := range generations {
+		fmt.Printf("%s: %d generation(s)\n", localPath, len(ids))
+		printPaths("generation", ids)
+	}
+	return 0
+}
+
+// runRestoreGeneration restores a backup generation, returning the exit code
+func runRestoreGeneration(ctx context.Context, svc *service.Service, localPath, id, target string) int {
+	if localPath == "" {
+		fmt.Fprintln(os.Stderr, "-restore-generation requires -directory")
+		return 1
+	}
+	if target == "" {
+		target = localPath
+	}
+
+	restored, err := svc.RestoreGeneration(ctx, localPath, id, target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Restore failed after %d file(s): %v\n", restored, err)
+		return 1
+	}
+	fmt.Printf("Restored %d file(s) from generation %s to %s\n", restored, id, target)
+	return 0
+}
+
+// runCommand runs a subcommand given after the options, returning the
+// process exit code
+func runCommand(cfg *config.Config, args []string) int {
+	switch args[0] {
+	case "get":
+		return runGet(cfg, args[1:])
+	case "mount":
+		return runMount(cfg, args[1:])
+	case "top":
+		return runTop(cfg, args[1:])
+	case "events":
+		return runEvents(cfg, args[1:])
+	case "ls":
+		return runList(cfg, args[1:])
+	case "sync":
+		return runSync(cfg, args[1:])
+	case "errors":
+		return runErrors(cfg)
+	case "health":
+		return runHealth(cfg)
+	case "quarantine":
+		return runQuarantine(cfg, args[1:])
+	case "remote-only":
+		return runRemoteOnly(cfg, args[1:])
+	case "runs":
+		return runRuns(cfg, args[1:])
+	case "export-state":
+		return runExportState(cfg, args[1:])
+	case "import-state":
+		return runImportState(cfg, args[1:])
+	case "install":
+		return runInstall(cfg, args[1:])
+	case "uninstall":
+		return runUninstall(cfg, args[1:])
+	case "enable", "disable":
+		return runSetEnabled(cfg, args[0], args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q, run with -help for usage\n", args[0])
+		return 1
+	}
+}
+
+// runGet hydrates archived files, asking a running agent over the control
+// socket when one is listening and hydrating in-process otherwise
+func runGet(cfg *config.Config, paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "get requires at least one path")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hydrate := func(path string) (string, error) {
+		return "", control.ErrUnavailable
+	}
+	if cfg.Control.Enabled {
+		client := control.NewClient(controlSocket(cfg))
+		hydrate = func(path string) (string, error) {
+			return client.Hydrate(ctx, path)
+		}
+	}
+
+	var svc *service.Service
+	exitCode := 0
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+
+		hydrated, err := hydrate(absPath)
+		if errors.Is(err, control.ErrUnavailable) {
+			// No agent is running, hydrate in this process
+			if svc == nil {
+				if svc, err = service.NewService(ctx, cfg); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+					return 1
+				}
+			}
+			hydrated, err = svc.Hydrate(ctx, absPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			exitCode = 1
+			continue
+		}
+		fmt.Println(hydrated)
+	}
+	return exitCode
+}
+
+// runTop shows a live dashboard of a running agent until interrupted
+func runTop(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	interval := flags.Duration("interval", time.Second, "Refresh interval")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "top requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := control.NewClient(controlSocket(cfg))
+	err := tui.Run(ctx, client, os.Stdout, tui.Options{
+		Interval: *interval,
+		Width:    func() int { return tui.TerminalWidth(os.Stdout) },
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runEvents prints the sync events of a running agent as JSON lines until
+// interrupted
+func runEvents(cfg *config.Config, types []string) int {
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "events requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	encoder := json.NewEncoder(os.Stdout)
+	client := control.NewClient(controlSocket(cfg))
+	err := client.Events(ctx, types, func(event interfaces.SyncEvent) error {
+		return encoder.Encode(event)
+	})
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runErrors prints the recent errors of a running agent, oldest first
+func runErrors(cfg *config.Config) int {
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "errors requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	client := control.NewClient(controlSocket(cfg))
+	stats, err := client.Stats(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, failure := range stats.RecentErrors {
+		fmt.Printf("%s  %-8s  %s: %v", failure.Timestamp.Local().Format(time.DateTime), failure.Operation, failure.Path, failure.Error)
+		if failure.Retries > 0 {
+			fmt.Printf(" (%d retries)", failure.Retries)
+		}
+		fmt.Println()
+	}
+	if int64(len(stats.RecentErrors)) < stats.SyncErrors {
+		fmt.Printf("%d earlier error(s) not shown\n", stats.SyncErrors-int64(len(stats.RecentErrors)))
+	}
+	return 0
+}
+
+// runHealth prints the health of a running agent. The exit status is 0
+// when healthy, 2 when degraded and 1 otherwise.
+func runHealth(cfg *config.Config) int {
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "health requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	client := control.NewClient(controlSocket(cfg))
+	health, err := client.Health(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Status: %s\n", health.Status)
+	if provider := health.Provider; provider != nil {
+		fmt.Printf("Storage: %s", provider.Active)
+		if provider.FailedOver {
+			fmt.Printf(" (failed over at %s)", provider.FailedOverAt.Local().Format(time.DateTime))
+		}
+		fmt.Println()
+		if provider.PendingRepairs > 0 {
+			fmt.Printf("Pending copies to primary: %d\n", provider.PendingRepairs)
+		}
+	}
+	for _, circuit := range health.Circuits {
+		fmt.Printf("Circuit %s: %s", circuit.Backend, circuit.State)
+		if circuit.State != "closed" {
+			fmt.Printf(" since %s", circuit.OpenedAt.Local().Format(time.DateTime))
+		}
+		fmt.Println()
+	}
+	for _, problem := range health.Problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+
+	switch health.Status {
+	case "ok":
+		return 0
+	case "degraded":
+		return 2
+	default:
+		return 1
+	}
+}
+
+// runQuarantine lists or clears the quarantined files of a running agent
+func runQuarantine(cfg *config.Config, args []string) int {
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "quarantine requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	ctx := context.Background()
+	client := control.NewClient(controlSocket(cfg))
+
+	if len(args) == 0 || args[0] == "list" {
+		files, err := client.Quarantined(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		for _, file := range files {
+			if file.ETag != "" {
+				fmt.Printf("%s\n  infected download of %s since %s, retry when it changes: %s\n",
+					file.LocalPath, file.RemotePath, file.QuarantinedAt.Local().Format(time.DateTime), file.Reason)
+				continue
+			}
+			retry := "when cleared"
+			if !file.RetryAfter.IsZero() {
+				retry = file.RetryAfter.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%s\n  %d failures since %s, retry %s: %s\n",
+				file.LocalPath, file.Failures, file.QuarantinedAt.Local().Format(time.DateTime), retry, file.Reason)
+		}
+		return 0
+	}
+
+	if args[0] != "clear" {
+		fmt.Fprintf(os.Stderr, "Unknown quarantine command %q, expected list or clear\n", args[0])
+		return 1
+	}
+
+	flags := flag.NewFlagSet("quarantine clear", flag.ContinueOnError)
+	all := flags.Bool("all", false, "Release every quarantined file")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if *all == (flags.NArg() > 0) {
+		fmt.Fprintln(os.Stderr, "quarantine clear requires either -all or at least one path")
+		return 1
+	}
+
+	var paths []string
+	for _, path := range flags.Args() {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		paths = append(paths, absPath)
+	}
+
+	cleared, err := client.ClearQuarantine(ctx, paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	fmt.Printf("Released %d file(s) from quarantine\n", cleared)
+	return 0
+}
+
+// runRemoteOnly prints the remote objects with no local file of each
+// directory of a running agent
+func runRemoteOnly(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("remote-only", flag.ContinueOnError)
+	keys := flags.Bool("keys", false, "List the remote-only objects")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "remote-only requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := control.NewClient(controlSocket(cfg))
+	reports, err := client.RemoteOnly(ctx, *keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, report := range reports {
+		fmt.Printf("%s: %d remote-only object(s), %s\n",
+			report.LocalPath, report.Objects, utils.FormatBytes(report.Bytes))
+		for _, key := range report.Keys {
+			fmt.Printf("  %s\n", key)
+		}
+	}
+	return 0
+}
+
+// runRuns prints the most recent sync runs of a running agent with what
+// each one changed
+func runRuns(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("runs", flag.ContinueOnError)
+	count := flags.Int("n", 10, "Number of runs to print")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "runs requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	directory := ""
+	if flags.NArg() > 0 {
+		absPath, err := filepath.Abs(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		directory = absPath
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := control.NewClient(controlSocket(cfg))
+	runs, err := client.SyncRuns(ctx, directory, *count)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, run := range runs {
+		duration := "running"
+		if !run.FinishedAt.IsZero() {
+			duration = run.Duration().Round(time.Millisecond).String()
+		}
+		fmt.Printf("%s  %s  %s  %s\n", run.StartedAt.Local().Format(time.DateTime), run.ID, run.Directory, duration)
+		fmt.Printf("  scanned %d, skipped %d, queued %d, uploaded %d (%s), downloaded %d (%s), deleted %d, errors %d\n",
+			run.FilesScanned, run.FilesSkipped, run.FilesQueued,
+			run.FilesUploaded, utils.FormatBytes(run.BytesUploaded),
+			run.FilesDownloaded, utils.FormatBytes(run.BytesDownloaded),
+			run.FilesDeleted, run.Errors)
+		if run.Error != "" {
+			fmt.Printf("  error: %s\n", run.Error)
+		}
+	}
+	return 0
+}
+
+// runSync asks a running agent to sync one directory, or every directory
+// when none is given
+func runSync(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	wait := flags.Bool("wait", false, "Wait until the directory's changes are queued")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if !cfg.Control.Enabled {
+		fmt.Fprintln(os.Stderr, "sync requires the control API, set control.enabled in the configuration")
+		return 1
+	}
+
+	path := ""
+	if flags.NArg() > 0 {
+		absPath, err := filepath.Abs(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+			return 1
+		}
+		path = absPath
+	}
+	if *wait && path == "" {
+		fmt.Fprintln(os.Stderr, "sync -wait requires a directory")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := control.NewClient(controlSocket(cfg))
+	var err error
+	if *wait {
+		err = client.SyncNow(ctx, path)
+	} else {
+		err = client.TriggerSync(ctx, path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runSetEnabled enables or disables directories of a running agent
+func runSetEnabled(cfg *config.Config, command string, paths []string) int {
+	if len(paths) == 0 {
+		fmt.Fprintf(os.Stderr, "%s requires at least one directory\n", command)
+		return 1
+	}
+	if !cfg.Control.Enabled {
+		fmt.Fprintf(os.Stderr, "%s requires the control API, set control.enabled in the configuration\n", command)
+		return 1
+	}
+
+	ctx := context.Background()
+	client := control.NewClient(controlSocket(cfg))
+	change := client.EnableDirectory
+	if command == "disable" {
+		change = client.DisableDirectory
+	}
+
+	exitCode := 0
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err == nil {
+			err = change(ctx, absPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+// runList prints the remote objects below a prefix with their plain keys
+func runList(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	long := flags.Bool("l", false, "Show sizes and modification times")
+	storedKeys := flags.Bool("stored-keys", false, "Show the key each object is stored under")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "ls takes at most one prefix")
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc, err := service.NewService(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+		return 1
+	}
+
+	files, err := svc.ListRemote(ctx, strings.Trim(flags.Arg(0), "/"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	for _, file := range files {
+		line := file.Key
+		if *long {
+			line = fmt.Sprintf("%10s  %s  %s", utils.FormatBytes(file.Size), file.ModTime.Local().Format(time.DateTime), file.Key)
+		}
+		if *storedKeys {
+			line += "  " + svc.StoredKey(file.Key)
+		}
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// runMount mounts a remote prefix with FUSE until interrupted. The remote
+// may be given as s3://bucket/prefix or as a prefix below aws.s3_prefix.
+func runMount(cfg *config.Config, args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "mount requires a remote prefix and a mountpoint")
+		return 1
+	}
+	remote, mountpoint := args[0], args[1]
+
+	if rest, ok := strings.CutPrefix(remote, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		if bucket == "" {
+			fmt.Fprintf(os.Stderr, "Invalid remote %q\n", remote)
+			return 1
+		}
+		cfg.AWS.S3Bucket = bucket
+		cfg.AWS.S3Prefix = ""
+		remote = prefix
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc, err := service.NewService(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create service: %v\n", err)
+		return 1
+	}
+
+	if err := svc.Mount(ctx, remote, mountpoint); err != nil {
+		fmt.Fprintf(os.Stderr, "Mount failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// printPaths prints a labelled list of paths
+func printPaths(label string, paths []string) {
+	for _, path := range paths {
+		fmt.Printf("  %s: %s\n", label, path)
+	}
+}
+
+// controlSocket returns the control socket recorded by the running
+// instance, or the configured socket when no instance has recorded one
+func controlSocket(cfg *config.Config) string {
+	if instance, ok, _ := service.RunningInstance(cfg); ok && instance.ControlSocket != "" {
+		return instance.ControlSocket
+	}
+	return cfg.Control.Socket
+}
+
 func getConfigPath(providedPath string) string {
 	if providedPath != "" {
 		return providedPath
@@ -266,58 +1503,190 @@ func getConfigPath(providedPath string) string {
 	return "default configuration"
 }
 
-// generateSystemDService generates a systemd service file
-func generateSystemDService(cfg *config.Config) error {
-	serviceContent := fmt.Sprintf(`[Unit]
-Description=CloudAWSync - Cloud File Synchronization Agent
-After=network.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-User=%s
-Group=%s
-WorkingDirectory=%s
-ExecStart=%s -daemon=true
-Restart=%s
-RestartSec=5
-StandardOutput=journal
-StandardError=journal
-SyslogIdentifier=cloudawsync
-
-# Security settings
-NoNewPrivileges=true
-PrivateTmp=true
-ProtectSystem=strict
-ProtectHome=read-only
-ReadWritePaths=%s
-
-# Resource limits
-MemoryMax=512M
-CPUQuota=50%%
-
-[Install]
-WantedBy=multi-user.target
-`,
-		cfg.SystemD.User,
-		cfg.SystemD.Group,
-		cfg.SystemD.WorkingDir,
-		filepath.Join(cfg.SystemD.WorkingDir, "cloudawsync"),
-		cfg.SystemD.RestartPolicy,
-		cfg.SystemD.WorkingDir,
-	)
-
-	serviceFile := "cloudawsync.service"
-	if err := os.WriteFile(serviceFile, []byte(serviceContent), 0644); err != nil {
+// generateSystemDService generates a systemd service file for the
+// configuration at configPath. With user set it generates a user unit for
+// systemctl --user instead of a system unit.
+func generateSystemDService(cfg *config.Config, configPath string, user bool) error {
+	executable, err := os.Executable()
+	if err == nil {
+		executable, err = filepath.EvalSymlinks(executable)
+	}
+	if err != nil {
+		executable = filepath.Join(cfg.SystemD.WorkingDir, "cloudawsync")
+	}
+	if configPath == "" {
+		configPath = config.DefaultConfigPath()
+	}
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+
+	serviceName := cfg.SystemD.ServiceName
+	serviceFile := serviceName + ".service"
+	if err := os.WriteFile(serviceFile, []byte(systemdUnit(cfg, configPath, executable, user)), 0644); err != nil {
 		return err
 	}
 
 	fmt.Printf("SystemD service file generated: %s\n", serviceFile)
 	fmt.Println("To install:")
-	fmt.Printf("  sudo cp %s /etc/systemd/system/\n", serviceFile)
-	fmt.Println("  sudo systemctl daemon-reload")
-	fmt.Println("  sudo systemctl enable cloudawsync")
-	fmt.Println("  sudo systemctl start cloudawsync")
+	if user {
+		fmt.Printf("  mkdir -p ~/.config/systemd/user && cp %s ~/.config/systemd/user/\n", serviceFile)
+		fmt.Println("  systemctl --user daemon-reload")
+		fmt.Printf("  systemctl --user enable --now %s\n", serviceName)
+		fmt.Println("  loginctl enable-linger $USER  # keep running after logout")
+	} else {
+		fmt.Printf("  sudo cp %s /etc/systemd/system/\n", serviceFile)
+		fmt.Println("  sudo systemctl daemon-reload")
+		fmt.Printf("  sudo systemctl enable %s\n", serviceName)
+		fmt.Printf("  sudo systemctl start %s\n", serviceName)
+	}
 
 	return nil
 }
+
+// systemdUnit returns a unit running executable with the configuration at
+// configPath, a user unit when user is set
+func systemdUnit(cfg *config.Config, configPath, executable string, user bool) string {
+	execStart := fmt.Sprintf("%s -daemon=true -config %s", executable, configPath)
+	if cfg.SystemD.LogLevel != "" {
+		execStart += " -log-level=" + cfg.SystemD.LogLevel
+	}
+
+	var unit strings.Builder
+	unit.WriteString("[Unit]\nDescription=CloudAWSync - Cloud File Synchronization Agent\n")
+	if !user {
+		// User managers cannot order against system targets
+		unit.WriteString("After=network-online.target\nWants=network-online.target\n")
+	}
+
+	unit.WriteString("\n[Service]\nType=simple\n")
+	if user {
+		unit.WriteString("WorkingDirectory=%h\n")
+	} else {
+		if needsRoot(cfg) {
+			// The agent drops root itself or creates files for the run_as
+			// users of its directories
+			unit.WriteString("User=root\nGroup=root\n")
+		} else {
+			fmt.Fprintf(&unit, "User=%s\nGroup=%s\n", cfg.SystemD.User, cfg.SystemD.Group)
+		}
+		if cfg.SystemD.WorkingDir != "" {
+			fmt.Fprintf(&unit, "WorkingDirectory=%s\n", cfg.SystemD.WorkingDir)
+		}
+	}
+	fmt.Fprintf(&unit, "ExecStart=%s\n", execStart)
+	unit.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&unit, "Restart=%s\nRestartSec=5\n", cfg.SystemD.RestartPolicy)
+	fmt.Fprintf(&unit, "StandardOutput=journal\nStandardError=journal\nSyslogIdentifier=%s\n", cfg.SystemD.ServiceName)
+
+	unit.WriteString("\n# Security settings\nNoNewPrivileges=true\n")
+	if !user {
+		// Sandboxing needs a system manager, user units run with the
+		// user's own permissions
+		unit.WriteString("PrivateTmp=true\nProtectSystem=strict\nProtectHome=read-only\n")
+		paths, runtimeDirs := writablePaths(cfg)
+		if len(runtimeDirs) > 0 {
+			// /run is emptied on boot, so systemd creates socket directories
+			fmt.Fprintf(&unit, "RuntimeDirectory=%s\n", strings.Join(runtimeDirs, " "))
+		}
+		if rel, ok := strings.CutPrefix(cfg.StateDir, "/var/lib/"); ok {
+			// systemd creates the state directory for the service user and
+			// passes it in $STATE_DIRECTORY, which the agent uses by default
+			fmt.Fprintf(&unit, "StateDirectory=%s\nStateDirectoryMode=0700\n", rel)
+		} else if cfg.StateDir != "" {
+			// Pin the state directory chosen here, the service user's
+			// environment may resolve another
+			fmt.Fprintf(&unit, "Environment=STATE_DIRECTORY=%s\n", cfg.StateDir)
+		}
+		// Missing paths are ignored instead of failing the unit
+		fmt.Fprintf(&unit, "ReadWritePaths=-%s\n", strings.Join(paths, " -"))
+	}
+
+	// The agent keeps itself within memory_limit and cpu_limit, the unit
+	// only throttles beyond them instead of killing mid-transfer
+	if cfg.Performance.MemoryLimit > 0 || cfg.Performance.CPULimit > 0 {
+		unit.WriteString("\n# Resource limits\n")
+		if cfg.Performance.MemoryLimit > 0 {
+			fmt.Fprintf(&unit, "MemoryHigh=%d\n", cfg.Performance.MemoryLimit)
+		}
+		if cfg.Performance.CPULimit > 0 {
+			fmt.Fprintf(&unit, "CPUQuota=%g%%\n", cfg.Performance.CPULimit)
+		}
+	}
+
+	unit.WriteString("\n[Install]\n")
+	if user {
+		unit.WriteString("WantedBy=default.target\n")
+	} else {
+		unit.WriteString("WantedBy=multi-user.target\n")
+	}
+	return unit.String()
+}
+
+// needsRoot reports whether the agent must start as root to act as the
+// users named by run_as settings
+func needsRoot(cfg *config.Config) bool {
+	if cfg.Security.RunAs != "" {
+		return true
+	}
+	for _, profile := range cfg.ProfileConfigs() {
+		if needsRoot(profile) {
+			return true
+		}
+	}
+	for _, dir := range cfg.Directories {
+		if dir.RunAs != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// writablePaths returns the directories the agent writes to, which a
+// sandboxed system unit must allow, and the directories below /run among
+// them relative to /run
+func writablePaths(cfg *config.Config) ([]string, []string) {
+	var paths, runtimeDirs []string
+	add := func(path string) {
+		if path == "" || path == "stdout" || path == "stderr" || !filepath.IsAbs(path) {
+			return
+		}
+		if rel, ok := strings.CutPrefix(path, "/run/"); ok {
+			if !slices.Contains(runtimeDirs, rel) {
+				runtimeDirs = append(runtimeDirs, rel)
+			}
+			return
+		}
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+
+	add(cfg.SystemD.WorkingDir)
+	add(cfg.StateDir)
+	add(filepath.Dir(cfg.Logging.OutputPath))
+	if cfg.State.Path != "" {
+		add(filepath.Dir(cfg.State.Path))
+	}
+	if cfg.Audit.Path != "" {
+		add(filepath.Dir(cfg.Audit.Path))
+	}
+	if cfg.Control.Enabled {
+		add(filepath.Dir(cfg.Control.Socket))
+		if cfg.Control.GRPCSocket != "" {
+			add(filepath.Dir(cfg.Control.GRPCSocket))
+		}
+	}
+	add(cfg.Mount.CacheDir)
+	// Archive stubs, hydration and restores write to synced directories
+	for _, dir := range cfg.Directories {
+		add(dir.LocalPath)
+	}
+	for _, profile := range cfg.Profiles {
+		for _, dir := range profile.Directories {
+			add(dir.LocalPath)
+		}
+	}
+	slices.Sort(paths)
+	return paths, runtimeDirs
+}
@@ -0,0 +1,112 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/service"
+)
+
+// profileService is the service of one profile run by the daemon
+type profileService struct {
+	name string
+	svc  *service.Service
+	lock *service.InstanceLock
+}
+
+// newProfileServices creates a service for every profile of cfg, each
+// holding the instance lock of its own state directory
+func newProfileServices(ctx context.Context, cfg *config.Config, configPath string, replace bool) ([]*profileService, error) {
+	var profiles []*profileService
+	for _, profileCfg := range cfg.ProfileConfigs() {
+		lock, err := service.AcquireInstanceLock(profileCfg, configPath, replace)
+		if err != nil {
+			releaseProfiles(profiles)
+			return nil, fmt.Errorf("profile %s: %w", profileCfg.Profile, err)
+		}
+		svc, err := service.NewService(ctx, profileCfg)
+		if err != nil {
+			lock.Release()
+			releaseProfiles(profiles)
+			return nil, fmt.Errorf("failed to create service of profile %s: %w", profileCfg.Profile, err)
+		}
+		profiles = append(profiles, &profileService{name: profileCfg.Profile, svc: svc, lock: lock})
+	}
+	return profiles, nil
+}
+
+// startProfiles starts every profile's service. A profile that fails to
+// start is logged and left stopped without affecting the others.
+func startProfiles(profiles []*profileService, logger *zap.Logger) {
+	for _, profile := range profiles {
+		if err := profile.svc.Start(); err != nil {
+			logger.Error("Failed to start profile",
+				zap.String("profile", profile.name),
+				zap.Error(err))
+		}
+	}
+}
+
+// stopProfiles stops every profile's service and releases its lock
+func stopProfiles(profiles []*profileService, logger *zap.Logger) {
+	for _, profile := range profiles {
+		if err := profile.svc.Stop(); err != nil {
+			logger.Error("Error stopping profile",
+				zap.String("profile", profile.name),
+				zap.Error(err))
+		}
+	}
+	releaseProfiles(profiles)
+}
+
+// releaseProfiles releases the instance locks of the profiles
+func releaseProfiles(profiles []*profileService) {
+	for _, profile := range profiles {
+		profile.lock.Release()
+	}
+}
+
+// reloadProfiles applies a reloaded configuration to the running profiles.
+// Profiles that were added or removed take effect on the next restart.
+func reloadProfiles(profiles []*profileService, newCfg *config.Config, logger *zap.Logger) {
+	for _, profile := range profiles {
+		profileCfg, err := newCfg.ProfileConfig(profile.name)
+		if err != nil {
+			logger.Warn("Profile removed from configuration, restart to stop it",
+				zap.String("profile", profile.name))
+			continue
+		}
+		if err := profile.svc.Reload(profileCfg); err != nil {
+			logger.Error("Failed to apply reloaded configuration to profile",
+				zap.String("profile", profile.name),
+				zap.Error(err))
+		}
+	}
+	if len(newCfg.Profiles) > len(profiles) {
+		logger.Warn("New profiles start on the next restart")
+	}
+}
@@ -0,0 +1,512 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+//
+// Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+//
+// This file is part of CloudAWSync.
+//
+// CloudAWSync is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// CloudAWSync is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: cloudawsync/v1/control.proto
+
+package cloudawsyncv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ControlService_GetStatus_FullMethodName        = "/cloudawsync.v1.ControlService/GetStatus"
+	ControlService_GetActivity_FullMethodName      = "/cloudawsync.v1.ControlService/GetActivity"
+	ControlService_TriggerSync_FullMethodName      = "/cloudawsync.v1.ControlService/TriggerSync"
+	ControlService_EnableDirectory_FullMethodName  = "/cloudawsync.v1.ControlService/EnableDirectory"
+	ControlService_DisableDirectory_FullMethodName = "/cloudawsync.v1.ControlService/DisableDirectory"
+	ControlService_PauseTransfers_FullMethodName   = "/cloudawsync.v1.ControlService/PauseTransfers"
+	ControlService_ResumeTransfers_FullMethodName  = "/cloudawsync.v1.ControlService/ResumeTransfers"
+	ControlService_AddDirectory_FullMethodName     = "/cloudawsync.v1.ControlService/AddDirectory"
+	ControlService_RemoveDirectory_FullMethodName  = "/cloudawsync.v1.ControlService/RemoveDirectory"
+	ControlService_StreamEvents_FullMethodName     = "/cloudawsync.v1.ControlService/StreamEvents"
+)
+
+// ControlServiceClient is the client API for ControlService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// ControlService manages a running CloudAWSync agent
+type ControlServiceClient interface {
+	// GetStatus returns the sync statistics and the status of every directory
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error)
+	// GetActivity returns the transfers in progress and recent failures
+	GetActivity(ctx context.Context, in *GetActivityRequest, opts ...grpc.CallOption) (*GetActivityResponse, error)
+	// TriggerSync starts a sync of one directory, or of all when path is empty
+	TriggerSync(ctx context.Context, in *TriggerSyncRequest, opts ...grpc.CallOption) (*TriggerSyncResponse, error)
+	// EnableDirectory resumes syncing a configured directory
+	EnableDirectory(ctx context.Context, in *EnableDirectoryRequest, opts ...grpc.CallOption) (*EnableDirectoryResponse, error)
+	// DisableDirectory stops syncing a configured directory until enabled
+	DisableDirectory(ctx context.Context, in *DisableDirectoryRequest, opts ...grpc.CallOption) (*DisableDirectoryResponse, error)
+	// PauseTransfers stops new transfers until ResumeTransfers is called
+	PauseTransfers(ctx context.Context, in *PauseTransfersRequest, opts ...grpc.CallOption) (*PauseTransfersResponse, error)
+	// ResumeTransfers lets transfers run again
+	ResumeTransfers(ctx context.Context, in *ResumeTransfersRequest, opts ...grpc.CallOption) (*ResumeTransfersResponse, error)
+	// AddDirectory starts syncing a new directory until the agent restarts
+	AddDirectory(ctx context.Context, in *AddDirectoryRequest, opts ...grpc.CallOption) (*AddDirectoryResponse, error)
+	// RemoveDirectory stops syncing a directory and cancels its transfers
+	// until the agent restarts, optionally deleting its remote objects
+	RemoveDirectory(ctx context.Context, in *RemoveDirectoryRequest, opts ...grpc.CallOption) (*RemoveDirectoryResponse, error)
+	// StreamEvents sends sync events as they happen until the client cancels
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamEventsResponse], error)
+}
+
+type controlServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlServiceClient(cc grpc.ClientConnInterface) ControlServiceClient {
+	return &controlServiceClient{cc}
+}
+
+func (c *controlServiceClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*GetStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatusResponse)
+	err := c.cc.Invoke(ctx, ControlService_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) GetActivity(ctx context.Context, in *GetActivityRequest, opts ...grpc.CallOption) (*GetActivityResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetActivityResponse)
+	err := c.cc.Invoke(ctx, ControlService_GetActivity_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) TriggerSync(ctx context.Context, in *TriggerSyncRequest, opts ...grpc.CallOption) (*TriggerSyncResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TriggerSyncResponse)
+	err := c.cc.Invoke(ctx, ControlService_TriggerSync_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) EnableDirectory(ctx context.Context, in *EnableDirectoryRequest, opts ...grpc.CallOption) (*EnableDirectoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnableDirectoryResponse)
+	err := c.cc.Invoke(ctx, ControlService_EnableDirectory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) DisableDirectory(ctx context.Context, in *DisableDirectoryRequest, opts ...grpc.CallOption) (*DisableDirectoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DisableDirectoryResponse)
+	err := c.cc.Invoke(ctx, ControlService_DisableDirectory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) PauseTransfers(ctx context.Context, in *PauseTransfersRequest, opts ...grpc.CallOption) (*PauseTransfersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PauseTransfersResponse)
+	err := c.cc.Invoke(ctx, ControlService_PauseTransfers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) ResumeTransfers(ctx context.Context, in *ResumeTransfersRequest, opts ...grpc.CallOption) (*ResumeTransfersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumeTransfersResponse)
+	err := c.cc.Invoke(ctx, ControlService_ResumeTransfers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) AddDirectory(ctx context.Context, in *AddDirectoryRequest, opts ...grpc.CallOption) (*AddDirectoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddDirectoryResponse)
+	err := c.cc.Invoke(ctx, ControlService_AddDirectory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) RemoveDirectory(ctx context.Context, in *RemoveDirectoryRequest, opts ...grpc.CallOption) (*RemoveDirectoryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RemoveDirectoryResponse)
+	err := c.cc.Invoke(ctx, ControlService_RemoveDirectory_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamEventsResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ControlService_ServiceDesc.Streams[0], ControlService_StreamEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamEventsRequest, StreamEventsResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlService_StreamEventsClient = grpc.ServerStreamingClient[StreamEventsResponse]
+
+// ControlServiceServer is the server API for ControlService service.
+// All implementations must embed UnimplementedControlServiceServer
+// for forward compatibility.
+//
+// ControlService manages a running CloudAWSync agent
+type ControlServiceServer interface {
+	// GetStatus returns the sync statistics and the status of every directory
+	GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error)
+	// GetActivity returns the transfers in progress and recent failures
+	GetActivity(context.Context, *GetActivityRequest) (*GetActivityResponse, error)
+	// TriggerSync starts a sync of one directory, or of all when path is empty
+	TriggerSync(context.Context, *TriggerSyncRequest) (*TriggerSyncResponse, error)
+	// EnableDirectory resumes syncing a configured directory
+	EnableDirectory(context.Context, *EnableDirectoryRequest) (*EnableDirectoryResponse, error)
+	// DisableDirectory stops syncing a configured directory until enabled
+	DisableDirectory(context.Context, *DisableDirectoryRequest) (*DisableDirectoryResponse, error)
+	// PauseTransfers stops new transfers until ResumeTransfers is called
+	PauseTransfers(context.Context, *PauseTransfersRequest) (*PauseTransfersResponse, error)
+	// ResumeTransfers lets transfers run again
+	ResumeTransfers(context.Context, *ResumeTransfersRequest) (*ResumeTransfersResponse, error)
+	// AddDirectory starts syncing a new directory until the agent restarts
+	AddDirectory(context.Context, *AddDirectoryRequest) (*AddDirectoryResponse, error)
+	// RemoveDirectory stops syncing a directory and cancels its transfers
+	// until the agent restarts, optionally deleting its remote objects
+	RemoveDirectory(context.Context, *RemoveDirectoryRequest) (*RemoveDirectoryResponse, error)
+	// StreamEvents sends sync events as they happen until the client cancels
+	StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[StreamEventsResponse]) error
+	mustEmbedUnimplementedControlServiceServer()
+}
+
+// UnimplementedControlServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedControlServiceServer struct{}
+
+func (UnimplementedControlServiceServer) GetStatus(context.Context, *GetStatusRequest) (*GetStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedControlServiceServer) GetActivity(context.Context, *GetActivityRequest) (*GetActivityResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetActivity not implemented")
+}
+func (UnimplementedControlServiceServer) TriggerSync(context.Context, *TriggerSyncRequest) (*TriggerSyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TriggerSync not implemented")
+}
+func (UnimplementedControlServiceServer) EnableDirectory(context.Context, *EnableDirectoryRequest) (*EnableDirectoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnableDirectory not implemented")
+}
+func (UnimplementedControlServiceServer) DisableDirectory(context.Context, *DisableDirectoryRequest) (*DisableDirectoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisableDirectory not implemented")
+}
+func (UnimplementedControlServiceServer) PauseTransfers(context.Context, *PauseTransfersRequest) (*PauseTransfersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseTransfers not implemented")
+}
+func (UnimplementedControlServiceServer) ResumeTransfers(context.Context, *ResumeTransfersRequest) (*ResumeTransfersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeTransfers not implemented")
+}
+func (UnimplementedControlServiceServer) AddDirectory(context.Context, *AddDirectoryRequest) (*AddDirectoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddDirectory not implemented")
+}
+func (UnimplementedControlServiceServer) RemoveDirectory(context.Context, *RemoveDirectoryRequest) (*RemoveDirectoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveDirectory not implemented")
+}
+func (UnimplementedControlServiceServer) StreamEvents(*StreamEventsRequest, grpc.ServerStreamingServer[StreamEventsResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamEvents not implemented")
+}
+func (UnimplementedControlServiceServer) mustEmbedUnimplementedControlServiceServer() {}
+func (UnimplementedControlServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeControlServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServiceServer will
+// result in compilation errors.
+type UnsafeControlServiceServer interface {
+	mustEmbedUnimplementedControlServiceServer()
+}
+
+func RegisterControlServiceServer(s grpc.ServiceRegistrar, srv ControlServiceServer) {
+	// If the following call pancis, it indicates UnimplementedControlServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ControlService_ServiceDesc, srv)
+}
+
+func _ControlService_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_GetActivity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetActivityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).GetActivity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_GetActivity_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).GetActivity(ctx, req.(*GetActivityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_TriggerSync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TriggerSyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).TriggerSync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_TriggerSync_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).TriggerSync(ctx, req.(*TriggerSyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_EnableDirectory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableDirectoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).EnableDirectory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_EnableDirectory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).EnableDirectory(ctx, req.(*EnableDirectoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_DisableDirectory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableDirectoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).DisableDirectory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_DisableDirectory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).DisableDirectory(ctx, req.(*DisableDirectoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_PauseTransfers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseTransfersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).PauseTransfers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_PauseTransfers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).PauseTransfers(ctx, req.(*PauseTransfersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_ResumeTransfers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeTransfersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).ResumeTransfers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_ResumeTransfers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).ResumeTransfers(ctx, req.(*ResumeTransfersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_AddDirectory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddDirectoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).AddDirectory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_AddDirectory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).AddDirectory(ctx, req.(*AddDirectoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_RemoveDirectory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveDirectoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).RemoveDirectory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ControlService_RemoveDirectory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).RemoveDirectory(ctx, req.(*RemoveDirectoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ControlServiceServer).StreamEvents(m, &grpc.GenericServerStream[StreamEventsRequest, StreamEventsResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlService_StreamEventsServer = grpc.ServerStreamingServer[StreamEventsResponse]
+
+// ControlService_ServiceDesc is the grpc.ServiceDesc for ControlService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ControlService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cloudawsync.v1.ControlService",
+	HandlerType: (*ControlServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _ControlService_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetActivity",
+			Handler:    _ControlService_GetActivity_Handler,
+		},
+		{
+			MethodName: "TriggerSync",
+			Handler:    _ControlService_TriggerSync_Handler,
+		},
+		{
+			MethodName: "EnableDirectory",
+			Handler:    _ControlService_EnableDirectory_Handler,
+		},
+		{
+			MethodName: "DisableDirectory",
+			Handler:    _ControlService_DisableDirectory_Handler,
+		},
+		{
+			MethodName: "PauseTransfers",
+			Handler:    _ControlService_PauseTransfers_Handler,
+		},
+		{
+			MethodName: "ResumeTransfers",
+			Handler:    _ControlService_ResumeTransfers_Handler,
+		},
+		{
+			MethodName: "AddDirectory",
+			Handler:    _ControlService_AddDirectory_Handler,
+		},
+		{
+			MethodName: "RemoveDirectory",
+			Handler:    _ControlService_RemoveDirectory_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _ControlService_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cloudawsync/v1/control.proto",
+}
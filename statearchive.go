@@ -0,0 +1,226 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/service"
+	"CloudAWSync/internal/state"
+)
+
+// stateArchiveFiles returns the files of cfg kept in a state archive: the
+// index of uploaded objects with their hashes, the obfuscated name map and
+// the audit log. The file event journal is left out, its events belong to
+// the machine they were recorded on.
+func stateArchiveFiles(cfg *config.Config) []state.ArchiveFile {
+	return []state.ArchiveFile{
+		{Name: "state.json", Path: cfg.State.Path},
+		{Name: "names.jsonl", Path: cfg.Security.NameMapPath},
+		{Name: "audit.log", Path: cfg.Audit.Path},
+	}
+}
+
+// readArchiveKey reads the secret a state archive is encrypted with, or
+// returns nil when no key file is given
+func readArchiveKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(secret) < state.MinKeySize {
+		return nil, fmt.Errorf("key file %s must hold at least %d bytes, has %d", path, state.MinKeySize, len(secret))
+	}
+	return secret, nil
+}
+
+// runExportState writes the state database, name map and audit log to a
+// compressed, optionally encrypted archive
+func runExportState(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("export-state", flag.ContinueOnError)
+	keyFile := flags.String("key-file", "", "Encrypt the archive with the secret in this file (at least 32 bytes)")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "export-state requires the archive path, or - for stdout")
+		return 1
+	}
+	if cfg.State.Path == "" {
+		fmt.Fprintln(os.Stderr, "export-state requires state.path, persistent state is disabled")
+		return 1
+	}
+	secret, err := readArchiveKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if _, running, _ := service.RunningInstance(cfg); running {
+		fmt.Fprintln(os.Stderr, "The agent is running, exporting the state it last saved")
+	}
+
+	target := flags.Arg(0)
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if target != "-" {
+		file, err = os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create archive: %v\n", err)
+			return 1
+		}
+		out = file
+	}
+
+	written, err := state.Export(out, stateArchiveFiles(cfg), secret)
+	if err == nil && len(written) == 0 {
+		err = fmt.Errorf("no state files found in %s", cfg.StateDir)
+	}
+	if file != nil {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(target)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	if target != "-" {
+		fmt.Printf("Exported %s to %s\n", strings.Join(written, ", "), target)
+	}
+	return 0
+}
+
+// pathMappings collects the -map-path options of import-state
+type pathMappings []string
+
+func (m *pathMappings) String() string {
+	return strings.Join(*m, ",")
+}
+
+func (m *pathMappings) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok || !filepath.IsAbs(from) || !filepath.IsAbs(to) {
+		return errors.New("expected /old/path=/new/path")
+	}
+	*m = append(*m, filepath.Clean(from)+"="+filepath.Clean(to))
+	return nil
+}
+
+// runImportState restores the files of a state archive to the paths of
+// this configuration, moving local paths with -map-path
+func runImportState(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("import-state", flag.ContinueOnError)
+	keyFile := flags.String("key-file", "", "Decrypt the archive with the secret in this file")
+	force := flags.Bool("force", false, "Replace existing state files")
+	var mappings pathMappings
+	flags.Var(&mappings, "map-path", "Move local paths below /old to /new, e.g. -map-path /home/alice=/home/alice2 (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "import-state requires the archive path, or - for stdin")
+		return 1
+	}
+	if cfg.State.Path == "" {
+		fmt.Fprintln(os.Stderr, "import-state requires state.path, persistent state is disabled")
+		return 1
+	}
+	secret, err := readArchiveKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if instance, running, err := service.RunningInstance(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	} else if running {
+		fmt.Fprintf(os.Stderr, "The agent is running (pid %d), stop it before importing state\n", instance.PID)
+		return 1
+	}
+
+	files := stateArchiveFiles(cfg)
+	if !*force {
+		for _, file := range files {
+			if file.Path == "" {
+				continue
+			}
+			if _, err := os.Stat(file.Path); err == nil {
+				fmt.Fprintf(os.Stderr, "%s already exists, use -force to replace it\n", file.Path)
+				return 1
+			}
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if source := flags.Arg(0); source != "-" {
+		file, err := os.Open(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open archive: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		in = file
+	}
+
+	restored, err := state.Import(in, files, secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import failed: %v\n", err)
+		return 1
+	}
+	if len(restored) == 0 {
+		fmt.Fprintln(os.Stderr, "The archive holds no state files this configuration uses")
+		return 1
+	}
+	fmt.Printf("Imported %s\n", strings.Join(restored, ", "))
+
+	if len(mappings) == 0 {
+		return 0
+	}
+	store, err := state.Open(cfg.State.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open imported state: %v\n", err)
+		return 1
+	}
+	for _, mapping := range mappings {
+		from, to, _ := strings.Cut(mapping, "=")
+		moved := store.RelocatePaths(from, to)
+		fmt.Printf("Moved %d record(s) from %s to %s\n", moved, from, to)
+	}
+	if err := store.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save imported state: %v\n", err)
+		return 1
+	}
+	return 0
+}
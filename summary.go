@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"CloudAWSync/internal/providers"
+	"CloudAWSync/internal/service"
+	"CloudAWSync/internal/utils"
+)
+
+// Process exit codes. They are stable so scripts can rely on them.
+const (
+	exitOK      = 0
+	exitFailure = 1 // the command could not run
+	exitPartial = 2 // some directories or files failed
+	exitConfig  = 3 // the configuration is missing or invalid
+	exitAuth    = 4 // the storage service rejected the credentials
+)
+
+// failureExitCode returns the exit code for a command that failed with err
+func failureExitCode(err error) int {
+	if providers.IsAuthError(err) {
+		return exitAuth
+	}
+	return exitFailure
+}
+
+// syncExitCode returns the exit code for a completed single-shot sync
+func syncExitCode(summary *service.SyncSummary) int {
+	switch {
+	case summary.AuthFailed():
+		return exitAuth
+	case !summary.OK():
+		return exitPartial
+	default:
+		return exitOK
+	}
+}
+
+// runSummary is the machine-readable report written by -summary-json
+type runSummary struct {
+	OK              bool               `json:"ok"`
+	ExitCode        int                `json:"exit_code"`
+	Error           string             `json:"error,omitempty"`
+	StartedAt       time.Time          `json:"started_at"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	FilesUploaded   int64              `json:"files_uploaded"`
+	BytesUploaded   int64              `json:"bytes_uploaded"`
+	FilesDownloaded int64              `json:"files_downloaded"`
+	BytesDownloaded int64              `json:"bytes_downloaded"`
+	FilesDeleted    int64              `json:"files_deleted"`
+	FilesAdopted    int64              `json:"files_adopted"`
+	OfflineQueued   int                `json:"offline_queued"`
+	Directories     []summaryDirectory `json:"directories"`
+	Errors          []summaryError     `json:"errors"`
+	ErrorsOmitted   int64              `json:"errors_omitted"`
+}
+
+// summaryDirectory is the outcome of one directory in a runSummary
+type summaryDirectory struct {
+	LocalPath  string `json:"local_path"`
+	RemotePath string `json:"remote_path"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+}
+
+// summaryError is a failed transfer in a runSummary
+type summaryError struct {
+	Path      string    `json:"path"`
+	Operation string    `json:"operation"`
+	Error     string    `json:"error"`
+	Retries   int       `json:"retries"`
+	Time      time.Time `json:"time"`
+}
+
+// newRunSummary builds the report of a single-shot sync. summary is nil when
+// the sync could not run, runErr then says why.
+func newRunSummary(summary *service.SyncSummary, runErr error, started time.Time, exitCode int) runSummary {
+	report := runSummary{
+		OK:          exitCode == exitOK,
+		ExitCode:    exitCode,
+		StartedAt:   started,
+		Directories: []summaryDirectory{},
+		Errors:      []summaryError{},
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+		report.DurationSeconds = time.Since(started).Seconds()
+		return report
+	}
+
+	stats := summary.Stats
+	report.StartedAt = summary.Started
+	report.DurationSeconds = summary.Duration.Seconds()
+	report.FilesUploaded = stats.FilesUploaded
+	report.BytesUploaded = stats.BytesUploaded
+	report.FilesDownloaded = stats.FilesDownloaded
+	report.BytesDownloaded = stats.BytesDownloaded
+	report.FilesDeleted = stats.FilesDeleted
+	report.FilesAdopted = stats.FilesAdopted
+	report.OfflineQueued = stats.OfflineQueued
+	for _, dir := range summary.Directories {
+		report.Directories = append(report.Directories, summaryDirectory{
+			LocalPath:  dir.LocalPath,
+			RemotePath: dir.RemotePath,
+			OK:         dir.LastError == "",
+			Error:      dir.LastError,
+		})
+	}
+	for _, failure := range stats.RecentErrors {
+		entry := summaryError{
+			Path:      failure.Path,
+			Operation: failure.Operation,
+			Retries:   failure.Retries,
+			Time:      failure.Timestamp,
+		}
+		if failure.Error != nil {
+			entry.Error = failure.Error.Error()
+		}
+		report.Errors = append(report.Errors, entry)
+	}
+	report.ErrorsOmitted = max(stats.SyncErrors-int64(len(stats.RecentErrors)), 0)
+	return report
+}
+
+// reportFailedRun writes the -summary-json report of a -once run that failed
+// before it could sync
+func reportFailedRun(err error, started time.Time, exitCode int) {
+	if !*once || *summaryJSON == "" {
+		return
+	}
+	if err := writeRunSummary(*summaryJSON, newRunSummary(nil, err, started, exitCode)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// writeRunSummary writes report as JSON to path, or to stdout when path
+// is "-"
+func writeRunSummary(path string, report runSummary) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode summary: %w", err)
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := utils.AtomicWrite(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return nil
+}
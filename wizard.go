@@ -0,0 +1,338 @@
+/*
+SPDX-License-Identifier: GPL-3.0-or-later
+
+Copyright (C) 2025 Aaron Mathis aaron@deepthought.sh
+
+This file is part of CloudAWSync.
+
+CloudAWSync is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+CloudAWSync is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with CloudAWSync. If not, see https://www.gnu.org/licenses/.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"CloudAWSync/internal/config"
+	"CloudAWSync/internal/interfaces"
+	"CloudAWSync/internal/providers"
+
+	"go.uber.org/zap"
+)
+
+// prompter asks questions on a terminal
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints a question and returns the answer, or def when the answer is
+// empty. It returns io.EOF when input ends.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// require asks until a non-empty answer is given
+func (p *prompter) require(question, def string) (string, error) {
+	for {
+		answer, err := p.ask(question, def)
+		if err != nil || answer != "" {
+			return answer, err
+		}
+		fmt.Fprintln(p.out, "  A value is required.")
+	}
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ("+hint+")", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "  Please answer y or n.")
+	}
+}
+
+// choose asks for one of options
+func (p *prompter) choose(question string, options []string, def string) (string, error) {
+	for {
+		answer, err := p.ask(fmt.Sprintf("%s (%s)", question, strings.Join(options, ", ")), def)
+		if err != nil {
+			return "", err
+		}
+		for _, option := range options {
+			if strings.EqualFold(answer, option) {
+				return option, nil
+			}
+		}
+		fmt.Fprintf(p.out, "  Please choose one of: %s.\n", strings.Join(options, ", "))
+	}
+}
+
+// runInit interactively creates a configuration file, returning the
+// process exit code
+func runInit(path string) int {
+	if path == "" {
+		path = config.DefaultConfigPath()
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+
+	err := initConfig(p, path)
+	if errors.Is(err, io.EOF) {
+		fmt.Fprintln(os.Stderr, "\nSetup cancelled, no configuration written")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Setup failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// initConfig runs the setup questions and writes the configuration to path
+func initConfig(p *prompter, path string) error {
+	fmt.Fprintf(p.out, "%s setup\n\n", appName)
+
+	path, err := p.require("Configuration file", path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		overwrite, err := p.confirm(fmt.Sprintf("%s exists, overwrite it?", path), false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return fmt.Errorf("%s left unchanged", path)
+		}
+	}
+
+	cfg := config.DefaultConfig()
+	if err := askStorage(p, cfg); err != nil {
+		return err
+	}
+	if err := askDirectories(p, cfg); err != nil {
+		return err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("configuration is not valid, nothing written: %w", err)
+	}
+	if err := cfg.SaveConfig(path); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	// The file holds credentials
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("failed to restrict configuration permissions: %w", err)
+	}
+	fmt.Fprintf(p.out, "\nConfiguration written to %s\n", path)
+
+	systemd, err := p.confirm("Generate a systemd service file?", false)
+	if err != nil {
+		return err
+	}
+	if systemd {
+		user, err := p.confirm("Run it as a user service (systemctl --user)?", os.Geteuid() != 0)
+		if err != nil {
+			return err
+		}
+		if err := generateSystemDService(cfg, path, user); err != nil {
+			return fmt.Errorf("failed to generate service file: %w", err)
+		}
+	}
+
+	fmt.Fprintf(p.out, "\nStart syncing with: %s -config %s\n", os.Args[0], path)
+	return nil
+}
+
+// askStorage asks for the bucket and credentials and tests access to the
+// bucket until it succeeds or the user accepts the failure
+func askStorage(p *prompter, cfg *config.Config) error {
+	aws := &cfg.AWS
+	for {
+		var err error
+		if aws.S3Bucket, err = p.require("S3 bucket", aws.S3Bucket); err != nil {
+			return err
+		}
+		if aws.Region, err = p.require("AWS region", aws.Region); err != nil {
+			return err
+		}
+		if aws.S3Prefix, err = p.ask("Key prefix in the bucket", aws.S3Prefix); err != nil {
+			return err
+		}
+		if aws.Endpoint, err = p.ask("Endpoint for S3-compatible services (empty for AWS)", aws.Endpoint); err != nil {
+			return err
+		}
+		if err := askCredentials(p, aws); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(p.out, "Testing access to bucket %s... ", aws.S3Bucket)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err = providers.NewS3Provider(ctx, providers.S3Config{
+			Region:          aws.Region,
+			Bucket:          aws.S3Bucket,
+			Prefix:          aws.S3Prefix,
+			Endpoint:        aws.Endpoint,
+			AccessKeyID:     aws.AccessKeyID,
+			SecretAccessKey: aws.SecretAccessKey,
+			SessionToken:    aws.SessionToken,
+			HTTP: providers.HTTPConfig{
+				TLSHandshakeTimeout: cfg.Network.TLSHandshakeTimeout,
+				IdleConnTimeout:     cfg.Network.IdleConnTimeout,
+			},
+		}, zap.NewNop())
+		cancel()
+		if err == nil {
+			fmt.Fprintln(p.out, "ok")
+			return nil
+		}
+		fmt.Fprintf(p.out, "failed\n  %v\n", err)
+
+		retry, err := p.confirm("Change the storage settings?", true)
+		if err != nil || !retry {
+			return err
+		}
+	}
+}
+
+// askCredentials asks where credentials come from and stores them in aws
+func askCredentials(p *prompter, aws *config.AWSConfig) error {
+	source, err := p.choose("Credentials source", []string{"keys", "environment", "profile"}, "keys")
+	if err != nil {
+		return err
+	}
+
+	if source == "keys" {
+		if aws.AccessKeyID, err = p.require("Access key ID", aws.AccessKeyID); err != nil {
+			return err
+		}
+		if aws.SecretAccessKey, err = p.require("Secret access key", ""); err != nil {
+			return err
+		}
+		aws.SessionToken = ""
+		return nil
+	}
+
+	// Environment variables and profiles are resolved now and copied into
+	// the configuration, which requires long-lived static keys. Temporary
+	// credentials would stop the daemon once they expire.
+	var profile string
+	if source == "profile" {
+		if profile, err = p.require("Profile name", "default"); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	credentials, err := providers.ResolveCredentials(ctx, profile)
+	if err != nil {
+		fmt.Fprintf(p.out, "  %v\n", err)
+		return askCredentials(p, aws)
+	}
+	if credentials.SessionToken != "" || credentials.CanExpire {
+		fmt.Fprintf(p.out, "  %s provides temporary credentials, which expire; enter access keys or choose another source\n", credentials.Source)
+		return askCredentials(p, aws)
+	}
+	aws.AccessKeyID = credentials.AccessKeyID
+	aws.SecretAccessKey = credentials.SecretAccessKey
+	aws.SessionToken = ""
+	fmt.Fprintf(p.out, "  Using access key %s from %s\n", credentials.AccessKeyID, credentials.Source)
+	return nil
+}
+
+// askDirectories asks for the directories to sync until an empty path is
+// given
+func askDirectories(p *prompter, cfg *config.Config) error {
+	fmt.Fprintln(p.out, "\nDirectories to sync (empty path to finish)")
+	for {
+		question := "Local directory"
+		if len(cfg.Directories) == 0 {
+			question = "Local directory (at least one)"
+		}
+		localPath, err := p.ask(question, "")
+		if err != nil {
+			return err
+		}
+		if localPath == "" {
+			if len(cfg.Directories) > 0 {
+				return nil
+			}
+			continue
+		}
+		localPath, err = filepath.Abs(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", localPath, err)
+		}
+		if info, err := os.Stat(localPath); err != nil || !info.IsDir() {
+			fmt.Fprintf(p.out, "  %s is not a directory\n", localPath)
+			continue
+		}
+
+		dir := interfaces.SyncDirectory{LocalPath: localPath, Recursive: true, Enabled: true}
+		if dir.RemotePath, err = p.require("Remote path", filepath.Base(localPath)); err != nil {
+			return err
+		}
+		modes := []string{
+			string(interfaces.SyncModeRealtime),
+			string(interfaces.SyncModeScheduled),
+			string(interfaces.SyncModeBoth),
+			string(interfaces.SyncModeBackup),
+		}
+		mode, err := p.choose("Sync mode", modes, string(interfaces.SyncModeRealtime))
+		if err != nil {
+			return err
+		}
+		dir.SyncMode = interfaces.SyncMode(mode)
+		if dir.SyncMode != interfaces.SyncModeRealtime {
+			if dir.Schedule, err = p.require("Schedule (cron)", "0 2 * * *"); err != nil {
+				return err
+			}
+		}
+		cfg.Directories = append(cfg.Directories, dir)
+	}
+}